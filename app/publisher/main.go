@@ -21,6 +21,10 @@ package main
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -190,15 +194,15 @@ func newPublishHandler(conf *config) *publishHandler {
 	}
 	var cache blobserver.Storage
 	var thumbMeta *server.ThumbMeta
+	thumbsCacheDir := filepath.Join(os.TempDir(), "camli-publisher-cache")
+	if err := os.MkdirAll(thumbsCacheDir, 0700); err != nil {
+		log.Fatalf("Could not create cache dir %s for %v publisher: %v", thumbsCacheDir, conf.RootName, err)
+	}
 	if conf.CacheRoot != "" {
 		cache, err = localdisk.New(conf.CacheRoot)
 		if err != nil {
 			log.Fatalf("Could not create localdisk cache: %v", err)
 		}
-		thumbsCacheDir := filepath.Join(os.TempDir(), "camli-publisher-cache")
-		if err := os.MkdirAll(thumbsCacheDir, 0700); err != nil {
-			log.Fatalf("Could not create cache dir %s for %v publisher: %v", thumbsCacheDir, conf.RootName, err)
-		}
 		kv, err := sorted.NewKeyValue(map[string]interface{}{
 			"type": "kv",
 			"file": filepath.Join(thumbsCacheDir, conf.RootName+"-thumbnails.kv"),
@@ -208,6 +212,10 @@ func newPublishHandler(conf *config) *publishHandler {
 		}
 		thumbMeta = server.NewThumbMeta(kv)
 	}
+	sigKey, err := loadOrCreateSigKey(filepath.Join(thumbsCacheDir, conf.RootName+"-resize-sig.key"))
+	if err != nil {
+		log.Fatalf("Could not load or create the resize URL signing key for %v publisher: %v", conf.RootName, err)
+	}
 
 	return &publishHandler{
 		rootName:       conf.RootName,
@@ -219,9 +227,31 @@ func newPublishHandler(conf *config) *publishHandler {
 		describedCache: make(map[string]*search.DescribedBlob),
 		cache:          cache,
 		thumbMeta:      thumbMeta,
+		resizeSigKey:   sigKey,
 	}
 }
 
+// loadOrCreateSigKey returns the HMAC key signing the scaled-image
+// URLs, creating and saving a random one in path on first use, so
+// emitted URLs survive restarts.
+func loadOrCreateSigKey(path string) ([]byte, error) {
+	key, err := ioutil.ReadFile(path)
+	if err == nil && len(key) >= 32 {
+		return key, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, key, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
 func goTemplate(files *fileembed.Files, templateFile string) (*template.Template, error) {
 	f, err := files.Open(templateFile)
 	if err != nil {
@@ -262,6 +292,8 @@ type publishHandler struct {
 
 	cache     blobserver.Storage // For caching images and files, or nil.
 	thumbMeta *server.ThumbMeta  // For keeping track of cached images, or nil.
+
+	resizeSigKey []byte // HMAC key for signing the scaled-image URL parameters.
 }
 
 func (ph *publishHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -804,8 +836,12 @@ func (pr *publishRequest) subjectFile(described map[string]*search.DescribedBlob
 	path := []blob.Ref{pr.subject, contentRef}
 	downloadURL := pr.SubresFileURL(path, fileDes.File.FileName)
 	thumbnailURL := ""
+	var scaledURL func(maxWidth, maxHeight, quality int) string
 	if fileDes.File.IsImage() {
 		thumbnailURL = pr.SubresThumbnailURL(path, fileDes.File.FileName, 600)
+		scaledURL = func(maxWidth, maxHeight, quality int) string {
+			return pr.SubresImageURL(path, fileDes.File.FileName, maxWidth, maxHeight, quality)
+		}
 	}
 	fileName := html.EscapeString(fileDes.File.FileName)
 	return &publish.PageFile{
@@ -819,19 +855,41 @@ func (pr *publishRequest) subjectFile(described map[string]*search.DescribedBlob
 		Nav: func() *publish.Nav {
 			return nil
 		},
+		ScaledURL: scaledURL,
 	}, nil
 }
 
 func (pr *publishRequest) SubresFileURL(path []blob.Ref, fileName string) string {
-	return pr.SubresThumbnailURL(path, fileName, -1)
+	return pr.subresURL(path, "f", fileName)
 }
 
 func (pr *publishRequest) SubresThumbnailURL(path []blob.Ref, fileName string, maxDimen int) string {
-	var buf bytes.Buffer
-	resType := "i"
 	if maxDimen == -1 {
-		resType = "f"
+		return pr.subresURL(path, "f", fileName)
 	}
+	return pr.SubresImageURL(path, fileName, maxDimen, maxDimen, 0)
+}
+
+// SubresImageURL returns a signed URL serving the image whose file
+// schema blobref is the last element of path, scaled down to fit in
+// maxWidth x maxHeight, as a JPEG of the given quality (1-100; 0
+// means the server default).
+func (pr *publishRequest) SubresImageURL(path []blob.Ref, fileName string, maxWidth, maxHeight, quality int) string {
+	var buf bytes.Buffer
+	buf.WriteString(pr.subresURL(path, "i", fileName))
+	fmt.Fprintf(&buf, "?mw=%d&mh=%d", maxWidth, maxHeight)
+	if quality != 0 {
+		fmt.Fprintf(&buf, "&q=%d", quality)
+	}
+	fmt.Fprintf(&buf, "&sig=%s", pr.ph.signImageParams(path[len(path)-1], maxWidth, maxHeight, quality, false))
+	return buf.String()
+}
+
+// subresURL returns the URL path serving the subresource of type
+// resType ("i", "f", "s", or "z") named by path and fileName, with no
+// query parameters.
+func (pr *publishRequest) subresURL(path []blob.Ref, resType, fileName string) string {
+	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "%s", pr.subjectBasePath)
 	if !strings.Contains(pr.subjectBasePath, "/-/") {
 		buf.Write([]byte("/-"))
@@ -844,12 +902,25 @@ func (pr *publishRequest) SubresThumbnailURL(path []blob.Ref, fileName string, m
 	}
 	fmt.Fprintf(&buf, "/=%s", resType)
 	fmt.Fprintf(&buf, "/%s", url.QueryEscape(fileName))
-	if maxDimen != -1 {
-		fmt.Fprintf(&buf, "?mw=%d&mh=%d", maxDimen, maxDimen)
-	}
 	return buf.String()
 }
 
+// signImageParams returns the hex HMAC-SHA256 signature of the
+// scaled-image parameters, so published pages can link to arbitrary
+// sizes while unauthenticated visitors cannot request resizes we
+// never emitted.
+func (ph *publishHandler) signImageParams(fileRef blob.Ref, maxWidth, maxHeight, quality int, square bool) string {
+	mac := hmac.New(sha256.New, ph.resizeSigKey)
+	fmt.Fprintf(mac, "%s\x00mw=%d\x00mh=%d\x00q=%d\x00square=%t", fileRef, maxWidth, maxHeight, quality, square)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validImageSig reports whether sig is a valid signature of the given
+// scaled-image parameters.
+func (ph *publishHandler) validImageSig(sig string, fileRef blob.Ref, maxWidth, maxHeight, quality int, square bool) bool {
+	return sig != "" && hmac.Equal([]byte(sig), []byte(ph.signImageParams(fileRef, maxWidth, maxHeight, quality, square)))
+}
+
 // subjectMembers returns the relevant PageMembers if the described subject is a permanode with members.
 func (pr *publishRequest) subjectMembers(resMap map[string]*search.DescribedBlob) (*publish.PageMembers, error) {
 	subdes := resMap[pr.subject.String()]
@@ -918,7 +989,7 @@ func (pr *publishRequest) subjectMembers(resMap map[string]*search.DescribedBlob
 }
 
 func (ph *publishHandler) describeMembers(br blob.Ref) (*search.SearchResult, error) {
-	res, err := ph.cl.Query(&search.SearchQuery{
+	it := search.NewQueryIterator(ph.cl, &search.SearchQuery{
 		Constraint: &search.Constraint{
 			Permanode: &search.PermanodeConstraint{
 				Relation: &search.RelationConstraint{
@@ -930,18 +1001,27 @@ func (ph *publishHandler) describeMembers(br blob.Ref) (*search.SearchResult, er
 			},
 			CamliType: "permanode",
 		},
-		Describe: &search.DescribeRequest{
-			ThumbnailSize: 1000,
-			Depth:         1,
-			Rules: []*search.DescribeRule{
-				{
-					Attrs: []string{"camliContent", "camliContentImage"},
-				},
+		Limit: 100, // page size; the iterator fetches all pages
+	})
+	it.DescribeEach = &search.DescribeRequest{
+		ThumbnailSize: 1000,
+		Depth:         1,
+		Rules: []*search.DescribeRule{
+			{
+				Attrs: []string{"camliContent", "camliContentImage"},
 			},
 		},
-		Limit: -1,
-	})
-	if err != nil {
+	}
+	res := &search.SearchResult{
+		Describe: &search.DescribeResponse{Meta: make(search.MetaMap)},
+	}
+	for it.Next() {
+		res.Blobs = append(res.Blobs, it.Blob())
+		if des := it.Described(); des != nil {
+			res.Describe.Meta[it.Blob().Blob.String()] = des
+		}
+	}
+	if err := it.Err(); err != nil {
 		return nil, fmt.Errorf("Could not describe members of %v: %v", br, err)
 	}
 	return res, nil
@@ -964,26 +1044,32 @@ func (pr *publishRequest) serveSubresImage() {
 	params := pr.req.URL.Query()
 	mw, _ := strconv.Atoi(params.Get("mw"))
 	mh, _ := strconv.Atoi(params.Get("mh"))
+	quality, _ := strconv.Atoi(params.Get("q"))
 	des, err := pr.ph.describe(pr.subject)
 	if err != nil {
 		log.Printf("error describing subject %q: %v", pr.subject, err)
 		return
 	}
-	pr.serveScaledImage(des, mw, mh, params.Get("square") == "1")
+	pr.serveScaledImage(des, mw, mh, quality, params.Get("square") == "1", params.Get("sig"))
 }
 
-func (pr *publishRequest) serveScaledImage(des *search.DescribedBlob, maxWidth, maxHeight int, square bool) {
+func (pr *publishRequest) serveScaledImage(des *search.DescribedBlob, maxWidth, maxHeight, quality int, square bool, sig string) {
 	fileref, _, ok := pr.fileSchemaRefFromBlob(des)
 	if !ok {
 		log.Printf("scaled image fail; failed to get file schema from des %q", des.BlobRef)
 		return
 	}
+	if !pr.ph.validImageSig(sig, fileref, maxWidth, maxHeight, quality, square) {
+		http.Error(pr.rw, "invalid or missing image URL signature", http.StatusForbidden)
+		return
+	}
 	ih := &server.ImageHandler{
 		Fetcher:   pr.ph.cl,
 		Cache:     pr.ph.cache,
 		MaxWidth:  maxWidth,
 		MaxHeight: maxHeight,
 		Square:    square,
+		Quality:   quality,
 		ThumbMeta: pr.ph.thumbMeta,
 		ResizeSem: pr.ph.resizeSem,
 	}
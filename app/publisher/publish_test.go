@@ -20,10 +20,12 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
 
+	"camlistore.org/pkg/blob"
 	camliClient "camlistore.org/pkg/client"
 	"camlistore.org/pkg/httputil"
 	"camlistore.org/pkg/index"
@@ -191,6 +193,71 @@ func TestPublishURLs(t *testing.T) {
 	}
 }
 
+func TestSignedImageURLs(t *testing.T) {
+	rootName := "foo"
+	idxd := setupContent(rootName)
+	sh := search.NewHandler(idxd.Index, idxd.SignerBlobRef)
+	corpus, err := idxd.Index.KeepInMemory()
+	if err != nil {
+		t.Fatalf("error slurping index to memory: %v", err)
+	}
+	sh.SetCorpus(corpus)
+	cl := camliClient.New("http://whatever.fake")
+	fcl := &fakeClient{cl, sh}
+	ph := &publishHandler{
+		rootName:     rootName,
+		cl:           fcl,
+		resizeSigKey: []byte("test-key"),
+	}
+
+	camp1 := blob.MustParse("sha1-961b700536d5151fc1f3920955cc92767572a064")
+	camp1f := blob.MustParse("sha1-1213ec17a42cc51bdeb95ff91ac1b5fc5157740f")
+
+	pr := &publishRequest{
+		ph:              ph,
+		inSubjectChain:  map[string]bool{},
+		subjectBasePath: "/pics/camping",
+	}
+	path := []blob.Ref{camp1, camp1f}
+	imgURL := pr.SubresImageURL(path, "marshmallow.jpg", 800, 600, 75)
+	u, err := url.Parse(imgURL)
+	if err != nil {
+		t.Fatalf("could not parse generated URL %q: %v", imgURL, err)
+	}
+	params := u.Query()
+	sig := params.Get("sig")
+	if sig == "" {
+		t.Fatalf("no signature in generated URL %q", imgURL)
+	}
+	if got, want := params.Get("q"), "75"; got != want {
+		t.Errorf("got quality %q in generated URL, want %q", got, want)
+	}
+	if !ph.validImageSig(sig, camp1f, 800, 600, 75, false) {
+		t.Errorf("signature of %q does not verify with the same parameters", imgURL)
+	}
+	if ph.validImageSig(sig, camp1f, 4000, 600, 75, false) {
+		t.Errorf("signature of %q verifies with a tampered width", imgURL)
+	}
+	if ph.validImageSig("", camp1f, 800, 600, 75, false) {
+		t.Errorf("empty signature should not verify")
+	}
+
+	// An unsigned scaled-image request is refused.
+	rw := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://foo.com"+u.Path+"?mw=800&mh=600", nil)
+	pr = &publishRequest{
+		ph:      ph,
+		rw:      rw,
+		req:     req,
+		subject: camp1f,
+		subres:  "/=i/marshmallow.jpg",
+	}
+	pr.serveSubresImage()
+	if rw.Code != http.StatusForbidden {
+		t.Errorf("unsigned scaled-image request got status %d, want %d", rw.Code, http.StatusForbidden)
+	}
+}
+
 func TestPublishMembers(t *testing.T) {
 	rootName := "foo"
 	idxd := setupContent(rootName)
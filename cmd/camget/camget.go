@@ -66,6 +66,11 @@ func main() {
 		return
 	}
 
+	if arg := flag.Arg(0); arg == "cat" || arg == "ls" {
+		runCatLs(arg, flag.Args()[1:])
+		return
+	}
+
 	if *flagGraph && flag.NArg() != 1 {
 		log.Fatalf("The --graph option requires exactly one parameter.")
 	}
@@ -0,0 +1,185 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/cacher"
+	"camlistore.org/pkg/client"
+	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/search"
+)
+
+// runCatLs implements the "camget cat" and "camget ls" subcommands,
+// for quick inspection of files and directories without a FUSE mount.
+func runCatLs(mode string, args []string) {
+	flags := flag.NewFlagSet("camget "+mode, flag.ExitOnError)
+	var offset, length *int64
+	if mode == "cat" {
+		offset = flags.Int64("offset", 0, "Offset in bytes to start reading the file at.")
+		length = flags.Int64("length", -1, "Number of bytes to read; -1 means the rest of the file.")
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatalf("Usage: camget %s [opts] <blobref>", mode)
+	}
+	br, ok := blob.Parse(flags.Arg(0))
+	if !ok {
+		log.Fatalf("Failed to parse argument %q as a blobref.", flags.Arg(0))
+	}
+
+	cl := client.NewOrFail()
+	cl.InsecureTLS = *flagInsecureTLS
+	cl.SetHTTPClient(&http.Client{
+		Transport: cl.TransportForConfig(&client.TransportConfig{Verbose: *flagHTTP}),
+	})
+	diskCacheFetcher, err := cacher.NewDiskCache(cl)
+	if err != nil {
+		log.Fatalf("Error setting up local disk cache: %v", err)
+	}
+	defer diskCacheFetcher.Clean()
+
+	b, err := resolveSchemaBlob(cl, diskCacheFetcher, br)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch mode {
+	case "cat":
+		err = catFile(diskCacheFetcher, b, *offset, *length)
+	case "ls":
+		err = lsDir(diskCacheFetcher, b)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// resolveSchemaBlob fetches br's schema blob, following permanode →
+// camliContent indirection (via the search handler) until it reaches
+// something with content.
+func resolveSchemaBlob(cl *client.Client, fetcher blob.Fetcher, br blob.Ref) (*schema.Blob, error) {
+	for depth := 0; depth < 5; depth++ {
+		rc, _, err := fetcher.Fetch(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %v: %v", br, err)
+		}
+		b, err := schema.BlobFromReader(br, rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%v is not a schema blob; use plain camget for raw blobs", br)
+		}
+		if b.Type() != "permanode" {
+			return b, nil
+		}
+		res, err := cl.Describe(&search.DescribeRequest{BlobRef: br})
+		if err != nil {
+			return nil, fmt.Errorf("error describing permanode %v: %v", br, err)
+		}
+		db := res.Meta[br.String()]
+		if db == nil || db.Permanode == nil {
+			return nil, fmt.Errorf("search handler doesn't know permanode %v", br)
+		}
+		content := db.Permanode.Attr.Get("camliContent")
+		if content == "" {
+			return nil, fmt.Errorf("permanode %v has no camliContent attribute", br)
+		}
+		cbr, ok := blob.Parse(content)
+		if !ok {
+			return nil, fmt.Errorf("permanode %v has invalid camliContent %q", br, content)
+		}
+		br = cbr
+	}
+	return nil, fmt.Errorf("too many levels of permanode indirection from %v", br)
+}
+
+// catFile streams b's reconstructed bytes to stdout.
+func catFile(fetcher blob.Fetcher, b *schema.Blob, offset, length int64) error {
+	switch b.Type() {
+	case "file", "bytes":
+	case "directory", "static-set":
+		return fmt.Errorf("%v is a %s, not a file; use \"camget ls\"", b.BlobRef(), b.Type())
+	default:
+		return fmt.Errorf("%v is a %q schema blob, not a file", b.BlobRef(), b.Type())
+	}
+	fr, err := schema.NewFileReader(fetcher, b.BlobRef())
+	if err != nil {
+		return fmt.Errorf("error reading file %v: %v", b.BlobRef(), err)
+	}
+	defer fr.Close()
+	size := fr.Size()
+	if offset < 0 || offset > size {
+		return fmt.Errorf("offset %d out of range; file is %d bytes", offset, size)
+	}
+	if length < 0 || offset+length > size {
+		length = size - offset
+	}
+	// FileReader reads at arbitrary offsets without fetching the
+	// skipped chunks, so this doesn't slurp the whole file.
+	_, err = io.Copy(os.Stdout, io.NewSectionReader(fr, offset, length))
+	return err
+}
+
+// lsDir prints b's entries, one per line, with their sizes and
+// modtimes.
+func lsDir(fetcher blob.Fetcher, b *schema.Blob) error {
+	var members []blob.Ref
+	switch b.Type() {
+	case "directory":
+		dr, err := schema.NewDirReader(fetcher, b.BlobRef())
+		if err != nil {
+			return fmt.Errorf("error reading directory %v: %v", b.BlobRef(), err)
+		}
+		if members, err = dr.StaticSet(); err != nil {
+			return fmt.Errorf("error reading static-set of %v: %v", b.BlobRef(), err)
+		}
+	case "static-set":
+		members = b.StaticSetMembers()
+	case "file", "bytes":
+		return fmt.Errorf("%v is a file, not a directory; use \"camget cat\"", b.BlobRef())
+	default:
+		return fmt.Errorf("%v is a %q schema blob, not a directory", b.BlobRef(), b.Type())
+	}
+	for _, mbr := range members {
+		rc, _, err := fetcher.Fetch(mbr)
+		if err != nil {
+			return fmt.Errorf("failed to fetch entry %v: %v", mbr, err)
+		}
+		mb, err := schema.BlobFromReader(mbr, rc)
+		rc.Close()
+		if err != nil {
+			fmt.Printf("?????????? %10s %20s %s (not a schema blob)\n", "?", "?", mbr)
+			continue
+		}
+		size := "-"
+		if mb.Type() == "file" {
+			size = fmt.Sprint(mb.PartsSize())
+		}
+		modtime := "-"
+		if mt := mb.ModTime(); !mt.IsZero() {
+			modtime = mt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%s %10s %20s %s\t%s\n", mb.FileMode(), size, modtime, mb.FileName(), mbr)
+	}
+	return nil
+}
@@ -27,6 +27,7 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"syscall"
@@ -44,10 +45,12 @@ import (
 )
 
 var (
-	debug = flag.Bool("debug", false, "print debugging messages.")
-	xterm = flag.Bool("xterm", false, "Run an xterm in the mounted directory. Shut down when xterm ends.")
-	term  = flag.Bool("term", false, "Open a terminal window. Doesn't shut down when exited. Mostly for demos.")
-	open  = flag.Bool("open", false, "Open a GUI window")
+	debug     = flag.Bool("debug", false, "print debugging messages.")
+	xterm     = flag.Bool("xterm", false, "Run an xterm in the mounted directory. Shut down when xterm ends.")
+	term      = flag.Bool("term", false, "Open a terminal window. Doesn't shut down when exited. Mostly for demos.")
+	open      = flag.Bool("open", false, "Open a GUI window")
+	cacheSize = flag.Int64("cachesize", 512, "Max size, in MB, of the local blob read cache. Least recently used blobs are evicted past it. 0 means unbounded.")
+	writeBack = flag.Bool("writeback", false, "Stage written data on local disk and upload it asynchronously, so writes don't wait on the server. Dirty blobs survive restarts and are flushed by the next run; see camtool mount-cache.")
 )
 
 func usage() {
@@ -143,14 +146,32 @@ func main() {
 		log.Fatalf("Error setting up local disk cache: %v", err)
 	}
 	defer diskCacheFetcher.Clean()
+	if *cacheSize != 0 {
+		diskCacheFetcher.SetMaxSize(*cacheSize << 20)
+	}
+	var fetcher blob.Fetcher = diskCacheFetcher
+	var wb *cacher.WriteBack
+	if *writeBack {
+		wb, err = cacher.NewWriteBack(filepath.Join(osutil.CacheDir(), "mount-writeback"), cl, diskCacheFetcher)
+		if err != nil {
+			log.Fatalf("Error setting up write-back cache: %v", err)
+		}
+		defer wb.Close()
+	}
+	if wb != nil {
+		fetcher = wb
+	}
 	if root.Valid() {
 		var err error
-		camfs, err = fs.NewRootedCamliFileSystem(cl, diskCacheFetcher, root)
+		camfs, err = fs.NewRootedCamliFileSystem(cl, fetcher, root)
 		if err != nil {
 			log.Fatalf("Error creating root with %v: %v", root, err)
 		}
 	} else {
-		camfs = fs.NewDefaultCamliFileSystem(cl, diskCacheFetcher)
+		camfs = fs.NewDefaultCamliFileSystem(cl, fetcher)
+	}
+	if wb != nil {
+		camfs.SetWriteBackCache(wb)
 	}
 
 	if *debug {
@@ -23,6 +23,7 @@ import (
 	"camlistore.org/pkg/blob"
 	"camlistore.org/pkg/cmdmain"
 	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/schema/nodeattr"
 )
 
 type attrCmd struct {
@@ -70,6 +71,11 @@ func (c *attrCmd) RunCommand(args []string) error {
 	if !ok {
 		return fmt.Errorf("Error parsing blobref %q", permanode)
 	}
+	if !c.del {
+		if err := nodeattr.Validate(attr, value); err != nil {
+			return err
+		}
+	}
 	claimFunc := func() func(blob.Ref, string, string) *schema.Builder {
 		switch {
 		case c.add:
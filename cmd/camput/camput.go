@@ -41,6 +41,7 @@ var (
 	flagHTTP       = flag.Bool("verbose_http", false, "show HTTP request summaries")
 	flagHaveCache  = true
 	flagBlobDir    = flag.String("blobdir", "", "If non-empty, the local directory to put blobs, instead of sending them over the network. If the string \"discard\", no blobs are written or sent over the network anywhere.")
+	flagSigner     = flag.String("signer", "", "If non-empty, the GPG key ID to sign with, instead of the config file's \"identity\". The key must be in the client's secret ring.")
 )
 
 var (
@@ -151,6 +152,9 @@ func newUploader() *Uploader {
 		cc = client.NewStorageClient(ss)
 	} else {
 		cc = client.NewOrFail()
+		if *flagSigner != "" {
+			cc.SetSignerKeyID(*flagSigner)
+		}
 		proxy := http.ProxyFromEnvironment
 		if flagProxyLocal {
 			proxy = proxyFromEnvironment
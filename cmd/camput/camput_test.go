@@ -242,3 +242,52 @@ func setAndRestore(dst *int, v int) func() {
 	*dst = v
 	return func() { *dst = old }
 }
+
+func TestWatchStateRoundTrip(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "camput-watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	w := &watcher{
+		stateFile: filepath.Join(tmpDir, "state.json"),
+		state:     make(map[string]*watchFileState),
+	}
+	if err := w.loadState(); err != nil {
+		t.Fatalf("loadState with no state file: %v", err)
+	}
+	w.state["/scans/doc.pdf"] = &watchFileState{
+		Size:         123,
+		ModTime:      456789,
+		Inode:        42,
+		Sum:          "sha1-0000000000000000000000000000000000000001",
+		Permanode:    "sha1-0000000000000000000000000000000000000002",
+		InCollection: true,
+	}
+	if err := w.saveState(); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+
+	w2 := &watcher{
+		stateFile: w.stateFile,
+		state:     make(map[string]*watchFileState),
+	}
+	if err := w2.loadState(); err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	st := w2.state["/scans/doc.pdf"]
+	if st == nil {
+		t.Fatal("state for /scans/doc.pdf not loaded")
+	}
+	if *st != *w.state["/scans/doc.pdf"] {
+		t.Errorf("loaded state = %+v; want %+v", st, w.state["/scans/doc.pdf"])
+	}
+
+	if !st.matches(fingerprint{size: 123, modTime: 456789, inode: 42}) {
+		t.Error("state doesn't match its own fingerprint")
+	}
+	if st.matches(fingerprint{size: 123, modTime: 456790, inode: 42}) {
+		t.Error("state matches a changed mtime")
+	}
+}
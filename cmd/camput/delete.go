@@ -48,13 +48,13 @@ func (c *deleteCmd) RunCommand(args []string) error {
 	if len(args) < 1 {
 		return cmdmain.UsageError("Need at least one blob to delete.")
 	}
-	if err := delete(args); err != nil {
+	if err := doDelete(args); err != nil {
 		return err
 	}
 	return nil
 }
 
-func delete(args []string) error {
+func doDelete(args []string) error {
 	for _, arg := range args {
 		br, ok := blob.Parse(arg)
 		if !ok {
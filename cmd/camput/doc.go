@@ -38,6 +38,7 @@ Examples:
   camput file [opts] <file(s)/director(ies)
   camput file --permanode --name='Homedir backup' --tag=backup,homedir $HOME
   camput file --filenodes /mnt/camera/DCIM
+  camput file --watch --watch_collection=sha1-xxxx ~/Scans
 
   camput blob <files>     (raw, without any metadata)
   camput blob -           (read from stdin)
@@ -39,6 +39,7 @@ import (
 	"camlistore.org/pkg/blob"
 	"camlistore.org/pkg/blobserver"
 	statspkg "camlistore.org/pkg/blobserver/stats"
+	"camlistore.org/pkg/camliignore"
 	"camlistore.org/pkg/client"
 	"camlistore.org/pkg/client/android"
 	"camlistore.org/pkg/cmdmain"
@@ -46,8 +47,17 @@ import (
 )
 
 type fileCmd struct {
-	title string
-	tag   string
+	title    string
+	tag      string
+	filename string // when uploading a single file, the name to store it under, instead of the local base name
+
+	manifest    string // path to a CSV manifest of files and attributes to batch-upload
+	manifestOut string // with manifest, optional CSV file to write with the resulting permanode refs
+	strict      bool   // with manifest, abort on the first bad row instead of skipping it
+
+	watch           bool   // watch the given directory and upload files as they appear or change
+	watchCollection string // with watch, blobref of a collection permanode to add the files to
+	watchState      string // with watch, path of the state file; empty means a default under the cache dir
 
 	makePermanode     bool // make new, unique permanode of the root (dir or file)
 	filePermanodes    bool // make planned permanodes for each file (based on their digest)
@@ -57,6 +67,7 @@ type fileCmd struct {
 	diskUsage         bool // show "du" disk usage only (dry run mode), don't actually upload
 	argsFromInput     bool // Android mode: filenames piped into stdin, one at a time.
 	deleteAfterUpload bool // with fileNodes, deletes the input file once uploaded
+	noCamliignore     bool // don't read .camliignore files in uploaded directory trees
 
 	statcache bool
 
@@ -86,7 +97,15 @@ func init() {
 		flags.BoolVar(&cmd.exifTime, "exiftime", false, "Try to use metadata (such as EXIF) to get a stable creation time. If found, used as the replacement for the modtime. Mainly useful with vivify or filenodes.")
 		flags.StringVar(&cmd.title, "title", "", "Optional title attribute to set on permanode when using -permanode.")
 		flags.StringVar(&cmd.tag, "tag", "", "Optional tag(s) to set on permanode when using -permanode or -filenodes. Single value or comma separated.")
-
+		flags.StringVar(&cmd.filename, "filename", "", "Use this name in the stored file schema, instead of the local file's base name. Only usable when uploading a single file.")
+		flags.StringVar(&cmd.manifest, "manifest", "", "CSV file of files to upload in batch. The header row names a \"path\" column and attribute columns (ex: title,dateCreated,tag); each following row uploads a file, creates a permanode, and sets those attributes. Multiple values in a \"tag\" cell are separated with \"|\".")
+		flags.StringVar(&cmd.manifestOut, "manifest_out", "", "With -manifest, optional CSV file to write, echoing each input row with the resulting permanode ref appended.")
+		flags.BoolVar(&cmd.strict, "strict", false, "With -manifest, abort the batch on the first bad row (missing file, invalid attribute value) instead of reporting and skipping it.")
+		flags.BoolVar(&cmd.watch, "watch", false, "Watch the given directory and upload new or modified files as their writes settle. Runs until interrupted; implies -filenodes.")
+		flags.StringVar(&cmd.watchCollection, "watch_collection", "", "With -watch, blobref of a collection permanode; each uploaded file's permanode is added to it as a camliMember.")
+		flags.StringVar(&cmd.watchState, "watch_state", "", "With -watch, file recording what has been uploaded, so restarts skip unchanged files. Default is a per-directory file under the Camlistore cache directory.")
+
+		flags.BoolVar(&cmd.noCamliignore, "no-camliignore", false, "Don't read .camliignore files in uploaded directory trees.")
 		flags.BoolVar(&cmd.diskUsage, "du", false, "Dry run mode: only show disk usage information, without upload or statting dest. Used for testing skipDirs configs, mostly.")
 
 		if debug, _ := strconv.ParseBool(os.Getenv("CAMLI_DEBUG")); debug {
@@ -124,6 +143,8 @@ func (c *fileCmd) Examples() []string {
 		"[opts] <file(s)/director(ies)",
 		"--permanode --title='Homedir backup' --tag=backup,homedir $HOME",
 		"--filenodes /mnt/camera/DCIM",
+		"--manifest=scans.csv --manifest_out=scans-refs.csv",
+		"--watch --watch_collection=sha1-xxxx ~/Scans",
 	}
 }
 
@@ -145,6 +166,40 @@ func (c *fileCmd) RunCommand(args []string) error {
 	if c.deleteAfterUpload && !c.filePermanodes {
 		return cmdmain.UsageError("Can't set use --delete_after_upload without --filenodes")
 	}
+	if c.manifest != "" {
+		if c.vivify || c.makePermanode || c.filePermanodes || c.tag != "" || c.title != "" || c.filename != "" {
+			return cmdmain.UsageError("--manifest excludes the other file options")
+		}
+		if len(args) > 0 {
+			return cmdmain.UsageError("--manifest doesn't take file arguments; the files to upload come from the manifest")
+		}
+	}
+	if c.manifestOut != "" && c.manifest == "" {
+		return cmdmain.UsageError("Can't use --manifest_out without --manifest")
+	}
+	if c.watch {
+		if c.vivify || c.makePermanode || c.manifest != "" || c.diskUsage || c.argsFromInput || c.filename != "" {
+			return cmdmain.UsageError("--watch excludes the other file modes")
+		}
+		if len(args) != 1 {
+			return cmdmain.UsageError("--watch requires exactly one directory argument")
+		}
+		// Watch mode always makes content-based permanodes, so
+		// renamed files keep their permanode.
+		c.filePermanodes = true
+	}
+	if c.watchCollection != "" && !c.watch {
+		return cmdmain.UsageError("Can't use --watch_collection without --watch")
+	}
+	if c.watchState != "" && !c.watch {
+		return cmdmain.UsageError("Can't use --watch_state without --watch")
+	}
+	if c.strict && c.manifest == "" {
+		return cmdmain.UsageError("Can't use --strict without --manifest")
+	}
+	if c.filename != "" && len(args) != 1 {
+		return cmdmain.UsageError("--filename requires exactly one file argument")
+	}
 	up := getUploader()
 	if c.memstats {
 		sr := new(statspkg.Receiver)
@@ -153,7 +208,7 @@ func (c *fileCmd) RunCommand(args []string) error {
 	}
 	c.initCaches(up)
 
-	if c.makePermanode || c.filePermanodes {
+	if c.makePermanode || c.filePermanodes || c.manifest != "" {
 		testSigBlobRef := up.Client.SignerPublicKeyBlobref()
 		if !testSigBlobRef.Valid() {
 			return cmdmain.UsageError("A GPG key is needed to create permanodes; configure one or use vivify mode.")
@@ -165,6 +220,20 @@ func (c *fileCmd) RunCommand(args []string) error {
 		vivify:    c.vivify,
 		exifTime:  c.exifTime,
 		capCtime:  c.capCtime,
+		filename:  c.filename,
+	}
+
+	if c.manifest != "" {
+		if up.statCache != nil {
+			defer up.statCache.Close()
+		}
+		return c.uploadManifest(up)
+	}
+	if c.watch {
+		if up.statCache != nil {
+			defer up.statCache.Close()
+		}
+		return c.runWatch(up, args[0])
 	}
 
 	var (
@@ -208,6 +277,7 @@ func (c *fileCmd) RunCommand(args []string) error {
 			return errors.New("args not supported with -argsfrominput")
 		}
 		tu := up.NewRootlessTreeUpload()
+		tu.NoCamliignore = c.noCamliignore
 		tu.Start()
 		br := bufio.NewReader(os.Stdin)
 		for {
@@ -243,6 +313,9 @@ func (c *fileCmd) RunCommand(args []string) error {
 			continue
 		}
 		if fi.IsDir() {
+			if c.filename != "" {
+				return cmdmain.UsageError("--filename can only be used when uploading a single file, not a directory")
+			}
 			if up.fileOpts.wantVivify() {
 				vlog.Printf("Directories not supported in vivify mode; skipping %v\n", filename)
 				continue
@@ -251,6 +324,7 @@ func (c *fileCmd) RunCommand(args []string) error {
 				log.SetOutput(ioutil.Discard)
 			}
 			t := up.NewTreeUpload(filename)
+			t.NoCamliignore = c.noCamliignore
 			t.Start()
 			lastPut, err = t.Wait()
 		} else {
@@ -544,6 +618,9 @@ func (up *Uploader) fileMapFromDuplicate(bs blobserver.StatReceiver, fileMap *sc
 func (up *Uploader) uploadNodeRegularFile(n *node) (*client.PutResult, error) {
 	filebb := schema.NewCommonFileMap(n.fullPath, n.fi)
 	filebb.SetType("file")
+	if name := up.fileOpts.fileName(); name != "" {
+		filebb.SetFileName(name)
+	}
 
 	up.fdGate.Start()
 	defer up.fdGate.Done()
@@ -884,6 +961,10 @@ type TreeUpload struct {
 	// command.
 	DiskUsageMode bool
 
+	// If NoCamliignore is set true before Start, .camliignore
+	// files found while walking the tree are not consulted.
+	NoCamliignore bool
+
 	// Immutable:
 	rootless bool   // if true, "base" will be empty.
 	base     string // base directory
@@ -904,11 +985,13 @@ type TreeUpload struct {
 
 // Enqueue starts uploading path (a file, directory, etc).
 func (t *TreeUpload) Enqueue(path string) {
-	t.statPath(path, nil)
+	t.statPath(path, nil, nil)
 }
 
-// fi is optional (will be statted if nil)
-func (t *TreeUpload) statPath(fullPath string, fi os.FileInfo) (nod *node, err error) {
+// fi is optional (will be statted if nil). ign carries the
+// .camliignore rules accumulated from ancestor directories and may be
+// nil.
+func (t *TreeUpload) statPath(fullPath string, fi os.FileInfo, ign *camliignore.Matcher) (nod *node, err error) {
 	defer func() {
 		if err == nil && nod != nil {
 			t.stattedc <- nod
@@ -933,6 +1016,12 @@ func (t *TreeUpload) statPath(fullPath string, fi os.FileInfo) (nod *node, err e
 	if !fi.IsDir() {
 		return n, nil
 	}
+	if !t.NoCamliignore {
+		ign, err = ign.WithDir(fullPath)
+		if err != nil {
+			return nil, err
+		}
+	}
 	f, err := t.up.open(fullPath)
 	if err != nil {
 		return nil, err
@@ -944,7 +1033,12 @@ func (t *TreeUpload) statPath(fullPath string, fi os.FileInfo) (nod *node, err e
 	}
 	sort.Sort(byTypeAndName(fis))
 	for _, fi := range fis {
-		depn, err := t.statPath(filepath.Join(fullPath, filepath.Base(fi.Name())), fi)
+		childPath := filepath.Join(fullPath, filepath.Base(fi.Name()))
+		if ign.Ignored(childPath, fi.IsDir()) {
+			vlog.Printf("%v ignored by a %s rule; skipping.", childPath, camliignore.IgnoreFileName)
+			continue
+		}
+		depn, err := t.statPath(childPath, fi, ign)
 		if err != nil {
 			return nil, err
 		}
@@ -967,7 +1061,7 @@ func (t *TreeUpload) run() {
 	rootc := make(chan *node, 1)
 	if !t.rootless {
 		go func() {
-			n, err := t.statPath(t.base, nil)
+			n, err := t.statPath(t.base, nil, nil)
 			if err != nil {
 				log.Fatalf("Error scanning files under %s: %v", t.base, err)
 			}
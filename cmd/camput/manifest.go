@@ -0,0 +1,185 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/schema/nodeattr"
+)
+
+// manifestValueSeparator separates the values of a multi-valued
+// attribute column (such as "tag") within one manifest cell.
+const manifestValueSeparator = "|"
+
+// manifestMultiValued lists the manifest columns whose cells may hold
+// several values, separated by manifestValueSeparator. Each value
+// becomes its own attribute claim.
+var manifestMultiValued = map[string]bool{
+	"tag": true,
+}
+
+// manifestValues returns the attribute values held by one manifest
+// cell: the cell itself, or its manifestValueSeparator-separated
+// parts for multi-valued columns such as "tag".
+func manifestValues(attr, cell string) []string {
+	if manifestMultiValued[attr] {
+		return strings.Split(cell, manifestValueSeparator)
+	}
+	return []string{cell}
+}
+
+// uploadManifest uploads the files named in the CSV manifest file
+// c.manifest, creating a permanode per row and setting the attributes
+// named by the row's columns. The first row is a header naming a
+// "path" column plus any attribute columns. Bad rows (missing file,
+// invalid attribute value) are reported and skipped, unless c.strict
+// is set, in which case the first one aborts the batch.
+func (c *fileCmd) uploadManifest(up *Uploader) error {
+	f, err := os.Open(c.manifest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cr := csv.NewReader(f)
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("manifest %s: reading header row: %v", c.manifest, err)
+	}
+	pathCol := -1
+	for i, name := range header {
+		if name == "path" {
+			pathCol = i
+		}
+	}
+	if pathCol == -1 {
+		return fmt.Errorf("manifest %s: header row has no \"path\" column", c.manifest)
+	}
+
+	var out *csv.Writer
+	if c.manifestOut != "" {
+		outf, err := os.Create(c.manifestOut)
+		if err != nil {
+			return err
+		}
+		defer outf.Close()
+		out = csv.NewWriter(outf)
+		if err := out.Write(append(append([]string(nil), header...), "permanode")); err != nil {
+			return err
+		}
+	}
+
+	var row, failed int
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		row++
+		var pn string
+		if err == nil {
+			pn, err = c.uploadManifestRow(up, header, pathCol, rec)
+		}
+		if err != nil {
+			if c.strict {
+				return fmt.Errorf("manifest row %d: %v", row, err)
+			}
+			log.Printf("manifest row %d: %v", row, err)
+			failed++
+		}
+		if out != nil {
+			if err := out.Write(append(append([]string(nil), rec...), pn)); err != nil {
+				return err
+			}
+		}
+	}
+	if out != nil {
+		out.Flush()
+		if err := out.Error(); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("manifest: %d of %d rows failed", failed, row)
+	}
+	return nil
+}
+
+// uploadManifestRow handles one data row of the manifest: it checks
+// the attribute values, uploads the file, creates a permanode, and
+// signs the attribute claims. It returns the new permanode's blobref.
+func (c *fileCmd) uploadManifestRow(up *Uploader, header []string, pathCol int, rec []string) (pnRef string, err error) {
+	path := rec[pathCol]
+	if path == "" {
+		return "", errors.New("empty path")
+	}
+	// Check the attribute values before uploading anything, so a bad
+	// row doesn't leave a half-attributed permanode behind.
+	for i, cell := range rec {
+		if i == pathCol || cell == "" {
+			continue
+		}
+		for _, value := range manifestValues(header[i], cell) {
+			if err := nodeattr.Validate(header[i], value); err != nil {
+				return "", err
+			}
+		}
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if fi.IsDir() {
+		return "", fmt.Errorf("%q is a directory; only regular files can be uploaded from a manifest", path)
+	}
+	filePut, err := up.UploadFile(path)
+	if handleResult("manifest-file", filePut, err) != nil {
+		return "", err
+	}
+	permaNode, err := up.UploadNewPermanode()
+	if handleResult("manifest-permanode", permaNode, err) != nil {
+		return "", err
+	}
+	put, err := up.UploadAndSignBlob(schema.NewSetAttributeClaim(permaNode.BlobRef, "camliContent", filePut.BlobRef.String()))
+	if handleResult("claim-permanode-content", put, err) != nil {
+		return "", err
+	}
+	for i, cell := range rec {
+		if i == pathCol || cell == "" {
+			continue
+		}
+		attr := header[i]
+		for j, value := range manifestValues(attr, cell) {
+			m := schema.NewSetAttributeClaim(permaNode.BlobRef, attr, value)
+			if j > 0 {
+				m = schema.NewAddAttributeClaim(permaNode.BlobRef, attr, value)
+			}
+			put, err := up.UploadAndSignBlob(m)
+			if handleResult("claim-permanode-attr", put, err) != nil {
+				return "", err
+			}
+		}
+	}
+	return permaNode.BlobRef.String(), nil
+}
@@ -26,20 +26,44 @@ import (
 	"camlistore.org/pkg/client"
 	"camlistore.org/pkg/cmdmain"
 	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/schema/nodeattr"
 )
 
 type permanodeCmd struct {
 	title   string
 	tag     string
+	attrs   attrFlag
 	key     string // else random
 	sigTime string
 }
 
+// attrFlag is a repeatable "attr=value" flag, validating recognized
+// attribute values as they are parsed.
+type attrFlag [][2]string
+
+func (af *attrFlag) String() string {
+	return fmt.Sprintf("%v", [][2]string(*af))
+}
+
+func (af *attrFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 1 {
+		return fmt.Errorf("invalid attribute %q; want \"attr=value\"", s)
+	}
+	attr, value := s[:i], s[i+1:]
+	if err := nodeattr.Validate(attr, value); err != nil {
+		return err
+	}
+	*af = append(*af, [2]string{attr, value})
+	return nil
+}
+
 func init() {
 	cmdmain.RegisterCommand("permanode", func(flags *flag.FlagSet) cmdmain.CommandRunner {
 		cmd := new(permanodeCmd)
 		flags.StringVar(&cmd.title, "title", "", "Optional 'title' attribute to set on new permanode")
 		flags.StringVar(&cmd.tag, "tag", "", "Optional tag(s) to set on new permanode; comma separated.")
+		flags.Var(&cmd.attrs, "attr", "Optional attribute to set on new permanode, of the form \"attr=value\". May be repeated; repeating an attribute adds values. Recognized attributes (dates, latitude, ...) are validated.")
 		flags.StringVar(&cmd.key, "key", "", "Optional key to create deterministic ('planned') permanodes. Must also use --sigtime.")
 		flags.StringVar(&cmd.sigTime, "sigtime", "", "Optional time to put in the OpenPGP signature packet instead of the current time. Required when producing a deterministic permanode (with --key). In format YYYY-MM-DD HH:MM:SS")
 		return cmd
@@ -102,5 +126,16 @@ func (c *permanodeCmd) RunCommand(args []string) error {
 			handleResult("claim-permanode-tag", put, err)
 		}
 	}
+	seenAttr := make(map[string]bool)
+	for _, kv := range c.attrs {
+		attr, value := kv[0], kv[1]
+		m := schema.NewSetAttributeClaim(permaNode.BlobRef, attr, value)
+		if seenAttr[attr] {
+			m = schema.NewAddAttributeClaim(permaNode.BlobRef, attr, value)
+		}
+		seenAttr[attr] = true
+		put, err := up.UploadAndSignBlob(m)
+		handleResult("claim-permanode-attr", put, err)
+	}
 	return nil
 }
@@ -3,6 +3,7 @@
 package main
 
 import (
+	"os"
 	"syscall"
 )
 
@@ -15,4 +16,11 @@ func init() {
 		st.Atimespec = syscall.Timespec{}
 		return st
 	}
+	statInode = func(fi os.FileInfo) uint64 {
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			return 0
+		}
+		return uint64(st.Ino)
+	}
 }
@@ -7,6 +7,7 @@
 package main
 
 import (
+	"os"
 	"syscall"
 )
 
@@ -19,4 +20,11 @@ func init() {
 		st.Atim = syscall.Timespec{}
 		return st
 	}
+	statInode = func(fi os.FileInfo) uint64 {
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			return 0
+		}
+		return uint64(st.Ino)
+	}
 }
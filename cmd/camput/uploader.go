@@ -57,6 +57,9 @@ type fileOptions struct {
 	vivify   bool
 	exifTime bool // use the time in exif metadata as the modtime if possible.
 	capCtime bool // use mtime as ctime if ctime > mtime
+	// filename, if non-empty, is the name to store in the file schema
+	// instead of the local file's base name.
+	filename string
 }
 
 func (o *fileOptions) tags() []string {
@@ -78,6 +81,13 @@ func (o *fileOptions) wantCapCtime() bool {
 	return o != nil && o.capCtime
 }
 
+func (o *fileOptions) fileName() string {
+	if o == nil {
+		return ""
+	}
+	return o.filename
+}
+
 func (up *Uploader) uploadString(s string) (*client.PutResult, error) {
 	return up.Upload(client.NewUploadHandleFromString(s))
 }
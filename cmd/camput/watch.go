@@ -0,0 +1,355 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/camliignore"
+	"camlistore.org/pkg/osutil"
+	"camlistore.org/pkg/schema"
+)
+
+const (
+	// watchPoll is how often the watched tree is re-scanned for
+	// changes.
+	// TODO(bradfitz): use inotify/FSEvents where available, with
+	// the scan only as a fallback and for catching up after
+	// missed events.
+	watchPoll = 2 * time.Second
+
+	// watchQuiet is how long a changed file must keep the same
+	// size and mtime before it's uploaded, so files still being
+	// written aren't uploaded mid-write.
+	watchQuiet = 3 * time.Second
+
+	// watchRenameWindow is how long a disappeared file is
+	// remembered, so a file appearing elsewhere with the same
+	// inode and contents is treated as a rename of it.
+	watchRenameWindow = 1 * time.Minute
+)
+
+// statInode returns fi's inode number for rename detection, or zero
+// if the operating system doesn't expose one. It's overridden at
+// init on OSes where fi.Sys() has it.
+var statInode = func(fi os.FileInfo) uint64 { return 0 }
+
+// watchFileState records what camput last uploaded for one file of
+// the watched tree. It's persisted in the watch state file between
+// runs, so a restart doesn't re-digest or re-upload unchanged files.
+type watchFileState struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"` // in unix nanoseconds
+	Inode   uint64 `json:"inode,omitempty"`
+
+	Sum          string `json:"sum"`                    // blobref of the file's whole contents
+	Permanode    string `json:"permanode,omitempty"`    // planned permanode for Sum, once known
+	InCollection bool   `json:"inCollection,omitempty"` // whether the camliMember claim was made
+}
+
+// fingerprint is what a scan records per file to decide whether it
+// changed.
+type fingerprint struct {
+	size    int64
+	modTime int64 // in unix nanoseconds
+	inode   uint64
+}
+
+func (st *watchFileState) matches(fp fingerprint) bool {
+	return st.Size == fp.size && st.ModTime == fp.modTime && st.Inode == fp.inode
+}
+
+// pendingFile is a file seen changed but not yet quiet long enough
+// to upload.
+type pendingFile struct {
+	fp    fingerprint
+	since time.Time // when fp was first seen
+}
+
+// removedFile is a recently disappeared file, kept around for
+// watchRenameWindow to detect renames.
+type removedFile struct {
+	st *watchFileState
+	at time.Time
+}
+
+// A watcher implements camput file --watch: it keeps a directory
+// tree in sync with the server, uploading files as they appear or
+// change.
+type watcher struct {
+	up            *Uploader
+	root          string   // absolute path of the watched directory
+	collection    blob.Ref // if valid, each file's permanode is added to it as a camliMember
+	stateFile     string
+	noCamliignore bool
+
+	state   map[string]*watchFileState // file path -> what was last uploaded
+	pending map[string]pendingFile
+	removed map[uint64]removedFile // by inode
+}
+
+func (c *fileCmd) runWatch(up *Uploader, dir string) error {
+	fullPath, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(fullPath)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("--watch argument %q is not a directory", dir)
+	}
+	w := &watcher{
+		up:            up,
+		root:          fullPath,
+		stateFile:     c.watchState,
+		noCamliignore: c.noCamliignore,
+		state:         make(map[string]*watchFileState),
+		pending:       make(map[string]pendingFile),
+		removed:       make(map[uint64]removedFile),
+	}
+	if c.watchCollection != "" {
+		ref, ok := blob.Parse(c.watchCollection)
+		if !ok {
+			return fmt.Errorf("invalid --watch_collection blobref %q", c.watchCollection)
+		}
+		w.collection = ref
+	}
+	if w.stateFile == "" {
+		w.stateFile = filepath.Join(osutil.CacheDir(),
+			fmt.Sprintf("camput.watch.%08x.json", crc32.ChecksumIEEE([]byte(fullPath))))
+	}
+	if err := w.loadState(); err != nil {
+		return fmt.Errorf("reading watch state file %v: %v", w.stateFile, err)
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+
+	log.Printf("Watching %s; state in %s", w.root, w.stateFile)
+	ticker := time.NewTicker(watchPoll)
+	defer ticker.Stop()
+	for {
+		w.scanOnce()
+		select {
+		case <-ticker.C:
+		case sig := <-sigc:
+			// Uploads happen synchronously in scanOnce, so
+			// anything in flight already finished; just record
+			// where we got to.
+			log.Printf("camput watch: got %v; exiting.", sig)
+			return w.saveState()
+		}
+	}
+}
+
+// scanOnce walks the watched tree once, uploading the files whose
+// changes have settled for at least watchQuiet.
+func (w *watcher) scanOnce() {
+	now := time.Now()
+	for ino, rm := range w.removed {
+		if now.Sub(rm.at) > watchRenameWindow {
+			delete(w.removed, ino)
+		}
+	}
+
+	files := make(map[string]fingerprint)
+	w.scanDir(w.root, nil, files)
+
+	dirty := false
+	for path, st := range w.state {
+		if _, ok := files[path]; ok {
+			continue
+		}
+		delete(w.state, path)
+		if st.Inode != 0 {
+			w.removed[st.Inode] = removedFile{st: st, at: now}
+		}
+		dirty = true
+	}
+
+	var due []string
+	for path, fp := range files {
+		if st, ok := w.state[path]; ok && st.matches(fp) {
+			delete(w.pending, path)
+			continue
+		}
+		p, ok := w.pending[path]
+		if !ok || p.fp != fp {
+			w.pending[path] = pendingFile{fp: fp, since: now}
+			continue
+		}
+		if now.Sub(p.since) >= watchQuiet {
+			due = append(due, path)
+		}
+	}
+	sort.Strings(due)
+	for _, path := range due {
+		if err := w.uploadOne(path, files[path]); err != nil {
+			log.Printf("camput watch: uploading %s: %v", path, err)
+			// Leave it pending; the next quiet period retries.
+			w.pending[path] = pendingFile{fp: files[path], since: time.Now()}
+			continue
+		}
+		delete(w.pending, path)
+		dirty = true
+	}
+
+	if dirty {
+		if err := w.saveState(); err != nil {
+			log.Printf("camput watch: writing state file %v: %v", w.stateFile, err)
+		}
+	}
+}
+
+// scanDir adds the regular files under dir to files, honoring
+// .camliignore and the client's ignore patterns. ign carries the
+// rules accumulated from ancestor directories and may be nil.
+func (w *watcher) scanDir(dir string, ign *camliignore.Matcher, files map[string]fingerprint) {
+	if !w.noCamliignore {
+		var err error
+		ign, err = ign.WithDir(dir)
+		if err != nil {
+			log.Printf("camput watch: reading %s in %s: %v", camliignore.IgnoreFileName, dir, err)
+		}
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		log.Printf("camput watch: %v", err)
+		return
+	}
+	fis, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		log.Printf("camput watch: reading %s: %v", dir, err)
+		return
+	}
+	for _, fi := range fis {
+		path := filepath.Join(dir, fi.Name())
+		if w.up.Client.IsIgnoredFile(path) || ign.Ignored(path, fi.IsDir()) {
+			continue
+		}
+		if fi.IsDir() {
+			w.scanDir(path, ign, files)
+			continue
+		}
+		if fi.Mode()&os.ModeType != 0 {
+			continue // regular files only
+		}
+		files[path] = fingerprint{
+			size:    fi.Size(),
+			modTime: fi.ModTime().UnixNano(),
+			inode:   statInode(fi),
+		}
+	}
+}
+
+// uploadOne uploads path, which has been quiet for watchQuiet, and
+// records the result in w.state.
+func (w *watcher) uploadOne(path string, fp fingerprint) error {
+	// A new file with the inode of a recently disappeared one is
+	// probably that file renamed; if the contents match too, keep
+	// its permanode bookkeeping instead of starting over.
+	var prev *watchFileState
+	if fp.inode != 0 {
+		if rm, ok := w.removed[fp.inode]; ok && rm.st.Size == fp.size {
+			prev = rm.st
+		}
+	}
+
+	sum, err := w.up.wholeFileDigest(path)
+	if err != nil {
+		return err
+	}
+	// UploadFile uses content-based planned permanodes, so a
+	// renamed file maps back to its old permanode and only its
+	// camliContent claim (pointing at the new file schema, with
+	// the new name) is updated.
+	pr, err := w.up.UploadFile(path)
+	if err != nil {
+		return err
+	}
+	handleResult("file", pr, nil)
+
+	st := &watchFileState{
+		Size:    fp.size,
+		ModTime: fp.modTime,
+		Inode:   fp.inode,
+		Sum:     sum.String(),
+	}
+	if prev != nil && prev.Sum == st.Sum {
+		log.Printf("camput watch: %s is a rename; kept permanode %v", path, prev.Permanode)
+		st.Permanode = prev.Permanode
+		st.InCollection = prev.InCollection
+		delete(w.removed, fp.inode)
+	}
+	if w.collection.Valid() && !st.InCollection {
+		if st.Permanode == "" {
+			// The planned permanode UploadFile made, keyed on
+			// the file's digest; re-upload is cheap (have cache)
+			// and gets us its blobref.
+			ppr, err := w.up.UploadPlannedPermanode(st.Sum, time.Unix(0, 0))
+			if err != nil {
+				return fmt.Errorf("getting permanode of %v: %v", path, err)
+			}
+			st.Permanode = ppr.BlobRef.String()
+		}
+		put, err := w.up.UploadAndSignBlob(schema.NewAddAttributeClaim(w.collection, "camliMember", st.Permanode))
+		if err != nil {
+			return fmt.Errorf("adding %v to collection %v: %v", path, w.collection, err)
+		}
+		handleResult("watch-member", put, nil)
+		st.InCollection = true
+	}
+	w.state[path] = st
+	return nil
+}
+
+func (w *watcher) loadState() error {
+	data, err := ioutil.ReadFile(w.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &w.state)
+}
+
+func (w *watcher) saveState() error {
+	data, err := json.MarshalIndent(w.state, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := w.stateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.stateFile)
+}
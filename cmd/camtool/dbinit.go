@@ -17,11 +17,13 @@ limitations under the License.
 package main
 
 import (
+	"bufio"
 	"database/sql"
 	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"camlistore.org/pkg/cmdmain"
@@ -30,7 +32,7 @@ import (
 	"camlistore.org/pkg/sorted/postgres"
 	"camlistore.org/pkg/sorted/sqlite"
 
-	_ "camlistore.org/third_party/github.com/go-sql-driver/mysql"
+	sqldrivermysql "camlistore.org/third_party/github.com/go-sql-driver/mysql"
 	_ "camlistore.org/third_party/github.com/lib/pq"
 	"camlistore.org/third_party/labix.org/v2/mgo"
 )
@@ -41,11 +43,14 @@ type dbinitCmd struct {
 	host     string
 	dbName   string
 	dbType   string
+	dbInfo   string // combined "user@host:password" form, as in the high-level config
 	sslMode  string // Postgres SSL mode configuration
 
-	wipe bool
-	keep bool
-	wal  bool // Write-Ahead Logging for SQLite
+	wipe  bool
+	force bool // skip the interactive confirmation for --wipe
+	check bool // only verify connectivity and schema version
+	keep  bool
+	wal   bool // Write-Ahead Logging for SQLite
 }
 
 func init() {
@@ -56,9 +61,12 @@ func init() {
 		flags.StringVar(&cmd.host, "host", "localhost", "host[:port]")
 		flags.StringVar(&cmd.dbName, "dbname", "", "Database to wipe or create. For sqlite, this is the db filename.")
 		flags.StringVar(&cmd.dbType, "dbtype", "mysql", "Which RDMS to use; possible values: mysql, postgres, sqlite, mongo.")
+		flags.StringVar(&cmd.dbInfo, "dbinfo", "", `Combined credentials, in the same form the server config uses: "user@host:password" (mysql, postgres) or "user:password@host" (mongo). Overrides -user, -host, and -password.`)
 		flags.StringVar(&cmd.sslMode, "sslmode", "require", "Configure SSL mode for postgres. Possible values: require, verify-full, disable.")
 
 		flags.BoolVar(&cmd.wipe, "wipe", false, "Wipe the database and re-create it?")
+		flags.BoolVar(&cmd.force, "force", false, "Don't ask for confirmation before wiping.")
+		flags.BoolVar(&cmd.check, "check", false, "Only verify connectivity and the schema version; modify nothing.")
 		flags.BoolVar(&cmd.keep, "ignoreexists", false, "Do nothing if database already exists.")
 		// Defaults to true, because it fixes http://camlistore.org/issues/114
 		flags.BoolVar(&cmd.wal, "wal", true, "Enable Write-Ahead Logging with SQLite, for better concurrency. Requires SQLite >= 3.7.0.")
@@ -78,6 +86,7 @@ func (c *dbinitCmd) Usage() {
 func (c *dbinitCmd) Examples() []string {
 	return []string{
 		"-user root -password root -host localhost -dbname camliprod -wipe",
+		`-dbtype postgres -dbinfo "camli@localhost:pass" -dbname camliprod -check`,
 	}
 }
 
@@ -85,6 +94,11 @@ func (c *dbinitCmd) RunCommand(args []string) error {
 	if c.dbName == "" {
 		return cmdmain.UsageError("--dbname flag required")
 	}
+	if c.dbInfo != "" {
+		if err := c.parseDBInfo(); err != nil {
+			return cmdmain.UsageError(err.Error())
+		}
+	}
 
 	if c.dbType != "mysql" && c.dbType != "postgres" && c.dbType != "mongo" {
 		if c.dbType == "sqlite" {
@@ -100,6 +114,10 @@ func (c *dbinitCmd) RunCommand(args []string) error {
 		}
 	}
 
+	if c.check {
+		return c.checkDB()
+	}
+
 	var rootdb *sql.DB
 	var err error
 	switch c.dbType {
@@ -122,10 +140,16 @@ func (c *dbinitCmd) RunCommand(args []string) error {
 		if !c.wipe {
 			return cmdmain.UsageError(fmt.Sprintf("Database %q already exists, but --wipe not given. Stopping.", dbname))
 		}
-		if c.dbType == "mongo" {
-			return c.wipeMongo()
+		if !c.force {
+			if err := c.confirmWipe(); err != nil {
+				return err
+			}
 		}
-		if c.dbType != "sqlite" {
+		if c.dbType == "mongo" {
+			if err := c.wipeMongo(); err != nil {
+				return err
+			}
+		} else if c.dbType != "sqlite" {
 			do(rootdb, "DROP DATABASE "+dbname)
 		}
 	}
@@ -136,7 +160,7 @@ func (c *dbinitCmd) RunCommand(args []string) error {
 			exitf("Error creating file %v for sqlite db: %v", dbname, err)
 		}
 	case "mongo":
-		return nil
+		return c.initMongo()
 	case "postgres":
 		// because we want string comparison to work as on MySQL and SQLite.
 		// in particular we want: 'foo|bar' < 'foo}' (which is not the case with an utf8 collation apparently).
@@ -145,16 +169,7 @@ func (c *dbinitCmd) RunCommand(args []string) error {
 		do(rootdb, "CREATE DATABASE "+dbname)
 	}
 
-	var db *sql.DB
-	switch c.dbType {
-	case "postgres":
-		conninfo := fmt.Sprintf("user=%s dbname=%s host=%s password=%s sslmode=%s", c.user, dbname, c.host, c.password, c.sslMode)
-		db, err = sql.Open("postgres", conninfo)
-	case "sqlite":
-		db, err = sql.Open("sqlite3", dbname)
-	default:
-		db, err = sql.Open("mysql", c.user+":"+c.password+"@/"+dbname)
-	}
+	db, err := c.openTargetDB()
 	if err != nil {
 		return fmt.Errorf("Connecting to the %s %s database: %v", dbname, c.dbType, err)
 	}
@@ -185,10 +200,156 @@ func (c *dbinitCmd) RunCommand(args []string) error {
 	return nil
 }
 
+// parseDBInfo fills user, host, and password from the -dbinfo flag,
+// which takes the same string form the high-level server config uses:
+// "user@host:password" for mysql and postgres, "user:password@host"
+// for mongo.
+func (c *dbinitCmd) parseDBInfo() error {
+	fields := strings.Split(c.dbInfo, "@")
+	if c.dbType == "mongo" {
+		creds := strings.Split(fields[0], ":")
+		if len(fields) != 2 || len(creds) != 2 {
+			return fmt.Errorf(`malformed --dbinfo value %q; want "user:password@host" for mongo`, c.dbInfo)
+		}
+		c.user, c.password, c.host = creds[0], creds[1], fields[1]
+		return nil
+	}
+	if len(fields) != 2 {
+		return fmt.Errorf(`malformed --dbinfo value %q; want "user@host:password"`, c.dbInfo)
+	}
+	hostpw := strings.Split(fields[1], ":")
+	if len(hostpw) != 2 {
+		return fmt.Errorf(`malformed --dbinfo value %q; want "user@host:password"`, c.dbInfo)
+	}
+	c.user, c.host, c.password = fields[0], hostpw[0], hostpw[1]
+	return nil
+}
+
+// confirmWipe asks on the terminal whether the database should really
+// be dropped, for --wipe without --force.
+func (c *dbinitCmd) confirmWipe() error {
+	fmt.Fprintf(os.Stdout, "About to WIPE the %s database %q on %s, deleting the index. Type \"yes\" to continue: ", c.dbType, c.dbName, c.host)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("reading confirmation: %v", err)
+	}
+	if strings.ToLower(strings.TrimSpace(line)) != "yes" {
+		return errors.New("wipe canceled")
+	}
+	return nil
+}
+
+// openTargetDB connects to the database to be initialized or checked
+// itself, as opposed to the root database used to create it.
+func (c *dbinitCmd) openTargetDB() (*sql.DB, error) {
+	switch c.dbType {
+	case "postgres":
+		conninfo := fmt.Sprintf("user=%s dbname=%s host=%s password=%s sslmode=%s", c.user, c.dbName, c.host, c.password, c.sslMode)
+		return sql.Open("postgres", conninfo)
+	case "sqlite":
+		return sql.Open("sqlite3", c.dbName)
+	default:
+		return sql.Open("mysql", c.user+":"+c.password+"@/"+c.dbName)
+	}
+}
+
+// schemaVersion is the index schema version this binary was built
+// with, from the same per-RDBMS packages the server uses at startup.
+func (c *dbinitCmd) schemaVersion() int {
+	switch c.dbType {
+	case "postgres":
+		return postgres.SchemaVersion()
+	case "sqlite":
+		return sqlite.SchemaVersion()
+	default:
+		return mysql.SchemaVersion()
+	}
+}
+
+// checkDB verifies, without modifying anything, that the database is
+// reachable with the given credentials and that its schema version
+// matches this binary's.
+func (c *dbinitCmd) checkDB() error {
+	if c.dbType == "mongo" {
+		session, err := c.mongoSession()
+		if err != nil {
+			return fmt.Errorf("dbinit --check: connecting to mongo: %v", err)
+		}
+		defer session.Close()
+		n, err := session.DB(c.dbName).C(mongo.CollectionName).Find(nil).Count()
+		if err != nil {
+			return fmt.Errorf("dbinit --check: reading collection %q: %v", mongo.CollectionName, err)
+		}
+		fmt.Printf("Database %q OK; collection %q has %d keys.\n", c.dbName, mongo.CollectionName, n)
+		return nil
+	}
+	db, err := c.openTargetDB()
+	if err != nil {
+		return describeDBError(err)
+	}
+	defer db.Close()
+	var version string
+	err = db.QueryRow(`SELECT value FROM meta WHERE metakey = 'version'`).Scan(&version)
+	switch {
+	case err == sql.ErrNoRows:
+		return fmt.Errorf("dbinit --check: database %q exists but records no schema version; run dbinit to create its tables", c.dbName)
+	case err != nil:
+		return describeDBError(err)
+	}
+	if want := strconv.Itoa(c.schemaVersion()); version != want {
+		return fmt.Errorf("dbinit --check: database schema version is %s; this binary expects %s (re-create with --wipe, then reindex)", version, want)
+	}
+	fmt.Printf("Database %q OK; schema version %s.\n", c.dbName, version)
+	return nil
+}
+
+// initMongo creates the keys collection, so that a fresh database
+// doesn't look missing to dbExists and --check.
+func (c *dbinitCmd) initMongo() error {
+	session, err := c.mongoSession()
+	if err != nil {
+		return fmt.Errorf("dbinit: connecting to mongo: %v", err)
+	}
+	defer session.Close()
+	err = session.DB(c.dbName).C(mongo.CollectionName).Create(&mgo.CollectionInfo{})
+	if err != nil && !strings.Contains(err.Error(), "already exists") {
+		return fmt.Errorf("dbinit: creating collection %q: %v", mongo.CollectionName, err)
+	}
+	return nil
+}
+
+// describeDBError annotates common database errors, so that bad
+// credentials, missing privileges, and the like are distinguishable
+// at a glance.
+func describeDBError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if merr, ok := err.(*sqldrivermysql.MySQLError); ok {
+		switch merr.Number {
+		case 1045: // ER_ACCESS_DENIED_ERROR
+			return fmt.Errorf("authentication failed: %v", err)
+		case 1044, 1142, 1227: // ER_DBACCESS_DENIED_ERROR, ER_TABLEACCESS_DENIED_ERROR, ER_SPECIFIC_ACCESS_DENIED_ERROR
+			return fmt.Errorf("missing database privileges: %v", err)
+		}
+	}
+	// The vendored postgres driver doesn't expose SQLSTATE codes;
+	// match its error strings.
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "password authentication failed"),
+		strings.Contains(msg, "Access denied"):
+		return fmt.Errorf("authentication failed: %v", err)
+	case strings.Contains(msg, "permission denied"):
+		return fmt.Errorf("missing database privileges: %v", err)
+	}
+	return err
+}
+
 func do(db *sql.DB, sql string) {
 	_, err := db.Exec(sql)
 	if err != nil {
-		exitf("Error %v running SQL: %s", err, sql)
+		exitf("Error %v running SQL: %s", describeDBError(err), sql)
 	}
 }
 
@@ -242,7 +403,7 @@ func check(err error) {
 	if err == nil {
 		return
 	}
-	exitf("SQL error: %v", err)
+	exitf("SQL error: %v", describeDBError(err))
 }
 
 func exitf(format string, args ...interface{}) {
@@ -0,0 +1,147 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"camlistore.org/pkg/cmdmain"
+	"camlistore.org/pkg/jsonconfig"
+	"camlistore.org/pkg/mdns"
+	"camlistore.org/pkg/osutil"
+)
+
+type discoverCmd struct {
+	timeout time.Duration
+	add     string
+}
+
+func init() {
+	cmdmain.RegisterCommand("discover", func(flags *flag.FlagSet) cmdmain.CommandRunner {
+		cmd := new(discoverCmd)
+		flags.DurationVar(&cmd.timeout, "timeout", 2*time.Second, "How long to wait for mDNS answers.")
+		flags.StringVar(&cmd.add, "add", "", "Add the discovered server to the client config under this profile alias. Requires exactly one discovered server.")
+		return cmd
+	})
+}
+
+func (c *discoverCmd) Describe() string {
+	return "Find Camlistore servers advertised on the local network."
+}
+
+func (c *discoverCmd) Usage() {
+	fmt.Fprintf(os.Stderr, "Usage: camtool [globalopts] discover [--add=<alias>]\n")
+}
+
+func (c *discoverCmd) RunCommand(args []string) error {
+	if len(args) > 0 {
+		return cmdmain.UsageError("doesn't take arguments")
+	}
+	services, err := mdns.Browse(c.timeout)
+	if err != nil {
+		return err
+	}
+	if len(services) == 0 {
+		return fmt.Errorf("no Camlistore server advertised on the local network")
+	}
+	trusted := trustedFingerprints()
+	for _, svc := range services {
+		fmt.Printf("%s\t%s", svc.Instance, svc.BaseURL())
+		if fp := svc.Fingerprint(); fp != "" {
+			if trusted[fp] {
+				fmt.Printf("\t%s (trusted)", fp)
+			} else {
+				fmt.Printf("\t%s (NOT in trustedCerts)", fp)
+			}
+		}
+		fmt.Println()
+	}
+	if c.add == "" {
+		return nil
+	}
+	if len(services) != 1 {
+		return fmt.Errorf("--add requires exactly one discovered server; found %d", len(services))
+	}
+	return addProfile(c.add, services[0])
+}
+
+// trustedFingerprints returns the union of the trustedCerts of all
+// the servers in the client config file, if any.
+func trustedFingerprints() map[string]bool {
+	fps := make(map[string]bool)
+	conf, err := jsonconfig.ReadFile(osutil.UserClientConfigPath())
+	if err != nil {
+		return fps
+	}
+	for _, vei := range jsonconfig.Obj(conf).OptionalObject("servers") {
+		serverMap, ok := vei.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, fp := range jsonconfig.Obj(serverMap).OptionalList("trustedCerts") {
+			fps[fp] = true
+		}
+	}
+	return fps
+}
+
+// addProfile adds svc to the servers section of the client config
+// file under the given alias.
+func addProfile(alias string, svc *mdns.Service) error {
+	if svc.BaseURL() == "" {
+		return fmt.Errorf("server %q did not advertise a URL", svc.Instance)
+	}
+	configPath := osutil.UserClientConfigPath()
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+	var conf map[string]interface{}
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return fmt.Errorf("error parsing %v: %v", configPath, err)
+	}
+	servers, ok := conf["servers"].(map[string]interface{})
+	if !ok {
+		servers = make(map[string]interface{})
+		conf["servers"] = servers
+	}
+	if _, dup := servers[alias]; dup {
+		return fmt.Errorf("profile %q already exists in %v", alias, configPath)
+	}
+	server := map[string]interface{}{
+		"server": svc.BaseURL(),
+	}
+	if fp := svc.Fingerprint(); fp != "" {
+		server["trustedCerts"] = []string{fp}
+	}
+	servers[alias] = server
+	newData, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return err
+	}
+	newData = append(newData, '\n')
+	if err := ioutil.WriteFile(configPath, newData, 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Added profile %q (%s) to %v\n", alias, svc.BaseURL(), configPath)
+	return nil
+}
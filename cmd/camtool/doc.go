@@ -31,6 +31,7 @@ Modes:
   claims: Ask the search system to list the claims that modify a permanode.
   dumpconfig: Dump the low-level server config from its simple config.
   describe: Ask the search system to describe one or more blobs.
+  orphans: List indexed files that no permanode references, as cleanup candidates.
   discovery: Perform configuration discovery against a server.
   reindex-diskpacked: Rebuild the index of the diskpacked blob store
   index: Synchronize blobs for all discovered blobs storage - indexer pairs.
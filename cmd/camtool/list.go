@@ -25,16 +25,21 @@ import (
 	"strings"
 
 	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
 	"camlistore.org/pkg/client"
 	"camlistore.org/pkg/cmdmain"
+	"camlistore.org/pkg/context"
 	"camlistore.org/pkg/search"
 )
 
 type listCmd struct {
 	*syncCmd
 
-	describe bool           // whether to describe each blob.
-	cl       *client.Client // client used for the describe requests.
+	describe  bool           // whether to describe each blob.
+	refPrefix string         // if non-empty, only list blobrefs starting with this.
+	minSize   uint           // if non-zero, only list blobs of at least this many bytes.
+	maxSize   uint           // if non-zero, only list blobs of at most this many bytes.
+	cl        *client.Client // client used for the describe requests.
 }
 
 func init() {
@@ -48,6 +53,9 @@ func init() {
 		flags.StringVar(&cmd.syncCmd.src, "src", "", "Source blobserver is either a URL prefix (with optional path), a host[:port], a path (starting with /, ./, or ../), or blank to use the Camlistore client config's default host.")
 		flags.BoolVar(&cmd.verbose, "verbose", false, "Be verbose.")
 		flags.BoolVar(&cmd.describe, "describe", false, "Use describe requests to get each blob's type. Requires a source server with a search endpoint. Mostly used for demos. Requires many extra round-trips to the server currently.")
+		flags.StringVar(&cmd.refPrefix, "refprefix", "", "Only list blobs whose blobref starts with this prefix (e.g. \"sha1-0f\"). Filtered server-side when the server supports it.")
+		flags.UintVar(&cmd.minSize, "minsize", 0, "If non-zero, only list blobs of at least this many bytes.")
+		flags.UintVar(&cmd.maxSize, "maxsize", 0, "If non-zero, only list blobs of at most this many bytes.")
 		return cmd
 	})
 }
@@ -68,7 +76,7 @@ func (c *listCmd) Examples() []string {
 
 func (c *listCmd) RunCommand(args []string) error {
 	if !c.describe {
-		return c.syncCmd.RunCommand(args)
+		return c.enumerateSource(args)
 	}
 
 	stdout := cmdmain.Stdout
@@ -86,7 +94,7 @@ func (c *listCmd) RunCommand(args []string) error {
 
 	scanner := bufio.NewScanner(pr)
 	go func() {
-		err := c.syncCmd.RunCommand(args)
+		err := c.enumerateSource(args)
 		if err != nil {
 			log.Printf("Error when enumerating source with sync: %v", err)
 		}
@@ -143,6 +151,57 @@ func (c *listCmd) RunCommand(args []string) error {
 	return nil
 }
 
+// filter returns the enumeration filter built from the filter flags.
+func (c *listCmd) filter() blobserver.EnumerateFilter {
+	return blobserver.EnumerateFilter{
+		RefPrefix: c.refPrefix,
+		MinSize:   uint32(c.minSize),
+		MaxSize:   uint32(c.maxSize),
+	}
+}
+
+// enumerateSource writes the source's blobs to cmdmain.Stdout as
+// "blobref size" lines, delegating to the sync machinery unless a
+// filter flag restricts the listing.
+func (c *listCmd) enumerateSource(args []string) error {
+	filter := c.filter()
+	if filter.IsZero() {
+		return c.syncCmd.RunCommand(args)
+	}
+	if len(args) > 0 {
+		return cmdmain.UsageError("doesn't take arguments")
+	}
+	ss, err := c.syncCmd.storageFromParam("src", c.syncCmd.src)
+	if err != nil {
+		return err
+	}
+	ctx := context.TODO()
+	print := func(sb blob.SizedRef) error {
+		_, err := fmt.Fprintf(cmdmain.Stdout, "%s %d\n", sb.Ref, sb.Size)
+		return err
+	}
+	if cl, ok := ss.(*client.Client); ok {
+		// Send the filters along, so a server supporting them
+		// doesn't enumerate everything over the network.
+		ch := make(chan blob.SizedRef, 100)
+		errch := make(chan error, 1)
+		go func() {
+			errch <- cl.EnumerateBlobsOpts(ctx, ch, client.EnumerateOpts{
+				RefPrefix: filter.RefPrefix,
+				MinSize:   filter.MinSize,
+				MaxSize:   filter.MaxSize,
+			})
+		}()
+		for sb := range ch {
+			if err := print(sb); err != nil {
+				return err
+			}
+		}
+		return <-errch
+	}
+	return blobserver.EnumerateAllFiltered(ctx, ss, filter, print)
+}
+
 // setClient configures a client for c, for the describe requests.
 func (c *listCmd) setClient() error {
 	ss, err := c.syncCmd.storageFromParam("src", c.syncCmd.src)
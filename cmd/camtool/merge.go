@@ -0,0 +1,292 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/client"
+	"camlistore.org/pkg/cmdmain"
+	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/search"
+)
+
+const (
+	mergePolicyKeepTarget   = "keep-target"
+	mergePolicyPreferNewest = "prefer-newest"
+)
+
+type mergeCmd struct {
+	server string
+	into   string
+	from   string
+	policy string
+	dryRun bool
+
+	cl *client.Client
+}
+
+func init() {
+	cmdmain.RegisterCommand("merge", func(flags *flag.FlagSet) cmdmain.CommandRunner {
+		cmd := new(mergeCmd)
+		flags.StringVar(&cmd.server, "server", "", "Server to merge permanodes on. "+serverFlagHelp)
+		flags.StringVar(&cmd.into, "into", "", "Target permanode blobref that the sources are merged into.")
+		flags.StringVar(&cmd.from, "from", "", "Comma-separated source permanode blobrefs to merge and delete.")
+		flags.StringVar(&cmd.policy, "policy", mergePolicyKeepTarget, "Conflict policy when the target already has a value for an attribute: \""+mergePolicyKeepTarget+"\" or \""+mergePolicyPreferNewest+"\".")
+		flags.BoolVar(&cmd.dryRun, "dry-run", false, "Print the claims that would be signed, without writing anything.")
+		return cmd
+	})
+}
+
+func (c *mergeCmd) Describe() string {
+	return "Merge duplicate permanodes into one, rewriting references to the merged ones."
+}
+
+func (c *mergeCmd) Usage() {
+	fmt.Fprintf(os.Stderr, "Usage: camtool [globalopts] merge --into=REF --from=REF[,REF...] [--policy=s] [--dry-run]\n")
+}
+
+func (c *mergeCmd) Examples() []string {
+	return []string{}
+}
+
+// permanodeState is the current attribute state of a permanode, as
+// replayed from its claims.
+type permanodeState struct {
+	attrs   map[string][]string  // attribute -> values
+	lastMod map[string]time.Time // attribute -> date of the latest claim touching it
+}
+
+func (c *mergeCmd) RunCommand(args []string) error {
+	if len(args) != 0 {
+		return cmdmain.UsageError("doesn't take arguments")
+	}
+	if c.policy != mergePolicyKeepTarget && c.policy != mergePolicyPreferNewest {
+		return cmdmain.UsageError(fmt.Sprintf("invalid --policy %q", c.policy))
+	}
+	target, ok := blob.Parse(c.into)
+	if !ok {
+		return cmdmain.UsageError(fmt.Sprintf("invalid --into blobref %q", c.into))
+	}
+	var sources []blob.Ref
+	for _, s := range strings.Split(c.from, ",") {
+		br, ok := blob.Parse(s)
+		if !ok {
+			return cmdmain.UsageError(fmt.Sprintf("invalid --from blobref %q", s))
+		}
+		if br == target {
+			return cmdmain.UsageError("--from may not contain the --into permanode")
+		}
+		sources = append(sources, br)
+	}
+	if len(sources) == 0 {
+		return cmdmain.UsageError("--from requires at least one blobref")
+	}
+	c.cl = newClient(c.server)
+
+	targetState, err := c.permanodeState(target)
+	if err != nil {
+		return err
+	}
+
+	var claims []*schema.Builder
+	for _, src := range sources {
+		srcState, err := c.permanodeState(src)
+		if err != nil {
+			return err
+		}
+		claims = append(claims, mergeAttrs(target, targetState, srcState, c.policy)...)
+		refClaims, err := c.rewriteReferences(target, targetState, src)
+		if err != nil {
+			return err
+		}
+		claims = append(claims, refClaims...)
+	}
+	for _, src := range sources {
+		claims = append(claims, schema.NewSetAttributeClaim(src, "mergedInto", target.String()))
+		claims = append(claims, schema.NewDeleteClaim(src))
+	}
+
+	if c.dryRun {
+		for _, bb := range claims {
+			json, err := bb.JSON()
+			if err != nil {
+				return err
+			}
+			fmt.Println(json)
+		}
+		return nil
+	}
+	for _, bb := range claims {
+		put, err := c.cl.UploadAndSignBlob(bb)
+		if err != nil {
+			return fmt.Errorf("error signing merge claim: %v", err)
+		}
+		fmt.Printf("Signed claim %v\n", put.BlobRef)
+	}
+	return nil
+}
+
+// permanodeState replays pn's claims to get its current attributes
+// and the date each attribute was last modified.
+func (c *mergeCmd) permanodeState(pn blob.Ref) (*permanodeState, error) {
+	res, err := c.cl.GetClaims(&search.ClaimsRequest{Permanode: pn})
+	if err != nil {
+		return nil, fmt.Errorf("error getting claims of %v: %v", pn, err)
+	}
+	cc := res.Claims
+	sort.Sort(claimItemsByDate(cc))
+	st := &permanodeState{
+		attrs:   make(map[string][]string),
+		lastMod: make(map[string]time.Time),
+	}
+	for _, cl := range cc {
+		switch cl.Type {
+		case "set-attribute":
+			st.attrs[cl.Attr] = []string{cl.Value}
+		case "add-attribute":
+			if !inList(st.attrs[cl.Attr], cl.Value) {
+				st.attrs[cl.Attr] = append(st.attrs[cl.Attr], cl.Value)
+			}
+		case "del-attribute":
+			if cl.Value == "" {
+				delete(st.attrs, cl.Attr)
+			} else {
+				st.attrs[cl.Attr] = withoutValue(st.attrs[cl.Attr], cl.Value)
+			}
+		default:
+			continue
+		}
+		if t := cl.Date.Time(); t.After(st.lastMod[cl.Attr]) {
+			st.lastMod[cl.Attr] = t
+		}
+	}
+	return st, nil
+}
+
+// mergeAttrs returns the claims giving target the attributes of src
+// that it is missing, and updates targetState with the planned
+// result so later sources merge against it.
+func mergeAttrs(target blob.Ref, targetState, srcState *permanodeState, policy string) []*schema.Builder {
+	var claims []*schema.Builder
+	for _, attr := range sortedAttrs(srcState.attrs) {
+		vals := srcState.attrs[attr]
+		tvals, ok := targetState.attrs[attr]
+		switch {
+		case !ok:
+			// Missing on the target: copy it over.
+			claims = append(claims, schema.NewSetAttributeClaim(target, attr, vals[0]))
+			for _, v := range vals[1:] {
+				claims = append(claims, schema.NewAddAttributeClaim(target, attr, v))
+			}
+			targetState.attrs[attr] = append([]string(nil), vals...)
+			targetState.lastMod[attr] = srcState.lastMod[attr]
+		case policy == mergePolicyPreferNewest && srcState.lastMod[attr].After(targetState.lastMod[attr]):
+			// Conflict, and the source's value is newer: replace.
+			claims = append(claims, schema.NewSetAttributeClaim(target, attr, vals[0]))
+			for _, v := range vals[1:] {
+				claims = append(claims, schema.NewAddAttributeClaim(target, attr, v))
+			}
+			targetState.attrs[attr] = append([]string(nil), vals...)
+			targetState.lastMod[attr] = srcState.lastMod[attr]
+		default:
+			// keep-target: only add values missing from
+			// multi-valued attributes.
+			for _, v := range vals {
+				if len(tvals) > 1 && !inList(tvals, v) {
+					claims = append(claims, schema.NewAddAttributeClaim(target, attr, v))
+					targetState.attrs[attr] = append(targetState.attrs[attr], v)
+				}
+			}
+		}
+	}
+	return claims
+}
+
+// rewriteReferences returns the claims making every permanode whose
+// camliPath or camliMember attributes point at src point at target
+// too. The claims pointing at src are left for the deletion of src to
+// neutralize.
+func (c *mergeCmd) rewriteReferences(target blob.Ref, targetState *permanodeState, src blob.Ref) ([]*schema.Builder, error) {
+	edges, err := c.cl.EdgesTo(&search.EdgesRequest{ToRef: src})
+	if err != nil {
+		return nil, fmt.Errorf("error getting edges to %v: %v", src, err)
+	}
+	var claims []*schema.Builder
+	for _, edge := range edges.EdgesTo {
+		if edge.FromType != "permanode" {
+			continue
+		}
+		fromState, err := c.permanodeState(edge.From)
+		if err != nil {
+			return nil, err
+		}
+		for _, attr := range sortedAttrs(fromState.attrs) {
+			if attr != "camliMember" && !strings.HasPrefix(attr, "camliPath:") {
+				continue
+			}
+			if !inList(fromState.attrs[attr], src.String()) {
+				continue
+			}
+			if inList(fromState.attrs[attr], target.String()) {
+				continue
+			}
+			claims = append(claims, schema.NewAddAttributeClaim(edge.From, attr, target.String()))
+		}
+	}
+	return claims, nil
+}
+
+type claimItemsByDate []*search.ClaimsItem
+
+func (s claimItemsByDate) Len() int           { return len(s) }
+func (s claimItemsByDate) Less(i, j int) bool { return s[i].Date.Time().Before(s[j].Date.Time()) }
+func (s claimItemsByDate) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func sortedAttrs(attrs map[string][]string) []string {
+	var names []string
+	for attr := range attrs {
+		names = append(names, attr)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func inList(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func withoutValue(list []string, v string) []string {
+	var out []string
+	for _, s := range list {
+		if s != v {
+			out = append(out, s)
+		}
+	}
+	return out
+}
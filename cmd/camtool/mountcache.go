@@ -0,0 +1,68 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"camlistore.org/pkg/cmdmain"
+)
+
+type mountCacheCmd struct {
+	flush bool
+}
+
+func init() {
+	cmdmain.RegisterCommand("mount-cache", func(flags *flag.FlagSet) cmdmain.CommandRunner {
+		cmd := new(mountCacheCmd)
+		flags.BoolVar(&cmd.flush, "flush", false, "Upload all dirty blobs before printing the stats.")
+		return cmd
+	})
+}
+
+func (c *mountCacheCmd) Describe() string {
+	return "Show the write-back cache stats of a running cammount, and optionally flush it."
+}
+
+func (c *mountCacheCmd) Usage() {
+	fmt.Fprintf(os.Stderr, "camtool mount-cache [--flush] <mountpoint>\n")
+}
+
+func (c *mountCacheCmd) RunCommand(args []string) error {
+	if len(args) != 1 {
+		return cmdmain.UsageError("requires the cammount mountpoint")
+	}
+	// cammount exposes its write-back cache under the mount's
+	// ".camli_cache" directory; see pkg/fs/cachectl.go.
+	dir := filepath.Join(args[0], ".camli_cache")
+	if c.flush {
+		err := ioutil.WriteFile(filepath.Join(dir, "control"), []byte("flush\n"), 0200)
+		if err != nil {
+			return fmt.Errorf("forcing a flush (is %s a cammount with --writeback?): %v", args[0], err)
+		}
+	}
+	stats, err := ioutil.ReadFile(filepath.Join(dir, "stats"))
+	if err != nil {
+		return fmt.Errorf("reading cache stats (is %s a cammount with --writeback?): %v", args[0], err)
+	}
+	_, err = os.Stdout.Write(stats)
+	return err
+}
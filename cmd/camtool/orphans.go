@@ -0,0 +1,91 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"camlistore.org/pkg/cmdmain"
+	"camlistore.org/pkg/search"
+)
+
+type orphansCmd struct {
+	server string
+	limit  int
+}
+
+func init() {
+	cmdmain.RegisterCommand("orphans", func(flags *flag.FlagSet) cmdmain.CommandRunner {
+		cmd := new(orphansCmd)
+		flags.StringVar(&cmd.server, "server", "", "Server to search. "+serverFlagHelp)
+		flags.IntVar(&cmd.limit, "limit", 0, "Limit number of results. 0 or negative means no limit; all pages are fetched.")
+		return cmd
+	})
+}
+
+func (c *orphansCmd) Describe() string {
+	return "List indexed files that no permanode references, as cleanup candidates."
+}
+
+func (c *orphansCmd) Usage() {
+	fmt.Fprintf(os.Stderr, "Usage: camtool [globalopts] orphans\n")
+}
+
+func (c *orphansCmd) RunCommand(args []string) error {
+	if len(args) != 0 {
+		return cmdmain.UsageError("doesn't take arguments")
+	}
+	req := &search.SearchQuery{
+		Constraint: &search.Constraint{
+			File: &search.FileConstraint{
+				IsOrphan: true,
+			},
+		},
+		Limit: c.limit,
+	}
+	cl := newClient(c.server)
+	it := search.NewQueryIterator(cl, req)
+	it.DescribeEach = &search.DescribeRequest{}
+
+	var (
+		files      int
+		totalBytes int64
+	)
+	for it.Next() {
+		var (
+			size int64
+			name string
+		)
+		if des := it.Described(); des != nil && des.File != nil {
+			size = des.File.Size
+			name = des.File.FileName
+		}
+		fmt.Fprintf(os.Stdout, "%s\t%d\t%s\n", it.Blob().Blob, size, name)
+		files++
+		totalBytes += size
+		if c.limit > 0 && files >= c.limit {
+			break
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d orphan file(s), %d byte(s) total\n", files, totalBytes)
+	return nil
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/client"
+	"camlistore.org/pkg/cmdmain"
+	"camlistore.org/pkg/context"
+	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/search"
+)
+
+type replayCmd struct {
+	server string
+	at     string
+	diff   string
+}
+
+func init() {
+	cmdmain.RegisterCommand("replay", func(flags *flag.FlagSet) cmdmain.CommandRunner {
+		cmd := new(replayCmd)
+		flags.StringVar(&cmd.server, "server", "", "Server to fetch blobs from. "+serverFlagHelp)
+		flags.StringVar(&cmd.at, "at", "", "Replay claims up to this RFC 3339 time. Empty means now.")
+		flags.StringVar(&cmd.diff, "diff", "", "Show attribute differences between the --at time and this second RFC 3339 time, instead of the attribute map.")
+		return cmd
+	})
+}
+
+func (c *replayCmd) Describe() string {
+	return "Replay a permanode's claims from raw blobs, bypassing the index."
+}
+
+func (c *replayCmd) Usage() {
+	fmt.Fprintf(os.Stderr, "Usage: camtool [globalopts] replay [--at=TIME] [--diff=TIME2] permanodeBlobRef\n")
+}
+
+func (c *replayCmd) Examples() []string {
+	return []string{}
+}
+
+func (c *replayCmd) RunCommand(args []string) error {
+	if len(args) != 1 {
+		return cmdmain.UsageError("requires 1 permanode blobref")
+	}
+	pn, ok := blob.Parse(args[0])
+	if !ok {
+		return cmdmain.UsageError("invalid blobref")
+	}
+	at := time.Now()
+	if c.at != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, c.at); err != nil {
+			return cmdmain.UsageError(fmt.Sprintf("invalid --at time %q: %v", c.at, err))
+		}
+	}
+
+	cl := newClient(c.server)
+	claims, err := fetchClaims(cl, pn)
+	if err != nil {
+		return err
+	}
+	if len(claims) == 0 {
+		return fmt.Errorf("no claims found referencing permanode %v", pn)
+	}
+
+	if c.diff != "" {
+		at2, err := time.Parse(time.RFC3339, c.diff)
+		if err != nil {
+			return cmdmain.UsageError(fmt.Sprintf("invalid --diff time %q: %v", c.diff, err))
+		}
+		printAttrDiff(replayAttrs(claims, at), replayAttrs(claims, at2))
+		return nil
+	}
+
+	attrs := replayAttrs(claims, at)
+	out, err := json.MarshalIndent(attrs, "", "  ")
+	if err != nil {
+		return err
+	}
+	out = append(out, '\n')
+	if _, err := os.Stdout.Write(out); err != nil {
+		return err
+	}
+	if c.at == "" {
+		// Replaying the current state; cross-check the index.
+		checkAgainstDescribe(cl, pn, attrs)
+	}
+	return nil
+}
+
+// fetchClaims enumerates all blobs on the server, sniffing each
+// schema-blob-sized one for a signed claim modifying pn, and returns
+// the claims sorted by claim date. It deliberately never asks the
+// search handler, so its results are ground truth even when the index
+// is corrupt.
+func fetchClaims(cl *client.Client, pn blob.Ref) ([]schema.Claim, error) {
+	ch := make(chan blob.SizedRef, 16)
+	errch := make(chan error, 1)
+	go func() {
+		errch <- cl.SimpleEnumerateBlobs(context.TODO(), ch)
+	}()
+	var claims []schema.Claim
+	for sb := range ch {
+		if sb.Size > schema.MaxSchemaBlobSize {
+			continue
+		}
+		rc, _, err := cl.Fetch(sb.Ref)
+		if err != nil {
+			return nil, fmt.Errorf("fetching %v: %v", sb.Ref, err)
+		}
+		sblob, err := schema.BlobFromReader(sb.Ref, rc)
+		rc.Close()
+		if err != nil {
+			continue // not a schema blob
+		}
+		cla, ok := sblob.AsClaim()
+		if !ok || cla.ModifiedPermanode() != pn {
+			continue
+		}
+		claims = append(claims, cla)
+	}
+	if err := <-errch; err != nil {
+		return nil, fmt.Errorf("enumerating blobs: %v", err)
+	}
+	sort.Sort(claimsByDate(claims))
+	return claims, nil
+}
+
+type claimsByDate []schema.Claim
+
+func (s claimsByDate) Len() int      { return len(s) }
+func (s claimsByDate) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s claimsByDate) Less(i, j int) bool {
+	di, _ := s[i].Blob().ClaimDate()
+	dj, _ := s[j].Blob().ClaimDate()
+	if di.Equal(dj) {
+		return s[i].Blob().BlobRef().String() < s[j].Blob().BlobRef().String()
+	}
+	return di.Before(dj)
+}
+
+// replayAttrs applies the date-ordered claims up to the time at,
+// with the same set/add/del semantics as the indexer, and returns the
+// resulting attribute map.
+func replayAttrs(claims []schema.Claim, at time.Time) map[string][]string {
+	attrs := make(map[string][]string)
+	for _, cla := range claims {
+		d, err := cla.Blob().ClaimDate()
+		if err != nil || d.After(at) {
+			continue
+		}
+		attr, value := cla.Attribute(), cla.Value()
+		switch schema.ClaimType(cla.ClaimType()) {
+		case schema.SetAttributeClaim:
+			attrs[attr] = []string{value}
+		case schema.AddAttributeClaim:
+			attrs[attr] = append(attrs[attr], value)
+		case schema.DelAttributeClaim:
+			if value == "" {
+				delete(attrs, attr)
+				continue
+			}
+			vv := attrs[attr][:0]
+			for _, v := range attrs[attr] {
+				if v != value {
+					vv = append(vv, v)
+				}
+			}
+			attrs[attr] = vv
+		}
+		if len(attrs[attr]) == 0 {
+			delete(attrs, attr)
+		}
+	}
+	return attrs
+}
+
+// printAttrDiff prints the attribute-level differences going from a to b.
+func printAttrDiff(a, b map[string][]string) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, attrs := range []map[string][]string{a, b} {
+		for name := range attrs {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		av, bv := a[name], b[name]
+		if valuesEqual(av, bv) {
+			continue
+		}
+		switch {
+		case len(av) == 0:
+			fmt.Printf("+ %s = %q\n", name, bv)
+		case len(bv) == 0:
+			fmt.Printf("- %s = %q\n", name, av)
+		default:
+			fmt.Printf("~ %s: %q -> %q\n", name, av, bv)
+		}
+	}
+}
+
+func valuesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, v := range a {
+		if b[i] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// checkAgainstDescribe compares the replayed attributes against what
+// the search handler believes, warning about differences, which are
+// potential index bugs. Describe failures are only noted: the whole
+// point of replaying is working without a healthy index.
+func checkAgainstDescribe(cl *client.Client, pn blob.Ref, attrs map[string][]string) {
+	res, err := cl.Describe(&search.DescribeRequest{BlobRef: pn, Depth: 1})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: can't cross-check the index: describe failed: %v\n", err)
+		return
+	}
+	db := res.Meta[pn.String()]
+	if db == nil || db.Permanode == nil {
+		fmt.Fprintf(os.Stderr, "replay: POTENTIAL INDEX BUG: search handler doesn't know %v as a permanode\n", pn)
+		return
+	}
+	for name, want := range attrs {
+		if got := db.Permanode.Attr[name]; !valuesEqual(got, want) {
+			fmt.Fprintf(os.Stderr, "replay: POTENTIAL INDEX BUG: attribute %q = %q per claims, but index says %q\n", name, want, got)
+		}
+	}
+	for name, got := range db.Permanode.Attr {
+		if _, ok := attrs[name]; !ok {
+			fmt.Fprintf(os.Stderr, "replay: POTENTIAL INDEX BUG: index has attribute %q = %q, absent from claims\n", name, got)
+		}
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	"camlistore.org/pkg/cmdmain"
 	"camlistore.org/pkg/search"
@@ -32,14 +33,18 @@ type searchCmd struct {
 	server   string
 	limit    int
 	describe bool
+	timeout  time.Duration
+	retries  int
 }
 
 func init() {
 	cmdmain.RegisterCommand("search", func(flags *flag.FlagSet) cmdmain.CommandRunner {
 		cmd := new(searchCmd)
 		flags.StringVar(&cmd.server, "server", "", "Server to search. "+serverFlagHelp)
-		flags.IntVar(&cmd.limit, "limit", 0, "Limit number of results. 0 is default. Negative means no limit.")
+		flags.IntVar(&cmd.limit, "limit", 0, "Limit number of results. 0 or negative means no limit; all pages are fetched.")
 		flags.BoolVar(&cmd.describe, "describe", false, "Describe results as well.")
+		flags.DurationVar(&cmd.timeout, "timeout", 0, "Optional timeout per search request. 0 means none.")
+		flags.IntVar(&cmd.retries, "retries", 1, "How many times to attempt each search request.")
 		return cmd
 	})
 }
@@ -86,15 +91,30 @@ func (c *searchCmd) RunCommand(args []string) error {
 	} else {
 		req.Expression = q
 	}
+	cl := newClient(c.server)
+	it := search.NewQueryIterator(cl, req)
+	it.Timeout = c.timeout
+	it.MaxTries = c.retries
 	if c.describe {
-		req.Describe = &search.DescribeRequest{}
+		it.DescribeEach = &search.DescribeRequest{}
 	}
-
-	cl := newClient(c.server)
-	res, err := cl.Query(req)
-	if err != nil {
+	res := new(search.SearchResult)
+	meta := make(search.MetaMap)
+	for it.Next() {
+		res.Blobs = append(res.Blobs, it.Blob())
+		if des := it.Described(); des != nil {
+			meta[it.Blob().Blob.String()] = des
+		}
+		if c.limit > 0 && len(res.Blobs) >= c.limit {
+			break
+		}
+	}
+	if err := it.Err(); err != nil {
 		return err
 	}
+	if c.describe {
+		res.Describe = &search.DescribeResponse{Meta: meta}
+	}
 	resj, err := json.MarshalIndent(res, "", "  ")
 	if err != nil {
 		return err
@@ -0,0 +1,196 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"camlistore.org/pkg/cmdmain"
+	"camlistore.org/pkg/osutil"
+)
+
+type serverCmd struct {
+	auth        string
+	makeDefault bool
+}
+
+func init() {
+	cmdmain.RegisterCommand("server", func(flags *flag.FlagSet) cmdmain.CommandRunner {
+		cmd := new(serverCmd)
+		flags.StringVar(&cmd.auth, "auth", "", `Auth scheme and values to use for the added server (ex: "userpass:foo:bar").`)
+		flags.BoolVar(&cmd.makeDefault, "default", false, "Make the added server the default one.")
+		return cmd
+	})
+}
+
+func (c *serverCmd) Describe() string {
+	return "List or edit the server profiles in the client configuration."
+}
+
+func (c *serverCmd) Usage() {
+	fmt.Fprintf(os.Stderr, `Usage: camtool server list
+       camtool server [-auth=...] [-default] add <alias> <url>
+       camtool server remove <alias>
+`)
+}
+
+func (c *serverCmd) RunCommand(args []string) error {
+	if len(args) == 0 {
+		return cmdmain.UsageError("missing mode argument: one of list, add, remove")
+	}
+	switch args[0] {
+	case "list":
+		if len(args) != 1 {
+			return cmdmain.UsageError("list takes no arguments")
+		}
+		return c.runList()
+	case "add":
+		if len(args) != 3 {
+			return cmdmain.UsageError("add takes an alias and a server URL")
+		}
+		return c.runAdd(args[1], args[2])
+	case "remove":
+		if len(args) != 2 {
+			return cmdmain.UsageError("remove takes an alias")
+		}
+		return c.runRemove(args[1])
+	}
+	return cmdmain.UsageError(fmt.Sprintf("unknown mode %q: want one of list, add, remove", args[0]))
+}
+
+// readConfigMap reads the client config file as generic JSON, so
+// editing it preserves any fields this version of camtool does not
+// know about.
+func readConfigMap() (map[string]interface{}, error) {
+	configPath := osutil.UserClientConfigPath()
+	slurp, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read client config %v: %v", configPath, err)
+	}
+	conf := make(map[string]interface{})
+	if err := json.Unmarshal(slurp, &conf); err != nil {
+		return nil, fmt.Errorf("could not parse client config %v: %v", configPath, err)
+	}
+	return conf, nil
+}
+
+func writeConfigMap(conf map[string]interface{}) error {
+	configPath := osutil.UserClientConfigPath()
+	contents, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(configPath, append(contents, '\n'), 0600)
+}
+
+// configServers returns the "servers" section of conf, creating it if
+// necessary. It errors on old-style single-server configs, which the
+// various camli tools convert on use.
+func configServers(conf map[string]interface{}) (map[string]interface{}, error) {
+	v, ok := conf["servers"]
+	if !ok {
+		if _, old := conf["server"]; old {
+			return nil, fmt.Errorf("client config at %v is in the old single-server style; run any camli command to convert it first", osutil.UserClientConfigPath())
+		}
+		servers := make(map[string]interface{})
+		conf["servers"] = servers
+		return servers, nil
+	}
+	servers, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf(`"servers" key in client config is a %T, want an object`, v)
+	}
+	return servers, nil
+}
+
+func serverAliases(servers map[string]interface{}) []string {
+	var aliases []string
+	for alias := range servers {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+func (c *serverCmd) runList() error {
+	conf, err := readConfigMap()
+	if err != nil {
+		return err
+	}
+	servers, err := configServers(conf)
+	if err != nil {
+		return err
+	}
+	for _, alias := range serverAliases(servers) {
+		server, _ := servers[alias].(map[string]interface{})
+		url, _ := server["server"].(string)
+		def := ""
+		if isDefault, _ := server["default"].(bool); isDefault {
+			def = " (default)"
+		}
+		fmt.Printf("%s: %s%s\n", alias, url, def)
+	}
+	return nil
+}
+
+func (c *serverCmd) runAdd(alias, url string) error {
+	conf, err := readConfigMap()
+	if err != nil {
+		return err
+	}
+	servers, err := configServers(conf)
+	if err != nil {
+		return err
+	}
+	server := map[string]interface{}{
+		"server": url,
+	}
+	if c.auth != "" {
+		server["auth"] = c.auth
+	}
+	if c.makeDefault {
+		for _, v := range servers {
+			if other, ok := v.(map[string]interface{}); ok {
+				delete(other, "default")
+			}
+		}
+		server["default"] = true
+	}
+	servers[alias] = server
+	return writeConfigMap(conf)
+}
+
+func (c *serverCmd) runRemove(alias string) error {
+	conf, err := readConfigMap()
+	if err != nil {
+		return err
+	}
+	servers, err := configServers(conf)
+	if err != nil {
+		return err
+	}
+	if _, ok := servers[alias]; !ok {
+		return fmt.Errorf("no server %q in config. Known servers: %v", alias, serverAliases(servers))
+	}
+	delete(servers, alias)
+	return writeConfigMap(conf)
+}
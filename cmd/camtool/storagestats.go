@@ -0,0 +1,88 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"camlistore.org/pkg/cmdmain"
+)
+
+type storageStatsCmd struct {
+	server string
+	json   bool
+}
+
+func init() {
+	cmdmain.RegisterCommand("storage-stats", func(flags *flag.FlagSet) cmdmain.CommandRunner {
+		cmd := new(storageStatsCmd)
+		flags.StringVar(&cmd.server, "server", "", "Server to query. "+serverFlagHelp)
+		flags.BoolVar(&cmd.json, "json", false, "Print the raw JSON response instead of a summary.")
+		return cmd
+	})
+}
+
+func (c *storageStatsCmd) Describe() string {
+	return "Show how data blobs are shared between the indexed files."
+}
+
+func (c *storageStatsCmd) Usage() {
+	fmt.Fprintf(os.Stderr, "Usage: camtool [globalopts] storage-stats\n")
+}
+
+func (c *storageStatsCmd) RunCommand(args []string) error {
+	if len(args) > 0 {
+		return cmdmain.UsageError("doesn't take arguments")
+	}
+	cl := newClient(c.server)
+	res, err := cl.StorageStats()
+	if err != nil {
+		return err
+	}
+	if c.json {
+		resj, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			return err
+		}
+		resj = append(resj, '\n')
+		_, err = os.Stdout.Write(resj)
+		return err
+	}
+	stats := res.Stats
+	if stats == nil {
+		return fmt.Errorf("no stats in response")
+	}
+	fmt.Printf("Referenced blobs: %d\n", stats.Blobs)
+	fmt.Printf("References:       %d\n", stats.Refs)
+	fmt.Printf("Physical bytes:   %d\n", stats.PhysicalBytes)
+	fmt.Printf("Logical bytes:    %d\n", stats.LogicalBytes)
+	fmt.Printf("Saved by dedupe:  %d\n", stats.LogicalBytes-stats.PhysicalBytes)
+	var counts []int
+	for n := range stats.RefHistogram {
+		counts = append(counts, n)
+	}
+	sort.Ints(counts)
+	fmt.Printf("%8s %s\n", "refs", "blobs")
+	for _, n := range counts {
+		fmt.Printf("%8d %d\n", n, stats.RefHistogram[n])
+	}
+	return nil
+}
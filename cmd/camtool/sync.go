@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"log"
@@ -32,6 +33,7 @@ import (
 	"camlistore.org/pkg/client"
 	"camlistore.org/pkg/cmdmain"
 	"camlistore.org/pkg/context"
+	"camlistore.org/pkg/search"
 )
 
 type syncCmd struct {
@@ -41,6 +43,14 @@ type syncCmd struct {
 	srcKeyID  string // GPG public key ID of the source server, if supported.
 	destKeyID string // GPG public key ID of the destination server, if supported.
 
+	query     string // optional search expression limiting which blobs to sync.
+	under     string // optional permanode blobref limiting which blobs to sync.
+	stateFile string // optional file recording already-synced blobrefs.
+
+	reachRoots []blob.Ref        // roots resolved from query/under; nil means sync everything.
+	stateSeen  map[blob.Ref]bool // blobs recorded in stateFile; nil means none.
+	newRefs    []blob.Ref        // reachable blobs seen this run, to append to stateFile.
+
 	loop        bool
 	verbose     bool
 	all         bool
@@ -64,6 +74,9 @@ func init() {
 		flags.BoolVar(&cmd.wipe, "wipe", false, "If dest is an index, drop it and repopulate it from scratch. NOOP for now.")
 		flags.BoolVar(&cmd.all, "all", false, "Discover all sync destinations configured on the source server and run them.")
 		flags.BoolVar(&cmd.removeSrc, "removesrc", false, "Remove each blob from the source after syncing to the destination; for queue processing.")
+		flags.StringVar(&cmd.query, "query", "", "Only sync blobs reachable from the permanodes matching this search expression on the source server. Claims by signers other than the server's owners aren't included.")
+		flags.StringVar(&cmd.under, "under", "", "Only sync blobs reachable from this permanode blobref on the source server. Claims by signers other than the server's owners aren't included.")
+		flags.StringVar(&cmd.stateFile, "statefile", "", "File recording the blobrefs synced by previous --query/--under runs, so repeat runs only consider newly reachable blobs.")
 		// TODO(mpl): maybe move this flag up to the client pkg as an AddFlag, as it can be used by all commands.
 		if debug, _ := strconv.ParseBool(os.Getenv("CAMLI_DEBUG")); debug {
 			flags.BoolVar(&cmd.insecureTLS, "insecure", false, "If set, when using TLS, the server's certificates verification is disabled, and they are not checked against the trustedCerts in the client configuration either.")
@@ -85,6 +98,7 @@ func (c *syncCmd) Examples() []string {
 	return []string{
 		"--all",
 		"--src http://localhost:3179/bs/ --dest http://localhost:3179/index-mem/",
+		"--src http://localhost:3179/ --dest http://small-vps:3179/bs/ --query \"tag:published\" --statefile ~/.camli-published.sync",
 	}
 }
 
@@ -92,6 +106,19 @@ func (c *syncCmd) RunCommand(args []string) error {
 	if c.loop && !c.removeSrc {
 		return cmdmain.UsageError("Can't use --loop without --removesrc")
 	}
+	if c.query != "" && c.under != "" {
+		return cmdmain.UsageError("Can't use --query and --under together")
+	}
+	if c.query != "" || c.under != "" {
+		if c.all {
+			return cmdmain.UsageError("--query/--under can't be used with --all")
+		}
+		if c.removeSrc {
+			return cmdmain.UsageError("--query/--under can't be used with --removesrc")
+		}
+	} else if c.stateFile != "" {
+		return cmdmain.UsageError("--statefile only makes sense with --query or --under")
+	}
 	if c.verbose {
 		c.logger = log.New(cmdmain.Stderr, "", 0) // else nil
 	}
@@ -116,6 +143,12 @@ func (c *syncCmd) RunCommand(args []string) error {
 		return err
 	}
 
+	if c.query != "" || c.under != "" {
+		if err := c.initReachable(ss); err != nil {
+			return err
+		}
+	}
+
 	differentKeyIDs := fmt.Sprintf("WARNING: the source server GPG key ID (%v) and the destination's (%v) differ. All blobs will be synced, but because the indexer at the other side is indexing claims by a different user, you may not see what you expect in that server's web UI, etc.", c.srcKeyID, c.destKeyID)
 
 	if c.dest != "stdout" && !c.oneIsDisk && c.srcKeyID != c.destKeyID { // both blank is ok.
@@ -139,9 +172,116 @@ func (c *syncCmd) RunCommand(args []string) error {
 			break
 		}
 	}
+	if c.stateFile != "" && len(c.newRefs) > 0 {
+		if err := c.saveState(); err != nil {
+			return fmt.Errorf("error writing state file %q: %v", c.stateFile, err)
+		}
+	}
+	return nil
+}
+
+// initReachable resolves --query or --under to the root permanodes
+// whose reachable closure will be synced, and loads the state file of
+// blobs already synced by previous runs.
+func (c *syncCmd) initReachable(ss blobserver.Storage) error {
+	src, ok := ss.(*client.Client)
+	if !ok {
+		return cmdmain.UsageError("--query/--under require an HTTP server --src, not a local disk")
+	}
+	if c.under != "" {
+		br, ok := blob.Parse(c.under)
+		if !ok {
+			return cmdmain.UsageError(fmt.Sprintf("invalid --under blobref %q", c.under))
+		}
+		c.reachRoots = []blob.Ref{br}
+	} else {
+		res, err := src.Query(&search.SearchQuery{
+			Expression: c.query,
+			Limit:      -1,
+		})
+		if err != nil {
+			return fmt.Errorf("--query %q failed: %v", c.query, err)
+		}
+		for _, sb := range res.Blobs {
+			c.reachRoots = append(c.reachRoots, sb.Blob)
+		}
+		if c.verbose {
+			log.Printf("--query %q matched %d root permanodes", c.query, len(c.reachRoots))
+		}
+	}
+	if c.stateFile != "" {
+		return c.loadState()
+	}
 	return nil
 }
 
+// loadState reads the state file of already-synced blobrefs, one per
+// line. A missing file means a first run.
+func (c *syncCmd) loadState() error {
+	c.stateSeen = make(map[blob.Ref]bool)
+	f, err := os.Open(c.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		br, ok := blob.Parse(line)
+		if !ok {
+			return fmt.Errorf("state file %q has malformed blobref %q", c.stateFile, line)
+		}
+		c.stateSeen[br] = true
+	}
+	return sc.Err()
+}
+
+// saveState appends the blobrefs newly synced this run to the state
+// file.
+func (c *syncCmd) saveState() error {
+	f, err := os.OpenFile(c.stateFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	for _, br := range c.newRefs {
+		if _, err := fmt.Fprintln(f, br.String()); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// enumerateReachableBlobs feeds dest the blobs reachable from
+// c.reachRoots on src, as streamed by the server, skipping those
+// recorded in the state file. It closes dest when done.
+func (c *syncCmd) enumerateReachableBlobs(ctx *context.Context, src *client.Client, dest chan<- blob.SizedRef) error {
+	defer close(dest)
+	all := make(chan blob.SizedRef, 100)
+	errch := make(chan error, 1)
+	go func() {
+		errch <- src.StreamReachable(ctx, c.reachRoots, all)
+	}()
+	for sb := range all {
+		if c.stateSeen[sb.Ref] {
+			continue
+		}
+		c.newRefs = append(c.newRefs, sb.Ref)
+		select {
+		case dest <- sb:
+		case <-ctx.Done():
+			return context.ErrCanceled
+		}
+	}
+	return <-errch
+}
+
 // A storageType is one of "src", "dest", or "thirdleg". These match the flag names.
 type storageType string
 
@@ -309,9 +449,10 @@ func enumerateAllBlobs(ctx *context.Context, s blobserver.Storage, destc chan<-
 // src: non-nil source
 // dest: non-nil destination
 // thirdLeg: optional third-leg client. if not nil, anything on src
-//     but not on dest will instead be copied to thirdLeg, instead of
-//     directly to dest. (sneakernet mode, copying to a portable drive
-//     and transporting thirdLeg to dest)
+//
+//	but not on dest will instead be copied to thirdLeg, instead of
+//	directly to dest. (sneakernet mode, copying to a portable drive
+//	and transporting thirdLeg to dest)
 func (c *syncCmd) doPass(src, dest, thirdLeg blobserver.Storage) (stats SyncStats, retErr error) {
 	srcBlobs := make(chan blob.SizedRef, 100)
 	destBlobs := make(chan blob.SizedRef, 100)
@@ -329,7 +470,17 @@ func (c *syncCmd) doPass(src, dest, thirdLeg blobserver.Storage) (stats SyncStat
 		errc <- err
 	}
 
-	go enumerate(srcErr, src, srcBlobs)
+	if len(c.reachRoots) > 0 {
+		go func() {
+			err := c.enumerateReachableBlobs(enumCtx, src.(*client.Client), srcBlobs)
+			if err != nil {
+				enumCtx.Cancel()
+			}
+			srcErr <- err
+		}()
+	} else {
+		go enumerate(srcErr, src, srcBlobs)
+	}
 	checkSourceError := func() {
 		if err := <-srcErr; err != nil && err != context.ErrCanceled {
 			retErr = fmt.Errorf("Enumerate error from source: %v", err)
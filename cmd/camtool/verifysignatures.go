@@ -0,0 +1,316 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/client"
+	"camlistore.org/pkg/cmdmain"
+	"camlistore.org/pkg/context"
+	"camlistore.org/pkg/jsonsign"
+	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/syncutil"
+)
+
+type verifySignaturesCmd struct {
+	server string
+	signer string
+	cursor string
+	json   bool
+}
+
+func init() {
+	cmdmain.RegisterCommand("verify-signatures", func(flags *flag.FlagSet) cmdmain.CommandRunner {
+		cmd := new(verifySignaturesCmd)
+		flags.StringVar(&cmd.server, "server", "", "Server to audit. "+serverFlagHelp)
+		flags.StringVar(&cmd.signer, "signer", "", "Only verify blobs signed by this public key blobref.")
+		flags.StringVar(&cmd.cursor, "cursor", "", "File recording the enumeration position. If it exists, the audit resumes after the blobref it names; it is updated as the audit progresses and removed when the audit completes.")
+		flags.BoolVar(&cmd.json, "json", false, "Print the report as JSON instead of human-readable text.")
+		return cmd
+	})
+}
+
+const (
+	// verifyBatchSize is how many candidate schema blobs are
+	// fetched together before their signatures are checked.
+	verifyBatchSize = 50
+	// verifyMaxFetch bounds the concurrent fetches within a batch.
+	verifyMaxFetch = 10
+)
+
+func (c *verifySignaturesCmd) Describe() string {
+	return "Verify the signatures of all signed schema blobs on a server."
+}
+
+func (c *verifySignaturesCmd) Usage() {
+	fmt.Fprintf(os.Stderr, "Usage: camtool [globalopts] verify-signatures [--signer=REF] [--cursor=FILE] [--json]\n")
+}
+
+func (c *verifySignaturesCmd) Examples() []string {
+	return nil
+}
+
+// sigFailure records one signed blob whose signature did not verify.
+type sigFailure struct {
+	Blob  string `json:"blob"`
+	Error string `json:"error"`
+}
+
+// signerReport summarizes the audit of one signer's blobs.
+type signerReport struct {
+	Signer   string       `json:"signer,omitempty"` // public key blobref; empty if the payload didn't parse
+	KeyID    string       `json:"keyID,omitempty"`  // e.g. "2931A67C26F5ABDA"
+	Verified int          `json:"verified"`
+	Failures []sigFailure `json:"failures,omitempty"`
+}
+
+// verifyReport is the result of a verify-signatures run.
+type verifyReport struct {
+	Checked  int            `json:"checked"` // signed schema blobs examined
+	Verified int            `json:"verified"`
+	Failed   int            `json:"failed"`
+	Signers  []signerReport `json:"signers,omitempty"`
+}
+
+func (c *verifySignaturesCmd) RunCommand(args []string) error {
+	if len(args) > 0 {
+		return cmdmain.UsageError("doesn't take arguments")
+	}
+	var signer blob.Ref
+	if c.signer != "" {
+		var ok bool
+		if signer, ok = blob.Parse(c.signer); !ok {
+			return cmdmain.UsageError("invalid --signer blobref")
+		}
+	}
+	after := ""
+	if c.cursor != "" {
+		if data, err := ioutil.ReadFile(c.cursor); err == nil {
+			after = strings.TrimSpace(string(data))
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("reading cursor file %v: %v", c.cursor, err)
+		}
+	}
+
+	cl := newClient(c.server)
+	keys := &keyCache{
+		src:     cl,
+		keys:    make(map[blob.Ref][]byte),
+		missing: make(map[blob.Ref]bool),
+	}
+	results := make(map[string]*signerReport) // keyed by signer blobref; "" if unknown
+	var report verifyReport
+
+	// verify checks one already-fetched blob, skipping it unless
+	// it's a signed schema blob, and records the outcome.
+	verify := func(br blob.Ref, contents []byte) {
+		if !bytes.HasPrefix(contents, []byte(`{"camliVersion"`)) ||
+			!bytes.Contains(contents, []byte(`"camliSig"`)) {
+			return
+		}
+		vr := jsonsign.NewVerificationRequest(string(contents), keys)
+		ok := vr.Err == nil && vr.ParseSigMap() && vr.ParsePayloadMap()
+		if ok && signer.Valid() && vr.CamliSigner != signer {
+			// Signed by someone else; out of the audit's scope.
+			return
+		}
+		ok = ok && vr.FindAndParsePublicKeyBlob() && vr.VerifySignature()
+		key := ""
+		if vr.CamliSigner.Valid() {
+			key = vr.CamliSigner.String()
+		}
+		sr := results[key]
+		if sr == nil {
+			sr = &signerReport{Signer: key}
+			results[key] = sr
+		}
+		report.Checked++
+		if ok {
+			sr.KeyID = vr.SignerKeyId
+			sr.Verified++
+			report.Verified++
+			return
+		}
+		errStr := "verification failed"
+		if vr.Err != nil {
+			errStr = vr.Err.Error()
+		}
+		sr.Failures = append(sr.Failures, sigFailure{Blob: br.String(), Error: errStr})
+		report.Failed++
+	}
+
+	ch := make(chan blob.SizedRef, 100)
+	errch := make(chan error, 1)
+	go func() {
+		errch <- cl.EnumerateBlobsOpts(context.TODO(), ch, client.EnumerateOpts{After: after})
+	}()
+	var batch []blob.Ref
+	flush := func(last blob.Ref) error {
+		contents, err := fetchBatch(cl, batch)
+		if err != nil {
+			return err
+		}
+		for i, br := range batch {
+			verify(br, contents[i])
+		}
+		batch = batch[:0]
+		if c.cursor != "" {
+			if err := ioutil.WriteFile(c.cursor, []byte(last.String()+"\n"), 0600); err != nil {
+				return fmt.Errorf("writing cursor file %v: %v", c.cursor, err)
+			}
+		}
+		return nil
+	}
+	for sb := range ch {
+		if sb.Size > schema.MaxSchemaBlobSize {
+			continue
+		}
+		batch = append(batch, sb.Ref)
+		if len(batch) == verifyBatchSize {
+			if err := flush(sb.Ref); err != nil {
+				return err
+			}
+		}
+	}
+	if err := <-errch; err != nil {
+		return fmt.Errorf("enumerating blobs: %v", err)
+	}
+	if len(batch) > 0 {
+		if err := flush(batch[len(batch)-1]); err != nil {
+			return err
+		}
+	}
+	if c.cursor != "" {
+		// The audit ran to completion; a rerun should start over.
+		if err := os.Remove(c.cursor); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	var keyRefs []string
+	for key := range results {
+		keyRefs = append(keyRefs, key)
+	}
+	sort.Strings(keyRefs)
+	for _, key := range keyRefs {
+		report.Signers = append(report.Signers, *results[key])
+	}
+	if err := c.printReport(&report); err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("verify-signatures: %d of %d signed blobs failed verification", report.Failed, report.Checked)
+	}
+	return nil
+}
+
+func (c *verifySignaturesCmd) printReport(report *verifyReport) error {
+	if c.json {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		out = append(out, '\n')
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	for _, sr := range report.Signers {
+		label := "(unknown signer)"
+		if sr.Signer != "" {
+			label = "signer " + sr.Signer
+			if sr.KeyID != "" {
+				label += " (key " + sr.KeyID + ")"
+			}
+		}
+		fmt.Printf("%s: %d verified, %d failed\n", label, sr.Verified, len(sr.Failures))
+		for _, f := range sr.Failures {
+			fmt.Printf("  %s: %s\n", f.Blob, f.Error)
+		}
+	}
+	fmt.Printf("checked %d signed blobs: %d verified, %d failed\n",
+		report.Checked, report.Verified, report.Failed)
+	return nil
+}
+
+// fetchBatch fetches the contents of each blob in batch, at most
+// verifyMaxFetch at a time.
+func fetchBatch(cl *client.Client, batch []blob.Ref) ([][]byte, error) {
+	var (
+		gate = syncutil.NewGate(verifyMaxFetch)
+		grp  syncutil.Group
+	)
+	contents := make([][]byte, len(batch))
+	for i := range batch {
+		i := i
+		gate.Start()
+		grp.Go(func() error {
+			defer gate.Done()
+			rc, _, err := cl.Fetch(batch[i])
+			if err != nil {
+				return fmt.Errorf("fetching %v: %v", batch[i], err)
+			}
+			defer rc.Close()
+			contents[i], err = ioutil.ReadAll(rc)
+			return err
+		})
+	}
+	return contents, grp.Err()
+}
+
+// keyCache is a blob.Fetcher for public key blobs that remembers, for
+// the duration of the audit, both the keys it has fetched and the ones
+// the server doesn't have. It reports any fetch failure as
+// os.ErrNotExist so jsonsign attributes it to the missing key blob.
+// It is used only from the sequential verification loop.
+type keyCache struct {
+	src     blob.Fetcher
+	keys    map[blob.Ref][]byte
+	missing map[blob.Ref]bool
+}
+
+func (kc *keyCache) Fetch(br blob.Ref) (io.ReadCloser, uint32, error) {
+	if data, ok := kc.keys[br]; ok {
+		return ioutil.NopCloser(bytes.NewReader(data)), uint32(len(data)), nil
+	}
+	if kc.missing[br] {
+		return nil, 0, os.ErrNotExist
+	}
+	rc, _, err := kc.src.Fetch(br)
+	if err == nil {
+		var data []byte
+		if data, err = ioutil.ReadAll(rc); err == nil {
+			kc.keys[br] = data
+			rc.Close()
+			return ioutil.NopCloser(bytes.NewReader(data)), uint32(len(data)), nil
+		}
+	}
+	if rc != nil {
+		rc.Close()
+	}
+	kc.missing[br] = true
+	return nil, 0, os.ErrNotExist
+}
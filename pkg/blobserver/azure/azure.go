@@ -0,0 +1,92 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package azure registers the "azure" blobserver storage type, storing
+blobs in a Microsoft Azure Blob Storage container.
+
+Example low-level config:
+
+     "/r1/": {
+         "handler": "storage-azure",
+         "handlerArgs": {
+            "account": "myaccount",
+            "access_key": "base64-encoded-access-key",
+            "container": "camliblobs"
+          }
+     },
+
+The optional "hostname" argument replaces the default
+"blob.core.windows.net" service suffix, for sovereign clouds or the
+storage emulator. The optional boolean "skipStartupCheck" skips the
+start-time probe of the container.
+*/
+package azure
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/jsonconfig"
+)
+
+type azureStorage struct {
+	client    *azureClient
+	container string
+}
+
+func (s *azureStorage) String() string {
+	return fmt.Sprintf("\"azure\" blob storage at account %q, container %q", s.client.account, s.container)
+}
+
+func newFromConfig(_ blobserver.Loader, config jsonconfig.Obj) (blobserver.Storage, error) {
+	account := config.RequiredString("account")
+	keyB64 := config.RequiredString("access_key")
+	sto := &azureStorage{
+		container: config.RequiredString("container"),
+	}
+	hostname := config.OptionalString("hostname", "blob.core.windows.net")
+	skipStartupCheck := config.OptionalBool("skipStartupCheck", false)
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(keyB64)
+	if err != nil {
+		return nil, fmt.Errorf("azure: \"access_key\" must be base64-encoded: %v", err)
+	}
+	sto.client = &azureClient{
+		account:  account,
+		key:      key,
+		hostname: hostname,
+	}
+	if !skipStartupCheck {
+		if _, err := sto.client.ListBlobs(sto.container, "", "", 1); err != nil {
+			return nil, fmt.Errorf("azure: error listing container %s: %v", sto.container, err)
+		}
+	}
+	return sto, nil
+}
+
+// key returns the Azure blob name for the blob br.
+func (s *azureStorage) key(br blob.Ref) string {
+	return br.String()
+}
+
+func init() {
+	blobserver.RegisterStorageConstructor("azure", blobserver.StorageConstructor(newFromConfig))
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiVersion is the value of the x-ms-version header sent with every
+// request.
+const apiVersion = "2014-02-14"
+
+// azureClient is a client for the Azure Blob Storage REST API,
+// authenticating with the account's shared key.
+type azureClient struct {
+	account  string
+	key      []byte
+	hostname string // service suffix, e.g. "blob.core.windows.net"
+
+	transport http.RoundTripper // or nil for http.DefaultTransport
+}
+
+// Error is the error type returned for Azure API-level errors. I/O
+// errors are returned as-is.
+type Error struct {
+	Op         string // the failing request, e.g. "PUT /container/name"
+	StatusCode int
+	AzureCode  string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	if e.AzureCode != "" {
+		return fmt.Sprintf("azure: %s: %s: %s", e.Op, e.AzureCode, e.Message)
+	}
+	return fmt.Sprintf("azure: %s: HTTP status %d", e.Op, e.StatusCode)
+}
+
+func (c *azureClient) httpClient() *http.Client {
+	if c.transport == nil {
+		return http.DefaultClient
+	}
+	return &http.Client{Transport: c.transport}
+}
+
+// doReq signs and sends req, returning an *Error for any non-2xx
+// response.
+func (c *azureClient) doReq(req *http.Request) (*http.Response, error) {
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", apiVersion)
+	c.sign(req)
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 != 2 {
+		azErr := &Error{
+			Op:         req.Method + " " + req.URL.Path,
+			StatusCode: res.StatusCode,
+		}
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4<<10))
+		res.Body.Close()
+		xml.Unmarshal(body, azErr)
+		return nil, azErr
+	}
+	return res, nil
+}
+
+// sign sets the Authorization header of req with the shared key
+// signature described at
+// http://msdn.microsoft.com/en-us/library/azure/dd179428.aspx
+func (c *azureClient) sign(req *http.Request) {
+	contentLength := ""
+	if req.Method == "PUT" {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+	var buf bytes.Buffer
+	buf.WriteString(req.Method + "\n")
+	buf.WriteString(req.Header.Get("Content-Encoding") + "\n")
+	buf.WriteString(req.Header.Get("Content-Language") + "\n")
+	buf.WriteString(contentLength + "\n")
+	buf.WriteString(req.Header.Get("Content-MD5") + "\n")
+	buf.WriteString(req.Header.Get("Content-Type") + "\n")
+	buf.WriteString("\n") // Date; x-ms-date is used instead
+	buf.WriteString(req.Header.Get("If-Modified-Since") + "\n")
+	buf.WriteString(req.Header.Get("If-Match") + "\n")
+	buf.WriteString(req.Header.Get("If-None-Match") + "\n")
+	buf.WriteString(req.Header.Get("If-Unmodified-Since") + "\n")
+	buf.WriteString(req.Header.Get("Range") + "\n")
+
+	// Canonicalized headers: the x-ms-* headers, lowercased and
+	// sorted.
+	var msHeaders []string
+	for k, vv := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-ms-") {
+			msHeaders = append(msHeaders, k+":"+strings.Join(vv, ","))
+		}
+	}
+	sort.Strings(msHeaders)
+	for _, h := range msHeaders {
+		buf.WriteString(h + "\n")
+	}
+
+	// Canonicalized resource: the account, the path, and the
+	// sorted query parameters.
+	buf.WriteString("/" + c.account + req.URL.Path)
+	if req.URL.RawQuery != "" {
+		params, _ := url.ParseQuery(req.URL.RawQuery)
+		var names []string
+		for name := range params {
+			names = append(names, strings.ToLower(name))
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			buf.WriteString("\n" + name + ":" + strings.Join(params[name], ","))
+		}
+	}
+
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(buf.String()))
+	req.Header.Set("Authorization", "SharedKey "+c.account+":"+
+		base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+func (c *azureClient) blobURL(container, name string) string {
+	return fmt.Sprintf("https://%s.%s/%s/%s", c.account, c.hostname, container, name)
+}
+
+// PutBlob uploads the size bytes of r as the named block blob.
+func (c *azureClient) PutBlob(container, name string, r io.Reader, size int64) error {
+	req, err := http.NewRequest("PUT", c.blobURL(container, name), ioutil.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	res, err := c.doReq(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// GetBlob returns the content and size of the named blob. It returns
+// os.ErrNotExist if the blob doesn't exist.
+func (c *azureClient) GetBlob(container, name string) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequest("GET", c.blobURL(container, name), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	res, err := c.doReq(req)
+	if azErr, ok := err.(*Error); ok && azErr.StatusCode == http.StatusNotFound {
+		return nil, 0, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return res.Body, res.ContentLength, nil
+}
+
+// StatBlob returns the size of the named blob, or os.ErrNotExist if
+// it doesn't exist.
+func (c *azureClient) StatBlob(container, name string) (int64, error) {
+	req, err := http.NewRequest("HEAD", c.blobURL(container, name), nil)
+	if err != nil {
+		return 0, err
+	}
+	res, err := c.doReq(req)
+	if azErr, ok := err.(*Error); ok && azErr.StatusCode == http.StatusNotFound {
+		return 0, os.ErrNotExist
+	}
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	return res.ContentLength, nil
+}
+
+// DeleteBlob deletes the named blob. Deleting a blob that doesn't
+// exist is not an error.
+func (c *azureClient) DeleteBlob(container, name string) error {
+	req, err := http.NewRequest("DELETE", c.blobURL(container, name), nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.doReq(req)
+	if azErr, ok := err.(*Error); ok && azErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// listBlobsResult is the subset of the List Blobs response body that
+// the client uses.
+type listBlobsResult struct {
+	Blobs []struct {
+		Name       string
+		Properties struct {
+			ContentLength int64 `xml:"Content-Length"`
+		}
+	} `xml:"Blobs>Blob"`
+	NextMarker string
+}
+
+// ListBlobs lists up to maxResults blobs of the container, in
+// lexical order, optionally restricted to the given name prefix.
+// A non-empty marker, from a previous response's NextMarker,
+// continues a listing; an empty NextMarker in the result means the
+// listing is complete.
+func (c *azureClient) ListBlobs(container, prefix, marker string, maxResults int) (*listBlobsResult, error) {
+	query := url.Values{
+		"restype":    {"container"},
+		"comp":       {"list"},
+		"maxresults": {strconv.Itoa(maxResults)},
+	}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if marker != "" {
+		query.Set("marker", marker)
+	}
+	listURL := fmt.Sprintf("https://%s.%s/%s?%s", c.account, c.hostname, container, query.Encode())
+	req, err := http.NewRequest("GET", listURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.doReq(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	result := new(listBlobsResult)
+	if err := xml.NewDecoder(res.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("azure: decoding blob listing of container %s: %v", container, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/context"
+)
+
+var _ blobserver.MaxEnumerateConfig = (*azureStorage)(nil)
+
+func (sto *azureStorage) MaxEnumerate() int { return 1000 }
+
+func (sto *azureStorage) EnumerateBlobs(ctx *context.Context, dest chan<- blob.SizedRef, after string, limit int) error {
+	defer close(dest)
+	// Azure listing markers are opaque continuation tokens, not
+	// names, so a fresh enumeration can't start mid-container:
+	// list from the beginning and skip names up through after.
+	marker := ""
+	sent := 0
+	for {
+		res, err := sto.client.ListBlobs(sto.container, "", marker, sto.MaxEnumerate())
+		if err != nil {
+			return err
+		}
+		for _, obj := range res.Blobs {
+			if obj.Name <= after {
+				continue
+			}
+			br, ok := blob.Parse(obj.Name)
+			if !ok {
+				continue
+			}
+			select {
+			case dest <- blob.SizedRef{Ref: br, Size: uint32(obj.Properties.ContentLength)}:
+			case <-ctx.Done():
+				return context.ErrCanceled
+			}
+			if sent++; sent == limit {
+				return nil
+			}
+		}
+		if res.NextMarker == "" {
+			return nil
+		}
+		marker = res.NextMarker
+	}
+}
@@ -0,0 +1,36 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"bytes"
+	"io"
+
+	"camlistore.org/pkg/blob"
+)
+
+func (sto *azureStorage) ReceiveBlob(b blob.Ref, source io.Reader) (sr blob.SizedRef, err error) {
+	var buf bytes.Buffer
+	size, err := io.Copy(&buf, source)
+	if err != nil {
+		return sr, err
+	}
+	if err := sto.client.PutBlob(sto.container, sto.key(b), &buf, size); err != nil {
+		return sr, err
+	}
+	return blob.SizedRef{Ref: b, Size: uint32(size)}, nil
+}
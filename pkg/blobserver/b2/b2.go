@@ -0,0 +1,83 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package b2 registers the "b2" blobserver storage type, storing blobs
+in a Backblaze B2 bucket.
+
+Example low-level config:
+
+     "/r1/": {
+         "handler": "storage-b2",
+         "handlerArgs": {
+            "account_id": "...",
+            "application_key": "...",
+            "bucket": "camliblobs"
+          }
+     },
+
+The bucket is named in the config but addressed by its B2 bucket id,
+resolved once at start; a missing bucket is an error at that point,
+not at first use.
+*/
+package b2
+
+import (
+	"fmt"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/jsonconfig"
+)
+
+type b2Storage struct {
+	client   *b2Client
+	bucket   string // bucket name, for messages
+	bucketID string
+}
+
+func (s *b2Storage) String() string {
+	return fmt.Sprintf("\"b2\" blob storage at bucket %q", s.bucket)
+}
+
+func newFromConfig(_ blobserver.Loader, config jsonconfig.Obj) (blobserver.Storage, error) {
+	client := &b2Client{
+		accountID: config.RequiredString("account_id"),
+		appKey:    config.RequiredString("application_key"),
+	}
+	sto := &b2Storage{
+		client: client,
+		bucket: config.RequiredString("bucket"),
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	bucketID, err := client.BucketID(sto.bucket)
+	if err != nil {
+		return nil, fmt.Errorf("b2: error resolving bucket %q: %v", sto.bucket, err)
+	}
+	sto.bucketID = bucketID
+	return sto, nil
+}
+
+// key returns the B2 file name for the blob br.
+func (s *b2Storage) key(br blob.Ref) string {
+	return br.String()
+}
+
+func init() {
+	blobserver.RegisterStorageConstructor("b2", blobserver.StorageConstructor(newFromConfig))
+}
@@ -0,0 +1,315 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package b2
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// authAccountURL is where b2_authorize_account lives; every other
+// call goes to the API URL that it returns.
+const authAccountURL = "https://api.backblazeb2.com/b2api/v1/b2_authorize_account"
+
+// b2Client is a client for the Backblaze B2 API, re-authorizing
+// transparently when its authorization token expires.
+type b2Client struct {
+	accountID string
+	appKey    string
+
+	transport http.RoundTripper // or nil for http.DefaultTransport
+
+	mu   sync.Mutex
+	auth *b2Auth // non-nil once authorized
+}
+
+type b2Auth struct {
+	Token       string `json:"authorizationToken"`
+	APIURL      string `json:"apiUrl"`
+	DownloadURL string `json:"downloadUrl"`
+}
+
+// Error is the error type returned for B2 API-level errors. I/O
+// errors are returned as-is.
+type Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("b2: %s: %s (HTTP status %d)", e.Code, e.Message, e.Status)
+}
+
+func (c *b2Client) httpClient() *http.Client {
+	if c.transport == nil {
+		return http.DefaultClient
+	}
+	return &http.Client{Transport: c.transport}
+}
+
+// errorFrom decodes the error document of a non-2xx res.
+func errorFrom(res *http.Response) error {
+	defer res.Body.Close()
+	b2err := &Error{Status: res.StatusCode}
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4<<10))
+	if err := json.Unmarshal(body, b2err); err != nil {
+		b2err.Code = "unknown"
+		b2err.Message = string(body)
+	}
+	return b2err
+}
+
+// authorize fetches a fresh authorization token and API URLs.
+func (c *b2Client) authorize() (*b2Auth, error) {
+	req, err := http.NewRequest("GET", authAccountURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Basic "+
+		base64.StdEncoding.EncodeToString([]byte(c.accountID+":"+c.appKey)))
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errorFrom(res)
+	}
+	defer res.Body.Close()
+	auth := new(b2Auth)
+	if err := json.NewDecoder(res.Body).Decode(auth); err != nil {
+		return nil, fmt.Errorf("b2: decoding authorization response: %v", err)
+	}
+	c.mu.Lock()
+	c.auth = auth
+	c.mu.Unlock()
+	return auth, nil
+}
+
+func (c *b2Client) currentAuth() (*b2Auth, error) {
+	c.mu.Lock()
+	auth := c.auth
+	c.mu.Unlock()
+	if auth != nil {
+		return auth, nil
+	}
+	return c.authorize()
+}
+
+// expiredToken reports whether err means the authorization token is
+// no longer valid and the call should be retried after authorize.
+func expiredToken(err error) bool {
+	b2err, ok := err.(*Error)
+	return ok && b2err.Status == http.StatusUnauthorized &&
+		(b2err.Code == "expired_auth_token" || b2err.Code == "bad_auth_token")
+}
+
+// apiCall makes the named b2api/v1 call with the JSON-encoded reqBody,
+// decoding the response into resBody, and re-authorizing once if the
+// token has expired.
+func (c *b2Client) apiCall(op string, reqBody, resBody interface{}) error {
+	encoded, err := json.Marshal(reqBody)
+	if err != nil {
+		return err
+	}
+	retried := false
+	for {
+		auth, err := c.currentAuth()
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest("POST", auth.APIURL+"/b2api/v1/"+op, bytes.NewReader(encoded))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", auth.Token)
+		res, err := c.httpClient().Do(req)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusOK {
+			err := errorFrom(res)
+			if expiredToken(err) && !retried {
+				retried = true
+				if _, err := c.authorize(); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+		defer res.Body.Close()
+		return json.NewDecoder(res.Body).Decode(resBody)
+	}
+}
+
+// BucketID resolves a bucket name to its B2 bucket id.
+func (c *b2Client) BucketID(bucket string) (string, error) {
+	var res struct {
+		Buckets []struct {
+			ID   string `json:"bucketId"`
+			Name string `json:"bucketName"`
+		} `json:"buckets"`
+	}
+	err := c.apiCall("b2_list_buckets", map[string]string{"accountId": c.accountID}, &res)
+	if err != nil {
+		return "", err
+	}
+	for _, b := range res.Buckets {
+		if b.Name == bucket {
+			return b.ID, nil
+		}
+	}
+	return "", fmt.Errorf("bucket %q doesn't exist", bucket)
+}
+
+// b2File is one file of a listing.
+type b2File struct {
+	Name   string `json:"fileName"`
+	ID     string `json:"fileId"`
+	Size   int64  `json:"contentLength"`
+	Action string `json:"action"`
+}
+
+type listFilesResult struct {
+	Files    []b2File `json:"files"`
+	NextName string   `json:"nextFileName"`
+}
+
+// ListFileNames lists up to maxCount file names of the bucket in
+// lexical order, starting at startName (inclusive). A non-empty
+// NextName in the result continues the listing.
+func (c *b2Client) ListFileNames(bucketID, startName string, maxCount int) (*listFilesResult, error) {
+	req := map[string]interface{}{
+		"bucketId":     bucketID,
+		"maxFileCount": maxCount,
+	}
+	if startName != "" {
+		req["startFileName"] = startName
+	}
+	res := new(listFilesResult)
+	if err := c.apiCall("b2_list_file_names", req, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// statFile returns the listing entry for the exactly named file, or
+// os.ErrNotExist.
+func (c *b2Client) statFile(bucketID, name string) (*b2File, error) {
+	res, err := c.ListFileNames(bucketID, name, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Files) == 0 || res.Files[0].Name != name {
+		return nil, os.ErrNotExist
+	}
+	return &res.Files[0], nil
+}
+
+// Stat returns the size of the named file, or os.ErrNotExist.
+func (c *b2Client) Stat(bucketID, name string) (int64, error) {
+	f, err := c.statFile(bucketID, name)
+	if err != nil {
+		return 0, err
+	}
+	return f.Size, nil
+}
+
+// Upload stores the contents as the named file.
+func (c *b2Client) Upload(bucketID, name string, contents []byte) error {
+	var upload struct {
+		URL   string `json:"uploadUrl"`
+		Token string `json:"authorizationToken"`
+	}
+	err := c.apiCall("b2_get_upload_url", map[string]string{"bucketId": bucketID}, &upload)
+	if err != nil {
+		return err
+	}
+	sha1Hex := fmt.Sprintf("%x", sha1.Sum(contents))
+	req, err := http.NewRequest("POST", upload.URL, bytes.NewReader(contents))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", upload.Token)
+	req.Header.Set("X-Bz-File-Name", url.QueryEscape(name))
+	req.Header.Set("Content-Type", "b2/x-auto")
+	req.Header.Set("Content-Length", strconv.Itoa(len(contents)))
+	req.Header.Set("X-Bz-Content-Sha1", sha1Hex)
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return errorFrom(res)
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Download returns the content and size of the named file. It
+// returns os.ErrNotExist if the file doesn't exist.
+func (c *b2Client) Download(bucket, name string) (io.ReadCloser, int64, error) {
+	auth, err := c.currentAuth()
+	if err != nil {
+		return nil, 0, err
+	}
+	req, err := http.NewRequest("GET", auth.DownloadURL+"/file/"+bucket+"/"+name, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Authorization", auth.Token)
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if res.StatusCode != http.StatusOK {
+		err := errorFrom(res)
+		if b2err, ok := err.(*Error); ok && b2err.Status == http.StatusNotFound {
+			return nil, 0, os.ErrNotExist
+		}
+		return nil, 0, err
+	}
+	return res.Body, res.ContentLength, nil
+}
+
+// Delete deletes the named file. Deleting a file that doesn't exist
+// is not an error.
+func (c *b2Client) Delete(bucketID, name string) error {
+	f, err := c.statFile(bucketID, name)
+	if err == os.ErrNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var res struct{}
+	return c.apiCall("b2_delete_file_version", map[string]string{
+		"fileName": f.Name,
+		"fileId":   f.ID,
+	}, &res)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package b2
+
+import (
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/context"
+)
+
+var _ blobserver.MaxEnumerateConfig = (*b2Storage)(nil)
+
+func (sto *b2Storage) MaxEnumerate() int { return 1000 }
+
+func (sto *b2Storage) EnumerateBlobs(ctx *context.Context, dest chan<- blob.SizedRef, after string, limit int) error {
+	defer close(dest)
+	// B2 listings start at an inclusive file name, so start at
+	// after itself and skip it if present.
+	startName := after
+	sent := 0
+	for {
+		res, err := sto.client.ListFileNames(sto.bucketID, startName, sto.MaxEnumerate())
+		if err != nil {
+			return err
+		}
+		for _, f := range res.Files {
+			if f.Name <= after {
+				continue
+			}
+			br, ok := blob.Parse(f.Name)
+			if !ok {
+				continue
+			}
+			select {
+			case dest <- blob.SizedRef{Ref: br, Size: uint32(f.Size)}:
+			case <-ctx.Done():
+				return context.ErrCanceled
+			}
+			if sent++; sent == limit {
+				return nil
+			}
+		}
+		if res.NextName == "" {
+			return nil
+		}
+		startName = res.NextName
+	}
+}
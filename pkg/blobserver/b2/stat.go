@@ -0,0 +1,49 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package b2
+
+import (
+	"fmt"
+	"os"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/syncutil"
+)
+
+var statGate = syncutil.NewGate(10) // arbitrary
+
+func (sto *b2Storage) StatBlobs(dest chan<- blob.SizedRef, blobs []blob.Ref) error {
+	var wg syncutil.Group
+	for _, br := range blobs {
+		br := br
+		statGate.Start()
+		wg.Go(func() error {
+			defer statGate.Done()
+
+			size, err := sto.client.Stat(sto.bucketID, sto.key(br))
+			if err == nil {
+				dest <- blob.SizedRef{Ref: br, Size: uint32(size)}
+				return nil
+			}
+			if err == os.ErrNotExist {
+				return nil
+			}
+			return fmt.Errorf("error statting %v: %v", br, err)
+		})
+	}
+	return wg.Err()
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package blobpacked registers the "blobpacked" blobserver storage
+type, storing blobs initially as one file per blob on local disk
+("loose" blobs) and then repacking the chunks of each complete file
+into a single zip archive once the file's schema blob arrives.
+
+Example low-level config:
+
+     "/bs/": {
+         "handler": "storage-blobpacked",
+         "handlerArgs": {
+            "smallBlobs": "/var/camlistore/blobs/loose",
+            "largeBlobs": "/var/camlistore/blobs/packed",
+            "metaIndex": {
+               "type": "kv",
+               "file": "/var/camlistore/blobs/packed/packindex.kv"
+            }
+          }
+     },
+
+When a "file" schema blob is received and all of the chunks it
+references are present and loose, the chunks and the schema blob are
+copied into one zip under largeBlobs, their pack locations are
+recorded in the meta index, and the loose copies are deleted. Blobs
+that never become part of a complete file (permanodes, claims, file
+schemas with indirect parts) stay loose in smallBlobs.
+*/
+package blobpacked
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/blobserver/localdisk"
+	"camlistore.org/pkg/jsonconfig"
+	"camlistore.org/pkg/sorted"
+)
+
+type storage struct {
+	small    blobserver.Storage // loose blobs, one file per blob
+	largeDir string             // directory of zip pack files
+	meta     sorted.KeyValue    // "b:<blobref>" -> "<size> <packfile>"
+
+	packMu sync.Mutex // serializes pack file creation
+}
+
+func (sto *storage) String() string {
+	return fmt.Sprintf("\"blobpacked\" blob storage, pack files in %q", sto.largeDir)
+}
+
+// blobMetaKey returns the meta index key recording where br is
+// packed.
+func blobMetaKey(br blob.Ref) string { return "b:" + br.String() }
+
+// parseMetaValue parses a meta index row value, "<size> <packfile>".
+func parseMetaValue(v string) (size uint32, packFile string, err error) {
+	f := strings.SplitN(v, " ", 2)
+	if len(f) != 2 {
+		return 0, "", fmt.Errorf("blobpacked: malformed meta row value %q", v)
+	}
+	n, err := strconv.ParseUint(f[0], 10, 32)
+	if err != nil {
+		return 0, "", fmt.Errorf("blobpacked: malformed size in meta row value %q", v)
+	}
+	return uint32(n), f[1], nil
+}
+
+// findPacked returns the size and pack file of br if it's packed, or
+// sorted.ErrNotFound if it's not in the meta index.
+func (sto *storage) findPacked(br blob.Ref) (size uint32, packFile string, err error) {
+	v, err := sto.meta.Get(blobMetaKey(br))
+	if err != nil {
+		return 0, "", err
+	}
+	return parseMetaValue(v)
+}
+
+func newFromConfig(_ blobserver.Loader, config jsonconfig.Obj) (blobserver.Storage, error) {
+	smallDir := config.RequiredString("smallBlobs")
+	largeDir := config.RequiredString("largeBlobs")
+	metaConf := config.RequiredObject("metaIndex")
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(smallDir, 0700); err != nil {
+		return nil, fmt.Errorf("blobpacked: creating smallBlobs directory: %v", err)
+	}
+	if err := os.MkdirAll(largeDir, 0700); err != nil {
+		return nil, fmt.Errorf("blobpacked: creating largeBlobs directory: %v", err)
+	}
+	small, err := localdisk.New(smallDir)
+	if err != nil {
+		return nil, fmt.Errorf("blobpacked: opening smallBlobs storage: %v", err)
+	}
+	meta, err := sorted.NewKeyValue(metaConf)
+	if err != nil {
+		return nil, fmt.Errorf("blobpacked: opening meta index: %v", err)
+	}
+	return &storage{
+		small:    small,
+		largeDir: largeDir,
+		meta:     meta,
+	}, nil
+}
+
+func init() {
+	blobserver.RegisterStorageConstructor("blobpacked", blobserver.StorageConstructor(newFromConfig))
+}
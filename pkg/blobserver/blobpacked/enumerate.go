@@ -0,0 +1,96 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blobpacked
+
+import (
+	"strings"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/context"
+)
+
+// EnumerateBlobs merges the loose blobs in the small storage with the
+// packed blobs recorded in the meta index; both are already sorted by
+// blobref.
+func (sto *storage) EnumerateBlobs(ctx *context.Context, dest chan<- blob.SizedRef, after string, limit int) error {
+	defer close(dest)
+
+	looseCh := make(chan blob.SizedRef, 8)
+	looseErrc := make(chan error, 1)
+	go func() {
+		looseErrc <- sto.small.EnumerateBlobs(ctx, looseCh, after, limit)
+	}()
+	loose := &blob.ChanPeeker{Ch: looseCh}
+
+	// The "b:" rows sort like the blobrefs they index; "b;" is the
+	// key just past them.
+	it := sto.meta.Find("b:"+after+"\x00", "b;")
+	packedOK := it.Next()
+
+	var retErr error
+	sent := 0
+	canceled := false
+	for sent < limit && !canceled {
+		var sb blob.SizedRef
+		looseSB, haveLoose := loose.Peek()
+		packedRef := ""
+		if packedOK {
+			packedRef = strings.TrimPrefix(it.Key(), "b:")
+		}
+		switch {
+		case !packedOK && !haveLoose:
+			// Both sources done.
+		case packedOK && (!haveLoose || packedRef <= looseSB.Ref.String()):
+			br, ok := blob.Parse(packedRef)
+			size, _, err := parseMetaValue(it.Value())
+			if !ok || err != nil {
+				retErr = err
+				break
+			}
+			sb = blob.SizedRef{Ref: br, Size: size}
+			if haveLoose && packedRef == looseSB.Ref.String() {
+				// Shouldn't happen, but don't send it twice.
+				loose.Take()
+			}
+			packedOK = it.Next()
+		default:
+			sb = looseSB
+			loose.Take()
+		}
+		if !sb.Ref.Valid() {
+			break
+		}
+		select {
+		case dest <- sb:
+			sent++
+		case <-ctx.Done():
+			canceled = true
+		}
+	}
+
+	if err := it.Close(); err != nil && retErr == nil {
+		retErr = err
+	}
+	go loose.ConsumeAll()
+	if err := <-looseErrc; err != nil && retErr == nil {
+		retErr = err
+	}
+	if canceled {
+		retErr = context.ErrCanceled
+	}
+	return retErr
+}
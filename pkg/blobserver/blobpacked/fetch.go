@@ -0,0 +1,70 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blobpacked
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/sorted"
+)
+
+func (sto *storage) Fetch(br blob.Ref) (io.ReadCloser, uint32, error) {
+	size, packName, err := sto.findPacked(br)
+	if err == sorted.ErrNotFound {
+		return sto.small.Fetch(br)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	zr, err := zip.OpenReader(filepath.Join(sto.largeDir, packName))
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, f := range zr.File {
+		if f.Name != br.String() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			zr.Close()
+			return nil, 0, err
+		}
+		return &packedReader{rc: rc, zr: zr}, size, nil
+	}
+	zr.Close()
+	return nil, 0, fmt.Errorf("blobpacked: blob %v missing from pack file %v", br, packName)
+}
+
+// packedReader closes the zip archive along with the member's reader.
+type packedReader struct {
+	rc io.ReadCloser
+	zr *zip.ReadCloser
+}
+
+func (pr *packedReader) Read(p []byte) (int, error) { return pr.rc.Read(p) }
+
+func (pr *packedReader) Close() error {
+	err := pr.rc.Close()
+	if err2 := pr.zr.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blobpacked
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/schema"
+)
+
+// maxPackSize is the maximum total chunk size of a file eligible for
+// repacking into a single zip.
+const maxPackSize = 1 << 30
+
+// maybePack repacks the file described by the "file" schema blob b if
+// all of its chunks are direct references, still loose, and small
+// enough in total. Packing failures are logged, not fatal: the blobs
+// are all safely in the small storage regardless.
+func (sto *storage) maybePack(b *schema.Blob) {
+	sto.packMu.Lock()
+	defer sto.packMu.Unlock()
+
+	refs := []blob.Ref{b.BlobRef()}
+	seen := map[blob.Ref]bool{b.BlobRef(): true}
+	var total uint64
+	for _, part := range b.ByteParts() {
+		if part.BytesRef.Valid() {
+			// Indirect parts; leave the file loose.
+			return
+		}
+		if !part.BlobRef.Valid() {
+			continue // a zero-filled part
+		}
+		if !seen[part.BlobRef] {
+			seen[part.BlobRef] = true
+			refs = append(refs, part.BlobRef)
+		}
+		total += part.Size
+	}
+	if len(refs) == 1 || total > maxPackSize {
+		return
+	}
+	for _, br := range refs {
+		if _, _, err := sto.findPacked(br); err == nil {
+			// Already packed elsewhere; don't pack it twice.
+			return
+		}
+	}
+	if err := sto.pack(b.BlobRef(), refs); err != nil {
+		log.Printf("blobpacked: packing file %v: %v", b.BlobRef(), err)
+	}
+}
+
+// pack writes refs (the file's schema blob and its chunks, all
+// present in the small storage) to a new zip in largeDir, records
+// their locations in the meta index, and deletes the loose copies.
+func (sto *storage) pack(fileRef blob.Ref, refs []blob.Ref) error {
+	packName := "pack-" + fileRef.Digest() + ".zip"
+	finalPath := filepath.Join(sto.largeDir, packName)
+	if _, err := os.Stat(finalPath); err == nil {
+		return fmt.Errorf("pack file %v already exists", packName)
+	}
+
+	tmp, err := ioutil.TempFile(sto.largeDir, "tmp-pack-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // a no-op once it's renamed into place
+
+	zw := zip.NewWriter(tmp)
+	sizes := make(map[blob.Ref]uint32)
+	for _, br := range refs {
+		rc, size, err := sto.small.Fetch(br)
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("chunk %v is not loose: %v", br, err)
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   br.String(),
+			Method: zip.Store,
+		})
+		if err != nil {
+			rc.Close()
+			tmp.Close()
+			return err
+		}
+		_, err = io.Copy(w, rc)
+		rc.Close()
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		sizes[br] = size
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), finalPath); err != nil {
+		return err
+	}
+
+	bt := sto.meta.BeginBatch()
+	for _, br := range refs {
+		bt.Set(blobMetaKey(br), fmt.Sprintf("%d %s", sizes[br], packName))
+	}
+	if err := sto.meta.CommitBatch(bt); err != nil {
+		// The pack file exists but isn't indexed; the loose
+		// copies are still authoritative, so don't delete them.
+		os.Remove(finalPath)
+		return fmt.Errorf("writing meta index: %v", err)
+	}
+	return sto.small.RemoveBlobs(refs)
+}
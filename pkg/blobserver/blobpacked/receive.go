@@ -0,0 +1,59 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blobpacked
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/sorted"
+)
+
+// schemaSniffLimit is the maximum size of a received blob that's
+// parsed as a potential "file" schema blob.
+const schemaSniffLimit = 1 << 20
+
+func (sto *storage) ReceiveBlob(br blob.Ref, source io.Reader) (blob.SizedRef, error) {
+	size, _, err := sto.findPacked(br)
+	if err == nil {
+		// A duplicate of an already-packed blob.
+		if _, err := io.Copy(ioutil.Discard, source); err != nil {
+			return blob.SizedRef{}, err
+		}
+		return blob.SizedRef{Ref: br, Size: size}, nil
+	}
+	if err != sorted.ErrNotFound {
+		return blob.SizedRef{}, err
+	}
+	data, err := ioutil.ReadAll(source)
+	if err != nil {
+		return blob.SizedRef{}, err
+	}
+	sb, err := sto.small.ReceiveBlob(br, bytes.NewReader(data))
+	if err != nil {
+		return sb, err
+	}
+	if len(data) > 0 && data[0] == '{' && len(data) <= schemaSniffLimit {
+		if b, err := schema.BlobFromReader(br, bytes.NewReader(data)); err == nil && b.Type() == "file" {
+			sto.maybePack(b)
+		}
+	}
+	return sb, nil
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blobpacked
+
+import (
+	"fmt"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/sorted"
+)
+
+func (sto *storage) RemoveBlobs(blobs []blob.Ref) error {
+	var loose []blob.Ref
+	for _, br := range blobs {
+		_, packName, err := sto.findPacked(br)
+		switch err {
+		case nil:
+			// Deleting one blob from a shared zip would
+			// require rewriting the pack.
+			return fmt.Errorf("blobpacked: cannot remove blob %v: packed in %v", br, packName)
+		case sorted.ErrNotFound:
+			loose = append(loose, br)
+		default:
+			return err
+		}
+	}
+	return sto.small.RemoveBlobs(loose)
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blobpacked
+
+import (
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/sorted"
+)
+
+func (sto *storage) StatBlobs(dest chan<- blob.SizedRef, blobs []blob.Ref) error {
+	var loose []blob.Ref
+	for _, br := range blobs {
+		size, _, err := sto.findPacked(br)
+		switch err {
+		case nil:
+			dest <- blob.SizedRef{Ref: br, Size: size}
+		case sorted.ErrNotFound:
+			loose = append(loose, br)
+		default:
+			return err
+		}
+	}
+	if len(loose) == 0 {
+		return nil
+	}
+	return sto.small.StatBlobs(dest, loose)
+}
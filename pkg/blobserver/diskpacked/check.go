@@ -0,0 +1,136 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diskpacked
+
+import (
+	"fmt"
+	"log"
+)
+
+const (
+	// spotCheckStride is how many index records go by between
+	// header spot-checks during the startup consistency check.
+	spotCheckStride = 4096
+
+	// maxCheckErrors caps how many discrepancies are kept for the
+	// status page. All of them are logged regardless.
+	maxCheckErrors = 20
+)
+
+// checkResult summarizes the startup consistency check of the meta
+// index against the pack files on disk. It's reported on the server's
+// status page.
+type checkResult struct {
+	Packs       int      `json:"packs"`
+	Records     int      `json:"indexRecords"`
+	SpotChecked int      `json:"spotChecked"`
+	Errors      []string `json:"errors,omitempty"`
+	Repaired    bool     `json:"repaired,omitempty"`
+
+	nerr int // total errors found, including ones dropped from Errors
+}
+
+func (r *checkResult) errorf(format string, args ...interface{}) {
+	s := fmt.Sprintf(format, args...)
+	log.Printf("diskpacked: consistency: %s", s)
+	r.nerr++
+	if len(r.Errors) < maxCheckErrors {
+		r.Errors = append(r.Errors, s)
+	}
+}
+
+// startupCheck is a fast sanity check of the meta index against the
+// pack files, catching things like packs truncated by a bad restore.
+// It verifies that no index record points past the end of its pack
+// file, and spot-checks the blob header of a sample of records. It
+// doesn't read blob contents; use Reindex (or the "repair" config
+// option) for a full rescan.
+// s.mu must be held by the caller.
+func (s *storage) startupCheck() *checkResult {
+	res := &checkResult{Packs: len(s.fds)}
+	packSize := make([]int64, len(s.fds))
+	for i, f := range s.fds {
+		fi, err := f.Stat()
+		if err != nil {
+			res.errorf("stat of pack %s: %v", s.filename(i), err)
+			continue
+		}
+		packSize[i] = fi.Size()
+	}
+	maxEnd := make([]int64, len(s.fds))
+	it := s.index.Find("", "")
+	for it.Next() {
+		res.Records++
+		m, ok := parseBlobMeta(it.Value())
+		if !ok {
+			res.errorf("%s: bad blob metadata %q", it.Key(), it.Value())
+			continue
+		}
+		if m.file >= len(packSize) {
+			res.errorf("%s: meta references pack %d; only %d pack file(s) exist", it.Key(), m.file, len(packSize))
+			continue
+		}
+		if end := m.offset + int64(m.size); end > maxEnd[m.file] {
+			maxEnd[m.file] = end
+		}
+		if res.Records%spotCheckStride == 1 {
+			if err := s.checkHeader(it.Key(), m); err != nil {
+				res.errorf("%v", err)
+			}
+			res.SpotChecked++
+		}
+	}
+	if err := it.Close(); err != nil {
+		res.errorf("closing index iterator: %v", err)
+	}
+	for i, end := range maxEnd {
+		if end > packSize[i] {
+			res.errorf("pack %s is %d bytes, but the meta index references data up to offset %d (truncated pack?)",
+				s.filename(i), packSize[i], end)
+		}
+	}
+	if res.nerr > 0 {
+		log.Printf("diskpacked: consistency check of %s found %d problem(s); re-run with \"repair\": true (or camtool reindex-diskpacked) to rebuild the index",
+			s.root, res.nerr)
+	}
+	return res
+}
+
+// checkHeader verifies that the pack file contains the expected
+// "[<digest> <size>]" header just before the blob data that the index
+// record m points at.
+func (s *storage) checkHeader(digest string, m blobMeta) error {
+	want := fmt.Sprintf("[%s %d]", digest, m.size)
+	off := m.offset - int64(len(want))
+	if off < 0 {
+		return fmt.Errorf("%s: meta offset %d is smaller than its own header", digest, m.offset)
+	}
+	buf := make([]byte, len(want))
+	if _, err := s.fds[m.file].ReadAt(buf, off); err != nil {
+		return fmt.Errorf("%s: reading header in %s at %d: %v", digest, s.filename(m.file), off, err)
+	}
+	if string(buf) != want {
+		return fmt.Errorf("%s: header mismatch in %s at %d: found %q, want %q", digest, s.filename(m.file), off, buf, want)
+	}
+	return nil
+}
+
+// StorageStatus returns the startup consistency check's result for the
+// status handler.
+func (s *storage) StorageStatus() interface{} {
+	return s.checkRes
+}
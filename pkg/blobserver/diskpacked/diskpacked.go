@@ -76,22 +76,42 @@ func (d debugT) Println(args ...interface{}) {
 
 const defaultMaxFileSize = 512 << 20 // 512MB
 
+// defaultNumWriters is how many pack files are kept open for appends
+// at once when "numWriters" isn't configured. Incoming blobs are
+// spread across the writers round-robin, so concurrent receives
+// don't all serialize on a single append lock.
+const defaultNumWriters = 2
+
 type storage struct {
 	root        string
 	index       sorted.KeyValue
 	maxFileSize int64
+	numWriters  int
 
-	writeLock io.Closer // Provided by lock.Lock, and guards other processes from accesing the file open for writes.
+	mu         sync.Mutex // Guards the fields below.
+	closed     bool
+	fds        []*os.File    // read-only handles of all pack files, by pack number
+	writers    []*packWriter // the pack files currently open for appends
+	nextWriter int           // round-robin counter assigning receives to writers
 
-	mu     sync.Mutex // Guards all I/O state.
-	closed bool
-	writer *os.File
-	fds    []*os.File
-	size   int64
+	checkRes *checkResult // result of the startup consistency check
 
 	*local.Generationer
 }
 
+// A packWriter is one pack file open for appends. Each writer has its
+// own mutex, so receives assigned to different writers append and
+// fsync in parallel.
+type packWriter struct {
+	s *storage
+
+	mu   sync.Mutex // Guards the fields below.
+	file int        // pack number
+	f    *os.File   // open for appends; nil once closed
+	lk   io.Closer  // Provided by lock.Lock, and guards other processes from accessing the file open for writes.
+	size int64
+}
+
 func (s *storage) String() string {
 	return fmt.Sprintf("\"diskpacked\" blob packs at %s", s.root)
 }
@@ -124,12 +144,13 @@ func New(dir string) (blobserver.Storage, error) {
 		// TODO: detect existing max size from size of files, if obvious,
 		// and set maxSize to that?
 	}
-	return newStorage(dir, maxSize, nil)
+	return newStorage(dir, maxSize, 0, nil)
 }
 
 // newStorage returns a new storage in path root with the given maxFileSize,
-// or defaultMaxFileSize (512MB) if <= 0
-func newStorage(root string, maxFileSize int64, indexConf jsonconfig.Obj) (s *storage, err error) {
+// or defaultMaxFileSize (512MB) if <= 0, keeping numWriters pack files
+// open for concurrent appends, or defaultNumWriters if <= 0.
+func newStorage(root string, maxFileSize int64, numWriters int, indexConf jsonconfig.Obj) (s *storage, err error) {
 	fi, err := os.Stat(root)
 	if os.IsNotExist(err) {
 		return nil, fmt.Errorf("storage root %q doesn't exist", root)
@@ -157,6 +178,9 @@ func newStorage(root string, maxFileSize int64, indexConf jsonconfig.Obj) (s *st
 	if maxFileSize <= 0 {
 		maxFileSize = defaultMaxFileSize
 	}
+	if numWriters <= 0 {
+		numWriters = defaultNumWriters
+	}
 	// Be consistent with trailing slashes.  Makes expvar stats for total
 	// reads/writes consistent across diskpacked targets, regardless of what
 	// people put in their low level config.
@@ -165,29 +189,55 @@ func newStorage(root string, maxFileSize int64, indexConf jsonconfig.Obj) (s *st
 		root:         root,
 		index:        index,
 		maxFileSize:  maxFileSize,
+		numWriters:   numWriters,
 		Generationer: local.NewGenerationer(root),
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if err := s.openAllPacks(); err != nil {
+		s.closeAllPacks()
 		return nil, err
 	}
+	s.checkRes = s.startupCheck()
 	if _, _, err := s.StorageGeneration(); err != nil {
 		return nil, fmt.Errorf("Error initialization generation for %q: %v", root, err)
 	}
 	return s, nil
 }
 
-func newFromConfig(_ blobserver.Loader, config jsonconfig.Obj) (storage blobserver.Storage, err error) {
+func newFromConfig(_ blobserver.Loader, config jsonconfig.Obj) (blobserver.Storage, error) {
 	var (
 		path        = config.RequiredString("path")
 		maxFileSize = config.OptionalInt("maxFileSize", 0)
+		numWriters  = config.OptionalInt("numWriters", 0)
 		indexConf   = config.OptionalObject("metaIndex")
+		repair      = config.OptionalBool("repair", false)
 	)
 	if err := config.Validate(); err != nil {
 		return nil, err
 	}
-	return newStorage(path, int64(maxFileSize), indexConf)
+	s, err := newStorage(path, int64(maxFileSize), numWriters, indexConf)
+	if err != nil {
+		return nil, err
+	}
+	if repair && s.checkRes.nerr > 0 {
+		if len(indexConf) > 0 {
+			log.Printf("diskpacked: not repairing %s: repair isn't supported with a custom metaIndex", path)
+			return s, nil
+		}
+		log.Printf("diskpacked: consistency check found %d problem(s) in %s; rebuilding index", s.checkRes.nerr, path)
+		if err := s.Close(); err != nil {
+			return nil, err
+		}
+		if err := Reindex(path, true); err != nil {
+			return nil, fmt.Errorf("diskpacked: repair of %s failed: %v", path, err)
+		}
+		if s, err = newStorage(path, int64(maxFileSize), numWriters, indexConf); err != nil {
+			return nil, err
+		}
+		s.checkRes.Repaired = true
+	}
+	return s, nil
 }
 
 func init() {
@@ -213,64 +263,88 @@ func (s *storage) openForRead(n int) error {
 	return nil
 }
 
-// openForWrite will create or open pack file n for writes, create a lock
+// openPackWriter will create or open pack file n for writes, create a lock
 // visible external to the process and seek to the end of the file ready for
 // appending new data.
-// This function is not thread safe, s.mu should be locked by the caller.
-func (s *storage) openForWrite(n int) error {
+func (s *storage) openPackWriter(n int) (*packWriter, error) {
 	fn := s.filename(n)
 	l, err := lock.Lock(fn + ".lock")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	f, err := os.OpenFile(fn, os.O_RDWR|os.O_CREATE, 0666)
 	if err != nil {
 		l.Close()
-		return err
+		return nil, err
 	}
 	openFdsVar.Add(s.root, 1)
 	debug.Printf("diskpacked: opened for write %q", fn)
 
-	s.size, err = f.Seek(0, os.SEEK_END)
+	size, err := f.Seek(0, os.SEEK_END)
 	if err != nil {
-		return err
+		f.Close()
+		l.Close()
+		return nil, err
 	}
-
-	s.writer = f
-	s.writeLock = l
-	return nil
+	return &packWriter{s: s, file: n, f: f, lk: l, size: size}, nil
 }
 
-// nextPack will close the current writer and release its lock if open,
-// open the next pack file in sequence for writing, grab its lock, set it
-// to the currently active writer, and open another copy for read-only use.
-// This function is not thread safe, s.mu should be locked by the caller.
-func (s *storage) nextPack() error {
-	debug.Println("diskpacked: nextPack")
-	s.size = 0
-	if s.writeLock != nil {
-		err := s.writeLock.Close()
-		if err != nil {
+// rotate closes pw's pack file and releases its lock, then opens the
+// next unused pack file in sequence for writing, grabs its lock, and
+// opens another copy of it for read-only use.
+// pw.mu must be held by the caller; pw.s.mu must not be.
+func (pw *packWriter) rotate() error {
+	debug.Println("diskpacked: rotating away from pack", pw.file)
+	s := pw.s
+	if pw.lk != nil {
+		if err := pw.lk.Close(); err != nil {
 			return err
 		}
-		s.writeLock = nil
+		pw.lk = nil
 	}
-	if s.writer != nil {
-		if err := s.writer.Close(); err != nil {
+	if pw.f != nil {
+		if err := pw.f.Close(); err != nil {
 			return err
 		}
 		openFdsVar.Add(s.root, -1)
+		pw.f = nil
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	n := len(s.fds)
-	if err := s.openForWrite(n); err != nil {
+	npw, err := s.openPackWriter(n)
+	if err != nil {
+		return err
+	}
+	if err := s.openForRead(n); err != nil {
 		return err
 	}
-	return s.openForRead(n)
+	pw.file, pw.f, pw.lk, pw.size = npw.file, npw.f, npw.lk, npw.size
+	return nil
+}
+
+// nextPack rotates every open writer to a fresh pack file. It's only
+// used by tests wanting deterministic pack file boundaries.
+func (s *storage) nextPack() error {
+	s.mu.Lock()
+	writers := make([]*packWriter, len(s.writers))
+	copy(writers, s.writers)
+	s.mu.Unlock()
+	for _, pw := range writers {
+		pw.mu.Lock()
+		err := pw.rotate()
+		pw.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// openAllPacks opens read-only each pack file in s.root, populating s.fds.
-// The latest pack file will also have a writable handle opened.
+// openAllPacks opens read-only each pack file in s.root, populating s.fds,
+// and opens the newest packs still below maxFileSize for writing, creating
+// fresh ones as needed, until s.numWriters packs are open for appends.
 // This function is not thread safe, s.mu should be locked by the caller.
 func (s *storage) openAllPacks() error {
 	debug.Println("diskpacked: openAllPacks")
@@ -281,44 +355,105 @@ func (s *storage) openAllPacks() error {
 			break
 		}
 		if err != nil {
-			s.Close()
 			return err
 		}
 		n++
 	}
 
-	if n == 0 {
-		// If no pack files are found, we create one open for read and write.
-		return s.nextPack()
+	// Reuse the newest pack files that still have room for appends.
+	for i := n - 1; i >= 0 && len(s.writers) < s.numWriters; i-- {
+		fi, err := s.fds[i].Stat()
+		if err != nil {
+			return err
+		}
+		if fi.Size() > s.maxFileSize {
+			continue
+		}
+		pw, err := s.openPackWriter(i)
+		if err != nil {
+			return err
+		}
+		s.writers = append(s.writers, pw)
+	}
+	// Create fresh pack files for the remaining writers.
+	for len(s.writers) < s.numWriters {
+		pn := len(s.fds)
+		pw, err := s.openPackWriter(pn)
+		if err != nil {
+			return err
+		}
+		if err := s.openForRead(pn); err != nil {
+			return err
+		}
+		s.writers = append(s.writers, pw)
 	}
+	return nil
+}
 
-	// If 1 or more pack files are found, open the last one read and write.
-	return s.openForWrite(n - 1)
+// closeAllPacks closes every open pack file handle and append lock
+// without touching the index. It's only used to clean up after a
+// failed open, so unlike Close it assumes no append is in flight.
+// This function is not thread safe, s.mu should be locked by the caller.
+func (s *storage) closeAllPacks() {
+	for _, pw := range s.writers {
+		if pw.f != nil {
+			pw.f.Close()
+			openFdsVar.Add(s.root, -1)
+			pw.f = nil
+		}
+		if pw.lk != nil {
+			pw.lk.Close()
+			pw.lk = nil
+		}
+	}
+	s.writers = nil
+	for _, f := range s.fds {
+		f.Close()
+		openFdsVar.Add(s.root, -1)
+	}
+	s.fds = nil
 }
 
 func (s *storage) Close() error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	writers := s.writers
+	fds := s.fds
+	s.writers = nil
+	if err := s.index.Close(); err != nil {
+		log.Println("diskpacked: closing index:", err)
+	}
+	s.mu.Unlock()
+
 	var closeErr error
-	if !s.closed {
-		s.closed = true
-		if err := s.index.Close(); err != nil {
-			log.Println("diskpacked: closing index:", err)
-		}
-		for _, f := range s.fds {
-			if err := f.Close(); err != nil {
+	// Locking each writer waits out any in-flight append before its
+	// pack file is closed out from under it.
+	for _, pw := range writers {
+		pw.mu.Lock()
+		if pw.f != nil {
+			if err := pw.f.Close(); err != nil {
 				closeErr = err
 			}
 			openFdsVar.Add(s.root, -1)
+			pw.f = nil
 		}
-		s.writer = nil
-		if l := s.writeLock; l != nil {
-			err := l.Close()
-			if closeErr == nil {
+		if pw.lk != nil {
+			if err := pw.lk.Close(); err != nil && closeErr == nil {
 				closeErr = err
 			}
-			s.writeLock = nil
+			pw.lk = nil
 		}
+		pw.mu.Unlock()
+	}
+	for _, f := range fds {
+		if err := f.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+		openFdsVar.Add(s.root, -1)
 	}
 	return closeErr
 }
@@ -370,7 +505,8 @@ func (s *storage) RemoveBlobs(blobs []blob.Ref) error {
 		batch.Delete(br.String())
 		wg.Go(func() error {
 			defer removeGate.Done()
-			if err := s.delete(br); err != nil {
+			// Removing an already-absent blob is harmless.
+			if err := s.delete(br); err != nil && err != os.ErrNotExist {
 				return err
 			}
 			return nil
@@ -410,9 +546,24 @@ func (s *storage) StatBlobs(dest chan<- blob.SizedRef, blobs []blob.Ref) (err er
 }
 
 func (s *storage) EnumerateBlobs(ctx *context.Context, dest chan<- blob.SizedRef, after string, limit int) (err error) {
+	return s.EnumerateFilteredBlobs(ctx, dest, blobserver.EnumerateFilter{}, after, limit)
+}
+
+// EnumerateFilteredBlobs implements blobserver.FilteredEnumerator,
+// scanning only the filter's blobref prefix in the meta index and
+// checking sizes there, without touching the pack files.
+func (s *storage) EnumerateFilteredBlobs(ctx *context.Context, dest chan<- blob.SizedRef, filter blobserver.EnumerateFilter, after string, limit int) (err error) {
 	defer close(dest)
 
-	t := s.index.Find(after, "")
+	start, end := after, ""
+	if p := filter.RefPrefix; p != "" {
+		if start < p {
+			start = p
+		}
+		// Blobrefs are ASCII, so this bounds the prefix scan.
+		end = p + "\xff"
+	}
+	t := s.index.Find(start, end)
 	defer func() {
 		closeErr := t.Close()
 		if err == nil {
@@ -433,8 +584,12 @@ func (s *storage) EnumerateBlobs(ctx *context.Context, dest chan<- blob.SizedRef
 		if !ok {
 			return fmt.Errorf("diskpacked: couldn't parse index value %q: %q", key, t.Value())
 		}
+		sb := m.SizedRef(br)
+		if !filter.Matches(sb) {
+			continue
+		}
 		select {
-		case dest <- m.SizedRef(br):
+		case dest <- sb:
 		case <-ctx.Done():
 			return context.ErrCanceled
 		}
@@ -617,17 +772,41 @@ func (s *storage) ReceiveBlob(br blob.Ref, source io.Reader) (sbr blob.SizedRef,
 	return
 }
 
-// append writes the provided blob to the current data file.
-func (s *storage) append(br blob.SizedRef, r io.Reader) error {
+// pick returns the writer the next append should go to, assigning
+// them round-robin.
+func (s *storage) pick() (*packWriter, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.closed {
+		return nil, errors.New("diskpacked: write to closed storage")
+	}
+	pw := s.writers[s.nextWriter%len(s.writers)]
+	s.nextWriter++
+	return pw, nil
+}
+
+// append writes the provided blob to one of the open pack files.
+func (s *storage) append(br blob.SizedRef, r io.Reader) error {
+	pw, err := s.pick()
+	if err != nil {
+		return err
+	}
+	return pw.append(br, r)
+}
+
+// append writes the provided blob to pw's pack file. The blob's index
+// entry is only written once its bytes have been synced to disk.
+func (pw *packWriter) append(br blob.SizedRef, r io.Reader) error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	s := pw.s
+	if pw.f == nil {
 		return errors.New("diskpacked: write to closed storage")
 	}
 
-	fn := s.writer.Name()
-	n, err := fmt.Fprintf(s.writer, "[%v %v]", br.Ref.String(), br.Size)
-	s.size += int64(n)
+	fn := pw.f.Name()
+	n, err := fmt.Fprintf(pw.f, "[%v %v]", br.Ref.String(), br.Size)
+	pw.size += int64(n)
 	writeVar.Add(fn, int64(n))
 	writeTotVar.Add(s.root, int64(n))
 	if err != nil {
@@ -635,18 +814,18 @@ func (s *storage) append(br blob.SizedRef, r io.Reader) error {
 	}
 
 	// TODO(adg): remove this seek and the offset check once confident
-	offset, err := s.writer.Seek(0, os.SEEK_CUR)
+	offset, err := pw.f.Seek(0, os.SEEK_CUR)
 	if err != nil {
 		return err
 	}
-	if offset != s.size {
+	if offset != pw.size {
 		return fmt.Errorf("diskpacked: seek says offset = %d, we think %d",
-			offset, s.size)
+			offset, pw.size)
 	}
-	offset = s.size // make this a declaration once the above is removed
+	offset = pw.size // make this a declaration once the above is removed
 
-	n2, err := io.Copy(s.writer, r)
-	s.size += n2
+	n2, err := io.Copy(pw.f, r)
+	pw.size += n2
 	writeVar.Add(fn, int64(n))
 	writeTotVar.Add(s.root, int64(n))
 	if err != nil {
@@ -655,13 +834,13 @@ func (s *storage) append(br blob.SizedRef, r io.Reader) error {
 	if n2 != int64(br.Size) {
 		return fmt.Errorf("diskpacked: written blob size %d didn't match size %d", n, br.Size)
 	}
-	if err = s.writer.Sync(); err != nil {
+	if err = pw.f.Sync(); err != nil {
 		return err
 	}
 
-	packIdx := len(s.fds) - 1
-	if s.size > s.maxFileSize {
-		if err := s.nextPack(); err != nil {
+	packIdx := pw.file
+	if pw.size > s.maxFileSize {
+		if err := pw.rotate(); err != nil {
 			return err
 		}
 	}
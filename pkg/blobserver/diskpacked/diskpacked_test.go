@@ -18,9 +18,11 @@ package diskpacked
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 
 	"camlistore.org/pkg/blob"
@@ -31,22 +33,22 @@ import (
 )
 
 func newTempDiskpacked(t *testing.T) (sto blobserver.Storage, cleanup func()) {
-	return newTempDiskpackedWithIndex(t, jsonconfig.Obj{})
+	return newTempDiskpackedWithIndex(t, jsonconfig.Obj{}, 0)
 }
 
 func newTempDiskpackedMemory(t *testing.T) (sto blobserver.Storage, cleanup func()) {
 	return newTempDiskpackedWithIndex(t, jsonconfig.Obj{
 		"type": "memory",
-	})
+	}, 0)
 }
 
-func newTempDiskpackedWithIndex(t *testing.T, indexConf jsonconfig.Obj) (sto blobserver.Storage, cleanup func()) {
+func newTempDiskpackedWithIndex(t *testing.T, indexConf jsonconfig.Obj, numWriters int) (sto blobserver.Storage, cleanup func()) {
 	dir, err := ioutil.TempDir("", "diskpacked-test")
 	if err != nil {
 		t.Fatal(err)
 	}
 	t.Logf("diskpacked test dir is %q", dir)
-	s, err := newStorage(dir, 1<<20, indexConf)
+	s, err := newStorage(dir, 1<<20, numWriters, indexConf)
 	if err != nil {
 		t.Fatalf("newStorage: %v", err)
 	}
@@ -70,7 +72,8 @@ func TestDiskpackedAltIndex(t *testing.T) {
 }
 
 func TestDoubleReceive(t *testing.T) {
-	sto, cleanup := newTempDiskpacked(t)
+	// A single writer, so the test knows which pack each blob lands in.
+	sto, cleanup := newTempDiskpackedWithIndex(t, jsonconfig.Obj{}, 1)
 	defer cleanup()
 
 	size := func(n int) int64 {
@@ -115,6 +118,47 @@ func TestDoubleReceive(t *testing.T) {
 	}
 }
 
+func TestStartupCheckTruncatedPack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskpacked-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := newStorage(dir, 1<<20, 1, nil)
+	if err != nil {
+		t.Fatalf("newStorage: %v", err)
+	}
+	if n := s.checkRes.nerr; n != 0 {
+		t.Fatalf("fresh storage: %d consistency error(s): %q", n, s.checkRes.Errors)
+	}
+	b := &test.Blob{Contents: strings.Repeat("a", 5<<10)}
+	if _, err := blobserver.Receive(s, b.BlobRef(), b.Reader()); err != nil {
+		t.Fatal(err)
+	}
+	pack := s.filename(0)
+	s.Close()
+
+	// Simulate a bad restore by truncating the pack file out from
+	// under the meta index.
+	fi, err := os.Stat(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(pack, fi.Size()/2); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = newStorage(dir, 1<<20, 1, nil)
+	if err != nil {
+		t.Fatalf("newStorage after truncate: %v", err)
+	}
+	defer s.Close()
+	if s.checkRes.nerr == 0 {
+		t.Error("consistency check didn't notice the truncated pack")
+	}
+}
+
 func TestDelete(t *testing.T) {
 	sto, cleanup := newTempDiskpacked(t)
 	defer cleanup()
@@ -196,3 +240,138 @@ func TestDelete(t *testing.T) {
 		}
 	}
 }
+
+// TestCrashRecoveryPartialPacks simulates a crash that leaves partial
+// records at the end of several actively-written packs, and verifies
+// that a reindex recovers exactly the fully-written blobs.
+func TestCrashRecoveryPartialPacks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskpacked-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := newStorage(dir, 1<<20, 3, nil)
+	if err != nil {
+		t.Fatalf("newStorage: %v", err)
+	}
+
+	var want []blob.SizedRef
+	for i := 0; i < 9; i++ {
+		b := &test.Blob{Contents: fmt.Sprintf("blob %d: %s", i, strings.Repeat("x", 1<<10))}
+		if _, err := blobserver.Receive(s, b.BlobRef(), b.Reader()); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, b.SizedRef())
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The blobs were assigned round-robin over three packs.
+	for i := 0; i < 3; i++ {
+		fi, err := os.Stat(s.filename(i))
+		if err != nil {
+			t.Fatalf("pack %d: %v", i, err)
+		}
+		if fi.Size() == 0 {
+			t.Fatalf("pack %d is empty; expected blobs in all three packs", i)
+		}
+	}
+
+	appendStr := func(path, str string) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(f, str); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Simulate a crash mid-append on two of the packs: a torn header
+	// on one, a complete header with truncated blob data on another.
+	partial := &test.Blob{Contents: strings.Repeat("p", 4<<10)}
+	appendStr(s.filename(0), "[sha1-00000")
+	appendStr(s.filename(1), fmt.Sprintf("[%v %d]only-a-few-bytes", partial.BlobRef(), len(partial.Contents)))
+
+	if err := Reindex(dir, true); err != nil {
+		t.Fatalf("Reindex: %v", err)
+	}
+	s, err = newStorage(dir, 1<<20, 3, nil)
+	if err != nil {
+		t.Fatalf("newStorage after crash: %v", err)
+	}
+	defer s.Close()
+	if n := s.checkRes.nerr; n != 0 {
+		t.Errorf("%d consistency error(s) after reindex: %q", n, s.checkRes.Errors)
+	}
+	if err := storagetest.CheckEnumerate(s, want); err != nil {
+		t.Error(err)
+	}
+	// The partially-written blob was never acknowledged, so it must
+	// not be in the index; re-uploading it must succeed.
+	if _, err := s.meta(partial.BlobRef()); err != os.ErrNotExist {
+		t.Errorf("meta(partial) = %v; want os.ErrNotExist", err)
+	}
+	if _, err := blobserver.Receive(s, partial.BlobRef(), partial.Reader()); err != nil {
+		t.Fatal(err)
+	}
+	if err := storagetest.CheckEnumerate(s, append(want, partial.SizedRef())); err != nil {
+		t.Error(err)
+	}
+}
+
+func benchmarkReceive(b *testing.B, numWriters int) {
+	dir, err := ioutil.TempDir("", "diskpacked-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := newStorage(dir, 1<<30, numWriters, jsonconfig.Obj{"type": "memory"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+
+	const (
+		uploaders = 8
+		blobSize  = 16 << 10
+	)
+	b.SetBytes(blobSize)
+	b.ResetTimer()
+
+	ch := make(chan int)
+	errc := make(chan error, uploaders)
+	var wg sync.WaitGroup
+	for i := 0; i < uploaders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				tb := &test.Blob{Contents: fmt.Sprintf("%d: %s", i, strings.Repeat("b", blobSize))}
+				if _, err := blobserver.Receive(s, tb.BlobRef(), tb.Reader()); err != nil {
+					errc <- err
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < b.N; i++ {
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+	select {
+	case err := <-errc:
+		b.Fatal(err)
+	default:
+	}
+}
+
+func BenchmarkReceiveOneWriter(b *testing.B) { benchmarkReceive(b, 1) }
+
+func BenchmarkReceiveTwoWriters(b *testing.B) { benchmarkReceive(b, 2) }
+
+func BenchmarkReceiveFourWriters(b *testing.B) { benchmarkReceive(b, 4) }
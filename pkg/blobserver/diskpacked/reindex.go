@@ -81,7 +81,7 @@ func (s *storage) reindexOne(ctx *context.Context, index sorted.KeyValue, overwr
 
 	// TODO(tgulacsi): proper verbose from context
 	verbose := camliDebug
-	err := s.walkPack(verbose, packID,
+	err := s.walkPack(verbose, true, packID,
 		func(packID int, ref blob.Ref, offset int64, size uint32) error {
 			if !ref.Valid() {
 				if camliDebug {
@@ -139,7 +139,7 @@ func (s *storage) Walk(ctx *context.Context,
 			return err
 		}
 		fh.Close()
-		if err = s.walkPack(verbose, i, walker); err != nil {
+		if err = s.walkPack(verbose, false, i, walker); err != nil {
 			return err
 		}
 	}
@@ -148,7 +148,9 @@ func (s *storage) Walk(ctx *context.Context,
 
 // walkPack walks the given pack and calls the walker callback with each blobref.
 // Stops if walker returns non-nil error and returns that.
-func (s *storage) walkPack(verbose bool, packID int,
+// If skipTruncated, a final record whose data is cut short by the end
+// of the file (from a crash mid-append) is skipped instead of walked.
+func (s *storage) walkPack(verbose, skipTruncated bool, packID int,
 	walker func(packID int, ref blob.Ref, offset int64, size uint32) error) error {
 
 	fh, err := os.Open(s.filename(packID))
@@ -157,6 +159,11 @@ func (s *storage) walkPack(verbose bool, packID int,
 	}
 	defer fh.Close()
 	name := fh.Name()
+	fi, err := fh.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := fi.Size()
 
 	var (
 		pos  int64
@@ -218,6 +225,14 @@ func (s *storage) walkPack(verbose bool, packID int,
 				log.Printf("found %s at %d", ref, pos)
 			}
 		}
+		if skipTruncated && pos+1+int64(m)+int64(size) > fileSize {
+			// A crash mid-append can leave a complete header
+			// with truncated blob data at the end of a pack.
+			// Don't index it; the blob was never acknowledged.
+			log.Printf("diskpacked: ignoring truncated blob %v at %d in %q (have %d of %d bytes)",
+				ref, pos, name, fileSize-pos-1-int64(m), size)
+			break
+		}
 		if err = walker(packID, ref, pos+1+int64(m), size); err != nil {
 			return err
 		}
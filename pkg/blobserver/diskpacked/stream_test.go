@@ -108,7 +108,7 @@ func newTestStorage(t *testing.T, packs ...pack) (s *storage, clean func()) {
 		writePack(t, dir, i, p)
 	}
 
-	s, err = newStorage(dir, 0, nil)
+	s, err = newStorage(dir, 0, 1, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
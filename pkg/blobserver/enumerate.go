@@ -17,6 +17,8 @@ limitations under the License.
 package blobserver
 
 import (
+	"errors"
+	"strings"
 	"sync"
 
 	"camlistore.org/pkg/blob"
@@ -66,6 +68,101 @@ func EnumerateAllFrom(ctx *context.Context, src BlobEnumerator, after string, fn
 	}
 }
 
+// EnumerateAllFiltered is like EnumerateAll, but runs fn only for
+// blobs matching filter, enumerating batches with
+// EnumerateFilteredBlobs.
+func EnumerateAllFiltered(ctx *context.Context, src BlobEnumerator, filter EnumerateFilter, fn func(blob.SizedRef) error) error {
+	if filter.IsZero() {
+		return EnumerateAll(ctx, src, fn)
+	}
+	const batchSize = 1000
+	var mu sync.Mutex // protects returning with an error while fn is still running
+	errc := make(chan error, 1)
+	after := ""
+	for {
+		ch := make(chan blob.SizedRef, 16)
+		n := 0
+		go func() {
+			var err error
+			for sb := range ch {
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				err = fn(sb)
+				mu.Unlock()
+				after = sb.Ref.String()
+				n++
+			}
+			errc <- err
+		}()
+		err := EnumerateFilteredBlobs(ctx, src, ch, filter, after, batchSize)
+		if err != nil {
+			mu.Lock() // make sure fn callback finished; no need to unlock
+			return err
+		}
+		if err := <-errc; err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+}
+
+var errStopEnumerating = errors.New("blobserver: stop enumerating")
+
+// EnumerateFilteredBlobs enumerates the blobs of src matching filter,
+// with EnumerateBlobs' after and limit semantics, where limit counts
+// matching blobs. It uses src's native filtered enumeration when
+// implemented and emulates it on top of EnumerateBlobs otherwise.
+// Like EnumerateBlobs, it closes dest.
+func EnumerateFilteredBlobs(ctx *context.Context, src BlobEnumerator, dest chan<- blob.SizedRef, filter EnumerateFilter, after string, limit int) error {
+	if filter.IsZero() {
+		return src.EnumerateBlobs(ctx, dest, after, limit)
+	}
+	if fe, ok := src.(FilteredEnumerator); ok {
+		return fe.EnumerateFilteredBlobs(ctx, dest, filter, after, limit)
+	}
+	defer close(dest)
+	if p := filter.RefPrefix; after < p {
+		// Skip ahead to just before the prefix. after is
+		// exclusive and the prefix may itself be a complete
+		// blobref, so starting at the prefix string would skip
+		// an exact match.
+		if skip := p[:len(p)-1]; after < skip {
+			after = skip
+		}
+	}
+	sent := 0
+	err := EnumerateAllFrom(ctx, src, after, func(sb blob.SizedRef) error {
+		if p := filter.RefPrefix; p != "" && !strings.HasPrefix(sb.Ref.String(), p) {
+			if sb.Ref.String() > p {
+				// Sorted enumeration: once past the
+				// prefix range, nothing later can match.
+				return errStopEnumerating
+			}
+			return nil
+		}
+		if !filter.Matches(sb) {
+			return nil
+		}
+		select {
+		case dest <- sb:
+		case <-ctx.Done():
+			return context.ErrCanceled
+		}
+		if sent++; sent == limit {
+			return errStopEnumerating
+		}
+		return nil
+	})
+	if err == errStopEnumerating {
+		err = nil
+	}
+	return err
+}
+
 // RefTypes returns a list of blobref types appearing on the provided enumerator.
 // A blobref type is a string like "sha1", or whatever is on the left side
 // of the hyphen in a blobref.
@@ -48,10 +48,16 @@ func newFromConfig(_ blobserver.Loader, config jsonconfig.Obj) (blobserver.Stora
 
 	gs := &Storage{
 		bucket: config.RequiredString("bucket"),
-		client: googlestorage.NewClient(googlestorage.MakeOauthTransport(
-			auth.RequiredString("client_id"),
-			auth.RequiredString("client_secret"),
-			auth.RequiredString("refresh_token"))),
+	}
+	// Either the GCE metadata service, a service account JSON key
+	// file, or the three OAuth fields from an interactive flow.
+	useGCE := auth.OptionalBool("gce", false)
+	keyFile := auth.OptionalString("service_account_file", "")
+	var clientID, secret, refreshToken string
+	if !useGCE && keyFile == "" {
+		clientID = auth.RequiredString("client_id")
+		secret = auth.RequiredString("client_secret")
+		refreshToken = auth.RequiredString("refresh_token")
 	}
 	if err := config.Validate(); err != nil {
 		return nil, err
@@ -59,6 +65,21 @@ func newFromConfig(_ blobserver.Loader, config jsonconfig.Obj) (blobserver.Stora
 	if err := auth.Validate(); err != nil {
 		return nil, err
 	}
+	switch {
+	case useGCE && keyFile != "":
+		return nil, errors.New(`in googlecloudstorage "auth", "gce" and "service_account_file" are mutually exclusive`)
+	case useGCE:
+		gs.client = googlestorage.NewGCEClient()
+	case keyFile != "":
+		client, err := googlestorage.NewServiceAccountClient(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		gs.client = client
+	default:
+		gs.client = googlestorage.NewClient(googlestorage.MakeOauthTransport(
+			clientID, secret, refreshToken))
+	}
 	return gs, nil
 }
 
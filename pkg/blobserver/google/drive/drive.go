@@ -31,10 +31,24 @@ Example low-level config:
         },
       },
     },
+
+Instead of "parent_id", "parent_name" names the parent folder by its
+title; it is resolved at the root of the drive on start, and created
+there if it does not exist yet.
+
+The optional "chunkSize" (bytes; a positive multiple of 256KiB),
+"maxRetries" and "retryBackoff" (a time.Duration string) arguments
+control how blob uploads deal with flaky connections: blobs larger
+than chunkSize are sent with the Drive resumable upload protocol in
+chunkSize pieces, and a failed upload (or chunk) is retried up to
+maxRetries times, waiting retryBackoff before the first retry and
+doubling the wait on each subsequent one.
 */
 package drive
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -73,13 +87,46 @@ func newFromConfig(_ blobserver.Loader, config jsonconfig.Obj) (blobserver.Stora
 		Config:    oauthConf,
 		Transport: http.DefaultTransport,
 	}
-	parent := config.RequiredString("parent_id")
+	parentId := config.OptionalString("parent_id", "")
+	parentName := config.OptionalString("parent_name", "")
+	chunkSize := config.OptionalInt64("chunkSize", 0)
+	maxRetries := config.OptionalInt("maxRetries", 0)
+	retryBackoffStr := config.OptionalString("retryBackoff", "")
 	if err := config.Validate(); err != nil {
 		return nil, err
 	}
-	service, err := service.New(transport, parent)
+	if chunkSize < 0 || chunkSize%service.ChunkSizeGranularity != 0 {
+		return nil, errors.New(`in googledrive config, "chunkSize" must be a positive multiple of 256KiB`)
+	}
+	if maxRetries < 0 {
+		return nil, errors.New(`in googledrive config, "maxRetries" must not be negative`)
+	}
+	var retryBackoff time.Duration
+	if retryBackoffStr != "" {
+		var err error
+		retryBackoff, err = time.ParseDuration(retryBackoffStr)
+		if err != nil {
+			return nil, fmt.Errorf(`in googledrive config, invalid "retryBackoff": %v`, err)
+		}
+	}
+	var svc *service.DriveService
+	var err error
+	switch {
+	case parentId != "" && parentName != "":
+		return nil, errors.New(`in googledrive config, "parent_id" and "parent_name" are mutually exclusive`)
+	case parentName != "":
+		svc, err = service.NewWithParentName(transport, parentName)
+	case parentId != "":
+		svc, err = service.New(transport, parentId)
+	default:
+		return nil, errors.New(`in googledrive config, either "parent_id" or "parent_name" is required`)
+	}
+	if err != nil {
+		return nil, err
+	}
+	svc.ConfigureUploads(chunkSize, maxRetries, retryBackoff)
 	sto := &driveStorage{
-		service: service,
+		service: svc,
 	}
 	return sto, err
 }
@@ -19,12 +19,17 @@ limitations under the License.
 package service
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"camlistore.org/third_party/code.google.com/p/goauth2/oauth"
 	client "camlistore.org/third_party/code.google.com/p/google-api-go-client/drive/v2"
@@ -33,6 +38,21 @@ import (
 const (
 	MimeTypeDriveFolder = "application/vnd.google-apps.folder"
 	MimeTypeCamliBlob   = "application/vnd.camlistore.blob"
+
+	// ChunkSizeGranularity is the granularity the Drive API requires
+	// of resumable upload chunk sizes: every chunk but the last must
+	// be a multiple of 256KiB.
+	ChunkSizeGranularity = 256 << 10
+
+	// resumableUploadURL is the files endpoint of the Drive upload
+	// API, which the generated client does not expose.
+	resumableUploadURL = "https://www.googleapis.com/upload/drive/v2/files"
+
+	// statusResumeIncomplete is the non-standard status the upload
+	// API replies with to acknowledge a non-final chunk.
+	statusResumeIncomplete = 308
+
+	defaultRetryBackoff = time.Second
 )
 
 // DriveService wraps Google Drive API to implement utility methods to
@@ -41,6 +61,10 @@ type DriveService struct {
 	transport  *oauth.Transport
 	apiservice *client.Service
 	parentId   string
+
+	chunkSize    int64
+	maxRetries   int
+	retryBackoff time.Duration
 }
 
 // New initiates a new DriveService. parentId is the ID of the directory
@@ -60,6 +84,35 @@ func New(transport *oauth.Transport, parentId string) (*DriveService, error) {
 	return service, err
 }
 
+// NewWithParentName is like New, but takes the title of the parent
+// directory instead of its ID. The directory is looked up at the root
+// of the drive, and created there if it does not exist yet.
+func NewWithParentName(transport *oauth.Transport, parentName string) (*DriveService, error) {
+	apiservice, err := client.New(transport.Client())
+	if err != nil {
+		return nil, err
+	}
+	req := apiservice.Files.List()
+	req.Q(fmt.Sprintf("title = '%s' and mimeType = '%s' and trashed = false", parentName, MimeTypeDriveFolder))
+	files, err := req.Do()
+	if err != nil {
+		return nil, err
+	}
+	var parent *client.File
+	if len(files.Items) > 0 {
+		parent = files.Items[0]
+	} else {
+		parent, err = apiservice.Files.Insert(&client.File{
+			Title:    parentName,
+			MimeType: MimeTypeDriveFolder,
+		}).Do()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &DriveService{transport: transport, apiservice: apiservice, parentId: parent.Id}, nil
+}
+
 // Get retrieves a file with its title equal to the provided id and a child of
 // the parentId as given to New. If not found, os.ErrNotExist is returned.
 func (s *DriveService) Get(id string) (*client.File, error) {
@@ -97,22 +150,151 @@ func (s *DriveService) List(pageToken string, limit int) (files []*client.File,
 	return result.Items, result.NextPageToken, err
 }
 
+// ConfigureUploads sets how Upsert sends blob contents: blobs larger
+// than chunkSize bytes (0 means never) are uploaded in chunkSize
+// pieces with the resumable upload protocol, and a failed upload or
+// chunk is retried up to maxRetries times, waiting retryBackoff
+// (default 1s) before the first retry and twice as long before each
+// subsequent one. chunkSize must be a multiple of
+// ChunkSizeGranularity.
+func (s *DriveService) ConfigureUploads(chunkSize int64, maxRetries int, retryBackoff time.Duration) {
+	s.chunkSize = chunkSize
+	s.maxRetries = maxRetries
+	s.retryBackoff = retryBackoff
+}
+
 // Upsert inserts a file, or updates if such a file exists.
 func (s *DriveService) Upsert(id string, data io.Reader) (file *client.File, err error) {
 	if file, err = s.Get(id); err != nil {
 		return
 	}
+	// Buffer the contents, so a failed upload can be retried without
+	// re-reading data.
+	contents, err := ioutil.ReadAll(data)
+	if err != nil {
+		return nil, err
+	}
 	if file == nil {
 		file = &client.File{Title: id}
 		file.Parents = []*client.ParentReference{
 			&client.ParentReference{Id: s.parentId},
 		}
 		file.MimeType = MimeTypeCamliBlob
-		return s.apiservice.Files.Insert(file).Media(data).Do()
+		return s.upload(file, "", contents)
 	}
+	return s.upload(file, file.Id, contents)
+}
 
-	// TODO: handle large blobs
-	return s.apiservice.Files.Update(file.Id, file).Media(data).Do()
+// upload sends contents to Drive, as fileId's new contents if fileId
+// is non-empty, as a new file otherwise, honoring the chunk size and
+// retry policy set with ConfigureUploads.
+func (s *DriveService) upload(file *client.File, fileId string, contents []byte) (*client.File, error) {
+	if s.chunkSize > 0 && int64(len(contents)) > s.chunkSize {
+		return s.resumableUpload(file, fileId, contents)
+	}
+	var f *client.File
+	err := s.retry(func() error {
+		var err error
+		if fileId == "" {
+			f, err = s.apiservice.Files.Insert(file).Media(bytes.NewReader(contents)).Do()
+		} else {
+			f, err = s.apiservice.Files.Update(fileId, file).Media(bytes.NewReader(contents)).Do()
+		}
+		return err
+	})
+	return f, err
+}
+
+// resumableUpload sends contents with the Drive resumable upload
+// protocol, one chunk of s.chunkSize bytes per request, so that on a
+// flaky connection a failure only costs retrying one chunk instead of
+// the whole blob. The generated API client only supports single-shot
+// media uploads, so the protocol is spoken directly.
+func (s *DriveService) resumableUpload(file *client.File, fileId string, contents []byte) (*client.File, error) {
+	metadata, err := json.Marshal(file)
+	if err != nil {
+		return nil, err
+	}
+	method, uploadURL := "POST", resumableUploadURL
+	if fileId != "" {
+		method, uploadURL = "PUT", resumableUploadURL+"/"+fileId
+	}
+	var session string
+	err = s.retry(func() error {
+		req, err := http.NewRequest(method, uploadURL+"?uploadType=resumable", bytes.NewReader(metadata))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		req.Header.Set("X-Upload-Content-Type", MimeTypeCamliBlob)
+		req.Header.Set("X-Upload-Content-Length", strconv.Itoa(len(contents)))
+		res, err := s.transport.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("drive: starting resumable upload: %v", res.Status)
+		}
+		session = res.Header.Get("Location")
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	total := int64(len(contents))
+	uploaded := new(client.File)
+	for off := int64(0); off < total; off += s.chunkSize {
+		end := off + s.chunkSize
+		if end > total {
+			end = total
+		}
+		last := end == total
+		err = s.retry(func() error {
+			req, err := http.NewRequest("PUT", session, bytes.NewReader(contents[off:end]))
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Type", MimeTypeCamliBlob)
+			req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", off, end-1, total))
+			res, err := s.transport.RoundTrip(req)
+			if err != nil {
+				return err
+			}
+			defer res.Body.Close()
+			if last {
+				if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+					return fmt.Errorf("drive: uploading final chunk: %v", res.Status)
+				}
+				return json.NewDecoder(res.Body).Decode(uploaded)
+			}
+			if res.StatusCode != statusResumeIncomplete {
+				return fmt.Errorf("drive: uploading chunk at offset %d: %v", off, res.Status)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return uploaded, nil
+}
+
+// retry runs f, retrying failures up to the maximum set with
+// ConfigureUploads, with doubling backoff between attempts.
+func (s *DriveService) retry(f func() error) error {
+	backoff := s.retryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	for try := 0; ; try++ {
+		err := f()
+		if err == nil || try == s.maxRetries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
 }
 
 var errNoDownload = errors.New("file can not be downloaded directly (conversion needed?)")
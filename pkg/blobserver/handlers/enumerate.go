@@ -46,6 +46,29 @@ func handleEnumerateBlobs(rw http.ResponseWriter, req *http.Request, storage blo
 	formValueMaxWaitSec := req.FormValue("maxwaitsec")
 	formValueAfter := req.FormValue("after")
 
+	filter := blobserver.EnumerateFilter{
+		RefPrefix: req.FormValue("refprefix"),
+	}
+	for _, sp := range []struct {
+		name string
+		dst  *uint32
+	}{
+		{"minsize", &filter.MinSize},
+		{"maxsize", &filter.MaxSize},
+	} {
+		v := req.FormValue(sp.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(rw, "Invalid '%s' value.\n", sp.name)
+			return
+		}
+		*sp.dst = uint32(n)
+	}
+
 	maxEnumerate := defaultMaxEnumerate
 	if config, ok := storage.(blobserver.MaxEnumerateConfig); ok {
 		maxEnumerate = config.MaxEnumerate()
@@ -95,7 +118,7 @@ func handleEnumerateBlobs(rw http.ResponseWriter, req *http.Request, storage blo
 		blobch := make(chan blob.SizedRef, 100)
 		resultch := make(chan error, 1)
 		go func() {
-			resultch <- storage.EnumerateBlobs(context.TODO(), blobch, formValueAfter, limit)
+			resultch <- blobserver.EnumerateFilteredBlobs(context.TODO(), storage, blobch, filter, formValueAfter, limit)
 		}()
 
 		gotBlobs := 0
@@ -132,5 +155,6 @@ func handleEnumerateBlobs(rw http.ResponseWriter, req *http.Request, storage blo
 	if longPollSupported {
 		io.WriteString(rw, ",\n  \"canLongPoll\": true")
 	}
+	io.WriteString(rw, ",\n  \"canFilter\": true")
 	io.WriteString(rw, "\n}\n")
 }
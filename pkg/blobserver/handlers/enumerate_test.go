@@ -44,7 +44,7 @@ type enumerateInputTest struct {
 func TestEnumerateInput(t *testing.T) {
 	enumerator := &emptyEnumerator{}
 
-	emptyOutput := "{\n  \"blobs\": [\n\n  ],\n  \"canLongPoll\": true\n}\n"
+	emptyOutput := "{\n  \"blobs\": [\n\n  ],\n  \"canLongPoll\": true,\n  \"canFilter\": true\n}\n"
 
 	tests := []enumerateInputTest{
 		{"no 'after' with 'maxwaitsec'",
@@ -53,6 +53,15 @@ func TestEnumerateInput(t *testing.T) {
 		{"'maxwaitsec' of 0 is okay with 'after'",
 			"http://example.com/camli/enumerate-blobs?after=foo&maxwaitsec=0", 200,
 			emptyOutput},
+		{"filter params are accepted",
+			"http://example.com/camli/enumerate-blobs?refprefix=sha1-0&minsize=10&maxsize=20", 200,
+			emptyOutput},
+		{"non-numeric 'minsize'",
+			"http://example.com/camli/enumerate-blobs?minsize=bogus", 400,
+			"Invalid 'minsize' value.\n"},
+		{"negative 'maxsize'",
+			"http://example.com/camli/enumerate-blobs?maxsize=-1", 400,
+			"Invalid 'maxsize' value.\n"},
 	}
 	for _, test := range tests {
 		wr := httptest.NewRecorder()
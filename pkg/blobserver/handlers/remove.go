@@ -24,6 +24,7 @@ import (
 	"camlistore.org/pkg/blob"
 	"camlistore.org/pkg/blobserver"
 	"camlistore.org/pkg/httputil"
+	"camlistore.org/pkg/maintenance"
 )
 
 const maxRemovesPerRequest = 1000
@@ -50,6 +51,10 @@ func handleRemove(conn http.ResponseWriter, req *http.Request, storage blobserve
 		fmt.Fprintf(conn, "storage does not permit deletes.\n")
 		return
 	}
+	if maintenance.Enabled() {
+		maintenance.Refuse(conn)
+		return
+	}
 
 	n := 0
 	toRemove := make([]blob.Ref, 0)
@@ -0,0 +1,312 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"camlistore.org/pkg/auth"
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/blobserver/protocol"
+	"camlistore.org/pkg/diskcheck"
+	"camlistore.org/pkg/httputil"
+	"camlistore.org/pkg/maintenance"
+)
+
+const (
+	// sessionTTL is how long an idle resumable upload session is
+	// kept around before its spool file is deleted.
+	sessionTTL = 24 * time.Hour
+
+	// sessionCleanInterval is how often expired sessions are
+	// looked for.
+	sessionCleanInterval = time.Hour
+)
+
+// An uploadSession is the server-side state of one resumable upload
+// in progress. The blob's bytes are spooled to a temporary file and
+// only received into storage (and thus made visible to stat and
+// enumerate) at finalize time, once the digest has been verified.
+type uploadSession struct {
+	id   string
+	ref  blob.Ref
+	size uint32
+
+	mu       sync.Mutex // guards following
+	file     *os.File   // spool file
+	offset   int64      // bytes durably written to file
+	lastUsed time.Time
+}
+
+// appendFrom copies src to the spool file at the given offset, which
+// must match the bytes already written. It returns the new durable
+// offset, which may have advanced even on error if part of src was
+// written and synced before the failure.
+func (s *uploadSession) appendFrom(offset int64, src io.Reader) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastUsed = time.Now()
+	if offset != s.offset {
+		return s.offset, errWrongOffset
+	}
+	n, err := io.Copy(s.file, io.LimitReader(src, int64(s.size)-s.offset))
+	if serr := s.file.Sync(); err == nil {
+		err = serr
+	}
+	s.offset += n
+	return s.offset, err
+}
+
+var errWrongOffset = fmt.Errorf("offset doesn't match bytes already received")
+
+// sessions is the package-global registry of resumable upload
+// sessions. It's package-global because the HTTP handlers are
+// recreated on each request.
+var sessions = &sessionStore{
+	byID:  make(map[string]*uploadSession),
+	byRef: make(map[string]*uploadSession),
+}
+
+type sessionStore struct {
+	cleanOnce sync.Once
+
+	mu    sync.Mutex
+	byID  map[string]*uploadSession
+	byRef map[string]*uploadSession // "ref-size" -> session
+}
+
+func refSizeKey(ref blob.Ref, size uint32) string {
+	return fmt.Sprintf("%v-%d", ref, size)
+}
+
+// openOrCreate returns the existing session for the given blob and
+// size, if any, so an interrupted client can resume, or else creates
+// a new one.
+func (st *sessionStore) openOrCreate(ref blob.Ref, size uint32) (*uploadSession, error) {
+	st.cleanOnce.Do(func() { go st.cleanLoop() })
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if s, ok := st.byRef[refSizeKey(ref, size)]; ok {
+		s.mu.Lock()
+		s.lastUsed = time.Now()
+		s.mu.Unlock()
+		return s, nil
+	}
+	f, err := ioutil.TempFile("", "camli-resumable-")
+	if err != nil {
+		return nil, err
+	}
+	s := &uploadSession{
+		id:       auth.RandToken(20),
+		ref:      ref,
+		size:     size,
+		file:     f,
+		lastUsed: time.Now(),
+	}
+	st.byID[s.id] = s
+	st.byRef[refSizeKey(ref, size)] = s
+	return s, nil
+}
+
+func (st *sessionStore) get(id string) *uploadSession {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.byID[id]
+}
+
+// remove forgets the session and deletes its spool file.
+func (st *sessionStore) remove(s *uploadSession) {
+	st.mu.Lock()
+	delete(st.byID, s.id)
+	delete(st.byRef, refSizeKey(s.ref, s.size))
+	st.mu.Unlock()
+	name := s.file.Name()
+	s.file.Close()
+	os.Remove(name)
+}
+
+func (st *sessionStore) cleanLoop() {
+	for {
+		time.Sleep(sessionCleanInterval)
+		var expired []*uploadSession
+		st.mu.Lock()
+		for _, s := range st.byID {
+			s.mu.Lock()
+			if time.Since(s.lastUsed) > sessionTTL {
+				expired = append(expired, s)
+			}
+			s.mu.Unlock()
+		}
+		st.mu.Unlock()
+		for _, s := range expired {
+			log.Printf("Removing expired resumable upload session for %v", s.ref)
+			st.remove(s)
+		}
+	}
+}
+
+// CreateResumableUploadHandler returns the handler that serves the
+// resumable upload protocol extension, by which a client uploads a
+// single large blob incrementally and the server only makes it
+// visible once it's complete and its digest has been verified. See
+// doc/protocol/blob-upload-resume.txt.
+func CreateResumableUploadHandler(storage blobserver.BlobReceiveConfiger) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		handleResumableUpload(rw, req, storage)
+	})
+}
+
+func handleResumableUpload(rw http.ResponseWriter, req *http.Request, storage blobserver.BlobReceiver) {
+	const actionPrefix = "/camli/resumable-upload"
+	idx := strings.Index(req.URL.Path, actionPrefix)
+	if idx == -1 {
+		httputil.BadRequestError(rw, "Inconfigured handler.")
+		return
+	}
+	sessionID := strings.TrimPrefix(req.URL.Path[idx+len(actionPrefix):], "/")
+
+	if sessionID == "" {
+		// Session creation (or retrieval, for a resuming client).
+		if req.Method != "POST" {
+			httputil.BadRequestError(rw, "POST to create an upload session")
+			return
+		}
+		if maintenance.Enabled() {
+			maintenance.Refuse(rw)
+			return
+		}
+		if err := diskcheck.CanWrite(); err != nil {
+			http.Error(rw, err.Error(), http.StatusInsufficientStorage)
+			return
+		}
+		br, ok := blob.Parse(req.FormValue("blob"))
+		if !ok {
+			httputil.BadRequestError(rw, "Missing or invalid 'blob' parameter")
+			return
+		}
+		if !br.IsSupported() {
+			httputil.BadRequestError(rw, "unsupported object hash function")
+			return
+		}
+		size, err := strconv.ParseUint(req.FormValue("size"), 10, 32)
+		if err != nil || size == 0 {
+			httputil.BadRequestError(rw, "Missing or invalid 'size' parameter")
+			return
+		}
+		if size > blobserver.MaxBlobSize {
+			httputil.BadRequestError(rw, "blob too big")
+			return
+		}
+		sess, err := sessions.openOrCreate(br, uint32(size))
+		if err != nil {
+			httputil.ServeError(rw, req, err)
+			return
+		}
+		httputil.ReturnJSON(rw, &protocol.ResumableUploadResponse{
+			Session: sess.id,
+			Offset:  sess.offset,
+		})
+		return
+	}
+
+	sess := sessions.get(sessionID)
+	if sess == nil {
+		http.Error(rw, "no such upload session", http.StatusNotFound)
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+		// Durable offset query.
+		sess.mu.Lock()
+		offset := sess.offset
+		sess.mu.Unlock()
+		httputil.ReturnJSON(rw, &protocol.ResumableUploadResponse{
+			Session: sess.id,
+			Offset:  offset,
+		})
+	case "PUT":
+		offset, err := strconv.ParseInt(req.Header.Get("X-Camli-Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			httputil.BadRequestError(rw, "Missing or invalid X-Camli-Upload-Offset header")
+			return
+		}
+		newOffset, err := sess.appendFrom(offset, req.Body)
+		if err == errWrongOffset {
+			httputil.ReturnJSONCode(rw, http.StatusConflict, &protocol.ResumableUploadResponse{
+				Session: sess.id,
+				Offset:  newOffset,
+			})
+			return
+		}
+		if err != nil {
+			httputil.ServeError(rw, req, err)
+			return
+		}
+		httputil.ReturnJSON(rw, &protocol.ResumableUploadResponse{
+			Session: sess.id,
+			Offset:  newOffset,
+		})
+	case "POST":
+		// Finalize.
+		if req.FormValue("finalize") != "1" {
+			httputil.BadRequestError(rw, "expected finalize=1")
+			return
+		}
+		sess.mu.Lock()
+		complete := sess.offset == int64(sess.size)
+		sess.mu.Unlock()
+		if !complete {
+			httputil.BadRequestError(rw, "upload not complete")
+			return
+		}
+		if _, err := sess.file.Seek(0, os.SEEK_SET); err != nil {
+			httputil.ServeError(rw, req, err)
+			return
+		}
+		sb, err := blobserver.Receive(storage, sess.ref, sess.file)
+		if err == blobserver.ErrCorruptBlob {
+			sessions.remove(sess)
+			httputil.BadRequestError(rw, "data doesn't match declared digest")
+			return
+		}
+		if err != nil {
+			httputil.ServeError(rw, req, err)
+			return
+		}
+		sessions.remove(sess)
+		httputil.ReturnJSON(rw, &protocol.ResumableUploadResponse{
+			Offset: int64(sb.Size),
+			Received: &protocol.RefAndSize{
+				Ref:  sb.Ref,
+				Size: sb.Size,
+			},
+		})
+	default:
+		httputil.BadRequestError(rw, "Unsupported method.")
+	}
+}
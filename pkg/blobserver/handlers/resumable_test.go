@@ -0,0 +1,147 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/blobserver/protocol"
+	"camlistore.org/pkg/test"
+)
+
+type testReceiver struct {
+	*test.Fetcher
+}
+
+func (testReceiver) Config() *blobserver.Config { return nil }
+
+func resumableReq(t *testing.T, h http.Handler, method, path, offset, body string) (int, *protocol.ResumableUploadResponse) {
+	req, err := http.NewRequest(method, "http://example.com"+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method == "POST" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if offset != "" {
+		req.Header.Set("X-Camli-Upload-Offset", offset)
+	}
+	wr := httptest.NewRecorder()
+	wr.Code = 200
+	h.ServeHTTP(wr, req)
+	res := new(protocol.ResumableUploadResponse)
+	if wr.Code == 200 || wr.Code == 409 {
+		if err := json.NewDecoder(wr.Body).Decode(res); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+	return wr.Code, res
+}
+
+func TestResumableUpload(t *testing.T) {
+	sto := testReceiver{new(test.Fetcher)}
+	h := CreateResumableUploadHandler(sto)
+
+	const content = "some large blob contents"
+	br := blob.SHA1FromString(content)
+	startBody := fmt.Sprintf("camliversion=1&blob=%s&size=%d", br, len(content))
+
+	code, res := resumableReq(t, h, "POST", "/camli/resumable-upload", "", startBody)
+	if code != 200 {
+		t.Fatalf("session creation status = %d", code)
+	}
+	if res.Session == "" || res.Offset != 0 {
+		t.Fatalf("session creation response = %+v", res)
+	}
+	sessPath := "/camli/resumable-upload/" + res.Session
+
+	// Re-requesting the session for the same ref+size returns it.
+	if _, res2 := resumableReq(t, h, "POST", "/camli/resumable-upload", "", startBody); res2.Session != res.Session {
+		t.Fatalf("re-request returned session %q; want %q", res2.Session, res.Session)
+	}
+
+	// First half, then a wrong-offset write, then the rest.
+	half := len(content) / 2
+	if code, res := resumableReq(t, h, "PUT", sessPath, "0", content[:half]); code != 200 || res.Offset != int64(half) {
+		t.Fatalf("first half: status %d, offset %d", code, res.Offset)
+	}
+	if code, res := resumableReq(t, h, "PUT", sessPath, "0", content); code != 409 || res.Offset != int64(half) {
+		t.Fatalf("wrong offset: status %d, offset %d; want 409, %d", code, res.Offset, half)
+	}
+	if code, res := resumableReq(t, h, "GET", sessPath, "", ""); code != 200 || res.Offset != int64(half) {
+		t.Fatalf("offset query: status %d, offset %d", code, res.Offset)
+	}
+	if code, _ := resumableReq(t, h, "POST", sessPath, "", "finalize=1"); code != 400 {
+		t.Fatalf("early finalize status = %d; want 400", code)
+	}
+	if code, res := resumableReq(t, h, "PUT", sessPath, fmt.Sprint(half), content[half:]); code != 200 || res.Offset != int64(len(content)) {
+		t.Fatalf("second half: status %d, offset %d", code, res.Offset)
+	}
+
+	// The blob must not be visible before finalize.
+	if sto.NumBlobs() != 0 {
+		t.Fatalf("storage has %d blobs before finalize", sto.NumBlobs())
+	}
+	code, res = resumableReq(t, h, "POST", sessPath, "", "finalize=1")
+	if code != 200 {
+		t.Fatalf("finalize status = %d", code)
+	}
+	if res.Received == nil || res.Received.Ref != br || res.Received.Size != uint32(len(content)) {
+		t.Fatalf("finalize response = %+v", res)
+	}
+	if sto.NumBlobs() != 1 {
+		t.Fatalf("storage has %d blobs after finalize; want 1", sto.NumBlobs())
+	}
+
+	// The session is gone after finalize.
+	if code, _ := resumableReq(t, h, "GET", sessPath, "", ""); code != 404 {
+		t.Fatalf("status after finalize = %d; want 404", code)
+	}
+}
+
+func TestResumableUploadCorrupt(t *testing.T) {
+	sto := testReceiver{new(test.Fetcher)}
+	h := CreateResumableUploadHandler(sto)
+
+	const content = "the real contents"
+	br := blob.SHA1FromString(content)
+	bogus := strings.Replace(content, "real", "fake", 1)
+
+	_, res := resumableReq(t, h, "POST", "/camli/resumable-upload",
+		"", fmt.Sprintf("camliversion=1&blob=%s&size=%d", br, len(bogus)))
+	sessPath := "/camli/resumable-upload/" + res.Session
+	if code, _ := resumableReq(t, h, "PUT", sessPath, "0", bogus); code != 200 {
+		t.Fatalf("upload status = %d", code)
+	}
+	if code, _ := resumableReq(t, h, "POST", sessPath, "", "finalize=1"); code != 400 {
+		t.Fatalf("corrupt finalize status = %d; want 400", code)
+	}
+	if sto.NumBlobs() != 0 {
+		t.Fatalf("storage has %d blobs after corrupt finalize", sto.NumBlobs())
+	}
+	// A corrupt finalize deletes the session so the client starts over.
+	if code, _ := resumableReq(t, h, "GET", sessPath, "", ""); code != 404 {
+		t.Fatalf("status after corrupt finalize = %d; want 404", code)
+	}
+}
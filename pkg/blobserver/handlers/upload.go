@@ -31,8 +31,10 @@ import (
 	"camlistore.org/pkg/blob"
 	"camlistore.org/pkg/blobserver"
 	"camlistore.org/pkg/blobserver/protocol"
+	"camlistore.org/pkg/diskcheck"
 	"camlistore.org/pkg/httputil"
 	"camlistore.org/pkg/jsonsign/signhandler"
+	"camlistore.org/pkg/maintenance"
 	"camlistore.org/pkg/readerutil"
 	"camlistore.org/pkg/schema"
 )
@@ -72,6 +74,14 @@ func CreatePutUploadHandler(storage blobserver.BlobReceiver) http.Handler {
 			httputil.BadRequestError(rw, "unsupported object hash function")
 			return
 		}
+		if maintenance.Enabled() {
+			maintenance.Refuse(rw)
+			return
+		}
+		if err := diskcheck.CanWrite(); err != nil {
+			http.Error(rw, err.Error(), http.StatusInsufficientStorage)
+			return
+		}
 		_, err := blobserver.Receive(storage, br, req.Body)
 		if err == blobserver.ErrCorruptBlob {
 			httputil.BadRequestError(rw, "data doesn't match declared digest")
@@ -176,6 +186,18 @@ func handleMultiPartUpload(rw http.ResponseWriter, req *http.Request, blobReceiv
 		return
 	}
 
+	// Refuse new uploads in maintenance mode, or when a monitored
+	// disk is almost full. The sync handler doesn't go through
+	// HTTP, so syncing to the index keeps working.
+	if maintenance.Enabled() {
+		maintenance.Refuse(rw)
+		return
+	}
+	if err := diskcheck.CanWrite(); err != nil {
+		http.Error(rw, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+
 	receivedBlobs := make([]blob.SizedRef, 0, 10)
 
 	multipart, err := req.MultipartReader()
@@ -0,0 +1,166 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blobserver
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Health tracking of storage backends, shared by the wrappers
+// (replica, proxycache) that can route reads around a failing
+// backend. It implements a simple circuit breaker, keyed by the
+// backend's prefix: after backendFailureThreshold consecutive
+// failures the backend is considered down and BackendUp fast-fails
+// until backendProbeInterval has passed, at which point a single
+// request is let through as a probe.
+
+const backendFailureThreshold = 3
+
+// Vars, not consts, for testing.
+var (
+	backendProbeInterval = 30 * time.Second
+	backendLatencyWindow = 16 // number of recent latencies averaged
+)
+
+// BackendHealth describes the tracked health of one storage backend.
+type BackendHealth struct {
+	Prefix        string    `json:"prefix"`
+	Healthy       bool      `json:"healthy"`
+	Failures      int       `json:"consecutiveFailures,omitempty"`
+	LastError     string    `json:"lastError,omitempty"`
+	LastErrorTime time.Time `json:"lastErrorTime,omitempty"`
+	AvgLatencyMs  int64     `json:"avgLatencyMs,omitempty"`
+}
+
+type backendState struct {
+	failures  int // consecutive
+	openUntil time.Time
+	probing   bool // a half-open probe is in flight
+	lastErr   string
+	lastErrAt time.Time
+	latencies []time.Duration // ring of recent success latencies
+	latencyAt int
+}
+
+func (s *backendState) avgLatency() time.Duration {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, d := range s.latencies {
+		sum += d
+	}
+	return sum / time.Duration(len(s.latencies))
+}
+
+var (
+	backendMu sync.Mutex
+	backends  = map[string]*backendState{}
+)
+
+// RecordBackend records the result of one request to the backend at
+// prefix. A nil err counts as a success; latency is only kept for
+// successes.
+func RecordBackend(prefix string, latency time.Duration, err error) {
+	if prefix == "" {
+		return
+	}
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	s := backends[prefix]
+	if s == nil {
+		s = new(backendState)
+		backends[prefix] = s
+	}
+	s.probing = false
+	if err == nil {
+		s.failures = 0
+		if len(s.latencies) < backendLatencyWindow {
+			s.latencies = append(s.latencies, latency)
+		} else {
+			s.latencies[s.latencyAt%backendLatencyWindow] = latency
+		}
+		s.latencyAt++
+		return
+	}
+	s.failures++
+	s.lastErr = err.Error()
+	s.lastErrAt = time.Now()
+	if s.failures >= backendFailureThreshold {
+		s.openUntil = time.Now().Add(backendProbeInterval)
+	}
+}
+
+// BackendUp reports whether a request to the backend at prefix should
+// be attempted. It returns nil if so, and otherwise an error
+// describing why the backend is considered down. Once the probe
+// interval has passed, a single caller gets a nil answer to probe the
+// backend; its RecordBackend result closes or re-opens the circuit.
+func BackendUp(prefix string) error {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	s := backends[prefix]
+	if s == nil || s.failures < backendFailureThreshold {
+		return nil
+	}
+	if !s.probing && !time.Now().Before(s.openUntil) {
+		s.probing = true
+		return nil
+	}
+	return fmt.Errorf("blobserver: backend %s is down: %s", prefix, s.lastErr)
+}
+
+// BackendHealths returns the health of all tracked backends, sorted
+// by prefix.
+func BackendHealths() []BackendHealth {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	var hs []BackendHealth
+	for prefix, s := range backends {
+		hs = append(hs, BackendHealth{
+			Prefix:        prefix,
+			Healthy:       s.failures < backendFailureThreshold,
+			Failures:      s.failures,
+			LastError:     s.lastErr,
+			LastErrorTime: s.lastErrAt,
+			AvgLatencyMs:  s.avgLatency().Nanoseconds() / 1e6,
+		})
+	}
+	sort.Sort(backendHealthByPrefix(hs))
+	return hs
+}
+
+// ResetBackendHealth forgets the tracked state of the backend at
+// prefix, closing its circuit. An empty prefix resets all backends.
+func ResetBackendHealth(prefix string) {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	if prefix == "" {
+		backends = map[string]*backendState{}
+		return
+	}
+	delete(backends, prefix)
+}
+
+type backendHealthByPrefix []BackendHealth
+
+func (s backendHealthByPrefix) Len() int           { return len(s) }
+func (s backendHealthByPrefix) Less(i, j int) bool { return s[i].Prefix < s[j].Prefix }
+func (s backendHealthByPrefix) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
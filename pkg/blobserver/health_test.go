@@ -0,0 +1,81 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blobserver
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackendHealthCircuitBreaker(t *testing.T) {
+	defer ResetBackendHealth("")
+	defer func(d time.Duration) { backendProbeInterval = d }(backendProbeInterval)
+	backendProbeInterval = 50 * time.Millisecond
+
+	const prefix = "/flaky/"
+	boom := errors.New("boom")
+	if err := BackendUp(prefix); err != nil {
+		t.Fatalf("untracked backend reported down: %v", err)
+	}
+	for i := 0; i < backendFailureThreshold; i++ {
+		if err := BackendUp(prefix); err != nil {
+			t.Fatalf("backend down after only %d failures: %v", i, err)
+		}
+		RecordBackend(prefix, 0, boom)
+	}
+	if err := BackendUp(prefix); err == nil {
+		t.Fatalf("backend still up after %d consecutive failures", backendFailureThreshold)
+	}
+	hs := BackendHealths()
+	if len(hs) != 1 || hs[0].Prefix != prefix || hs[0].Healthy || hs[0].LastError != "boom" {
+		t.Errorf("BackendHealths = %+v; want one unhealthy %s entry", hs, prefix)
+	}
+
+	// After the probe interval, exactly one request gets through.
+	time.Sleep(2 * backendProbeInterval)
+	if err := BackendUp(prefix); err != nil {
+		t.Fatalf("no half-open probe allowed after probe interval: %v", err)
+	}
+	if err := BackendUp(prefix); err == nil {
+		t.Fatal("second request allowed while a probe is in flight")
+	}
+	// A failed probe re-opens the circuit.
+	RecordBackend(prefix, 0, boom)
+	if err := BackendUp(prefix); err == nil {
+		t.Fatal("backend up right after a failed probe")
+	}
+
+	// A successful probe closes it.
+	time.Sleep(2 * backendProbeInterval)
+	if err := BackendUp(prefix); err != nil {
+		t.Fatalf("no probe allowed after second probe interval: %v", err)
+	}
+	RecordBackend(prefix, time.Millisecond, nil)
+	if err := BackendUp(prefix); err != nil {
+		t.Errorf("backend still down after successful probe: %v", err)
+	}
+
+	// Manual reset while down.
+	for i := 0; i < backendFailureThreshold; i++ {
+		RecordBackend(prefix, 0, boom)
+	}
+	ResetBackendHealth(prefix)
+	if err := BackendUp(prefix); err != nil {
+		t.Errorf("backend still down after reset: %v", err)
+	}
+}
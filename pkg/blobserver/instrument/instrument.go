@@ -0,0 +1,265 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package instrument provides a blobserver.Storage wrapper which
+// records per-operation latency histograms and logs slow operations.
+// serverinit wraps every configured storage handler with it, so the
+// metrics come for free for all storage types. The histograms are
+// published as expvars and summarized on /status/.
+package instrument
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/context"
+)
+
+// nBuckets is the number of histogram buckets. Bucket i counts
+// operations that took less than 2^i milliseconds; the last bucket
+// counts everything slower.
+const nBuckets = 16
+
+// opStats accumulates the latency histogram of one operation type.
+// All fields are updated atomically, so recording an operation
+// neither allocates nor takes a lock.
+type opStats struct {
+	count   int64
+	totalNs int64
+	slow    int64
+	buckets [nBuckets]int64
+}
+
+func (s *opStats) record(d time.Duration) {
+	atomic.AddInt64(&s.count, 1)
+	atomic.AddInt64(&s.totalNs, int64(d))
+	ms := int64(d / time.Millisecond)
+	i := 0
+	for ; i < nBuckets-1; i++ {
+		if ms < 1<<uint(i) {
+			break
+		}
+	}
+	atomic.AddInt64(&s.buckets[i], 1)
+}
+
+// quantile returns the upper bound, in milliseconds, of the bucket
+// holding the q'th (0 < q < 1) fraction of recorded operations.
+func (s *opStats) quantile(q float64) int64 {
+	count := atomic.LoadInt64(&s.count)
+	if count == 0 {
+		return 0
+	}
+	want := int64(q*float64(count)) + 1
+	var seen int64
+	for i := 0; i < nBuckets; i++ {
+		seen += atomic.LoadInt64(&s.buckets[i])
+		if seen >= want {
+			return 1 << uint(i)
+		}
+	}
+	return 1 << uint(nBuckets-1)
+}
+
+// OpSummary is one row of the summary table reported on /status/.
+type OpSummary struct {
+	Op      string  `json:"op"`
+	Count   int64   `json:"count"`
+	AvgMs   float64 `json:"avgMs"`
+	P50Ms   int64   `json:"p50Ms"` // upper bounds of histogram buckets
+	P90Ms   int64   `json:"p90Ms"`
+	P99Ms   int64   `json:"p99Ms"`
+	SlowOps int64   `json:"slowOps"`
+	// Buckets[i] counts operations under 2^i ms; the last one
+	// counts the rest.
+	Buckets []int64 `json:"buckets"`
+}
+
+func (s *opStats) summary(op string) OpSummary {
+	os := OpSummary{
+		Op:      op,
+		Count:   atomic.LoadInt64(&s.count),
+		SlowOps: atomic.LoadInt64(&s.slow),
+		P50Ms:   s.quantile(0.50),
+		P90Ms:   s.quantile(0.90),
+		P99Ms:   s.quantile(0.99),
+		Buckets: make([]int64, nBuckets),
+	}
+	if os.Count > 0 {
+		os.AvgMs = float64(atomic.LoadInt64(&s.totalNs)) / float64(os.Count) / float64(time.Millisecond)
+	}
+	for i := range os.Buckets {
+		os.Buckets[i] = atomic.LoadInt64(&s.buckets[i])
+	}
+	return os
+}
+
+// Status is the value reported on /status/ for an instrumented
+// storage.
+type Status struct {
+	Prefix string      `json:"prefix"`
+	Ops    []OpSummary `json:"ops"`
+	// Impl is the wrapped storage's own status, if it reports one.
+	Impl interface{} `json:"impl,omitempty"`
+}
+
+type sto struct {
+	prefix string
+	target blobserver.Storage
+	slow   time.Duration // log ops taking at least this long; 0 means never
+
+	fetch     opStats
+	receive   opStats
+	stat      opStats
+	enumerate opStats
+	remove    opStats
+}
+
+// NewStorage wraps target, recording the latency of its operations
+// under the given prefix. Operations taking at least slow are logged;
+// a zero slow threshold disables the logging.
+func NewStorage(prefix string, target blobserver.Storage, slow time.Duration) blobserver.Storage {
+	s := &sto{
+		prefix: prefix,
+		target: target,
+		slow:   slow,
+	}
+	name := "blobserver-latency-" + prefix
+	if expvar.Get(name) == nil {
+		expvar.Publish(name, expvar.Func(func() interface{} { return s.summaries() }))
+	}
+	return s
+}
+
+func (s *sto) summaries() []OpSummary {
+	return []OpSummary{
+		s.fetch.summary("fetch"),
+		s.receive.summary("receive"),
+		s.stat.summary("stat"),
+		s.enumerate.summary("enumerate"),
+		s.remove.summary("remove"),
+	}
+}
+
+// done records one finished operation. The ref function builds the
+// logged ref lazily, so the fast path doesn't allocate its string.
+func (s *sto) done(st *opStats, op string, t0 time.Time, ref blob.Ref) {
+	d := time.Since(t0)
+	st.record(d)
+	if s.slow > 0 && d >= s.slow {
+		atomic.AddInt64(&st.slow, 1)
+		if ref.Valid() {
+			log.Printf("instrument: slow %s of %v on %s: %v", op, ref, s.prefix, d)
+		} else {
+			log.Printf("instrument: slow %s on %s: %v", op, s.prefix, d)
+		}
+	}
+}
+
+func (s *sto) Fetch(b blob.Ref) (io.ReadCloser, uint32, error) {
+	t0 := time.Now()
+	rc, size, err := s.target.Fetch(b)
+	s.done(&s.fetch, "fetch", t0, b)
+	return rc, size, err
+}
+
+func (s *sto) ReceiveBlob(br blob.Ref, src io.Reader) (blob.SizedRef, error) {
+	t0 := time.Now()
+	sb, err := s.target.ReceiveBlob(br, src)
+	s.done(&s.receive, "receive", t0, br)
+	return sb, err
+}
+
+func (s *sto) StatBlobs(dest chan<- blob.SizedRef, blobs []blob.Ref) error {
+	t0 := time.Now()
+	err := s.target.StatBlobs(dest, blobs)
+	s.done(&s.stat, "stat", t0, blob.Ref{})
+	return err
+}
+
+func (s *sto) EnumerateBlobs(ctx *context.Context, dest chan<- blob.SizedRef, after string, limit int) error {
+	t0 := time.Now()
+	err := s.target.EnumerateBlobs(ctx, dest, after, limit)
+	s.done(&s.enumerate, "enumerate", t0, blob.Ref{})
+	return err
+}
+
+func (s *sto) RemoveBlobs(blobs []blob.Ref) error {
+	t0 := time.Now()
+	err := s.target.RemoveBlobs(blobs)
+	s.done(&s.remove, "remove", t0, blob.Ref{})
+	return err
+}
+
+// The optional blobserver interfaces below forward to the wrapped
+// storage when it implements them, in the style of the cond storage.
+
+func (s *sto) Config() *blobserver.Config {
+	if c, ok := s.target.(blobserver.Configer); ok {
+		return c.Config()
+	}
+	// Conservative defaults (e.g. not deletable) for targets with
+	// no config of their own.
+	return &blobserver.Config{}
+}
+
+func (s *sto) StorageGeneration() (initTime time.Time, random string, err error) {
+	if gener, ok := s.target.(blobserver.Generationer); ok {
+		return gener.StorageGeneration()
+	}
+	err = blobserver.GenerationNotSupportedError(fmt.Sprintf("blobserver.Generationer not implemented on %T", s.target))
+	return
+}
+
+func (s *sto) ResetStorageGeneration() error {
+	if gener, ok := s.target.(blobserver.Generationer); ok {
+		return gener.ResetStorageGeneration()
+	}
+	return blobserver.GenerationNotSupportedError(fmt.Sprintf("blobserver.Generationer not implemented on %T", s.target))
+}
+
+func (s *sto) MaxEnumerate() int {
+	if mec, ok := s.target.(blobserver.MaxEnumerateConfig); ok {
+		return mec.MaxEnumerate()
+	}
+	// Same default as the enumerate handler uses for storages
+	// that don't implement MaxEnumerateConfig.
+	return 10000
+}
+
+func (s *sto) Close() error {
+	if cl, ok := s.target.(blobserver.ShutdownStorage); ok {
+		return cl.Close()
+	}
+	return nil
+}
+
+func (s *sto) StorageStatus() interface{} {
+	st := &Status{
+		Prefix: s.prefix,
+		Ops:    s.summaries(),
+	}
+	if sr, ok := s.target.(blobserver.StorageStatusReporter); ok {
+		st.Impl = sr.StorageStatus()
+	}
+	return st
+}
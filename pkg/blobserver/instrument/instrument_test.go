@@ -0,0 +1,117 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrument
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/context"
+)
+
+// nopStorage is the cheapest possible Storage, so benchmarks below
+// measure mostly the instrumentation overhead.
+type nopStorage struct{}
+
+var emptyBody = ioutil.NopCloser(strings.NewReader(""))
+
+func (nopStorage) Fetch(blob.Ref) (io.ReadCloser, uint32, error) {
+	return emptyBody, 0, nil
+}
+func (nopStorage) ReceiveBlob(br blob.Ref, src io.Reader) (blob.SizedRef, error) {
+	return blob.SizedRef{Ref: br}, nil
+}
+func (nopStorage) StatBlobs(dest chan<- blob.SizedRef, blobs []blob.Ref) error { return nil }
+func (nopStorage) EnumerateBlobs(ctx *context.Context, dest chan<- blob.SizedRef, after string, limit int) error {
+	close(dest)
+	return nil
+}
+func (nopStorage) RemoveBlobs(blobs []blob.Ref) error { return nil }
+
+func TestHistogram(t *testing.T) {
+	var st opStats
+	for _, ms := range []int64{0, 1, 3, 900, 100000} {
+		st.record(time.Duration(ms) * time.Millisecond)
+	}
+	sum := st.summary("fetch")
+	if sum.Count != 5 {
+		t.Errorf("count = %d; want 5", sum.Count)
+	}
+	for i, want := range map[int]int64{0: 1, 1: 1, 2: 1, 10: 1, nBuckets - 1: 1} {
+		if got := sum.Buckets[i]; got != want {
+			t.Errorf("bucket %d = %d; want %d", i, got, want)
+		}
+	}
+	if sum.P50Ms > sum.P90Ms || sum.P90Ms > sum.P99Ms {
+		t.Errorf("quantiles not monotonic: p50=%d p90=%d p99=%d", sum.P50Ms, sum.P90Ms, sum.P99Ms)
+	}
+}
+
+func TestWrappedOps(t *testing.T) {
+	s := NewStorage("/test-wrapped/", nopStorage{}, 0).(*sto)
+	br := blob.SHA1FromString("foo")
+	if _, _, err := s.Fetch(br); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blobserver.Receive(s, br, strings.NewReader("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.StatBlobs(make(chan blob.SizedRef, 1), []blob.Ref{br}); err != nil {
+		t.Fatal(err)
+	}
+	for _, sum := range s.summaries() {
+		want := int64(0)
+		switch sum.Op {
+		case "fetch", "receive", "stat":
+			want = 1
+		}
+		if sum.Count != want {
+			t.Errorf("%s count = %d; want %d", sum.Op, sum.Count, want)
+		}
+	}
+	st := s.StorageStatus().(*Status)
+	if st.Prefix != "/test-wrapped/" || len(st.Ops) != 5 {
+		t.Errorf("bad status: %+v", st)
+	}
+}
+
+func BenchmarkRecord(b *testing.B) {
+	b.ReportAllocs()
+	var st opStats
+	for i := 0; i < b.N; i++ {
+		st.record(time.Millisecond)
+	}
+}
+
+func BenchmarkFetch(b *testing.B) {
+	b.ReportAllocs()
+	s := NewStorage("/bench-fetch/", nopStorage{}, 0)
+	br := blob.SHA1FromString("foo")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rc, _, err := s.Fetch(br)
+		if err != nil {
+			b.Fatal(err)
+		}
+		rc.Close()
+	}
+}
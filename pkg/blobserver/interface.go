@@ -21,6 +21,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"camlistore.org/pkg/blob"
@@ -96,6 +97,55 @@ type BlobEnumerator interface {
 		limit int) error
 }
 
+// An EnumerateFilter restricts which blobs an enumeration returns.
+// The zero value matches all blobs.
+type EnumerateFilter struct {
+	// RefPrefix, if non-empty, restricts the enumeration to
+	// blobrefs whose string form starts with it (e.g. "sha1-0f").
+	RefPrefix string
+
+	// MinSize and MaxSize, if non-zero, restrict the enumeration
+	// to blobs of at least and at most that many bytes,
+	// respectively.
+	MinSize uint32
+	MaxSize uint32
+}
+
+// IsZero reports whether f places no restriction on an enumeration.
+func (f EnumerateFilter) IsZero() bool { return f == EnumerateFilter{} }
+
+// Matches reports whether sb satisfies f.
+func (f EnumerateFilter) Matches(sb blob.SizedRef) bool {
+	if f.RefPrefix != "" && !strings.HasPrefix(sb.Ref.String(), f.RefPrefix) {
+		return false
+	}
+	if f.MinSize != 0 && sb.Size < f.MinSize {
+		return false
+	}
+	if f.MaxSize != 0 && sb.Size > f.MaxSize {
+		return false
+	}
+	return true
+}
+
+// FilteredEnumerator is an optional interface implemented by
+// BlobEnumerators for which a filter makes enumeration cheaper than
+// scanning everything: index prefix scans, directory pruning, remote
+// list prefixes. Callers should use blobserver.EnumerateFilteredBlobs,
+// which emulates filtering for enumerators not implementing it.
+type FilteredEnumerator interface {
+	BlobEnumerator
+
+	// EnumerateFilteredBlobs is like EnumerateBlobs, but sends
+	// only blobs matching filter. limit counts blobs sent, not
+	// blobs considered.
+	EnumerateFilteredBlobs(ctx *context.Context,
+		dest chan<- blob.SizedRef,
+		filter EnumerateFilter,
+		after string,
+		limit int) error
+}
+
 type BlobStreamer interface {
 	// StreamBlobs sends blobs to dest in unspecified order. It is
 	// expected that a blobstorage implementing BlobStreamer will
@@ -226,3 +276,15 @@ type MaxEnumerateConfig interface {
 	// capable of enumerating at once.
 	MaxEnumerate() int
 }
+
+// StorageStatusReporter is an optional interface implemented by
+// Storage implementations to report implementation-specific status
+// (such as the outcome of startup consistency checks) on the server's
+// status page.
+type StorageStatusReporter interface {
+	Storage
+
+	// StorageStatus returns a JSON-marshalable value summarizing
+	// the storage's status.
+	StorageStatus() interface{}
+}
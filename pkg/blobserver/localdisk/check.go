@@ -0,0 +1,92 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package localdisk
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// layoutStatus summarizes the startup check of the on-disk directory
+// layout. It's reported on the server's status page.
+type layoutStatus struct {
+	// LayoutVersion is the shard directory name width of the
+	// current layout (2, as in sha1/xx/yy/sha1-xxyy...dat).
+	LayoutVersion int      `json:"layoutVersion"`
+	ShardDirs     int      `json:"shardDirs"`
+	Errors        []string `json:"errors,omitempty"`
+}
+
+func (st *layoutStatus) errorf(format string, args ...interface{}) {
+	s := fmt.Sprintf(format, args...)
+	log.Printf("localdisk: layout: %s", s)
+	st.Errors = append(st.Errors, s)
+}
+
+// startupCheck verifies that the directory layout under root matches
+// the current layout version, catching partial restores or interrupted
+// migrations. It only reads the top two directory levels, so it's
+// cheap even for large stores.
+func (ds *DiskStorage) startupCheck() *layoutStatus {
+	st := &layoutStatus{LayoutVersion: 2}
+	f, err := os.Open(ds.root)
+	if err != nil {
+		st.errorf("opening root %s: %v", ds.root, err)
+		return st
+	}
+	hashDirs, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		st.errorf("reading root %s: %v", ds.root, err)
+		return st
+	}
+	for _, hashDir := range hashDirs {
+		full := filepath.Join(ds.root, hashDir)
+		fi, err := os.Stat(full)
+		if err != nil || !fi.IsDir() || hashDir == "partition" || hashDir == "cache" {
+			continue
+		}
+		hf, err := os.Open(full)
+		if err != nil {
+			st.errorf("opening %s: %v", full, err)
+			continue
+		}
+		shards, err := hf.Readdirnames(-1)
+		hf.Close()
+		if err != nil {
+			st.errorf("reading %s: %v", full, err)
+			continue
+		}
+		for _, shard := range shards {
+			switch len(shard) {
+			case 2:
+				st.ShardDirs++
+			case 3:
+				st.errorf("%s uses the old 3-character shard layout; migration incomplete?", filepath.Join(full, shard))
+			}
+		}
+	}
+	return st
+}
+
+// StorageStatus returns the startup layout check's result for the
+// status handler.
+func (ds *DiskStorage) StorageStatus() interface{} {
+	return ds.layout
+}
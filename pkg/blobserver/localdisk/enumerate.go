@@ -25,6 +25,7 @@ import (
 	"strings"
 
 	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
 	"camlistore.org/pkg/context"
 )
 
@@ -34,6 +35,7 @@ type readBlobRequest struct {
 	after   string
 	remain  *int // limit countdown
 	dirRoot string
+	filter  blobserver.EnumerateFilter // zero value means all blobs
 
 	// Not used on initial request, only on recursion
 	blobPrefix, pathInto string
@@ -132,6 +134,15 @@ func (ds *DiskStorage) readBlobs(opts readBlobRequest) error {
 					continue
 				}
 			}
+			if p := opts.filter.RefPrefix; p != "" {
+				compareLen := len(newBlobPrefix)
+				if len(p) < compareLen {
+					compareLen = len(p)
+				}
+				if newBlobPrefix[:compareLen] != p[:compareLen] {
+					continue
+				}
+			}
 			ropts := opts
 			ropts.blobPrefix = newBlobPrefix
 			ropts.pathInto = opts.pathInto + "/" + name
@@ -152,8 +163,12 @@ func (ds *DiskStorage) readBlobs(opts readBlobRequest) error {
 				continue
 			}
 			if blobRef, ok := blob.Parse(blobName); ok {
+				sb := blob.SizedRef{Ref: blobRef, Size: uint32(fi.Size())}
+				if !opts.filter.Matches(sb) {
+					continue
+				}
 				select {
-				case opts.ch <- blob.SizedRef{Ref: blobRef, Size: uint32(fi.Size())}:
+				case opts.ch <- sb:
 				case <-opts.done:
 					return context.ErrCanceled
 				}
@@ -167,6 +182,13 @@ func (ds *DiskStorage) readBlobs(opts readBlobRequest) error {
 }
 
 func (ds *DiskStorage) EnumerateBlobs(ctx *context.Context, dest chan<- blob.SizedRef, after string, limit int) error {
+	return ds.EnumerateFilteredBlobs(ctx, dest, blobserver.EnumerateFilter{}, after, limit)
+}
+
+// EnumerateFilteredBlobs implements blobserver.FilteredEnumerator,
+// pruning shard directories that can't contain the filter's blobref
+// prefix.
+func (ds *DiskStorage) EnumerateFilteredBlobs(ctx *context.Context, dest chan<- blob.SizedRef, filter blobserver.EnumerateFilter, after string, limit int) error {
 	defer close(dest)
 	if limit == 0 {
 		log.Printf("Warning: localdisk.EnumerateBlobs called with a limit of 0")
@@ -179,6 +201,7 @@ func (ds *DiskStorage) EnumerateBlobs(ctx *context.Context, dest chan<- blob.Siz
 		dirRoot: ds.root,
 		after:   after,
 		remain:  &limitMutable,
+		filter:  filter,
 	})
 }
 
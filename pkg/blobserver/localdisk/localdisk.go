@@ -56,6 +56,8 @@ type DiskStorage struct {
 
 	// gen will be nil if partition != ""
 	gen *local.Generationer
+
+	layout *layoutStatus // result of the startup layout check
 }
 
 func (ds *DiskStorage) String() string {
@@ -92,6 +94,7 @@ func New(root string) (*DiskStorage, error) {
 	if err := ds.migrate3to2(); err != nil {
 		return nil, fmt.Errorf("Error updating localdisk format: %v", err)
 	}
+	ds.layout = ds.startupCheck()
 	if _, _, err := ds.StorageGeneration(); err != nil {
 		return nil, fmt.Errorf("Error initialization generation for %q: %v", root, err)
 	}
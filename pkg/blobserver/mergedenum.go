@@ -48,7 +48,8 @@ func MergedEnumerate(ctx *context.Context, dest chan<- blob.SizedRef, sources []
 
 	nSent := 0
 	lastSent := ""
-	for nSent < limit {
+	canceled := false
+	for nSent < limit && !canceled {
 		lowestIdx := -1
 		var lowest blob.SizedRef
 		for idx, peeker := range peekers {
@@ -69,9 +70,13 @@ func MergedEnumerate(ctx *context.Context, dest chan<- blob.SizedRef, sources []
 			break
 		}
 
-		dest <- lowest
-		nSent++
-		lastSent = lowest.Ref.String()
+		select {
+		case dest <- lowest:
+			nSent++
+			lastSent = lowest.Ref.String()
+		case <-ctx.Done():
+			canceled = true
+		}
 	}
 
 	// Once we've gotten enough, ignore the rest of whatever's
@@ -87,5 +92,8 @@ func MergedEnumerate(ctx *context.Context, dest chan<- blob.SizedRef, sources []
 			retErr = err
 		}
 	}
+	if canceled {
+		retErr = context.ErrCanceled
+	}
 	return retErr
 }
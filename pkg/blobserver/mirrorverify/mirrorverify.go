@@ -0,0 +1,351 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package mirrorverify registers the "mirror-verify" blobserver storage
+type, a wrapper for soaking a new storage backend before cutting over
+to it. All writes go to both the primary and the shadow (shadow
+failures are logged and counted, not fatal), a configurable fraction
+of reads is also fetched from the shadow and byte-compared, and
+enumerations of the two sides are diffed periodically, a window at a
+time. The agreement statistics appear in the status handler's JSON,
+and cutting over is just a config change replacing the wrapper with
+the shadow.
+
+Example config:
+
+      "/bs/": {
+          "handler": "storage-mirror-verify",
+          "handlerArgs": {
+              "primary": "/old-bs/",
+              "shadow": "/new-bs/",
+              "readCheckPercent": 10,
+              "enumerateCheckInterval": "1h"
+          }
+      },
+*/
+package mirrorverify
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/camlog"
+	"camlistore.org/pkg/context"
+	"camlistore.org/pkg/jsonconfig"
+)
+
+var mirrorLog = camlog.New("mirror-verify")
+
+// enumSampleSize is how many blobs each periodic enumerate check
+// compares, advancing a rotating cursor so repeated runs cover the
+// whole keyspace.
+const enumSampleSize = 1000
+
+type mirrorVerify struct {
+	primaryPrefix, shadowPrefix string
+	primary, shadow             blobserver.Storage
+	readCheckPercent            int           // of reads also fetched from the shadow and compared
+	enumInterval                time.Duration // between enumerate checks; 0 means disabled
+
+	mu     sync.Mutex
+	cursor string // "after" value of the next enumerate check
+	stats  Status
+}
+
+// Status is the wrapper's agreement statistics, as reported in the
+// status handler's JSON. All counts are since the server started.
+type Status struct {
+	Primary          string `json:"primary"`
+	Shadow           string `json:"shadow"`
+	ReadCheckPercent int    `json:"readCheckPercent"`
+
+	Writes               int64 `json:"writes"`
+	ShadowWriteFailures  int64 `json:"shadowWriteFailures"`
+	Removes              int64 `json:"removes"`
+	ShadowRemoveFailures int64 `json:"shadowRemoveFailures"`
+
+	ReadChecks          int64 `json:"readChecks"`
+	ReadCheckMismatches int64 `json:"readCheckMismatches"`
+	ReadCheckMissing    int64 `json:"readCheckMissing"`
+	ReadCheckErrors     int64 `json:"readCheckErrors"`
+
+	EnumerateRuns           int64  `json:"enumerateRuns"`
+	EnumerateChecked        int64  `json:"enumerateChecked"`
+	EnumerateMissingShadow  int64  `json:"enumerateMissingShadow"`
+	EnumerateExtraShadow    int64  `json:"enumerateExtraShadow"`
+	EnumerateSizeMismatches int64  `json:"enumerateSizeMismatches"`
+	LastEnumerate           string `json:"lastEnumerate,omitempty"` // RFC 3339
+}
+
+func newFromConfig(ld blobserver.Loader, config jsonconfig.Obj) (blobserver.Storage, error) {
+	sto := &mirrorVerify{
+		primaryPrefix:    config.RequiredString("primary"),
+		shadowPrefix:     config.RequiredString("shadow"),
+		readCheckPercent: config.OptionalInt("readCheckPercent", 10),
+	}
+	intervalStr := config.OptionalString("enumerateCheckInterval", "1h")
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if sto.readCheckPercent < 0 || sto.readCheckPercent > 100 {
+		return nil, fmt.Errorf("mirror-verify: \"readCheckPercent\" must be between 0 and 100; got %d", sto.readCheckPercent)
+	}
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil {
+		return nil, fmt.Errorf("mirror-verify: bad \"enumerateCheckInterval\" %q: %v", intervalStr, err)
+	}
+	if interval < 0 {
+		return nil, fmt.Errorf("mirror-verify: negative \"enumerateCheckInterval\" %q", intervalStr)
+	}
+	sto.enumInterval = interval
+	if sto.primary, err = ld.GetStorage(sto.primaryPrefix); err != nil {
+		return nil, err
+	}
+	if sto.shadow, err = ld.GetStorage(sto.shadowPrefix); err != nil {
+		return nil, err
+	}
+	if sto.enumInterval > 0 {
+		go sto.enumerateLoop()
+	}
+	return sto, nil
+}
+
+func (sto *mirrorVerify) ReceiveBlob(br blob.Ref, src io.Reader) (blob.SizedRef, error) {
+	// Slurp the whole blob before mirroring. Bounded by 16 MB anyway.
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, src); err != nil {
+		return blob.SizedRef{}, err
+	}
+	sb, err := blobserver.Receive(sto.primary, br, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return blob.SizedRef{}, err
+	}
+	sto.mu.Lock()
+	sto.stats.Writes++
+	sto.mu.Unlock()
+	// The primary verified the hash above, so ReceiveNoHash suffices.
+	if _, err := blobserver.ReceiveNoHash(sto.shadow, br, bytes.NewReader(buf.Bytes())); err != nil {
+		sto.mu.Lock()
+		sto.stats.ShadowWriteFailures++
+		sto.mu.Unlock()
+		mirrorLog.Printf("shadow %s: writing %v: %v", sto.shadowPrefix, br, err)
+	}
+	return sb, nil
+}
+
+func (sto *mirrorVerify) Fetch(br blob.Ref) (io.ReadCloser, uint32, error) {
+	rc, size, err := sto.primary.Fetch(br)
+	if err != nil || rand.Intn(100) >= sto.readCheckPercent {
+		return rc, size, err
+	}
+	// This read is sampled for cross-checking: slurp it so the
+	// shadow comparison can run without re-fetching the primary.
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, 0, err
+	}
+	go sto.checkShadowRead(br, data)
+	return ioutil.NopCloser(bytes.NewReader(data)), size, nil
+}
+
+// checkShadowRead fetches br from the shadow and compares its bytes
+// with what the primary returned.
+func (sto *mirrorVerify) checkShadowRead(br blob.Ref, want []byte) {
+	sto.mu.Lock()
+	sto.stats.ReadChecks++
+	sto.mu.Unlock()
+	rc, _, err := sto.shadow.Fetch(br)
+	if err != nil {
+		sto.mu.Lock()
+		if err == os.ErrNotExist {
+			sto.stats.ReadCheckMissing++
+		} else {
+			sto.stats.ReadCheckErrors++
+		}
+		sto.mu.Unlock()
+		mirrorLog.Printf("shadow %s: reading %v: %v", sto.shadowPrefix, br, err)
+		return
+	}
+	defer rc.Close()
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		sto.mu.Lock()
+		sto.stats.ReadCheckErrors++
+		sto.mu.Unlock()
+		mirrorLog.Printf("shadow %s: reading %v: %v", sto.shadowPrefix, br, err)
+		return
+	}
+	if !bytes.Equal(got, want) {
+		sto.mu.Lock()
+		sto.stats.ReadCheckMismatches++
+		sto.mu.Unlock()
+		mirrorLog.Printf("shadow %s: %v differs from primary %s (%d bytes vs %d)",
+			sto.shadowPrefix, br, sto.primaryPrefix, len(got), len(want))
+	}
+}
+
+func (sto *mirrorVerify) StatBlobs(dest chan<- blob.SizedRef, blobs []blob.Ref) error {
+	return sto.primary.StatBlobs(dest, blobs)
+}
+
+func (sto *mirrorVerify) EnumerateBlobs(ctx *context.Context, dest chan<- blob.SizedRef, after string, limit int) error {
+	return sto.primary.EnumerateBlobs(ctx, dest, after, limit)
+}
+
+func (sto *mirrorVerify) RemoveBlobs(blobs []blob.Ref) error {
+	err := sto.primary.RemoveBlobs(blobs)
+	if err != nil {
+		return err
+	}
+	sto.mu.Lock()
+	sto.stats.Removes++
+	sto.mu.Unlock()
+	if err := sto.shadow.RemoveBlobs(blobs); err != nil {
+		sto.mu.Lock()
+		sto.stats.ShadowRemoveFailures++
+		sto.mu.Unlock()
+		mirrorLog.Printf("shadow %s: removing %d blobs: %v", sto.shadowPrefix, len(blobs), err)
+	}
+	return nil
+}
+
+func (sto *mirrorVerify) enumerateLoop() {
+	for {
+		time.Sleep(sto.enumInterval)
+		sto.enumerateCheck()
+	}
+}
+
+// enumerateCheck diffs the next enumSampleSize blobs of the primary
+// and the shadow, starting at the rotating cursor.
+func (sto *mirrorVerify) enumerateCheck() {
+	sto.mu.Lock()
+	after := sto.cursor
+	sto.mu.Unlock()
+
+	p, pDone, err := enumerateUpTo(sto.primary, after, enumSampleSize)
+	if err != nil {
+		mirrorLog.Printf("primary %s: enumerate after %q: %v", sto.primaryPrefix, after, err)
+		return
+	}
+	s, sDone, err := enumerateUpTo(sto.shadow, after, enumSampleSize)
+	if err != nil {
+		mirrorLog.Printf("shadow %s: enumerate after %q: %v", sto.shadowPrefix, after, err)
+		return
+	}
+
+	var checked, missing, extra, sizes int64
+	i, j := 0, 0
+	for i < len(p) && j < len(s) {
+		a, b := p[i], s[j]
+		switch {
+		case a.Ref == b.Ref:
+			checked++
+			if a.Size != b.Size {
+				sizes++
+				mirrorLog.Printf("shadow %s: %v has size %d; primary has %d", sto.shadowPrefix, b.Ref, b.Size, a.Size)
+			}
+			i++
+			j++
+		case a.Ref.Less(b.Ref):
+			checked++
+			missing++
+			mirrorLog.Printf("shadow %s: missing %v", sto.shadowPrefix, a.Ref)
+			i++
+		default:
+			extra++
+			mirrorLog.Printf("shadow %s: has %v; primary doesn't", sto.shadowPrefix, b.Ref)
+			j++
+		}
+	}
+	// Leftovers on one side only mean something if the other side
+	// was fully enumerated; otherwise they're beyond its window.
+	if sDone {
+		for ; i < len(p); i++ {
+			checked++
+			missing++
+			mirrorLog.Printf("shadow %s: missing %v", sto.shadowPrefix, p[i].Ref)
+		}
+	}
+	if pDone {
+		for ; j < len(s); j++ {
+			extra++
+			mirrorLog.Printf("shadow %s: has %v; primary doesn't", sto.shadowPrefix, s[j].Ref)
+		}
+	}
+
+	sto.mu.Lock()
+	defer sto.mu.Unlock()
+	sto.stats.EnumerateRuns++
+	sto.stats.EnumerateChecked += checked
+	sto.stats.EnumerateMissingShadow += missing
+	sto.stats.EnumerateExtraShadow += extra
+	sto.stats.EnumerateSizeMismatches += sizes
+	sto.stats.LastEnumerate = time.Now().UTC().Format(time.RFC3339)
+	if pDone {
+		sto.cursor = "" // wrapped around; start over next time
+	} else {
+		sto.cursor = p[len(p)-1].Ref.String()
+	}
+}
+
+// enumerateUpTo returns up to limit blobs of s following after, and
+// whether s's enumeration was exhausted before the limit.
+func enumerateUpTo(s blobserver.Storage, after string, limit int) (blobs []blob.SizedRef, done bool, err error) {
+	ctx := context.New()
+	defer ctx.Cancel()
+	ch := make(chan blob.SizedRef, 16)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.EnumerateBlobs(ctx, ch, after, limit)
+	}()
+	for sb := range ch {
+		blobs = append(blobs, sb)
+	}
+	if err := <-errc; err != nil {
+		return nil, false, err
+	}
+	return blobs, len(blobs) < limit, nil
+}
+
+func (sto *mirrorVerify) StorageStatus() interface{} {
+	sto.mu.Lock()
+	defer sto.mu.Unlock()
+	st := sto.stats // copy
+	st.Primary = sto.primaryPrefix
+	st.Shadow = sto.shadowPrefix
+	st.ReadCheckPercent = sto.readCheckPercent
+	return &st
+}
+
+var (
+	_ blobserver.Storage               = (*mirrorVerify)(nil)
+	_ blobserver.StorageStatusReporter = (*mirrorVerify)(nil)
+)
+
+func init() {
+	blobserver.RegisterStorageConstructor("mirror-verify", blobserver.StorageConstructor(newFromConfig))
+}
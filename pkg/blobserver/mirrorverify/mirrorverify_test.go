@@ -0,0 +1,128 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mirrorverify
+
+import (
+	"errors"
+	"testing"
+
+	"camlistore.org/pkg/test"
+)
+
+func newTestStorage() (sto *mirrorVerify, primary, shadow *test.Fetcher) {
+	primary, shadow = new(test.Fetcher), new(test.Fetcher)
+	sto = &mirrorVerify{
+		primaryPrefix:    "/primary/",
+		shadowPrefix:     "/shadow/",
+		primary:          primary,
+		shadow:           shadow,
+		readCheckPercent: 100,
+	}
+	return
+}
+
+func TestReceiveMirrors(t *testing.T) {
+	sto, primary, shadow := newTestStorage()
+	tb := &test.Blob{Contents: "some blob"}
+	sb, err := sto.ReceiveBlob(tb.BlobRef(), tb.Reader())
+	if err != nil {
+		t.Fatalf("ReceiveBlob: %v", err)
+	}
+	if sb.Ref != tb.BlobRef() {
+		t.Errorf("received %v; want %v", sb.Ref, tb.BlobRef())
+	}
+	for _, tf := range []*test.Fetcher{primary, shadow} {
+		if tf.NumBlobs() != 1 {
+			t.Errorf("backend has %d blobs; want 1", tf.NumBlobs())
+		}
+	}
+	if got := sto.stats.Writes; got != 1 {
+		t.Errorf("Writes = %d; want 1", got)
+	}
+}
+
+func TestShadowWriteFailureNotFatal(t *testing.T) {
+	sto, primary, shadow := newTestStorage()
+	shadow.ReceiveErr = errors.New("shadow is broken")
+	tb := &test.Blob{Contents: "some blob"}
+	if _, err := sto.ReceiveBlob(tb.BlobRef(), tb.Reader()); err != nil {
+		t.Fatalf("ReceiveBlob with broken shadow: %v", err)
+	}
+	if primary.NumBlobs() != 1 {
+		t.Errorf("primary has %d blobs; want 1", primary.NumBlobs())
+	}
+	if got := sto.stats.ShadowWriteFailures; got != 1 {
+		t.Errorf("ShadowWriteFailures = %d; want 1", got)
+	}
+}
+
+func TestReadCheck(t *testing.T) {
+	sto, primary, shadow := newTestStorage()
+	tb := &test.Blob{Contents: "some blob"}
+	primary.AddBlob(tb)
+	shadow.AddBlob(tb)
+
+	sto.checkShadowRead(tb.BlobRef(), []byte(tb.Contents))
+	if got := sto.stats.ReadCheckMismatches; got != 0 {
+		t.Errorf("ReadCheckMismatches after agreeing read = %d; want 0", got)
+	}
+
+	sto.checkShadowRead(tb.BlobRef(), []byte("what the primary claimed"))
+	if got := sto.stats.ReadCheckMismatches; got != 1 {
+		t.Errorf("ReadCheckMismatches after disagreeing read = %d; want 1", got)
+	}
+
+	missing := &test.Blob{Contents: "only on the primary"}
+	primary.AddBlob(missing)
+	sto.checkShadowRead(missing.BlobRef(), []byte(missing.Contents))
+	if got := sto.stats.ReadCheckMissing; got != 1 {
+		t.Errorf("ReadCheckMissing = %d; want 1", got)
+	}
+	if got := sto.stats.ReadChecks; got != 3 {
+		t.Errorf("ReadChecks = %d; want 3", got)
+	}
+}
+
+func TestEnumerateCheck(t *testing.T) {
+	sto, primary, shadow := newTestStorage()
+	both1, both2 := &test.Blob{Contents: "blob A"}, &test.Blob{Contents: "blob B"}
+	onlyPrimary := &test.Blob{Contents: "only on the primary"}
+	onlyShadow := &test.Blob{Contents: "only on the shadow"}
+	for _, tb := range []*test.Blob{both1, both2, onlyPrimary} {
+		primary.AddBlob(tb)
+	}
+	for _, tb := range []*test.Blob{both1, both2, onlyShadow} {
+		shadow.AddBlob(tb)
+	}
+
+	sto.enumerateCheck()
+	if got := sto.stats.EnumerateRuns; got != 1 {
+		t.Errorf("EnumerateRuns = %d; want 1", got)
+	}
+	if got := sto.stats.EnumerateChecked; got != 3 {
+		t.Errorf("EnumerateChecked = %d; want 3", got)
+	}
+	if got := sto.stats.EnumerateMissingShadow; got != 1 {
+		t.Errorf("EnumerateMissingShadow = %d; want 1", got)
+	}
+	if got := sto.stats.EnumerateExtraShadow; got != 1 {
+		t.Errorf("EnumerateExtraShadow = %d; want 1", got)
+	}
+	if sto.cursor != "" {
+		t.Errorf("cursor = %q; want empty after a full pass", sto.cursor)
+	}
+}
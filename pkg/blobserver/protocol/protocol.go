@@ -45,6 +45,24 @@ func (p *StatResponse) MarshalJSON() ([]byte, error) {
 	return json.Marshal(v)
 }
 
+// ResumableUploadResponse is the JSON document returned from the
+// resumable upload handler.
+//
+// See doc/protocol/blob-upload-resume.txt.
+type ResumableUploadResponse struct {
+	// Session identifies the upload session. It's empty in the
+	// response to a successful finalize, which deletes the session.
+	Session string `json:"session,omitempty"`
+
+	// Offset is the number of bytes of the blob the server has
+	// durably spooled so far.
+	Offset int64 `json:"offset"`
+
+	// Received is the blob received into storage, set only in the
+	// response to a successful finalize.
+	Received *RefAndSize `json:"received,omitempty"`
+}
+
 // UploadResponse is the JSON document returned from the blob batch
 // upload handler.
 //
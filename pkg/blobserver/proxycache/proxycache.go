@@ -54,6 +54,7 @@ const buffered = 8
 
 type sto struct {
 	origin        blobserver.Storage
+	originPrefix  string
 	cache         blobserver.Storage
 	kv            sorted.KeyValue
 	maxCacheBytes int64
@@ -95,6 +96,7 @@ func newFromConfig(ld blobserver.Loader, config jsonconfig.Obj) (storage blobser
 
 	s := &sto{
 		origin:        originSto,
+		originPrefix:  origin,
 		cache:         cacheSto,
 		maxCacheBytes: maxCacheBytes,
 		kv:            kv,
@@ -134,7 +136,18 @@ func (sto *sto) Fetch(b blob.Ref) (rc io.ReadCloser, size uint32, err error) {
 	if err != os.ErrNotExist {
 		log.Printf("warning: proxycache cache fetch error for %v: %v", b, err)
 	}
-	rc, size, err = sto.cache.Fetch(b)
+	if herr := blobserver.BackendUp(sto.originPrefix); herr != nil {
+		// Known-down origin; fail fast instead of waiting for
+		// its timeout.
+		return nil, 0, herr
+	}
+	t0 := time.Now()
+	rc, size, err = sto.origin.Fetch(b)
+	rerr := err
+	if rerr == os.ErrNotExist {
+		rerr = nil // a missing blob is a normal answer, not a failing origin
+	}
+	blobserver.RecordBackend(sto.originPrefix, time.Since(t0), rerr)
 	if err != nil {
 		return
 	}
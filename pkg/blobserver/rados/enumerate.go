@@ -0,0 +1,68 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rados
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/context"
+)
+
+func (sto *radosStorage) EnumerateBlobs(ctx *context.Context, dest chan<- blob.SizedRef, after string, limit int) error {
+	defer close(dest)
+	// rados(8) has no ordered or resumable listing, so list the
+	// whole pool and sort client-side.
+	out, err := sto.run(nil, "ls")
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, name := range strings.Split(string(out), "\n") {
+		if name <= after {
+			continue
+		}
+		if _, ok := blob.Parse(name); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	sent := 0
+	for _, name := range names {
+		br, _ := blob.Parse(name)
+		size, err := sto.statSize(name)
+		if err == os.ErrNotExist {
+			// Removed since the listing; skip it.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case dest <- blob.SizedRef{Ref: br, Size: size}:
+		case <-ctx.Done():
+			return context.ErrCanceled
+		}
+		if sent++; sent == limit {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package rados registers the "rados" blobserver storage type, storing
+blobs as objects in a Ceph RADOS pool.
+
+It shells out to the rados(8) command-line tool from Ceph, which must
+be in the server's PATH; no librados binding is required at build
+time.
+
+Example low-level config:
+
+     "/r1/": {
+         "handler": "storage-rados",
+         "handlerArgs": {
+            "monitors": ["ceph1.example.com:6789", "ceph2.example.com:6789"],
+            "pool": "camliblobs",
+            "user": "camlistore",
+            "keyring": "/etc/ceph/ceph.client.camlistore.keyring"
+          }
+     },
+
+"user" and "keyring" are optional and default to whatever the local
+Ceph configuration provides. Each blob is stored as one RADOS object
+named after its blobref.
+*/
+package rados
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/jsonconfig"
+)
+
+type radosStorage struct {
+	pool string
+
+	// flags holds the cluster arguments (-m, -p, --id, --keyring)
+	// passed before the subcommand on every rados(8) invocation.
+	flags []string
+}
+
+func (sto *radosStorage) String() string {
+	return fmt.Sprintf("\"rados\" blob storage in pool %q", sto.pool)
+}
+
+// run invokes rados(8) with the cluster flags followed by args,
+// feeding it stdin if non-nil and returning its standard output. A
+// "No such file or directory" failure (a missing object) is reported
+// as os.ErrNotExist.
+func (sto *radosStorage) run(stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := exec.Command("rados", append(append([]string(nil), sto.flags...), args...)...)
+	cmd.Stdin = stdin
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stderr.String(), "No such file or directory") {
+			return nil, os.ErrNotExist
+		}
+		return nil, fmt.Errorf("rados: %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func newFromConfig(_ blobserver.Loader, config jsonconfig.Obj) (blobserver.Storage, error) {
+	monitors := config.RequiredList("monitors")
+	pool := config.RequiredString("pool")
+	user := config.OptionalString("user", "")
+	keyring := config.OptionalString("keyring", "")
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := exec.LookPath("rados"); err != nil {
+		return nil, fmt.Errorf("rados: the rados(8) tool from Ceph is required in PATH: %v", err)
+	}
+	flags := []string{"-m", strings.Join(monitors, ","), "-p", pool}
+	if user != "" {
+		flags = append(flags, "--id", user)
+	}
+	if keyring != "" {
+		flags = append(flags, "--keyring", keyring)
+	}
+	sto := &radosStorage{
+		pool:  pool,
+		flags: flags,
+	}
+	out, err := sto.run(nil, "lspools")
+	if err != nil {
+		return nil, fmt.Errorf("rados: listing pools on %v: %v", monitors, err)
+	}
+	found := false
+	for _, p := range strings.Split(string(out), "\n") {
+		if p == pool {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("rados: pool %q not found in cluster", pool)
+	}
+	return sto, nil
+}
+
+func init() {
+	blobserver.RegisterStorageConstructor("rados", blobserver.StorageConstructor(newFromConfig))
+}
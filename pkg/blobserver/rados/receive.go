@@ -0,0 +1,36 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rados
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"camlistore.org/pkg/blob"
+)
+
+func (sto *radosStorage) ReceiveBlob(br blob.Ref, source io.Reader) (blob.SizedRef, error) {
+	data, err := ioutil.ReadAll(source)
+	if err != nil {
+		return blob.SizedRef{}, err
+	}
+	if _, err := sto.run(bytes.NewReader(data), "put", br.String(), "-"); err != nil {
+		return blob.SizedRef{}, err
+	}
+	return blob.SizedRef{Ref: br, Size: uint32(len(data))}, nil
+}
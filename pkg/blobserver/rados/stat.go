@@ -0,0 +1,68 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rados
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/syncutil"
+)
+
+var statGate = syncutil.NewGate(10) // arbitrary; bounds rados(8) processes
+
+// statSize runs "rados stat" on the object name and parses the size
+// out of its one-line report ("pool/name mtime ..., size N").
+func (sto *radosStorage) statSize(name string) (uint32, error) {
+	out, err := sto.run(nil, "stat", name)
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(strings.TrimSpace(string(out)))
+	if len(fields) < 2 || fields[len(fields)-2] != "size" {
+		return 0, fmt.Errorf("rados: unexpected stat output for %q: %q", name, out)
+	}
+	size, err := strconv.ParseUint(fields[len(fields)-1], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("rados: unexpected size in stat output for %q: %q", name, out)
+	}
+	return uint32(size), nil
+}
+
+func (sto *radosStorage) StatBlobs(dest chan<- blob.SizedRef, blobs []blob.Ref) error {
+	var wg syncutil.Group
+	for i := range blobs {
+		br := blobs[i]
+		statGate.Start()
+		wg.Go(func() error {
+			defer statGate.Done()
+			size, err := sto.statSize(br.String())
+			if err == os.ErrNotExist {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error statting %v: %v", br, err)
+			}
+			dest <- blob.SizedRef{Ref: br, Size: size}
+			return nil
+		})
+	}
+	return wg.Err()
+}
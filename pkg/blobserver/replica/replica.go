@@ -40,6 +40,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"time"
 
 	"camlistore.org/pkg/blob"
@@ -129,8 +130,19 @@ func newFromConfig(ld blobserver.Loader, config jsonconfig.Obj) (storage blobser
 
 func (sto *replicaStorage) Fetch(b blob.Ref) (file io.ReadCloser, size uint32, err error) {
 	// TODO: race these? first to respond?
-	for _, replica := range sto.readReplicas {
+	for i, replica := range sto.readReplicas {
+		prefix := sto.readPrefixes[i]
+		if herr := blobserver.BackendUp(prefix); herr != nil {
+			// Known-down backend; fast failure unless
+			// another replica has the blob.
+			if err == nil {
+				err = herr
+			}
+			continue
+		}
+		t0 := time.Now()
 		file, size, err = replica.Fetch(b)
+		blobserver.RecordBackend(prefix, time.Since(t0), backendFetchErr(err))
 		if err == nil {
 			return
 		}
@@ -138,6 +150,16 @@ func (sto *replicaStorage) Fetch(b blob.Ref) (file io.ReadCloser, size uint32, e
 	return
 }
 
+// backendFetchErr returns the error to feed the backend health
+// tracker for a fetch result: a missing blob is a normal answer, not
+// a failing backend.
+func backendFetchErr(err error) error {
+	if err == os.ErrNotExist {
+		return nil
+	}
+	return err
+}
+
 // StatBlobs stats all read replicas.
 func (sto *replicaStorage) StatBlobs(dest chan<- blob.SizedRef, blobs []blob.Ref) error {
 	need := make(map[blob.Ref]bool)
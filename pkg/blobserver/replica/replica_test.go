@@ -17,6 +17,8 @@ limitations under the License.
 package replica
 
 import (
+	"errors"
+	"io"
 	"testing"
 
 	"camlistore.org/pkg/blob"
@@ -93,6 +95,58 @@ func TestReceiveOneGoodOneFail(t *testing.T) {
 	}
 }
 
+// downStorage is a read replica whose fetches always fail, counting
+// how often it was asked.
+type downStorage struct {
+	blobserver.Storage
+	fetches int
+}
+
+func (d *downStorage) Fetch(b blob.Ref) (io.ReadCloser, uint32, error) {
+	d.fetches++
+	return nil, 0, errors.New("fake backend outage")
+}
+
+func TestFetchSkipsDownBackend(t *testing.T) {
+	defer blobserver.ResetBackendHealth("")
+	good := new(test.Fetcher)
+	tb := &test.Blob{Contents: "health"}
+	tb.MustUpload(t, good)
+	down := new(downStorage)
+	sto := &replicaStorage{
+		readPrefixes: []string{"/down/", "/good/"},
+		readReplicas: []blobserver.Storage{down, good},
+	}
+
+	// Reads succeed throughout the outage, and once the tracker
+	// declares /down/ down, it stops being asked at all.
+	for i := 0; i < 10; i++ {
+		rc, _, err := sto.Fetch(tb.BlobRef())
+		if err != nil {
+			t.Fatalf("fetch %d: %v", i, err)
+		}
+		rc.Close()
+	}
+	if err := blobserver.BackendUp("/down/"); err == nil {
+		t.Error("/down/ still considered up after consecutive fetch failures")
+	}
+	if down.fetches >= 10 {
+		t.Errorf("down backend saw %d fetches; want fewer (circuit open)", down.fetches)
+	}
+
+	// After recovery (here: a manual reset), it is read from again.
+	blobserver.ResetBackendHealth("/down/")
+	n := down.fetches
+	rc, _, err := sto.Fetch(tb.BlobRef())
+	if err != nil {
+		t.Fatalf("fetch after reset: %v", err)
+	}
+	rc.Close()
+	if down.fetches != n+1 {
+		t.Errorf("down backend saw %d fetches after reset; want %d", down.fetches, n+1)
+	}
+}
+
 func TestReplica(t *testing.T) {
 	storagetest.Test(t, func(t *testing.T) (sto blobserver.Storage, cleanup func()) {
 		sto = newReplica(t, map[string]interface{}{
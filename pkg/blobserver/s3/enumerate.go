@@ -18,6 +18,7 @@ package s3
 
 import (
 	"log"
+	"strings"
 
 	"camlistore.org/pkg/blob"
 	"camlistore.org/pkg/blobserver"
@@ -47,32 +48,65 @@ func nextStr(s string) string {
 }
 
 func (sto *s3Storage) EnumerateBlobs(ctx *context.Context, dest chan<- blob.SizedRef, after string, limit int) (err error) {
+	return sto.EnumerateFilteredBlobs(ctx, dest, blobserver.EnumerateFilter{}, after, limit)
+}
+
+// EnumerateFilteredBlobs implements blobserver.FilteredEnumerator,
+// using the filter's blobref prefix as the bucket listing prefix. Size
+// filters are applied to the listing results, paging further as needed
+// to return up to limit matches.
+func (sto *s3Storage) EnumerateFilteredBlobs(ctx *context.Context, dest chan<- blob.SizedRef, filter blobserver.EnumerateFilter, after string, limit int) (err error) {
 	defer close(dest)
 	if faultEnumerate.FailErr(&err) {
 		return
 	}
-	startAt := after
-	if _, ok := blob.Parse(after); ok {
-		startAt = nextStr(after)
-	}
-	objs, err := sto.s3Client.ListBucket(sto.bucket, startAt, limit)
-	if err != nil {
-		log.Printf("s3 ListBucket: %v", err)
-		return err
+	if p := filter.RefPrefix; after < p {
+		// Skip ahead to just before the prefix. after is
+		// exclusive and the prefix may itself be a complete
+		// blobref, so starting the listing at the prefix string
+		// (and then advancing past it with nextStr below) would
+		// skip an exact match.
+		if skip := p[:len(p)-1]; after < skip {
+			after = skip
+		}
 	}
-	for _, obj := range objs {
-		if obj.Key == after {
-			continue
+	sent := 0
+	for {
+		startAt := after
+		if _, ok := blob.Parse(after); ok {
+			startAt = nextStr(after)
+		}
+		objs, err := sto.s3Client.ListBucket(sto.bucket, sto.dirPrefix+filter.RefPrefix, sto.dirPrefix+startAt, limit)
+		if err != nil {
+			log.Printf("s3 ListBucket: %v", err)
+			return err
 		}
-		br, ok := blob.Parse(obj.Key)
-		if !ok {
-			continue
+		for _, obj := range objs {
+			key := strings.TrimPrefix(obj.Key, sto.dirPrefix)
+			if key == after {
+				continue
+			}
+			after = key
+			br, ok := blob.Parse(key)
+			if !ok {
+				continue
+			}
+			sb := blob.SizedRef{Ref: br, Size: uint32(obj.Size)}
+			if !filter.Matches(sb) {
+				continue
+			}
+			select {
+			case dest <- sb:
+			case <-ctx.Done():
+				return context.ErrCanceled
+			}
+			if sent++; sent == limit {
+				return nil
+			}
 		}
-		select {
-		case dest <- blob.SizedRef{Ref: br, Size: uint32(obj.Size)}:
-		case <-ctx.Done():
-			return context.ErrCanceled
+		if len(objs) == 0 || len(objs) < limit {
+			// Listing exhausted.
+			return nil
 		}
 	}
-	return nil
 }
@@ -26,6 +26,6 @@ func (sto *s3Storage) Fetch(blob blob.Ref) (file io.ReadCloser, size uint32, err
 	if faultGet.FailErr(&err) {
 		return
 	}
-	file, sz, err := sto.s3Client.Get(sto.bucket, blob.String())
+	file, sz, err := sto.s3Client.Get(sto.bucket, sto.key(blob))
 	return file, uint32(sz), err
 }
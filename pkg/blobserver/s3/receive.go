@@ -37,7 +37,11 @@ func (sto *s3Storage) ReceiveBlob(b blob.Ref, source io.Reader) (sr blob.SizedRe
 		return
 	}
 
-	err = sto.s3Client.PutObject(b.String(), sto.bucket, md5h, size, &buf)
+	if sto.multipartThreshold > 0 && size > sto.multipartThreshold {
+		err = sto.s3Client.PutMultipart(sto.key(b), sto.bucket, size, sto.partSize, &buf)
+	} else {
+		err = sto.s3Client.PutObject(sto.key(b), sto.bucket, md5h, size, &buf)
+	}
 	if err != nil {
 		return sr, err
 	}
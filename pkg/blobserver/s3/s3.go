@@ -30,14 +30,37 @@ Example low-level config:
           }
      },
 
+The optional "dirPrefix" argument stores blobs under a
+subdirectory of the bucket instead of at its root. The optional
+booleans "pathStyle" and "insecureSkipTLSVerify" are for
+S3-compatible servers (Ceph radosgw, etc.) that need path-style
+bucket addressing or run with self-signed TLS certificates.
+
+The optional "serverSideEncryption" (e.g. "AES256" or "aws:kms")
+and "storageClass" (e.g. "STANDARD_IA") arguments are sent with
+each upload as the corresponding x-amz-* request headers.
+
+The optional "multipartThreshold" argument is the blob size in
+bytes above which uploads use the S3 multipart API instead of a
+single PUT, in parts of the optional "partSize" bytes (at least
+5 MB, S3's minimum). Zero, the default, never uses multipart.
+
+Instead of "aws_access_key" and "aws_secret_access_key", the
+boolean argument "useIAMRole" makes the handler fetch temporary
+credentials for the EC2 instance's IAM role from the instance
+metadata service.
+
 */
 package s3
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
+	"net/http"
 	"strings"
 
+	"camlistore.org/pkg/blob"
 	"camlistore.org/pkg/blobserver"
 	"camlistore.org/pkg/fault"
 	"camlistore.org/pkg/jsonconfig"
@@ -55,32 +78,73 @@ type s3Storage struct {
 	s3Client *s3.Client
 	bucket   string
 	hostname string
+
+	// dirPrefix is the optional subdirectory of the bucket under
+	// which blobs are stored. It is either empty or ends in a
+	// slash.
+	dirPrefix string
+
+	// multipartThreshold is the blob size in bytes above which
+	// uploads go through the S3 multipart API, in parts of
+	// partSize bytes. Zero means no multipart uploads.
+	multipartThreshold int64
+	partSize           int64
 }
 
 func (s *s3Storage) String() string {
+	if s.dirPrefix != "" {
+		return fmt.Sprintf("\"s3\" blob storage at host %q, bucket %q, directory %q", s.hostname, s.bucket, s.dirPrefix)
+	}
 	return fmt.Sprintf("\"s3\" blob storage at host %q, bucket %q", s.hostname, s.bucket)
 }
 
+// key returns the S3 object key for the blob br.
+func (s *s3Storage) key(br blob.Ref) string {
+	return s.dirPrefix + br.String()
+}
+
 func newFromConfig(_ blobserver.Loader, config jsonconfig.Obj) (blobserver.Storage, error) {
 	hostname := config.OptionalString("hostname", "s3.amazonaws.com")
 	client := &s3.Client{
 		Auth: &s3.Auth{
-			AccessKey:       config.RequiredString("aws_access_key"),
-			SecretAccessKey: config.RequiredString("aws_secret_access_key"),
-			Hostname:        hostname,
+			Hostname: hostname,
 		},
+		PathStyle:            config.OptionalBool("pathStyle", false),
+		ServerSideEncryption: config.OptionalString("serverSideEncryption", ""),
+		StorageClass:         config.OptionalString("storageClass", ""),
+	}
+	if config.OptionalBool("useIAMRole", false) {
+		client.UseIAMRole = true
+	} else {
+		client.Auth.AccessKey = config.RequiredString("aws_access_key")
+		client.Auth.SecretAccessKey = config.RequiredString("aws_secret_access_key")
+	}
+	if config.OptionalBool("insecureSkipTLSVerify", false) {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+	dirPrefix := strings.Trim(config.OptionalString("dirPrefix", ""), "/")
+	if dirPrefix != "" {
+		dirPrefix += "/"
 	}
 	sto := &s3Storage{
-		s3Client: client,
-		bucket:   config.RequiredString("bucket"),
-		hostname: hostname,
+		s3Client:           client,
+		bucket:             config.RequiredString("bucket"),
+		hostname:           hostname,
+		dirPrefix:          dirPrefix,
+		multipartThreshold: config.OptionalInt64("multipartThreshold", 0),
+		partSize:           config.OptionalInt64("partSize", 0),
+	}
+	if sto.partSize != 0 && sto.partSize < s3.MinPartSize {
+		return nil, fmt.Errorf("s3: \"partSize\" is %d bytes; S3 requires at least %d byte parts", sto.partSize, s3.MinPartSize)
 	}
 	skipStartupCheck := config.OptionalBool("skipStartupCheck", false)
 	if err := config.Validate(); err != nil {
 		return nil, err
 	}
 	if !skipStartupCheck {
-		_, err := client.ListBucket(sto.bucket, "", 1)
+		_, err := client.ListBucket(sto.bucket, "", "", 1)
 		if serr, ok := err.(*s3.Error); ok {
 			if serr.AmazonCode == "NoSuchBucket" {
 				return nil, fmt.Errorf("Bucket %q doesn't exist.", sto.bucket)
@@ -93,7 +157,7 @@ func newFromConfig(_ blobserver.Loader, config jsonconfig.Obj) (blobserver.Stora
 					return nil, fmt.Errorf("Wrong server for bucket %q; and error determining bucket's location: %v", sto.bucket, lerr)
 				}
 				client.Auth.Hostname = loc
-				_, err = client.ListBucket(sto.bucket, "", 1)
+				_, err = client.ListBucket(sto.bucket, "", "", 1)
 				if err == nil {
 					log.Printf("Warning: s3 server should be %q, not %q. Change config file to avoid start-up latency.", client.Auth.Hostname, hostname)
 				}
@@ -106,7 +170,7 @@ func newFromConfig(_ blobserver.Loader, config jsonconfig.Obj) (blobserver.Stora
 				// UseEndpoint will be e.g. "brads3test-ca.s3-us-west-1.amazonaws.com"
 				// But we only want the "s3-us-west-1.amazonaws.com" part.
 				client.Auth.Hostname = strings.TrimPrefix(serr.UseEndpoint, sto.bucket+".")
-				_, err = client.ListBucket(sto.bucket, "", 1)
+				_, err = client.ListBucket(sto.bucket, "", "", 1)
 				if err == nil {
 					log.Printf("Warning: s3 server should be %q, not %q. Change config file to avoid start-up latency.", client.Auth.Hostname, hostname)
 				}
@@ -0,0 +1,192 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package s3
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/blobserver/storagetest"
+	"camlistore.org/pkg/jsonconfig"
+	"camlistore.org/pkg/test"
+)
+
+// fakeS3 is a minimal in-memory S3 server for testing without
+// talking to Amazon. It understands both virtual-host-style
+// ("bucket.host/key") and path-style ("host/bucket/key") requests.
+type fakeS3 struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string]string // key -> contents
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{
+		bucket:  bucket,
+		objects: make(map[string]string),
+	}
+}
+
+// objectKey returns the object key addressed by r, or ok == false if
+// r addresses a bucket other than f.bucket. A list request addresses
+// the empty key.
+func (f *fakeS3) objectKey(r *http.Request) (key string, ok bool) {
+	if strings.HasPrefix(r.Host, f.bucket+".") {
+		return strings.TrimPrefix(r.URL.Path, "/"), true
+	}
+	if p := strings.TrimPrefix(r.URL.Path, "/"+f.bucket); p != r.URL.Path && (p == "" || p[0] == '/') {
+		return strings.TrimPrefix(p, "/"), true
+	}
+	return "", false
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, ok := f.objectKey(r)
+	if !ok {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+	if key == "" && r.Method == "GET" {
+		f.serveList(w, r)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case "GET", "HEAD":
+		v, ok := f.objects[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(v)))
+		if r.Method == "GET" {
+			io.WriteString(w, v)
+		}
+	case "PUT":
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		f.objects[key] = buf.String()
+	case "DELETE":
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) serveList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	marker, prefix := q.Get("marker"), q.Get("prefix")
+	maxKeys, _ := strconv.Atoi(q.Get("max-keys"))
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for k := range f.objects {
+		if k > marker && strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	truncated := false
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		truncated = true
+	}
+	fmt.Fprintf(w, "<ListBucketResult><Name>%s</Name><Marker>%s</Marker><MaxKeys>%d</MaxKeys><IsTruncated>%v</IsTruncated>",
+		f.bucket, marker, maxKeys, truncated)
+	for _, k := range keys {
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", k, len(f.objects[k]))
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
+
+// proxyTo is an http.RoundTripper routing every request to addr
+// over plain HTTP, preserving the request's host for bucket
+// detection, so the client's generated bucket hostnames need not
+// resolve.
+type proxyTo struct {
+	addr string
+}
+
+func (p proxyTo) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.Host = req.URL.Host
+	req.URL.Scheme = "http"
+	req.URL.Host = p.addr
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newFakeStorage(t *testing.T, pathStyle bool) (*fakeS3, *s3Storage, func()) {
+	fake := newFakeS3("test-bucket")
+	ts := httptest.NewServer(fake)
+	sto, err := newFromConfig(nil, jsonconfig.Obj{
+		"aws_access_key":        "key",
+		"aws_secret_access_key": "secret",
+		"bucket":                fake.bucket,
+		"hostname":              strings.TrimPrefix(ts.URL, "http://"),
+		"dirPrefix":             "blobs",
+		"pathStyle":             pathStyle,
+		"skipStartupCheck":      true, // the proxy transport isn't in place yet
+	})
+	if err != nil {
+		ts.Close()
+		t.Fatalf("newFromConfig error: %v", err)
+	}
+	sto.(*s3Storage).s3Client.Transport = proxyTo{addr: ts.Listener.Addr().String()}
+	return fake, sto.(*s3Storage), ts.Close
+}
+
+func TestS3FakeServer(t *testing.T) {
+	storagetest.Test(t, func(t *testing.T) (blobserver.Storage, func()) {
+		_, sto, cleanup := newFakeStorage(t, false)
+		return sto, cleanup
+	})
+}
+
+func TestS3FakeServerPathStyle(t *testing.T) {
+	storagetest.Test(t, func(t *testing.T) (blobserver.Storage, func()) {
+		_, sto, cleanup := newFakeStorage(t, true)
+		return sto, cleanup
+	})
+}
+
+func TestS3DirPrefix(t *testing.T) {
+	fake, sto, cleanup := newFakeStorage(t, false)
+	defer cleanup()
+	tb := &test.Blob{Contents: "some blob contents"}
+	tb.MustUpload(t, sto)
+	key := "blobs/" + tb.BlobRef().String()
+	fake.mu.Lock()
+	_, ok := fake.objects[key]
+	fake.mu.Unlock()
+	if !ok {
+		t.Errorf("blob not stored under bucket directory key %q; have %v", key, fake.objects)
+	}
+}
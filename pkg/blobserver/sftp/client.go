@@ -0,0 +1,472 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sftp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// This file implements the client side of SFTP protocol version 3
+// (draft-ietf-secsh-filexfer-02), the version that OpenSSH's
+// sftp-server speaks, over the stdin/stdout of an ssh "sftp"
+// subsystem session. Only the handful of operations the blobserver
+// needs are implemented. Calls are synchronous: one request is in
+// flight at a time, serialized by a mutex.
+
+const (
+	sshFxpInit    = 1
+	sshFxpVersion = 2
+	sshFxpOpen    = 3
+	sshFxpClose   = 4
+	sshFxpRead    = 5
+	sshFxpWrite   = 6
+	sshFxpOpendir = 11
+	sshFxpReaddir = 12
+	sshFxpRemove  = 13
+	sshFxpMkdir   = 14
+	sshFxpStat    = 17
+	sshFxpRename  = 18
+	sshFxpStatus  = 101
+	sshFxpHandle  = 102
+	sshFxpData    = 103
+	sshFxpName    = 104
+	sshFxpAttrs   = 105
+
+	sshFxOk         = 0
+	sshFxEOF        = 1
+	sshFxNoSuchFile = 2
+	sshFxPermDenied = 3
+	sshFxFailure    = 4
+
+	sshFxfRead  = 0x01
+	sshFxfWrite = 0x02
+	sshFxfCreat = 0x08
+	sshFxfTrunc = 0x10
+	sshFxfExcl  = 0x20
+
+	sftpProtocolVersion = 3
+
+	// attrFlagSize etc. are the SSH_FILEXFER_ATTR_* bits of the
+	// flags field of an attrs structure.
+	attrFlagSize        = 0x01
+	attrFlagUIDGID      = 0x02
+	attrFlagPermissions = 0x04
+	attrFlagACModTime   = 0x08
+
+	// maxWritePacket is the data size per SSH_FXP_WRITE; OpenSSH
+	// accepts at most 32KB packets.
+	maxWritePacket = 31 << 10
+)
+
+// statusError is an SFTP error status reply.
+type statusError struct {
+	code uint32
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	if e.msg != "" {
+		return fmt.Sprintf("sftp: %s (status %d)", e.msg, e.code)
+	}
+	return fmt.Sprintf("sftp: status %d", e.code)
+}
+
+// sftpClient speaks the SFTP protocol over w (requests) and r
+// (replies).
+type sftpClient struct {
+	mu     sync.Mutex
+	w      io.Writer
+	r      io.Reader
+	nextID uint32
+}
+
+// pkt is an outgoing packet being marshalled.
+type pkt struct {
+	buf []byte
+}
+
+func (p *pkt) byte(v byte) { p.buf = append(p.buf, v) }
+
+func (p *pkt) uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	p.buf = append(p.buf, b[:]...)
+}
+
+func (p *pkt) uint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	p.buf = append(p.buf, b[:]...)
+}
+
+func (p *pkt) str(s string) {
+	p.uint32(uint32(len(s)))
+	p.buf = append(p.buf, s...)
+}
+
+func (p *pkt) bytes(b []byte) {
+	p.uint32(uint32(len(b)))
+	p.buf = append(p.buf, b...)
+}
+
+// unpacker reads fields from a received packet payload.
+type unpacker struct {
+	buf []byte
+	err error
+}
+
+func (u *unpacker) uint32() uint32 {
+	if u.err != nil || len(u.buf) < 4 {
+		u.err = errors.New("sftp: short packet")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(u.buf)
+	u.buf = u.buf[4:]
+	return v
+}
+
+func (u *unpacker) uint64() uint64 {
+	if u.err != nil || len(u.buf) < 8 {
+		u.err = errors.New("sftp: short packet")
+		return 0
+	}
+	v := binary.BigEndian.Uint64(u.buf)
+	u.buf = u.buf[8:]
+	return v
+}
+
+func (u *unpacker) str() string {
+	n := u.uint32()
+	if u.err != nil || uint32(len(u.buf)) < n {
+		u.err = errors.New("sftp: short packet")
+		return ""
+	}
+	s := string(u.buf[:n])
+	u.buf = u.buf[n:]
+	return s
+}
+
+// attrs is the parsed attrs structure; only size and permissions are
+// retained.
+type attrs struct {
+	size   uint32 // valid if sizeOK
+	sizeOK bool
+	perm   uint32 // valid if permOK
+	permOK bool
+}
+
+func (a attrs) isDir() bool { return a.permOK && a.perm&0040000 != 0 }
+
+func (u *unpacker) attrs() (a attrs) {
+	flags := u.uint32()
+	if flags&attrFlagSize != 0 {
+		a.size = uint32(u.uint64())
+		a.sizeOK = true
+	}
+	if flags&attrFlagUIDGID != 0 {
+		u.uint32()
+		u.uint32()
+	}
+	if flags&attrFlagPermissions != 0 {
+		a.perm = u.uint32()
+		a.permOK = true
+	}
+	if flags&attrFlagACModTime != 0 {
+		u.uint32()
+		u.uint32()
+	}
+	return
+}
+
+// newSFTPClient performs the version handshake over the given
+// subsystem pipes.
+func newSFTPClient(w io.Writer, r io.Reader) (*sftpClient, error) {
+	c := &sftpClient{w: w, r: r}
+	var p pkt
+	p.byte(sshFxpInit)
+	p.uint32(sftpProtocolVersion)
+	if err := c.writePacket(p.buf); err != nil {
+		return nil, err
+	}
+	typ, payload, err := c.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	if typ != sshFxpVersion {
+		return nil, fmt.Errorf("sftp: expected version reply to init, got packet type %d", typ)
+	}
+	u := &unpacker{buf: payload}
+	if v := u.uint32(); v != sftpProtocolVersion {
+		return nil, fmt.Errorf("sftp: server speaks protocol version %d; want %d", v, sftpProtocolVersion)
+	}
+	return c, nil
+}
+
+func (c *sftpClient) writePacket(payload []byte) error {
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(payload)))
+	if _, err := c.w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	_, err := c.w.Write(payload)
+	return err
+}
+
+func (c *sftpClient) readPacket() (typ byte, payload []byte, err error) {
+	var lenBuf [4]byte
+	if _, err = io.ReadFull(c.r, lenBuf[:]); err != nil {
+		return
+	}
+	n := binary.BigEndian.Uint32(lenBuf[:])
+	if n == 0 || n > (1<<20) {
+		return 0, nil, fmt.Errorf("sftp: bogus packet length %d", n)
+	}
+	buf := make([]byte, n)
+	if _, err = io.ReadFull(c.r, buf); err != nil {
+		return
+	}
+	return buf[0], buf[1:], nil
+}
+
+// call sends one request packet and returns the matching reply. The
+// request id is prepended to the payload here.
+func (c *sftpClient) call(typ byte, build func(p *pkt)) (replyTyp byte, u *unpacker, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	id := c.nextID
+	var p pkt
+	p.byte(typ)
+	p.uint32(id)
+	build(&p)
+	if err := c.writePacket(p.buf); err != nil {
+		return 0, nil, err
+	}
+	for {
+		replyTyp, payload, err := c.readPacket()
+		if err != nil {
+			return 0, nil, err
+		}
+		u := &unpacker{buf: payload}
+		if u.uint32() == id {
+			return replyTyp, u, u.err
+		}
+		// A reply to an abandoned earlier request; skip it.
+	}
+}
+
+// statusErr interprets a reply that should be a plain status.
+func statusErr(replyTyp byte, u *unpacker) error {
+	if replyTyp != sshFxpStatus {
+		return fmt.Errorf("sftp: unexpected reply packet type %d", replyTyp)
+	}
+	code := u.uint32()
+	if code == sshFxOk {
+		return nil
+	}
+	msg := u.str()
+	if code == sshFxNoSuchFile {
+		return os.ErrNotExist
+	}
+	return &statusError{code: code, msg: msg}
+}
+
+// Open opens the remote path with the given SSH_FXF_* flags and
+// returns the file handle.
+func (c *sftpClient) Open(path string, pflags uint32) (handle string, err error) {
+	replyTyp, u, err := c.call(sshFxpOpen, func(p *pkt) {
+		p.str(path)
+		p.uint32(pflags)
+		p.uint32(0) // empty attrs
+	})
+	if err != nil {
+		return "", err
+	}
+	if replyTyp == sshFxpHandle {
+		return u.str(), u.err
+	}
+	return "", statusErr(replyTyp, u)
+}
+
+func (c *sftpClient) Close(handle string) error {
+	replyTyp, u, err := c.call(sshFxpClose, func(p *pkt) { p.str(handle) })
+	if err != nil {
+		return err
+	}
+	return statusErr(replyTyp, u)
+}
+
+// ReadAt reads up to length bytes at offset. It returns io.EOF after
+// the end of the file.
+func (c *sftpClient) ReadAt(handle string, offset uint64, length uint32) ([]byte, error) {
+	replyTyp, u, err := c.call(sshFxpRead, func(p *pkt) {
+		p.str(handle)
+		p.uint64(offset)
+		p.uint32(length)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if replyTyp == sshFxpData {
+		data := u.str()
+		return []byte(data), u.err
+	}
+	if replyTyp == sshFxpStatus {
+		if code := u.uint32(); code == sshFxEOF {
+			return nil, io.EOF
+		} else if code == sshFxNoSuchFile {
+			return nil, os.ErrNotExist
+		} else {
+			return nil, &statusError{code: code, msg: u.str()}
+		}
+	}
+	return nil, fmt.Errorf("sftp: unexpected reply packet type %d", replyTyp)
+}
+
+func (c *sftpClient) WriteAt(handle string, offset uint64, data []byte) error {
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > maxWritePacket {
+			chunk = chunk[:maxWritePacket]
+		}
+		replyTyp, u, err := c.call(sshFxpWrite, func(p *pkt) {
+			p.str(handle)
+			p.uint64(offset)
+			p.bytes(chunk)
+		})
+		if err != nil {
+			return err
+		}
+		if err := statusErr(replyTyp, u); err != nil {
+			return err
+		}
+		data = data[len(chunk):]
+		offset += uint64(len(chunk))
+	}
+	return nil
+}
+
+// Stat returns the attrs of the remote path, following symlinks. It
+// returns os.ErrNotExist if the path doesn't exist.
+func (c *sftpClient) Stat(path string) (attrs, error) {
+	replyTyp, u, err := c.call(sshFxpStat, func(p *pkt) { p.str(path) })
+	if err != nil {
+		return attrs{}, err
+	}
+	if replyTyp == sshFxpAttrs {
+		a := u.attrs()
+		return a, u.err
+	}
+	return attrs{}, statusErr(replyTyp, u)
+}
+
+func (c *sftpClient) Remove(path string) error {
+	replyTyp, u, err := c.call(sshFxpRemove, func(p *pkt) { p.str(path) })
+	if err != nil {
+		return err
+	}
+	return statusErr(replyTyp, u)
+}
+
+// Rename renames old to new. SFTP version 3 renames fail if new
+// already exists.
+func (c *sftpClient) Rename(old, new string) error {
+	replyTyp, u, err := c.call(sshFxpRename, func(p *pkt) {
+		p.str(old)
+		p.str(new)
+	})
+	if err != nil {
+		return err
+	}
+	return statusErr(replyTyp, u)
+}
+
+// Mkdir creates the remote directory. It is an error if it already
+// exists.
+func (c *sftpClient) Mkdir(path string) error {
+	replyTyp, u, err := c.call(sshFxpMkdir, func(p *pkt) {
+		p.str(path)
+		p.uint32(0) // empty attrs
+	})
+	if err != nil {
+		return err
+	}
+	return statusErr(replyTyp, u)
+}
+
+// dirEntry is one entry of a directory listing.
+type dirEntry struct {
+	name string
+	size uint32
+	dir  bool
+}
+
+// ReadDir returns the entries of the remote directory, excluding "."
+// and "..", in no particular order. It returns os.ErrNotExist if the
+// directory doesn't exist.
+func (c *sftpClient) ReadDir(path string) ([]dirEntry, error) {
+	replyTyp, u, err := c.call(sshFxpOpendir, func(p *pkt) { p.str(path) })
+	if err != nil {
+		return nil, err
+	}
+	var handle string
+	if replyTyp == sshFxpHandle {
+		handle = u.str()
+		if u.err != nil {
+			return nil, u.err
+		}
+	} else {
+		return nil, statusErr(replyTyp, u)
+	}
+	defer c.Close(handle)
+
+	var entries []dirEntry
+	for {
+		replyTyp, u, err := c.call(sshFxpReaddir, func(p *pkt) { p.str(handle) })
+		if err != nil {
+			return nil, err
+		}
+		if replyTyp == sshFxpStatus {
+			if code := u.uint32(); code == sshFxEOF {
+				return entries, nil
+			} else {
+				return nil, &statusError{code: code, msg: u.str()}
+			}
+		}
+		if replyTyp != sshFxpName {
+			return nil, fmt.Errorf("sftp: unexpected reply packet type %d", replyTyp)
+		}
+		count := u.uint32()
+		for i := uint32(0); i < count; i++ {
+			name := u.str()
+			u.str() // longname, unused
+			a := u.attrs()
+			if u.err != nil {
+				return nil, u.err
+			}
+			if name == "." || name == ".." {
+				continue
+			}
+			entries = append(entries, dirEntry{name: name, size: a.size, dir: a.isDir()})
+		}
+	}
+}
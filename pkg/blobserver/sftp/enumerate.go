@@ -0,0 +1,95 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sftp
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/context"
+)
+
+func (sto *sftpStorage) EnumerateBlobs(ctx *context.Context, dest chan<- blob.SizedRef, after string, limit int) error {
+	defer close(dest)
+	entries, err := sto.client.ReadDir(sto.dir)
+	if err != nil {
+		return err
+	}
+	var hashDirs []string
+	for _, ent := range entries {
+		if ent.dir && ent.name != "tmp" {
+			hashDirs = append(hashDirs, ent.name)
+		}
+	}
+	sort.Strings(hashDirs)
+
+	sent := 0
+	for _, hashDir := range hashDirs {
+		shards, err := sto.client.ReadDir(path.Join(sto.dir, hashDir))
+		if err != nil {
+			return err
+		}
+		var shardNames []string
+		for _, ent := range shards {
+			if ent.dir {
+				shardNames = append(shardNames, ent.name)
+			}
+		}
+		sort.Strings(shardNames)
+		for _, shard := range shardNames {
+			// Any blobref in this shard starts with prefix,
+			// so the whole shard sorts before after if its
+			// upper bound does.
+			prefix := hashDir + "-" + shard
+			if after >= prefix+"\xff" {
+				continue
+			}
+			files, err := sto.client.ReadDir(path.Join(sto.dir, hashDir, shard))
+			if err != nil {
+				return err
+			}
+			var blobs []blob.SizedRef
+			for _, ent := range files {
+				if ent.dir || !strings.HasSuffix(ent.name, ".dat") {
+					continue
+				}
+				br, ok := blob.Parse(strings.TrimSuffix(ent.name, ".dat"))
+				if !ok {
+					continue
+				}
+				blobs = append(blobs, blob.SizedRef{Ref: br, Size: ent.size})
+			}
+			sort.Sort(blob.SizedByRef(blobs))
+			for _, sb := range blobs {
+				if sb.Ref.String() <= after {
+					continue
+				}
+				select {
+				case dest <- sb:
+				case <-ctx.Done():
+					return context.ErrCanceled
+				}
+				if sent++; sent == limit {
+					return nil
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sftp
+
+import (
+	"io"
+
+	"camlistore.org/pkg/blob"
+)
+
+func (sto *sftpStorage) Fetch(b blob.Ref) (file io.ReadCloser, size uint32, err error) {
+	p := sto.blobPath(b)
+	a, err := sto.client.Stat(p)
+	if err != nil {
+		return nil, 0, err
+	}
+	handle, err := sto.client.Open(p, sshFxfRead)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &fetchReader{client: sto.client, handle: handle}, a.size, nil
+}
+
+// fetchReader streams a remote file with sequential SSH_FXP_READ
+// requests.
+type fetchReader struct {
+	client *sftpClient
+	handle string
+	offset uint64
+}
+
+func (r *fetchReader) Read(p []byte) (int, error) {
+	want := uint32(len(p))
+	if want > maxWritePacket {
+		want = maxWritePacket
+	}
+	data, err := r.client.ReadAt(r.handle, r.offset, want)
+	n := copy(p, data)
+	r.offset += uint64(n)
+	return n, err
+}
+
+func (r *fetchReader) Close() error {
+	return r.client.Close(r.handle)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sftp
+
+import (
+	"io"
+	"io/ioutil"
+	"path"
+
+	"camlistore.org/pkg/blob"
+)
+
+func (sto *sftpStorage) ReceiveBlob(b blob.Ref, source io.Reader) (sr blob.SizedRef, err error) {
+	contents, err := ioutil.ReadAll(source)
+	if err != nil {
+		return sr, err
+	}
+
+	tmp := sto.tmpName(b)
+	handle, err := sto.client.Open(tmp, sshFxfWrite|sshFxfCreat|sshFxfExcl)
+	if err != nil {
+		return sr, err
+	}
+	if err := sto.client.WriteAt(handle, 0, contents); err != nil {
+		sto.client.Close(handle)
+		sto.client.Remove(tmp)
+		return sr, err
+	}
+	if err := sto.client.Close(handle); err != nil {
+		sto.client.Remove(tmp)
+		return sr, err
+	}
+
+	final := sto.blobPath(b)
+	sto.mkdirAll(path.Dir(final))
+	if err := sto.client.Rename(tmp, final); err != nil {
+		// SFTP renames fail if the target exists; a
+		// concurrent (or earlier) upload of the same blob is
+		// fine.
+		if _, statErr := sto.client.Stat(final); statErr == nil {
+			sto.client.Remove(tmp)
+		} else {
+			sto.client.Remove(tmp)
+			return sr, err
+		}
+	}
+	return blob.SizedRef{Ref: b, Size: uint32(len(contents))}, nil
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package sftp registers the "sftp" blobserver storage type, storing
+blobs on a remote host over the SFTP subsystem of SSH.
+
+Example low-level config:
+
+     "/r1/": {
+         "handler": "storage-sftp",
+         "handlerArgs": {
+            "host": "nas.example.com:22",
+            "user": "camlistore",
+            "dir": "/backup/camliblobs",
+            "privateKeyFile": "/home/camlistore/.ssh/id_rsa"
+          }
+     },
+
+"password" may be given instead of "privateKeyFile"; the key file
+must be an unencrypted PEM RSA key. Blobs are stored under dir as
+<hash>/<xx>/<blobref>.dat, with xx the first two digest digits, and
+are written to a temporary name and renamed into place.
+*/
+package sftp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha1" // for crypto.SHA1 in keychain.Sign
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/jsonconfig"
+	"camlistore.org/third_party/code.google.com/p/go.crypto/ssh"
+)
+
+type sftpStorage struct {
+	client *sftpClient
+	conn   *ssh.ClientConn // held open for the life of the storage
+	host   string
+	dir    string
+}
+
+func (s *sftpStorage) String() string {
+	return fmt.Sprintf("\"sftp\" blob storage at host %q, directory %q", s.host, s.dir)
+}
+
+// password implements ssh.ClientPassword.
+type password string
+
+func (p password) Password(user string) (string, error) { return string(p), nil }
+
+// keychain implements ssh.ClientKeyring for a single RSA key.
+type keychain struct {
+	key *rsa.PrivateKey
+}
+
+func (k *keychain) Key(i int) (interface{}, error) {
+	if i != 0 {
+		return nil, nil
+	}
+	return k.key.PublicKey, nil
+}
+
+func (k *keychain) Sign(i int, rand io.Reader, data []byte) ([]byte, error) {
+	if i != 0 {
+		return nil, errors.New("sftp: no such key")
+	}
+	h := crypto.SHA1.New()
+	h.Write(data)
+	return rsa.SignPKCS1v15(rand, k.key, crypto.SHA1, h.Sum(nil))
+}
+
+// loadKeyFile parses the unencrypted PEM RSA private key in file.
+func loadKeyFile(file string) (*rsa.PrivateKey, error) {
+	pemBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("sftp: no PEM block found in key file %q", file)
+	}
+	if block.Type != "RSA PRIVATE KEY" {
+		return nil, fmt.Errorf("sftp: unsupported key type %q in key file %q; want an RSA key", block.Type, file)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: parsing key file %q: %v", file, err)
+	}
+	return key, nil
+}
+
+func newFromConfig(_ blobserver.Loader, config jsonconfig.Obj) (blobserver.Storage, error) {
+	host := config.RequiredString("host")
+	user := config.RequiredString("user")
+	dir := config.RequiredString("dir")
+	pass := config.OptionalString("password", "")
+	keyFile := config.OptionalString("privateKeyFile", "")
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	var auth []ssh.ClientAuth
+	switch {
+	case keyFile != "":
+		key, err := loadKeyFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.ClientAuthKeyring(&keychain{key: key}))
+	case pass != "":
+		auth = append(auth, ssh.ClientAuthPassword(password(pass)))
+	default:
+		return nil, errors.New(`sftp: config requires one of "password" or "privateKeyFile"`)
+	}
+
+	conn, err := ssh.Dial("tcp", host, &ssh.ClientConfig{
+		User: user,
+		Auth: auth,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sftp: connecting to %s: %v", host, err)
+	}
+	sess, err := conn.NewSession()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sftp: opening session on %s: %v", host, err)
+	}
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := sess.RequestSubsystem("sftp"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sftp: starting sftp subsystem on %s: %v", host, err)
+	}
+	client, err := newSFTPClient(stdin, stdout)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sftp: handshake with %s: %v", host, err)
+	}
+
+	sto := &sftpStorage{
+		client: client,
+		conn:   conn,
+		host:   host,
+		dir:    dir,
+	}
+	sto.mkdirAll(dir)
+	sto.mkdirAll(sto.tmpDir())
+	if _, err := client.ReadDir(dir); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sftp: could not create or read directory %q on %s: %v", dir, host, err)
+	}
+	return sto, nil
+}
+
+func (s *sftpStorage) tmpDir() string { return path.Join(s.dir, "tmp") }
+
+// blobPath returns the remote file name for the blob br:
+// dir/<hash>/<xx>/<blobref>.dat, with xx sharding on the first two
+// digest digits.
+func (s *sftpStorage) blobPath(br blob.Ref) string {
+	return path.Join(s.dir, br.HashName(), br.Digest()[:2], br.String()+".dat")
+}
+
+// mkdirAll creates dir and its missing parents, ignoring errors for
+// components that already exist.
+func (s *sftpStorage) mkdirAll(dir string) {
+	parent := path.Dir(dir)
+	if parent != dir && parent != "." && parent != "/" {
+		s.mkdirAll(parent)
+	}
+	s.client.Mkdir(dir)
+}
+
+// tmpName returns a fresh temporary file name for an upload of br.
+func (s *sftpStorage) tmpName(br blob.Ref) string {
+	var randBytes [4]byte
+	rand.Read(randBytes[:])
+	return path.Join(s.tmpDir(), br.String()+"."+hex.EncodeToString(randBytes[:]))
+}
+
+func init() {
+	blobserver.RegisterStorageConstructor("sftp", blobserver.StorageConstructor(newFromConfig))
+}
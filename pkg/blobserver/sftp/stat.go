@@ -0,0 +1,40 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sftp
+
+import (
+	"fmt"
+	"os"
+
+	"camlistore.org/pkg/blob"
+)
+
+func (sto *sftpStorage) StatBlobs(dest chan<- blob.SizedRef, blobs []blob.Ref) error {
+	// Requests on the one SFTP connection are serialized anyway,
+	// so stat sequentially.
+	for _, br := range blobs {
+		a, err := sto.client.Stat(sto.blobPath(br))
+		if err == os.ErrNotExist {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error statting %v: %v", br, err)
+		}
+		dest <- blob.SizedRef{Ref: br, Size: a.size}
+	}
+	return nil
+}
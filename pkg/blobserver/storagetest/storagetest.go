@@ -30,13 +30,41 @@ import (
 
 	"camlistore.org/pkg/blob"
 	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/constants"
 	"camlistore.org/pkg/context"
 	"camlistore.org/pkg/syncutil"
 	"camlistore.org/pkg/test"
 )
 
+// Opts controls optional parts of the conformance suite run by TestOpt.
+type Opts struct {
+	// New returns the storage to test and a func to clean it up
+	// after the test.
+	New func(t *testing.T) (sto blobserver.Storage, cleanup func())
+
+	// SkipConcurrentReceive skips uploading the same blob from many
+	// goroutines at once, for backends that are serialized
+	// externally.
+	SkipConcurrentReceive bool
+	// SkipEnumerateWhileWriting skips enumerating concurrently with
+	// writes, for backends without a consistent enumeration view.
+	SkipEnumerateWhileWriting bool
+	// SkipLargeBlob skips the 32 MB blob round trip (1 MB with
+	// -short), for backends where it would be too slow or expensive.
+	SkipLargeBlob bool
+	// SkipCancel skips checking that EnumerateBlobs notices its
+	// context being canceled mid-enumeration.
+	SkipCancel bool
+}
+
 func Test(t *testing.T, fn func(*testing.T) (sto blobserver.Storage, cleanup func())) {
-	sto, cleanup := fn(t)
+	TestOpt(t, Opts{New: fn})
+}
+
+// TestOpt runs the conformance suite, minus the cases opts skips,
+// against the storage returned by opts.New.
+func TestOpt(t *testing.T, opts Opts) {
+	sto, cleanup := opts.New(t)
 	defer func() {
 		if t.Failed() {
 			t.Logf("test %T FAILED, skipping cleanup!", sto)
@@ -133,6 +161,32 @@ func Test(t *testing.T, fn func(*testing.T) (sto blobserver.Storage, cleanup fun
 		testEnumerate(t, sto, blobSizedRefs[:1], after, 1)
 	}
 
+	t.Logf("Testing filtered Enumerate")
+	testEnumerateFiltered(t, sto, blobSizedRefs)
+
+	t.Logf("Testing Stat on a mix of present and absent blobs")
+	testStatMixed(t, sto, blobSizedRefs)
+
+	if !opts.SkipConcurrentReceive {
+		b := testConcurrentReceive(t, sto)
+		blobRefs = append(blobRefs, b.BlobRef())
+		blobSizedRefs = append(blobSizedRefs, b.SizedRef())
+	}
+	if !opts.SkipEnumerateWhileWriting {
+		for _, b := range testEnumerateWhileWriting(t, sto, blobSizedRefs) {
+			blobRefs = append(blobRefs, b.BlobRef())
+			blobSizedRefs = append(blobSizedRefs, b.SizedRef())
+		}
+	}
+	if !opts.SkipLargeBlob {
+		b := testLargeBlob(t, sto)
+		blobRefs = append(blobRefs, b.BlobRef())
+		blobSizedRefs = append(blobSizedRefs, b.SizedRef())
+	}
+	if !opts.SkipCancel {
+		testCancelEnumerate(t, sto)
+	}
+
 	t.Logf("Testing Remove")
 	if err := sto.RemoveBlobs(blobRefs); err != nil {
 		if strings.Contains(err.Error(), "not implemented") {
@@ -141,6 +195,338 @@ func Test(t *testing.T, fn func(*testing.T) (sto blobserver.Storage, cleanup fun
 			t.Fatalf("RemoveBlob %s: %v", b1, err)
 		}
 	}
+
+	t.Logf("Testing Remove of absent blobs")
+	absent := []blob.Ref{
+		(&test.Blob{"absent blob 1"}).BlobRef(),
+		(&test.Blob{"absent blob 2"}).BlobRef(),
+	}
+	if err := sto.RemoveBlobs(absent); err != nil {
+		if strings.Contains(err.Error(), "not implemented") {
+			t.Logf("RemoveBlobs of absent blobs: %v", err)
+		} else {
+			t.Fatalf("RemoveBlobs of absent blobs: %v", err)
+		}
+	}
+}
+
+// testStatMixed checks that StatBlobs on a mix of present and absent
+// blobs reports each present blob exactly once, with its size, and
+// stays silent about the absent ones.
+func testStatMixed(t *testing.T, sto blobserver.Storage, present []blob.SizedRef) {
+	refs := []blob.Ref{(&test.Blob{"absent blob a"}).BlobRef()}
+	want := make(map[blob.Ref]uint32)
+	n := len(present)
+	if n > 3 {
+		n = 3
+	}
+	for _, sb := range present[:n] {
+		refs = append(refs, sb.Ref)
+		want[sb.Ref] = sb.Size
+	}
+	refs = append(refs, (&test.Blob{"absent blob b"}).BlobRef())
+
+	dest := make(chan blob.SizedRef, len(refs))
+	errc := make(chan error, 1)
+	go func() {
+		err := sto.StatBlobs(dest, refs)
+		close(dest)
+		errc <- err
+	}()
+	got := make(map[blob.Ref]uint32)
+	for sb := range dest {
+		if _, dup := got[sb.Ref]; dup {
+			t.Fatalf("StatBlobs sent %v twice", sb.Ref)
+		}
+		size, ok := want[sb.Ref]
+		if !ok {
+			t.Fatalf("StatBlobs sent %v, which is absent or wasn't asked about", sb.Ref)
+		}
+		if sb.Size != size {
+			t.Fatalf("StatBlobs sent %v with size %d; want %d", sb.Ref, sb.Size, size)
+		}
+		got[sb.Ref] = sb.Size
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("StatBlobs with absent blobs: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("StatBlobs reported %d blobs; want %d", len(got), len(want))
+	}
+}
+
+// testConcurrentReceive uploads the same blob from many goroutines at
+// once and checks that every upload succeeds with the right SizedRef
+// and that the blob round-trips afterwards.
+func testConcurrentReceive(t *testing.T, sto blobserver.Storage) *test.Blob {
+	t.Logf("Testing concurrent ReceiveBlob of the same blob")
+	tb := &test.Blob{"a blob received concurrently"}
+	const n = 10
+	errc := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			sb, err := sto.ReceiveBlob(tb.BlobRef(), tb.Reader())
+			if err == nil && sb != tb.SizedRef() {
+				err = fmt.Errorf("ReceiveBlob = %v; want %v", sb, tb.SizedRef())
+			}
+			errc <- err
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errc; err != nil {
+			t.Fatalf("concurrent ReceiveBlob: %v", err)
+		}
+	}
+	rc, size, err := sto.Fetch(tb.BlobRef())
+	if err != nil {
+		t.Fatalf("fetching concurrently received blob: %v", err)
+	}
+	defer rc.Close()
+	testSizedBlob(t, rc, tb.BlobRef(), int64(size))
+	return tb
+}
+
+// testEnumerateWhileWriting enumerates concurrently with writes and
+// checks that the result is still a sane snapshot: sorted, no
+// duplicates, no unknown blobs, and every blob present before the
+// enumeration started is in it. It returns the blobs it wrote.
+func testEnumerateWhileWriting(t *testing.T, sto blobserver.Storage, have []blob.SizedRef) []*test.Blob {
+	t.Logf("Testing Enumerate while writing")
+	var written []*test.Blob
+	for i := 0; i < 10; i++ {
+		written = append(written, &test.Blob{fmt.Sprintf("written during enumerate %d", i)})
+	}
+	writeErr := make(chan error, 1)
+	go func() {
+		for _, b := range written {
+			if _, err := sto.ReceiveBlob(b.BlobRef(), b.Reader()); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+		writeErr <- nil
+	}()
+
+	sbc := make(chan blob.SizedRef, 10)
+	enumErr := make(chan error, 1)
+	go func() {
+		enumErr <- sto.EnumerateBlobs(context.New(), sbc, "", 1000)
+	}()
+	var got []blob.SizedRef
+	for sb := range sbc {
+		got = append(got, sb)
+	}
+	if err := <-enumErr; err != nil {
+		t.Fatalf("Enumerate during writes: %v", err)
+	}
+	if err := <-writeErr; err != nil {
+		t.Fatalf("ReceiveBlob during enumerate: %v", err)
+	}
+
+	ok := make(map[blob.Ref]uint32, len(have)+len(written))
+	for _, sb := range have {
+		ok[sb.Ref] = sb.Size
+	}
+	for _, b := range written {
+		ok[b.BlobRef()] = uint32(b.Size())
+	}
+	seen := make(map[blob.Ref]bool, len(got))
+	for i, sb := range got {
+		if seen[sb.Ref] {
+			t.Fatalf("Enumerate during writes sent %v twice", sb.Ref)
+		}
+		seen[sb.Ref] = true
+		if size, known := ok[sb.Ref]; !known || size != sb.Size {
+			t.Fatalf("Enumerate during writes sent unexpected blob %v", sb)
+		}
+		if i > 0 && got[i-1].Ref.String() >= sb.Ref.String() {
+			t.Fatalf("Enumerate during writes not sorted: %v before %v", got[i-1].Ref, sb.Ref)
+		}
+	}
+	for _, sb := range have {
+		if !seen[sb.Ref] {
+			t.Fatalf("Enumerate during writes missed %v, present before it started", sb.Ref)
+		}
+	}
+	return written
+}
+
+// testLargeBlob round-trips a blob of the maximum allowed size,
+// constants.MaxBlobSize (1 MB with -short), well past any internal
+// buffer or chunk sizes. It returns the blob.
+func testLargeBlob(t *testing.T, sto blobserver.Storage) *test.Blob {
+	size := constants.MaxBlobSize
+	if testing.Short() {
+		size = 1 << 20
+	}
+	t.Logf("Testing a %d byte blob round trip", size)
+	data := make([]byte, size)
+	rnd := uint32(0xcab11057)
+	for i := range data {
+		rnd = rnd*1664525 + 1013904223 // any LCG will do: deterministic and incompressible enough
+		data[i] = byte(rnd >> 24)
+	}
+	b := &test.Blob{string(data)}
+	sb, err := sto.ReceiveBlob(b.BlobRef(), b.Reader())
+	if err != nil {
+		t.Fatalf("ReceiveBlob of %d byte blob: %v", size, err)
+	}
+	if sb != b.SizedRef() {
+		t.Fatalf("Received %v; want %v", sb, b.SizedRef())
+	}
+	rc, fetchSize, err := sto.Fetch(b.BlobRef())
+	if err != nil {
+		t.Fatalf("fetching %d byte blob: %v", size, err)
+	}
+	defer rc.Close()
+	testSizedBlob(t, rc, b.BlobRef(), int64(fetchSize))
+	return b
+}
+
+// testCancelEnumerate checks that EnumerateBlobs notices its context
+// being canceled mid-enumeration: it must stop sending, close dest,
+// and return context.ErrCanceled. The storage must not be empty.
+func testCancelEnumerate(t *testing.T, sto blobserver.Storage) {
+	t.Logf("Testing Enumerate cancellation")
+	ctx := context.New()
+	defer ctx.Cancel()
+	sbc := make(chan blob.SizedRef) // unbuffered, so the enumeration can't run ahead of us
+	errc := make(chan error, 1)
+	go func() {
+		errc <- sto.EnumerateBlobs(ctx, sbc, "", 1000)
+	}()
+	select {
+	case sb, ok := <-sbc:
+		if !ok {
+			t.Fatalf("Enumerate closed its channel without sending anything")
+		}
+		if !sb.Valid() {
+			t.Fatalf("invalid blobref %#v received in enumerate", sb)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timeout waiting for the first enumerated blob")
+	}
+	ctx.Cancel()
+	select {
+	case err := <-errc:
+		if err != context.ErrCanceled {
+			t.Fatalf("canceled Enumerate returned %v; want context.ErrCanceled", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timeout waiting for canceled Enumerate to return")
+	}
+	if sb, ok := <-sbc; ok {
+		t.Fatalf("canceled Enumerate still sent %v", sb)
+	}
+}
+
+// testEnumerateFiltered checks that filtered enumeration — native via
+// blobserver.FilteredEnumerator or emulated on top of EnumerateBlobs —
+// returns exactly the blobs that filtering a full enumeration
+// client-side would. all must be sorted and complete.
+func testEnumerateFiltered(t *testing.T, sto blobserver.Storage, all []blob.SizedRef) {
+	if len(all) == 0 {
+		t.Fatal("testEnumerateFiltered needs a populated storage")
+	}
+	var minSize, maxSize uint32
+	for _, sb := range all {
+		if minSize == 0 || sb.Size < minSize {
+			minSize = sb.Size
+		}
+		if sb.Size > maxSize {
+			maxSize = sb.Size
+		}
+	}
+	mid := all[len(all)/2].Ref.String()
+	filters := []blobserver.EnumerateFilter{
+		{RefPrefix: mid[:7]},             // digest type plus two hex digits
+		{RefPrefix: all[0].Ref.String()}, // exactly one blob
+		{RefPrefix: "zzzz-nothing"},
+		{MinSize: minSize + 1},
+		{MaxSize: maxSize - 1},
+		{MinSize: minSize, MaxSize: maxSize},
+		{RefPrefix: mid[:6], MinSize: minSize + 1, MaxSize: maxSize - 1},
+	}
+	for _, filter := range filters {
+		var want []blob.SizedRef
+		for _, sb := range all {
+			if filter.Matches(sb) {
+				want = append(want, sb)
+			}
+		}
+		sbc := make(chan blob.SizedRef, 16)
+		errc := make(chan error, 1)
+		go func() {
+			errc <- blobserver.EnumerateFilteredBlobs(context.New(), sto, sbc, filter, "", 1000)
+		}()
+		var got []blob.SizedRef
+		for sb := range sbc {
+			got = append(got, sb)
+		}
+		if err := <-errc; err != nil {
+			t.Fatalf("filtered Enumerate %+v: %v", filter, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("filtered Enumerate %+v = %v; want %v", filter, got, want)
+		}
+	}
+}
+
+// TestReadOnly tests the read-only subset (Fetch, Stat, Enumerate) of
+// a storage already populated with the given blobs, and that writes
+// and removals are rejected.
+func TestReadOnly(t *testing.T, sto blobserver.Storage, blobs []*test.Blob) {
+	t.Logf("Testing read-only blobserver storage %T", sto)
+
+	var blobRefs []blob.Ref
+	var blobSizedRefs []blob.SizedRef
+	for _, b := range blobs {
+		blobRefs = append(blobRefs, b.BlobRef())
+		blobSizedRefs = append(blobSizedRefs, b.SizedRef())
+	}
+
+	t.Logf("Testing Fetch")
+	for i, b := range blobs {
+		rc, size, err := sto.Fetch(b.BlobRef())
+		if err != nil {
+			t.Fatalf("error fetching %d. %s: %v", i, b, err)
+		}
+		defer rc.Close()
+		testSizedBlob(t, rc, b.BlobRef(), int64(size))
+	}
+
+	t.Logf("Testing Stat")
+	dest := make(chan blob.SizedRef)
+	go func() {
+		if err := sto.StatBlobs(dest, blobRefs); err != nil {
+			t.Fatalf("error stating blobs %s: %v", blobRefs, err)
+		}
+	}()
+	testStat(t, dest, blobSizedRefs)
+
+	sort.Sort(blob.SizedByRef(blobSizedRefs))
+
+	t.Logf("Testing Enumerate on all")
+	testEnumerate(t, sto, blobSizedRefs)
+
+	if len(blobSizedRefs) >= 3 {
+		t.Logf("Testing Enumerate 'limit' param")
+		testEnumerate(t, sto, blobSizedRefs[:3], 3)
+
+		after := blobSizedRefs[2].Ref.String()
+		t.Logf("Testing Enumerate 'after' param; after %q", after)
+		testEnumerate(t, sto, blobSizedRefs[3:], after)
+	}
+
+	t.Logf("Testing that writes are rejected")
+	b := &test.Blob{"a blob the read-only storage shouldn't accept"}
+	if _, err := sto.ReceiveBlob(b.BlobRef(), b.Reader()); err == nil {
+		t.Fatalf("ReceiveBlob succeeded; want error from read-only storage")
+	}
+	if err := sto.RemoveBlobs(blobRefs); err == nil {
+		t.Fatalf("RemoveBlobs succeeded; want error from read-only storage")
+	}
+	testEnumerate(t, sto, blobSizedRefs)
 }
 
 func testSizedBlob(t *testing.T, r io.Reader, b1 blob.Ref, size int64) {
@@ -0,0 +1,325 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package swift
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// swiftClient is a client for the OpenStack Swift object API,
+// re-authenticating transparently when its token expires.
+type swiftClient struct {
+	authURL string
+	user    string
+	apiKey  string
+	region  string
+	tenant  string
+
+	transport http.RoundTripper // or nil for http.DefaultTransport
+
+	mu         sync.Mutex
+	token      string // empty until authenticated
+	storageURL string
+}
+
+// Error is the error type returned for Swift API-level errors. I/O
+// errors are returned as-is.
+type Error struct {
+	Op         string // the failing request, e.g. "PUT /v1/AUTH_x/c/name"
+	StatusCode int
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("swift: %s: HTTP status %d", e.Op, e.StatusCode)
+}
+
+func (c *swiftClient) httpClient() *http.Client {
+	if c.transport == nil {
+		return http.DefaultClient
+	}
+	return &http.Client{Transport: c.transport}
+}
+
+// authenticate obtains a token and storage URL, using Keystone
+// password credentials if authURL looks like a v2 endpoint and the
+// legacy v1 handshake otherwise.
+func (c *swiftClient) authenticate() error {
+	var token, storageURL string
+	var err error
+	if strings.Contains(c.authURL, "/v2") {
+		token, storageURL, err = c.authKeystone()
+	} else {
+		token, storageURL, err = c.authV1()
+	}
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.token, c.storageURL = token, storageURL
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *swiftClient) authV1() (token, storageURL string, err error) {
+	req, err := http.NewRequest("GET", c.authURL, nil)
+	if err != nil {
+		return
+	}
+	user := c.user
+	if c.tenant != "" {
+		user = c.tenant + ":" + user
+	}
+	req.Header.Set("X-Auth-User", user)
+	req.Header.Set("X-Auth-Key", c.apiKey)
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return "", "", fmt.Errorf("swift: v1 auth against %s failed: HTTP status %d", c.authURL, res.StatusCode)
+	}
+	token = res.Header.Get("X-Auth-Token")
+	storageURL = res.Header.Get("X-Storage-Url")
+	if token == "" || storageURL == "" {
+		return "", "", fmt.Errorf("swift: v1 auth against %s returned no token or storage URL", c.authURL)
+	}
+	return token, storageURL, nil
+}
+
+func (c *swiftClient) authKeystone() (token, storageURL string, err error) {
+	authReq := map[string]interface{}{
+		"auth": map[string]interface{}{
+			"passwordCredentials": map[string]string{
+				"username": c.user,
+				"password": c.apiKey,
+			},
+		},
+	}
+	if c.tenant != "" {
+		authReq["auth"].(map[string]interface{})["tenantName"] = c.tenant
+	}
+	body, err := json.Marshal(authReq)
+	if err != nil {
+		return
+	}
+	res, err := c.httpClient().Post(strings.TrimSuffix(c.authURL, "/")+"/tokens",
+		"application/json", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return "", "", fmt.Errorf("swift: keystone auth against %s failed: HTTP status %d", c.authURL, res.StatusCode)
+	}
+	var access struct {
+		Access struct {
+			Token struct {
+				ID string `json:"id"`
+			} `json:"token"`
+			ServiceCatalog []struct {
+				Type      string `json:"type"`
+				Endpoints []struct {
+					Region    string `json:"region"`
+					PublicURL string `json:"publicURL"`
+				} `json:"endpoints"`
+			} `json:"serviceCatalog"`
+		} `json:"access"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&access); err != nil {
+		return "", "", fmt.Errorf("swift: decoding keystone auth response: %v", err)
+	}
+	for _, svc := range access.Access.ServiceCatalog {
+		if svc.Type != "object-store" {
+			continue
+		}
+		for _, ep := range svc.Endpoints {
+			if c.region == "" || ep.Region == c.region {
+				return access.Access.Token.ID, ep.PublicURL, nil
+			}
+		}
+	}
+	if c.region != "" {
+		return "", "", fmt.Errorf("swift: no object-store endpoint in region %q", c.region)
+	}
+	return "", "", fmt.Errorf("swift: no object-store endpoint in keystone service catalog")
+}
+
+func (c *swiftClient) currentAuth() (token, storageURL string, err error) {
+	c.mu.Lock()
+	token, storageURL = c.token, c.storageURL
+	c.mu.Unlock()
+	if token != "" {
+		return
+	}
+	if err = c.authenticate(); err != nil {
+		return
+	}
+	c.mu.Lock()
+	token, storageURL = c.token, c.storageURL
+	c.mu.Unlock()
+	return
+}
+
+// do sends one authenticated request, re-authenticating and retrying
+// once on 401. path is relative to the storage URL and already
+// escaped; body may be nil.
+func (c *swiftClient) do(method, path string, body []byte) (*http.Response, error) {
+	retried := false
+	for {
+		token, storageURL, err := c.currentAuth()
+		if err != nil {
+			return nil, err
+		}
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, storageURL+path, r)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.ContentLength = int64(len(body))
+		}
+		req.Header.Set("X-Auth-Token", token)
+		res, err := c.httpClient().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode == http.StatusUnauthorized && !retried {
+			retried = true
+			res.Body.Close()
+			if err := c.authenticate(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if res.StatusCode/100 != 2 {
+			res.Body.Close()
+			return nil, &Error{Op: method + " " + path, StatusCode: res.StatusCode}
+		}
+		return res, nil
+	}
+}
+
+func objectPath(container, name string) string {
+	return "/" + url.QueryEscape(container) + "/" + url.QueryEscape(name)
+}
+
+// CreateContainer creates the container if it doesn't exist yet.
+func (c *swiftClient) CreateContainer(container string) error {
+	res, err := c.do("PUT", "/"+url.QueryEscape(container), nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Put stores contents as the named object.
+func (c *swiftClient) Put(container, name string, contents []byte) error {
+	res, err := c.do("PUT", objectPath(container, name), contents)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Get returns the content and size of the named object. It returns
+// os.ErrNotExist if the object doesn't exist.
+func (c *swiftClient) Get(container, name string) (io.ReadCloser, int64, error) {
+	res, err := c.do("GET", objectPath(container, name), nil)
+	if swErr, ok := err.(*Error); ok && swErr.StatusCode == http.StatusNotFound {
+		return nil, 0, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return res.Body, res.ContentLength, nil
+}
+
+// Stat returns the size of the named object, or os.ErrNotExist.
+func (c *swiftClient) Stat(container, name string) (int64, error) {
+	res, err := c.do("HEAD", objectPath(container, name), nil)
+	if swErr, ok := err.(*Error); ok && swErr.StatusCode == http.StatusNotFound {
+		return 0, os.ErrNotExist
+	}
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	return res.ContentLength, nil
+}
+
+// Delete deletes the named object. Deleting an object that doesn't
+// exist is not an error.
+func (c *swiftClient) Delete(container, name string) error {
+	res, err := c.do("DELETE", objectPath(container, name), nil)
+	if swErr, ok := err.(*Error); ok && swErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// swiftObject is one object of a container listing.
+type swiftObject struct {
+	Name string `json:"name"`
+	Size int64  `json:"bytes"`
+}
+
+// ListObjects lists up to limit objects of the container in lexical
+// order, starting after marker (exclusive). An empty result means
+// the listing is complete.
+func (c *swiftClient) ListObjects(container, marker string, limit int) ([]swiftObject, error) {
+	query := url.Values{
+		"format": {"json"},
+		"limit":  {strconv.Itoa(limit)},
+	}
+	if marker != "" {
+		query.Set("marker", marker)
+	}
+	res, err := c.do("GET", "/"+url.QueryEscape(container)+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	var objs []swiftObject
+	if err := json.Unmarshal(body, &objs); err != nil {
+		return nil, fmt.Errorf("swift: decoding listing of container %q: %v", container, err)
+	}
+	return objs, nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package swift registers the "swift" blobserver storage type, storing
+blobs in an OpenStack Swift container.
+
+Example low-level config:
+
+     "/r1/": {
+         "handler": "storage-swift",
+         "handlerArgs": {
+            "auth_url": "https://keystone.example.com:5000/v2.0",
+            "user": "camlistore",
+            "api_key": "secret",
+            "container": "camliblobs",
+            "tenant": "mytenant",
+            "region": "RegionOne"
+          }
+     },
+
+An "auth_url" containing "/v2" authenticates against Keystone
+(password credentials, with the optional "tenant" and "region"
+selecting the object-store endpoint); any other URL uses the legacy
+v1 X-Auth-User/X-Auth-Key handshake, where "tenant" (if set) is
+prepended to the user as "tenant:user". The container is created at
+start if it doesn't exist.
+*/
+package swift
+
+import (
+	"fmt"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/jsonconfig"
+)
+
+type swiftStorage struct {
+	client    *swiftClient
+	container string
+}
+
+func (s *swiftStorage) String() string {
+	return fmt.Sprintf("\"swift\" blob storage at %q, container %q", s.client.authURL, s.container)
+}
+
+func newFromConfig(_ blobserver.Loader, config jsonconfig.Obj) (blobserver.Storage, error) {
+	client := &swiftClient{
+		authURL: config.RequiredString("auth_url"),
+		user:    config.RequiredString("user"),
+		apiKey:  config.RequiredString("api_key"),
+		region:  config.OptionalString("region", ""),
+		tenant:  config.OptionalString("tenant", ""),
+	}
+	sto := &swiftStorage{
+		client:    client,
+		container: config.RequiredString("container"),
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if err := client.CreateContainer(sto.container); err != nil {
+		return nil, fmt.Errorf("swift: error creating container %q: %v", sto.container, err)
+	}
+	return sto, nil
+}
+
+// key returns the Swift object name for the blob br.
+func (s *swiftStorage) key(br blob.Ref) string {
+	return br.String()
+}
+
+func init() {
+	blobserver.RegisterStorageConstructor("swift", blobserver.StorageConstructor(newFromConfig))
+}
@@ -0,0 +1,334 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package tiered registers the "tiered" blobserver storage type, which
+presents two other blobservers -- a fast, expensive "hot" one and a
+slow, cheap "cold" one -- as a single storage. New blobs are written to
+the hot storage; a background job periodically moves blobs which
+haven't been fetched in maxFetchAge to the cold storage. The time of
+each blob's last fetch is tracked in the provided sorted key/value
+store. Fetches check the hot storage first and fall back to the cold
+one; stats and enumerates present the union of both.
+
+Example config:
+
+	"/bs/": {
+	    "handler": "storage-tiered",
+	    "handlerArgs": {
+	        "hot": "/bs-hot/",
+	        "cold": "/bs-cold/",
+	        "meta": {"type": "leveldb", "file": "/var/camlistore/tiered.leveldb"},
+	        "maxFetchAge": "2160h",
+	        "scanInterval": "1h",
+	        "promoteOnFetch": true
+	    }
+	}
+*/
+package tiered
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/context"
+	"camlistore.org/pkg/jsonconfig"
+	"camlistore.org/pkg/sorted"
+)
+
+const (
+	defaultMaxFetchAge  = 8760 * time.Hour // a year
+	defaultScanInterval = time.Hour
+)
+
+type tieredStorage struct {
+	hot, cold blobserver.Storage
+	kv        sorted.KeyValue // blobref -> unix time of last fetch
+	maxAge    time.Duration   // move to cold when unfetched this long
+	interval  time.Duration   // between migration scans; 0 disables them
+	promote   bool            // copy cold hits back to hot
+
+	mu    sync.Mutex // guards stats
+	stats tieredStatus
+}
+
+// tieredStatus is the value reported on /status/ for a tiered storage.
+type tieredStatus struct {
+	HotBlobs      int   `json:"hotBlobs"`
+	HotBytes      int64 `json:"hotBytes"`
+	ColdBlobs     int   `json:"coldBlobs"`
+	ColdBytes     int64 `json:"coldBytes"`
+	MigratedBlobs int64 `json:"migratedBlobs"` // total over this process' lifetime
+	MigratedBytes int64 `json:"migratedBytes"`
+
+	LastScan        time.Time `json:"lastScan,omitempty"`
+	LastScanSeconds float64   `json:"lastScanSeconds,omitempty"`
+	// LastScanBytesPerSec is the migration throughput of the last
+	// scan that moved any blobs.
+	LastScanBytesPerSec int64 `json:"lastScanBytesPerSec,omitempty"`
+}
+
+func init() {
+	blobserver.RegisterStorageConstructor("tiered", blobserver.StorageConstructor(newFromConfig))
+}
+
+func newFromConfig(ld blobserver.Loader, config jsonconfig.Obj) (blobserver.Storage, error) {
+	var (
+		hot         = config.RequiredString("hot")
+		cold        = config.RequiredString("cold")
+		kvConf      = config.RequiredObject("meta")
+		maxAgeStr   = config.OptionalString("maxFetchAge", "")
+		intervalStr = config.OptionalString("scanInterval", "")
+		promote     = config.OptionalBool("promoteOnFetch", false)
+	)
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	sto := &tieredStorage{
+		maxAge:   defaultMaxFetchAge,
+		interval: defaultScanInterval,
+		promote:  promote,
+	}
+	var err error
+	if sto.hot, err = ld.GetStorage(hot); err != nil {
+		return nil, err
+	}
+	if sto.cold, err = ld.GetStorage(cold); err != nil {
+		return nil, err
+	}
+	if sto.kv, err = sorted.NewKeyValue(kvConf); err != nil {
+		return nil, err
+	}
+	if maxAgeStr != "" {
+		if sto.maxAge, err = time.ParseDuration(maxAgeStr); err != nil {
+			return nil, fmt.Errorf("invalid maxFetchAge %q: %v", maxAgeStr, err)
+		}
+	}
+	if intervalStr != "" {
+		if sto.interval, err = time.ParseDuration(intervalStr); err != nil {
+			return nil, fmt.Errorf("invalid scanInterval %q: %v", intervalStr, err)
+		}
+	}
+	if sto.interval > 0 {
+		go sto.scanLoop()
+	}
+	return sto, nil
+}
+
+func (sto *tieredStorage) ReceiveBlob(br blob.Ref, src io.Reader) (blob.SizedRef, error) {
+	sb, err := sto.hot.ReceiveBlob(br, src)
+	if err != nil {
+		return sb, err
+	}
+	// Seed the last-fetch time, so a new blob gets a full maxAge in
+	// the hot storage before it's eligible for migration.
+	sto.touchBlob(br)
+	return sb, nil
+}
+
+func (sto *tieredStorage) Fetch(b blob.Ref) (io.ReadCloser, uint32, error) {
+	rc, size, err := sto.hot.Fetch(b)
+	if err == nil {
+		sto.touchBlob(b)
+		return rc, size, nil
+	}
+	rc, size, err = sto.cold.Fetch(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	sto.touchBlob(b)
+	if !sto.promote {
+		return rc, size, nil
+	}
+	all, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, 0, err
+	}
+	go sto.promoteBlob(b, all)
+	return ioutil.NopCloser(bytes.NewReader(all)), size, nil
+}
+
+// promoteBlob copies a blob fetched from the cold storage back to the
+// hot one and removes the cold copy.
+func (sto *tieredStorage) promoteBlob(br blob.Ref, contents []byte) {
+	if _, err := blobserver.Receive(sto.hot, br, bytes.NewReader(contents)); err != nil {
+		log.Printf("tiered: promoting %v to hot storage: %v", br, err)
+		return
+	}
+	if err := sto.cold.RemoveBlobs([]blob.Ref{br}); err != nil {
+		log.Printf("tiered: removing promoted %v from cold storage: %v", br, err)
+	}
+}
+
+func (sto *tieredStorage) StatBlobs(dest chan<- blob.SizedRef, blobs []blob.Ref) error {
+	ch := make(chan blob.SizedRef, len(blobs))
+	if err := sto.hot.StatBlobs(ch, blobs); err != nil {
+		return err
+	}
+	close(ch)
+	inHot := make(map[blob.Ref]bool)
+	for sb := range ch {
+		inHot[sb.Ref] = true
+		dest <- sb
+	}
+	var missing []blob.Ref
+	for _, br := range blobs {
+		if !inHot[br] {
+			missing = append(missing, br)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return sto.cold.StatBlobs(dest, missing)
+}
+
+func (sto *tieredStorage) EnumerateBlobs(ctx *context.Context, dest chan<- blob.SizedRef, after string, limit int) error {
+	return blobserver.MergedEnumerate(ctx, dest, []blobserver.Storage{sto.hot, sto.cold}, after, limit)
+}
+
+func (sto *tieredStorage) RemoveBlobs(blobs []blob.Ref) error {
+	err1 := sto.hot.RemoveBlobs(blobs)
+	err2 := sto.cold.RemoveBlobs(blobs)
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+// touchBlob records now as br's last fetch time.
+func (sto *tieredStorage) touchBlob(br blob.Ref) {
+	if err := sto.kv.Set(br.String(), strconv.FormatInt(time.Now().Unix(), 10)); err != nil {
+		log.Printf("tiered: recording fetch time of %v: %v", br, err)
+	}
+}
+
+// lastFetch returns br's recorded last fetch time. Blobs with no
+// record (e.g. ones predating this handler) are seeded with now, so
+// they get one full maxAge before migrating.
+func (sto *tieredStorage) lastFetch(br blob.Ref) time.Time {
+	v, err := sto.kv.Get(br.String())
+	if err != nil {
+		sto.touchBlob(br)
+		return time.Now()
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(n, 0)
+}
+
+func (sto *tieredStorage) scanLoop() {
+	for {
+		time.Sleep(sto.interval)
+		if err := sto.scan(); err != nil {
+			log.Printf("tiered: migration scan: %v", err)
+		}
+	}
+}
+
+// scan enumerates the hot storage, moves blobs unfetched for maxAge to
+// the cold storage, and refreshes the per-tier counters.
+func (sto *tieredStorage) scan() error {
+	start := time.Now()
+	cutoff := start.Add(-sto.maxAge)
+	var (
+		hotBlobs   int
+		hotBytes   int64
+		candidates []blob.SizedRef
+	)
+	err := blobserver.EnumerateAll(context.TODO(), sto.hot, func(sb blob.SizedRef) error {
+		hotBlobs++
+		hotBytes += int64(sb.Size)
+		if sto.lastFetch(sb.Ref).Before(cutoff) {
+			candidates = append(candidates, sb)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var movedBlobs, movedBytes int64
+	for _, sb := range candidates {
+		if err := sto.migrateBlob(sb.Ref); err != nil {
+			log.Printf("tiered: migrating %v to cold storage: %v", sb.Ref, err)
+			continue
+		}
+		movedBlobs++
+		movedBytes += int64(sb.Size)
+		hotBlobs--
+		hotBytes -= int64(sb.Size)
+	}
+	var (
+		coldBlobs int
+		coldBytes int64
+	)
+	err = blobserver.EnumerateAll(context.TODO(), sto.cold, func(sb blob.SizedRef) error {
+		coldBlobs++
+		coldBytes += int64(sb.Size)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+
+	sto.mu.Lock()
+	defer sto.mu.Unlock()
+	st := &sto.stats
+	st.HotBlobs, st.HotBytes = hotBlobs, hotBytes
+	st.ColdBlobs, st.ColdBytes = coldBlobs, coldBytes
+	st.MigratedBlobs += movedBlobs
+	st.MigratedBytes += movedBytes
+	st.LastScan = start
+	st.LastScanSeconds = elapsed.Seconds()
+	if movedBytes > 0 && elapsed > 0 {
+		st.LastScanBytesPerSec = int64(float64(movedBytes) / elapsed.Seconds())
+	}
+	return nil
+}
+
+// migrateBlob moves one blob from the hot storage to the cold one.
+func (sto *tieredStorage) migrateBlob(br blob.Ref) error {
+	rc, _, err := sto.hot.Fetch(br)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if _, err := blobserver.Receive(sto.cold, br, rc); err != nil {
+		return err
+	}
+	return sto.hot.RemoveBlobs([]blob.Ref{br})
+}
+
+// StorageStatus implements blobserver.StorageStatusReporter. The
+// counters are refreshed by each migration scan.
+func (sto *tieredStorage) StorageStatus() interface{} {
+	sto.mu.Lock()
+	defer sto.mu.Unlock()
+	st := sto.stats
+	return &st
+}
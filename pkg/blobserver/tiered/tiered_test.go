@@ -0,0 +1,136 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tiered
+
+import (
+	"testing"
+	"time"
+
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/blobserver/storagetest"
+	"camlistore.org/pkg/jsonconfig"
+	"camlistore.org/pkg/test"
+)
+
+func newTiered(t *testing.T, config jsonconfig.Obj) *tieredStorage {
+	if config == nil {
+		config = jsonconfig.Obj{}
+	}
+	if _, ok := config["hot"]; !ok {
+		config["hot"] = "/good-hot/"
+	}
+	if _, ok := config["cold"]; !ok {
+		config["cold"] = "/good-cold/"
+	}
+	if _, ok := config["meta"]; !ok {
+		config["meta"] = map[string]interface{}{"type": "memory"}
+	}
+	if _, ok := config["scanInterval"]; !ok {
+		config["scanInterval"] = "0s" // no background scans in tests
+	}
+	sto, err := newFromConfig(test.NewLoader(), config)
+	if err != nil {
+		t.Fatalf("Invalid config: %v", err)
+	}
+	return sto.(*tieredStorage)
+}
+
+func TestTiered(t *testing.T) {
+	storagetest.Test(t, func(t *testing.T) (blobserver.Storage, func()) {
+		return newTiered(t, nil), func() {}
+	})
+}
+
+func TestMigration(t *testing.T) {
+	sto := newTiered(t, nil)
+	old := &test.Blob{Contents: "old blob"}
+	recent := &test.Blob{Contents: "recent blob"}
+	for _, tb := range []*test.Blob{old, recent} {
+		if _, err := blobserver.Receive(sto, tb.BlobRef(), tb.Reader()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Age the old blob's last-fetch record past maxAge.
+	sto.maxAge = time.Minute
+	if err := sto.kv.Set(old.BlobRef().String(), "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sto.scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	hot := sto.hot.(*test.Fetcher)
+	cold := sto.cold.(*test.Fetcher)
+	if _, ok := hot.BlobContents(old.BlobRef()); ok {
+		t.Error("old blob still in hot storage after scan")
+	}
+	if _, ok := cold.BlobContents(old.BlobRef()); !ok {
+		t.Error("old blob not in cold storage after scan")
+	}
+	if _, ok := hot.BlobContents(recent.BlobRef()); !ok {
+		t.Error("recent blob missing from hot storage after scan")
+	}
+
+	// The union storage still serves both.
+	for _, tb := range []*test.Blob{old, recent} {
+		if _, err := blobserver.StatBlob(sto, tb.BlobRef()); err != nil {
+			t.Errorf("StatBlob(%v) after scan: %v", tb.BlobRef(), err)
+		}
+		rc, _, err := sto.Fetch(tb.BlobRef())
+		if err != nil {
+			t.Errorf("Fetch(%v) after scan: %v", tb.BlobRef(), err)
+			continue
+		}
+		rc.Close()
+	}
+
+	st := sto.StorageStatus().(*tieredStatus)
+	if st.MigratedBlobs != 1 || st.MigratedBytes != int64(len(old.Contents)) {
+		t.Errorf("migrated %d blobs (%d bytes); want 1 blob (%d bytes)",
+			st.MigratedBlobs, st.MigratedBytes, len(old.Contents))
+	}
+	if st.HotBlobs != 1 || st.ColdBlobs != 1 {
+		t.Errorf("hot/cold blobs = %d/%d; want 1/1", st.HotBlobs, st.ColdBlobs)
+	}
+}
+
+func TestPromoteOnFetch(t *testing.T) {
+	sto := newTiered(t, jsonconfig.Obj{"promoteOnFetch": true})
+	tb := &test.Blob{Contents: "cold blob"}
+	cold := sto.cold.(*test.Fetcher)
+	cold.AddBlob(tb)
+
+	rc, _, err := sto.Fetch(tb.BlobRef())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+
+	hot := sto.hot.(*test.Fetcher)
+	ok := false
+	for i := 0; i < 100; i++ { // promotion is asynchronous
+		if _, ok = hot.BlobContents(tb.BlobRef()); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !ok {
+		t.Error("cold blob wasn't promoted to hot storage after fetch")
+	}
+}
+
+var _ blobserver.StorageStatusReporter = (*tieredStorage)(nil)
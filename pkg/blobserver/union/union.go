@@ -0,0 +1,194 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package union registers the "union" read-only blobserver storage type,
+presenting the union of the blobs in an ordered list of other
+storages. Fetches and stats try each backend in order; enumerates
+merge the backends' blobs, with duplicates removed. All writes and
+removals are rejected.
+
+By default a backend erroring during an enumerate or a stat is only
+logged, and the remaining backends' blobs are still returned. With
+"strict" set, any backend error fails the whole operation.
+
+Example config:
+
+	"/union/": {
+	    "handler": "storage-union",
+	    "handlerArgs": {
+	        "backends": ["/old-disk/", "/packed/", "/sto-s3/"]
+	    }
+	},
+*/
+package union
+
+import (
+	"errors"
+	"io"
+	"log"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/context"
+	"camlistore.org/pkg/jsonconfig"
+)
+
+const buffered = 8
+
+var errReadOnly = errors.New("union: storage is read-only")
+
+type unionStorage struct {
+	backendPrefixes []string
+	backends        []blobserver.Storage
+
+	// strict controls what a failing backend does to the whole
+	// operation: when true, any backend error fails it; when false,
+	// the error is logged and the other backends' answers are used.
+	strict bool
+}
+
+func newFromConfig(ld blobserver.Loader, config jsonconfig.Obj) (blobserver.Storage, error) {
+	sto := &unionStorage{
+		backendPrefixes: config.RequiredList("backends"),
+		strict:          config.OptionalBool("strict", false),
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if len(sto.backendPrefixes) == 0 {
+		return nil, errors.New("union: need at least one backend")
+	}
+	for _, prefix := range sto.backendPrefixes {
+		s, err := ld.GetStorage(prefix)
+		if err != nil {
+			return nil, err
+		}
+		sto.backends = append(sto.backends, s)
+	}
+	return sto, nil
+}
+
+// NewForTest returns a union storage reading from the provided
+// storages in order.
+func NewForTest(sto []blobserver.Storage) blobserver.Storage {
+	sto = append([]blobserver.Storage(nil), sto...) // clone
+	names := make([]string, len(sto))
+	for i := range names {
+		names[i] = "/unknown-prefix/"
+	}
+	return &unionStorage{
+		backendPrefixes: names,
+		backends:        sto,
+	}
+}
+
+func (sto *unionStorage) Fetch(b blob.Ref) (file io.ReadCloser, size uint32, err error) {
+	for _, backend := range sto.backends {
+		file, size, err = backend.Fetch(b)
+		if err == nil {
+			return
+		}
+	}
+	return
+}
+
+// StatBlobs stats all backends, reporting each blob at most once.
+func (sto *unionStorage) StatBlobs(dest chan<- blob.SizedRef, blobs []blob.Ref) error {
+	need := make(map[blob.Ref]bool)
+	for _, br := range blobs {
+		need[br] = true
+	}
+
+	ch := make(chan blob.SizedRef, buffered)
+	donec := make(chan bool)
+
+	go func() {
+		for sb := range ch {
+			if need[sb.Ref] {
+				dest <- sb
+				delete(need, sb.Ref)
+			}
+		}
+		donec <- true
+	}()
+
+	type idxErr struct {
+		idx int
+		err error
+	}
+	errc := make(chan idxErr, buffered)
+	statBackend := func(idx int, s blobserver.Storage) {
+		errc <- idxErr{idx, s.StatBlobs(ch, blobs)}
+	}
+
+	for idx, backend := range sto.backends {
+		go statBackend(idx, backend)
+	}
+
+	var retErr error
+	for _ = range sto.backends {
+		if res := <-errc; res.err != nil {
+			if !sto.strict {
+				log.Printf("union: stat error from %s (continuing): %v", sto.backendPrefixes[res.idx], res.err)
+				continue
+			}
+			retErr = res.err
+		}
+	}
+	close(ch)
+	<-donec
+
+	return retErr
+}
+
+func (sto *unionStorage) EnumerateBlobs(ctx *context.Context, dest chan<- blob.SizedRef, after string, limit int) error {
+	sources := sto.backends
+	if !sto.strict {
+		sources = make([]blobserver.Storage, len(sto.backends))
+		for i, backend := range sto.backends {
+			sources[i] = enumerateLogsErrors{backend, sto.backendPrefixes[i]}
+		}
+	}
+	return blobserver.MergedEnumerate(ctx, dest, sources, after, limit)
+}
+
+// enumerateLogsErrors wraps a backend so that an enumerate error is
+// logged and reported as a clean end of that backend's blobs, letting
+// MergedEnumerate finish with the remaining backends.
+type enumerateLogsErrors struct {
+	blobserver.Storage
+	prefix string
+}
+
+func (s enumerateLogsErrors) EnumerateBlobs(ctx *context.Context, dest chan<- blob.SizedRef, after string, limit int) error {
+	if err := s.Storage.EnumerateBlobs(ctx, dest, after, limit); err != nil {
+		log.Printf("union: enumerate error from %s (continuing): %v", s.prefix, err)
+	}
+	return nil
+}
+
+func (sto *unionStorage) ReceiveBlob(br blob.Ref, src io.Reader) (blob.SizedRef, error) {
+	return blob.SizedRef{}, errReadOnly
+}
+
+func (sto *unionStorage) RemoveBlobs(blobs []blob.Ref) error {
+	return errReadOnly
+}
+
+func init() {
+	blobserver.RegisterStorageConstructor("union", blobserver.StorageConstructor(newFromConfig))
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package union
+
+import (
+	"errors"
+	"testing"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/blobserver/storagetest"
+	"camlistore.org/pkg/context"
+	"camlistore.org/pkg/jsonconfig"
+	"camlistore.org/pkg/test"
+)
+
+func newUnion(t *testing.T, config jsonconfig.Obj) *unionStorage {
+	if config == nil {
+		config = jsonconfig.Obj{}
+	}
+	if _, ok := config["backends"]; !ok {
+		config["backends"] = []interface{}{"/good-1/", "/good-2/"}
+	}
+	sto, err := newFromConfig(test.NewLoader(), config)
+	if err != nil {
+		t.Fatalf("Invalid config: %v", err)
+	}
+	return sto.(*unionStorage)
+}
+
+func TestUnion(t *testing.T) {
+	sto := newUnion(t, nil)
+	blobs := []*test.Blob{
+		{Contents: "foo"},
+		{Contents: "quux"},
+		{Contents: "asdf"},
+		{Contents: "qwerty"},
+	}
+	// Scatter the blobs over the backends, with one in both.
+	back1 := sto.backends[0].(*test.Fetcher)
+	back2 := sto.backends[1].(*test.Fetcher)
+	back1.AddBlob(blobs[0])
+	back2.AddBlob(blobs[1])
+	back2.AddBlob(blobs[2])
+	back1.AddBlob(blobs[3])
+	back2.AddBlob(blobs[3])
+	storagetest.TestReadOnly(t, sto, blobs)
+}
+
+// enumerateFails wraps a storage whose enumerates always fail.
+type enumerateFails struct {
+	blobserver.Storage
+}
+
+func (s enumerateFails) EnumerateBlobs(ctx *context.Context, dest chan<- blob.SizedRef, after string, limit int) error {
+	close(dest)
+	return errors.New("intentional enumerate failure")
+}
+
+func TestBrokenBackendEnumerate(t *testing.T) {
+	good := &test.Fetcher{}
+	b := &test.Blob{Contents: "in the good backend"}
+	good.AddBlob(b)
+	broken := enumerateFails{&test.Fetcher{}}
+
+	sto := NewForTest([]blobserver.Storage{good, broken})
+	if err := storagetest.CheckEnumerate(sto, []blob.SizedRef{b.SizedRef()}); err != nil {
+		t.Errorf("enumerate with broken backend: %v", err)
+	}
+
+	strict := NewForTest([]blobserver.Storage{good, broken}).(*unionStorage)
+	strict.strict = true
+	dest := make(chan blob.SizedRef, 16)
+	if err := strict.EnumerateBlobs(context.New(), dest, "", 100); err == nil {
+		t.Errorf("strict enumerate with broken backend succeeded; want error")
+	}
+}
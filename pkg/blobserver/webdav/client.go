@@ -0,0 +1,279 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webdav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// webdavClient is a minimal WebDAV (RFC 4918) client, implementing
+// just the methods the blobserver needs: GET, HEAD, PUT, DELETE,
+// MKCOL and PROPFIND with Depth 1.
+type webdavClient struct {
+	base     *url.URL // collection holding the blobs; path has no trailing slash
+	username string   // optional basic auth
+	password string
+
+	transport http.RoundTripper // or nil for the default
+}
+
+// Error is the error type returned for unexpected WebDAV server
+// responses.
+type Error struct {
+	Op         string
+	Path       string
+	StatusCode int
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("webdav: %s %q: HTTP status %d", e.Op, e.Path, e.StatusCode)
+}
+
+func (c *webdavClient) httpClient() *http.Client {
+	if c.transport == nil {
+		return http.DefaultClient
+	}
+	return &http.Client{Transport: c.transport}
+}
+
+// newRequest makes a request for the resource at relPath below the
+// base collection, or for the collection itself if relPath is empty.
+func (c *webdavClient) newRequest(method, relPath string, body io.Reader) (*http.Request, error) {
+	u := *c.base
+	if relPath != "" {
+		u.Path += "/" + relPath
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	return req, nil
+}
+
+// discard drains and closes a response body so the underlying
+// connection can be reused.
+func discard(res *http.Response) {
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+}
+
+// Put stores data at relPath. The parent collections must already
+// exist; a missing intermediate collection is reported as a 409 in
+// the returned *Error.
+func (c *webdavClient) Put(relPath string, data []byte) error {
+	req, err := c.newRequest("PUT", relPath, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(data))
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer discard(res)
+	if res.StatusCode/100 != 2 {
+		return &Error{Op: "PUT", Path: relPath, StatusCode: res.StatusCode}
+	}
+	return nil
+}
+
+// Get fetches the resource at relPath, returning its body and size.
+// A missing resource is reported as os.ErrNotExist.
+func (c *webdavClient) Get(relPath string) (io.ReadCloser, uint32, error) {
+	req, err := c.newRequest("GET", relPath, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		discard(res)
+		return nil, 0, os.ErrNotExist
+	}
+	if res.StatusCode/100 != 2 {
+		discard(res)
+		return nil, 0, &Error{Op: "GET", Path: relPath, StatusCode: res.StatusCode}
+	}
+	return res.Body, uint32(res.ContentLength), nil
+}
+
+// Stat returns the size of the resource at relPath, or
+// os.ErrNotExist if it does not exist.
+func (c *webdavClient) Stat(relPath string) (uint32, error) {
+	req, err := c.newRequest("HEAD", relPath, nil)
+	if err != nil {
+		return 0, err
+	}
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer discard(res)
+	if res.StatusCode == http.StatusNotFound {
+		return 0, os.ErrNotExist
+	}
+	if res.StatusCode/100 != 2 {
+		return 0, &Error{Op: "HEAD", Path: relPath, StatusCode: res.StatusCode}
+	}
+	return uint32(res.ContentLength), nil
+}
+
+// Delete removes the resource at relPath. Deleting a resource that
+// does not exist is not an error.
+func (c *webdavClient) Delete(relPath string) error {
+	req, err := c.newRequest("DELETE", relPath, nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer discard(res)
+	if res.StatusCode == http.StatusNotFound || res.StatusCode/100 == 2 {
+		return nil
+	}
+	return &Error{Op: "DELETE", Path: relPath, StatusCode: res.StatusCode}
+}
+
+// Mkcol creates the collection at relPath. The server replies 405 if
+// it already exists, which callers generally ignore.
+func (c *webdavClient) Mkcol(relPath string) error {
+	req, err := c.newRequest("MKCOL", relPath, nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer discard(res)
+	if res.StatusCode/100 != 2 {
+		return &Error{Op: "MKCOL", Path: relPath, StatusCode: res.StatusCode}
+	}
+	return nil
+}
+
+// MkcolAll creates the collection at relPath and any missing parents,
+// ignoring errors for collections that already exist.
+func (c *webdavClient) MkcolAll(relPath string) {
+	if relPath != "" {
+		if parent := path.Dir(relPath); parent != "." {
+			c.MkcolAll(parent)
+		}
+	}
+	c.Mkcol(relPath)
+}
+
+type davEntry struct {
+	name string
+	size uint32
+	dir  bool
+}
+
+const propfindBody = `<?xml version="1.0"?>
+<propfind xmlns="DAV:"><prop><getcontentlength/><resourcetype/></prop></propfind>
+`
+
+// davMultistatus is the 207 Multi-Status response to a PROPFIND.
+type davMultistatus struct {
+	XMLName  xml.Name      `xml:"DAV: multistatus"`
+	Response []davResponse `xml:"response"`
+}
+
+type davResponse struct {
+	Href     string        `xml:"href"`
+	Propstat []davPropstat `xml:"propstat"`
+}
+
+type davPropstat struct {
+	Prop struct {
+		ContentLength string `xml:"getcontentlength"`
+		ResourceType  struct {
+			Collection *struct{} `xml:"collection"`
+		} `xml:"resourcetype"`
+	} `xml:"prop"`
+}
+
+// List returns the members of the collection at relPath, using a
+// PROPFIND with Depth 1. A missing collection is reported as
+// os.ErrNotExist.
+func (c *webdavClient) List(relPath string) ([]davEntry, error) {
+	req, err := c.newRequest("PROPFIND", relPath, strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, os.ErrNotExist
+	}
+	if res.StatusCode != http.StatusMultiStatus && res.StatusCode/100 != 2 {
+		return nil, &Error{Op: "PROPFIND", Path: relPath, StatusCode: res.StatusCode}
+	}
+	var ms davMultistatus
+	if err := xml.NewDecoder(res.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("webdav: parsing PROPFIND response for %q: %v", relPath, err)
+	}
+	selfPath := c.base.Path
+	if relPath != "" {
+		selfPath += "/" + relPath
+	}
+	var entries []davEntry
+	for _, r := range ms.Response {
+		hrefURL, err := url.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+		href := strings.TrimSuffix(hrefURL.Path, "/")
+		if href == selfPath {
+			// The Depth 1 response includes the collection itself.
+			continue
+		}
+		ent := davEntry{name: path.Base(href)}
+		for _, ps := range r.Propstat {
+			if ps.Prop.ResourceType.Collection != nil {
+				ent.dir = true
+			}
+			if n, err := strconv.ParseUint(ps.Prop.ContentLength, 10, 32); err == nil {
+				ent.size = uint32(n)
+			}
+		}
+		entries = append(entries, ent)
+	}
+	return entries, nil
+}
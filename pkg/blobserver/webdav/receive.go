@@ -0,0 +1,45 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webdav
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+
+	"camlistore.org/pkg/blob"
+)
+
+func (sto *webdavStorage) ReceiveBlob(br blob.Ref, source io.Reader) (blob.SizedRef, error) {
+	data, err := ioutil.ReadAll(source)
+	if err != nil {
+		return blob.SizedRef{}, err
+	}
+	p := blobPath(br)
+	err = sto.client.Put(p, data)
+	if we, ok := err.(*Error); ok && we.StatusCode == http.StatusConflict {
+		// A 409 means an intermediate collection is missing.
+		// Create the shard collections and retry.
+		sto.client.MkcolAll(path.Dir(p))
+		err = sto.client.Put(p, data)
+	}
+	if err != nil {
+		return blob.SizedRef{}, err
+	}
+	return blob.SizedRef{Ref: br, Size: uint32(len(data))}, nil
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webdav
+
+import (
+	"fmt"
+	"os"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/syncutil"
+)
+
+var statGate = syncutil.NewGate(20) // arbitrary
+
+func (sto *webdavStorage) StatBlobs(dest chan<- blob.SizedRef, blobs []blob.Ref) error {
+	var wg syncutil.Group
+	for i := range blobs {
+		br := blobs[i]
+		statGate.Start()
+		wg.Go(func() error {
+			defer statGate.Done()
+			size, err := sto.client.Stat(blobPath(br))
+			if err == os.ErrNotExist {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error statting %v: %v", br, err)
+			}
+			dest <- blob.SizedRef{Ref: br, Size: size}
+			return nil
+		})
+	}
+	return wg.Err()
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package webdav registers the "webdav" blobserver storage type,
+storing blobs in a collection of a WebDAV server (Nextcloud,
+ownCloud, Apache mod_dav, etc.).
+
+Example low-level config:
+
+     "/r1/": {
+         "handler": "storage-webdav",
+         "handlerArgs": {
+            "url": "https://cloud.example.com/remote.php/webdav/camliblobs",
+            "username": "camlistore",
+            "password": "secret"
+          }
+     },
+
+Blobs are stored under the configured collection as
+<hash>/<xx>/<blobref>.dat, with xx the first two digest digits; the
+sub-collections are created as needed. "username" and "password", if
+set, are sent as HTTP basic auth.
+*/
+package webdav
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/jsonconfig"
+)
+
+type webdavStorage struct {
+	client *webdavClient
+}
+
+func (s *webdavStorage) String() string {
+	return fmt.Sprintf("\"webdav\" blob storage at %q", s.client.base.String())
+}
+
+func newFromConfig(_ blobserver.Loader, config jsonconfig.Obj) (blobserver.Storage, error) {
+	rawURL := config.RequiredString("url")
+	username := config.OptionalString("username", "")
+	pass := config.OptionalString("password", "")
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("webdav: invalid url %q: %v", rawURL, err)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	sto := &webdavStorage{
+		client: &webdavClient{
+			base:     base,
+			username: username,
+			password: pass,
+		},
+	}
+	sto.client.MkcolAll("")
+	if _, err := sto.client.List(""); err != nil {
+		return nil, fmt.Errorf("webdav: could not create or list collection %q: %v", rawURL, err)
+	}
+	return sto, nil
+}
+
+// blobPath returns the path of the blob br relative to the base
+// collection: <hash>/<xx>/<blobref>.dat, with xx sharding on the
+// first two digest digits.
+func blobPath(br blob.Ref) string {
+	return br.HashName() + "/" + br.Digest()[:2] + "/" + br.String() + ".dat"
+}
+
+func init() {
+	blobserver.RegisterStorageConstructor("webdav", blobserver.StorageConstructor(newFromConfig))
+}
@@ -18,15 +18,18 @@ limitations under the License.
 package cacher
 
 import (
+	"container/list"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"camlistore.org/pkg/blob"
 	"camlistore.org/pkg/blobserver"
 	"camlistore.org/pkg/blobserver/localdisk"
+	"camlistore.org/pkg/context"
 	"camlistore.org/pkg/osutil"
 	"camlistore.org/pkg/singleflight"
 )
@@ -80,6 +83,20 @@ type DiskCache struct {
 	Root string
 
 	cleanAll bool // cleaning policy. TODO: something better.
+
+	disk *localdisk.DiskStorage // the cache storage, for eviction
+
+	lmu      sync.Mutex // guards the LRU fields below
+	maxBytes int64      // evict past this size; 0 means unbounded
+	lru      *list.List // of *lruEntry; front is most recently used
+	lruEnt   map[string]*list.Element
+	lruSize  int64 // sum of the sizes in lru
+}
+
+// An lruEntry is one cached blob in a size-bounded DiskCache.
+type lruEntry struct {
+	ref  string
+	size int64
 }
 
 // NewDiskCache returns a new DiskCache from a Fetcher, which
@@ -105,10 +122,83 @@ func NewDiskCache(fetcher blob.Fetcher) (*DiskCache, error) {
 	dc := &DiskCache{
 		CachingFetcher: NewCachingFetcher(diskcache, fetcher),
 		Root:           cacheDir,
+		disk:           diskcache,
 	}
 	return dc, nil
 }
 
+// SetMaxSize bounds the cache to maxBytes of blobs, evicting the least
+// recently used ones once it grows past that. It must be called before
+// the cache is used. Blobs cached by previous runs count against the
+// bound, seeded in enumeration order since their access order is no
+// longer known.
+func (dc *DiskCache) SetMaxSize(maxBytes int64) {
+	dc.lmu.Lock()
+	defer dc.lmu.Unlock()
+	dc.maxBytes = maxBytes
+	if dc.lru != nil {
+		dc.evictLocked()
+		return
+	}
+	dc.lru = list.New()
+	dc.lruEnt = make(map[string]*list.Element)
+	err := blobserver.EnumerateAll(context.TODO(), dc.disk, func(sb blob.SizedRef) error {
+		ent := &lruEntry{ref: sb.Ref.String(), size: int64(sb.Size)}
+		dc.lruEnt[ent.ref] = dc.lru.PushFront(ent)
+		dc.lruSize += ent.size
+		return nil
+	})
+	if err != nil {
+		log.Printf("cacher: enumerating existing cache in %s: %v", dc.Root, err)
+	}
+	dc.evictLocked()
+}
+
+// Fetch serves br from the local cache, fetching and caching it from
+// the backing fetcher on a miss.
+func (dc *DiskCache) Fetch(br blob.Ref) (file io.ReadCloser, size uint32, err error) {
+	file, size, err = dc.CachingFetcher.Fetch(br)
+	if err == nil {
+		dc.noteAccess(br, int64(size))
+	}
+	return
+}
+
+func (dc *DiskCache) noteAccess(br blob.Ref, size int64) {
+	dc.lmu.Lock()
+	defer dc.lmu.Unlock()
+	if dc.lru == nil {
+		return
+	}
+	if el, ok := dc.lruEnt[br.String()]; ok {
+		dc.lru.MoveToFront(el)
+	} else {
+		dc.lruEnt[br.String()] = dc.lru.PushFront(&lruEntry{ref: br.String(), size: size})
+		dc.lruSize += size
+	}
+	dc.evictLocked()
+}
+
+// evictLocked removes least recently used blobs until the cache is
+// within its size bound, never evicting the most recently used one.
+// dc.lmu must be held.
+func (dc *DiskCache) evictLocked() {
+	for dc.lruSize > dc.maxBytes && dc.lru.Len() > 1 {
+		el := dc.lru.Back()
+		ent := el.Value.(*lruEntry)
+		br, ok := blob.Parse(ent.ref)
+		if ok {
+			if err := dc.disk.RemoveBlobs([]blob.Ref{br}); err != nil {
+				log.Printf("cacher: evicting %v: %v", br, err)
+				return
+			}
+		}
+		dc.lru.Remove(el)
+		delete(dc.lruEnt, ent.ref)
+		dc.lruSize -= ent.size
+	}
+}
+
 // Clean cleans some or all of the DiskCache.
 func (dc *DiskCache) Clean() {
 	// TODO: something between nothing and deleting everything.
@@ -0,0 +1,343 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cacher
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/blobserver/localdisk"
+	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/sorted"
+	"camlistore.org/pkg/sorted/kvfile"
+)
+
+const (
+	// flushInterval is how often the background flusher retries
+	// uploading dirty blobs, absent new writes.
+	flushInterval = 10 * time.Second
+
+	// schemaSniffSize is how many leading bytes of a received blob
+	// are examined to classify it as a schema blob or a data chunk.
+	schemaSniffSize = 1024
+)
+
+// A WriteBack is a blob.Fetcher and blobserver.StatReceiver that
+// stages received blobs on local disk and uploads them to an origin
+// blobserver asynchronously, so writes complete at local disk speed
+// and survive a slow or unreachable server. The staging directory and
+// its dirty-blob journal are persistent: blobs not yet uploaded when
+// the process exits are flushed by the next run.
+type WriteBack struct {
+	origin blobserver.StatReceiver // the remote server (usually the pkg/client HTTP client)
+	fall   blob.Fetcher            // where reads go on a local miss
+	local  *localdisk.DiskStorage  // staged blobs
+	// queue journals the staged blobs not yet uploaded. Keys are
+	// "d:<sequence>" preserving write order; values are
+	// "<kind>|<blobref>" with kind "c" (data chunk) or "s" (schema
+	// blob).
+	// TODO: garbage-collect staged blobs once uploaded and old;
+	// for now they stay as an offline cache of recent writes.
+	queue sorted.KeyValue
+
+	flushc chan struct{} // wakes the flusher; buffered, len 1
+	donec  chan struct{} // closed by Close
+
+	flushMu sync.Mutex // serializes Flush calls
+
+	mu        sync.Mutex // guards the fields below
+	nextSeq   uint64
+	dirty     int
+	lastFlush time.Time
+	lastErr   error // from the last flush attempt, or nil
+}
+
+// WriteBackStats summarizes a WriteBack's state, for cammount's cache
+// control directory and camtool mount-cache.
+type WriteBackStats struct {
+	// Dirty is the number of blobs written locally but not yet
+	// uploaded to the server.
+	Dirty     int       `json:"dirty"`
+	LastFlush time.Time `json:"lastFlush"` // zero if none succeeded yet
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// NewWriteBack returns a WriteBack staging blobs under dir, uploading
+// them to origin, and falling back to fall (usually a DiskCache around
+// the same server) for reads it can't serve locally. Dirty blobs
+// journaled by previous runs are scheduled for upload immediately.
+func NewWriteBack(dir string, origin blobserver.StatReceiver, fall blob.Fetcher) (*WriteBack, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	local, err := localdisk.New(filepath.Join(dir, "staged"))
+	if err != nil {
+		return nil, err
+	}
+	queue, err := kvfile.NewStorage(filepath.Join(dir, "dirty-queue.kv"))
+	if err != nil {
+		return nil, err
+	}
+	wb := &WriteBack{
+		origin: origin,
+		fall:   fall,
+		local:  local,
+		queue:  queue,
+		flushc: make(chan struct{}, 1),
+		donec:  make(chan struct{}),
+	}
+	it := queue.Find("d:", "d;")
+	for it.Next() {
+		wb.dirty++
+		var seq uint64
+		if _, err := fmt.Sscanf(it.Key(), "d:%016x", &seq); err == nil && seq >= wb.nextSeq {
+			wb.nextSeq = seq + 1
+		}
+	}
+	if err := it.Close(); err != nil {
+		queue.Close()
+		return nil, err
+	}
+	go wb.flusher()
+	if wb.dirty > 0 {
+		log.Printf("cacher: %d dirty blobs left by a previous run; scheduling upload", wb.dirty)
+		wb.kick()
+	}
+	return wb, nil
+}
+
+// Fetch serves br from the local staging area if present, else from
+// the fallback fetcher.
+func (wb *WriteBack) Fetch(br blob.Ref) (file io.ReadCloser, size uint32, err error) {
+	file, size, err = wb.local.Fetch(br)
+	if err == nil {
+		return
+	}
+	return wb.fall.Fetch(br)
+}
+
+// ReceiveBlob stages the blob locally, journals it as dirty, and
+// schedules an upload. It does not wait for the server.
+func (wb *WriteBack) ReceiveBlob(br blob.Ref, src io.Reader) (blob.SizedRef, error) {
+	sniff := &sniffWriter{max: schemaSniffSize}
+	sb, err := blobserver.Receive(wb.local, br, io.TeeReader(src, sniff))
+	if err != nil {
+		return sb, err
+	}
+	kind := "c"
+	if schema.LikelySchemaBlob(sniff.buf) {
+		kind = "s"
+	}
+	wb.mu.Lock()
+	seq := wb.nextSeq
+	wb.nextSeq++
+	err = wb.queue.Set(fmt.Sprintf("d:%016x", seq), kind+"|"+br.String())
+	if err == nil {
+		wb.dirty++
+	}
+	wb.mu.Unlock()
+	if err != nil {
+		return sb, err
+	}
+	wb.kick()
+	return sb, nil
+}
+
+// StatBlobs checks the local staging area first and only asks the
+// origin about the rest. If the origin is unreachable its blobs are
+// reported missing, so callers re-stage data locally instead of
+// failing.
+func (wb *WriteBack) StatBlobs(dest chan<- blob.SizedRef, blobs []blob.Ref) error {
+	local := make(map[blob.Ref]bool)
+	ch := make(chan blob.SizedRef, 16)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- wb.local.StatBlobs(ch, blobs)
+		close(ch)
+	}()
+	for sb := range ch {
+		local[sb.Ref] = true
+		dest <- sb
+	}
+	if err := <-errc; err != nil {
+		return err
+	}
+	rest := blobs[:0:0]
+	for _, br := range blobs {
+		if !local[br] {
+			rest = append(rest, br)
+		}
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+	if err := wb.origin.StatBlobs(dest, rest); err != nil {
+		log.Printf("cacher: stat of %d blobs on origin failed, treating as missing: %v", len(rest), err)
+	}
+	return nil
+}
+
+// DirtyCount returns the number of blobs staged locally but not yet
+// uploaded to the server.
+func (wb *WriteBack) DirtyCount() int {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	return wb.dirty
+}
+
+// Stats returns a snapshot of the write-back state.
+func (wb *WriteBack) Stats() WriteBackStats {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	st := WriteBackStats{
+		Dirty:     wb.dirty,
+		LastFlush: wb.lastFlush,
+	}
+	if wb.lastErr != nil {
+		st.LastError = wb.lastErr.Error()
+	}
+	return st
+}
+
+// Flush uploads all dirty blobs to the origin, data chunks before
+// schema blobs, so a file's schema blob never reaches the server ahead
+// of the chunks it refers to.
+func (wb *WriteBack) Flush() error {
+	wb.flushMu.Lock()
+	defer wb.flushMu.Unlock()
+	err := wb.flushKind("c")
+	if err == nil {
+		err = wb.flushKind("s")
+	}
+	wb.mu.Lock()
+	wb.lastErr = err
+	if err == nil {
+		wb.lastFlush = time.Now()
+	}
+	wb.mu.Unlock()
+	return err
+}
+
+// flushKind uploads the dirty blobs of the given kind ("c" or "s") in
+// write order.
+func (wb *WriteBack) flushKind(kind string) error {
+	type entry struct {
+		key string
+		ref blob.Ref
+	}
+	var ents []entry
+	it := wb.queue.Find("d:", "d;")
+	for it.Next() {
+		v := it.Value()
+		i := strings.Index(v, "|")
+		if i < 0 || v[:i] != kind {
+			continue
+		}
+		br, ok := blob.Parse(v[i+1:])
+		if !ok {
+			continue
+		}
+		ents = append(ents, entry{key: it.Key(), ref: br})
+	}
+	if err := it.Close(); err != nil {
+		return err
+	}
+	for _, ent := range ents {
+		rc, _, err := wb.local.Fetch(ent.ref)
+		if err != nil {
+			// The staged copy is gone; nothing left to upload.
+			log.Printf("cacher: dirty blob %v missing from staging area: %v", ent.ref, err)
+		} else {
+			_, err = wb.origin.ReceiveBlob(ent.ref, rc)
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("uploading %v: %v", ent.ref, err)
+			}
+		}
+		wb.mu.Lock()
+		if err := wb.queue.Delete(ent.key); err != nil {
+			wb.mu.Unlock()
+			return err
+		}
+		wb.dirty--
+		wb.mu.Unlock()
+	}
+	return nil
+}
+
+// Close stops the background flusher, without a final flush, and
+// releases the journal. Dirty blobs stay journaled for the next run.
+func (wb *WriteBack) Close() error {
+	close(wb.donec)
+	return wb.queue.Close()
+}
+
+// kick wakes the background flusher without blocking.
+func (wb *WriteBack) kick() {
+	select {
+	case wb.flushc <- struct{}{}:
+	default:
+	}
+}
+
+func (wb *WriteBack) flusher() {
+	for {
+		select {
+		case <-wb.donec:
+			return
+		case <-wb.flushc:
+			// Let a burst of writes accumulate into one flush.
+			time.Sleep(500 * time.Millisecond)
+		case <-time.After(flushInterval):
+		}
+		if wb.DirtyCount() == 0 {
+			continue
+		}
+		if err := wb.Flush(); err != nil {
+			log.Printf("cacher: write-back flush: %v (%d blobs still dirty)", err, wb.DirtyCount())
+		}
+	}
+}
+
+// A sniffWriter keeps the first max bytes written to it.
+type sniffWriter struct {
+	buf []byte
+	max int
+}
+
+func (w *sniffWriter) Write(p []byte) (int, error) {
+	if n := w.max - len(w.buf); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+	}
+	return len(p), nil
+}
+
+var (
+	_ blob.Fetcher            = (*WriteBack)(nil)
+	_ blobserver.StatReceiver = (*WriteBack)(nil)
+)
@@ -0,0 +1,180 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package camliignore parses .camliignore files and matches paths
+// against their rules, with gitignore-like semantics: shell globs,
+// "**" matching any number of path elements, "!" negation, and
+// trailing-slash rules which only apply to directories. Rules
+// accumulate from ancestor directories, and the last matching rule
+// wins.
+package camliignore
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFileName is the name of the per-directory ignore file.
+const IgnoreFileName = ".camliignore"
+
+type rule struct {
+	dir      string   // directory of the ignore file the rule came from
+	pattern  string   // cleaned pattern, without '!' prefix or trailing slash
+	segs     []string // pattern split on '/'; only set if anchored
+	negate   bool     // rule un-ignores matching paths
+	dirOnly  bool     // rule only applies to directories
+	anchored bool     // pattern contained a slash: relative to dir
+}
+
+// A Matcher holds the accumulated ignore rules for some directory.
+// The zero value or nil matcher matches nothing. Matchers are
+// immutable; WithDir returns extended copies, so a tree walker can
+// keep one per directory level while sharing the ancestors' rules.
+type Matcher struct {
+	rules []*rule
+}
+
+// WithDir returns a Matcher with the rules of m (which may be nil)
+// plus the rules of the IgnoreFileName file in dir, if any.
+func (m *Matcher) WithDir(dir string) (*Matcher, error) {
+	f, err := os.Open(filepath.Join(dir, IgnoreFileName))
+	if os.IsNotExist(err) {
+		if m == nil {
+			return new(Matcher), nil
+		}
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return m.WithRules(dir, f)
+}
+
+// WithRules returns a Matcher with the rules of m (which may be nil)
+// plus the rules read from r, as if from an ignore file in dir.
+func (m *Matcher) WithRules(dir string, r io.Reader) (*Matcher, error) {
+	nm := new(Matcher)
+	if m != nil {
+		nm.rules = m.rules
+	}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if rl := parseRule(dir, sc.Text()); rl != nil {
+			// Copy-on-append so sibling matchers sharing the
+			// ancestor slice can't stomp on each other.
+			rules := make([]*rule, len(nm.rules), len(nm.rules)+1)
+			copy(rules, nm.rules)
+			nm.rules = append(rules, rl)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return nm, nil
+}
+
+// parseRule parses one ignore file line, returning nil for blank lines
+// and comments.
+func parseRule(dir, line string) *rule {
+	line = strings.TrimRight(line, " \t")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	rl := &rule{dir: dir}
+	if strings.HasPrefix(line, "!") {
+		rl.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rl.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rl.anchored = true
+		line = strings.TrimPrefix(line, "/")
+		rl.segs = strings.Split(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+	rl.pattern = line
+	return rl
+}
+
+// Ignored reports whether the file or directory at path (isDir says
+// which) is excluded by the accumulated rules. The last matching rule
+// wins, so a later "!" rule can re-include a path a previous rule
+// ignored.
+func (m *Matcher) Ignored(fullPath string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	ignored := false
+	for _, rl := range m.rules {
+		if rl.negate == ignored && rl.matches(fullPath, isDir) {
+			ignored = !rl.negate
+		}
+	}
+	return ignored
+}
+
+func (rl *rule) matches(fullPath string, isDir bool) bool {
+	if rl.dirOnly && !isDir {
+		return false
+	}
+	rel, err := filepath.Rel(rl.dir, fullPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || rel == ".." {
+		return false
+	}
+	segs := strings.Split(filepath.ToSlash(rel), "/")
+	if !rl.anchored {
+		// A pattern without a slash matches the base name at any
+		// depth below the rule's directory.
+		ok, _ := path.Match(rl.pattern, segs[len(segs)-1])
+		return ok
+	}
+	return matchSegs(rl.segs, segs)
+}
+
+// matchSegs reports whether the pattern elements pat match the path
+// elements segs. "**" matches any number of elements, including none;
+// other elements match with path.Match semantics.
+func matchSegs(pat, segs []string) bool {
+	if len(pat) == 0 {
+		return len(segs) == 0
+	}
+	if pat[0] == "**" {
+		if matchSegs(pat[1:], segs) {
+			return true
+		}
+		if len(segs) > 0 {
+			return matchSegs(pat, segs[1:])
+		}
+		return false
+	}
+	if len(segs) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pat[0], segs[0]); !ok {
+		return false
+	}
+	return matchSegs(pat[1:], segs[1:])
+}
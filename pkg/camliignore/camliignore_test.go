@@ -0,0 +1,125 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package camliignore
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func matcherFromRules(t *testing.T, dir, rules string) *Matcher {
+	m, err := (*Matcher)(nil).WithRules(dir, strings.NewReader(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMatcher(t *testing.T) {
+	root := filepath.FromSlash("/home/alice/photos")
+	tests := []struct {
+		rules string
+		path  string // slash-separated, relative to root
+		isDir bool
+		want  bool
+	}{
+		// Basename patterns match at any depth.
+		{rules: "*.tmp", path: "a.tmp", want: true},
+		{rules: "*.tmp", path: "sub/dir/a.tmp", want: true},
+		{rules: "*.tmp", path: "a.jpg", want: false},
+		{rules: "thumbs.db", path: "x/thumbs.db", want: true},
+
+		// Comments and blank lines.
+		{rules: "# a comment\n\n*.bak", path: "b.bak", want: true},
+		{rules: "# *.bak", path: "b.bak", want: false},
+
+		// Anchored (slash-containing) patterns are relative to
+		// the ignore file's directory.
+		{rules: "build/out", path: "build/out", want: true},
+		{rules: "build/out", path: "sub/build/out", want: false},
+		{rules: "/cache", path: "cache", want: true},
+		{rules: "/cache", path: "sub/cache", want: false},
+
+		// ** patterns.
+		{rules: "raw/**", path: "raw/2014/img.nef", want: true},
+		{rules: "raw/**", path: "jpg/2014/img.jpg", want: false},
+		{rules: "**/node_modules", path: "a/b/node_modules", want: true},
+		{rules: "a/**/z", path: "a/z", want: true},
+		{rules: "a/**/z", path: "a/b/c/z", want: true},
+		{rules: "a/**/z", path: "a/b/c/y", want: false},
+
+		// Directory-only rules.
+		{rules: "tmp/", path: "tmp", isDir: true, want: true},
+		{rules: "tmp/", path: "tmp", isDir: false, want: false},
+
+		// Negation: last matching rule wins.
+		{rules: "*.log\n!keep.log", path: "x/keep.log", want: false},
+		{rules: "*.log\n!keep.log", path: "x/other.log", want: true},
+		{rules: "!keep.log\n*.log", path: "x/keep.log", want: true},
+	}
+	for _, tt := range tests {
+		m := matcherFromRules(t, root, tt.rules)
+		fullPath := filepath.Join(root, filepath.FromSlash(tt.path))
+		if got := m.Ignored(fullPath, tt.isDir); got != tt.want {
+			t.Errorf("rules %q: Ignored(%q, isDir=%v) = %v; want %v",
+				tt.rules, tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestMatcherAncestorRules(t *testing.T) {
+	root := filepath.FromSlash("/src")
+	sub := filepath.Join(root, "sub")
+
+	m := matcherFromRules(t, root, "*.tmp\nsecret/")
+	m2, err := m.WithRules(sub, strings.NewReader("!important.tmp\nlocal-only"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Parent rules apply below.
+	if !m2.Ignored(filepath.Join(sub, "a.tmp"), false) {
+		t.Error("sub/a.tmp should inherit *.tmp from the parent")
+	}
+	// Child negation overrides the parent rule.
+	if m2.Ignored(filepath.Join(sub, "important.tmp"), false) {
+		t.Error("sub/important.tmp should be re-included by the sub rule")
+	}
+	// Child rules don't leak into the parent matcher.
+	if m.Ignored(filepath.Join(root, "local-only"), false) {
+		t.Error("root matcher should not have the sub rules")
+	}
+	// The parent's negation doesn't apply above the child dir.
+	if !m.Ignored(filepath.Join(root, "important.tmp"), false) {
+		t.Error("root/important.tmp should still be ignored by the root matcher")
+	}
+}
+
+func TestMatcherOutsideDir(t *testing.T) {
+	m := matcherFromRules(t, filepath.FromSlash("/src/sub"), "*.tmp")
+	if m.Ignored(filepath.FromSlash("/src/a.tmp"), false) {
+		t.Error("rules should not apply outside their directory")
+	}
+}
+
+func TestNilMatcher(t *testing.T) {
+	var m *Matcher
+	if m.Ignored("/anything", false) {
+		t.Error("nil matcher should match nothing")
+	}
+}
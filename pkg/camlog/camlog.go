@@ -0,0 +1,183 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package camlog provides a thin leveled wrapper around the standard
+// log package, with one logger per named subsystem ("sync",
+// "importer", ...) whose verbosity can be changed at runtime.
+//
+// Output goes through the standard logger, so the format (and any
+// flags or output destination set on it) stays the same as plain
+// log.Printf calls, apart from the subsystem tag.
+package camlog
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Level is a logging verbosity level.
+type Level int32
+
+const (
+	// Info is the default level: messages worth seeing in normal
+	// operation.
+	Info Level = iota
+	// Debug additionally logs messages only useful when debugging
+	// a subsystem.
+	Debug
+)
+
+func (l Level) String() string {
+	switch l {
+	case Info:
+		return "info"
+	case Debug:
+		return "debug"
+	}
+	return fmt.Sprintf("level(%d)", int32(l))
+}
+
+// ParseLevel parses a level name, either "info" or "debug".
+func ParseLevel(s string) (Level, error) {
+	switch s {
+	case "info":
+		return Info, nil
+	case "debug":
+		return Debug, nil
+	}
+	return 0, fmt.Errorf(`invalid logging level %q; valid levels are "info" and "debug"`, s)
+}
+
+// A Logger logs messages for one subsystem. Its level may be changed
+// at any time, from any goroutine, and takes effect immediately.
+type Logger struct {
+	sub   string
+	level int32 // atomic; a Level
+}
+
+var (
+	mu      sync.Mutex
+	loggers = map[string]*Logger{}
+)
+
+// New returns the logger for the named subsystem, creating it at the
+// Info level if needed. Loggers are process-wide: New twice with the
+// same name returns the same logger, so a level set from the config
+// or the /debug/logging handler applies no matter which came first.
+func New(subsystem string) *Logger {
+	mu.Lock()
+	defer mu.Unlock()
+	l, ok := loggers[subsystem]
+	if !ok {
+		l = &Logger{sub: subsystem}
+		loggers[subsystem] = l
+	}
+	return l
+}
+
+// Level returns the logger's current level.
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(&l.level))
+}
+
+// SetLevel sets the logger's level.
+func (l *Logger) SetLevel(v Level) {
+	atomic.StoreInt32(&l.level, int32(v))
+}
+
+// Debugging reports whether the logger is at Debug level. Hot paths
+// should guard their Debugf calls with it, so arguments aren't even
+// boxed when debug logging is off.
+func (l *Logger) Debugging() bool {
+	return l.Level() >= Debug
+}
+
+// Printf logs to the standard logger with the subsystem tag as a
+// prefix, regardless of level.
+func (l *Logger) Printf(format string, args ...interface{}) {
+	log.Printf(l.sub+": "+format, args...)
+}
+
+// Debugf is like Printf, but only logs when the logger is at Debug
+// level. Otherwise it returns without formatting anything.
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	if !l.Debugging() {
+		return
+	}
+	log.Printf(l.sub+": "+format, args...)
+}
+
+// SetLevel sets the named subsystem's level, creating its logger if
+// the subsystem hasn't called New yet.
+func SetLevel(subsystem string, v Level) {
+	New(subsystem).SetLevel(v)
+}
+
+// Levels returns a snapshot of each registered subsystem's level.
+func Levels() map[string]Level {
+	mu.Lock()
+	defer mu.Unlock()
+	m := make(map[string]Level, len(loggers))
+	for sub, l := range loggers {
+		m[sub] = l.Level()
+	}
+	return m
+}
+
+// Handler returns the HTTP handler served at /debug/logging: a GET
+// lists each subsystem and its level, one "subsystem: level" pair per
+// line, and a POST with "subsystem" and "level" form values changes
+// one at runtime.
+func Handler() http.Handler {
+	return handler{}
+}
+
+type handler struct{}
+
+func (handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		levels := Levels()
+		subs := make([]string, 0, len(levels))
+		for sub := range levels {
+			subs = append(subs, sub)
+		}
+		sort.Strings(subs)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, sub := range subs {
+			fmt.Fprintf(w, "%s: %s\n", sub, levels[sub])
+		}
+	case "POST":
+		sub := r.FormValue("subsystem")
+		if sub == "" {
+			http.Error(w, "missing 'subsystem' parameter", http.StatusBadRequest)
+			return
+		}
+		level, err := ParseLevel(r.FormValue("level"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		SetLevel(sub, level)
+		fmt.Fprintf(w, "%s: %s\n", sub, level)
+	default:
+		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package camlog
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func capture(t *testing.T, f func()) string {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	f()
+	return buf.String()
+}
+
+func TestLevelChangeAtRuntime(t *testing.T) {
+	l := New("testsub")
+	defer l.SetLevel(Info)
+
+	got := capture(t, func() { l.Debugf("quiet %d", 1) })
+	if got != "" {
+		t.Errorf("Debugf at info level logged %q; want nothing", got)
+	}
+
+	// Change the level as the /debug/logging handler would, without
+	// touching the *Logger already in hand.
+	SetLevel("testsub", Debug)
+	got = capture(t, func() { l.Debugf("loud %d", 2) })
+	if !strings.Contains(got, "testsub: loud 2") {
+		t.Errorf("Debugf at debug level logged %q; want it to contain %q", got, "testsub: loud 2")
+	}
+
+	SetLevel("testsub", Info)
+	got = capture(t, func() { l.Debugf("quiet again") })
+	if got != "" {
+		t.Errorf("Debugf after lowering level logged %q; want nothing", got)
+	}
+}
+
+func TestPrintfAlwaysLogs(t *testing.T) {
+	l := New("testsub")
+	got := capture(t, func() { l.Printf("hello %s", "world") })
+	if !strings.Contains(got, "testsub: hello world") {
+		t.Errorf("Printf logged %q; want it to contain %q", got, "testsub: hello world")
+	}
+}
+
+func TestDisabledDebugDoesNotAllocate(t *testing.T) {
+	l := New("testsub")
+	ref := "sha1-0000000000000000000000000000000000000000"
+	n := testing.AllocsPerRun(1000, func() {
+		if l.Debugging() {
+			l.Debugf("considering blob %v (%d bytes)", ref, 123)
+		}
+	})
+	if n != 0 {
+		t.Errorf("guarded Debugf with level disabled allocated %v times per run; want 0", n)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	New("handlersub")
+	defer SetLevel("handlersub", Info)
+	h := Handler()
+
+	form := url.Values{"subsystem": {"handlersub"}, "level": {"debug"}}
+	req, err := http.NewRequest("POST", "/debug/logging", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("POST status = %d; want 200. Body: %s", rec.Code, rec.Body)
+	}
+	if got := New("handlersub").Level(); got != Debug {
+		t.Errorf("level after POST = %v; want debug", got)
+	}
+
+	req, err = http.NewRequest("GET", "/debug/logging", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("GET status = %d; want 200", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "handlersub: debug\n") {
+		t.Errorf("GET body = %q; want it to list %q", rec.Body, "handlersub: debug")
+	}
+
+	form = url.Values{"subsystem": {"handlersub"}, "level": {"chatty"}}
+	req, err = http.NewRequest("POST", "/debug/logging", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("POST with bad level status = %d; want %d", rec.Code, http.StatusBadRequest)
+	}
+}
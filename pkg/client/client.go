@@ -39,6 +39,7 @@ import (
 	"camlistore.org/pkg/blob"
 	"camlistore.org/pkg/blobserver"
 	"camlistore.org/pkg/client/android"
+	"camlistore.org/pkg/context"
 	"camlistore.org/pkg/httputil"
 	"camlistore.org/pkg/misc"
 	"camlistore.org/pkg/osutil"
@@ -68,6 +69,7 @@ type Client struct {
 	storageGen     string      // storage generation, or "" if not reported
 	syncHandlers   []*SyncInfo // "from" and "to" url prefix for each syncHandler
 	serverKeyID    string      // Server's GPG public key ID.
+	resumableUp    bool        // whether the server supports resumable uploads.
 
 	signerOnce sync.Once
 	signer     *schema.Signer
@@ -110,6 +112,7 @@ type Client struct {
 	pendStat   map[blob.Ref][]statReq // blobref -> reqs; for next batch(es)
 
 	initSignerPublicKeyBlobrefOnce sync.Once
+	signerKeyID                    string // explicit GPG key ID to sign with; "" means env/config
 	signerPublicKeyRef             blob.Ref
 	publicKeyArmored               string
 
@@ -130,9 +133,12 @@ type Client struct {
 const maxParallelHTTP = 5
 
 // New returns a new Camlistore Client.
-// The provided server is either "host:port" (assumed http, not https) or a URL prefix, with or without a path, or a server alias from the client configuration file. A server alias should not be confused with a hostname, therefore it cannot contain any colon or period.
+// The provided server is either "host:port" (assumed http, not https) or a URL prefix, with or without a path, or a server alias from the client configuration file, or "auto" to discover a server advertised on the local network with mDNS. A server alias should not be confused with a hostname, therefore it cannot contain any colon or period.
 // Errors are not returned until subsequent operations.
 func New(server string) *Client {
+	if server == "auto" {
+		server = discoverServer()
+	}
 	if !isURLOrHostPort(server) {
 		configOnce.Do(parseConfig)
 		serverConf, ok := config.Servers[server]
@@ -505,6 +511,44 @@ func (c *Client) GetClaims(req *search.ClaimsRequest) (*search.ClaimsResponse, e
 	return res, nil
 }
 
+// StorageStats queries the server's search handler for its blob
+// reference-count statistics.
+func (c *Client) StorageStats() (*search.StorageStatsResponse, error) {
+	sr, err := c.SearchRoot()
+	if err != nil {
+		return nil, err
+	}
+	url := sr + "camli/search/storagestats"
+	hreq := c.newRequest("GET", url)
+	hres, err := c.expect2XX(hreq)
+	if err != nil {
+		return nil, err
+	}
+	res := new(search.StorageStatsResponse)
+	if err := httputil.DecodeJSON(hres, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Client) EdgesTo(req *search.EdgesRequest) (*search.EdgesResponse, error) {
+	sr, err := c.SearchRoot()
+	if err != nil {
+		return nil, err
+	}
+	url := sr + req.URLSuffix()
+	hreq := c.newRequest("GET", url)
+	hres, err := c.expect2XX(hreq)
+	if err != nil {
+		return nil, err
+	}
+	res := new(search.EdgesResponse)
+	if err := httputil.DecodeJSON(hres, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 func (c *Client) Query(req *search.SearchQuery) (*search.SearchResult, error) {
 	sr, err := c.SearchRoot()
 	if err != nil {
@@ -527,6 +571,42 @@ func (c *Client) Query(req *search.SearchQuery) (*search.SearchResult, error) {
 	return res, nil
 }
 
+// StreamReachable requests the search handler's reachable endpoint
+// and streams the closure of blobs reachable from roots to dest,
+// closing dest when done. Claims on the reached permanodes signed by
+// signers other than the server's owners aren't included.
+func (c *Client) StreamReachable(ctx *context.Context, roots []blob.Ref, dest chan<- blob.SizedRef) error {
+	defer close(dest)
+	sr, err := c.SearchRoot()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(&search.ReachableRequest{Roots: roots})
+	if err != nil {
+		return err
+	}
+	hreq := c.newRequest("POST", sr+"camli/search/reachable", bytes.NewReader(body))
+	hres, err := c.expect2XX(hreq)
+	if err != nil {
+		return err
+	}
+	defer hres.Body.Close()
+	dec := json.NewDecoder(hres.Body)
+	for {
+		var item search.ReachableItem
+		if err := dec.Decode(&item); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		select {
+		case dest <- blob.SizedRef{Ref: item.BlobRef, Size: item.Size}:
+		case <-ctx.Done():
+			return context.ErrCanceled
+		}
+	}
+}
+
 // SearchExistingFileSchema does a search query looking for an
 // existing file with entire contents of wholeRef, then does a HEAD
 // request to verify the file still exists on the server.  If so,
@@ -730,6 +810,7 @@ func (c *Client) doDiscovery() error {
 	}
 
 	c.storageGen, _ = m["storageGeneration"].(string)
+	c.resumableUp, _ = m["resumableUpload"].(bool)
 
 	blobRoot, ok := m["blobRoot"].(string)
 	if !ok {
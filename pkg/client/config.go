@@ -23,6 +23,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -49,7 +50,7 @@ func AddFlags() {
 	if !buildinfo.TestingLinked() {
 		defaultPath = osutil.UserClientConfigPath()
 	}
-	flag.StringVar(&flagServer, "server", "", "Camlistore server prefix. If blank, the default from the \"server\" field of "+defaultPath+" is used. Acceptable forms: https://you.example.com, example.com:1345 (https assumed), or http://you.example.com/alt-root")
+	flag.StringVar(&flagServer, "server", "", "Camlistore server prefix. If blank, the default from the \"server\" field of "+defaultPath+" is used. Acceptable forms: https://you.example.com, example.com:1345 (https assumed), http://you.example.com/alt-root, or \"auto\" to discover a server on the local network with mDNS.")
 	osutil.AddSecretRingFlag()
 }
 
@@ -262,7 +263,8 @@ func serverOrDie() string {
 			if ok {
 				return serverConf.Server
 			}
-			log.Printf("%q looks like a server alias, but no such alias found in config.", flagServer)
+			log.Fatalf("%q looks like a server alias, but no such alias found in config at %v. Known aliases: %v",
+				flagServer, osutil.UserClientConfigPath(), strings.Join(knownServerAliases(), ", "))
 		} else {
 			return cleanServer(flagServer)
 		}
@@ -274,6 +276,17 @@ func serverOrDie() string {
 	return cleanServer(server)
 }
 
+// knownServerAliases returns the sorted server aliases from the config.
+// The config must have been parsed before calling it.
+func knownServerAliases() []string {
+	var aliases []string
+	for alias := range config.Servers {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
 func defaultServer() string {
 	configOnce.Do(parseConfig)
 	wantAlias := os.Getenv("CAMLI_DEFAULT_SERVER")
@@ -393,12 +406,23 @@ func (c *Client) SignerPublicKeyBlobref() blob.Ref {
 	return c.signerPublicKeyRef
 }
 
+// SetSignerKeyID sets the GPG key ID (short or long form) to sign
+// with, overriding the CAMLI_KEYID environment variable and the
+// config file's "identity". The key must exist in the client's secret
+// ring. It must be called before the signer is first used.
+func (c *Client) SetSignerKeyID(keyID string) {
+	c.signerKeyID = keyID
+}
+
 func (c *Client) initSignerPublicKeyBlobref() {
 	if c.paramsOnly {
 		log.Print("client: paramsOnly set; cannot get public key from config or env vars.")
 		return
 	}
-	keyId := os.Getenv("CAMLI_KEYID")
+	keyId := c.signerKeyID
+	if keyId == "" {
+		keyId = os.Getenv("CAMLI_KEYID")
+	}
 	if keyId == "" {
 		configOnce.Do(parseConfig)
 		keyId = config.Identity
@@ -0,0 +1,76 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"camlistore.org/pkg/mdns"
+)
+
+// discoverServer returns the base URL of the one Camlistore server
+// advertised on the local network, for --server=auto. It dies if no
+// server (or more than one) is found, or if an HTTPS server's
+// advertised certificate fingerprint is not in the client config's
+// trustedCerts.
+func discoverServer() string {
+	services, err := mdns.Browse(2 * time.Second)
+	if err != nil {
+		log.Fatalf("Error browsing the local network for servers: %v", err)
+	}
+	var candidates []*mdns.Service
+	for _, svc := range services {
+		if svc.BaseURL() != "" {
+			candidates = append(candidates, svc)
+		}
+	}
+	if len(candidates) == 0 {
+		log.Fatalf("--server=auto: no Camlistore server advertised on the local network")
+	}
+	if len(candidates) > 1 {
+		var names []string
+		for _, svc := range candidates {
+			names = append(names, svc.Instance+" ("+svc.BaseURL()+")")
+		}
+		log.Fatalf("--server=auto: several servers advertised on the local network: %v; pick one with --server", strings.Join(names, ", "))
+	}
+	svc := candidates[0]
+	if fp := svc.Fingerprint(); fp != "" {
+		if configDisabled {
+			log.Printf("Warning: can not verify fingerprint %v of discovered server %v: client config file disabled", fp, svc.BaseURL())
+		} else if !fingerprintTrusted(fp) {
+			log.Fatalf("Discovered server %v has certificate fingerprint %v, which is not in any trustedCerts list of %v. See 'camtool discover --add' to add it as a profile.", svc.BaseURL(), fp, svc.Instance)
+		}
+	}
+	return svc.BaseURL()
+}
+
+// fingerprintTrusted reports whether fp is in the trustedCerts list
+// of any server of the client config.
+func fingerprintTrusted(fp string) bool {
+	configOnce.Do(parseConfig)
+	for _, server := range config.Servers {
+		for _, v := range server.TrustedCerts {
+			if strings.ToLower(v) == fp {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
 	"camlistore.org/pkg/context"
 )
 
@@ -33,6 +34,23 @@ type EnumerateOpts struct {
 	After   string        // last blobref seen; start with ones greater than this
 	MaxWait time.Duration // how long to poll for (second granularity), waiting for any blob, or 0 for no limit
 	Limit   int           // if non-zero, the max blobs to return
+
+	// RefPrefix, MinSize and MaxSize restrict the enumeration to
+	// matching blobs; see blobserver.EnumerateFilter. They are
+	// sent to the server, which filters natively when it can, and
+	// are additionally applied client-side for servers predating
+	// the filter parameters.
+	RefPrefix string
+	MinSize   uint32
+	MaxSize   uint32
+}
+
+func (o *EnumerateOpts) filter() blobserver.EnumerateFilter {
+	return blobserver.EnumerateFilter{
+		RefPrefix: o.RefPrefix,
+		MinSize:   o.MinSize,
+		MaxSize:   o.MaxSize,
+	}
 }
 
 // SimpleEnumerateBlobs sends all blobs to the provided channel.
@@ -76,6 +94,20 @@ func (c *Client) EnumerateBlobsOpts(ctx *context.Context, ch chan<- blob.SizedRe
 		return err
 	}
 
+	filter := opts.filter()
+	filterParams := ""
+	if !filter.IsZero() {
+		if opts.RefPrefix != "" {
+			filterParams += "&refprefix=" + url.QueryEscape(opts.RefPrefix)
+		}
+		if opts.MinSize > 0 {
+			filterParams += fmt.Sprintf("&minsize=%d", opts.MinSize)
+		}
+		if opts.MaxSize > 0 {
+			filterParams += fmt.Sprintf("&maxsize=%d", opts.MaxSize)
+		}
+	}
+
 	nSent := 0
 	keepGoing := true
 	after := opts.After
@@ -89,8 +121,8 @@ func (c *Client) EnumerateBlobsOpts(ctx *context.Context, ch chan<- blob.SizedRe
 				}
 			}
 		}
-		url_ := fmt.Sprintf("%s/camli/enumerate-blobs?after=%s&limit=%d&maxwaitsec=%d",
-			pfx, url.QueryEscape(after), enumerateBatchSize, waitSec)
+		url_ := fmt.Sprintf("%s/camli/enumerate-blobs?after=%s&limit=%d&maxwaitsec=%d%s",
+			pfx, url.QueryEscape(after), enumerateBatchSize, waitSec, filterParams)
 		req := c.newRequest("GET", url_)
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
@@ -123,8 +155,13 @@ func (c *Client) EnumerateBlobsOpts(ctx *context.Context, ch chan<- blob.SizedRe
 			if !ok {
 				return error("item in 'blobs' had invalid blobref.", nil)
 			}
+			sb := blob.SizedRef{Ref: br, Size: uint32(size)}
+			if !filter.Matches(sb) {
+				// Server predating the filter parameters.
+				continue
+			}
 			select {
-			case ch <- blob.SizedRef{Ref: br, Size: uint32(size)}:
+			case ch <- sb:
 			case <-ctx.Done():
 				return context.ErrCanceled
 			}
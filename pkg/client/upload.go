@@ -334,6 +334,143 @@ func (h *UploadHandle) readerAndSize() (io.Reader, int64, error) {
 	return &b, n, nil
 }
 
+// resumableThreshold is the blob size in bytes at or above which
+// Upload switches to the resumable upload protocol, when the server
+// advertises support for it and the blob contents are seekable.
+const resumableThreshold = 4 << 20
+
+// supportsResumableUpload reports whether the server advertised
+// support for the resumable upload protocol extension in discovery.
+func (c *Client) supportsResumableUpload() bool {
+	if c.sto != nil {
+		return false
+	}
+	if err := c.condDiscovery(); err != nil {
+		return false
+	}
+	return c.resumableUp
+}
+
+// resumableOffset asks the server how many bytes of the upload
+// session at sessURL it has durably spooled.
+func (c *Client) resumableOffset(sessURL string) (int64, error) {
+	resp, err := c.doReqGated(c.newRequest("GET", sessURL))
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != 200 {
+		httputil.CloseBody(resp.Body)
+		return 0, fmt.Errorf("offset query on upload session had http status %d", resp.StatusCode)
+	}
+	var rr protocol.ResumableUploadResponse
+	if err := httputil.DecodeJSON(resp, &rr); err != nil {
+		return 0, err
+	}
+	return rr.Offset, nil
+}
+
+// uploadResumable uploads the blob described by pr using the
+// resumable upload protocol: it opens (or, after an earlier
+// interrupted run, resumes) an upload session for the blob, sends the
+// remaining bytes from the server's durable offset, retrying after
+// dropped connections, and finalizes the session once all bytes are
+// on the server. See doc/protocol/blob-upload-resume.txt.
+func (c *Client) uploadResumable(pr *PutResult, rs io.ReadSeeker, bodySize int64, pfx string) (*PutResult, error) {
+	errorf := func(msg string, arg ...interface{}) (*PutResult, error) {
+		err := fmt.Errorf(msg, arg...)
+		c.log.Print(err.Error())
+		return nil, err
+	}
+	blobrefStr := pr.BlobRef.String()
+
+	form := fmt.Sprintf("camliversion=1&blob=%s&size=%d", blobrefStr, bodySize)
+	req := c.newRequest("POST", fmt.Sprintf("%s/camli/resumable-upload", pfx), strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.doReqGated(req)
+	if err != nil {
+		return errorf("resumable upload session http error: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		httputil.CloseBody(resp.Body)
+		return errorf("resumable upload session had http status %d", resp.StatusCode)
+	}
+	var rr protocol.ResumableUploadResponse
+	if err := httputil.DecodeJSON(resp, &rr); err != nil {
+		return nil, err
+	}
+	if rr.Session == "" {
+		return errorf("resumable upload response missing session")
+	}
+	sessURL := fmt.Sprintf("%s/camli/resumable-upload/%s", pfx, rr.Session)
+	offset := rr.Offset
+
+	const maxStalls = 3 // max consecutive attempts without progress
+	stalls := 0
+	for offset < bodySize {
+		if _, err := rs.Seek(offset, os.SEEK_SET); err != nil {
+			return errorf("resumable upload seek to %d: %v", offset, err)
+		}
+		req := c.newRequest("PUT", sessURL, io.LimitReader(rs, bodySize-offset))
+		req.ContentLength = bodySize - offset
+		req.Header.Set("X-Camli-Upload-Offset", fmt.Sprint(offset))
+		newOffset := offset
+		resp, err := c.doReqGated(req)
+		switch {
+		case err != nil:
+			// Connection dropped mid-upload; ask the server
+			// how much it kept and continue from there.
+			c.log.Printf("resumable upload of %v interrupted at byte %d: %v", blobrefStr, offset, err)
+			newOffset, err = c.resumableOffset(sessURL)
+			if err != nil {
+				return errorf("resumable upload offset query: %v", err)
+			}
+		case resp.StatusCode == 200 || resp.StatusCode == 409:
+			var rr protocol.ResumableUploadResponse
+			if err := httputil.DecodeJSON(resp, &rr); err != nil {
+				return nil, err
+			}
+			newOffset = rr.Offset
+		default:
+			httputil.CloseBody(resp.Body)
+			return errorf("resumable upload had http status %d", resp.StatusCode)
+		}
+		if newOffset <= offset {
+			stalls++
+			if stalls == maxStalls {
+				return errorf("resumable upload of %v stuck at byte %d of %d", blobrefStr, newOffset, bodySize)
+			}
+		} else {
+			stalls = 0
+		}
+		offset = newOffset
+	}
+
+	req = c.newRequest("POST", sessURL, strings.NewReader("finalize=1"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err = c.doReqGated(req)
+	if err != nil {
+		return errorf("resumable upload finalize http error: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		httputil.CloseBody(resp.Body)
+		return errorf("resumable upload finalize had http status %d", resp.StatusCode)
+	}
+	rr = protocol.ResumableUploadResponse{}
+	if err := httputil.DecodeJSON(resp, &rr); err != nil {
+		return nil, err
+	}
+	if rr.Received == nil || rr.Received.Ref != pr.BlobRef || int64(rr.Received.Size) != bodySize {
+		return errorf("resumable upload finalize didn't report blob received")
+	}
+
+	c.statsMutex.Lock()
+	c.stats.Uploads.Blobs++
+	c.stats.Uploads.Bytes += bodySize
+	c.statsMutex.Unlock()
+	c.haveCache.NoteBlobExists(pr.BlobRef, uint32(bodySize))
+	return pr, nil
+}
+
 // Upload uploads a blob, as described by the provided UploadHandle parameters.
 func (c *Client) Upload(h *UploadHandle) (*PutResult, error) {
 	errorf := func(msg string, arg ...interface{}) (*PutResult, error) {
@@ -426,6 +563,11 @@ func (c *Client) Upload(h *UploadHandle) (*PutResult, error) {
 		log.Printf("Uploading: %s (%d bytes)", blobrefStr, bodySize)
 	}
 
+	if rs, ok := bodyReader.(io.ReadSeeker); ok && !h.Vivify &&
+		bodySize >= resumableThreshold && c.supportsResumableUpload() {
+		return c.uploadResumable(pr, rs, bodySize, pfx)
+	}
+
 	pipeReader, pipeWriter := io.Pipe()
 	multipartWriter := multipart.NewWriter(pipeWriter)
 
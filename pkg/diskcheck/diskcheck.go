@@ -0,0 +1,173 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package diskcheck monitors free disk space on the filesystems
+// backing the server's stateful directories (blobs, index, cache),
+// warning when space runs low and refusing new blob uploads before a
+// full disk can corrupt the index.
+package diskcheck
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Default thresholds, in percent of free disk space.
+const (
+	DefaultWarnPercent   = 5
+	DefaultRefusePercent = 1
+)
+
+// checkInterval is how often the monitor re-checks the watched
+// filesystems to log threshold transitions.
+const checkInterval = 1 * time.Minute
+
+// Status describes the disk usage of the filesystem holding one
+// monitored directory.
+type Status struct {
+	Dir         string  `json:"dir"`
+	TotalBytes  uint64  `json:"totalBytes"`
+	FreeBytes   uint64  `json:"freeBytes"`
+	FreePercent float64 `json:"freePercent"`
+	Warn        bool    `json:"warn"`   // below the warning threshold
+	Refuse      bool    `json:"refuse"` // below the refusal threshold; new uploads are refused
+}
+
+// statFS returns the total and free bytes of the filesystem holding
+// dir. It is nil on platforms without support, and a variable for
+// testing.
+var statFS func(dir string) (total, free uint64, err error)
+
+var (
+	mu        sync.Mutex
+	dirs      []string
+	warnPct   = float64(DefaultWarnPercent)
+	refusePct = float64(DefaultRefusePercent)
+	lastLevel = map[string]int{} // dir -> 0 (ok), 1 (warn), or 2 (refuse), as last logged
+	started   bool
+)
+
+// Monitor starts monitoring the filesystems holding watchDirs,
+// replacing any previously monitored set. The thresholds are
+// percentages of free space; values <= 0 mean the defaults (warn
+// below 5%, refuse below 1%). On platforms without filesystem
+// statistics support, Monitor logs and does nothing.
+func Monitor(watchDirs []string, warnPercent, refusePercent int) {
+	mu.Lock()
+	defer mu.Unlock()
+	if statFS == nil {
+		log.Printf("diskcheck: disk space monitoring not supported on this platform")
+		return
+	}
+	dirs = dirs[:0]
+	seen := map[string]bool{}
+	for _, dir := range watchDirs {
+		if dir == "" || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		dirs = append(dirs, dir)
+	}
+	warnPct = DefaultWarnPercent
+	if warnPercent > 0 {
+		warnPct = float64(warnPercent)
+	}
+	refusePct = DefaultRefusePercent
+	if refusePercent > 0 {
+		refusePct = float64(refusePercent)
+	}
+	if !started {
+		started = true
+		go monitorLoop()
+	}
+}
+
+func monitorLoop() {
+	for {
+		logTransitions()
+		time.Sleep(checkInterval)
+	}
+}
+
+func logTransitions() {
+	for _, st := range Statuses() {
+		level := 0
+		switch {
+		case st.Refuse:
+			level = 2
+		case st.Warn:
+			level = 1
+		}
+		mu.Lock()
+		was := lastLevel[st.Dir]
+		lastLevel[st.Dir] = level
+		mu.Unlock()
+		if level == was {
+			continue
+		}
+		switch level {
+		case 2:
+			log.Printf("diskcheck: filesystem holding %s is almost full (%.1f%% free); refusing new blob uploads", st.Dir, st.FreePercent)
+		case 1:
+			log.Printf("diskcheck: filesystem holding %s is low on space (%.1f%% free)", st.Dir, st.FreePercent)
+		default:
+			log.Printf("diskcheck: filesystem holding %s has enough space again (%.1f%% free)", st.Dir, st.FreePercent)
+		}
+	}
+}
+
+// Statuses returns the current disk usage of each monitored
+// directory, or nil if nothing is monitored.
+func Statuses() []Status {
+	mu.Lock()
+	watch := append([]string(nil), dirs...)
+	wp, rp := warnPct, refusePct
+	mu.Unlock()
+	var sts []Status
+	for _, dir := range watch {
+		total, free, err := statFS(dir)
+		if err != nil || total == 0 {
+			// The directory may not exist yet; don't refuse
+			// writes for it.
+			continue
+		}
+		pct := 100 * float64(free) / float64(total)
+		sts = append(sts, Status{
+			Dir:         dir,
+			TotalBytes:  total,
+			FreeBytes:   free,
+			FreePercent: pct,
+			Warn:        pct < wp,
+			Refuse:      pct < rp,
+		})
+	}
+	return sts
+}
+
+// CanWrite reports whether new blobs may be written, returning nil if
+// so, or an error describing the nearly-full filesystem. Callers on
+// internal paths (e.g. sync to index) should not consult CanWrite, so
+// data already accepted stays consistent.
+func CanWrite() error {
+	for _, st := range Statuses() {
+		if st.Refuse {
+			return fmt.Errorf("disk holding %s is almost full (%.1f%% free)", st.Dir, st.FreePercent)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diskcheck
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestThresholds(t *testing.T) {
+	defer func(f func(string) (uint64, uint64, error)) { statFS = f }(statFS)
+	fakeFree := map[string]uint64{
+		"/blobs": 500, // 50% free
+		"/index": 40,  // 4% free: warn
+		"/cache": 5,   // 0.5% free: refuse
+	}
+	statFS = func(dir string) (total, free uint64, err error) {
+		return 1000, fakeFree[dir], nil
+	}
+
+	Monitor([]string{"/blobs", "/index", "/cache", "/index", ""}, 0, 0)
+	sts := Statuses()
+	if len(sts) != 3 {
+		t.Fatalf("got %d statuses; want 3", len(sts))
+	}
+	for _, st := range sts {
+		var wantWarn, wantRefuse bool
+		switch st.Dir {
+		case "/index":
+			wantWarn = true
+		case "/cache":
+			wantWarn, wantRefuse = true, true
+		}
+		if st.Warn != wantWarn || st.Refuse != wantRefuse {
+			t.Errorf("dir %s: warn = %v, refuse = %v; want %v, %v", st.Dir, st.Warn, st.Refuse, wantWarn, wantRefuse)
+		}
+	}
+	err := CanWrite()
+	if err == nil || !strings.Contains(err.Error(), "/cache") {
+		t.Errorf("CanWrite = %v; want error naming /cache", err)
+	}
+
+	// Raising the refusal threshold above /index's free space should
+	// refuse for it too; clearing the cache dir makes writes possible
+	// again once only the blob dir is watched.
+	Monitor([]string{"/index"}, 10, 5)
+	if err := CanWrite(); err == nil {
+		t.Error("CanWrite with refuse threshold 5% and 4% free = nil; want error")
+	}
+	Monitor([]string{"/blobs"}, 0, 0)
+	if err := CanWrite(); err != nil {
+		t.Errorf("CanWrite on half-empty disk = %v; want nil", err)
+	}
+}
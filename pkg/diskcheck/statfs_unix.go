@@ -0,0 +1,34 @@
+// +build linux,!appengine darwin freebsd
+
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diskcheck
+
+import "syscall"
+
+func init() {
+	statFS = statFSUnix
+}
+
+func statFSUnix(dir string) (total, free uint64, err error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err != nil {
+		return 0, 0, err
+	}
+	bsize := uint64(fs.Bsize)
+	return uint64(fs.Blocks) * bsize, uint64(fs.Bavail) * bsize, nil
+}
@@ -0,0 +1,132 @@
+// +build linux darwin
+
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fs
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+
+	"camlistore.org/third_party/bazil.org/fuse"
+	"camlistore.org/third_party/bazil.org/fuse/fs"
+)
+
+// A cacheCtlDir is the ".camli_cache" directory of a mount with a
+// write-back cache, looked up from root.go and mut.go. Its "stats"
+// file holds the cacher.WriteBackStats as JSON, and writing "flush"
+// to its "control" file forces an upload of all dirty blobs. It is
+// what camtool mount-cache talks to.
+type cacheCtlDir struct {
+	fs *CamliFileSystem
+}
+
+func (d *cacheCtlDir) Attr() fuse.Attr {
+	return fuse.Attr{
+		Mode: os.ModeDir | 0700,
+		Uid:  uint32(os.Getuid()),
+		Gid:  uint32(os.Getgid()),
+	}
+}
+
+func (d *cacheCtlDir) ReadDir(intr fs.Intr) (ents []fuse.Dirent, err fuse.Error) {
+	return []fuse.Dirent{
+		{Name: "stats"},
+		{Name: "control"},
+	}, nil
+}
+
+func (d *cacheCtlDir) Lookup(req *fuse.LookupRequest, res *fuse.LookupResponse, intr fs.Intr) (fs.Node, fuse.Error) {
+	switch req.Name {
+	case "stats":
+		return &cacheStatsFile{fs: d.fs}, nil
+	case "control":
+		return &cacheCtlFile{fs: d.fs}, nil
+	}
+	return nil, fuse.ENOENT
+}
+
+// A cacheStatsFile serves the write-back cache stats as JSON.
+type cacheStatsFile struct {
+	fs *CamliFileSystem
+}
+
+func (f *cacheStatsFile) content() []byte {
+	b, err := json.MarshalIndent(f.fs.writeBack.Stats(), "", "  ")
+	if err != nil {
+		return nil
+	}
+	return append(b, '\n')
+}
+
+func (f *cacheStatsFile) Attr() fuse.Attr {
+	return fuse.Attr{
+		Mode: 0400,
+		Uid:  uint32(os.Getuid()),
+		Gid:  uint32(os.Getgid()),
+		Size: uint64(len(f.content())),
+	}
+}
+
+func (f *cacheStatsFile) Read(req *fuse.ReadRequest, res *fuse.ReadResponse, intr fs.Intr) fuse.Error {
+	c := f.content()
+	if req.Offset > int64(len(c)) {
+		return nil
+	}
+	c = c[req.Offset:]
+	size := req.Size
+	if size > len(c) {
+		size = len(c)
+	}
+	res.Data = make([]byte, size)
+	copy(res.Data, c)
+	return nil
+}
+
+// A cacheCtlFile accepts commands for the write-back cache. The only
+// command is "flush", which synchronously uploads all dirty blobs, so
+// the writer sees whether it succeeded.
+type cacheCtlFile struct {
+	fs *CamliFileSystem
+}
+
+func (f *cacheCtlFile) Attr() fuse.Attr {
+	return fuse.Attr{
+		Mode: 0200,
+		Uid:  uint32(os.Getuid()),
+		Gid:  uint32(os.Getgid()),
+	}
+}
+
+func (f *cacheCtlFile) Write(req *fuse.WriteRequest, res *fuse.WriteResponse, intr fs.Intr) fuse.Error {
+	cmd := strings.TrimSpace(string(req.Data))
+	switch cmd {
+	case "flush":
+		if err := f.fs.writeBack.Flush(); err != nil {
+			log.Printf("fs: write-back flush requested via control file: %v", err)
+			return fuse.EIO
+		}
+	default:
+		log.Printf("fs: unknown cache control command %q", cmd)
+		return fuse.Errno(syscall.EINVAL)
+	}
+	res.Size = len(req.Data)
+	return nil
+}
@@ -30,6 +30,8 @@ import (
 	"time"
 
 	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/cacher"
 	"camlistore.org/pkg/client"
 	"camlistore.org/pkg/lru"
 	"camlistore.org/pkg/schema"
@@ -52,6 +54,11 @@ type CamliFileSystem struct {
 	// permissions to 0600/0700.
 	IgnoreOwners bool
 
+	// writeBack, if non-nil, receives written file data instead of
+	// the client, staging it locally for asynchronous upload. See
+	// SetWriteBackCache.
+	writeBack *cacher.WriteBack
+
 	blobToSchema *lru.Cache // ~map[blobstring]*schema.Blob
 	nameToBlob   *lru.Cache // ~map[string]blob.Ref
 	nameToAttr   *lru.Cache // ~map[string]*fuse.Attr
@@ -97,6 +104,23 @@ func NewRootedCamliFileSystem(cli *client.Client, fetcher blob.Fetcher, root blo
 	return fs, nil
 }
 
+// SetWriteBackCache routes written file data through wb instead of
+// uploading it synchronously with the client, and exposes wb's stats
+// and a flush trigger under the ".camli_cache" directory of the mount.
+// It must be called before the filesystem is served.
+func (fs *CamliFileSystem) SetWriteBackCache(wb *cacher.WriteBack) {
+	fs.writeBack = wb
+}
+
+// fileDataReceiver returns the destination for written file data: the
+// write-back cache if one is configured, else the client.
+func (fs *CamliFileSystem) fileDataReceiver() blobserver.StatReceiver {
+	if fs.writeBack != nil {
+		return fs.writeBack
+	}
+	return fs.client
+}
+
 // node implements fuse.Node with a read-only Camli "file" or
 // "directory" blob.
 type node struct {
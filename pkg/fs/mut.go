@@ -285,6 +285,11 @@ func (n *mutDir) Lookup(name string, intr fs.Intr) (ret fs.Node, err fuse.Error)
 	if n2 := n.children[name]; n2 != nil {
 		return n2, nil
 	}
+	if name == ".camli_cache" && n.fs.writeBack != nil {
+		// The write-back cache control directory, reachable from
+		// any mutable directory so it also works on rooted mounts.
+		return &cacheCtlDir{fs: n.fs}, nil
+	}
 	return nil, fuse.ENOENT
 }
 
@@ -831,7 +836,7 @@ func (h *mutFileHandle) Flush(*fuse.FlushRequest, fs.Intr) fuse.Error {
 		return fuse.EIO
 	}
 	var n int64
-	br, err := schema.WriteFileFromReader(h.f.fs.client, h.f.name, readerutil.CountingReader{Reader: h.tmp, N: &n})
+	br, err := schema.WriteFileFromReader(h.f.fs.fileDataReceiver(), h.f.name, readerutil.CountingReader{Reader: h.tmp, N: &n})
 	if err != nil {
 		log.Println("mutFileHandle.Flush:", err)
 		return fuse.EIO
@@ -107,6 +107,11 @@ func (n *root) Lookup(name string, intr fs.Intr) (fs.Node, fuse.Error) {
 		return notImplementDirNode{}, nil
 	case ".camli_fs_stats":
 		return statsDir{}, nil
+	case ".camli_cache":
+		if n.fs.writeBack != nil {
+			return &cacheCtlDir{fs: n.fs}, nil
+		}
+		return nil, fuse.ENOENT
 	case "mach_kernel", ".hidden", "._.":
 		// Just quiet some log noise on OS X.
 		return nil, fuse.ENOENT
@@ -0,0 +1,144 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package googlestorage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// metadataHost serves instance metadata on Google Compute Engine VMs.
+	metadataHost = "metadata.google.internal"
+	// metadataTokenURL returns an access token for the instance's
+	// default service account.
+	metadataTokenURL = "http://" + metadataHost + "/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+var (
+	onGCEOnce sync.Once
+	onGCEv    bool
+)
+
+// OnGCE reports whether the process appears to be running on a Google
+// Compute Engine instance, by probing the metadata service. The first
+// call's answer is cached.
+func OnGCE() bool {
+	onGCEOnce.Do(func() {
+		client := &http.Client{Timeout: 750 * time.Millisecond}
+		res, err := client.Get("http://" + metadataHost)
+		if err != nil {
+			return
+		}
+		res.Body.Close()
+		onGCEv = res.Header.Get("Metadata-Flavor") == "Google"
+	})
+	return onGCEv
+}
+
+// gceTransport authorizes requests with access tokens for the
+// instance's default service account, fetched from the GCE metadata
+// service. The instance must have been started with a storage scope.
+type gceTransport struct {
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// NewGCEClient returns a Client using the credentials of the GCE
+// instance's default service account, from the metadata service.
+// It is only usable on a GCE VM.
+func NewGCEClient() *Client {
+	tr := &gceTransport{}
+	return &Client{tr, tr.Client()}
+}
+
+// Client returns an HTTP client adding the instance's access token to
+// each request, implementing the refreshTransport interface.
+func (t *gceTransport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+func (t *gceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.accessToken()
+	if err != nil {
+		return nil, err
+	}
+	// Per the http.RoundTripper contract, don't modify the request.
+	newReq := *req
+	newReq.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		newReq.Header[k] = v
+	}
+	newReq.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultTransport.RoundTrip(&newReq)
+}
+
+// Refresh implements the refreshTransport interface, forcing a new
+// access token on the next request.
+func (t *gceTransport) Refresh() error {
+	t.mu.Lock()
+	t.token = ""
+	t.mu.Unlock()
+	_, err := t.accessToken()
+	return err
+}
+
+// accessToken returns the current access token, fetching a new one
+// from the metadata service if absent or about to expire.
+func (t *gceTransport) accessToken() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Now().Add(time.Minute).Before(t.expiry) {
+		return t.token, nil
+	}
+	req, err := http.NewRequest("GET", metadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("googlestorage: error reading instance token from metadata service (not on GCE?): %v", err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("googlestorage: metadata service token request failed: %v; %s", res.Status, body)
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return "", err
+	}
+	if tok.AccessToken == "" {
+		return "", errors.New("googlestorage: no access token in metadata service response")
+	}
+	t.token = tok.AccessToken
+	t.expiry = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	return t.token, nil
+}
@@ -35,8 +35,16 @@ const (
 	gsAccessURL = "https://storage.googleapis.com"
 )
 
+// refreshTransport is the subset of *oauth.Transport the client
+// needs: an HTTP client whose credentials can be refreshed after an
+// auth error.
+type refreshTransport interface {
+	Refresh() error
+	Client() *http.Client
+}
+
 type Client struct {
-	transport *oauth.Transport
+	transport refreshTransport
 	client    *http.Client
 }
 
@@ -0,0 +1,206 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package googlestorage
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// jwtGrantType is the OAuth2 grant type for JWT bearer assertions,
+// used by Google service accounts.
+const jwtGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// serviceAccountKey is the relevant subset of the JSON key file
+// downloaded from the Google Developers Console for a service
+// account.
+type serviceAccountKey struct {
+	Type        string `json:"type"` // "service_account"
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"` // PEM
+}
+
+// serviceAccountTransport authorizes requests as a Google service
+// account, minting short-lived access tokens from a signed JWT
+// assertion instead of a stored refresh token, so no interactive
+// OAuth flow is needed.
+type serviceAccountTransport struct {
+	email string
+	key   *rsa.PrivateKey
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// NewServiceAccountClient returns a Client authenticating as the
+// service account whose JSON key file (as downloaded from the Google
+// Developers Console) is at keyFile.
+func NewServiceAccountClient(keyFile string) (*Client, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	var k serviceAccountKey
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("googlestorage: parsing service account key file %v: %v", keyFile, err)
+	}
+	if k.Type != "service_account" || k.ClientEmail == "" || k.PrivateKey == "" {
+		return nil, fmt.Errorf("googlestorage: %v doesn't look like a service account JSON key file", keyFile)
+	}
+	block, _ := pem.Decode([]byte(k.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("googlestorage: no PEM block in private key of %v", keyFile)
+	}
+	var rsaKey *rsa.PrivateKey
+	switch key, err := x509.ParsePKCS8PrivateKey(block.Bytes); {
+	case err == nil:
+		var ok bool
+		if rsaKey, ok = key.(*rsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("googlestorage: private key of %v is a %T, not RSA", keyFile, key)
+		}
+	default:
+		if rsaKey, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("googlestorage: parsing private key of %v: %v", keyFile, err)
+		}
+	}
+	tr := &serviceAccountTransport{
+		email: k.ClientEmail,
+		key:   rsaKey,
+	}
+	return &Client{tr, tr.Client()}, nil
+}
+
+// Client returns an HTTP client adding the service account's access
+// token to each request, implementing the refreshTransport interface.
+func (t *serviceAccountTransport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+func (t *serviceAccountTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.accessToken()
+	if err != nil {
+		return nil, err
+	}
+	// Per the http.RoundTripper contract, don't modify the request.
+	newReq := *req
+	newReq.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		newReq.Header[k] = v
+	}
+	newReq.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultTransport.RoundTrip(&newReq)
+}
+
+// Refresh implements the refreshTransport interface, forcing a new
+// access token on the next request.
+func (t *serviceAccountTransport) Refresh() error {
+	t.mu.Lock()
+	t.token = ""
+	t.mu.Unlock()
+	_, err := t.accessToken()
+	return err
+}
+
+// accessToken returns the current access token, minting a new one
+// from a JWT assertion if absent or about to expire.
+func (t *serviceAccountTransport) accessToken() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Now().Add(time.Minute).Before(t.expiry) {
+		return t.token, nil
+	}
+	assertion, err := t.jwtAssertion(time.Now())
+	if err != nil {
+		return "", err
+	}
+	res, err := http.PostForm(TokenURL, url.Values{
+		"grant_type": {jwtGrantType},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("googlestorage: token request for service account %v failed: %v; %s", t.email, res.Status, body)
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return "", err
+	}
+	if tok.AccessToken == "" {
+		return "", errors.New("googlestorage: no access token in service account token response")
+	}
+	t.token = tok.AccessToken
+	t.expiry = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	return t.token, nil
+}
+
+// jwtAssertion returns the RS256-signed JWT claiming the storage
+// scope for the service account, as the Google token endpoint
+// expects.
+func (t *serviceAccountTransport) jwtAssertion(now time.Time) (string, error) {
+	seg := func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return base64.RawURLEncoding.EncodeToString(b), nil
+	}
+	header, err := seg(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := seg(map[string]interface{}{
+		"iss":   t.email,
+		"scope": Scope,
+		"aud":   TokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + claims
+	h := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, t.key, crypto.SHA256, h[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httputil
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Degraded marking: degradeThreshold panics within degradeWindow on
+// one prefix mark it degraded on /status/.
+const (
+	degradeThreshold = 5
+	degradeWindow    = 10 * time.Minute
+)
+
+// PanicStatus describes the panics recovered on one handler prefix,
+// as reported on /status/.
+type PanicStatus struct {
+	Prefix    string    `json:"prefix"`
+	Count     int64     `json:"count"`
+	LastPanic time.Time `json:"lastPanic"`
+	LastValue string    `json:"lastValue"`
+	// Degraded is set when the prefix has panicked at least 5
+	// times in the past 10 minutes.
+	Degraded bool `json:"degraded,omitempty"`
+}
+
+type recoverHandler struct {
+	prefix string
+	h      http.Handler
+
+	mu      sync.Mutex
+	count   int64
+	last    time.Time
+	lastVal string
+	recent  []time.Time // panic times within degradeWindow, oldest first
+}
+
+var (
+	panicHandlerMu sync.Mutex
+	panicHandlers  []*recoverHandler
+
+	panicReqID int64 // incremented per recovered panic, for log/response correlation
+)
+
+// RecoverHandler wraps h so that a panic while serving a request is
+// contained to that request instead of killing its goroutine: the
+// stack is logged with a request ID, a per-prefix crash counter
+// (published as an expvar and on /status/) is incremented, and the
+// client gets a structured 500 error naming that request ID.
+func RecoverHandler(prefix string, h http.Handler) http.Handler {
+	rh := &recoverHandler{prefix: prefix, h: h}
+	panicHandlerMu.Lock()
+	panicHandlers = append(panicHandlers, rh)
+	panicHandlerMu.Unlock()
+	name := "handler-panics-" + prefix
+	if expvar.Get(name) == nil {
+		expvar.Publish(name, expvar.Func(func() interface{} { return rh.status() }))
+	}
+	return rh
+}
+
+func (rh *recoverHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		p := recover()
+		if p == nil {
+			return
+		}
+		reqID := strconv.FormatInt(atomic.AddInt64(&panicReqID, 1), 10)
+		buf := make([]byte, 32<<10)
+		buf = buf[:runtime.Stack(buf, false)]
+		log.Printf("httputil: panic serving %s %s on %s (request %s): %v\n%s",
+			r.Method, r.URL.Path, rh.prefix, reqID, p, buf)
+		rh.record(p)
+		serve500(w, r, rh.prefix, reqID)
+	}()
+	rh.h.ServeHTTP(w, r)
+}
+
+func (rh *recoverHandler) record(p interface{}) {
+	now := time.Now()
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	rh.count++
+	rh.last = now
+	rh.lastVal = fmt.Sprint(p)
+	rh.recent = append(rh.recent, now)
+	rh.pruneRecent(now)
+}
+
+// pruneRecent drops panic times older than degradeWindow.
+// The caller must hold rh.mu.
+func (rh *recoverHandler) pruneRecent(now time.Time) {
+	cutoff := now.Add(-degradeWindow)
+	i := 0
+	for ; i < len(rh.recent); i++ {
+		if rh.recent[i].After(cutoff) {
+			break
+		}
+	}
+	rh.recent = rh.recent[i:]
+}
+
+func (rh *recoverHandler) status() PanicStatus {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	rh.pruneRecent(time.Now())
+	return PanicStatus{
+		Prefix:    rh.prefix,
+		Count:     rh.count,
+		LastPanic: rh.last,
+		LastValue: rh.lastVal,
+		Degraded:  len(rh.recent) >= degradeThreshold,
+	}
+}
+
+// PanicStatuses returns the state of all prefixes that have recovered
+// at least one panic, sorted by prefix.
+func PanicStatuses() []PanicStatus {
+	panicHandlerMu.Lock()
+	handlers := make([]*recoverHandler, len(panicHandlers))
+	copy(handlers, panicHandlers)
+	panicHandlerMu.Unlock()
+	var sts []PanicStatus
+	for _, rh := range handlers {
+		if st := rh.status(); st.Count > 0 {
+			sts = append(sts, st)
+		}
+	}
+	sort.Sort(panicStatusByPrefix(sts))
+	return sts
+}
+
+type panicStatusByPrefix []PanicStatus
+
+func (s panicStatusByPrefix) Len() int           { return len(s) }
+func (s panicStatusByPrefix) Less(i, j int) bool { return s[i].Prefix < s[j].Prefix }
+func (s panicStatusByPrefix) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// serve500 writes a structured 500 error naming the recovered request
+// ID, as JSON unless the client prefers HTML. The panic value itself
+// is only logged, to not leak internals to unauthenticated clients.
+func serve500(w http.ResponseWriter, r *http.Request, prefix, reqID string) {
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "<html><body><h1>Internal Server Error</h1><p>The %s handler crashed serving this request (request %s). The error has been logged.</p></body></html>\n",
+			prefix, reqID)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, "{\"error\":\"internal server error on %s handler\",\"requestId\":%q}\n", prefix, reqID)
+}
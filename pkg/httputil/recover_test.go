@@ -0,0 +1,108 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httputil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoverHandler(t *testing.T) {
+	panicky := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	h := RecoverHandler("/panic-test/", panicky)
+
+	req, _ := http.NewRequest("GET", "/panic-test/foo", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d; want 500", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("got Content-Type %q; want JSON", ct)
+	}
+	var body struct {
+		Error     string `json:"error"`
+		RequestID string `json:"requestId"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unparseable 500 body %q: %v", rec.Body.Bytes(), err)
+	}
+	if !strings.Contains(body.Error, "/panic-test/") || body.RequestID == "" {
+		t.Errorf("unexpected 500 body: %+v", body)
+	}
+
+	// HTML for browsers.
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("got Content-Type %q; want HTML", ct)
+	}
+
+	st := findPanicStatus(t, "/panic-test/")
+	if st.Count != 2 || st.LastValue != "boom" {
+		t.Errorf("got status %+v; want count 2, last value \"boom\"", st)
+	}
+	if st.Degraded {
+		t.Errorf("prefix degraded after %d panics; threshold is %d", st.Count, degradeThreshold)
+	}
+}
+
+func TestRecoverHandlerDegraded(t *testing.T) {
+	h := RecoverHandler("/degraded-test/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("again")
+	}))
+	req, _ := http.NewRequest("GET", "/degraded-test/", nil)
+	for i := 0; i < degradeThreshold; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if st := findPanicStatus(t, "/degraded-test/"); !st.Degraded {
+		t.Errorf("prefix not degraded after %d panics: %+v", degradeThreshold, st)
+	}
+}
+
+func TestRecoverHandlerNoPanic(t *testing.T) {
+	h := RecoverHandler("/calm-test/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/calm-test/", nil)
+	h.ServeHTTP(rec, req)
+	if rec.Code != 200 || rec.Body.String() != "ok" {
+		t.Errorf("got %d %q; want 200 ok", rec.Code, rec.Body.String())
+	}
+	for _, st := range PanicStatuses() {
+		if st.Prefix == "/calm-test/" {
+			t.Errorf("prefix without panics reported: %+v", st)
+		}
+	}
+}
+
+func findPanicStatus(t *testing.T, prefix string) PanicStatus {
+	for _, st := range PanicStatuses() {
+		if st.Prefix == prefix {
+			return st
+		}
+	}
+	t.Fatalf("prefix %s not in PanicStatuses", prefix)
+	return PanicStatus{}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package images
+
+import (
+	"fmt"
+	"image"
+)
+
+// tinyPreviewGrid is the number of cells per side in a TinyPreview.
+const tinyPreviewGrid = 4
+
+// TinyPreview returns a very small fixed-size representation of m: the
+// average color of each cell of a 4x4 grid over the image, row-major,
+// as 96 lowercase hex characters (6 per cell, RGB). It is small enough
+// to store in an index row, so a UI can paint a rough placeholder from
+// search results alone, before any real thumbnail loads.
+// It returns the empty string if m has no pixels.
+func TinyPreview(m image.Image) string {
+	b := m.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return ""
+	}
+	buf := make([]byte, 0, tinyPreviewGrid*tinyPreviewGrid*6)
+	for ty := 0; ty < tinyPreviewGrid; ty++ {
+		y0 := b.Min.Y + ty*b.Dy()/tinyPreviewGrid
+		y1 := b.Min.Y + (ty+1)*b.Dy()/tinyPreviewGrid
+		if y1 == y0 {
+			y1++
+		}
+		for tx := 0; tx < tinyPreviewGrid; tx++ {
+			x0 := b.Min.X + tx*b.Dx()/tinyPreviewGrid
+			x1 := b.Min.X + (tx+1)*b.Dx()/tinyPreviewGrid
+			if x1 == x0 {
+				x1++
+			}
+			buf = appendCellColor(buf, m, x0, y0, x1, y1)
+		}
+	}
+	return string(buf)
+}
+
+// appendCellColor appends the average color of the pixels of m in
+// [x0,x1)x[y0,y1) to buf as 6 lowercase hex characters. Large cells
+// are sampled rather than read exhaustively, to bound the cost on big
+// images.
+func appendCellColor(buf []byte, m image.Image, x0, y0, x1, y1 int) []byte {
+	const maxSamplesPerSide = 16
+	xstep, ystep := 1, 1
+	if w := x1 - x0; w > maxSamplesPerSide {
+		xstep = w / maxSamplesPerSide
+	}
+	if h := y1 - y0; h > maxSamplesPerSide {
+		ystep = h / maxSamplesPerSide
+	}
+	var rsum, gsum, bsum, n uint64
+	for y := y0; y < y1; y += ystep {
+		for x := x0; x < x1; x += xstep {
+			r, g, b, _ := m.At(x, y).RGBA()
+			rsum += uint64(r >> 8)
+			gsum += uint64(g >> 8)
+			bsum += uint64(b >> 8)
+			n++
+		}
+	}
+	return append(buf, fmt.Sprintf("%02x%02x%02x", rsum/n, gsum/n, bsum/n)...)
+}
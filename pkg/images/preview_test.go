@@ -0,0 +1,64 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package images
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"testing"
+)
+
+func TestTinyPreviewUniform(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	draw.Draw(m, m.Bounds(), &image.Uniform{color.RGBA{0x12, 0x34, 0x56, 0xff}}, image.Point{}, draw.Src)
+	got := TinyPreview(m)
+	want := strings.Repeat("123456", 16)
+	if got != want {
+		t.Errorf("TinyPreview = %q; want %q", got, want)
+	}
+}
+
+func TestTinyPreviewCells(t *testing.T) {
+	// Left half white, right half black: the two left columns of
+	// cells should be white, the two right ones black.
+	m := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	draw.Draw(m, image.Rect(0, 0, 8, 16), image.White, image.Point{}, draw.Src)
+	got := TinyPreview(m)
+	if len(got) != 96 {
+		t.Fatalf("TinyPreview length = %d; want 96", len(got))
+	}
+	want := strings.Repeat("ffffffffffff000000000000", 4)
+	if got != want {
+		t.Errorf("TinyPreview = %q; want %q", got, want)
+	}
+}
+
+func TestTinyPreviewEmpty(t *testing.T) {
+	if got := TinyPreview(image.NewRGBA(image.Rect(0, 0, 0, 0))); got != "" {
+		t.Errorf("TinyPreview of empty image = %q; want empty", got)
+	}
+}
+
+func BenchmarkTinyPreview(b *testing.B) {
+	m := image.NewYCbCr(image.Rect(0, 0, 64, 64), image.YCbCrSubsampleRatio422)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = TinyPreview(m)
+	}
+}
@@ -18,10 +18,14 @@ limitations under the License.
 package allimporters
 
 import (
+	_ "camlistore.org/pkg/importer/blog"
+	_ "camlistore.org/pkg/importer/dropbox"
 	_ "camlistore.org/pkg/importer/dummy"
 	_ "camlistore.org/pkg/importer/feed"
 	_ "camlistore.org/pkg/importer/flickr"
 	_ "camlistore.org/pkg/importer/foursquare"
+	_ "camlistore.org/pkg/importer/ical"
 	_ "camlistore.org/pkg/importer/picasa"
 	_ "camlistore.org/pkg/importer/twitter"
+	_ "camlistore.org/pkg/importer/youtube"
 )
@@ -76,4 +76,20 @@ const (
 
 	// http://schema.org/primaryImageOfPage
 	AttrPrimaryImageOfPage = "primaryImageOfPage"
+
+	// Provenance of imported bytes.
+
+	// AttrSourceDownloadURL is the URL the item's bytes were
+	// actually fetched from, as opposed to AttrURL, which is the
+	// item's canonical page.
+	AttrSourceDownloadURL = "sourceDownloadURL"
+	// AttrSourceChecksum is the checksum of the item's bytes as
+	// reported by the service imported from, prefixed with its
+	// type. Example: "md5-e66e5a1d1bfc86e47478fc3d71e5aa48".
+	AttrSourceChecksum = "sourceChecksum"
+	// AttrChecksumVerified is "true" if AttrSourceChecksum matched
+	// our hash of the fetched bytes at import time, "false" if it
+	// didn't. A mismatch doesn't fail the import; it's only
+	// flagged here (and logged) for later investigation.
+	AttrChecksumVerified = "checksumVerified"
 )
@@ -0,0 +1,362 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package blog implements an importer for blog exports: WordPress WXR
+// files and LiveJournal XML exports. The export file is first uploaded
+// as a regular file (camput file export.xml) and its file blobref is
+// then given to the importer, which creates a permanode per post,
+// keyed by the post's GUID so re-importing the same export is
+// idempotent.
+package blog
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/httputil"
+	"camlistore.org/pkg/importer"
+	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/schema/nodeattr"
+)
+
+const (
+	// acctAttrExportFileRef is the file blobref of the uploaded
+	// export file (WXR or LiveJournal XML).
+	acctAttrExportFileRef = "blogExportFileRef"
+
+	// acctAttrFetchImages is "true" if images referenced by URL in
+	// post bodies should be fetched and stored, with an "archived"
+	// variant of the post written with the links rewritten to the
+	// stored copies.
+	acctAttrFetchImages = "blogFetchImages"
+
+	// acctAttrExportDoneVersion notes which export file (and
+	// importer version) was fully imported, so unchanged re-runs
+	// can stop early.
+	acctAttrExportDoneVersion = "blogExportDoneVersion" // == "<fileref>:<runCompleteVersion>"
+
+	// attrPostGUID is the globally unique ID of a post from the
+	// export, used for dedup.
+	attrPostGUID = "blogPostGUID"
+
+	// attrArchivedContent is the file blobref of the "archived"
+	// variant of a post's HTML, with image URLs rewritten to the
+	// blobrefs of fetched copies.
+	attrArchivedContent = "blogArchivedContent"
+
+	runCompleteVersion = "1"
+)
+
+func init() {
+	importer.Register("blog", &imp{
+		urlFileRef: make(map[string]blob.Ref),
+	})
+}
+
+type imp struct {
+	mu         sync.Mutex          // guards urlFileRef
+	urlFileRef map[string]blob.Ref // image URL to file schema blobref
+
+	importer.OAuth1 // for CallbackRequestAccount and CallbackURLParameters
+}
+
+func (im *imp) NeedsAPIKey() bool { return false }
+
+func (im *imp) IsAccountReady(acctNode *importer.Object) (ok bool, err error) {
+	return acctNode.Attr(acctAttrExportFileRef) != "", nil
+}
+
+func (im *imp) SummarizeAccount(acct *importer.Object) string {
+	ok, err := im.IsAccountReady(acct)
+	if err != nil {
+		return "Not configured; error = " + err.Error()
+	}
+	if !ok {
+		return "Not configured"
+	}
+	s := fmt.Sprintf("blog export %s", acct.Attr(acctAttrExportFileRef))
+	if acct.Attr(acctAttrFetchImages) == "true" {
+		s += " + images"
+	}
+	return s
+}
+
+// A post is a normalized blog post, parsed from either a WXR or a
+// LiveJournal export.
+type post struct {
+	GUID     string // used for dedup; never empty
+	Title    string
+	URL      string
+	Date     time.Time
+	Author   string
+	Content  string // HTML
+	Tags     []string
+	Comments []*comment
+}
+
+type comment struct {
+	ID      string // unique within its post
+	Author  string
+	Date    time.Time
+	Content string
+}
+
+// A run is our state for a given run of the importer.
+type run struct {
+	*importer.RunContext
+	im          *imp
+	fetchImages bool
+}
+
+func (im *imp) Run(ctx *importer.RunContext) error {
+	acctNode := ctx.AccountNode()
+	fileRef := acctNode.Attr(acctAttrExportFileRef)
+	if fileRef == "" {
+		return fmt.Errorf("blog: no %s attribute set", acctAttrExportFileRef)
+	}
+	doneVal := fileRef + ":" + runCompleteVersion
+	if acctNode.Attr(acctAttrExportDoneVersion) == doneVal {
+		log.Printf("blog: export %s already imported; skipping.", fileRef)
+		return nil
+	}
+	br, ok := blob.Parse(fileRef)
+	if !ok {
+		return fmt.Errorf("blog: invalid export file blobref %q", fileRef)
+	}
+	fr, err := schema.NewFileReader(ctx.Host.BlobSource(), br)
+	if err != nil {
+		return fmt.Errorf("blog: error opening export %v: %v", br, err)
+	}
+	defer fr.Close()
+	slurp := make([]byte, fr.Size())
+	if _, err := fr.ReadAt(slurp, 0); err != nil {
+		return fmt.Errorf("blog: error reading export %v: %v", br, err)
+	}
+
+	var posts []*post
+	if bytes.Contains(slurp[:min(len(slurp), 1024)], []byte("<livejournal")) {
+		posts, err = parseLiveJournal(slurp)
+	} else {
+		posts, err = parseWXR(slurp)
+	}
+	if err != nil {
+		return err
+	}
+
+	r := &run{
+		RunContext:  ctx,
+		im:          im,
+		fetchImages: acctNode.Attr(acctAttrFetchImages) == "true",
+	}
+	postsNode, err := r.getTopLevelNode("posts")
+	if err != nil {
+		return err
+	}
+	for _, p := range posts {
+		if err := r.importPost(postsNode, p); err != nil {
+			return fmt.Errorf("blog: error importing post %q: %v", p.GUID, err)
+		}
+	}
+	log.Printf("blog: imported %d posts from %s", len(posts), fileRef)
+	return acctNode.SetAttrs(acctAttrExportDoneVersion, doneVal)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (r *run) importPost(parent *importer.Object, p *post) error {
+	postNode, err := parent.ChildPathObject(p.GUID)
+	if err != nil {
+		return err
+	}
+	contentRef, err := schema.WriteFileFromReader(r.Host.Target(), "post.html", strings.NewReader(p.Content))
+	if err != nil {
+		return err
+	}
+	attrs := []string{
+		attrPostGUID, p.GUID,
+		nodeattr.Type, "blog:post",
+		nodeattr.Title, p.Title,
+		nodeattr.StartDate, schema.RFC3339FromTime(p.Date),
+		nodeattr.CamliContent, contentRef.String(),
+	}
+	if p.URL != "" {
+		attrs = append(attrs, nodeattr.URL, p.URL)
+	}
+	if p.Author != "" {
+		attrs = append(attrs, "author", p.Author)
+	}
+	if r.fetchImages {
+		archived, changed := r.archiveImages(p.Content)
+		if changed {
+			archivedRef, err := schema.WriteFileFromReader(r.Host.Target(), "post-archived.html", strings.NewReader(archived))
+			if err != nil {
+				return err
+			}
+			attrs = append(attrs, attrArchivedContent, archivedRef.String())
+		}
+	}
+	if err := postNode.SetAttrs(attrs...); err != nil {
+		return err
+	}
+	if err := postNode.SetAttrValues("tag", p.Tags); err != nil {
+		return err
+	}
+	for _, c := range p.Comments {
+		if err := r.importComment(postNode, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *run) importComment(postNode *importer.Object, c *comment) error {
+	commentNode, err := postNode.ChildPathObject("comment-" + c.ID)
+	if err != nil {
+		return err
+	}
+	contentRef, err := schema.WriteFileFromReader(r.Host.Target(), "comment.html", strings.NewReader(c.Content))
+	if err != nil {
+		return err
+	}
+	return commentNode.SetAttrs(
+		nodeattr.Type, "blog:comment",
+		nodeattr.StartDate, schema.RFC3339FromTime(c.Date),
+		"author", c.Author,
+		nodeattr.CamliContent, contentRef.String(),
+	)
+}
+
+var imgSrcRx = regexp.MustCompile(`(<img[^>]+src=["'])(https?://[^"']+)(["'])`)
+
+// archiveImages fetches the images referenced by URL in the HTML body,
+// stores them, and returns a variant of the body with the image URLs
+// replaced by the blobrefs of the stored copies. changed reports
+// whether any image was successfully archived.
+func (r *run) archiveImages(body string) (archived string, changed bool) {
+	archived = imgSrcRx.ReplaceAllStringFunc(body, func(m string) string {
+		sub := imgSrcRx.FindStringSubmatch(m)
+		ref := r.urlFileRef(sub[2])
+		if ref == "" {
+			return m
+		}
+		changed = true
+		return sub[1] + ref + sub[3]
+	})
+	return
+}
+
+// urlFileRef slurps urlstr from the net, writes it to a file blob and
+// returns its blobref, or "" on error.
+func (r *run) urlFileRef(urlstr string) string {
+	im := r.im
+	im.mu.Lock()
+	if br, ok := im.urlFileRef[urlstr]; ok {
+		im.mu.Unlock()
+		return br.String()
+	}
+	im.mu.Unlock()
+
+	res, err := r.HTTPClient().Get(urlstr)
+	if err != nil {
+		log.Printf("blog: error fetching image %s: %v", urlstr, err)
+		return ""
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		log.Printf("blog: status %s fetching image %s", res.Status, urlstr)
+		return ""
+	}
+	filename := urlstr[strings.LastIndex(urlstr, "/")+1:]
+	fileRef, err := schema.WriteFileFromReader(r.Host.Target(), filename, res.Body)
+	if err != nil {
+		log.Printf("blog: error storing image %s: %v", urlstr, err)
+		return ""
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.urlFileRef[urlstr] = fileRef
+	return fileRef.String()
+}
+
+func (r *run) getTopLevelNode(path string) (*importer.Object, error) {
+	root := r.RootNode()
+	if root.Attr(nodeattr.Title) == "" {
+		if err := root.SetAttr(nodeattr.Title, "Blog Import"); err != nil {
+			return nil, err
+		}
+	}
+	obj, err := root.ChildPathObject(path)
+	if err != nil {
+		return nil, err
+	}
+	var title string
+	switch path {
+	case "posts":
+		title = "Blog Posts"
+	}
+	return obj, obj.SetAttr(nodeattr.Title, title)
+}
+
+func (im *imp) ServeSetup(w http.ResponseWriter, r *http.Request, ctx *importer.SetupContext) error {
+	return tmpl.ExecuteTemplate(w, "serveSetup", ctx)
+}
+
+var tmpl = template.Must(template.New("root").Parse(`
+{{define "serveSetup"}}
+<h1>Configuring Blog Import</h1>
+<p>Upload your WordPress WXR or LiveJournal XML export with
+<b>camput file export.xml</b> and paste the printed file blobref below.</p>
+<form method="get" action="{{.CallbackURL}}">
+  <input type="hidden" name="acct" value="{{.AccountNode.PermanodeRef}}">
+  <table border=0 cellpadding=3>
+  <tr><td align=right>Export file blobref</td><td><input name="exportFileRef" size=50></td></tr>
+  <tr><td align=right>Fetch images</td><td><input type="checkbox" name="fetchImages" value="true"></td></tr>
+  <tr><td align=right></td><td><input type="submit" value="Add"></td></tr>
+  </table>
+</form>
+{{end}}
+`))
+
+func (im *imp) ServeCallback(w http.ResponseWriter, r *http.Request, ctx *importer.SetupContext) {
+	ref := r.FormValue("exportFileRef")
+	br, ok := blob.Parse(ref)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Expected a file blobref; got %q", ref), 400)
+		return
+	}
+	if err := ctx.AccountNode.SetAttrs(
+		acctAttrExportFileRef, br.String(),
+		acctAttrFetchImages, r.FormValue("fetchImages"),
+	); err != nil {
+		httputil.ServeError(w, r, fmt.Errorf("Error setting attribute: %v", err))
+		return
+	}
+	http.Redirect(w, r, ctx.AccountURL(), http.StatusFound)
+}
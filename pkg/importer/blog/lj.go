@@ -0,0 +1,105 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blog
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LiveJournal's per-month XML export: a <livejournal> root with one
+// <entry> per post.
+
+type ljExport struct {
+	XMLName xml.Name  `xml:"livejournal"`
+	Entries []ljEntry `xml:"entry"`
+}
+
+type ljEntry struct {
+	ItemID    string      `xml:"itemid"`
+	EventTime string      `xml:"eventtime"`
+	Subject   string      `xml:"subject"`
+	Event     string      `xml:"event"`
+	URL       string      `xml:"url"`
+	TagList   string      `xml:"taglist"`
+	Comments  []ljComment `xml:"comments>comment"`
+}
+
+type ljComment struct {
+	ID     string `xml:"id"`
+	User   string `xml:"user"`
+	Date   string `xml:"date"`
+	Body   string `xml:"body"`
+	Anonym string `xml:"anonym,attr"`
+}
+
+// ljTimeLayout is the format of eventtime, in the journal's local time.
+const ljTimeLayout = "2006-01-02 15:04:05"
+
+// parseLiveJournal parses a LiveJournal XML export.
+func parseLiveJournal(data []byte) ([]*post, error) {
+	var export ljExport
+	if err := xml.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("blog: error parsing LiveJournal export: %v", err)
+	}
+	var posts []*post
+	for _, e := range export.Entries {
+		if e.ItemID == "" {
+			continue
+		}
+		date, err := time.ParseInLocation(ljTimeLayout, e.EventTime, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("blog: bad eventtime for LiveJournal entry %s: %v", e.ItemID, err)
+		}
+		p := &post{
+			GUID:    "livejournal-" + e.ItemID,
+			Title:   e.Subject,
+			URL:     e.URL,
+			Date:    date,
+			Content: e.Event,
+		}
+		for _, tag := range strings.Split(e.TagList, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				p.Tags = append(p.Tags, tag)
+			}
+		}
+		for _, c := range e.Comments {
+			author := c.User
+			if author == "" {
+				author = "anonymous"
+			}
+			cdate, err := time.ParseInLocation(ljTimeLayout, c.Date, time.Local)
+			if err != nil {
+				// Comment dates are also seen in RFC 3339 form.
+				cdate, err = time.Parse(time.RFC3339, c.Date)
+				if err != nil {
+					return nil, fmt.Errorf("blog: bad comment date for LiveJournal entry %s: %v", e.ItemID, err)
+				}
+			}
+			p.Comments = append(p.Comments, &comment{
+				ID:      c.ID,
+				Author:  author,
+				Date:    cdate,
+				Content: c.Body,
+			})
+		}
+		posts = append(posts, p)
+	}
+	return posts, nil
+}
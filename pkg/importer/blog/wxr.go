@@ -0,0 +1,129 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blog
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+// WXR (WordPress eXtended RSS) is RSS 2.0 plus elements in the
+// wp: namespace, which is versioned (http://wordpress.org/export/1.2/
+// and earlier). The wp: elements below are matched by local name only,
+// so any export version works.
+
+type wxrExport struct {
+	XMLName xml.Name `xml:"rss"`
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []wxrItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type wxrItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	Creator     string        `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	GUID        string        `xml:"guid"`
+	Content     string        `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	PostDateGMT string        `xml:"post_date_gmt"`
+	PostDate    string        `xml:"post_date"`
+	PostType    string        `xml:"post_type"`
+	Status      string        `xml:"status"`
+	Categories  []wxrCategory `xml:"category"`
+	Comments    []wxrComment  `xml:"comment"`
+}
+
+type wxrCategory struct {
+	Domain string `xml:"domain,attr"`
+	Name   string `xml:",chardata"`
+}
+
+type wxrComment struct {
+	ID       string `xml:"comment_id"`
+	Author   string `xml:"comment_author"`
+	DateGMT  string `xml:"comment_date_gmt"`
+	Date     string `xml:"comment_date"`
+	Content  string `xml:"comment_content"`
+	Approved string `xml:"comment_approved"`
+}
+
+// wxrTimeLayout is the format of wp:post_date and wp:comment_date.
+const wxrTimeLayout = "2006-01-02 15:04:05"
+
+func parseWXRTime(gmt, local string) (time.Time, error) {
+	if gmt != "" && gmt != "0000-00-00 00:00:00" {
+		return time.Parse(wxrTimeLayout, gmt)
+	}
+	return time.ParseInLocation(wxrTimeLayout, local, time.Local)
+}
+
+// parseWXR parses a WordPress WXR export, returning its published
+// posts.
+func parseWXR(data []byte) ([]*post, error) {
+	var export wxrExport
+	if err := xml.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("blog: error parsing WXR: %v", err)
+	}
+	var posts []*post
+	for _, item := range export.Channel.Items {
+		if item.PostType != "" && item.PostType != "post" {
+			continue // skip pages, attachments, nav menus, etc.
+		}
+		if item.Status != "" && item.Status != "publish" {
+			continue
+		}
+		if item.GUID == "" {
+			continue
+		}
+		date, err := parseWXRTime(item.PostDateGMT, item.PostDate)
+		if err != nil {
+			return nil, fmt.Errorf("blog: bad post date for %q: %v", item.GUID, err)
+		}
+		p := &post{
+			GUID:    item.GUID,
+			Title:   item.Title,
+			URL:     item.Link,
+			Date:    date,
+			Author:  item.Creator,
+			Content: item.Content,
+		}
+		for _, cat := range item.Categories {
+			if cat.Domain == "post_tag" || cat.Domain == "category" || cat.Domain == "tag" {
+				p.Tags = append(p.Tags, cat.Name)
+			}
+		}
+		for _, wc := range item.Comments {
+			if wc.Approved != "" && wc.Approved != "1" {
+				continue
+			}
+			cdate, err := parseWXRTime(wc.DateGMT, wc.Date)
+			if err != nil {
+				return nil, fmt.Errorf("blog: bad comment date for %q: %v", item.GUID, err)
+			}
+			p.Comments = append(p.Comments, &comment{
+				ID:      wc.ID,
+				Author:  wc.Author,
+				Date:    cdate,
+				Content: wc.Content,
+			})
+		}
+		posts = append(posts, p)
+	}
+	return posts, nil
+}
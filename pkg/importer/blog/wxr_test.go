@@ -0,0 +1,150 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const wxrSample = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0"
+	xmlns:content="http://purl.org/rss/1.0/modules/content/"
+	xmlns:dc="http://purl.org/dc/elements/1.1/"
+	xmlns:wp="http://wordpress.org/export/1.2/">
+<channel>
+	<title>Example Blog</title>
+	<item>
+		<title>Hello world</title>
+		<link>http://blog.example.com/2004/01/hello-world/</link>
+		<dc:creator><![CDATA[alice]]></dc:creator>
+		<guid isPermaLink="false">http://blog.example.com/?p=1</guid>
+		<content:encoded><![CDATA[<p>First post.</p>]]></content:encoded>
+		<wp:post_date><![CDATA[2004-01-02 10:00:00]]></wp:post_date>
+		<wp:post_date_gmt><![CDATA[2004-01-02 18:00:00]]></wp:post_date_gmt>
+		<wp:status><![CDATA[publish]]></wp:status>
+		<wp:post_type><![CDATA[post]]></wp:post_type>
+		<category domain="post_tag" nicename="meta"><![CDATA[meta]]></category>
+		<category domain="category" nicename="general"><![CDATA[General]]></category>
+		<wp:comment>
+			<wp:comment_id>7</wp:comment_id>
+			<wp:comment_author><![CDATA[bob]]></wp:comment_author>
+			<wp:comment_date><![CDATA[2004-01-03 09:00:00]]></wp:comment_date>
+			<wp:comment_date_gmt><![CDATA[2004-01-03 17:00:00]]></wp:comment_date_gmt>
+			<wp:comment_content><![CDATA[Welcome!]]></wp:comment_content>
+			<wp:comment_approved><![CDATA[1]]></wp:comment_approved>
+		</wp:comment>
+		<wp:comment>
+			<wp:comment_id>8</wp:comment_id>
+			<wp:comment_author><![CDATA[spammer]]></wp:comment_author>
+			<wp:comment_date_gmt><![CDATA[2004-01-04 00:00:00]]></wp:comment_date_gmt>
+			<wp:comment_content><![CDATA[Buy pills]]></wp:comment_content>
+			<wp:comment_approved><![CDATA[spam]]></wp:comment_approved>
+		</wp:comment>
+	</item>
+	<item>
+		<title>A draft</title>
+		<guid isPermaLink="false">http://blog.example.com/?p=2</guid>
+		<wp:post_date_gmt><![CDATA[2004-02-01 00:00:00]]></wp:post_date_gmt>
+		<wp:status><![CDATA[draft]]></wp:status>
+		<wp:post_type><![CDATA[post]]></wp:post_type>
+	</item>
+	<item>
+		<title>attachment.jpg</title>
+		<guid isPermaLink="false">http://blog.example.com/?p=3</guid>
+		<wp:post_date_gmt><![CDATA[2004-02-01 00:00:00]]></wp:post_date_gmt>
+		<wp:status><![CDATA[publish]]></wp:status>
+		<wp:post_type><![CDATA[attachment]]></wp:post_type>
+	</item>
+</channel>
+</rss>`
+
+func TestParseWXR(t *testing.T) {
+	posts, err := parseWXR([]byte(wxrSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 1 {
+		t.Fatalf("got %d posts; want 1 (drafts and attachments skipped)", len(posts))
+	}
+	p := posts[0]
+	if p.GUID != "http://blog.example.com/?p=1" {
+		t.Errorf("GUID = %q", p.GUID)
+	}
+	if p.Title != "Hello world" || p.Author != "alice" {
+		t.Errorf("title/author = %q/%q", p.Title, p.Author)
+	}
+	if p.Content != "<p>First post.</p>" {
+		t.Errorf("content = %q", p.Content)
+	}
+	if want := time.Date(2004, 1, 2, 18, 0, 0, 0, time.UTC); !p.Date.Equal(want) {
+		t.Errorf("date = %v; want %v", p.Date, want)
+	}
+	if want := []string{"meta", "General"}; !reflect.DeepEqual(p.Tags, want) {
+		t.Errorf("tags = %q; want %q", p.Tags, want)
+	}
+	if len(p.Comments) != 1 {
+		t.Fatalf("got %d comments; want 1 (spam skipped)", len(p.Comments))
+	}
+	c := p.Comments[0]
+	if c.ID != "7" || c.Author != "bob" || c.Content != "Welcome!" {
+		t.Errorf("comment = %+v", c)
+	}
+}
+
+const ljSample = `<?xml version="1.0" encoding="UTF-8"?>
+<livejournal>
+<entry>
+	<itemid>129</itemid>
+	<eventtime>2004-03-01 21:10:00</eventtime>
+	<subject>An update</subject>
+	<event>Not much happened.</event>
+	<taglist>life, misc</taglist>
+	<comments>
+		<comment>
+			<id>3</id>
+			<user>frank</user>
+			<date>2004-03-02 08:00:00</date>
+			<body>Same here.</body>
+		</comment>
+	</comments>
+</entry>
+</livejournal>`
+
+func TestParseLiveJournal(t *testing.T) {
+	posts, err := parseLiveJournal([]byte(ljSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 1 {
+		t.Fatalf("got %d posts; want 1", len(posts))
+	}
+	p := posts[0]
+	if p.GUID != "livejournal-129" {
+		t.Errorf("GUID = %q", p.GUID)
+	}
+	if p.Title != "An update" || p.Content != "Not much happened." {
+		t.Errorf("title/content = %q/%q", p.Title, p.Content)
+	}
+	if want := []string{"life", "misc"}; !reflect.DeepEqual(p.Tags, want) {
+		t.Errorf("tags = %q; want %q", p.Tags, want)
+	}
+	if len(p.Comments) != 1 || p.Comments[0].Author != "frank" {
+		t.Errorf("comments = %+v", p.Comments)
+	}
+}
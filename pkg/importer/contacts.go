@@ -0,0 +1,280 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/context"
+	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/search"
+)
+
+const (
+	nodeTypePeopleRoot = "peopleRoot"
+	nodeTypePerson     = "person"
+
+	// attrImportContacts is set to "true" on an account node to
+	// enable the contacts pass for that account.
+	attrImportContacts = "importContacts"
+
+	// attrServiceID is the multi-valued person attribute listing
+	// the person's service-qualified user IDs, e.g.
+	// "twitter:114053510". A person known to several services
+	// accumulates all their IDs on the one node.
+	attrServiceID = "serviceID"
+
+	// attrEmail is the person's email address, the preferred
+	// deduplication key across services.
+	attrEmail = "email"
+
+	// attrContentImage is the fileref of the person's avatar
+	// image.
+	attrContentImage = "camliContentImage"
+)
+
+// A Contact is one entry of an account's contact or "following" list,
+// in the normalized form a ContactsFetcher returns. ID is the
+// contact's user ID on the service and is required; all other fields
+// are optional.
+type Contact struct {
+	ID         string // e.g. "114053510"
+	Email      string
+	Name       string // display name, e.g. "John Smith"
+	UserName   string // service account name, e.g. "johnsmith"
+	ProfileURL string
+	AvatarURL  string
+}
+
+// A ContactsFetcher is an optional interface implemented by importers
+// whose service exposes the account's contact list. When an account
+// has its contacts toggle enabled, the host runs a contacts pass
+// after each successful import run, upserting a person permanode
+// under the shared People root for each fetched contact.
+type ContactsFetcher interface {
+	FetchContacts(*RunContext) ([]*Contact, error)
+}
+
+// PeopleRoot returns (creating if necessary) the permanode under
+// which all importers file the person permanodes of imported
+// contacts. It is shared across importers and accounts, so the same
+// person is one node no matter how many services know them.
+func (h *Host) PeopleRoot() (*Object, error) {
+	h.peoplemu.Lock()
+	defer h.peoplemu.Unlock()
+	if h.peopleCache != nil {
+		return h.peopleCache, nil
+	}
+	expr := fmt.Sprintf("attr:%s:%s", attrNodeType, nodeTypePeopleRoot)
+	res, err := h.search.Query(&search.SearchQuery{
+		Limit:      10, // only expect 1
+		Expression: expr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Blobs) > 1 {
+		return nil, fmt.Errorf("Ambiguous; too many permanodes matched query %q: %v", expr, res.Blobs)
+	}
+	if len(res.Blobs) == 1 {
+		o, err := h.ObjectFromRef(res.Blobs[0].Blob)
+		if err != nil {
+			return nil, err
+		}
+		h.peopleCache = o
+		return o, nil
+	}
+	o, err := h.NewObject()
+	if err != nil {
+		return nil, err
+	}
+	if err := o.SetAttrs(
+		"title", "People",
+		attrNodeType, nodeTypePeopleRoot,
+	); err != nil {
+		return nil, err
+	}
+	h.peopleCache = o
+	return o, nil
+}
+
+// findPerson returns the existing person permanode for the given
+// email or service-qualified user ID, or nil if there is none yet.
+func (h *Host) findPerson(root *Object, email, serviceID string) (*Object, error) {
+	if v := root.Attr("camliPath:" + serviceID); v != "" {
+		if br, ok := blob.Parse(v); ok {
+			return h.ObjectFromRef(br)
+		}
+	}
+	if email == "" {
+		return nil, nil
+	}
+	res, err := h.search.Query(&search.SearchQuery{
+		Limit: 10,
+		Expression: fmt.Sprintf("attr:%s:%s attr:%s:%s",
+			attrNodeType, nodeTypePerson,
+			attrEmail, email,
+		),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Blobs) == 0 {
+		return nil, nil
+	}
+	return h.ObjectFromRef(res.Blobs[0].Blob)
+}
+
+// importContacts runs the contacts pass for the account: it fetches
+// the service's contact list through f and upserts a person permanode
+// for each entry. Individual contact failures are recorded as skips
+// and don't abort the pass.
+func (rc *RunContext) importContacts(f ContactsFetcher) error {
+	contacts, err := f.FetchContacts(rc)
+	if err != nil {
+		return fmt.Errorf("fetching contacts: %v", err)
+	}
+	root, err := rc.Host.PeopleRoot()
+	if err != nil {
+		return err
+	}
+	importerLog.Printf("%v: importing %d contacts", rc.ia, len(contacts))
+	for _, c := range contacts {
+		if rc.Context.IsCanceled() {
+			return context.ErrCanceled
+		}
+		if err := rc.importContact(root, c); err != nil {
+			importerLog.Printf("%v: error importing contact %q: %v", rc.ia, c.ID, err)
+			rc.Skip(rc.ia.im.name+":"+c.ID, SkipReasonError)
+		}
+	}
+	return nil
+}
+
+// importContact creates or updates the person permanode for one
+// contact. People are deduplicated by email when the service reports
+// one, and otherwise by the service-qualified user ID. Name, profile
+// URL, and avatar changes on the service are applied on re-runs.
+func (rc *RunContext) importContact(root *Object, c *Contact) error {
+	if c.ID == "" {
+		return errors.New("contact has no ID")
+	}
+	h := rc.Host
+	sid := rc.ia.im.name + ":" + c.ID
+	person, err := h.findPerson(root, c.Email, sid)
+	if err != nil {
+		return err
+	}
+	if person == nil {
+		person, err = h.NewObject()
+		if err != nil {
+			return err
+		}
+		if err := person.SetAttr(attrNodeType, nodeTypePerson); err != nil {
+			return err
+		}
+	}
+	if ids := person.Attrs(attrServiceID); !inStrings(ids, sid) {
+		if err := person.SetAttrValues(attrServiceID, append(ids, sid)); err != nil {
+			return err
+		}
+	}
+	var attrs []string
+	if c.Name != "" {
+		attrs = append(attrs, AttrName, c.Name, "title", c.Name)
+	}
+	if c.Email != "" {
+		attrs = append(attrs, attrEmail, c.Email)
+	}
+	if c.ProfileURL != "" {
+		attrs = append(attrs, AttrURL, c.ProfileURL)
+	}
+	if len(attrs) > 0 {
+		if err := person.SetAttrs(attrs...); err != nil {
+			return err
+		}
+	}
+	if err := rc.importContactAvatar(person, c); err != nil {
+		return err
+	}
+	// File the person under the People root, once per service ID.
+	return root.SetAttr("camliPath:"+sid, person.PermanodeRef().String())
+}
+
+// importContactAvatar fetches the contact's avatar image, if any, and
+// stores it as the person's camliContentImage. The avatar's source
+// URL is recorded so re-runs only refetch when it changes.
+func (rc *RunContext) importContactAvatar(person *Object, c *Contact) error {
+	if c.AvatarURL == "" || person.Attr(AttrSourceDownloadURL) == c.AvatarURL {
+		return nil
+	}
+	res, err := rc.HTTPClient().Get(c.AvatarURL)
+	if err != nil {
+		return fmt.Errorf("fetching avatar %s: %v", c.AvatarURL, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		// The service reports an avatar URL even for the
+		// default icon on some services; no avatar, no error.
+		return nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP status %s fetching avatar %s", res.Status, c.AvatarURL)
+	}
+	fileRef, err := schema.WriteFileFromReader(rc.Host.Target(), path.Base(c.AvatarURL), res.Body)
+	if err != nil {
+		return fmt.Errorf("writing avatar %s: %v", c.AvatarURL, err)
+	}
+	return person.SetAttrs(
+		attrContentImage, fileRef.String(),
+		AttrSourceDownloadURL, c.AvatarURL,
+	)
+}
+
+func inStrings(ss []string, v string) bool {
+	for _, s := range ss {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ContactsSupported reports whether the account's importer can fetch
+// the service's contact list.
+func (ia *importerAcct) ContactsSupported() bool {
+	_, ok := ia.im.impl.(ContactsFetcher)
+	return ok
+}
+
+// ContactsEnabled reports whether the contacts pass is enabled for
+// the account.
+func (ia *importerAcct) ContactsEnabled() bool {
+	return ia.acct.Attr(attrImportContacts) == "true"
+}
+
+func (ia *importerAcct) toggleContacts() error {
+	var new string
+	if !ia.ContactsEnabled() {
+		new = "true"
+	}
+	return ia.acct.SetAttrs(attrImportContacts, new)
+}
@@ -0,0 +1,506 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dropbox implements an importer that mirrors a Dropbox
+// folder into permanodes, preserving the folder structure as
+// permanodes with camliPath attributes. Deleted files are recorded
+// with an attribute instead of being removed, so the archive keeps
+// everything that ever appeared in the folder.
+package dropbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/context"
+	"camlistore.org/pkg/httputil"
+	"camlistore.org/pkg/importer"
+	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/schema/nodeattr"
+
+	"camlistore.org/third_party/code.google.com/p/goauth2/oauth"
+)
+
+const (
+	apiURL     = "https://api.dropbox.com/1/"
+	contentURL = "https://api-content.dropbox.com/1/files/auto"
+	authURL    = "https://www.dropbox.com/1/oauth2/authorize"
+	tokenURL   = "https://api.dropbox.com/1/oauth2/token"
+
+	// Permanode attributes on the account node:
+
+	// acctAttrFolder is the Dropbox path of the folder to mirror,
+	// e.g. "/Shared/Scans". "/" means the whole Dropbox.
+	acctAttrFolder = "dropboxFolder"
+	// acctAttrCursor is the delta API cursor as of the last
+	// successfully imported page, so subsequent runs only see
+	// changes since then.
+	acctAttrCursor = "dropboxCursor"
+
+	// Permanode attributes on file and folder nodes:
+
+	// attrDropboxPath is the original-case Dropbox path of the
+	// entry. The camliPath attributes use Dropbox's lowercased
+	// paths, since that's all the delta API reports for deletes.
+	attrDropboxPath = "dropboxPath"
+	// attrDropboxRev is the Dropbox revision of the file contents
+	// last imported, used to skip unchanged files.
+	attrDropboxRev = "dropboxRev"
+	// attrDropboxDeleted is the RFC 3339 time at which the
+	// importer noticed the entry had been deleted from Dropbox.
+	// The permanode and its contents are kept.
+	attrDropboxDeleted = "dropboxDeleted"
+)
+
+func init() {
+	importer.Register("dropbox", newImporter())
+}
+
+var _ importer.ImporterSetupHTMLer = (*imp)(nil)
+
+type imp struct {
+	extendedOAuth2
+}
+
+var baseOAuthConfig = oauth.Config{
+	AuthURL:  authURL,
+	TokenURL: tokenURL,
+}
+
+func newImporter() *imp {
+	return &imp{
+		newExtendedOAuth2(baseOAuthConfig, getUserInfo),
+	}
+}
+
+func (*imp) AccountSetupHTML(host *importer.Host) string {
+	callback := host.ImporterBaseURL() + "dropbox/callback"
+	return fmt.Sprintf(`
+<h1>Configuring Dropbox</h1>
+<p>Visit <a href='https://www.dropbox.com/developers/apps'>https://www.dropbox.com/developers/apps</a>
+and click <b>"Create app"</b>. Choose the <b>Dropbox API</b> with <b>Full Dropbox</b> access
+(or <b>App folder</b> if you only want to mirror that folder).</p>
+<p>Add the following OAuth 2 Redirect URI: <b>%s</b></p>
+<p>Copy the "App key" and "App secret" into the boxes above.</p>
+`, callback)
+}
+
+// ServeSetup asks for the folder to mirror before redirecting to
+// Dropbox for authorization.
+func (im *imp) ServeSetup(w http.ResponseWriter, r *http.Request, ctx *importer.SetupContext) error {
+	if folder := r.FormValue("folder"); folder != "" {
+		if err := ctx.AccountNode.SetAttr(acctAttrFolder, cleanFolder(folder)); err != nil {
+			return err
+		}
+		return im.extendedOAuth2.ServeSetup(w, r, ctx)
+	}
+	return tmpl.ExecuteTemplate(w, "serveSetup", struct {
+		*importer.SetupContext
+		Folder string
+	}{ctx, ctx.AccountNode.Attr(acctAttrFolder)})
+}
+
+var tmpl = template.Must(template.New("root").Parse(`
+{{define "serveSetup"}}
+<h1>Configuring Dropbox account</h1>
+<form method="post" action="{{.AccountURL}}">
+  <input type="hidden" name="mode" value="login">
+  <table border=0 cellpadding=3>
+  <tr><td align=right>Folder to mirror</td><td><input name="folder" size=50 value="{{.Folder}}" placeholder="/"></td></tr>
+  <tr><td align=right></td><td><input type="submit" value="Continue to Dropbox"></td></tr>
+  </table>
+</form>
+{{end}}
+`))
+
+// cleanFolder normalizes a user-entered Dropbox folder path to have a
+// leading slash and no trailing slash. The root folder is "/".
+func cleanFolder(folder string) string {
+	return path.Clean("/" + strings.Trim(folder, "/"))
+}
+
+// A run is our state for a given run of the importer.
+type run struct {
+	*importer.RunContext
+	im     *imp
+	folder string // cleanFolder'd Dropbox path being mirrored
+
+	mu     sync.Mutex // guards anyErr
+	anyErr bool
+}
+
+func (r *run) errorf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.anyErr = true
+}
+
+func (r *run) sawError() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.anyErr
+}
+
+func (im *imp) Run(ctx *importer.RunContext) error {
+	clientId, secret, err := ctx.Credentials()
+	if err != nil {
+		return err
+	}
+	acctNode := ctx.AccountNode()
+	ocfg := baseOAuthConfig
+	ocfg.ClientId, ocfg.ClientSecret = clientId, secret
+	token := decodeToken(acctNode.Attr(acctAttrOAuthToken))
+	transport := &oauth.Transport{
+		Config:    &ocfg,
+		Token:     &token,
+		Transport: notOAuthTransport(ctx.HTTPClient()),
+	}
+	ctx.Context = ctx.Context.New(context.WithHTTPClient(transport.Client()))
+	r := &run{
+		RunContext: ctx,
+		im:         im,
+		folder:     cleanFolder(acctNode.Attr(acctAttrFolder)),
+	}
+	rootNode := r.RootNode()
+	if rootNode.Attr(nodeattr.Title) == "" {
+		if err := rootNode.SetAttr(nodeattr.Title, fmt.Sprintf("Dropbox %s", r.folder)); err != nil {
+			return err
+		}
+	}
+	return r.importDeltas()
+}
+
+// delta is a page of changes from the Dropbox /delta API.
+type delta struct {
+	Entries []deltaEntry `json:"entries"`
+	Reset   bool         `json:"reset"`
+	Cursor  string       `json:"cursor"`
+	HasMore bool         `json:"has_more"`
+}
+
+// deltaEntry is one changed path. Dropbox encodes it as a two-element
+// array [path_lower, metadata], where metadata is null if the path
+// was deleted.
+type deltaEntry struct {
+	PathLower string
+	Meta      *fileMeta
+}
+
+func (e *deltaEntry) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw) != 2 {
+		return fmt.Errorf("dropbox: delta entry has %d elements; want 2", len(raw))
+	}
+	if err := json.Unmarshal(raw[0], &e.PathLower); err != nil {
+		return err
+	}
+	return json.Unmarshal(raw[1], &e.Meta)
+}
+
+// fileMeta is the subset of Dropbox file metadata we use.
+type fileMeta struct {
+	Path     string `json:"path"` // original-case path
+	IsDir    bool   `json:"is_dir"`
+	Bytes    int64  `json:"bytes"`
+	Modified string `json:"modified"` // RFC 1123Z
+	Rev      string `json:"rev"`
+	MimeType string `json:"mime_type"`
+}
+
+func (r *run) importDeltas() error {
+	acctNode := r.AccountNode()
+	cursor := acctNode.Attr(acctAttrCursor)
+	for {
+		if r.Context.IsCanceled() {
+			return context.ErrCanceled
+		}
+		page, err := r.getDelta(cursor)
+		if err != nil {
+			return err
+		}
+		if page.Reset {
+			// Dropbox invalidated our cursor. Entries will describe
+			// the whole folder again; re-importing is idempotent
+			// since nodes are keyed by path.
+			log.Printf("dropbox: cursor was reset; running a full import of %s", r.folder)
+		}
+		for _, ent := range page.Entries {
+			if r.Context.IsCanceled() {
+				return context.ErrCanceled
+			}
+			if err := r.importEntry(ent); err != nil {
+				r.errorf("dropbox: error importing %s: %v", ent.PathLower, err)
+			}
+		}
+		cursor = page.Cursor
+		// Save the cursor after each clean page, so an interrupted
+		// run resumes where it left off. Once an entry has failed,
+		// stop advancing the saved cursor: the next run will retry
+		// from the last fully imported page.
+		if !r.sawError() {
+			if err := acctNode.SetAttr(acctAttrCursor, cursor); err != nil {
+				return err
+			}
+		}
+		if !page.HasMore {
+			break
+		}
+	}
+	return nil
+}
+
+func (r *run) getDelta(cursor string) (*delta, error) {
+	form := url.Values{}
+	if cursor != "" {
+		form.Set("cursor", cursor)
+	}
+	if r.folder != "/" {
+		form.Set("path_prefix", r.folder)
+	}
+	res, err := r.HTTPClient().PostForm(apiURL+"delta", form)
+	if err != nil {
+		return nil, err
+	}
+	defer httputil.CloseBody(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dropbox: delta request failed with: %s", res.Status)
+	}
+	page := new(delta)
+	if err := json.NewDecoder(res.Body).Decode(page); err != nil {
+		return nil, fmt.Errorf("dropbox: decoding delta response: %v", err)
+	}
+	return page, nil
+}
+
+func (r *run) importEntry(ent deltaEntry) error {
+	segments := r.pathSegments(ent.PathLower)
+	if segments == nil {
+		// The mirrored folder itself, or a path outside it.
+		return nil
+	}
+	if ent.Meta == nil {
+		return r.markDeleted(ent.PathLower, segments)
+	}
+	node, err := r.nodeForPath(segments)
+	if err != nil {
+		return err
+	}
+	if ent.Meta.IsDir {
+		return r.importFolder(node, ent.Meta)
+	}
+	return r.importFile(node, ent.PathLower, ent.Meta)
+}
+
+// pathSegments returns the lowercased path elements of pathLower
+// below the mirrored folder, or nil if pathLower is the folder itself
+// or not under it.
+func (r *run) pathSegments(pathLower string) []string {
+	rel := pathLower
+	if r.folder != "/" {
+		rel = strings.TrimPrefix(pathLower, strings.ToLower(r.folder))
+		if rel == pathLower {
+			return nil
+		}
+	}
+	rel = strings.Trim(rel, "/")
+	if rel == "" {
+		return nil
+	}
+	return strings.Split(rel, "/")
+}
+
+// nodeForPath returns (creating if necessary) the permanode for the
+// given path segments below the importer's root node, going through
+// one camliPath hop per segment.
+func (r *run) nodeForPath(segments []string) (*importer.Object, error) {
+	node := r.RootNode()
+	for _, seg := range segments {
+		var err error
+		node, err = node.ChildPathObject(seg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// lookupNodeForPath is like nodeForPath but returns (nil, nil) as
+// soon as a segment has no existing child, instead of creating one.
+func (r *run) lookupNodeForPath(segments []string) (*importer.Object, error) {
+	node := r.RootNode()
+	for _, seg := range segments {
+		ref, ok := blob.Parse(node.Attr("camliPath:" + seg))
+		if !ok {
+			return nil, nil
+		}
+		var err error
+		node, err = r.Host.ObjectFromRef(ref)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// markDeleted records on the node for pathLower that Dropbox no
+// longer has the entry. The permanode, its contents, and its place in
+// the camliPath tree are all kept.
+func (r *run) markDeleted(pathLower string, segments []string) error {
+	node, err := r.lookupNodeForPath(segments)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		// Never imported; nothing to mark.
+		return nil
+	}
+	if node.Attr(attrDropboxDeleted) != "" {
+		return nil
+	}
+	log.Printf("dropbox: %s was deleted remotely; keeping its permanode", pathLower)
+	return node.SetAttr(attrDropboxDeleted, schema.RFC3339FromTime(time.Now()))
+}
+
+func (r *run) importFolder(node *importer.Object, meta *fileMeta) error {
+	if err := node.SetAttrs(
+		nodeattr.Type, "dropbox.com:folder",
+		nodeattr.Title, path.Base(meta.Path),
+		attrDropboxPath, meta.Path,
+	); err != nil {
+		return err
+	}
+	return r.clearDeleted(node)
+}
+
+func (r *run) importFile(node *importer.Object, pathLower string, meta *fileMeta) error {
+	if rev := node.Attr(attrDropboxRev); rev != "" && rev == meta.Rev {
+		r.Skip(pathLower, importer.SkipReasonUnchanged)
+		return r.clearDeleted(node)
+	}
+	body, status, err := r.download(pathLower, meta.Rev)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusForbidden {
+		// Typically a shared-folder member whose files we may
+		// list but not read.
+		r.Skip(pathLower, importer.SkipReasonNoPermission)
+		return nil
+	}
+	defer body.Close()
+	var modTime time.Time
+	if t, err := time.Parse(time.RFC1123Z, meta.Modified); err == nil {
+		modTime = t
+	}
+	fileRef, err := schema.WriteFileFromReaderWithOpts(r.Host.Target(), path.Base(meta.Path), body,
+		schema.WriteFileOptions{
+			ModTime:  modTime,
+			MIMEType: meta.MimeType,
+		})
+	if err != nil {
+		return fmt.Errorf("error writing file: %v", err)
+	}
+	if err := node.SetAttrs(
+		nodeattr.CamliContent, fileRef.String(),
+		nodeattr.Title, path.Base(meta.Path),
+		attrDropboxPath, meta.Path,
+		attrDropboxRev, meta.Rev,
+		"dateModified", schema.RFC3339FromTime(modTime),
+	); err != nil {
+		return fmt.Errorf("error setting file attributes: %v", err)
+	}
+	return r.clearDeleted(node)
+}
+
+// clearDeleted removes the deleted marker from node, for entries that
+// reappear after a deletion.
+func (r *run) clearDeleted(node *importer.Object) error {
+	if node.Attr(attrDropboxDeleted) == "" {
+		return nil
+	}
+	return node.SetAttrValues(attrDropboxDeleted, nil)
+}
+
+// download fetches the contents of the file at pathLower, pinned to
+// rev. The caller must close the body unless a non-nil error or a
+// non-200 status is returned. The body streams directly from
+// Dropbox, so large files never need to fit in memory.
+func (r *run) download(pathLower, rev string) (body io.ReadCloser, status int, err error) {
+	u, err := url.Parse(contentURL)
+	if err != nil {
+		return nil, 0, err
+	}
+	u.Path += pathLower
+	u.RawQuery = "rev=" + url.QueryEscape(rev)
+	res, err := r.HTTPClient().Get(u.String())
+	if err != nil {
+		return nil, 0, err
+	}
+	if res.StatusCode == http.StatusForbidden {
+		httputil.CloseBody(res.Body)
+		return nil, res.StatusCode, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		httputil.CloseBody(res.Body)
+		return nil, res.StatusCode, fmt.Errorf("dropbox: file download of %s failed with: %s", pathLower, res.Status)
+	}
+	return res.Body, res.StatusCode, nil
+}
+
+// accountInfo is the subset of the Dropbox /account/info response we use.
+type accountInfo struct {
+	UID         int64  `json:"uid"`
+	DisplayName string `json:"display_name"`
+}
+
+func getUserInfo(ctx *context.Context) (*userInfo, error) {
+	res, err := ctx.HTTPClient().Get(apiURL + "account/info")
+	if err != nil {
+		return nil, err
+	}
+	defer httputil.CloseBody(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dropbox: account/info request failed with: %s", res.Status)
+	}
+	var ai accountInfo
+	if err := json.NewDecoder(res.Body).Decode(&ai); err != nil {
+		return nil, err
+	}
+	firstName, lastName := ai.DisplayName, ""
+	if i := strings.LastIndex(ai.DisplayName, " "); i >= 0 {
+		firstName, lastName = ai.DisplayName[:i], ai.DisplayName[i+1:]
+	}
+	return &userInfo{
+		ID:        strconv.FormatInt(ai.UID, 10),
+		FirstName: firstName,
+		LastName:  lastName,
+	}, nil
+}
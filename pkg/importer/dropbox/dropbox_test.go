@@ -0,0 +1,81 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dropbox
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCleanFolder(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"Scans", "/Scans"},
+		{"/Scans/", "/Scans"},
+		{"Shared/Scans", "/Shared/Scans"},
+	}
+	for _, tt := range tests {
+		if got := cleanFolder(tt.in); got != tt.want {
+			t.Errorf("cleanFolder(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPathSegments(t *testing.T) {
+	tests := []struct {
+		folder, path string
+		want         []string
+	}{
+		{"/", "/a/b.txt", []string{"a", "b.txt"}},
+		{"/scans", "/scans", nil},
+		{"/scans", "/scans/a/b.txt", []string{"a", "b.txt"}},
+		{"/scans", "/other/a.txt", nil},
+	}
+	for _, tt := range tests {
+		r := &run{folder: tt.folder}
+		if got := r.pathSegments(tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("pathSegments(%q) with folder %q = %q; want %q", tt.path, tt.folder, got, tt.want)
+		}
+	}
+}
+
+func TestDeltaEntryUnmarshal(t *testing.T) {
+	const page = `{"entries": [
+		["/scans/a.pdf", {"path": "/Scans/A.pdf", "is_dir": false, "bytes": 4, "rev": "abc123", "mime_type": "application/pdf"}],
+		["/scans/gone.pdf", null]
+	], "reset": true, "cursor": "cur1", "has_more": false}`
+	var d delta
+	if err := json.Unmarshal([]byte(page), &d); err != nil {
+		t.Fatal(err)
+	}
+	if !d.Reset || d.Cursor != "cur1" || d.HasMore {
+		t.Errorf("got reset=%v cursor=%q has_more=%v; want true, \"cur1\", false", d.Reset, d.Cursor, d.HasMore)
+	}
+	if len(d.Entries) != 2 {
+		t.Fatalf("got %d entries; want 2", len(d.Entries))
+	}
+	if e := d.Entries[0]; e.PathLower != "/scans/a.pdf" || e.Meta == nil || e.Meta.Path != "/Scans/A.pdf" || e.Meta.Rev != "abc123" {
+		t.Errorf("unexpected first entry: %+v", e)
+	}
+	if e := d.Entries[1]; e.PathLower != "/scans/gone.pdf" || e.Meta != nil {
+		t.Errorf("second entry should be a deletion; got %+v", e)
+	}
+}
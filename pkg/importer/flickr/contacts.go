@@ -0,0 +1,112 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package flickr
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"camlistore.org/pkg/importer"
+	"camlistore.org/third_party/github.com/garyburd/go-oauth/oauth"
+)
+
+var _ importer.ContactsFetcher = imp{}
+
+type contactsGetList struct {
+	Contacts struct {
+		Page    int
+		Pages   int
+		Contact []*contactsGetListItem
+	}
+}
+
+type contactsGetListItem struct {
+	NSID       string `json:"nsid"`
+	Username   string `json:"username"`
+	RealName   string `json:"realname"`
+	IconServer string `json:"iconserver"`
+	IconFarm   int    `json:"iconfarm"`
+}
+
+// FetchContacts implements importer.ContactsFetcher, returning the
+// account's contact list.
+func (im imp) FetchContacts(ctx *importer.RunContext) ([]*importer.Contact, error) {
+	octx, err := im.acctOAuthContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var contacts []*importer.Contact
+	for page, pages := 1, 1; page <= pages; page++ {
+		resp := contactsGetList{}
+		if err := octx.flickrAPIRequest(&resp, "flickr.contacts.getList",
+			"page", strconv.Itoa(page)); err != nil {
+			return nil, err
+		}
+		if resp.Contacts.Pages > 0 {
+			pages = resp.Contacts.Pages
+		}
+		for _, c := range resp.Contacts.Contact {
+			var avatar string
+			// An iconserver of "0" means the default buddy
+			// icon; no avatar to fetch then.
+			if c.IconServer != "" && c.IconServer != "0" {
+				avatar = fmt.Sprintf("https://farm%d.staticflickr.com/%s/buddyicons/%s.jpg",
+					c.IconFarm, c.IconServer, c.NSID)
+			}
+			contacts = append(contacts, &importer.Contact{
+				ID:         c.NSID,
+				Name:       c.RealName,
+				UserName:   c.Username,
+				ProfileURL: "https://www.flickr.com/people/" + c.NSID + "/",
+				AvatarURL:  avatar,
+			})
+		}
+	}
+	return contacts, nil
+}
+
+// acctOAuthContext builds an oauthContext from the account node's
+// stored credentials, for API calls made outside a run.
+func (im imp) acctOAuthContext(ctx *importer.RunContext) (oauthContext, error) {
+	clientID, secret, err := ctx.Credentials()
+	if err != nil {
+		return oauthContext{}, fmt.Errorf("no API credentials: %v", err)
+	}
+	accountNode := ctx.AccountNode()
+	accessToken := accountNode.Attr(importer.AcctAttrAccessToken)
+	accessSecret := accountNode.Attr(importer.AcctAttrAccessTokenSecret)
+	if accessToken == "" || accessSecret == "" {
+		return oauthContext{}, errors.New("access credentials not found")
+	}
+	return oauthContext{
+		Context: ctx.Context,
+		client: &oauth.Client{
+			TemporaryCredentialRequestURI: temporaryCredentialRequestURL,
+			ResourceOwnerAuthorizationURI: resourceOwnerAuthorizationURL,
+			TokenRequestURI:               tokenRequestURL,
+			Credentials: oauth.Credentials{
+				Token:  clientID,
+				Secret: secret,
+			},
+		},
+		creds: &oauth.Credentials{
+			Token:  accessToken,
+			Secret: accessSecret,
+		},
+	}, nil
+}
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -110,6 +111,7 @@ func (imp) Run(ctx *importer.RunContext) error {
 	if userID == "" {
 		return errors.New("UserID hasn't been set by account setup.")
 	}
+	ctx.Context = ctx.Context.New(context.WithHTTPClient(ctx.LimitedHTTPClient()))
 	r := &run{
 		userID:     userID,
 		RunContext: ctx,
@@ -346,6 +348,7 @@ func (r *run) importPhoto(parent *importer.Object, photo *photosSearchItem) erro
 		attrFlickrId, photo.ID,
 		nodeattr.DateCreated, schema.RFC3339FromTime(dateTaken),
 		nodeattr.Description, photo.Description.Content,
+		nodeattr.URL, fmt.Sprintf("https://www.flickr.com/photos/%s/%s/", r.userID, photo.ID),
 	}
 	if schema.IsInterestingTitle(photo.Title) {
 		attrs = append(attrs, nodeattr.Title, photo.Title)
@@ -381,11 +384,20 @@ func (r *run) importPhoto(parent *importer.Object, photo *photosSearchItem) erro
 	}
 	defer res.Body.Close()
 
-	fileRef, err := schema.WriteFileFromReader(r.Host.Target(), filename, res.Body)
+	fileRef, err := schema.WriteFileFromReaderWithOpts(r.Host.Target(), filename, res.Body,
+		schema.WriteFileOptions{
+			ModTime:  lastUpdate,
+			MIMEType: mime.TypeByExtension("." + photo.OriginalFormat),
+		})
 	if err != nil {
 		return err
 	}
-	if err := photoNode.SetAttr("camliContent", fileRef.String()); err != nil {
+	if err := photoNode.SetAttrs(
+		nodeattr.CamliContent, fileRef.String(),
+		// Record which URL the bytes actually came from; Flickr
+		// reports no checksum to verify them against.
+		importer.AttrSourceDownloadURL, photo.URL,
+	); err != nil {
 		return err
 	}
 	// Write lastupdate last, so that if any of the preceding fails, we will try again next time.
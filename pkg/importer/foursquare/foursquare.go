@@ -133,6 +133,7 @@ func (r *run) token() string {
 }
 
 func (im *imp) Run(ctx *importer.RunContext) error {
+	ctx.Context = ctx.Context.New(context.WithHTTPClient(ctx.LimitedHTTPClient()))
 	r := &run{
 		RunContext:  ctx,
 		im:          im,
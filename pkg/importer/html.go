@@ -72,6 +72,19 @@ type acctBody struct {
 	StartedAgo time.Duration // or zero if !Running
 	LastAgo    time.Duration // non-zero if previous run && !Running
 	LastError  string
+	Skips      []skipSummary // skips of the current (if Running) or previous run
+
+	// NewHookToken is a freshly generated webhook token, shown only
+	// on the page following its generation; only its hash is stored.
+	NewHookToken string
+}
+
+// skipSummary is the UI summary of a run's skipped items for one
+// reason category, as recorded with RunContext.Skip.
+type skipSummary struct {
+	Reason string
+	Count  int
+	Items  []string // at most maxSkipsPerReason item IDs
 }
 
 var tmpl = template.Must(template.New("root").Funcs(map[string]interface{}{
@@ -168,6 +181,8 @@ var tmpl = template.Must(template.New("root").Funcs(map[string]interface{}{
    <li>Configured: {{.Acct.IsAccountReady}}</li>
    <li>Summary: {{.Acct.AccountLinkSummary}}</li>
    <li>Import interval: {{if .Acct.RefreshInterval}}{{.Acct.RefreshInterval}}{{else}}(manual){{end}}</li>
+   <li>Webhook: {{if .Acct.HookEnabled}}enabled; POST token to {{.Acct.HookURL}}{{else}}(disabled){{end}}</li>
+   <li>Contacts import: {{if .Acct.ContactsSupported}}{{if .Acct.ContactsEnabled}}enabled{{else}}disabled{{end}}{{else}}(not supported){{end}}</li>
    <li>Running: {{.Running}}</li>
    {{if .Running}}
      <li>Started: {{.StartedAgo}} ago</li>
@@ -177,6 +192,17 @@ var tmpl = template.Must(template.New("root").Funcs(map[string]interface{}{
         <li>Previous run: {{.LastAgo}} ago{{if .LastError}}: {{.LastError}}{{else}} (success){{end}}</li>
      {{end}}
    {{end}}
+   {{if .Skips}}
+     <li>Skipped items:
+       <ul>
+         {{range .Skips}}
+           <li><details><summary>{{.Reason}}: {{.Count}}</summary>
+             <ul>{{range .Items}}<li>{{.}}</li>{{end}}</ul>
+           </details></li>
+         {{end}}
+       </ul>
+     </li>
+   {{end}}
 </ul>
 
 {{if .Acct.IsAccountReady}}
@@ -201,11 +227,28 @@ var tmpl = template.Must(template.New("root").Funcs(map[string]interface{}{
 <input type='submit' value='Toggle auto'>
 </form>
 
+<form method='post' style='display: inline'>
+<input type='hidden' name='mode' value='newhooktoken'>
+<input type='submit' value='New webhook token'>
+</form>
+
+{{if .Acct.ContactsSupported}}
+<form method='post' style='display: inline'>
+<input type='hidden' name='mode' value='togglecontacts'>
+<input type='submit' value='Toggle contacts'>
+</form>
+{{end}}
+
 <form method='post' style='display: inline'>
 <input type='hidden' name='mode' value='delete'>
 <input type='submit' value='Delete Account' onclick='return confirm("Delete account?")'>
 </form>
 
+{{if .NewHookToken}}
+<p>New webhook token (shown only once): <code>{{.NewHookToken}}</code><br>
+Have the external service POST to <code>{{.Acct.HookURL}}?token={{.NewHookToken}}</code> to queue an import run.</p>
+{{end}}
+
 {{end}}
 
 `))
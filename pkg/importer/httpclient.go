@@ -0,0 +1,240 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package importer
+
+import (
+	"expvar"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for an importer's outbound HTTP policy; see HTTPLimit.
+const (
+	defaultHTTPTries   = 3
+	defaultHTTPTimeout = 5 * time.Minute
+)
+
+// backoffBase is the pause before the first retry of a failed
+// request. Each further retry doubles it, unless the server's
+// Retry-After header asks for more. It's a variable only for tests.
+var backoffBase = 1 * time.Second
+
+// httpStats counts outbound HTTP activity per importer, with keys
+// like "picasa-requests", "picasa-retries", and "picasa-failures".
+var httpStats = expvar.NewMap("importer-http")
+
+// HTTPLimit is an importer's outbound HTTP policy, as used by
+// RunContext.LimitedHTTPClient. It can be set per importer in the
+// importer's section of the handler config, e.g.:
+//
+//   "picasa": {
+//       "clientSecret": "clientId:clientSecret",
+//       "maxQPS": 5,
+//       "maxTries": 5,
+//       "requestTimeout": 60
+//   }
+type HTTPLimit struct {
+	// QPS is the max sustained number of requests per second to
+	// the remote service. Zero means unlimited.
+	QPS int
+
+	// Burst is the capacity of the token bucket: how many requests
+	// may be sent back to back before the QPS limit kicks in.
+	// Zero means QPS.
+	Burst int
+
+	// MaxTries is the number of attempts per request. Responses
+	// with a 429 or 5xx status, and transport errors on requests
+	// without a body, are retried with exponential backoff,
+	// honoring the server's Retry-After header. Zero means a
+	// default of 3.
+	MaxTries int
+
+	// Timeout bounds each request, including its retries. Zero
+	// means a default of 5 minutes.
+	Timeout time.Duration
+}
+
+func (l HTTPLimit) maxTries() int {
+	if l.MaxTries > 0 {
+		return l.MaxTries
+	}
+	return defaultHTTPTries
+}
+
+func (l HTTPLimit) timeout() time.Duration {
+	if l.Timeout > 0 {
+		return l.Timeout
+	}
+	return defaultHTTPTimeout
+}
+
+// LimitedHTTPClient returns a client like rc.HTTPClient(), but
+// applying the importer's configured HTTPLimit: rate limiting,
+// retries, and a per-request timeout. URLs starting with one of the
+// limitFree prefixes skip the rate limiter (but are still retried);
+// importers doing OAuth should pass their token endpoint here so
+// token refreshes aren't queued behind the requests that need them.
+func (rc *RunContext) LimitedHTTPClient(limitFree ...string) *http.Client {
+	return &http.Client{
+		Transport: rc.LimitedTransport(nil, limitFree...),
+		Timeout:   rc.ia.im.httpLimit.timeout(),
+	}
+}
+
+// LimitedTransport is like LimitedHTTPClient, for importers that need
+// to place the policy under a transport of their own (typically an
+// OAuth signing transport). A nil base means the transport of
+// rc.HTTPClient().
+func (rc *RunContext) LimitedTransport(base http.RoundTripper, limitFree ...string) http.RoundTripper {
+	im := rc.ia.im
+	if base == nil {
+		base = rc.HTTPClient().Transport
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &limitTransport{
+		base:      base,
+		bucket:    im.tokenBucket(),
+		limitFree: limitFree,
+		maxTries:  im.httpLimit.maxTries(),
+		timeout:   im.httpLimit.timeout(),
+		name:      im.name,
+	}
+}
+
+// tokenBucket returns the importer's rate limiter, shared by all its
+// runs and created on first use, or nil if the importer has no QPS
+// limit configured.
+func (im *importer) tokenBucket() *tokenBucket {
+	im.bucketmu.Lock()
+	defer im.bucketmu.Unlock()
+	if im.bucket == nil && im.httpLimit.QPS > 0 {
+		burst := im.httpLimit.Burst
+		if burst <= 0 {
+			burst = im.httpLimit.QPS
+		}
+		im.bucket = newTokenBucket(im.httpLimit.QPS, burst)
+	}
+	return im.bucket
+}
+
+// limitTransport applies an HTTPLimit around a base RoundTripper.
+type limitTransport struct {
+	base      http.RoundTripper
+	bucket    *tokenBucket // or nil for no rate limiting
+	limitFree []string     // URL prefixes exempt from bucket
+	maxTries  int
+	timeout   time.Duration
+	name      string // importer name, for stats
+}
+
+// requestCanceler is implemented by http.Transport; see
+// limitTransport.RoundTrip.
+type requestCanceler interface {
+	CancelRequest(*http.Request)
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	limited := t.bucket != nil
+	for _, pfx := range t.limitFree {
+		if strings.HasPrefix(req.URL.String(), pfx) {
+			limited = false
+			break
+		}
+	}
+	deadline := time.Now().Add(t.timeout)
+	canceler, canCancel := t.base.(requestCanceler)
+	for try := 1; ; try++ {
+		if limited {
+			t.bucket.wait()
+		}
+		httpStats.Add(t.name+"-requests", 1)
+		if canCancel {
+			timer := time.AfterFunc(deadline.Sub(time.Now()), func() { canceler.CancelRequest(req) })
+			res, err = t.base.RoundTrip(req)
+			timer.Stop()
+		} else {
+			res, err = t.base.RoundTrip(req)
+		}
+		if err == nil && res.StatusCode != 429 && res.StatusCode < 500 {
+			return res, nil
+		}
+		pause := backoffBase << uint(try-1)
+		if err == nil {
+			if ra := retryAfter(res); ra > pause {
+				pause = ra
+			}
+		}
+		// Only requests without a body can be replayed.
+		if req.Body != nil || try >= t.maxTries || time.Now().Add(pause).After(deadline) {
+			if err != nil {
+				httpStats.Add(t.name+"-failures", 1)
+			}
+			return res, err
+		}
+		if err == nil {
+			res.Body.Close()
+		}
+		httpStats.Add(t.name+"-retries", 1)
+		time.Sleep(pause)
+	}
+}
+
+// retryAfter returns the wait the server requested with a Retry-After
+// header (either seconds or an HTTP date), or zero.
+func retryAfter(res *http.Response) time.Duration {
+	v := res.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(v); err == nil {
+		return time.Duration(sec) * time.Second
+	}
+	if at, err := time.Parse(http.TimeFormat, v); err == nil {
+		return at.Sub(time.Now())
+	}
+	return 0
+}
+
+// tokenBucket is a simple token bucket rate limiter: wait takes a
+// token, and tokens are replenished qps times per second, up to the
+// bucket's capacity of burst.
+type tokenBucket struct {
+	tokens chan struct{}
+}
+
+func newTokenBucket(qps, burst int) *tokenBucket {
+	tb := &tokenBucket{tokens: make(chan struct{}, burst)}
+	for i := 0; i < burst; i++ {
+		tb.tokens <- struct{}{}
+	}
+	go func() {
+		for _ = range time.Tick(time.Second / time.Duration(qps)) {
+			select {
+			case tb.tokens <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return tb
+}
+
+func (tb *tokenBucket) wait() { <-tb.tokens }
@@ -0,0 +1,364 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ical implements an importer for iCalendar (.ics) calendars,
+// read either from an uploaded .ics file or from a CalDAV server,
+// creating an event permanode per VEVENT.
+package ical
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/httputil"
+	"camlistore.org/pkg/importer"
+	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/schema/nodeattr"
+)
+
+const (
+	// Permanode attributes on account node:
+	acctAttrURL       = "icalURL"       // address of an .ics file or CalDAV calendar collection.
+	acctAttrUsername  = "icalUsername"  // optional HTTP basic auth username.
+	acctAttrPassword  = "icalPassword"  // optional HTTP basic auth password.
+	acctAttrFileRef   = "icalFileRef"   // file schema blobref of an uploaded .ics file.
+	acctAttrSyncToken = "icalSyncToken" // DAV sync-token (or ctag) as of the last completed run.
+
+	nodeTypeEvent = "ical:event"
+)
+
+func init() {
+	importer.Register("ical", &imp{})
+}
+
+type imp struct {
+	importer.OAuth1 // for CallbackRequestAccount and CallbackURLParameters
+}
+
+func (im *imp) NeedsAPIKey() bool { return false }
+
+func (im *imp) IsAccountReady(acctNode *importer.Object) (ok bool, err error) {
+	if acctNode.Attr(acctAttrURL) != "" || acctNode.Attr(acctAttrFileRef) != "" {
+		return true, nil
+	}
+	return false, nil
+}
+
+func (im *imp) SummarizeAccount(acct *importer.Object) string {
+	ok, err := im.IsAccountReady(acct)
+	if err != nil {
+		return "Not configured; error = " + err.Error()
+	}
+	if !ok {
+		return "Not configured"
+	}
+	if u := acct.Attr(acctAttrURL); u != "" {
+		return fmt.Sprintf("calendar %s", u)
+	}
+	return "uploaded calendar file"
+}
+
+// A run is our state for a given run of the importer.
+type run struct {
+	*importer.RunContext
+}
+
+func (im *imp) Run(ctx *importer.RunContext) error {
+	r := &run{RunContext: ctx}
+	return r.importCalendar()
+}
+
+func (r *run) importCalendar() error {
+	acct := r.AccountNode()
+	if ref := acct.Attr(acctAttrFileRef); ref != "" {
+		return r.importFile(ref)
+	}
+	calURL := acct.Attr(acctAttrURL)
+	if calURL == "" {
+		return errors.New("ical: account has neither an uploaded file nor a URL")
+	}
+	token, err := r.davToken(calURL)
+	if err == errNotDAV {
+		// A plain .ics file served over HTTP.
+		body, err := r.doGet(calURL)
+		if err != nil {
+			return err
+		}
+		return r.importEvents(body)
+	}
+	if err != nil {
+		return err
+	}
+	if token != "" && token == acct.Attr(acctAttrSyncToken) {
+		log.Printf("ical: calendar %s unchanged since last run; skipping", calURL)
+		return nil
+	}
+	calendars, err := r.davCalendarData(calURL)
+	if err != nil {
+		return err
+	}
+	for _, data := range calendars {
+		if err := r.importEvents([]byte(data)); err != nil {
+			return err
+		}
+	}
+	if token != "" {
+		return acct.SetAttr(acctAttrSyncToken, token)
+	}
+	return nil
+}
+
+func (r *run) importFile(ref string) error {
+	br, ok := blob.Parse(ref)
+	if !ok {
+		return fmt.Errorf("ical: invalid blobref %q for uploaded file", ref)
+	}
+	fr, err := schema.NewFileReader(r.Host.BlobSource(), br)
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(fr, 8<<20))
+	if err != nil {
+		return err
+	}
+	return r.importEvents(body)
+}
+
+func (r *run) importEvents(body []byte) error {
+	events, err := parseEvents(body)
+	if err != nil {
+		return err
+	}
+	parent := r.RootNode()
+	if parent.Attr(nodeattr.Title) == "" {
+		if err := parent.SetAttr(nodeattr.Title, "Calendar Events"); err != nil {
+			return err
+		}
+	}
+	var imported, dups int
+	for _, ev := range events {
+		dup, err := r.importEvent(parent, ev)
+		if err != nil {
+			log.Printf("ical: error importing event %s: %v", ev.key(), err)
+			continue
+		}
+		if dup {
+			dups++
+		} else {
+			imported++
+		}
+	}
+	log.Printf("ical: imported %d events (%d already present)", imported, dups)
+	return nil
+}
+
+func (r *run) importEvent(parent *importer.Object, ev *event) (dup bool, err error) {
+	evNode, err := parent.ChildPathObject(ev.key())
+	if err != nil {
+		return false, err
+	}
+	dup = evNode.Attr(nodeattr.StartDate) != ""
+	attrs := []string{
+		"icalUID", ev.UID,
+		nodeattr.Type, nodeTypeEvent,
+		nodeattr.Title, ev.Summary,
+		nodeattr.StartDate, schema.RFC3339FromTime(ev.Start),
+	}
+	if ev.RecurrenceID != "" {
+		attrs = append(attrs, "icalRecurrenceId", ev.RecurrenceID)
+	}
+	if !ev.End.IsZero() {
+		attrs = append(attrs, "endDate", schema.RFC3339FromTime(ev.End))
+	}
+	if ev.Description != "" {
+		attrs = append(attrs, nodeattr.Description, ev.Description)
+	}
+	if ev.Location != "" {
+		attrs = append(attrs, "locationText", ev.Location)
+	}
+	if ev.Lat != "" && ev.Long != "" {
+		attrs = append(attrs, nodeattr.Latitude, ev.Lat, nodeattr.Longitude, ev.Long)
+	}
+	if ev.RRule != "" {
+		attrs = append(attrs, "rrule", ev.RRule)
+	}
+	if err := evNode.SetAttrs(attrs...); err != nil {
+		return false, err
+	}
+	return dup, nil
+}
+
+// errNotDAV is returned by davToken when the configured URL does not
+// answer PROPFIND, i.e. when it is a plain .ics file.
+var errNotDAV = errors.New("ical: not a DAV collection")
+
+func (r *run) setAuth(req *http.Request) {
+	acct := r.AccountNode()
+	if user := acct.Attr(acctAttrUsername); user != "" {
+		req.SetBasicAuth(user, acct.Attr(acctAttrPassword))
+	}
+}
+
+func (r *run) doGet(url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.setAuth(req)
+	res, err := r.Context.HTTPClient().Do(req)
+	if err != nil {
+		log.Printf("ical: error fetching %s: %v", url, err)
+		return nil, err
+	}
+	defer httputil.CloseBody(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ical: get request on %s failed with: %s", url, res.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(res.Body, 8<<20))
+}
+
+// davToken asks the CalDAV collection for its DAV sync-token, falling
+// back to the calendarserver.org getctag, so that an unchanged
+// calendar can be skipped on later runs. It returns errNotDAV if the
+// URL does not answer PROPFIND.
+func (r *run) davToken(calURL string) (string, error) {
+	const propfind = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:cs="http://calendarserver.org/ns/">
+ <d:prop><d:sync-token/><cs:getctag/></d:prop>
+</d:propfind>`
+	req, err := http.NewRequest("PROPFIND", calURL, strings.NewReader(propfind))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Depth", "0")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	r.setAuth(req)
+	res, err := r.Context.HTTPClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer httputil.CloseBody(res.Body)
+	if res.StatusCode != 207 {
+		return "", errNotDAV
+	}
+	var ms struct {
+		SyncToken []string `xml:"response>propstat>prop>sync-token"`
+		CTag      []string `xml:"response>propstat>prop>getctag"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&ms); err != nil {
+		return "", fmt.Errorf("ical: parsing PROPFIND response: %v", err)
+	}
+	for _, tok := range append(ms.SyncToken, ms.CTag...) {
+		if tok != "" {
+			return tok, nil
+		}
+	}
+	return "", nil
+}
+
+// davCalendarData runs a CalDAV calendar-query REPORT and returns the
+// iCalendar data of each VEVENT resource in the collection.
+func (r *run) davCalendarData(calURL string) ([]string, error) {
+	const query = `<?xml version="1.0" encoding="utf-8"?>
+<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+ <d:prop><c:calendar-data/></d:prop>
+ <c:filter><c:comp-filter name="VCALENDAR"><c:comp-filter name="VEVENT"/></c:comp-filter></c:filter>
+</c:calendar-query>`
+	req, err := http.NewRequest("REPORT", calURL, strings.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	r.setAuth(req)
+	res, err := r.Context.HTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httputil.CloseBody(res.Body)
+	if res.StatusCode != 207 {
+		return nil, fmt.Errorf("ical: calendar-query on %s failed with: %s", calURL, res.Status)
+	}
+	var ms struct {
+		CalendarData []string `xml:"response>propstat>prop>calendar-data"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(res.Body, 64<<20)).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("ical: parsing calendar-query response: %v", err)
+	}
+	return ms.CalendarData, nil
+}
+
+func (im *imp) ServeSetup(w http.ResponseWriter, r *http.Request, ctx *importer.SetupContext) error {
+	return tmpl.ExecuteTemplate(w, "serveSetup", ctx)
+}
+
+var tmpl = template.Must(template.New("root").Parse(`
+{{define "serveSetup"}}
+<h1>Configuring Calendar</h1>
+<p>Provide either the URL of an .ics file or CalDAV calendar (with
+credentials if needed), or upload an .ics file.</p>
+<form method="post" enctype="multipart/form-data" action="{{.CallbackURL}}">
+  <input type="hidden" name="acct" value="{{.AccountNode.PermanodeRef}}">
+  <table border=0 cellpadding=3>
+  <tr><td align=right>Calendar URL</td><td><input name="icalURL" size=50></td></tr>
+  <tr><td align=right>Username</td><td><input name="icalUsername" size=20></td></tr>
+  <tr><td align=right>Password</td><td><input type="password" name="icalPassword" size=20></td></tr>
+  <tr><td align=right>Or .ics file</td><td><input type="file" name="icsFile"></td></tr>
+  <tr><td align=right></td><td><input type="submit" value="Add"></td></tr>
+  </table>
+</form>
+{{end}}
+`))
+
+func (im *imp) ServeCallback(w http.ResponseWriter, r *http.Request, ctx *importer.SetupContext) {
+	if file, hdr, err := r.FormFile("icsFile"); err == nil {
+		defer file.Close()
+		fileRef, err := schema.WriteFileFromReader(ctx.Host.Target(), hdr.Filename, file)
+		if err != nil {
+			httputil.ServeError(w, r, fmt.Errorf("Error writing uploaded file: %v", err))
+			return
+		}
+		if err := ctx.AccountNode.SetAttrs(acctAttrFileRef, fileRef.String()); err != nil {
+			httputil.ServeError(w, r, fmt.Errorf("Error setting attribute: %v", err))
+			return
+		}
+		http.Redirect(w, r, ctx.AccountURL(), http.StatusFound)
+		return
+	}
+	u := r.FormValue("icalURL")
+	if u == "" {
+		http.Error(w, "Expected a calendar URL or an .ics file", 400)
+		return
+	}
+	if err := ctx.AccountNode.SetAttrs(
+		acctAttrURL, u,
+		acctAttrUsername, r.FormValue("icalUsername"),
+		acctAttrPassword, r.FormValue("icalPassword"),
+	); err != nil {
+		httputil.ServeError(w, r, fmt.Errorf("Error setting attribute: %v", err))
+		return
+	}
+	http.Redirect(w, r, ctx.AccountURL(), http.StatusFound)
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"camlistore.org/pkg/schema"
+)
+
+// An event is one VEVENT from an iCalendar stream.
+type event struct {
+	UID          string
+	RecurrenceID string // raw RECURRENCE-ID value, if any
+	Summary      string
+	Description  string
+	Location     string
+	RRule        string // raw RRULE value, if any
+	Lat, Long    string // from the GEO property, if present
+	Start        time.Time
+	End          time.Time // zero if the event has no DTEND
+}
+
+// key returns the identifier used to dedupe the event across runs: the
+// UID, with the RECURRENCE-ID appended for overridden instances of a
+// recurring event.
+func (ev *event) key() string {
+	if ev.RecurrenceID != "" {
+		return ev.UID + "/" + ev.RecurrenceID
+	}
+	return ev.UID
+}
+
+// A prop is one (unfolded) content line: NAME;PARAM=value:value.
+type prop struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+func (p prop) param(name string) string { return p.params[name] }
+
+// parseEvents parses an iCalendar stream and returns its VEVENT
+// components. Components other than VEVENT are ignored.
+func parseEvents(data []byte) ([]*event, error) {
+	var events []*event
+	var ev *event
+	for _, line := range unfoldLines(data) {
+		p, err := parseProp(line)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case p.name == "BEGIN" && p.value == "VEVENT":
+			ev = new(event)
+		case p.name == "END" && p.value == "VEVENT":
+			if ev == nil {
+				return nil, fmt.Errorf("ical: END:VEVENT without BEGIN:VEVENT")
+			}
+			if ev.UID == "" {
+				return nil, fmt.Errorf("ical: VEVENT without UID")
+			}
+			events = append(events, ev)
+			ev = nil
+		case ev == nil:
+			// Property outside a VEVENT; not interesting.
+		case p.name == "UID":
+			ev.UID = p.value
+		case p.name == "RECURRENCE-ID":
+			ev.RecurrenceID = p.value
+		case p.name == "SUMMARY":
+			ev.Summary = unescapeText(p.value)
+		case p.name == "DESCRIPTION":
+			ev.Description = unescapeText(p.value)
+		case p.name == "LOCATION":
+			ev.Location = unescapeText(p.value)
+		case p.name == "RRULE":
+			ev.RRule = p.value
+		case p.name == "GEO":
+			if i := strings.Index(p.value, ";"); i >= 0 {
+				ev.Lat, ev.Long = p.value[:i], p.value[i+1:]
+			}
+		case p.name == "DTSTART":
+			t, err := parseDateTime(p)
+			if err != nil {
+				return nil, err
+			}
+			ev.Start = t
+		case p.name == "DTEND":
+			t, err := parseDateTime(p)
+			if err != nil {
+				return nil, err
+			}
+			ev.End = t
+		}
+	}
+	if ev != nil {
+		return nil, fmt.Errorf("ical: unterminated VEVENT")
+	}
+	return events, nil
+}
+
+// unfoldLines splits data into content lines, joining folded
+// continuation lines (lines starting with a space or tab) with their
+// predecessor, per RFC 5545 section 3.1.
+func unfoldLines(data []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// parseProp parses a content line of the form
+// NAME[;PARAM=value[;...]]:value. Parameter values may be quoted, and
+// a quoted value may contain ':' and ';'.
+func parseProp(line string) (prop, error) {
+	p := prop{params: make(map[string]string)}
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			inQuotes = !inQuotes
+		case ';', ':':
+			if inQuotes {
+				continue
+			}
+			seg := line[start:i]
+			if p.name == "" {
+				p.name = strings.ToUpper(seg)
+			} else if eq := strings.Index(seg, "="); eq >= 0 {
+				p.params[strings.ToUpper(seg[:eq])] = strings.Trim(seg[eq+1:], `"`)
+			}
+			start = i + 1
+			if line[i] == ':' {
+				p.value = line[start:]
+				return p, nil
+			}
+		}
+	}
+	return prop{}, fmt.Errorf("ical: malformed content line %q", line)
+}
+
+// unescapeText undoes RFC 5545 TEXT escaping: \\, \;, \, and \n (or
+// \N) for a newline.
+func unescapeText(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			buf.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			buf.WriteByte('\n')
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+	return buf.String()
+}
+
+// parseDateTime parses a DATE or DATE-TIME property value. UTC values
+// (trailing "Z") and values with a recognized TZID parameter get their
+// real offset; floating times, all-day DATE values, and values with an
+// unknown TZID are returned in schema.UnknownLocation so that
+// schema.RFC3339FromTime marks them with the -00:01 offset.
+func parseDateTime(p prop) (time.Time, error) {
+	v := p.value
+	if p.param("VALUE") == "DATE" || !strings.Contains(v, "T") {
+		return time.ParseInLocation("20060102", v, schema.UnknownLocation)
+	}
+	if strings.HasSuffix(v, "Z") {
+		return time.Parse("20060102T150405Z", v)
+	}
+	loc := schema.UnknownLocation
+	if tzid := p.param("TZID"); tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	return time.ParseInLocation("20060102T150405", v, loc)
+}
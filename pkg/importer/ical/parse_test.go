@@ -0,0 +1,150 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ical
+
+import (
+	"strings"
+	"testing"
+
+	"camlistore.org/pkg/schema"
+)
+
+var sampleICS = strings.Replace(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//Test//EN
+BEGIN:VEVENT
+UID:utc-event@example.com
+DTSTART:20140102T150405Z
+DTEND:20140102T160405Z
+SUMMARY:Team meeting\, room 3
+DESCRIPTION:Line one\nLine two
+LOCATION:HQ\, floor 2
+GEO:37.386013;-122.082932
+END:VEVENT
+BEGIN:VEVENT
+UID:tzid-event@example.com
+DTSTART;TZID=America/New_York:20140315T090000
+SUMMARY:Folded summary goes on
+  and on
+RRULE:FREQ=WEEKLY;BYDAY=SA
+END:VEVENT
+BEGIN:VEVENT
+UID:tzid-event@example.com
+RECURRENCE-ID;TZID=America/New_York:20140322T090000
+DTSTART;TZID=America/New_York:20140322T100000
+SUMMARY:Moved instance
+END:VEVENT
+BEGIN:VEVENT
+UID:floating-event@example.com
+DTSTART:20140704T120000
+SUMMARY:Floating lunch
+END:VEVENT
+BEGIN:VEVENT
+UID:allday-event@example.com
+DTSTART;VALUE=DATE:20141225
+SUMMARY:All day
+END:VEVENT
+END:VCALENDAR
+`, "\n", "\r\n", -1)
+
+func TestParseEvents(t *testing.T) {
+	events, err := parseEvents([]byte(sampleICS))
+	if err != nil {
+		t.Fatalf("parseEvents: %v", err)
+	}
+	if len(events) != 5 {
+		t.Fatalf("got %d events; want 5", len(events))
+	}
+
+	utc := events[0]
+	if utc.Summary != "Team meeting, room 3" {
+		t.Errorf("UTC event summary = %q", utc.Summary)
+	}
+	if utc.Description != "Line one\nLine two" {
+		t.Errorf("UTC event description = %q", utc.Description)
+	}
+	if utc.Location != "HQ, floor 2" {
+		t.Errorf("UTC event location = %q", utc.Location)
+	}
+	if utc.Lat != "37.386013" || utc.Long != "-122.082932" {
+		t.Errorf("UTC event geo = %q, %q", utc.Lat, utc.Long)
+	}
+	if got := schema.RFC3339FromTime(utc.Start); got != "2014-01-02T15:04:05Z" {
+		t.Errorf("UTC event start = %q", got)
+	}
+	if got := schema.RFC3339FromTime(utc.End); got != "2014-01-02T16:04:05Z" {
+		t.Errorf("UTC event end = %q", got)
+	}
+
+	tzid := events[1]
+	if tzid.Summary != "Folded summary goes on and on" {
+		t.Errorf("TZID event summary = %q", tzid.Summary)
+	}
+	if tzid.RRule != "FREQ=WEEKLY;BYDAY=SA" {
+		t.Errorf("TZID event rrule = %q", tzid.RRule)
+	}
+	// 09:00 EDT is 13:00 UTC.
+	if got := schema.RFC3339FromTime(tzid.Start); got != "2014-03-15T13:00:00Z" {
+		t.Errorf("TZID event start = %q", got)
+	}
+	if tzid.key() != "tzid-event@example.com" {
+		t.Errorf("TZID event key = %q", tzid.key())
+	}
+
+	moved := events[2]
+	if moved.RecurrenceID != "20140322T090000" {
+		t.Errorf("moved instance recurrence-id = %q", moved.RecurrenceID)
+	}
+	if moved.key() != "tzid-event@example.com/20140322T090000" {
+		t.Errorf("moved instance key = %q", moved.key())
+	}
+
+	floating := events[3]
+	if schema.IsZoneKnown(floating.Start) {
+		t.Errorf("floating event start has a known zone: %v", floating.Start)
+	}
+	if got := schema.RFC3339FromTime(floating.Start); !strings.HasSuffix(got, "-00:01") {
+		t.Errorf("floating event start = %q; want -00:01 suffix", got)
+	}
+
+	allday := events[4]
+	if got := schema.RFC3339FromTime(allday.Start); !strings.HasPrefix(got, "2014-12-25T00:00:00") || !strings.HasSuffix(got, "-00:01") {
+		t.Errorf("all-day event start = %q", got)
+	}
+	if !allday.End.IsZero() {
+		t.Errorf("all-day event has an end: %v", allday.End)
+	}
+}
+
+func TestParseProp(t *testing.T) {
+	p, err := parseProp(`ATTENDEE;CN="Doe, John";ROLE=CHAIR:mailto:john@example.com`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.name != "ATTENDEE" {
+		t.Errorf("name = %q", p.name)
+	}
+	if got := p.param("CN"); got != "Doe, John" {
+		t.Errorf("CN param = %q", got)
+	}
+	if p.value != "mailto:john@example.com" {
+		t.Errorf("value = %q", p.value)
+	}
+	if _, err := parseProp("NOCOLON"); err == nil {
+		t.Error("expected error for line without a colon")
+	}
+}
@@ -18,41 +18,76 @@ limitations under the License.
 package importer
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"html/template"
-	"log"
 	"net/http"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"camlistore.org/pkg/blob"
 	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/camlog"
 	"camlistore.org/pkg/context"
 	"camlistore.org/pkg/httputil"
 	"camlistore.org/pkg/jsonconfig"
 	"camlistore.org/pkg/jsonsign/signhandler"
+	"camlistore.org/pkg/maintenance"
 	"camlistore.org/pkg/schema"
 	"camlistore.org/pkg/search"
 	"camlistore.org/pkg/server"
+	"camlistore.org/pkg/serverinit"
 	"camlistore.org/pkg/syncutil"
 )
 
 const (
-	attrNodeType            = "camliNodeType"
-	nodeTypeImporter        = "importer"
-	nodeTypeImporterAccount = "importerAccount"
-
-	attrImporterType = "importerType" // => "twitter", "foursquare", etc
-	attrClientID     = "authClientID"
-	attrClientSecret = "authClientSecret"
-	attrImportRoot   = "importRoot"
-	attrImportAuto   = "importAuto" // => time.Duration value ("30m") or "" for off
+	attrNodeType               = "camliNodeType"
+	nodeTypeImporter           = "importer"
+	nodeTypeImporterAccount    = "importerAccount"
+	nodeTypeImporterRunSummary = "importerRunSummary"
+
+	attrImporterType   = "importerType" // => "twitter", "foursquare", etc
+	attrClientID       = "authClientID"
+	attrClientSecret   = "authClientSecret"
+	attrImportRoot     = "importRoot"
+	attrImportAuto     = "importAuto"       // => time.Duration value ("30m") or "" for off
+	attrLastRunSummary = "lastRunSummary"   // => permanode ref of the last run's summary node
+	attrHookTokenHash  = "webhookTokenHash" // => hex SHA-1 of the account's webhook token, or "" for no webhook
 )
 
+// Reason categories for RunContext.Skip.
+const (
+	SkipReasonUnchanged        = "unchanged"
+	SkipReasonUnsupportedMedia = "unsupported-media"
+	SkipReasonTooLarge         = "too-large"
+	SkipReasonNoPermission     = "no-permission"
+	SkipReasonError            = "error"
+)
+
+// maxSkipsPerReason bounds how many skipped item IDs are recorded per
+// reason category on the run summary node. Counts are unbounded.
+const maxSkipsPerReason = 100
+
+// periodicCheckInterval is how often the "importers" scheduled task
+// re-scans importer accounts for ones whose refresh interval has
+// elapsed. The jitter spreads the search load after restarts.
+const (
+	periodicCheckInterval = 5 * time.Minute
+	periodicCheckJitter   = 30 * time.Second
+)
+
+// importerLog is the "importer" logging subsystem. Its level can be
+// raised to "debug" in the config or via /debug/logging.
+var importerLog = camlog.New("importer")
+
 // An Importer imports from a third-party site.
 type Importer interface {
 	// Run runs a full or incremental import.
@@ -140,6 +175,10 @@ type HostConfig struct {
 	ClientId     map[string]string // optionally maps importer impl name to a clientId credential
 	ClientSecret map[string]string // optionally maps importer impl name to a clientSecret credential
 
+	// HTTPLimit optionally maps importer impl name to its outbound
+	// HTTP policy (rate limit, retries, timeout).
+	HTTPLimit map[string]HTTPLimit
+
 	// HTTPClient optionally specifies how to fetch external network
 	// resources. The Host will use http.DefaultClient otherwise.
 	HTTPClient *http.Client
@@ -177,6 +216,7 @@ func NewHost(hc HostConfig) (*Host, error) {
 			impl:         impl,
 			clientID:     clientId,
 			clientSecret: clientSecret,
+			httpLimit:    hc.HTTPLimit[k],
 		}
 		h.imp[k] = imp
 	}
@@ -199,6 +239,7 @@ func newFromConfig(ld blobserver.Loader, cfg jsonconfig.Obj) (http.Handler, erro
 	}
 	ClientId := make(map[string]string)
 	ClientSecret := make(map[string]string)
+	HTTPLimits := make(map[string]HTTPLimit)
 	for k, _ := range importers {
 		var clientId, clientSecret string
 		if impConf := cfg.OptionalObject(k); impConf != nil {
@@ -211,11 +252,18 @@ func newFromConfig(ld blobserver.Loader, cfg jsonconfig.Obj) (http.Handler, erro
 					clientId, clientSecret = f[0], f[1]
 				}
 			}
+			limit := HTTPLimit{
+				QPS:      impConf.OptionalInt("maxQPS", 0),
+				Burst:    impConf.OptionalInt("maxQPSBurst", 0),
+				MaxTries: impConf.OptionalInt("maxTries", 0),
+				Timeout:  time.Duration(impConf.OptionalInt("requestTimeout", 0)) * time.Second, // in seconds
+			}
 			if err := impConf.Validate(); err != nil {
 				return nil, fmt.Errorf("Invalid static configuration for importer %q: %v", k, err)
 			}
 			ClientId[k] = clientId
 			ClientSecret[k] = clientSecret
+			HTTPLimits[k] = limit
 		}
 	}
 	if err := cfg.Validate(); err != nil {
@@ -223,6 +271,7 @@ func newFromConfig(ld blobserver.Loader, cfg jsonconfig.Obj) (http.Handler, erro
 	}
 	hc.ClientId = ClientId
 	hc.ClientSecret = ClientSecret
+	hc.HTTPLimit = HTTPLimits
 	return NewHost(hc)
 }
 
@@ -264,6 +313,79 @@ type RunContext struct {
 
 	mu           sync.Mutex // guards following
 	lastProgress *ProgressMessage
+	skipCount    map[string]int      // reason category -> number of items skipped
+	skipItems    map[string][]string // reason category -> item IDs, at most maxSkipsPerReason each
+}
+
+// Skip records that the importer intentionally did not import the
+// item identified by itemID for the given reason, which should be one
+// of the SkipReason constants. Skips are not errors: they don't
+// affect the run's result. They are accumulated during the run
+// (keeping at most maxSkipsPerReason item IDs per reason) and
+// persisted on a run summary node when the run finishes, so that
+// "why wasn't this item imported?" can be answered without re-running
+// with extra logging.
+func (rc *RunContext) Skip(itemID, reason string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.skipCount == nil {
+		rc.skipCount = make(map[string]int)
+		rc.skipItems = make(map[string][]string)
+	}
+	rc.skipCount[reason]++
+	if len(rc.skipItems[reason]) < maxSkipsPerReason {
+		rc.skipItems[reason] = append(rc.skipItems[reason], itemID)
+	}
+}
+
+// skipSummary returns the skips recorded so far, one entry per reason
+// category, sorted by reason.
+func (rc *RunContext) skipSummary() []skipSummary {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if len(rc.skipCount) == 0 {
+		return nil
+	}
+	reasons := make([]string, 0, len(rc.skipCount))
+	for reason := range rc.skipCount {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	sum := make([]skipSummary, 0, len(reasons))
+	for _, reason := range reasons {
+		sum = append(sum, skipSummary{
+			Reason: reason,
+			Count:  rc.skipCount[reason],
+			Items:  rc.skipItems[reason],
+		})
+	}
+	return sum
+}
+
+// saveSkipSummary persists this run's accumulated skips (if any) on a
+// new run summary permanode and points the account node's
+// attrLastRunSummary attribute at it.
+func (rc *RunContext) saveSkipSummary() error {
+	sum := rc.skipSummary()
+	if len(sum) == 0 {
+		return nil
+	}
+	obj, err := rc.Host.NewObject()
+	if err != nil {
+		return err
+	}
+	if err := obj.SetAttr(attrNodeType, nodeTypeImporterRunSummary); err != nil {
+		return err
+	}
+	for _, ss := range sum {
+		if err := obj.SetAttr("skipCount:"+ss.Reason, strconv.Itoa(ss.Count)); err != nil {
+			return err
+		}
+		if err := obj.SetAttrValues("skipped:"+ss.Reason, ss.Items); err != nil {
+			return err
+		}
+	}
+	return rc.AccountNode().SetAttr(attrLastRunSummary, obj.PermanodeRef().String())
 }
 
 // CreateAccount creates a new importer account for the Host h, and the importer
@@ -325,6 +447,13 @@ type Host struct {
 	signer       *schema.Signer
 	uiPrefix     string // or empty if no UI handler
 
+	// periodic is the scheduled task re-checking accounts with an
+	// importAuto interval; set by InitHandler.
+	periodic *serverinit.TaskHandle
+
+	peoplemu    sync.Mutex // guards peopleCache
+	peopleCache *Object    // or nil if unset; see PeopleRoot
+
 	// HTTPClient optionally specifies how to fetch external network
 	// resources. Defaults to http.DefaultClient.
 	client    *http.Client
@@ -359,7 +488,13 @@ func (h *Host) InitHandler(hl blobserver.FindHandlerByTyper) error {
 	if h.signer == nil {
 		return errors.New("importer requires a 'jsonsign' handler")
 	}
-	go h.startPeriodicImporters()
+	h.periodic = serverinit.RegisterTask(serverinit.Task{
+		Name:     "importers",
+		Interval: periodicCheckInterval,
+		Jitter:   periodicCheckJitter,
+		Run:      h.checkPeriodicImporters,
+	})
+	h.periodic.Wake() // do a first pass at startup
 	return nil
 }
 
@@ -367,6 +502,7 @@ func (h *Host) InitHandler(hl blobserver.FindHandlerByTyper) error {
 //   http://host/importer/
 //   http://host/importer/twitter/
 //   http://host/importer/twitter/callback
+//   http://host/importer/twitter/hook/sha1-abcabcabcabcabc (webhook)
 //   http://host/importer/twitter/sha1-abcabcabcabcabc (single account)
 func (h *Host) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	suffix := httputil.PathSuffix(r)
@@ -391,6 +527,19 @@ func (h *Host) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.serveImporterAcctCallback(w, r, imp)
 		return
 	}
+	if seg[1] == "hook" {
+		if len(seg) < 3 {
+			http.NotFound(w, r)
+			return
+		}
+		acctRef, ok := blob.Parse(seg[2])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		h.serveImporterAccountHook(w, r, imp, acctRef)
+		return
+	}
 	acctRef, ok := blob.Parse(seg[1])
 	if !ok {
 		http.NotFound(w, r)
@@ -439,7 +588,7 @@ func (h *Host) serveImporter(w http.ResponseWriter, r *http.Request, imp *import
 
 // Serves oauth callback at http://host/importer/TYPE/callback
 func (h *Host) serveImporterAcctCallback(w http.ResponseWriter, r *http.Request, imp *importer) {
-	if r.Method != "GET" {
+	if r.Method != "GET" && r.Method != "POST" {
 		http.Error(w, "invalid method", 400)
 		return
 	}
@@ -504,7 +653,20 @@ func (h *Host) serveImporterAccount(w http.ResponseWriter, r *http.Request, imp
 	ia.ServeHTTP(w, r)
 }
 
-func (h *Host) startPeriodicImporters() {
+// Serves webhook hits at http://host/importer/twitter/hook/sha1-23098429382934
+func (h *Host) serveImporterAccountHook(w http.ResponseWriter, r *http.Request, imp *importer, acctRef blob.Ref) {
+	ia, err := imp.account(acctRef)
+	if err != nil {
+		http.Error(w, "Unknown or invalid importer account "+acctRef.String()+": "+err.Error(), 400)
+		return
+	}
+	ia.serveHook(w, r)
+}
+
+// checkPeriodicImporters is the "importers" scheduled task: it finds
+// all importer accounts and starts an import for those whose refresh
+// interval (the importAuto attribute) has elapsed.
+func (h *Host) checkPeriodicImporters(ctx *context.Context) error {
 	res, err := h.search.Query(&search.SearchQuery{
 		Expression: "attr:camliNodeType:importerAccount",
 		Describe: &search.DescribeRequest{
@@ -512,12 +674,10 @@ func (h *Host) startPeriodicImporters() {
 		},
 	})
 	if err != nil {
-		log.Printf("periodic importer search fail: %v", err)
-		return
+		return fmt.Errorf("periodic importer search fail: %v", err)
 	}
 	if res.Describe == nil {
-		log.Printf("No describe response in search result")
-		return
+		return errors.New("no describe response in periodic importer search result")
 	}
 	for _, resBlob := range res.Blobs {
 		blob := resBlob.Blob
@@ -536,17 +696,18 @@ func (h *Host) startPeriodicImporters() {
 		}
 		ia, err := imp.account(blob)
 		if err != nil {
-			log.Printf("Can't load importer account %v for regular importing: %v", blob, err)
+			importerLog.Printf("Can't load importer account %v for regular importing: %v", blob, err)
 			continue
 		}
-		go ia.maybeStart()
+		ia.maybeStart()
 	}
+	return nil
 }
 
 func (ia *importerAcct) maybeStart() {
 	acctObj, err := ia.im.host.ObjectFromRef(ia.acct.PermanodeRef())
 	if err != nil {
-		log.Printf("Error maybe starting %v: %v", ia.acct.PermanodeRef(), err)
+		importerLog.Printf("Error maybe starting %v: %v", ia.acct.PermanodeRef(), err)
 		return
 	}
 	duration, err := time.ParseDuration(acctObj.Attr(attrImportAuto))
@@ -559,13 +720,13 @@ func (ia *importerAcct) maybeStart() {
 		return
 	}
 	if ia.lastRunDone.After(time.Now().Add(-duration)) {
-		sleepFor := ia.lastRunDone.Add(duration).Sub(time.Now())
-		log.Printf("%v ran recently enough. Sleeping for %v.", ia, sleepFor)
-		time.AfterFunc(sleepFor, ia.maybeStart)
+		// Ran recently enough; the scheduler's next pass of the
+		// "importers" task will retry.
+		importerLog.Debugf("%v ran recently enough; not due for %v.", ia, ia.lastRunDone.Add(duration).Sub(time.Now()))
 		return
 	}
 
-	log.Printf("Starting regular periodic import for %v", ia)
+	importerLog.Printf("Starting regular periodic import for %v", ia)
 	go ia.start()
 }
 
@@ -600,6 +761,13 @@ type importer struct {
 	clientID     string
 	clientSecret string
 
+	// httpLimit is the importer's outbound HTTP policy, from the
+	// handler config.
+	httpLimit HTTPLimit
+
+	bucketmu sync.Mutex
+	bucket   *tokenBucket // lazily created by tokenBucket; nil if no QPS limit
+
 	nodemu    sync.Mutex // guards nodeCache
 	nodeCache *Object    // or nil if unset
 
@@ -842,6 +1010,9 @@ type importerAcct struct {
 	lastRunErr   error
 	lastRunStart time.Time
 	lastRunDone  time.Time
+	lastRunSkips []skipSummary
+	hookPending  bool      // a webhook-triggered run is queued
+	lastHookRun  time.Time // start of the last webhook-triggered run
 }
 
 func (ia *importerAcct) String() string {
@@ -886,6 +1057,104 @@ func (ia *importerAcct) AccountLinkSummary() string {
 	return ia.im.impl.SummarizeAccount(ia.acct)
 }
 
+// HookURL returns the URL external services POST to (with the account's
+// webhook token) to queue an import run.
+func (ia *importerAcct) HookURL() string {
+	return ia.im.URL() + "/hook/" + ia.acct.PermanodeRef().String()
+}
+
+// HookEnabled reports whether a webhook token has been generated for
+// the account.
+func (ia *importerAcct) HookEnabled() bool {
+	return ia.acct.Attr(attrHookTokenHash) != ""
+}
+
+// hashHookToken returns the hex SHA-1 of a webhook token, the only form
+// in which tokens are stored; the clear token is shown once, when
+// generated.
+func hashHookToken(token string) string {
+	sum := sha1.Sum([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newHookToken generates a new webhook token for the account, stores
+// its hash, and returns the clear token, to be shown once. Any previous
+// token stops working.
+func (ia *importerAcct) newHookToken() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	if err := ia.acct.SetAttr(attrHookTokenHash, hashHookToken(token)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// minHookInterval spaces the webhook-triggered runs of an account, so a
+// misbehaving webhook cannot cause continuous imports.
+const minHookInterval = 1 * time.Minute
+
+// serveHook serves an authenticated webhook hit, queueing an import
+// run for the account.
+func (ia *importerAcct) serveHook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	wantHash := ia.acct.Attr(attrHookTokenHash)
+	if wantHash == "" {
+		http.Error(w, "webhook is not enabled for this account", http.StatusForbidden)
+		return
+	}
+	if !hmac.Equal([]byte(hashHookToken(r.FormValue("token"))), []byte(wantHash)) {
+		http.Error(w, "invalid webhook token", http.StatusForbidden)
+		return
+	}
+	if maintenance.Enabled() {
+		maintenance.Refuse(w)
+		return
+	}
+	ia.scheduleHookRun()
+	w.Header().Set("Location", ia.AccountURL())
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "Import run queued; status at %s\n", ia.AccountURL())
+}
+
+// scheduleHookRun queues an import run for a webhook hit, coalescing a
+// burst of hits into a single run and keeping webhook-triggered runs at
+// least minHookInterval apart.
+func (ia *importerAcct) scheduleHookRun() {
+	ia.mu.Lock()
+	defer ia.mu.Unlock()
+	ia.scheduleHookRunLocked()
+}
+
+func (ia *importerAcct) scheduleHookRunLocked() {
+	if ia.hookPending {
+		return
+	}
+	ia.hookPending = true
+	delay := minHookInterval - time.Since(ia.lastHookRun)
+	if delay < 0 {
+		delay = 0
+	}
+	time.AfterFunc(delay, func() {
+		ia.mu.Lock()
+		defer ia.mu.Unlock()
+		ia.hookPending = false
+		if ia.current != nil {
+			// A run is already in flight; queue another look
+			// once the rate limit allows.
+			ia.scheduleHookRunLocked()
+			return
+		}
+		ia.lastHookRun = time.Now()
+		go ia.start()
+	})
+}
+
 func (ia *importerAcct) RefreshInterval() time.Duration {
 	ds := ia.acct.Attr(attrImportAuto)
 	if ds == "" {
@@ -900,11 +1169,18 @@ func (ia *importerAcct) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		ia.serveHTTPPost(w, r)
 		return
 	}
+	ia.serveAccountPage(w, r, "")
+}
+
+// serveAccountPage renders the account page. newHookToken, if
+// non-empty, is a freshly generated webhook token to display once.
+func (ia *importerAcct) serveAccountPage(w http.ResponseWriter, r *http.Request, newHookToken string) {
 	ia.mu.Lock()
 	defer ia.mu.Unlock()
 	body := acctBody{
-		Acct:     ia,
-		AcctType: fmt.Sprintf("%T", ia.im.impl),
+		Acct:         ia,
+		AcctType:     fmt.Sprintf("%T", ia.im.impl),
+		NewHookToken: newHookToken,
 	}
 	if run := ia.current; run != nil {
 		body.Running = true
@@ -912,11 +1188,13 @@ func (ia *importerAcct) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		run.mu.Lock()
 		body.LastStatus = fmt.Sprintf("%+v", run.lastProgress)
 		run.mu.Unlock()
+		body.Skips = run.skipSummary()
 	} else if !ia.lastRunDone.IsZero() {
 		body.LastAgo = time.Since(ia.lastRunDone)
 		if ia.lastRunErr != nil {
 			body.LastError = ia.lastRunErr.Error()
 		}
+		body.Skips = ia.lastRunSkips
 	}
 	title := fmt.Sprintf("%s account: ", ia.im.name)
 	if summary := ia.im.impl.SummarizeAccount(ia.acct); summary != "" {
@@ -937,6 +1215,10 @@ func (ia *importerAcct) serveHTTPPost(w http.ResponseWriter, r *http.Request) {
 	case "":
 		// Nothing.
 	case "start":
+		if maintenance.Enabled() {
+			maintenance.Refuse(w)
+			return
+		}
 		ia.start()
 	case "stop":
 		ia.stop()
@@ -948,6 +1230,19 @@ func (ia *importerAcct) serveHTTPPost(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), 500)
 			return
 		}
+	case "togglecontacts":
+		if err := ia.toggleContacts(); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	case "newhooktoken":
+		token, err := ia.newHookToken()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		ia.serveAccountPage(w, r, token)
+		return
 	case "delete":
 		ia.stop() // can't hurt
 		if err := ia.delete(); err != nil {
@@ -970,7 +1265,7 @@ func (ia *importerAcct) setup(w http.ResponseWriter, r *http.Request) {
 		AccountNode: ia.acct,
 		ia:          ia,
 	}); err != nil {
-		log.Printf("%v", err)
+		importerLog.Printf("%v", err)
 	}
 }
 
@@ -990,12 +1285,23 @@ func (ia *importerAcct) start() {
 	ia.stopped = false
 	ia.lastRunStart = time.Now()
 	go func() {
-		log.Printf("Starting %v: %s", ia, ia.AccountLinkSummary())
+		importerLog.Printf("Starting %v: %s", ia, ia.AccountLinkSummary())
 		err := ia.im.impl.Run(rc)
 		if err != nil {
-			log.Printf("%v error: %v", ia, err)
+			importerLog.Printf("%v error: %v", ia, err)
 		} else {
-			log.Printf("%v finished.", ia)
+			importerLog.Printf("%v finished.", ia)
+		}
+		if err == nil && ia.ContactsEnabled() {
+			if f, ok := ia.im.impl.(ContactsFetcher); ok {
+				if cerr := rc.importContacts(f); cerr != nil {
+					importerLog.Printf("%v: contacts import error: %v", ia, cerr)
+					err = cerr
+				}
+			}
+		}
+		if serr := rc.saveSkipSummary(); serr != nil {
+			importerLog.Printf("%v: error saving skip summary: %v", ia, serr)
 		}
 		ia.mu.Lock()
 		defer ia.mu.Unlock()
@@ -1003,6 +1309,7 @@ func (ia *importerAcct) start() {
 		ia.stopped = false
 		ia.lastRunDone = time.Now()
 		ia.lastRunErr = err
+		ia.lastRunSkips = rc.skipSummary()
 		go ia.maybeStart()
 	}()
 }
@@ -17,8 +17,16 @@ limitations under the License.
 package importer
 
 import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"camlistore.org/pkg/blob"
 	"camlistore.org/pkg/jsonconfig"
 	"camlistore.org/pkg/test"
 )
@@ -64,3 +72,224 @@ func TestStaticConfig(t *testing.T) {
 		t.Errorf("expected error from secret without id")
 	}
 }
+
+func TestHostBaseURLs(t *testing.T) {
+	tests := []struct {
+		base, want string
+	}{
+		{"http://foo.com:3179", "http://foo.com:3179/importer/"},
+		// IPv6 literals stay bracketed in generated URLs.
+		{"https://[2001:db8::1]:443", "https://[2001:db8::1]:443/importer/"},
+		{"http://[::1]:3179", "http://[::1]:3179/importer/"},
+	}
+	for _, tt := range tests {
+		h, err := NewHost(HostConfig{
+			BaseURL: tt.base,
+			Prefix:  "/importer/",
+		})
+		if err != nil {
+			t.Fatalf("NewHost(%q): %v", tt.base, err)
+		}
+		if got := h.ImporterBaseURL(); got != tt.want {
+			t.Errorf("ImporterBaseURL for %q = %q; want %q", tt.base, got, tt.want)
+		}
+		if got := h.BaseURL(); got != tt.base {
+			t.Errorf("BaseURL for %q = %q; want %q", tt.base, got, tt.base)
+		}
+	}
+}
+
+func TestHookAuth(t *testing.T) {
+	host := &Host{importerBase: "http://foo.com/importer/"}
+	im := &importer{host: host, name: "dummy1", impl: TODOImporter}
+	ia := &importerAcct{
+		im:   im,
+		acct: &Object{pn: blob.MustParse("sha1-0000000000000000000000000000000000000001")},
+		// Pretend a run is already queued, so a valid hit only
+		// coalesces instead of starting a real import.
+		hookPending: true,
+	}
+	ia.acct.attr = map[string][]string{}
+
+	post := func(token string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		v := url.Values{}
+		if token != "" {
+			v.Set("token", token)
+		}
+		req, _ := http.NewRequest("POST", ia.HookURL()+"?"+v.Encode(), nil)
+		ia.serveHook(rw, req)
+		return rw
+	}
+
+	if got := post("sekrit").Code; got != http.StatusForbidden {
+		t.Errorf("hook with no token configured = %d; want %d", got, http.StatusForbidden)
+	}
+	ia.acct.attr[attrHookTokenHash] = []string{hashHookToken("sekrit")}
+	if got := post("wrong").Code; got != http.StatusForbidden {
+		t.Errorf("hook with wrong token = %d; want %d", got, http.StatusForbidden)
+	}
+	if got := post("").Code; got != http.StatusForbidden {
+		t.Errorf("hook with no token = %d; want %d", got, http.StatusForbidden)
+	}
+	rw := post("sekrit")
+	if rw.Code != http.StatusAccepted {
+		t.Errorf("hook with valid token = %d; want %d", rw.Code, http.StatusAccepted)
+	}
+	if got, want := rw.Header().Get("Location"), ia.AccountURL(); got != want {
+		t.Errorf("hook status URL = %q; want %q", got, want)
+	}
+
+	rw = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", ia.HookURL()+"?token=sekrit", nil)
+	ia.serveHook(rw, req)
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET hook = %d; want %d", rw.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestRunContextSkip(t *testing.T) {
+	rc := &RunContext{}
+	if got := rc.skipSummary(); got != nil {
+		t.Errorf("skipSummary with no skips = %v; want nil", got)
+	}
+	rc.Skip("photo1", SkipReasonUnchanged)
+	rc.Skip("photo2", SkipReasonUnchanged)
+	rc.Skip("movie1", SkipReasonUnsupportedMedia)
+	for i := 0; i < maxSkipsPerReason+5; i++ {
+		rc.Skip(fmt.Sprintf("big%d", i), SkipReasonTooLarge)
+	}
+	sum := rc.skipSummary()
+	want := []struct {
+		reason   string
+		count    int
+		numItems int
+	}{
+		{SkipReasonTooLarge, maxSkipsPerReason + 5, maxSkipsPerReason},
+		{SkipReasonUnchanged, 2, 2},
+		{SkipReasonUnsupportedMedia, 1, 1},
+	}
+	if len(sum) != len(want) {
+		t.Fatalf("got %d skip summaries; want %d", len(sum), len(want))
+	}
+	for i, w := range want {
+		ss := sum[i]
+		if ss.Reason != w.reason || ss.Count != w.count || len(ss.Items) != w.numItems {
+			t.Errorf("skip summary %d = {%q, %d, %d items}; want {%q, %d, %d items}",
+				i, ss.Reason, ss.Count, len(ss.Items), w.reason, w.count, w.numItems)
+		}
+	}
+	if got := sum[1].Items; got[0] != "photo1" || got[1] != "photo2" {
+		t.Errorf("unchanged items = %q; want [photo1 photo2]", got)
+	}
+}
+
+type fakeTransport struct {
+	reqs  int
+	serve func(try int) *http.Response
+}
+
+func (ft *fakeTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	ft.reqs++
+	return ft.serve(ft.reqs), nil
+}
+
+func fakeResponse(status int, hdr http.Header) *http.Response {
+	if hdr == nil {
+		hdr = make(http.Header)
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     hdr,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}
+}
+
+func TestLimitTransportRetries(t *testing.T) {
+	defer func(old time.Duration) { backoffBase = old }(backoffBase)
+	backoffBase = time.Millisecond
+
+	req, err := http.NewRequest("GET", "http://example.com/api", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A 503 with a Retry-After is retried, and the next try succeeds.
+	ft := &fakeTransport{serve: func(try int) *http.Response {
+		if try == 1 {
+			return fakeResponse(503, http.Header{"Retry-After": {"0"}})
+		}
+		return fakeResponse(200, nil)
+	}}
+	tr := &limitTransport{base: ft, maxTries: 3, timeout: time.Minute, name: "test"}
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 200 || ft.reqs != 2 {
+		t.Errorf("got status %d after %d requests; want 200 after 2", res.StatusCode, ft.reqs)
+	}
+
+	// A persistent 500 gives up after maxTries, returning the last response.
+	ft = &fakeTransport{serve: func(int) *http.Response { return fakeResponse(500, nil) }}
+	tr = &limitTransport{base: ft, maxTries: 3, timeout: time.Minute, name: "test"}
+	res, err = tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 500 || ft.reqs != 3 {
+		t.Errorf("got status %d after %d requests; want 500 after 3", res.StatusCode, ft.reqs)
+	}
+
+	// Requests with a body can't be replayed, so they aren't retried.
+	preq, err := http.NewRequest("POST", "http://example.com/api", strings.NewReader("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft = &fakeTransport{serve: func(int) *http.Response { return fakeResponse(500, nil) }}
+	tr = &limitTransport{base: ft, maxTries: 3, timeout: time.Minute, name: "test"}
+	res, err = tr.RoundTrip(preq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if ft.reqs != 1 {
+		t.Errorf("POST with body was tried %d times; want 1", ft.reqs)
+	}
+}
+
+func TestLimitTransportBucketBypass(t *testing.T) {
+	// An empty bucket that is never refilled: any rate-limited
+	// request would block forever.
+	bucket := &tokenBucket{tokens: make(chan struct{})}
+	ft := &fakeTransport{serve: func(int) *http.Response { return fakeResponse(200, nil) }}
+	tr := &limitTransport{
+		base:      ft,
+		bucket:    bucket,
+		limitFree: []string{"https://token.example.com/"},
+		maxTries:  1,
+		timeout:   time.Minute,
+		name:      "test",
+	}
+	req, err := http.NewRequest("GET", "https://token.example.com/refresh", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res.Body.Close()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request to a limit-free URL blocked on the rate limiter")
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picasa
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+
+	"camlistore.org/pkg/httputil"
+	"camlistore.org/pkg/importer"
+)
+
+const contactsFeedURL = "https://www.google.com/m8/feeds/contacts/default/full?alt=json&max-results=10000"
+
+var _ importer.ContactsFetcher = (*imp)(nil)
+
+// contactsFeed is the subset we care about of the Google Contacts
+// GData feed in its JSON form.
+type contactsFeed struct {
+	Feed struct {
+		Entry []struct {
+			ID    gdText `json:"id"`
+			Title gdText `json:"title"`
+			Email []struct {
+				Address string `json:"address"`
+				Primary string `json:"primary"`
+			} `json:"gd$email"`
+			Link []struct {
+				Rel  string `json:"rel"`
+				Href string `json:"href"`
+			} `json:"link"`
+		} `json:"entry"`
+	} `json:"feed"`
+}
+
+type gdText struct {
+	Text string `json:"$t"`
+}
+
+// FetchContacts implements importer.ContactsFetcher, reading the
+// account's Google Contacts feed. It relies on the authenticated HTTP
+// client that Run installs on the RunContext, so it only works as the
+// contacts pass of a run; accounts authorized before the contacts
+// scope was requested need a re-login first.
+func (im *imp) FetchContacts(ctx *importer.RunContext) ([]*importer.Contact, error) {
+	req, err := http.NewRequest("GET", contactsFeedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("GData-Version", "3.0")
+	res, err := ctx.HTTPClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching contacts feed: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("contacts feed returned status %s; re-login may be needed to grant the contacts scope", res.Status)
+	}
+	var feed contactsFeed
+	if err := httputil.DecodeJSON(res, &feed); err != nil {
+		return nil, fmt.Errorf("could not parse contacts feed: %v", err)
+	}
+	var contacts []*importer.Contact
+	for _, e := range feed.Feed.Entry {
+		// The entry ID is a URL whose last path element is the
+		// contact's stable ID.
+		id := path.Base(e.ID.Text)
+		if id == "" || id == "." {
+			continue
+		}
+		var email string
+		for _, ge := range e.Email {
+			if email == "" || ge.Primary == "true" {
+				email = ge.Address
+			}
+		}
+		var avatar string
+		for _, l := range e.Link {
+			if l.Rel == "http://schemas.google.com/contacts/2008/rel#photo" {
+				avatar = l.Href
+				break
+			}
+		}
+		contacts = append(contacts, &importer.Contact{
+			ID:        id,
+			Email:     email,
+			Name:      e.Title.Text,
+			AvatarURL: avatar,
+		})
+	}
+	return contacts, nil
+}
@@ -18,7 +18,9 @@ limitations under the License.
 package picasa
 
 import (
+	"crypto/md5"
 	"fmt"
+	"io"
 	"log"
 	"net/url"
 	"os"
@@ -43,6 +45,10 @@ const (
 	tokenURL = "https://accounts.google.com/o/oauth2/token"
 	scopeURL = "https://picasaweb.google.com/data/"
 
+	// contactsScopeURL is the read-only Google Contacts (GData)
+	// scope, for the contacts pass. See contacts.go.
+	contactsScopeURL = "https://www.google.com/m8/feeds"
+
 	// runCompleteVersion is a cache-busting version number of the
 	// importer code. It should be incremented whenever the
 	// behavior of this importer is updated enough to warrant a
@@ -65,7 +71,7 @@ type imp struct {
 var baseOAuthConfig = oauth.Config{
 	AuthURL:  authURL,
 	TokenURL: tokenURL,
-	Scope:    scopeURL,
+	Scope:    scopeURL + " " + contactsScopeURL,
 
 	// AccessType needs to be "offline", as the user is not here all the time;
 	// ApprovalPrompt needs to be "force" to be able to get a RefreshToken
@@ -152,9 +158,11 @@ func (im *imp) Run(ctx *importer.RunContext) error {
 	ocfg.ClientId, ocfg.ClientSecret = clientId, secret
 	token := decodeToken(acctNode.Attr(acctAttrOAuthToken))
 	transport := &oauth.Transport{
-		Config:    &ocfg,
-		Token:     &token,
-		Transport: notOAuthTransport(ctx.HTTPClient()),
+		Config: &ocfg,
+		Token:  &token,
+		// The token URL skips the rate limiter, so token refreshes
+		// aren't queued behind the API requests that need them.
+		Transport: ctx.LimitedTransport(notOAuthTransport(ctx.HTTPClient()), ocfg.TokenURL),
 	}
 	ctx.Context = ctx.Context.New(context.WithHTTPClient(transport.Client()))
 	r := &run{
@@ -248,12 +256,14 @@ func (r *run) importAlbum(albumsNode *importer.Object, album picago.Album) error
 						log.Printf("No dateModified on %s, re-import.", refString)
 					case schema.RFC3339FromTime(photo.Updated):
 						// Assume we have this photo already and don't need to refetch.
+						r.Skip(idFilename, importer.SkipReasonUnchanged)
 						continue
 					default: // modtimes differ - import again
 						switch filepath.Ext(photo.Filename()) {
 						case ".mp4", ".m4v":
 							// photo is a video, cannot rely on its modtime, so not importing again.
 							// TODO(bradfitz): why is that comment true?
+							r.Skip(idFilename, importer.SkipReasonUnsupportedMedia)
 							continue
 						default:
 							log.Printf("photo %s imported(%s) != remote(%s), so importing again",
@@ -286,7 +296,12 @@ func (r *run) importPhoto(albumNode *importer.Object, photo picago.Photo) (*impo
 		return nil, fmt.Errorf("importPhoto: DownloadPhoto error: %v", err)
 	}
 	defer body.Close()
-	fileRef, err := schema.WriteFileFromReader(r.Host.Target(), photo.Filename(), body)
+	h := md5.New()
+	fileRef, err := schema.WriteFileFromReaderWithOpts(r.Host.Target(), photo.Filename(), io.TeeReader(body, h),
+		schema.WriteFileOptions{
+			ModTime:  photo.Published,
+			MIMEType: photo.Type,
+		})
 	if err != nil {
 		return nil, fmt.Errorf("error writing file: %v", err)
 	}
@@ -308,6 +323,24 @@ func (r *run) importPhoto(albumNode *importer.Object, photo picago.Photo) (*impo
 		importer.AttrLocationText, photo.Location,
 		"dateModified", schema.RFC3339FromTime(photo.Updated),
 		"datePublished", schema.RFC3339FromTime(photo.Published),
+		// Provenance. picago doesn't expose the photo's alternate
+		// (page) link, so the download URL stands in for both.
+		nodeattr.URL, photo.URL,
+		importer.AttrSourceDownloadURL, photo.URL,
+	}
+	// Picasa reports the EXIF imageUniqueID, an MD5, for most
+	// photos; compare it against the bytes we actually fetched.
+	if uid := strings.ToLower(photo.Exif.UID); uid != "" {
+		sum := fmt.Sprintf("%x", h.Sum(nil))
+		verified := uid == sum
+		if !verified {
+			log.Printf("Picasa importer: checksum mismatch on %s: %s reported md5-%s; fetched bytes are md5-%s",
+				photo.URL, photo.ID, uid, sum)
+		}
+		attrs = append(attrs,
+			importer.AttrSourceChecksum, "md5-"+uid,
+			importer.AttrChecksumVerified, strconv.FormatBool(verified),
+		)
 	}
 	if photo.Latitude != 0 || photo.Longitude != 0 {
 		attrs = append(attrs,
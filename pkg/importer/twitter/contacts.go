@@ -0,0 +1,107 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package twitter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"camlistore.org/pkg/importer"
+	"camlistore.org/third_party/github.com/garyburd/go-oauth/oauth"
+)
+
+const friendsListAPIPath = "friends/list.json"
+
+var _ importer.ContactsFetcher = (*imp)(nil)
+
+// apiUser is a user object of the REST API, as returned by
+// friends/list.json.
+type apiUser struct {
+	ID           string `json:"id_str"`
+	ScreenName   string `json:"screen_name"`
+	Name         string `json:"name"`
+	ProfileImage string `json:"profile_image_url_https"`
+}
+
+type friendsList struct {
+	Users      []*apiUser `json:"users"`
+	NextCursor string     `json:"next_cursor_str"`
+}
+
+// FetchContacts implements importer.ContactsFetcher, returning the
+// accounts the user follows.
+func (im *imp) FetchContacts(ctx *importer.RunContext) ([]*importer.Contact, error) {
+	octx, err := im.acctOAuthContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var contacts []*importer.Contact
+	for cursor := "-1"; cursor != "" && cursor != "0"; {
+		var resp friendsList
+		if err := octx.doAPI(&resp, friendsListAPIPath, "count", "200", "cursor", cursor); err != nil {
+			return nil, err
+		}
+		for _, u := range resp.Users {
+			contacts = append(contacts, &importer.Contact{
+				ID:         u.ID,
+				Name:       u.Name,
+				UserName:   u.ScreenName,
+				ProfileURL: "https://twitter.com/" + u.ScreenName,
+				// The _normal suffix is a 48x48 thumbnail;
+				// drop it for the full-size avatar.
+				AvatarURL: strings.Replace(u.ProfileImage, "_normal", "", 1),
+			})
+		}
+		if len(resp.Users) == 0 {
+			break
+		}
+		cursor = resp.NextCursor
+	}
+	return contacts, nil
+}
+
+// acctOAuthContext builds an oauthContext from the account node's
+// stored credentials, for API calls made outside a run.
+func (im *imp) acctOAuthContext(ctx *importer.RunContext) (oauthContext, error) {
+	clientId, secret, err := ctx.Credentials()
+	if err != nil {
+		return oauthContext{}, fmt.Errorf("no API credentials: %v", err)
+	}
+	acctNode := ctx.AccountNode()
+	accessToken := acctNode.Attr(importer.AcctAttrAccessToken)
+	accessSecret := acctNode.Attr(importer.AcctAttrAccessTokenSecret)
+	if accessToken == "" || accessSecret == "" {
+		return oauthContext{}, errors.New("access credentials not found")
+	}
+	return oauthContext{
+		Context: ctx.Context,
+		client: &oauth.Client{
+			TemporaryCredentialRequestURI: temporaryCredentialRequestURL,
+			ResourceOwnerAuthorizationURI: resourceOwnerAuthorizationURL,
+			TokenRequestURI:               tokenRequestURL,
+			Credentials: oauth.Credentials{
+				Token:  clientId,
+				Secret: secret,
+			},
+		},
+		creds: &oauth.Credentials{
+			Token:  accessToken,
+			Secret: accessSecret,
+		},
+	}, nil
+}
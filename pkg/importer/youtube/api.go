@@ -0,0 +1,199 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Types and client helpers for the YouTube Data API v3.
+// Reference: https://developers.google.com/youtube/v3/docs/
+
+package youtube
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"camlistore.org/pkg/context"
+	"camlistore.org/pkg/httputil"
+)
+
+type channelList struct {
+	Etag  string
+	Items []*channelItem
+}
+
+type channelItem struct {
+	Id      string
+	Snippet struct {
+		Title string
+	}
+	ContentDetails struct {
+		RelatedPlaylists struct {
+			// Uploads is the ID of the auto-generated playlist
+			// containing all of the channel's uploaded videos.
+			Uploads string
+		}
+	}
+}
+
+type playlistItemList struct {
+	Etag          string
+	NextPageToken string
+	Items         []*playlistItem
+}
+
+type playlistItem struct {
+	Snippet struct {
+		Title       string
+		Description string
+		PublishedAt string // RFC 3339
+		PlaylistId  string
+		Position    int64 // zero-based position within the playlist
+		ResourceId  struct {
+			Kind    string // e.g. "youtube#video"
+			VideoId string
+		}
+	}
+}
+
+type playlistList struct {
+	NextPageToken string
+	Items         []*playlistEntry
+}
+
+type playlistEntry struct {
+	Id      string
+	Snippet struct {
+		Title       string
+		Description string
+		PublishedAt string // RFC 3339
+	}
+}
+
+type videoList struct {
+	Items []*videoItem
+}
+
+type videoItem struct {
+	Id      string
+	Snippet struct {
+		Title       string
+		Description string
+		PublishedAt string // RFC 3339
+		Tags        []string
+	}
+	ContentDetails struct {
+		Duration string // ISO 8601, e.g. "PT4M13S"
+	}
+}
+
+// apiError is the error document the API returns with non-2xx statuses.
+type apiError struct {
+	Error struct {
+		Code    int
+		Message string
+		Errors  []struct {
+			Reason string
+		}
+	}
+}
+
+// quotaExceeded reports whether e is one of the errors the API returns
+// when the daily quota or the per-user rate limit has been hit.
+func (e *apiError) quotaExceeded() bool {
+	for _, ee := range e.Error.Errors {
+		switch ee.Reason {
+		case "quotaExceeded", "dailyLimitExceeded", "userRateLimitExceeded", "rateLimitExceeded":
+			return true
+		}
+	}
+	return false
+}
+
+// errNotModified is returned by getJSON when a conditional request
+// (If-None-Match with a previous ETag) came back 304 Not Modified.
+var errNotModified = errors.New("youtube: resource not modified")
+
+const (
+	// The Data API's quota is tight (and shared with anything else
+	// using the project's key), so on a quota error we back off hard
+	// before retrying, doubling up to quotaMaxRetries times.
+	quotaBaseDelay  = 30 * time.Second
+	quotaMaxRetries = 5
+)
+
+// getJSON runs a GET request against the given API path (relative to
+// apiURL) with the given URL parameters and decodes the response into
+// result. If etag is non-empty it is sent as If-None-Match, and a 304
+// response is reported as errNotModified. The returned newETag is the
+// response's ETag header, when present.
+//
+// Quota errors are retried with exponential backoff; other API errors
+// are returned to the caller.
+func getJSON(ctx *context.Context, result interface{}, apiPath, etag string, keyval ...string) (newETag string, err error) {
+	if len(keyval)%2 == 1 {
+		panic("Incorrect number of keyval arguments")
+	}
+	form := url.Values{}
+	for i := 0; i < len(keyval); i += 2 {
+		if keyval[i+1] != "" {
+			form.Set(keyval[i], keyval[i+1])
+		}
+	}
+	fullURL := apiURL + apiPath + "?" + form.Encode()
+
+	delay := quotaBaseDelay
+	for try := 0; ; try++ {
+		req, err := http.NewRequest("GET", fullURL, nil)
+		if err != nil {
+			return "", err
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		res, err := ctx.HTTPClient().Do(req)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case res.StatusCode == http.StatusNotModified:
+			res.Body.Close()
+			return "", errNotModified
+		case res.StatusCode == http.StatusOK:
+			newETag = res.Header.Get("Etag")
+			return newETag, httputil.DecodeJSON(res, result)
+		}
+		var apiErr apiError
+		json.NewDecoder(res.Body).Decode(&apiErr)
+		res.Body.Close()
+		if res.StatusCode == http.StatusForbidden && apiErr.quotaExceeded() && try < quotaMaxRetries {
+			log.Printf("youtube: quota exceeded on %s; backing off %v before retrying", apiPath, delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return "", context.ErrCanceled
+			}
+			delay *= 2
+			continue
+		}
+		if apiErr.Error.Message != "" {
+			return "", fmt.Errorf("youtube: API request %s failed: %s (%s)", apiPath, res.Status, apiErr.Error.Message)
+		}
+		return "", fmt.Errorf("youtube: API request %s failed: %s", apiPath, res.Status)
+	}
+}
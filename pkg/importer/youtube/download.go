@@ -0,0 +1,146 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package youtube
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"camlistore.org/pkg/context"
+)
+
+// The Data API has no method to download a video's bytes; the
+// get_video_info endpoint (the same one the Flash and HTML5 players
+// use) reports the available streams and their URLs.
+const videoInfoURL = "https://www.youtube.com/get_video_info"
+
+// errNotDownloadable is returned by bestStream when no stream of the
+// video can be fetched: the video is private, region-locked, uses
+// ciphered stream signatures, or get_video_info otherwise declines to
+// hand out a URL.
+var errNotDownloadable = errors.New("youtube: video has no downloadable stream")
+
+// stream describes one downloadable encoding of a video.
+type stream struct {
+	url      string
+	mimeType string // e.g. "video/mp4"; container only, codecs stripped
+	quality  string // e.g. "hd720", "medium"
+}
+
+// qualityRank orders get_video_info's quality labels, best first.
+var qualityRank = map[string]int{
+	"highres": 7, // 4K ("original")
+	"hd1080":  6,
+	"hd720":   5,
+	"large":   4, // 480p
+	"medium":  3, // 360p
+	"small":   2, // 240p
+}
+
+// bestStream asks get_video_info about videoID and returns the
+// highest-quality stream it is willing to let us download. It returns
+// errNotDownloadable if the video can't be fetched at all.
+func bestStream(ctx *context.Context, videoID string) (*stream, error) {
+	infoURL := videoInfoURL + "?" + url.Values{"video_id": {videoID}}.Encode()
+	res, err := ctx.HTTPClient().Get(infoURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("youtube: get_video_info for %s failed: %s", videoID, res.Status)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	info, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("youtube: parsing get_video_info response for %s: %v", videoID, err)
+	}
+	if info.Get("status") != "ok" {
+		// "fail" with an errorcode: private, deleted, region-locked, etc.
+		return nil, errNotDownloadable
+	}
+	if info.Get("use_cipher_signature") == "True" {
+		// Ciphered signatures need the player's Javascript to
+		// decode; don't even try.
+		return nil, errNotDownloadable
+	}
+
+	var best *stream
+	bestRank := 0
+	for _, enc := range strings.Split(info.Get("url_encoded_fmt_stream_map"), ",") {
+		v, err := url.ParseQuery(enc)
+		if err != nil {
+			continue
+		}
+		streamURL := v.Get("url")
+		if streamURL == "" {
+			continue
+		}
+		if v.Get("s") != "" {
+			// Ciphered per-stream signature; can't use this one.
+			continue
+		}
+		if sig := v.Get("sig"); sig != "" {
+			streamURL += "&signature=" + url.QueryEscape(sig)
+		}
+		rank := qualityRank[v.Get("quality")]
+		if best != nil && rank <= bestRank {
+			continue
+		}
+		mimeType := v.Get("type") // e.g. `video/mp4; codecs="avc1.42001E, mp4a.40.2"`
+		if i := strings.Index(mimeType, ";"); i >= 0 {
+			mimeType = strings.TrimSpace(mimeType[:i])
+		}
+		best = &stream{
+			url:      streamURL,
+			mimeType: mimeType,
+			quality:  v.Get("quality"),
+		}
+		bestRank = rank
+	}
+	if best == nil {
+		return nil, errNotDownloadable
+	}
+	return best, nil
+}
+
+// streamExt maps a stream's MIME type to a filename extension.
+var streamExt = map[string]string{
+	"video/mp4":    ".mp4",
+	"video/webm":   ".webm",
+	"video/x-flv":  ".flv",
+	"video/3gpp":   ".3gp",
+	"video/3gpp2":  ".3g2",
+	"video/x-m4v":  ".m4v",
+	"video/ms-wmv": ".wmv",
+}
+
+// ext returns the filename extension for the stream's container
+// format, defaulting to ".mp4".
+func (s *stream) ext() string {
+	if ext, ok := streamExt[s.mimeType]; ok {
+		return ext
+	}
+	return ".mp4"
+}
@@ -0,0 +1,486 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package youtube implements an importer for the authenticated user's
+// own YouTube uploads and playlists, via the YouTube Data API v3.
+package youtube
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/context"
+	"camlistore.org/pkg/importer"
+	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/schema/nodeattr"
+
+	"camlistore.org/third_party/code.google.com/p/goauth2/oauth"
+)
+
+const (
+	apiURL   = "https://www.googleapis.com/youtube/v3/"
+	authURL  = "https://accounts.google.com/o/oauth2/auth"
+	tokenURL = "https://accounts.google.com/o/oauth2/token"
+	scopeURL = "https://www.googleapis.com/auth/youtube.readonly"
+
+	// runCompleteVersion is a cache-busting version number of the
+	// importer code. It should be incremented whenever the
+	// behavior of this importer is updated enough to warrant a
+	// complete run.  Otherwise, if the importer runs to
+	// completion, this version number is recorded on the account
+	// permanode and subsequent importers can stop early.
+	runCompleteVersion = "1"
+
+	// attrYouTubeId is the video or playlist ID on permanodes
+	// created by this importer.
+	attrYouTubeId = "youtubeId"
+
+	// attrNotDownloadable is set to "true" on video permanodes
+	// whose bytes we couldn't fetch (private, region-locked,
+	// ciphered stream signatures, ...). The metadata is still
+	// imported; a later run with better luck clears the flag.
+	attrNotDownloadable = "notDownloadable"
+
+	// acctAttrUploadsETag is the ETag of the first page of the
+	// channel's uploads playlist as of the last completed run,
+	// stored on the account permanode. If it hasn't changed,
+	// incremental runs skip the uploads pass entirely.
+	acctAttrUploadsETag = "youtubeUploadsETag"
+)
+
+func init() {
+	importer.Register("youtube", newImporter())
+}
+
+var _ importer.ImporterSetupHTMLer = (*imp)(nil)
+
+type imp struct {
+	extendedOAuth2
+}
+
+var baseOAuthConfig = oauth.Config{
+	AuthURL:  authURL,
+	TokenURL: tokenURL,
+	Scope:    scopeURL,
+
+	// AccessType needs to be "offline", as the user is not here all the time;
+	// ApprovalPrompt needs to be "force" to be able to get a RefreshToken
+	// everytime, even for Re-logins, too.
+	//
+	// Source: https://developers.google.com/youtube/v3/guides/authentication#server-side-apps
+	AccessType:     "offline",
+	ApprovalPrompt: "force",
+}
+
+func newImporter() *imp {
+	return &imp{
+		newExtendedOAuth2(
+			baseOAuthConfig,
+			func(ctx *context.Context) (*userInfo, error) {
+				channel, err := getChannel(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return &userInfo{
+					ID:        channel.Id,
+					FirstName: channel.Snippet.Title,
+				}, nil
+			}),
+	}
+}
+
+func (*imp) AccountSetupHTML(host *importer.Host) string {
+	// YouTube doesn't allow a path in the origin. Remove it.
+	origin := host.ImporterBaseURL()
+	if u, err := url.Parse(origin); err == nil {
+		u.Path = ""
+		origin = u.String()
+	}
+
+	callback := host.ImporterBaseURL() + "youtube/callback"
+	return fmt.Sprintf(`
+<h1>Configuring YouTube</h1>
+<p>Visit <a href='https://console.developers.google.com/'>https://console.developers.google.com/</a>
+and click <b>"Create Project"</b>.</p>
+<p>Then under "APIs & Auth" in the left sidebar, click on "APIs" and enable the <b>"YouTube Data API v3"</b>.</p>
+<p>Click on "Credentials", then click the button <b>"Create new Client ID"</b>.</p>
+<p>Use the following settings:</p>
+<ul>
+  <li>Web application</li>
+  <li>Authorized JavaScript origins: <b>%s</b></li>
+  <li>Authorized Redirect URI: <b>%s</b></li>
+</ul>
+<p>Click "Create Client ID".  Copy the "Client ID" and "Client Secret" into the boxes above.</p>
+`, origin, callback)
+}
+
+// getChannel returns the authenticated user's own channel.
+func getChannel(ctx *context.Context) (*channelItem, error) {
+	var channels channelList
+	if _, err := getJSON(ctx, &channels, "channels", "",
+		"part", "snippet,contentDetails",
+		"mine", "true"); err != nil {
+		return nil, err
+	}
+	if len(channels.Items) == 0 {
+		return nil, errors.New("youtube: no channel for the authenticated user")
+	}
+	return channels.Items[0], nil
+}
+
+// A run is our state for a given run of the importer.
+type run struct {
+	*importer.RunContext
+	im          *imp
+	incremental bool // whether we've completed a run in the past
+
+	mu     sync.Mutex // guards anyErr
+	anyErr bool
+}
+
+func (r *run) errorf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.anyErr = true
+}
+
+var forceFullImport, _ = strconv.ParseBool(os.Getenv("CAMLI_YOUTUBE_FULL_IMPORT"))
+
+func (im *imp) Run(ctx *importer.RunContext) error {
+	clientId, secret, err := ctx.Credentials()
+	if err != nil {
+		return err
+	}
+	acctNode := ctx.AccountNode()
+	ocfg := baseOAuthConfig
+	ocfg.ClientId, ocfg.ClientSecret = clientId, secret
+	token := decodeToken(acctNode.Attr(acctAttrOAuthToken))
+	transport := &oauth.Transport{
+		Config: &ocfg,
+		Token:  &token,
+		// The token URL skips the rate limiter, so token refreshes
+		// aren't queued behind the API requests that need them.
+		Transport: ctx.LimitedTransport(notOAuthTransport(ctx.HTTPClient()), ocfg.TokenURL),
+	}
+	ctx.Context = ctx.Context.New(context.WithHTTPClient(transport.Client()))
+	r := &run{
+		RunContext:  ctx,
+		im:          im,
+		incremental: !forceFullImport && acctNode.Attr(importer.AcctAttrCompletedVersion) == runCompleteVersion,
+	}
+	channel, err := getChannel(r.Context)
+	if err != nil {
+		return err
+	}
+	uploadsETag, err := r.importUploads(channel)
+	if err != nil {
+		return err
+	}
+	if err := r.importPlaylists(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	anyErr := r.anyErr
+	r.mu.Unlock()
+	if !anyErr {
+		if err := acctNode.SetAttrs(
+			importer.AcctAttrCompletedVersion, runCompleteVersion,
+			acctAttrUploadsETag, uploadsETag,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// importUploads walks the channel's auto-generated uploads playlist
+// and imports each video. It returns the ETag of the playlist's first
+// page, which is recorded on the account node after a clean run: when
+// nothing has been uploaded (or removed) since, the next incremental
+// run's conditional request comes back 304 and the whole pass is
+// skipped. Each API page costs quota, so that matters.
+func (r *run) importUploads(channel *channelItem) (uploadsETag string, err error) {
+	uploadsID := channel.ContentDetails.RelatedPlaylists.Uploads
+	if uploadsID == "" {
+		return "", errors.New("importUploads: channel has no uploads playlist")
+	}
+	videosNode, err := r.getTopLevelNode("videos", "Videos")
+	if err != nil {
+		return "", err
+	}
+
+	prevETag := ""
+	if r.incremental {
+		prevETag = r.AccountNode().Attr(acctAttrUploadsETag)
+	}
+	pageToken := ""
+	for {
+		var page playlistItemList
+		etag := ""
+		if pageToken == "" {
+			etag = prevETag
+		}
+		newETag, err := getJSON(r.Context, &page, "playlistItems", etag,
+			"part", "snippet",
+			"playlistId", uploadsID,
+			"maxResults", "50",
+			"pageToken", pageToken)
+		if err == errNotModified {
+			log.Printf("youtube: uploads playlist unchanged since last run; skipping")
+			return prevETag, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("importUploads: error listing uploads: %v", err)
+		}
+		if pageToken == "" {
+			uploadsETag = newETag
+		}
+		for _, item := range page.Items {
+			if r.Context.IsCanceled() {
+				return "", context.ErrCanceled
+			}
+			videoID := item.Snippet.ResourceId.VideoId
+			if videoID == "" {
+				continue
+			}
+			attr := "camliPath:" + videoID
+			if r.incremental && videosNode.Attr(attr) != "" {
+				r.Skip(videoID, importer.SkipReasonUnchanged)
+				continue
+			}
+			videoNode, err := r.importVideo(videoID)
+			if err != nil {
+				r.errorf("youtube: error importing video %s: %v", videoID, err)
+				continue
+			}
+			if err := videosNode.SetAttr(attr, videoNode.PermanodeRef().String()); err != nil {
+				r.errorf("youtube: error adding video %s to videos node: %v", videoID, err)
+				continue
+			}
+		}
+		pageToken = page.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+	return uploadsETag, nil
+}
+
+// importVideo fetches the video's metadata and (when possible) its
+// highest-quality stream, and records both on a permanode.
+func (r *run) importVideo(videoID string) (*importer.Object, error) {
+	var videos videoList
+	if _, err := getJSON(r.Context, &videos, "videos", "",
+		"part", "snippet,contentDetails",
+		"id", videoID); err != nil {
+		return nil, err
+	}
+	if len(videos.Items) == 0 {
+		return nil, fmt.Errorf("importVideo: video %s not found", videoID)
+	}
+	video := videos.Items[0]
+	log.Printf("youtube: importing video %s: %q", videoID, video.Snippet.Title)
+
+	videoNode, err := r.Host.NewObject()
+	if err != nil {
+		return nil, fmt.Errorf("error creating video permanode for %s: %v", videoID, err)
+	}
+
+	attrs := []string{
+		nodeattr.Type, "youtube.com:video",
+		attrYouTubeId, videoID,
+		nodeattr.Title, video.Snippet.Title,
+		nodeattr.Description, video.Snippet.Description,
+		"datePublished", video.Snippet.PublishedAt,
+		// ISO 8601, as on http://schema.org/VideoObject
+		"duration", video.ContentDetails.Duration,
+		nodeattr.URL, "https://www.youtube.com/watch?v=" + videoID,
+	}
+
+	st, err := bestStream(r.Context, videoID)
+	switch err {
+	case nil:
+		fileRef, err := r.downloadStream(st, video)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs,
+			nodeattr.CamliContent, fileRef.String(),
+			importer.AttrSourceDownloadURL, st.url,
+		)
+	case errNotDownloadable:
+		log.Printf("youtube: video %s has no downloadable stream; importing metadata only", videoID)
+		attrs = append(attrs, attrNotDownloadable, "true")
+	default:
+		return nil, err
+	}
+
+	if err := videoNode.SetAttrs(attrs...); err != nil {
+		return nil, fmt.Errorf("error setting video attributes: %v", err)
+	}
+	if err := videoNode.SetAttrValues("tag", video.Snippet.Tags); err != nil {
+		return nil, fmt.Errorf("error setting videoNode's tags: %v", err)
+	}
+	return videoNode, nil
+}
+
+// downloadStream fetches the stream's bytes and writes them as a file
+// schema blob, named after the video's title.
+func (r *run) downloadStream(st *stream, video *videoItem) (fileRef blob.Ref, err error) {
+	res, err := r.Context.HTTPClient().Get(st.url)
+	if err != nil {
+		return blob.Ref{}, fmt.Errorf("downloadStream: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return blob.Ref{}, fmt.Errorf("downloadStream: stream fetch for %s failed: %s", video.Id, res.Status)
+	}
+	filename := video.Snippet.Title + st.ext()
+	var modTime time.Time
+	if t, err := time.Parse(time.RFC3339, video.Snippet.PublishedAt); err == nil {
+		modTime = t
+	}
+	fileRef, err = schema.WriteFileFromReaderWithOpts(r.Host.Target(), filename, res.Body,
+		schema.WriteFileOptions{
+			ModTime:  modTime,
+			MIMEType: st.mimeType,
+		})
+	if err != nil {
+		return blob.Ref{}, fmt.Errorf("error writing video file: %v", err)
+	}
+	return fileRef, nil
+}
+
+// importPlaylists imports the user's own playlists as permanodes whose
+// camliPath members point at the (already imported) video permanodes,
+// ordered by the videos' positions within the playlist.
+func (r *run) importPlaylists() error {
+	playlistsNode, err := r.getTopLevelNode("playlists", "Playlists")
+	if err != nil {
+		return err
+	}
+	videosNode, err := r.getTopLevelNode("videos", "Videos")
+	if err != nil {
+		return err
+	}
+	pageToken := ""
+	for {
+		var page playlistList
+		if _, err := getJSON(r.Context, &page, "playlists", "",
+			"part", "snippet",
+			"mine", "true",
+			"maxResults", "50",
+			"pageToken", pageToken); err != nil {
+			return fmt.Errorf("importPlaylists: error listing playlists: %v", err)
+		}
+		for _, playlist := range page.Items {
+			if r.Context.IsCanceled() {
+				return context.ErrCanceled
+			}
+			if err := r.importPlaylist(playlistsNode, videosNode, playlist); err != nil {
+				r.errorf("youtube: error importing playlist %s: %v", playlist.Id, err)
+			}
+		}
+		pageToken = page.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+	return nil
+}
+
+func (r *run) importPlaylist(playlistsNode, videosNode *importer.Object, playlist *playlistEntry) error {
+	playlistNode, err := playlistsNode.ChildPathObject(playlist.Id)
+	if err != nil {
+		return err
+	}
+	if err := playlistNode.SetAttrs(
+		nodeattr.Type, "youtube.com:playlist",
+		attrYouTubeId, playlist.Id,
+		nodeattr.Title, playlist.Snippet.Title,
+		nodeattr.Description, playlist.Snippet.Description,
+		"datePublished", playlist.Snippet.PublishedAt,
+		nodeattr.URL, "https://www.youtube.com/playlist?list="+playlist.Id,
+	); err != nil {
+		return fmt.Errorf("error setting playlist attributes: %v", err)
+	}
+
+	pageToken := ""
+	for {
+		var page playlistItemList
+		if _, err := getJSON(r.Context, &page, "playlistItems", "",
+			"part", "snippet",
+			"playlistId", playlist.Id,
+			"maxResults", "50",
+			"pageToken", pageToken); err != nil {
+			return fmt.Errorf("error listing items of playlist %s: %v", playlist.Id, err)
+		}
+		for _, item := range page.Items {
+			if r.Context.IsCanceled() {
+				return context.ErrCanceled
+			}
+			videoID := item.Snippet.ResourceId.VideoId
+			if videoID == "" {
+				continue
+			}
+			videoRef := videosNode.Attr("camliPath:" + videoID)
+			if videoRef == "" {
+				// Not one of ours: playlists may contain other
+				// people's videos, which we don't import. Record
+				// the membership as a plain attribute so the
+				// ordering isn't lost.
+				attr := fmt.Sprintf("youtubeVideoId:%05d", item.Snippet.Position)
+				if err := playlistNode.SetAttr(attr, videoID); err != nil {
+					return fmt.Errorf("error recording video %s on playlist %s: %v", videoID, playlist.Id, err)
+				}
+				continue
+			}
+			// The zero-padded position keeps the playlist's order
+			// under the lexical sorting of camliPath members.
+			attr := fmt.Sprintf("camliPath:%05d-%s", item.Snippet.Position, videoID)
+			if err := playlistNode.SetAttr(attr, videoRef); err != nil {
+				return fmt.Errorf("error adding video %s to playlist %s: %v", videoID, playlist.Id, err)
+			}
+		}
+		pageToken = page.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+	return nil
+}
+
+func (r *run) getTopLevelNode(path string, title string) (*importer.Object, error) {
+	childObject, err := r.RootNode().ChildPathObject(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := childObject.SetAttr(nodeattr.Title, title); err != nil {
+		return nil, err
+	}
+	return childObject, nil
+}
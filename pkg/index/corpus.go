@@ -73,6 +73,10 @@ type Corpus struct {
 	fileWholeRef map[blob.Ref]blob.Ref           // fileref -> its wholeref (TODO: multi-valued?)
 	gps          map[blob.Ref]latLong            // wholeRef -> GPS coordinates
 
+	// imagePreview is keyed by fileref. See images.TinyPreview.
+	imagePreview    map[blob.Ref]string
+	sumPreviewBytes int64 // bytes of imagePreview values
+
 	// edge tracks "forward" edges. e.g. from a directory's static-set to
 	// its members. Permanodes' camliMembers aren't tracked, since they
 	// can be obtained from permanodes.Claims.
@@ -94,6 +98,11 @@ type Corpus struct {
 	// The slice is not sorted.
 	claimBack map[blob.Ref][]*camtypes.Claim
 
+	// claims holds all claims, sorted by (date, claim blobref).
+	// It's the data behind the activity feed iteration API, so
+	// deleted and superseded claims stay in it.
+	claims []*camtypes.Claim
+
 	// TOOD: use deletedCache instead?
 	deletedBy map[blob.Ref]blob.Ref // key is deleted by value
 	// deletes tracks deletions of claims and permanodes. The key is
@@ -155,6 +164,7 @@ func newCorpus() *Corpus {
 		files:        make(map[blob.Ref]camtypes.FileInfo),
 		permanodes:   make(map[blob.Ref]*PermanodeMeta),
 		imageInfo:    make(map[blob.Ref]camtypes.ImageInfo),
+		imagePreview: make(map[blob.Ref]string),
 		deletedBy:    make(map[blob.Ref]blob.Ref),
 		keyId:        make(map[blob.Ref]string),
 		brOfStr:      make(map[string]blob.Ref),
@@ -218,6 +228,7 @@ var corpusMergeFunc = map[string]func(c *Corpus, k, v []byte) error{
 	"fileinfo":        (*Corpus).mergeFileInfoRow,
 	"filetimes":       (*Corpus).mergeFileTimesRow,
 	"imagesize":       (*Corpus).mergeImageSizeRow,
+	"imagepreview":    (*Corpus).mergeImagePreviewRow,
 	"wholetofile":     (*Corpus).mergeWholeToFileRow,
 	"exifgps":         (*Corpus).mergeEXIFGPSRow,
 	"exiftag":         nil, // not using any for now
@@ -241,6 +252,7 @@ var slurpPrefixes = []string{
 	"fileinfo|",
 	"filetimes|",
 	"imagesize|",
+	"imagepreview|",
 	"wholetofile|",
 	"exifgps|",
 	"mediatag|",
@@ -289,6 +301,7 @@ func (c *Corpus) scanFromStorage(s sorted.KeyValue) error {
 	}
 
 	// Post-load optimizations and restoration of invariants.
+	sort.Sort(claimPtrsByDateAndRef(c.claims))
 	for _, pm := range c.permanodes {
 		// Restore invariants violated during building:
 		sort.Sort(camtypes.ClaimPtrsByDate(pm.Claims))
@@ -317,14 +330,16 @@ func (c *Corpus) scanFromStorage(s sorted.KeyValue) error {
 		if ms1.Alloc < ms0.Alloc {
 			memUsed = 0
 		}
-		log.Printf("Corpus stats: %.3f MiB mem: %d blobs (%.3f GiB) (%d schema (%d permanode, %d file (%d image), ...)",
+		log.Printf("Corpus stats: %.3f MiB mem: %d blobs (%.3f GiB) (%d schema (%d permanode, %d file (%d image, %d preview (%.3f KiB)), ...)",
 			float64(memUsed)/(1<<20),
 			len(c.blobs),
 			float64(c.sumBlobBytes)/(1<<30),
 			c.numSchemaBlobsLocked(),
 			len(c.permanodes),
 			len(c.files),
-			len(c.imageInfo))
+			len(c.imageInfo),
+			len(c.imagePreview),
+			float64(c.sumPreviewBytes)/(1<<10))
 		log.Printf("Corpus scanning CPU usage: %v", cpu)
 	}
 
@@ -493,6 +508,7 @@ func (c *Corpus) mergeClaimRow(k, v []byte) error {
 		// the end instead), keep this sorted.
 		sort.Sort(camtypes.ClaimPtrsByDate(pm.Claims))
 	}
+	c.insertClaimLocked(&cl)
 
 	if vbr, ok := blob.Parse(cl.Value); ok {
 		c.claimBack[vbr] = append(c.claimBack[vbr], &cl)
@@ -568,6 +584,17 @@ func (c *Corpus) mergeImageSizeRow(k, v []byte) error {
 	return nil
 }
 
+// "imagepreview|sha1-xxxx" -> "8e8e8e..." (from images.TinyPreview)
+func (c *Corpus) mergeImagePreviewRow(k, v []byte) error {
+	br, ok := blob.ParseBytes(k[len("imagepreview|"):])
+	if !ok {
+		return fmt.Errorf("bogus row %q = %q", k, v)
+	}
+	c.imagePreview[c.br(br)] = string(v)
+	c.sumPreviewBytes += int64(len(v))
+	return nil
+}
+
 // "wholetofile|sha1-17b53c7c3e664d3613dfdce50ef1f2a09e8f04b5|sha1-fb88f3eab3acfcf3cfc8cd77ae4366f6f975d227" -> "1"
 func (c *Corpus) mergeWholeToFileRow(k, v []byte) error {
 	pair := k[len("wholetofile|"):]
@@ -991,6 +1018,11 @@ func (c *Corpus) PermanodeModtimeLocked(pn blob.Ref) (t time.Time, ok bool) {
 
 // AppendPermanodeAttrValues appends to dst all the values for the attribute
 // attr set on permaNode.
+// The values of a multi-valued attribute are in a stable order: the
+// order of their add-attribute claims, as defined by
+// camtypes.ClaimPtrsByDate. Deleting a value preserves the relative
+// order of the values that remain; re-adding a deleted value moves it
+// to the end.
 // signerFilter is optional.
 // dst must start with length 0 (laziness, mostly)
 func (c *Corpus) AppendPermanodeAttrValues(dst []string,
@@ -1086,6 +1118,40 @@ func (c *Corpus) AppendPermanodeAttrValuesLocked(dst []string,
 	return dst
 }
 
+// PermanodeAttrChangedLocked reports whether permaNode has at least
+// one set/add/del-attribute claim strictly newer than since touching
+// the attribute attr (or any attribute, if attr is empty), and
+// returns the time of the latest such claim.
+func (c *Corpus) PermanodeAttrChangedLocked(permaNode blob.Ref, attr string, since time.Time) (latest time.Time, ok bool) {
+	pm, ok := c.permanodes[permaNode]
+	if !ok {
+		return latest, false
+	}
+	// Claims are sorted by date; walk backwards and stop at the
+	// first claim at or before since.
+	for i := len(pm.Claims) - 1; i >= 0; i-- {
+		cl := pm.Claims[i]
+		if !cl.Date.After(since) {
+			break
+		}
+		switch cl.Type {
+		case string(schema.SetAttributeClaim),
+			string(schema.AddAttributeClaim),
+			string(schema.DelAttributeClaim):
+		default:
+			continue
+		}
+		if attr != "" && cl.Attr != attr {
+			continue
+		}
+		if c.IsDeletedLocked(cl.BlobRef) {
+			continue
+		}
+		return cl.Date, true
+	}
+	return latest, false
+}
+
 func (c *Corpus) AppendClaims(dst []camtypes.Claim, permaNode blob.Ref,
 	signerFilter blob.Ref,
 	attrFilter string) ([]camtypes.Claim, error) {
@@ -1110,6 +1176,24 @@ func (c *Corpus) AppendClaims(dst []camtypes.Claim, permaNode blob.Ref,
 	return dst, nil
 }
 
+// PermanodeSignedByLocked reports whether the permanode pn has at
+// least one undeleted claim signed by one of the given signers.
+func (c *Corpus) PermanodeSignedByLocked(pn blob.Ref, signers map[blob.Ref]bool) bool {
+	pm, ok := c.permanodes[pn]
+	if !ok {
+		return false
+	}
+	for _, cl := range pm.Claims {
+		if c.IsDeletedLocked(cl.BlobRef) {
+			continue
+		}
+		if signers[cl.Signer] {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Corpus) GetFileInfo(fileRef blob.Ref) (fi camtypes.FileInfo, err error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -1134,7 +1218,9 @@ func (c *Corpus) GetImageInfoLocked(fileRef blob.Ref) (ii camtypes.ImageInfo, er
 	ii, ok := c.imageInfo[fileRef]
 	if !ok {
 		err = os.ErrNotExist
+		return
 	}
+	ii.TinyPreview = c.imagePreview[fileRef]
 	return
 }
 
@@ -1214,14 +1300,142 @@ func (c *Corpus) ForeachClaimBackLocked(value blob.Ref, at time.Time, fn func(*c
 	}
 }
 
+// claimPtrsByDateAndRef sorts claims by (date, claim blobref)
+// ascending, giving the global claim slice a total order so iteration
+// can resume from a continuation position.
+type claimPtrsByDateAndRef []*camtypes.Claim
+
+func (s claimPtrsByDateAndRef) Len() int      { return len(s) }
+func (s claimPtrsByDateAndRef) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s claimPtrsByDateAndRef) Less(i, j int) bool {
+	if !s[i].Date.Equal(s[j].Date) {
+		return s[i].Date.Before(s[j].Date)
+	}
+	return s[i].BlobRef.String() < s[j].BlobRef.String()
+}
+
+// insertClaimLocked adds cl to the global time-ordered claim slice.
+// While the corpus is being built the slice is sorted once afterwards
+// instead.
+func (c *Corpus) insertClaimLocked(cl *camtypes.Claim) {
+	if c.building {
+		c.claims = append(c.claims, cl)
+		return
+	}
+	i := sort.Search(len(c.claims), func(i int) bool {
+		o := c.claims[i]
+		if !o.Date.Equal(cl.Date) {
+			return o.Date.After(cl.Date)
+		}
+		return o.BlobRef.String() >= cl.BlobRef.String()
+	})
+	c.claims = append(c.claims, nil)
+	copy(c.claims[i+1:], c.claims[i:])
+	c.claims[i] = cl
+}
+
+// ForeachClaimReverseLocked calls fn for each claim of the corpus, in
+// reverse chronological order (ties broken by claim blobref). If
+// before and beforeRef are non-zero, iteration starts just before
+// that position, so a caller can resume where a previous call left
+// off. If fn returns false, iteration ends. Deleted and superseded
+// claims are yielded like any other: this is the data for an activity
+// log, not the current state.
+func (c *Corpus) ForeachClaimReverseLocked(before time.Time, beforeRef blob.Ref, fn func(*camtypes.Claim) bool) {
+	i := len(c.claims)
+	if !before.IsZero() {
+		i = sort.Search(len(c.claims), func(i int) bool {
+			o := c.claims[i]
+			if !o.Date.Equal(before) {
+				return o.Date.After(before)
+			}
+			if beforeRef.Valid() {
+				return o.BlobRef.String() >= beforeRef.String()
+			}
+			return true
+		})
+	}
+	for i--; i >= 0; i-- {
+		if !fn(c.claims[i]) {
+			return
+		}
+	}
+}
+
+// ForeachClaimLocked calls fn for each claim of permaNode, in claim
+// date order. If fn returns false, iteration ends.
+func (c *Corpus) ForeachClaimLocked(permaNode blob.Ref, fn func(*camtypes.Claim) bool) {
+	pm, ok := c.permanodes[permaNode]
+	if !ok {
+		return
+	}
+	for _, cl := range pm.Claims {
+		if !fn(cl) {
+			return
+		}
+	}
+}
+
+// AttrValueRefCountsLocked returns, for each blob ref appearing as the
+// value of a claim with the given attribute, the number of distinct
+// permanodes which have that attribute set to it at time at (zero
+// means now). Refs which are no longer a value of the attribute on any
+// permanode (e.g. after del-attribute claims) are not in the returned
+// map.
+func (c *Corpus) AttrValueRefCountsLocked(at time.Time, attr string) map[blob.Ref]int {
+	counts := make(map[blob.Ref]int)
+	for value, claims := range c.claimBack {
+		var counted map[blob.Ref]bool
+		for _, cl := range claims {
+			if cl.Attr != attr || counted[cl.Permanode] {
+				continue
+			}
+			if !c.PermanodeHasAttrValueLocked(cl.Permanode, at, attr, value.String()) {
+				continue
+			}
+			if counted == nil {
+				counted = make(map[blob.Ref]bool)
+			}
+			counted[cl.Permanode] = true
+			counts[value]++
+		}
+	}
+	return counts
+}
+
+// AppendClaimBackRefsLocked appends to dst the permanodes which have
+// the given attribute set to value at time at (zero means now), and
+// returns dst.
+func (c *Corpus) AppendClaimBackRefsLocked(dst []blob.Ref, value blob.Ref, at time.Time, attr string) []blob.Ref {
+	var seen map[blob.Ref]bool
+	for _, cl := range c.claimBack[value] {
+		if cl.Attr != attr || seen[cl.Permanode] {
+			continue
+		}
+		if !c.PermanodeHasAttrValueLocked(cl.Permanode, at, attr, value.String()) {
+			continue
+		}
+		if seen == nil {
+			seen = make(map[blob.Ref]bool)
+		}
+		seen[cl.Permanode] = true
+		dst = append(dst, cl.Permanode)
+	}
+	return dst
+}
+
 // PermanodeHasAttrValueLocked reports whether the permanode pn at
 // time at (zero means now) has the given attribute with the given
-// value. If the attribute is multi-valued, any may match.
+// value. If the attribute is multi-valued, any may match. Deleted
+// claims, and deleted permanodes as a whole, don't count.
 func (c *Corpus) PermanodeHasAttrValueLocked(pn blob.Ref, at time.Time, attr, val string) bool {
 	pm, ok := c.permanodes[pn]
 	if !ok {
 		return false
 	}
+	if c.IsDeletedLocked(pn) {
+		return false
+	}
 	if at.IsZero() {
 		at = time.Now()
 	}
@@ -1230,6 +1444,9 @@ func (c *Corpus) PermanodeHasAttrValueLocked(pn blob.Ref, at time.Time, attr, va
 		if cl.Attr != attr {
 			continue
 		}
+		if c.IsDeletedLocked(cl.BlobRef) {
+			continue
+		}
 		if cl.Date.After(at) {
 			break
 		}
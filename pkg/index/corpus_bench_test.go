@@ -18,6 +18,8 @@ package index_test
 
 import (
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -61,3 +63,20 @@ func BenchmarkCorpusFromStorage(b *testing.B) {
 		}
 	}
 }
+
+// BenchmarkIndexImageFile measures the cost of indexing an image file,
+// including the image-specific rows (size, EXIF, tiny preview).
+func BenchmarkIndexImageFile(b *testing.B) {
+	defer test.TLog(b)()
+	contents, err := ioutil.ReadFile(filepath.Join("indextest", "testdata", "dude.jpg"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	id := indextest.NewIndexDeps(index.NewMemoryIndex())
+	id.Fataler = b
+	b.SetBytes(int64(len(contents)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id.UploadFile("dude.jpg", string(contents), time.Unix(1382073153, 0))
+	}
+}
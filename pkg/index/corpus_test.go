@@ -101,6 +101,95 @@ func TestCorpusAppendPermanodeAttrValues(t *testing.T) {
 
 }
 
+// Claims arriving out of order (e.g. from a camtool sync) must not
+// change the order of a multi-valued attribute's values: the order is
+// defined by claim date, with ties broken by claim blobref.
+func TestCorpusAttrValueOrdering(t *testing.T) {
+	c := index.ExpNewCorpus()
+	pn := blob.MustParse("abc-123")
+	claim := func(br string, sec int64, verb, val string) *camtypes.Claim {
+		return &camtypes.Claim{
+			BlobRef: blob.MustParse("sha1-" + br),
+			Type:    verb + "-attribute",
+			Attr:    "item",
+			Value:   val,
+			Date:    time.Unix(sec, 0),
+		}
+	}
+
+	c.SetClaims(pn, &index.PermanodeMeta{
+		Claims: []*camtypes.Claim{
+			// Deliberately not in application order: "c" was
+			// added last, and "a" and "b" share a date, so
+			// their blobrefs decide.
+			claim("cccccccccccccccccccccccccccccccccccccccc", 103, "add", "c"),
+			claim("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 101, "add", "b"),
+			claim("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 101, "add", "a"),
+			claim("dddddddddddddddddddddddddddddddddddddddd", 102, "del", "x"),
+		},
+	})
+
+	want := []string{"a", "b", "c"}
+	got := c.AppendPermanodeAttrValues(nil, pn, "item", time.Time{}, blob.Ref{})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("attr values = %q; want %q", got, want)
+	}
+}
+
+func TestCorpusForeachClaimReverse(t *testing.T) {
+	c := index.ExpNewCorpus()
+	pn := blob.MustParse("abc-123")
+	tm := time.Unix(99, 0)
+	var want []blob.Ref
+	var claims []*camtypes.Claim
+	for i := 0; i < 5; i++ {
+		tm = tm.Add(time.Second)
+		br := blob.MustParse(fmt.Sprintf("abc-c%d", i))
+		claims = append(claims, &camtypes.Claim{
+			BlobRef:   br,
+			Permanode: pn,
+			Type:      "set-attribute",
+			Attr:      "title",
+			Value:     fmt.Sprintf("v%d", i),
+			Date:      tm,
+		})
+		want = append(want, br)
+	}
+	c.SetClaims(pn, &index.PermanodeMeta{Claims: claims})
+
+	collect := func(before time.Time, beforeRef blob.Ref, max int) (got []blob.Ref) {
+		c.RLock()
+		defer c.RUnlock()
+		c.ForeachClaimReverseLocked(before, beforeRef, func(cl *camtypes.Claim) bool {
+			got = append(got, cl.BlobRef)
+			return len(got) < max
+		})
+		return
+	}
+
+	got := collect(time.Time{}, blob.Ref{}, 100)
+	if len(got) != len(want) {
+		t.Fatalf("got %d claims; want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[len(want)-1-i] {
+			t.Errorf("claim %d = %v; want %v", i, got[i], want[len(want)-1-i])
+		}
+	}
+
+	// Resume from a continuation position: everything strictly
+	// before claim 3 (i.e. claims 2, 1, 0).
+	got = collect(claims[3].Date, claims[3].BlobRef, 100)
+	if len(got) != 3 || got[0] != want[2] || got[2] != want[0] {
+		t.Errorf("continuation got %v; want [%v %v %v]", got, want[2], want[1], want[0])
+	}
+
+	// Early stop after 2.
+	if got := collect(time.Time{}, blob.Ref{}, 2); len(got) != 2 {
+		t.Errorf("early stop got %d claims; want 2", len(got))
+	}
+}
+
 func TestKVClaimAllocs(t *testing.T) {
 	n := testing.AllocsPerRun(20, func() {
 		index.ExpKvClaim("claim|sha1-b380b3080f9c71faa5c1d82bbd4d583a473bc77d|2931A67C26F5ABDA|2011-11-28T01:32:37.000123456Z|sha1-b3d93daee62e40d36237ff444022f42d7d0e43f2",
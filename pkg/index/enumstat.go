@@ -24,6 +24,7 @@ import (
 	"camlistore.org/pkg/blob"
 	"camlistore.org/pkg/context"
 	"camlistore.org/pkg/sorted"
+	"camlistore.org/pkg/types/camtypes"
 )
 
 func (ix *Index) EnumerateBlobs(ctx *context.Context, dest chan<- blob.SizedRef, after string, limit int) (err error) {
@@ -59,6 +60,57 @@ func (ix *Index) EnumerateBlobs(ctx *context.Context, dest chan<- blob.SizedRef,
 	return nil
 }
 
+// StorageStats aggregates the "dataref" reference-count rows written
+// at indexing time. Because there is one row per (referenced blob,
+// referencing schema blob) pair, the numbers only depend on which
+// blobs are indexed, not on the order they arrived in, so a reindex
+// reproduces them.
+func (ix *Index) StorageStats() (*camtypes.BlobStorageStats, error) {
+	stats := &camtypes.BlobStorageStats{
+		RefHistogram: make(map[int]int),
+	}
+	var (
+		cur  blob.Ref
+		refs int
+	)
+	flush := func() {
+		if refs == 0 {
+			return
+		}
+		stats.Blobs++
+		stats.Refs += refs
+		stats.RefHistogram[refs]++
+		if v, err := ix.s.Get("have:" + cur.String()); err == nil {
+			if size, err := strconv.ParseInt(v, 10, 64); err == nil {
+				stats.PhysicalBytes += size
+				stats.LogicalBytes += size * int64(refs)
+			}
+		}
+		refs = 0
+	}
+	it := ix.s.Find(keyDataBlobRef.name+"|", keyDataBlobRef.name+"~")
+	for it.Next() {
+		parts := strings.Split(it.Key(), "|")
+		if len(parts) != 3 {
+			continue
+		}
+		br, ok := blob.Parse(parts[1])
+		if !ok {
+			continue
+		}
+		if br != cur {
+			flush()
+			cur = br
+		}
+		refs++
+	}
+	if err := it.Close(); err != nil {
+		return nil, err
+	}
+	flush()
+	return stats, nil
+}
+
 func (ix *Index) StatBlobs(dest chan<- blob.SizedRef, blobs []blob.Ref) error {
 	for _, br := range blobs {
 		key := "have:" + br.String()
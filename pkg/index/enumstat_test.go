@@ -0,0 +1,73 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package index
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"camlistore.org/pkg/blob"
+)
+
+func receiveString(t *testing.T, ix *Index, contents string) blob.Ref {
+	br := blob.SHA1FromString(contents)
+	if _, err := ix.ReceiveBlob(br, strings.NewReader(contents)); err != nil {
+		t.Fatalf("ReceiveBlob(%v): %v", br, err)
+	}
+	return br
+}
+
+func TestStorageStats(t *testing.T) {
+	ix := NewMemoryIndex()
+
+	chunkA := receiveString(t, ix, "chunk A.")   // 8 bytes, referenced twice
+	chunkB := receiveString(t, ix, "chunk Bee.") // 10 bytes, referenced once
+
+	bytesBlob := func(refs ...blob.Ref) string {
+		var parts []string
+		for _, br := range refs {
+			parts = append(parts, fmt.Sprintf(`{"blobRef": %q, "size": 1}`, br))
+		}
+		return fmt.Sprintf(`{"camliVersion": 1,
+"camliType": "bytes",
+"parts": [%s]
+}`, strings.Join(parts, ", "))
+	}
+	receiveString(t, ix, bytesBlob(chunkA))
+	receiveString(t, ix, bytesBlob(chunkA, chunkB))
+
+	stats, err := ix.StorageStats()
+	if err != nil {
+		t.Fatalf("StorageStats: %v", err)
+	}
+	if got, want := stats.Blobs, 2; got != want {
+		t.Errorf("Blobs = %d; want %d", got, want)
+	}
+	if got, want := stats.Refs, 3; got != want {
+		t.Errorf("Refs = %d; want %d", got, want)
+	}
+	if got, want := stats.PhysicalBytes, int64(18); got != want {
+		t.Errorf("PhysicalBytes = %d; want %d", got, want)
+	}
+	if got, want := stats.LogicalBytes, int64(26); got != want {
+		t.Errorf("LogicalBytes = %d; want %d", got, want)
+	}
+	if got, want := fmt.Sprint(stats.RefHistogram), "map[1:1 2:1]"; got != want {
+		t.Errorf("RefHistogram = %s; want %s", got, want)
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package index
 
 import (
+	"sort"
 	"testing"
 	"time"
 
@@ -50,7 +51,13 @@ func ExpKvClaim(k, v string, blobParse func(string) (blob.Ref, bool)) (c camtype
 }
 
 func (c *Corpus) SetClaims(pn blob.Ref, claims *PermanodeMeta) {
+	// Restore the sorted-by-date invariant, like mergeClaimRow
+	// does, so tests may pass claims in any order.
+	sort.Sort(camtypes.ClaimPtrsByDate(claims.Claims))
 	c.permanodes[pn] = claims
+	for _, cl := range claims.Claims {
+		c.insertClaimLocked(cl)
+	}
 }
 
 func (x *Index) NeededMapsForTest() (needs, neededBy map[blob.Ref][]blob.Ref, ready map[blob.Ref]bool) {
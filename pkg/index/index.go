@@ -31,6 +31,7 @@ import (
 
 	"camlistore.org/pkg/blob"
 	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/camlog"
 	"camlistore.org/pkg/context"
 	"camlistore.org/pkg/jsonconfig"
 	"camlistore.org/pkg/schema"
@@ -44,6 +45,10 @@ func init() {
 	blobserver.RegisterStorageConstructor("index", newFromConfig)
 }
 
+// indexLog is the "index" logging subsystem. Its level can be raised
+// to "debug" in the config or via /debug/logging.
+var indexLog = camlog.New("index")
+
 type Index struct {
 	*blobserver.NoImplStorage
 
@@ -212,11 +217,11 @@ func (x *Index) Reindex() error {
 	if !ok {
 		return fmt.Errorf("index's storage type %T doesn't support sorted.Wiper", x.s)
 	}
-	log.Printf("Wiping index storage type %T ...", x.s)
+	indexLog.Printf("Wiping index storage type %T ...", x.s)
 	if err := wiper.Wipe(); err != nil {
 		return fmt.Errorf("error wiping index's sorted key/value type %T: %v", x.s, err)
 	}
-	log.Printf("Index wiped. Rebuilding...")
+	indexLog.Printf("Index wiped. Rebuilding...")
 
 	reindexStart, _ := blob.Parse(os.Getenv("CAMLI_REINDEX_START"))
 
@@ -239,7 +244,7 @@ func (x *Index) Reindex() error {
 		enumErr <- blobserver.EnumerateAll(enumCtx, x.blobSource, func(sb blob.SizedRef) error {
 			now := time.Now()
 			if lastTick.Before(now.Add(-1 * time.Second)) {
-				log.Printf("Reindexing at %v", sb.Ref)
+				indexLog.Printf("Reindexing at %v", sb.Ref)
 				lastTick = now
 			}
 			if reindexStart.Valid() && sb.Ref.Less(reindexStart) {
@@ -261,7 +266,7 @@ func (x *Index) Reindex() error {
 			defer wg.Done()
 			for br := range blobc {
 				if err := x.indexBlob(br); err != nil {
-					log.Printf("Error reindexing %v: %v", br, err)
+					indexLog.Printf("Error reindexing %v: %v", br, err)
 					nerrmu.Lock()
 					nerr++
 					nerrmu.Unlock()
@@ -284,7 +289,7 @@ func (x *Index) Reindex() error {
 		return fmt.Errorf("%d blobs were ready to reindex in out-of-order queue, but not yet ran", readyCount)
 	}
 
-	log.Printf("Index rebuild complete.")
+	indexLog.Printf("Index rebuild complete.")
 	nerrmu.Lock() // no need to unlock
 	if nerr != 0 {
 		return fmt.Errorf("%d blobs failed to re-index", nerr)
@@ -1080,6 +1085,11 @@ func (x *Index) GetImageInfo(fileRef blob.Ref) (camtypes.ImageInfo, error) {
 	if !ok {
 		return camtypes.ImageInfo{}, fmt.Errorf("index: bogus key %q = %q", key, v)
 	}
+	// Absence of a preview just means indexing predates them or
+	// decoding failed; not an error.
+	if p, err := x.s.Get(keyImagePreview.Key(fileRef.String())); err == nil {
+		ii.TinyPreview = p
+	}
 	return ii, nil
 }
 
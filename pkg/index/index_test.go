@@ -75,6 +75,7 @@ var (
 	notAnIndexer = []string{
 		"corpus_bench_test.go",
 		"corpus_test.go",
+		"enumstat_test.go",
 		"export_test.go",
 		"index_test.go",
 		"keys_test.go",
@@ -51,6 +51,11 @@ type IndexDeps struct {
 	EntityFetcher    jsonsign.EntityFetcher // fetching decrypted openpgp entities
 	SignerBlobRef    blob.Ref
 
+	// Following two are a second identity, for tests involving
+	// multiple signers sharing an index:
+	EntityFetcher2 jsonsign.EntityFetcher
+	SignerBlobRef2 blob.Ref
+
 	now time.Time // fake clock, nanos since epoch
 
 	Fataler // optional means of failing.
@@ -88,15 +93,23 @@ func (id *IndexDeps) DumpIndex(t *testing.T) {
 }
 
 func (id *IndexDeps) uploadAndSign(m *schema.Builder) blob.Ref {
-	m.SetSigner(id.SignerBlobRef)
+	return id.uploadAndSignAs(id.SignerBlobRef, m)
+}
+
+func (id *IndexDeps) uploadAndSignAs(signer blob.Ref, m *schema.Builder) blob.Ref {
+	m.SetSigner(signer)
 	unsigned, err := m.JSON()
 	if err != nil {
 		id.Fatalf("uploadAndSignMap: " + err.Error())
 	}
+	entityFetcher := id.EntityFetcher
+	if signer == id.SignerBlobRef2 {
+		entityFetcher = id.EntityFetcher2
+	}
 	sr := &jsonsign.SignRequest{
 		UnsignedJSON:  unsigned,
 		Fetcher:       id.PublicKeyFetcher,
-		EntityFetcher: id.EntityFetcher,
+		EntityFetcher: entityFetcher,
 		SignatureTime: id.now,
 	}
 	signed, err := sr.Sign()
@@ -122,6 +135,14 @@ func (id *IndexDeps) NewPermanode() blob.Ref {
 	return id.uploadAndSign(unsigned)
 }
 
+// NewPermanodeAs creates (& signs, as signer) a new permanode and adds
+// it to the index, returning its blobref. The signer must be either
+// id.SignerBlobRef or id.SignerBlobRef2.
+func (id *IndexDeps) NewPermanodeAs(signer blob.Ref) blob.Ref {
+	unsigned := schema.NewUnsignedPermanode()
+	return id.uploadAndSignAs(signer, unsigned)
+}
+
 // NewPermanode creates (& signs) a new planned permanode and adds it
 // to the index, returning its blobref.
 func (id *IndexDeps) NewPlannedPermanode(key string) blob.Ref {
@@ -145,6 +166,13 @@ func (id *IndexDeps) SetAttribute(permaNode blob.Ref, attr, value string) blob.R
 	return id.uploadAndSign(m)
 }
 
+// SetAttributeAs is like SetAttribute, but signs the claim as signer.
+func (id *IndexDeps) SetAttributeAs(signer, permaNode blob.Ref, attr, value string) blob.Ref {
+	m := schema.NewSetAttributeClaim(permaNode, attr, value)
+	m.SetClaimDate(id.advanceTime())
+	return id.uploadAndSignAs(signer, m)
+}
+
 func (id *IndexDeps) SetAttribute_NoTimeMove(permaNode blob.Ref, attr, value string) blob.Ref {
 	m := schema.NewSetAttributeClaim(permaNode, attr, value)
 	m.SetClaimDate(id.LastTime())
@@ -243,6 +271,69 @@ func (id *IndexDeps) UploadDir(dirName string, children []blob.Ref, modTime time
 	return dirb.BlobRef()
 }
 
+// UploadDirChunked is like UploadDir, but spreads the children over
+// several static-set blobs of at most chunkSize members each, linked
+// together with "mergeSets", as an uploader does for directories too
+// large for a single schema blob.
+func (id *IndexDeps) UploadDirChunked(dirName string, children []blob.Ref, chunkSize int, modTime time.Time) blob.Ref {
+	if chunkSize <= 0 {
+		id.Fatalf("UploadDirChunked: bogus chunkSize %d", chunkSize)
+	}
+	uploadSet := func(ss *schema.StaticSet) blob.Ref {
+		ssb := &test.Blob{Contents: ss.Blob().JSON()}
+		id.BlobSource.AddBlob(ssb)
+		if _, err := id.Index.ReceiveBlob(ssb.BlobRef(), ssb.Reader()); err != nil {
+			id.Fatalf("UploadDirChunked.ReceiveBlob: %v", err)
+		}
+		return ssb.BlobRef()
+	}
+
+	// Spill-over sets for all but the first chunk.
+	var spill []blob.Ref
+	for i := chunkSize; i < len(children); i += chunkSize {
+		ss := new(schema.StaticSet)
+		end := i + chunkSize
+		if end > len(children) {
+			end = len(children)
+		}
+		for _, child := range children[i:end] {
+			ss.Add(child)
+		}
+		spill = append(spill, uploadSet(ss))
+	}
+
+	// Root static-set: the first chunk plus the spill-over refs.
+	root := new(schema.StaticSet)
+	end := chunkSize
+	if end > len(children) {
+		end = len(children)
+	}
+	for _, child := range children[:end] {
+		root.Add(child)
+	}
+	for _, ref := range spill {
+		root.AddMergeSet(ref)
+	}
+	rootRef := uploadSet(root)
+
+	// directory blob
+	bb := schema.NewDirMap(dirName)
+	bb.PopulateDirectoryMap(rootRef)
+	if !modTime.IsZero() {
+		bb.SetModTime(modTime)
+	}
+	dirjson, err := bb.JSON()
+	if err != nil {
+		id.Fatalf("UploadDirChunked.JSON: %v", err)
+	}
+	dirb := &test.Blob{Contents: dirjson}
+	id.BlobSource.AddBlob(dirb)
+	if _, err := id.Index.ReceiveBlob(dirb.BlobRef(), dirb.Reader()); err != nil {
+		id.Fatalf("UploadDirChunked.ReceiveBlob: %v", err)
+	}
+	return dirb.BlobRef()
+}
+
 // NewIndexDeps returns an IndexDeps helper for populating and working
 // with the provided index for tests.
 func NewIndexDeps(index *index.Index) *IndexDeps {
@@ -260,6 +351,18 @@ aRYBJqdT1mctGqNEISd/WYPMgwnWXQsVi3x4z1dYu2tD9uO1dkAff12z1kyZQIBQ
 rexKYRRRh9IKAayD4kgS0wdlULjBU98aeEaMz1ckuB46DX3lAYqmmTEL/Rl9cOI0
 Enpn/oOOfYFa5h0AFndZd1blMvruXfdAobjVABEBAAE=
 =28/7
+-----END PGP PUBLIC KEY BLOCK-----`}
+
+	secretRingFile2 := filepath.Join(camliRootPath, "pkg", "jsonsign", "testdata", "test-secring2.gpg")
+	pubKey2 := &test.Blob{Contents: `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+xsBNBEz61lcBCADRQhcb9LIQdV3LhU5f7cCjOctmLsL+y4k4VKmznssWORiNPEHQ
+13CxFLjRDN2OQYXi4NSqoUqHNMsRTUJTVW0CnznUUb11ibXLUYW/zbPN9dWs8PlI
+UZSScS1dxtGKKk+VfXrvc1LB6pqrjWmAgEwQxsBWToW2IFR/eMo1LiVU83dzpKU1
+n/yb8Jy9wizchspd9xecK2X0JnKLRIJklLTAKQ+XKP+cSwXmShcs+3pxu5f4piqF
+7oBfh9noFA0vdGYNBGVch3DfJwFcTmLkkGFZKdiehWncvVYT1jxUkJvc0K44ohDH
+smkG2VZm3rJCwi2GIWA/clLiDAhYM6vTI3oZABEBAAE=
+=aWwV
 -----END PGP PUBLIC KEY BLOCK-----`}
 
 	id := &IndexDeps{
@@ -270,15 +373,25 @@ Enpn/oOOfYFa5h0AFndZd1blMvruXfdAobjVABEBAAE=
 			Fetcher: &jsonsign.FileEntityFetcher{File: secretRingFile},
 		},
 		SignerBlobRef: pubKey.BlobRef(),
-		now:           test.ClockOrigin,
-		Fataler:       logFataler{},
+		EntityFetcher2: &jsonsign.CachingEntityFetcher{
+			Fetcher: &jsonsign.FileEntityFetcher{File: secretRingFile2},
+		},
+		SignerBlobRef2: pubKey2.BlobRef(),
+		now:            test.ClockOrigin,
+		Fataler:        logFataler{},
 	}
 	// Add dev client test key public key, keyid 26F5ABDA,
 	// blobref sha1-ad87ca5c78bd0ce1195c46f7c98e6025abbaf007
 	if g, w := id.SignerBlobRef.String(), "sha1-ad87ca5c78bd0ce1195c46f7c98e6025abbaf007"; g != w {
 		id.Fatalf("unexpected signer blobref; got signer = %q; want %q", g, w)
 	}
+	// Add the second test key public key, keyid 4BEC5AB5,
+	// blobref sha1-d5ca74e6283496d0b51ae1e929802c7ec199faa0
+	if g, w := id.SignerBlobRef2.String(), "sha1-d5ca74e6283496d0b51ae1e929802c7ec199faa0"; g != w {
+		id.Fatalf("unexpected second signer blobref; got signer = %q; want %q", g, w)
+	}
 	id.PublicKeyFetcher.AddBlob(pubKey)
+	id.PublicKeyFetcher.AddBlob(pubKey2)
 	id.Index.KeyFetcher = id.PublicKeyFetcher
 	id.Index.InitBlobSource(id.BlobSource)
 	return id
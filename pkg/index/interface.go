@@ -133,6 +133,12 @@ type Interface interface {
 	// opts may be nil to accept the defaults.
 	EdgesTo(ref blob.Ref, opts *camtypes.EdgesToOpts) ([]*camtypes.Edge, error)
 
+	// StorageStats summarizes how the data blobs known to the
+	// index are shared by the file and bytes schema blobs that
+	// reference them: reference counts, and the logical vs
+	// physical bytes they imply.
+	StorageStats() (*camtypes.BlobStorageStats, error)
+
 	// EnumerateBlobMeta sends ch information about all blobs
 	// known to the indexer (which may be a subset of all total
 	// blobs, since the indexer is typically configured to not see
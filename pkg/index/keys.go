@@ -25,7 +25,9 @@ import (
 // requiredSchemaVersion is incremented every time
 // an index key type is added, changed, or removed.
 // Version 4: EXIF tags + GPS
-const requiredSchemaVersion = 4
+// Version 5: blob reference counts
+// Version 6: tiny image previews
+const requiredSchemaVersion = 6
 
 // type of key returns the identifier in k before the first ":" or "|".
 // (Originally we packed keys by hand and there are a mix of styles)
@@ -233,6 +235,23 @@ var (
 		},
 	}
 
+	// keyDataBlobRef records that the "file" or "bytes" schema blob
+	// in "schema" references the blob in "blob" from one of its
+	// parts. The number of rows for a given "blob" is its reference
+	// count. One row per referencing schema blob (rather than a
+	// mutable counter) keeps indexing idempotent, so a reindex
+	// recomputes the same counts.
+	keyDataBlobRef = &keyType{
+		"dataref",
+		[]part{
+			{"blob", typeBlobRef},
+			{"schema", typeBlobRef}, // for key uniqueness
+		},
+		[]part{
+			{"1", typeStr},
+		},
+	}
+
 	keyFileInfo = &keyType{
 		"fileinfo",
 		[]part{
@@ -316,6 +335,19 @@ var (
 		},
 	}
 
+	// Tiny preview of an image, so a UI can paint a rough
+	// placeholder from search results alone, before any real
+	// thumbnail loads.
+	keyImagePreview = &keyType{
+		"imagepreview",
+		[]part{
+			{"fileref", typeBlobRef}, // blobref of "file" schema blob
+		},
+		[]part{
+			{"preview", typeStr}, // from images.TinyPreview; hex, so escaping is a no-op
+		},
+	}
+
 	// child of a directory
 	keyStaticDirChild = &keyType{
 		"dirchild",
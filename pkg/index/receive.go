@@ -305,6 +305,8 @@ func (ix *Index) populateMutationMap(fetcher *missTrackFetcher, br blob.Ref, sni
 			if err := ix.populateDir(fetcher, blob, mm); err != nil {
 				return nil, err
 			}
+		case "bytes":
+			indexByteParts(blob, mm)
 		}
 	}
 
@@ -379,6 +381,13 @@ func (ix *Index) populateFile(fetcher blob.Fetcher, b *schema.Blob, mm *mutation
 	if imageBuf != nil {
 		if conf, err := images.DecodeConfig(bytes.NewReader(imageBuf.Bytes)); err == nil {
 			mm.Set(keyImageSize.Key(blobRef), keyImageSize.Val(fmt.Sprint(conf.Width), fmt.Sprint(conf.Height)))
+			// Decoding at most 64x64 keeps this cheap for JPEGs
+			// (DCT scaling) and is plenty for a 4x4 preview.
+			if m, _, err := images.Decode(bytes.NewReader(imageBuf.Bytes), &images.DecodeOpts{MaxWidth: 64, MaxHeight: 64}); err == nil {
+				if p := images.TinyPreview(m); p != "" {
+					mm.Set(keyImagePreview.Key(blobRef), keyImagePreview.Val(p))
+				}
+			}
 		}
 		if ft, err := schema.FileTime(bytes.NewReader(imageBuf.Bytes)); err == nil {
 			log.Printf("filename %q exif = %v, %v", b.FileName(), ft, err)
@@ -409,6 +418,7 @@ func (ix *Index) populateFile(fetcher blob.Fetcher, b *schema.Blob, mm *mutation
 	mm.Set(keyWholeToFileRef.Key(wholeRef, blobRef), "1")
 	mm.Set(keyFileInfo.Key(blobRef), keyFileInfo.Val(size, b.FileName(), mime))
 	mm.Set(keyFileTimes.Key(blobRef), keyFileTimes.Val(time3339s))
+	indexByteParts(b, mm)
 
 	if strings.HasPrefix(mime, "audio/") {
 		indexMusic(io.NewSectionReader(fr, 0, fr.Size()), wholeRef, mm)
@@ -417,6 +427,22 @@ func (ix *Index) populateFile(fetcher blob.Fetcher, b *schema.Blob, mm *mutation
 	return nil
 }
 
+// indexByteParts adds a reference-count row for each blob directly
+// referenced by the parts of the "file" or "bytes" schema blob b.
+// Indirect references (through "bytes" blobs) are recorded when those
+// blobs are themselves indexed.
+func indexByteParts(b *schema.Blob, mm *mutationMap) {
+	blobRef := b.BlobRef()
+	for _, part := range b.ByteParts() {
+		if r := part.BlobRef; r.Valid() {
+			mm.Set(keyDataBlobRef.Key(r, blobRef), "1")
+		}
+		if r := part.BytesRef; r.Valid() {
+			mm.Set(keyDataBlobRef.Key(r, blobRef), "1")
+		}
+	}
+}
+
 func tagFormatString(tag *tiff.Tag) string {
 	switch tag.Format() {
 	case tiff.IntVal:
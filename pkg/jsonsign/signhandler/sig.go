@@ -20,9 +20,12 @@ package signhandler
 
 import (
 	"crypto"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 
@@ -32,35 +35,46 @@ import (
 	"camlistore.org/pkg/httputil"
 	"camlistore.org/pkg/jsonconfig"
 	"camlistore.org/pkg/jsonsign"
+	"camlistore.org/pkg/maintenance"
 	"camlistore.org/pkg/osutil"
 	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/schema/nodeattr"
 
 	"camlistore.org/third_party/code.google.com/p/go.crypto/openpgp"
 )
 
 const kMaxJSONLength = 1024 * 1024
 
-type Handler struct {
-	// Optional path to non-standard secret gpg keyring file
-	secretRing string
+// A signingKey is one GPG identity the handler can sign with.
+type signingKey struct {
+	keyID      string // as configured, e.g. "26F5ABDA"
+	secretRing string // path to its secret keyring; "" means the handler default
 
 	pubKey        string // armored
 	pubKeyBlobRef blob.Ref
-	pubKeyFetcher blob.Fetcher
+	entity        *openpgp.Entity
+	signer        *schema.Signer
 
-	pubKeyBlobRefServeSuffix string // "camli/sha1-xxxx"
-	pubKeyHandler            http.Handler
+	uploadMu sync.RWMutex
+	uploaded bool
+}
 
-	pubKeyDest blobserver.Storage // Where our public key is published
+type Handler struct {
+	// Optional path to non-standard secret gpg keyring file,
+	// the default for keys that don't name their own.
+	secretRing string
 
-	pubKeyUploadMu sync.RWMutex
-	pubKeyUploaded bool
+	primary *signingKey
+	keys    []*signingKey          // all signing identities; keys[0] == primary
+	byKeyID map[string]*signingKey // configured ID, long openpgp ID, and pubkey blobref → key
 
-	entity *openpgp.Entity
-	signer *schema.Signer
+	pubKeyFetcher blob.Fetcher // holds all the armored public keys
+	pubKeyHandler http.Handler
+
+	pubKeyDest blobserver.Storage // Where the public keys are published
 }
 
-func (h *Handler) Signer() *schema.Signer { return h.signer }
+func (h *Handler) Signer() *schema.Signer { return h.primary.signer }
 
 func (h *Handler) secretRingPath() string {
 	if h.secretRing != "" {
@@ -79,27 +93,60 @@ func newJSONSignFromConfig(ld blobserver.Loader, conf jsonconfig.Obj) (http.Hand
 	// either a short form ("26F5ABDA") or one the longer forms.
 	keyId := conf.RequiredString("keyId")
 
+	// Extra secondary signing identities, mapping a key ID to the
+	// path of the secret keyring holding it, or "" for the same
+	// ring as the primary. Sign requests select among them with
+	// the "signer" parameter; the primary remains the default.
+	secondaryKeys := conf.OptionalObject("secondaryKeys")
+
+	// Extra attribute validation rules, attribute name to rule
+	// string, on top of the nodeattr defaults. See
+	// nodeattr.RuleValidator for the rule syntax.
+	attrRules := conf.OptionalObject("attrValidation")
+
 	h := &Handler{
 		secretRing: conf.OptionalString("secretRing", ""),
+		byKeyID:    make(map[string]*signingKey),
 	}
 	var err error
 	if err = conf.Validate(); err != nil {
 		return nil, err
 	}
 
-	h.entity, err = jsonsign.EntityFromSecring(keyId, h.secretRingPath())
-	if err != nil {
-		return nil, err
+	for attr, rulei := range attrRules {
+		rule, ok := rulei.(string)
+		if !ok {
+			return nil, fmt.Errorf("attrValidation.%s must be a string", attr)
+		}
+		v, err := nodeattr.RuleValidator(rule)
+		if err != nil {
+			return nil, fmt.Errorf("attrValidation.%s: %v", attr, err)
+		}
+		nodeattr.Register(attr, v)
 	}
 
-	h.pubKey, err = jsonsign.ArmoredPublicKey(h.entity)
-
 	ms := new(blob.MemoryStore)
-	h.pubKeyBlobRef, err = ms.AddBlob(crypto.SHA1, h.pubKey)
-	if err != nil {
+	h.pubKeyFetcher = ms
+
+	if h.primary, err = h.loadKey(ms, keyId, h.secretRing); err != nil {
 		return nil, err
 	}
-	h.pubKeyFetcher = ms
+
+	// Sorted for deterministic key order in h.keys.
+	secondaryIDs := make([]string, 0, len(secondaryKeys))
+	for id := range secondaryKeys {
+		secondaryIDs = append(secondaryIDs, id)
+	}
+	sort.Strings(secondaryIDs)
+	for _, id := range secondaryIDs {
+		ring, ok := secondaryKeys[id].(string)
+		if !ok {
+			return nil, fmt.Errorf("secondaryKeys.%s must be a secret keyring path string", id)
+		}
+		if _, err := h.loadKey(ms, id, ring); err != nil {
+			return nil, fmt.Errorf("secondaryKeys.%s: %v", id, err)
+		}
+	}
 
 	if pubKeyDestPrefix != "" {
 		sto, err := ld.GetStorage(pubKeyDestPrefix)
@@ -107,57 +154,130 @@ func newJSONSignFromConfig(ld blobserver.Loader, conf jsonconfig.Obj) (http.Hand
 			return nil, err
 		}
 		h.pubKeyDest = sto
+		// Publish all the public keys at startup, so signed
+		// claims from any identity can be verified and indexed
+		// immediately.
+		go h.uploadAllPublicKeys()
 	}
-	h.pubKeyBlobRefServeSuffix = "camli/" + h.pubKeyBlobRef.String()
 	h.pubKeyHandler = &gethandler.Handler{
 		Fetcher: ms,
 	}
 
-	h.signer, err = schema.NewSigner(h.pubKeyBlobRef, strings.NewReader(h.pubKey), h.entity)
+	return h, nil
+}
+
+// loadKey loads the key keyID from the secret keyring ring (or the
+// handler default if empty), adds its public key to ms, and registers
+// it on h.
+func (h *Handler) loadKey(ms *blob.MemoryStore, keyID, ring string) (*signingKey, error) {
+	if ring == "" {
+		ring = h.secretRingPath()
+	}
+	k := &signingKey{
+		keyID:      keyID,
+		secretRing: ring,
+	}
+	var err error
+	k.entity, err = jsonsign.EntityFromSecring(keyID, ring)
 	if err != nil {
 		return nil, err
 	}
+	k.pubKey, err = jsonsign.ArmoredPublicKey(k.entity)
+	if err != nil {
+		return nil, err
+	}
+	k.pubKeyBlobRef, err = ms.AddBlob(crypto.SHA1, k.pubKey)
+	if err != nil {
+		return nil, err
+	}
+	k.signer, err = schema.NewSigner(k.pubKeyBlobRef, strings.NewReader(k.pubKey), k.entity)
+	if err != nil {
+		return nil, err
+	}
+	h.keys = append(h.keys, k)
+	h.byKeyID[strings.ToUpper(keyID)] = k
+	h.byKeyID[k.entity.PrimaryKey.KeyIdString()] = k
+	h.byKeyID[k.pubKeyBlobRef.String()] = k
+	return k, nil
+}
 
-	return h, nil
+// signingKey returns the configured key named by s: a key ID (short
+// or long form) or a public key blobref. It returns nil if s names no
+// configured key.
+func (h *Handler) signingKey(s string) *signingKey {
+	if k, ok := h.byKeyID[strings.ToUpper(s)]; ok {
+		return k
+	}
+	return h.byKeyID[s]
 }
 
-func (h *Handler) uploadPublicKey() error {
-	h.pubKeyUploadMu.RLock()
-	if h.pubKeyUploaded {
-		h.pubKeyUploadMu.RUnlock()
-		return nil
+// FetchEntity implements jsonsign.EntityFetcher, so sign requests use
+// the already-loaded entity (from the right secret ring) for any of
+// the configured keys.
+func (h *Handler) FetchEntity(keyId string) (*openpgp.Entity, error) {
+	if k, ok := h.byKeyID[keyId]; ok {
+		return k.entity, nil
+	}
+	return nil, fmt.Errorf("signhandler: no configured key with ID %q", keyId)
+}
+
+func (h *Handler) uploadAllPublicKeys() {
+	for _, k := range h.keys {
+		if err := h.uploadPublicKey(k); err != nil {
+			log.Printf("signhandler: error uploading public key %v: %v", k.pubKeyBlobRef, err)
+		}
 	}
-	h.pubKeyUploadMu.RUnlock()
+}
 
+func (h *Handler) uploadPublicKey(k *signingKey) error {
 	sto := h.pubKeyDest
+	if sto == nil {
+		return nil
+	}
+	k.uploadMu.RLock()
+	if k.uploaded {
+		k.uploadMu.RUnlock()
+		return nil
+	}
+	k.uploadMu.RUnlock()
 
-	h.pubKeyUploadMu.Lock()
-	defer h.pubKeyUploadMu.Unlock()
-	if h.pubKeyUploaded {
+	k.uploadMu.Lock()
+	defer k.uploadMu.Unlock()
+	if k.uploaded {
 		return nil
 	}
-	_, err := blobserver.StatBlob(sto, h.pubKeyBlobRef)
+	_, err := blobserver.StatBlob(sto, k.pubKeyBlobRef)
 	if err == nil {
-		h.pubKeyUploaded = true
+		k.uploaded = true
 		return nil
 	}
-	_, err = blobserver.Receive(sto, h.pubKeyBlobRef, strings.NewReader(h.pubKey))
-	log.Printf("uploadPublicKey(%T, %v) = %v", sto, h.pubKeyBlobRef, err)
+	_, err = blobserver.Receive(sto, k.pubKeyBlobRef, strings.NewReader(k.pubKey))
+	log.Printf("uploadPublicKey(%T, %v) = %v", sto, k.pubKeyBlobRef, err)
 	if err == nil {
-		h.pubKeyUploaded = true
+		k.uploaded = true
 	}
 	return err
 }
 
 func (h *Handler) DiscoveryMap(base string) map[string]interface{} {
 	m := map[string]interface{}{
-		"publicKeyId":   h.entity.PrimaryKey.KeyIdString(),
+		"publicKeyId":   h.primary.entity.PrimaryKey.KeyIdString(),
 		"signHandler":   base + "camli/sig/sign",
 		"verifyHandler": base + "camli/sig/verify",
 	}
-	if h.pubKeyBlobRef.Valid() {
-		m["publicKeyBlobRef"] = h.pubKeyBlobRef.String()
-		m["publicKey"] = base + h.pubKeyBlobRefServeSuffix
+	if h.primary.pubKeyBlobRef.Valid() {
+		m["publicKeyBlobRef"] = h.primary.pubKeyBlobRef.String()
+		m["publicKey"] = base + "camli/" + h.primary.pubKeyBlobRef.String()
+	}
+	if len(h.keys) > 1 {
+		signers := map[string]interface{}{}
+		for _, k := range h.keys {
+			signers[k.entity.PrimaryKey.KeyIdString()] = map[string]interface{}{
+				"publicKeyBlobRef": k.pubKeyBlobRef.String(),
+				"publicKey":        base + "camli/" + k.pubKeyBlobRef.String(),
+			}
+		}
+		m["signers"] = signers
 	}
 	return m
 }
@@ -171,9 +291,6 @@ func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		case "":
 			http.Redirect(rw, req, base+"camli/sig/discovery", http.StatusFound)
 			return
-		case h.pubKeyBlobRefServeSuffix:
-			h.pubKeyHandler.ServeHTTP(rw, req)
-			return
 		case "camli/sig/sign":
 			fallthrough
 		case "camli/sig/verify":
@@ -183,9 +300,19 @@ func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			httputil.ReturnJSON(rw, h.DiscoveryMap(base))
 			return
 		}
+		for _, k := range h.keys {
+			if subPath == "camli/"+k.pubKeyBlobRef.String() {
+				h.pubKeyHandler.ServeHTTP(rw, req)
+				return
+			}
+		}
 	case "POST":
 		switch subPath {
 		case "camli/sig/sign":
+			if maintenance.Enabled() {
+				maintenance.Refuse(rw)
+				return
+			}
 			h.handleSign(rw, req)
 			return
 		case "camli/sig/verify":
@@ -225,6 +352,11 @@ func (h *Handler) handleVerify(rw http.ResponseWriter, req *http.Request) {
 	httputil.ReturnJSON(rw, m)
 }
 
+// camliSignerValue matches the "camliSigner" member of an unsigned
+// schema blob, so its value can be swapped without disturbing the
+// rest of the client's serialization (which is what gets signed).
+var camliSignerValue = regexp.MustCompile(`("camliSigner"\s*:\s*)"[^"]*"`)
+
 func (h *Handler) handleSign(rw http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
 
@@ -244,11 +376,37 @@ func (h *Handler) handleSign(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	key := h.primary
+	if s := req.FormValue("signer"); s != "" {
+		if key = h.signingKey(s); key == nil {
+			badReq(fmt.Sprintf("unknown \"signer\" %q", s))
+			return
+		}
+		// Point the blob at the selected identity's public key
+		// before signing.
+		jsonStr = camliSignerValue.ReplaceAllString(jsonStr, `${1}"`+key.pubKeyBlobRef.String()+`"`)
+	}
+
+	// Validate attribute claim values before signing, so the UI
+	// gets told about malformed dates, latitudes, etc.
+	var claim struct {
+		ClaimType string `json:"claimType"`
+		Attribute string `json:"attribute"`
+		Value     string `json:"value"`
+	}
+	if err := json.Unmarshal([]byte(jsonStr), &claim); err == nil &&
+		(claim.ClaimType == "set-attribute" || claim.ClaimType == "add-attribute") {
+		if err := nodeattr.Validate(claim.Attribute, claim.Value); err != nil {
+			badReq(err.Error())
+			return
+		}
+	}
+
 	sreq := &jsonsign.SignRequest{
-		UnsignedJSON:      jsonStr,
-		Fetcher:           h.pubKeyFetcher,
-		ServerMode:        true,
-		SecretKeyringPath: h.secretRing,
+		UnsignedJSON:  jsonStr,
+		Fetcher:       h.pubKeyFetcher,
+		ServerMode:    true,
+		EntityFetcher: h,
 	}
 	signedJSON, err := sreq.Sign()
 	if err != nil {
@@ -256,21 +414,21 @@ func (h *Handler) handleSign(rw http.ResponseWriter, req *http.Request) {
 		badReq(fmt.Sprintf("%v", err))
 		return
 	}
-	h.uploadPublicKey()
+	h.uploadPublicKey(key)
 	rw.Write([]byte(signedJSON))
 }
 
 func (h *Handler) Sign(bb *schema.Builder) (string, error) {
-	bb.SetSigner(h.pubKeyBlobRef)
+	bb.SetSigner(h.primary.pubKeyBlobRef)
 	unsigned, err := bb.JSON()
 	if err != nil {
 		return "", err
 	}
 	sreq := &jsonsign.SignRequest{
-		UnsignedJSON:      unsigned,
-		Fetcher:           h.pubKeyFetcher,
-		ServerMode:        true,
-		SecretKeyringPath: h.secretRing,
+		UnsignedJSON:  unsigned,
+		Fetcher:       h.pubKeyFetcher,
+		ServerMode:    true,
+		EntityFetcher: h,
 	}
 	claimTime, err := bb.Blob().ClaimDate()
 	if err != nil {
@@ -280,6 +438,6 @@ func (h *Handler) Sign(bb *schema.Builder) (string, error) {
 	} else {
 		sreq.SignatureTime = claimTime
 	}
-	h.uploadPublicKey()
+	h.uploadPublicKey(h.primary)
 	return sreq.Sign()
 }
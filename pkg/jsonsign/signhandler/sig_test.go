@@ -0,0 +1,149 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signhandler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"camlistore.org/pkg/jsonconfig"
+	"camlistore.org/pkg/jsonsign"
+)
+
+const (
+	// in testdata/test-secring.gpg:
+	primaryKeyID     = "26F5ABDA"
+	primaryKeyIDLong = "2931A67C26F5ABDA"
+	// in testdata/test-secring2.gpg:
+	secondaryKeyID     = "4BEC5AB5"
+	secondaryKeyIDLong = "851E08B24BEC5AB5"
+)
+
+func newTestHandler(t *testing.T) *Handler {
+	hi, err := newJSONSignFromConfig(nil, jsonconfig.Obj{
+		"keyId":      primaryKeyID,
+		"secretRing": "../testdata/test-secring.gpg",
+		"secondaryKeys": map[string]interface{}{
+			secondaryKeyID: "../testdata/test-secring2.gpg",
+		},
+	})
+	if err != nil {
+		t.Fatalf("newJSONSignFromConfig: %v", err)
+	}
+	return hi.(*Handler)
+}
+
+// sign posts an unsigned permanode blob to the handler with the given
+// extra form values and returns the HTTP status code and body.
+func sign(h *Handler, form url.Values) (int, string) {
+	unsigned := `{"camliVersion": 1,
+"camliType": "permanode",
+"camliSigner": "` + h.primary.pubKeyBlobRef.String() + `",
+"random": "foo"
+}`
+	form.Set("json", unsigned)
+	req, _ := http.NewRequest("POST", "/sig/camli/sig/sign", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	h.handleSign(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+// verifiedKeyID verifies sjson with the handler's verify endpoint and
+// returns the signing key ID.
+func verifiedKeyID(t *testing.T, h *Handler, sjson string) string {
+	req, _ := http.NewRequest("POST", "/sig/camli/sig/verify",
+		strings.NewReader(url.Values{"sjson": {sjson}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	h.handleVerify(rec, req)
+	var res struct {
+		SignatureValid int    `json:"signatureValid"`
+		SignerKeyId    string `json:"signerKeyId"`
+		ErrorMessage   string `json:"errorMessage"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("parsing verify response %q: %v", rec.Body.String(), err)
+	}
+	if res.SignatureValid != 1 {
+		t.Fatalf("signature on %q invalid: %s", sjson, res.ErrorMessage)
+	}
+	return res.SignerKeyId
+}
+
+func TestSignDefaultKey(t *testing.T) {
+	h := newTestHandler(t)
+	code, body := sign(h, url.Values{})
+	if code != 200 {
+		t.Fatalf("sign status = %d (%s); want 200", code, body)
+	}
+	if got := verifiedKeyID(t, h, body); got != primaryKeyIDLong {
+		t.Errorf("signed with key %q; want primary %q", got, primaryKeyIDLong)
+	}
+}
+
+func TestSignSelectedKey(t *testing.T) {
+	h := newTestHandler(t)
+	// The secondary key should be selectable by its configured
+	// ID, its long ID, and its public key blobref.
+	sec := h.signingKey(secondaryKeyID)
+	if sec == nil {
+		t.Fatal("secondary key not configured")
+	}
+	for _, signer := range []string{secondaryKeyID, secondaryKeyIDLong, sec.pubKeyBlobRef.String()} {
+		code, body := sign(h, url.Values{"signer": {signer}})
+		if code != 200 {
+			t.Fatalf("sign with signer=%q status = %d (%s); want 200", signer, code, body)
+		}
+		if !strings.Contains(body, sec.pubKeyBlobRef.String()) {
+			t.Errorf("signer=%q: blob doesn't name the secondary camliSigner %v: %s", signer, sec.pubKeyBlobRef, body)
+		}
+		if got := verifiedKeyID(t, h, body); got != secondaryKeyIDLong {
+			t.Errorf("signer=%q: signed with key %q; want %q", signer, got, secondaryKeyIDLong)
+		}
+	}
+}
+
+func TestSignUnknownKey(t *testing.T) {
+	h := newTestHandler(t)
+	code, body := sign(h, url.Values{"signer": {"DEADBEEF"}})
+	if code != 400 {
+		t.Errorf("sign with unknown signer status = %d (%s); want 400", code, body)
+	}
+}
+
+func TestFetchEntity(t *testing.T) {
+	h := newTestHandler(t)
+	var _ jsonsign.EntityFetcher = h
+	for _, keyID := range []string{primaryKeyIDLong, secondaryKeyIDLong} {
+		e, err := h.FetchEntity(keyID)
+		if err != nil {
+			t.Errorf("FetchEntity(%q) = %v", keyID, err)
+			continue
+		}
+		if got := e.PrimaryKey.KeyIdString(); got != keyID {
+			t.Errorf("FetchEntity(%q) returned entity for key %q", keyID, got)
+		}
+	}
+	if _, err := h.FetchEntity("DEADBEEFDEADBEEF"); err == nil {
+		t.Error("FetchEntity of unconfigured key succeeded")
+	}
+}
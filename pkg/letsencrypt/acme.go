@@ -0,0 +1,373 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package letsencrypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// directoryURL is the production Let's Encrypt ACME v1 directory.
+const directoryURL = "https://acme-v01.api.letsencrypt.org/directory"
+
+// acmeClient is a minimal ACME v1 (draft-ietf-acme-acme-01) client,
+// implementing just enough of the protocol to get a certificate from
+// Let's Encrypt with the tls-sni-01 challenge.
+type acmeClient struct {
+	key *rsa.PrivateKey // account key; signs every request
+
+	dir struct {
+		NewReg   string `json:"new-reg"`
+		NewAuthz string `json:"new-authz"`
+		NewCert  string `json:"new-cert"`
+		Meta     struct {
+			TermsOfService string `json:"terms-of-service"`
+		} `json:"meta"`
+	}
+	nonce string // Replay-Nonce from the most recent response
+}
+
+// challenge is one entry of an authorization's "challenges" list.
+type challenge struct {
+	Type  string `json:"type"`
+	URI   string `json:"uri"`
+	Token string `json:"token"`
+}
+
+// b64 is the unpadded base64url encoding JOSE uses everywhere.
+func b64(b []byte) string {
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(b), "=")
+}
+
+func newACMEClient(key *rsa.PrivateKey) (*acmeClient, error) {
+	c := &acmeClient{key: key}
+	res, err := http.Get(directoryURL)
+	if err != nil {
+		return nil, fmt.Errorf("letsencrypt: fetching ACME directory: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, acmeError("fetching ACME directory", res)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&c.dir); err != nil {
+		return nil, fmt.Errorf("letsencrypt: parsing ACME directory: %v", err)
+	}
+	c.nonce = res.Header.Get("Replay-Nonce")
+	return c, nil
+}
+
+// acmeError describes an unexpected server response, including the
+// problem document the ACME server returns as the body.
+func acmeError(op string, res *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4<<10))
+	return fmt.Errorf("letsencrypt: %s: HTTP status %v: %s", op, res.Status, bytes.TrimSpace(body))
+}
+
+// jwk returns the account's public key as a JSON Web Key.
+func (c *acmeClient) jwk() map[string]string {
+	return map[string]string{
+		"e":   b64(big.NewInt(int64(c.key.PublicKey.E)).Bytes()),
+		"kty": "RSA",
+		"n":   b64(c.key.PublicKey.N.Bytes()),
+	}
+}
+
+// thumbprint returns the RFC 7638 SHA-256 thumbprint of the account
+// key, with the JWK fields in the required lexicographic order.
+func (c *acmeClient) thumbprint() string {
+	jwk := c.jwk()
+	sum := sha256.Sum256([]byte(fmt.Sprintf(`{"e":%q,"kty":%q,"n":%q}`, jwk["e"], jwk["kty"], jwk["n"])))
+	return b64(sum[:])
+}
+
+// keyAuthorization returns the key authorization string binding the
+// challenge token to the account key.
+func (c *acmeClient) keyAuthorization(token string) string {
+	return token + "." + c.thumbprint()
+}
+
+// post signs payload as a JWS with the account key and POSTs it to
+// url. The caller owns the response body.
+func (c *acmeClient) post(url string, payload interface{}) (*http.Response, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	if c.nonce == "" {
+		res, err := http.Head(directoryURL)
+		if err != nil {
+			return nil, fmt.Errorf("letsencrypt: fetching nonce: %v", err)
+		}
+		res.Body.Close()
+		c.nonce = res.Header.Get("Replay-Nonce")
+		if c.nonce == "" {
+			return nil, errors.New("letsencrypt: ACME server sent no Replay-Nonce")
+		}
+	}
+	protected, err := json.Marshal(map[string]string{"nonce": c.nonce})
+	if err != nil {
+		return nil, err
+	}
+	c.nonce = ""
+	signingInput := b64(protected) + "." + b64(body)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, c.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	jws, err := json.Marshal(map[string]interface{}{
+		"header": map[string]interface{}{
+			"alg": "RS256",
+			"jwk": c.jwk(),
+		},
+		"protected": b64(protected),
+		"payload":   b64(body),
+		"signature": b64(sig),
+	})
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.Post(url, "application/jose+json", bytes.NewReader(jws))
+	if err != nil {
+		return nil, err
+	}
+	if n := res.Header.Get("Replay-Nonce"); n != "" {
+		c.nonce = n
+	}
+	return res, nil
+}
+
+// linkHeader returns the URL of the Link response header with the
+// given rel, or "".
+func linkHeader(res *http.Response, rel string) string {
+	for _, link := range res.Header["Link"] {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 {
+			continue
+		}
+		url := strings.Trim(strings.TrimSpace(parts[0]), "<>")
+		for _, part := range parts[1:] {
+			if strings.TrimSpace(part) == fmt.Sprintf("rel=%q", rel) {
+				return url
+			}
+		}
+	}
+	return ""
+}
+
+// register creates the ACME account for the key, agreeing to the
+// CA's terms of service. A key that's already registered is not an
+// error.
+func (c *acmeClient) register() error {
+	res, err := c.post(c.dir.NewReg, map[string]string{
+		"resource":  "new-reg",
+		"agreement": c.dir.Meta.TermsOfService,
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusCreated:
+	case http.StatusConflict: // already registered
+		return nil
+	default:
+		return acmeError("registering account", res)
+	}
+	// If the directory didn't carry the terms URL, it comes as a
+	// Link on the registration; agree to it now.
+	if c.dir.Meta.TermsOfService != "" {
+		return nil
+	}
+	terms := linkHeader(res, "terms-of-service")
+	regURL := res.Header.Get("Location")
+	if terms == "" || regURL == "" {
+		return nil
+	}
+	res2, err := c.post(regURL, map[string]string{
+		"resource":  "reg",
+		"agreement": terms,
+	})
+	if err != nil {
+		return err
+	}
+	defer res2.Body.Close()
+	if res2.StatusCode >= 300 {
+		return acmeError("agreeing to terms of service", res2)
+	}
+	return nil
+}
+
+// authorize starts a new authorization for domain and returns its
+// URL and its tls-sni-01 challenge.
+func (c *acmeClient) authorize(domain string) (authzURL string, chal *challenge, err error) {
+	res, err := c.post(c.dir.NewAuthz, map[string]interface{}{
+		"resource": "new-authz",
+		"identifier": map[string]string{
+			"type":  "dns",
+			"value": domain,
+		},
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		return "", nil, acmeError("requesting authorization", res)
+	}
+	var authz struct {
+		Challenges []*challenge `json:"challenges"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&authz); err != nil {
+		return "", nil, fmt.Errorf("letsencrypt: parsing authorization: %v", err)
+	}
+	for _, ch := range authz.Challenges {
+		if ch.Type == "tls-sni-01" {
+			return res.Header.Get("Location"), ch, nil
+		}
+	}
+	return "", nil, fmt.Errorf("letsencrypt: ACME server offered no tls-sni-01 challenge for %v", domain)
+}
+
+// acceptChallenge tells the server the challenge is ready to be
+// validated.
+func (c *acmeClient) acceptChallenge(chal *challenge) error {
+	res, err := c.post(chal.URI, map[string]string{
+		"resource":         "challenge",
+		"keyAuthorization": c.keyAuthorization(chal.Token),
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return acmeError("accepting challenge", res)
+	}
+	return nil
+}
+
+// waitAuthorization polls authzURL until the authorization becomes
+// valid, the server reports failure, or a minute passes.
+func (c *acmeClient) waitAuthorization(authzURL string) error {
+	deadline := time.Now().Add(time.Minute)
+	for {
+		res, err := http.Get(authzURL)
+		if err != nil {
+			return err
+		}
+		var authz struct {
+			Status string `json:"status"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&authz)
+		res.Body.Close()
+		if err != nil {
+			return fmt.Errorf("letsencrypt: polling authorization: %v", err)
+		}
+		switch authz.Status {
+		case "valid":
+			return nil
+		case "pending", "processing", "":
+			if time.Now().After(deadline) {
+				return errors.New("letsencrypt: timeout waiting for challenge validation")
+			}
+			time.Sleep(time.Second)
+		default:
+			return fmt.Errorf("letsencrypt: challenge validation failed with status %q", authz.Status)
+		}
+	}
+}
+
+// newCert requests a certificate for the DER-encoded CSR and returns
+// the DER certificate chain, leaf first.
+func (c *acmeClient) newCert(csr []byte) ([][]byte, error) {
+	res, err := c.post(c.dir.NewCert, map[string]string{
+		"resource": "new-cert",
+		"csr":      b64(csr),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		return nil, acmeError("requesting certificate", res)
+	}
+	leaf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(leaf) == 0 {
+		return nil, errors.New("letsencrypt: ACME server returned an empty certificate")
+	}
+	chain := [][]byte{leaf}
+	if issuerURL := linkHeader(res, "up"); issuerURL != "" {
+		ires, err := http.Get(issuerURL)
+		if err != nil {
+			return nil, fmt.Errorf("letsencrypt: fetching issuer certificate: %v", err)
+		}
+		defer ires.Body.Close()
+		if ires.StatusCode == http.StatusOK {
+			if issuer, err := ioutil.ReadAll(ires.Body); err == nil && len(issuer) > 0 {
+				chain = append(chain, issuer)
+			}
+		}
+	}
+	return chain, nil
+}
+
+// challengeCert builds the self-signed certificate to present for the
+// tls-sni-01 challenge of keyAuth, and returns it along with the SNI
+// name it must be served for.
+func challengeCert(keyAuth string) (tls.Certificate, string, error) {
+	z := fmt.Sprintf("%x", sha256.Sum256([]byte(keyAuth)))
+	san := z[:32] + "." + z[32:] + ".acme.invalid"
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: san},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		DNSNames:     []string{san},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, san, nil
+}
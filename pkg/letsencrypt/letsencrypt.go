@@ -0,0 +1,283 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package letsencrypt obtains and renews a TLS certificate for a domain
+from the Let's Encrypt CA, speaking ACME v1 with the tls-sni-01
+challenge. The challenge is answered over the HTTPS port the server is
+already listening on, so no extra port or external client is needed:
+install Manager.GetCertificate as the tls.Config.GetCertificate
+callback and the certificate is obtained on the first handshake and
+renewed in the background thereafter.
+
+The account key, certificate, and certificate key are cached as PEM
+files in a directory, so restarts reuse them.
+*/
+package letsencrypt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// renewBefore is how long before expiry the certificate is renewed.
+const renewBefore = 30 * 24 * time.Hour
+
+// Manager provides the TLS certificate for one domain, obtaining and
+// renewing it from the CA as needed.
+type Manager struct {
+	domain   string
+	cacheDir string
+	client   *acmeClient
+
+	obtainMu sync.Mutex // serializes calls to obtain
+
+	mu             sync.Mutex
+	cert           *tls.Certificate
+	leaf           *x509.Certificate
+	challengeCerts map[string]*tls.Certificate // SNI name -> pending challenge cert
+	renewing       bool
+}
+
+// NewManager returns a Manager providing certificates for domain,
+// caching its state in dir, which is created if necessary. The CA is
+// not contacted until a certificate is first needed.
+func NewManager(domain, dir string) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("letsencrypt: creating cache directory: %v", err)
+	}
+	accountKey, err := loadOrCreateKey(filepath.Join(dir, "account.key"))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manager{
+		domain:         domain,
+		cacheDir:       dir,
+		client:         &acmeClient{key: accountKey},
+		challengeCerts: make(map[string]*tls.Certificate),
+	}
+	m.loadCert()
+	return m, nil
+}
+
+func (m *Manager) certFile() string { return filepath.Join(m.cacheDir, m.domain+".crt") }
+func (m *Manager) keyFile() string  { return filepath.Join(m.cacheDir, m.domain+".key") }
+
+// Certificate returns the domain's current certificate, or nil if
+// none has been obtained yet.
+func (m *Manager) Certificate() *x509.Certificate {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.leaf
+}
+
+// GetCertificate returns the certificate to present for hello. It's
+// meant to be installed as a tls.Config.GetCertificate callback.
+// When no valid certificate is cached, it runs the ACME flow before
+// answering; when the certificate is merely nearing expiry, it
+// renews in the background and answers with the current one.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if name := hello.ServerName; strings.HasSuffix(name, ".acme.invalid") {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if cert, ok := m.challengeCerts[name]; ok {
+			return cert, nil
+		}
+		return nil, fmt.Errorf("letsencrypt: no pending challenge for %q", name)
+	}
+
+	m.mu.Lock()
+	cert, leaf := m.cert, m.leaf
+	m.mu.Unlock()
+	if cert == nil || time.Now().After(leaf.NotAfter) {
+		if err := m.obtain(); err != nil {
+			return nil, err
+		}
+		m.mu.Lock()
+		cert = m.cert
+		m.mu.Unlock()
+		return cert, nil
+	}
+	if leaf.NotAfter.Sub(time.Now()) < renewBefore {
+		go m.renew()
+	}
+	return cert, nil
+}
+
+// renew runs obtain in the background, at most once at a time.
+func (m *Manager) renew() {
+	m.mu.Lock()
+	if m.renewing {
+		m.mu.Unlock()
+		return
+	}
+	m.renewing = true
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.renewing = false
+		m.mu.Unlock()
+	}()
+	if err := m.obtain(); err != nil {
+		log.Printf("letsencrypt: renewing certificate for %v: %v", m.domain, err)
+	}
+}
+
+// obtain runs the ACME flow: it registers the account if needed,
+// answers a tls-sni-01 challenge for the domain, sends a CSR, and
+// installs and caches the resulting certificate chain.
+func (m *Manager) obtain() error {
+	m.obtainMu.Lock()
+	defer m.obtainMu.Unlock()
+
+	// Another caller may have finished while we waited.
+	m.mu.Lock()
+	leaf := m.leaf
+	m.mu.Unlock()
+	if leaf != nil && leaf.NotAfter.Sub(time.Now()) >= renewBefore {
+		return nil
+	}
+
+	c := m.client
+	if c.dir.NewReg == "" {
+		nc, err := newACMEClient(c.key)
+		if err != nil {
+			return err
+		}
+		m.client = nc
+		c = nc
+	}
+	if err := c.register(); err != nil {
+		return err
+	}
+	authzURL, chal, err := c.authorize(m.domain)
+	if err != nil {
+		return err
+	}
+	chalCert, san, err := challengeCert(c.keyAuthorization(chal.Token))
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.challengeCerts[san] = &chalCert
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.challengeCerts, san)
+		m.mu.Unlock()
+	}()
+	if err := c.acceptChallenge(chal); err != nil {
+		return err
+	}
+	if err := c.waitAuthorization(authzURL); err != nil {
+		return err
+	}
+
+	certKey, err := loadOrCreateKey(m.keyFile())
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.domain},
+		DNSNames: []string{m.domain},
+	}, certKey)
+	if err != nil {
+		return err
+	}
+	chain, err := c.newCert(csr)
+	if err != nil {
+		return err
+	}
+	newLeaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return fmt.Errorf("letsencrypt: parsing new certificate: %v", err)
+	}
+
+	var pemOut []byte
+	for _, der := range chain {
+		pemOut = append(pemOut, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	if err := ioutil.WriteFile(m.certFile(), pemOut, 0600); err != nil {
+		return fmt.Errorf("letsencrypt: writing certificate: %v", err)
+	}
+
+	m.mu.Lock()
+	m.cert = &tls.Certificate{
+		Certificate: chain,
+		PrivateKey:  certKey,
+		Leaf:        newLeaf,
+	}
+	m.leaf = newLeaf
+	m.mu.Unlock()
+	log.Printf("letsencrypt: obtained certificate for %v, valid until %v", m.domain, newLeaf.NotAfter)
+	return nil
+}
+
+// loadCert loads a previously obtained certificate and key from the
+// cache directory, ignoring them if missing or unparseable.
+func (m *Manager) loadCert() {
+	cert, err := tls.LoadX509KeyPair(m.certFile(), m.keyFile())
+	if err != nil {
+		return
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return
+	}
+	cert.Leaf = leaf
+	m.cert, m.leaf = &cert, leaf
+}
+
+// loadOrCreateKey loads the PEM RSA private key in file, generating
+// and writing it first if it doesn't exist.
+func loadOrCreateKey(file string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("letsencrypt: no PEM block found in %v", file)
+		}
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("letsencrypt: parsing key %v: %v", file, err)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	data = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return nil, fmt.Errorf("letsencrypt: writing key %v: %v", file, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package maintenance tracks whether the server is in read-only
+// maintenance mode, in which writes (blob uploads and removes,
+// signing, importer runs) are refused with a 503 while reads keep
+// working. The mode is in-memory state: it survives a handler
+// reconfiguration but not a process restart, unless the high-level
+// config sets "readonly" to start in it.
+package maintenance
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// RetryAfterSeconds is the Retry-After value, in seconds, sent with
+// refused writes, hinting clients when to try again.
+const RetryAfterSeconds = 300
+
+// DefaultMessage explains a refused write when no custom message was
+// set with the mode.
+const DefaultMessage = "server is in read-only maintenance mode; writes are temporarily refused"
+
+var (
+	mu      sync.RWMutex
+	enabled bool
+	message string
+)
+
+// Set puts the server in (or takes it out of) read-only maintenance
+// mode. A non-empty msg replaces DefaultMessage as the explanation
+// sent with refused writes.
+func Set(on bool, msg string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if on == enabled && msg == message {
+		return
+	}
+	enabled, message = on, msg
+	if on {
+		log.Printf("maintenance: read-only mode enabled")
+	} else {
+		log.Printf("maintenance: read-only mode disabled")
+	}
+}
+
+// Enabled reports whether read-only maintenance mode is on.
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return enabled
+}
+
+// Message returns the explanation sent with refused writes, or the
+// empty string when maintenance mode is off.
+func Message() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if !enabled {
+		return ""
+	}
+	if message != "" {
+		return message
+	}
+	return DefaultMessage
+}
+
+// CanWrite reports whether writes may proceed, returning nil if so,
+// or an error with the human-readable explanation when in maintenance
+// mode. As with diskcheck.CanWrite, internal paths (e.g. sync to the
+// index) should not consult it, so data already accepted stays
+// consistent.
+func CanWrite() error {
+	if msg := Message(); msg != "" {
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// Refuse replies to a refused write with a 503, a Retry-After header,
+// and the current explanation. Callers should have checked Enabled
+// first.
+func Refuse(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(RetryAfterSeconds))
+	msg := Message()
+	if msg == "" {
+		msg = DefaultMessage
+	}
+	http.Error(w, msg, http.StatusServiceUnavailable)
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package maintenance
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMaintenance(t *testing.T) {
+	defer Set(false, "")
+
+	if Enabled() {
+		t.Fatal("maintenance mode on by default")
+	}
+	if err := CanWrite(); err != nil {
+		t.Fatalf("CanWrite with mode off = %v; want nil", err)
+	}
+	if got := Message(); got != "" {
+		t.Fatalf("Message with mode off = %q; want empty", got)
+	}
+
+	Set(true, "")
+	if !Enabled() {
+		t.Fatal("maintenance mode off after Set(true)")
+	}
+	if got := Message(); got != DefaultMessage {
+		t.Errorf("Message = %q; want DefaultMessage", got)
+	}
+	if err := CanWrite(); err == nil || err.Error() != DefaultMessage {
+		t.Errorf("CanWrite = %v; want DefaultMessage error", err)
+	}
+
+	Set(true, "back after the migration")
+	if got, want := Message(), "back after the migration"; got != want {
+		t.Errorf("Message = %q; want %q", got, want)
+	}
+
+	rec := httptest.NewRecorder()
+	Refuse(rec)
+	if rec.Code != 503 {
+		t.Errorf("Refuse status = %d; want 503", rec.Code)
+	}
+	if got := rec.HeaderMap.Get("Retry-After"); got != "300" {
+		t.Errorf("Retry-After = %q; want \"300\"", got)
+	}
+	if got, want := rec.Body.String(), "back after the migration\n"; got != want {
+		t.Errorf("Refuse body = %q; want %q", got, want)
+	}
+
+	Set(false, "")
+	if Enabled() || CanWrite() != nil {
+		t.Error("maintenance mode still on after Set(false)")
+	}
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The subset of DNS record types needed for DNS-SD (RFC 6763)
+// service advertisement and browsing.
+const (
+	typePTR = 12
+	typeTXT = 16
+	typeSRV = 33
+	typeANY = 255
+
+	classIN = 1
+	// cacheFlush is the top bit of the class field in a resource
+	// record, indicating a unique record set (RFC 6762, section 10.2).
+	cacheFlush = 0x8000
+)
+
+// question is a single question of a DNS message.
+type question struct {
+	name  string
+	qtype uint16
+}
+
+// resource is a single resource record of a DNS message, with its
+// rdata already decoded. Which of target, port and txt are meaningful
+// depends on rtype.
+type resource struct {
+	name  string
+	rtype uint16
+	ttl   uint32
+
+	target string            // PTR and SRV
+	port   uint16            // SRV
+	txt    map[string]string // TXT
+}
+
+// msg is a DNS message, restricted to what mDNS service discovery
+// needs. Resource records from the answer, authority, and additional
+// sections are all collected in answers.
+type msg struct {
+	id        uint16
+	response  bool
+	questions []question
+	answers   []resource
+}
+
+// pack encodes m in DNS wire format, without name compression.
+func (m *msg) pack() ([]byte, error) {
+	var b []byte
+	var flags uint16
+	if m.response {
+		flags = 1<<15 | 1<<10 // QR, AA
+	}
+	b = append16(b, m.id)
+	b = append16(b, flags)
+	b = append16(b, uint16(len(m.questions)))
+	b = append16(b, uint16(len(m.answers)))
+	b = append16(b, 0)
+	b = append16(b, 0)
+	for _, q := range m.questions {
+		nb, err := packName(q.name)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, nb...)
+		b = append16(b, q.qtype)
+		b = append16(b, classIN)
+	}
+	for _, rr := range m.answers {
+		nb, err := packName(rr.name)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, nb...)
+		b = append16(b, rr.rtype)
+		b = append16(b, classIN|cacheFlush)
+		b = append32(b, rr.ttl)
+		rdata, err := rr.packData()
+		if err != nil {
+			return nil, err
+		}
+		b = append16(b, uint16(len(rdata)))
+		b = append(b, rdata...)
+	}
+	return b, nil
+}
+
+func (rr *resource) packData() ([]byte, error) {
+	switch rr.rtype {
+	case typePTR:
+		return packName(rr.target)
+	case typeSRV:
+		nb, err := packName(rr.target)
+		if err != nil {
+			return nil, err
+		}
+		var b []byte
+		b = append16(b, 0) // priority
+		b = append16(b, 0) // weight
+		b = append16(b, rr.port)
+		return append(b, nb...), nil
+	case typeTXT:
+		var keys []string
+		for k := range rr.txt {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var b []byte
+		for _, k := range keys {
+			s := k + "=" + rr.txt[k]
+			if len(s) > 255 {
+				return nil, fmt.Errorf("mdns: TXT entry for %q too long", k)
+			}
+			b = append(b, byte(len(s)))
+			b = append(b, s...)
+		}
+		if len(b) == 0 {
+			b = []byte{0}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("mdns: can't pack resource type %d", rr.rtype)
+}
+
+// parseMsg decodes the DNS message in b.
+// Resource records of types other than PTR, SRV, and TXT are skipped.
+func parseMsg(b []byte) (*msg, error) {
+	if len(b) < 12 {
+		return nil, errors.New("mdns: message too short")
+	}
+	m := &msg{
+		id:       binary.BigEndian.Uint16(b),
+		response: b[2]&0x80 != 0,
+	}
+	qd := int(binary.BigEndian.Uint16(b[4:]))
+	rrcount := int(binary.BigEndian.Uint16(b[6:])) +
+		int(binary.BigEndian.Uint16(b[8:])) +
+		int(binary.BigEndian.Uint16(b[10:]))
+	off := 12
+	for i := 0; i < qd; i++ {
+		name, n, err := parseName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n
+		if off+4 > len(b) {
+			return nil, errors.New("mdns: truncated question")
+		}
+		m.questions = append(m.questions, question{
+			name:  name,
+			qtype: binary.BigEndian.Uint16(b[off:]),
+		})
+		off += 4
+	}
+	for i := 0; i < rrcount; i++ {
+		name, n, err := parseName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n
+		if off+10 > len(b) {
+			return nil, errors.New("mdns: truncated resource record")
+		}
+		rr := resource{
+			name:  name,
+			rtype: binary.BigEndian.Uint16(b[off:]),
+			ttl:   binary.BigEndian.Uint32(b[off+4:]),
+		}
+		rdlen := int(binary.BigEndian.Uint16(b[off+8:]))
+		off += 10
+		if off+rdlen > len(b) {
+			return nil, errors.New("mdns: truncated rdata")
+		}
+		if err := rr.parseData(b, off, rdlen); err != nil {
+			return nil, err
+		}
+		off += rdlen
+		if rr.rtype == typePTR || rr.rtype == typeSRV || rr.rtype == typeTXT {
+			m.answers = append(m.answers, rr)
+		}
+	}
+	return m, nil
+}
+
+func (rr *resource) parseData(b []byte, off, rdlen int) (err error) {
+	switch rr.rtype {
+	case typePTR:
+		rr.target, _, err = parseName(b, off)
+	case typeSRV:
+		if rdlen < 6 {
+			return errors.New("mdns: SRV rdata too short")
+		}
+		rr.port = binary.BigEndian.Uint16(b[off+4:])
+		rr.target, _, err = parseName(b, off+6)
+	case typeTXT:
+		rr.txt = make(map[string]string)
+		end := off + rdlen
+		for off < end {
+			n := int(b[off])
+			off++
+			if off+n > end {
+				return errors.New("mdns: truncated TXT entry")
+			}
+			s := string(b[off : off+n])
+			off += n
+			if i := strings.Index(s, "="); i > 0 {
+				rr.txt[s[:i]] = s[i+1:]
+			}
+		}
+	}
+	return err
+}
+
+// packName encodes the domain name s (ex: "_camlistore._tcp.local.")
+// as a sequence of length-prefixed labels.
+func packName(s string) ([]byte, error) {
+	var b []byte
+	for _, label := range strings.Split(strings.TrimSuffix(s, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("mdns: invalid label in name %q", s)
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+// parseName decodes the possibly-compressed domain name at b[off:],
+// returning it in dotted form with a trailing dot, and the offset of
+// the first byte after the name.
+func parseName(b []byte, off int) (name string, next int, err error) {
+	var labels []string
+	next = -1 // set on the first compression pointer
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, errors.New("mdns: truncated name")
+		}
+		n := int(b[off])
+		switch {
+		case n == 0:
+			if next == -1 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(b) {
+				return "", 0, errors.New("mdns: truncated compression pointer")
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, errors.New("mdns: compression pointer loop")
+			}
+			if next == -1 {
+				next = off + 2
+			}
+			off = n&0x3F<<8 | int(b[off+1])
+		case n&0xC0 != 0:
+			return "", 0, errors.New("mdns: bad label length")
+		default:
+			if off+1+n > len(b) {
+				return "", 0, errors.New("mdns: truncated label")
+			}
+			labels = append(labels, string(b[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+func append16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func append32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
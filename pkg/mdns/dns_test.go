@@ -0,0 +1,115 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mdns
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnnouncementRoundTrip(t *testing.T) {
+	svc := &Service{
+		Instance: "home",
+		Host:     "home.local.",
+		Port:     3179,
+		TXT: map[string]string{
+			"url": "https://home.local:3179",
+			"fp":  "sha256-deadbeef",
+		},
+	}
+	b, err := svc.announcement().pack()
+	if err != nil {
+		t.Fatalf("pack: %v", err)
+	}
+	m, err := parseMsg(b)
+	if err != nil {
+		t.Fatalf("parseMsg: %v", err)
+	}
+	if !m.response {
+		t.Error("parsed message is not a response")
+	}
+	if len(m.answers) != 3 {
+		t.Fatalf("got %d answers; want 3", len(m.answers))
+	}
+	ptr, srv, txt := m.answers[0], m.answers[1], m.answers[2]
+	if ptr.rtype != typePTR || ptr.name != ServiceType || ptr.target != "home."+ServiceType {
+		t.Errorf("bad PTR record: %+v", ptr)
+	}
+	if srv.rtype != typeSRV || srv.name != "home."+ServiceType || srv.target != "home.local." || srv.port != 3179 {
+		t.Errorf("bad SRV record: %+v", srv)
+	}
+	if txt.rtype != typeTXT || !reflect.DeepEqual(txt.txt, svc.TXT) {
+		t.Errorf("bad TXT record: %+v", txt)
+	}
+}
+
+func TestQueryRoundTrip(t *testing.T) {
+	query := &msg{
+		questions: []question{{name: ServiceType, qtype: typePTR}},
+	}
+	b, err := query.pack()
+	if err != nil {
+		t.Fatalf("pack: %v", err)
+	}
+	m, err := parseMsg(b)
+	if err != nil {
+		t.Fatalf("parseMsg: %v", err)
+	}
+	if m.response {
+		t.Error("parsed query claims to be a response")
+	}
+	if len(m.questions) != 1 || m.questions[0] != query.questions[0] {
+		t.Errorf("got questions %+v; want %+v", m.questions, query.questions)
+	}
+}
+
+func TestParseCompressedName(t *testing.T) {
+	// A hand-built message with one PTR answer whose rdata points
+	// back at the record's own name with a compression pointer.
+	var b []byte
+	b = append16(b, 0)     // id
+	b = append16(b, 1<<15) // QR
+	b = append16(b, 0)     // qdcount
+	b = append16(b, 1)     // ancount
+	b = append16(b, 0)     // nscount
+	b = append16(b, 0)     // arcount
+	name, _ := packName("x.local.")
+	b = append(b, name...) // offset 12
+	b = append16(b, typePTR)
+	b = append16(b, classIN)
+	b = append32(b, 120)
+	b = append16(b, 4)      // rdlength
+	b = append(b, 1, 'y')   // label "y"
+	b = append(b, 0xC0, 12) // pointer to "x.local." at offset 12
+	m, err := parseMsg(b)
+	if err != nil {
+		t.Fatalf("parseMsg: %v", err)
+	}
+	if len(m.answers) != 1 {
+		t.Fatalf("got %d answers; want 1", len(m.answers))
+	}
+	if got, want := m.answers[0].target, "y.x.local."; got != want {
+		t.Errorf("target = %q; want %q", got, want)
+	}
+}
+
+func TestParseNameLoop(t *testing.T) {
+	// A compression pointer pointing at itself must not hang.
+	if _, _, err := parseName([]byte{0xC0, 0}, 0); err == nil {
+		t.Error("parseName on a pointer loop succeeded; want error")
+	}
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mdns advertises a Camlistore server on the local network
+// with multicast DNS (RFC 6762) and DNS-SD (RFC 6763), and browses
+// for such advertisements. It implements just the small subset of DNS
+// needed for that; it is not a general mDNS responder.
+package mdns
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ServiceType is the DNS-SD service type under which Camlistore
+// servers advertise themselves.
+const ServiceType = "_camlistore._tcp.local."
+
+const (
+	mdnsAddr = "224.0.0.251:5353"
+
+	// recordTTL is the TTL of the advertised records, in seconds.
+	recordTTL = 120
+)
+
+// A Service describes one advertised (or discovered) Camlistore
+// server.
+type Service struct {
+	Instance string // instance name (ex: the server's hostname)
+	Host     string // host name the SRV record points at (ex: "home.local.")
+	Port     int
+
+	// TXT records of the advertisement. Camlistore servers set
+	// "url" to their baseURL, and "fp" to their TLS certificate's
+	// SHA-256 fingerprint prefix (the same form the client
+	// config's trustedCerts uses), if running HTTPS.
+	TXT map[string]string
+}
+
+// BaseURL returns the server's advertised base URL, or the empty
+// string if the advertisement lacked one.
+func (s *Service) BaseURL() string { return s.TXT["url"] }
+
+// Fingerprint returns the server's advertised TLS certificate
+// fingerprint, or the empty string for non-HTTPS servers.
+func (s *Service) Fingerprint() string { return s.TXT["fp"] }
+
+func (s *Service) instanceName() string {
+	return s.Instance + "." + ServiceType
+}
+
+// announcement builds the mDNS response message advertising s.
+func (s *Service) announcement() *msg {
+	return &msg{
+		response: true,
+		answers: []resource{
+			{name: ServiceType, rtype: typePTR, ttl: recordTTL, target: s.instanceName()},
+			{name: s.instanceName(), rtype: typeSRV, ttl: recordTTL, target: s.Host, port: uint16(s.Port)},
+			{name: s.instanceName(), rtype: typeTXT, ttl: recordTTL, txt: s.TXT},
+		},
+	}
+}
+
+// Server is an active advertisement, returned by Advertise.
+type Server struct {
+	svc  *Service
+	conn *net.UDPConn
+	to   *net.UDPAddr
+}
+
+// Advertise starts answering mDNS queries for ServiceType with the
+// provided service, and sends one unsolicited announcement. The
+// returned Server stops advertising when closed.
+func Advertise(svc *Service) (*Server, error) {
+	if svc.Instance == "" || strings.Contains(svc.Instance, ".") {
+		return nil, fmt.Errorf("mdns: invalid instance name %q", svc.Instance)
+	}
+	to, err := net.ResolveUDPAddr("udp4", mdnsAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, to)
+	if err != nil {
+		return nil, fmt.Errorf("mdns: can't listen on %s: %v", mdnsAddr, err)
+	}
+	s := &Server{svc: svc, conn: conn, to: to}
+	if err := s.announce(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go s.serve()
+	return s, nil
+}
+
+// Close stops the advertisement.
+func (s *Server) Close() error {
+	return s.conn.Close()
+}
+
+func (s *Server) announce() error {
+	b, err := s.svc.announcement().pack()
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.WriteToUDP(b, s.to)
+	return err
+}
+
+func (s *Server) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			// Closed, or a network error either way we're done.
+			return
+		}
+		m, err := parseMsg(buf[:n])
+		if err != nil || m.response {
+			continue
+		}
+		for _, q := range m.questions {
+			if q.name == ServiceType && (q.qtype == typePTR || q.qtype == typeANY) {
+				if err := s.announce(); err != nil {
+					log.Printf("mdns: answering query: %v", err)
+				}
+				break
+			}
+		}
+	}
+}
+
+// Browse queries the local network for Camlistore servers and returns
+// the ones that answered within the given timeout.
+func Browse(timeout time.Duration) ([]*Service, error) {
+	to, err := net.ResolveUDPAddr("udp4", mdnsAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	query := &msg{
+		questions: []question{{name: ServiceType, qtype: typePTR}},
+	}
+	b, err := query.pack()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteToUDP(b, to); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	found := make(map[string]*Service)
+	byInstance := func(name string) *Service {
+		instance := strings.TrimSuffix(name, "."+ServiceType)
+		svc, ok := found[instance]
+		if !ok {
+			svc = &Service{Instance: instance, TXT: make(map[string]string)}
+			found[instance] = svc
+		}
+		return svc
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break // deadline reached
+		}
+		m, err := parseMsg(buf[:n])
+		if err != nil || !m.response {
+			continue
+		}
+		for _, rr := range m.answers {
+			switch rr.rtype {
+			case typeSRV:
+				if strings.HasSuffix(rr.name, "."+ServiceType) {
+					svc := byInstance(rr.name)
+					svc.Host = rr.target
+					svc.Port = int(rr.port)
+				}
+			case typeTXT:
+				if strings.HasSuffix(rr.name, "."+ServiceType) {
+					svc := byInstance(rr.name)
+					for k, v := range rr.txt {
+						svc.TXT[k] = v
+					}
+				}
+			}
+		}
+	}
+	var services []*Service
+	for _, svc := range found {
+		services = append(services, svc)
+	}
+	sort.Sort(byInstanceName(services))
+	return services, nil
+}
+
+type byInstanceName []*Service
+
+func (s byInstanceName) Len() int           { return len(s) }
+func (s byInstanceName) Less(i, j int) bool { return s[i].Instance < s[j].Instance }
+func (s byInstanceName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -36,6 +36,11 @@ type Auth struct {
 	AccessKey       string
 	SecretAccessKey string
 
+	// SecurityToken is the session token accompanying temporary
+	// credentials (e.g. from an EC2 IAM role). If non-empty, it
+	// is sent as the x-amz-security-token header on each request.
+	SecurityToken string
+
 	// Hostname is the S3 hostname to use.
 	// If empty, the standard US region of "s3.amazonaws.com" is
 	// used.
@@ -55,6 +60,11 @@ func (a *Auth) SignRequest(req *http.Request) {
 	if date := req.Header.Get("Date"); date == "" {
 		req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
 	}
+	if a.SecurityToken != "" {
+		// Must be set before signing; it's canonicalized along
+		// with the other x-amz-* headers.
+		req.Header.Set("x-amz-security-token", a.SecurityToken)
+	}
 	hm := hmac.New(sha1.New, []byte(a.SecretAccessKey))
 	ss := a.stringToSign(req)
 	// log.Printf("String to sign: %q (%x)", ss, ss)
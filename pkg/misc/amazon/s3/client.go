@@ -34,6 +34,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"camlistore.org/pkg/httputil"
@@ -45,6 +46,31 @@ const maxList = 1000
 type Client struct {
 	*Auth
 	Transport http.RoundTripper // or nil for the default
+
+	// PathStyle forces the client to address buckets as
+	// "hostname/bucket/" instead of "bucket.hostname/". Some
+	// S3-compatible servers (e.g. Ceph radosgw) only support
+	// path-style addressing.
+	PathStyle bool
+
+	// ServerSideEncryption, if non-empty, is sent as the
+	// x-amz-server-side-encryption header on uploads,
+	// e.g. "AES256" (SSE-S3) or "aws:kms" (SSE-KMS).
+	ServerSideEncryption string
+
+	// StorageClass, if non-empty, is sent as the x-amz-storage-class
+	// header on uploads, e.g. "STANDARD_IA".
+	StorageClass string
+
+	// UseIAMRole makes the client fetch temporary credentials
+	// for the EC2 instance's IAM role from the instance metadata
+	// service, refreshing them before they expire. Auth's
+	// AccessKey, SecretAccessKey, and SecurityToken are then
+	// managed by the client and should be left empty.
+	UseIAMRole bool
+
+	credsMu     sync.Mutex
+	credsExpire time.Time // when the IAM role credentials in Auth expire
 }
 
 type Bucket struct {
@@ -61,7 +87,7 @@ func (c *Client) transport() http.RoundTripper {
 
 // bucketURL returns the URL prefix of the bucket, with trailing slash
 func (c *Client) bucketURL(bucket string) string {
-	if IsValidBucket(bucket) && !strings.Contains(bucket, ".") {
+	if !c.PathStyle && IsValidBucket(bucket) && !strings.Contains(bucket, ".") {
 		return fmt.Sprintf("https://%s.%s/", bucket, c.hostname())
 	}
 	return fmt.Sprintf("https://%s/%s/", c.hostname(), bucket)
@@ -82,7 +108,9 @@ func newReq(url_ string) *http.Request {
 
 func (c *Client) Buckets() ([]*Bucket, error) {
 	req := newReq("https://" + c.hostname() + "/")
-	c.Auth.SignRequest(req)
+	if err := c.signRequest(req); err != nil {
+		return nil, err
+	}
 	res, err := c.transport().RoundTrip(req)
 	if err != nil {
 		return nil, err
@@ -111,7 +139,9 @@ func parseListAllMyBuckets(r io.Reader) ([]*Bucket, error) {
 func (c *Client) Stat(key, bucket string) (size int64, reterr error) {
 	req := newReq(c.keyURL(bucket, key))
 	req.Method = "HEAD"
-	c.Auth.SignRequest(req)
+	if err := c.signRequest(req); err != nil {
+		return 0, err
+	}
 	res, err := c.transport().RoundTrip(req)
 	if err != nil {
 		return 0, err
@@ -139,7 +169,15 @@ func (c *Client) PutObject(key, bucket string, md5 hash.Hash, size int64, body i
 		encoder.Close()
 		req.Header.Set("Content-MD5", b64.String())
 	}
-	c.Auth.SignRequest(req)
+	if c.ServerSideEncryption != "" {
+		req.Header.Set("x-amz-server-side-encryption", c.ServerSideEncryption)
+	}
+	if c.StorageClass != "" {
+		req.Header.Set("x-amz-storage-class", c.StorageClass)
+	}
+	if err := c.signRequest(req); err != nil {
+		return err
+	}
 	req.Body = ioutil.NopCloser(body)
 
 	res, err := c.transport().RoundTrip(req)
@@ -176,7 +214,9 @@ func (c *Client) BucketLocation(bucket string) (location string, err error) {
 	}
 	url_ := fmt.Sprintf("https://s3.amazonaws.com/%s/?location", url.QueryEscape(bucket))
 	req := newReq(url_)
-	c.Auth.SignRequest(req)
+	if err := c.signRequest(req); err != nil {
+		return "", err
+	}
 	res, err := c.transport().RoundTrip(req)
 	if err != nil {
 		return
@@ -193,10 +233,11 @@ func (c *Client) BucketLocation(bucket string) (location string, err error) {
 
 // ListBucket returns 0 to maxKeys (inclusive) items from the provided
 // bucket. Keys before startAt will be skipped. (This is the S3
-// 'marker' value). If the length of the returned items is equal to
-// maxKeys, there is no indication whether or not the returned list is
-// truncated.
-func (c *Client) ListBucket(bucket string, startAt string, maxKeys int) (items []*Item, err error) {
+// 'marker' value). If prefix is non-empty, only keys beginning with
+// prefix are returned. (This is the S3 'prefix' value.) If the length
+// of the returned items is equal to maxKeys, there is no indication
+// whether or not the returned list is truncated.
+func (c *Client) ListBucket(bucket string, prefix, startAt string, maxKeys int) (items []*Item, err error) {
 	if maxKeys < 0 {
 		return nil, errors.New("invalid negative maxKeys")
 	}
@@ -210,6 +251,9 @@ func (c *Client) ListBucket(bucket string, startAt string, maxKeys int) (items [
 
 		url_ := fmt.Sprintf("%s?marker=%s&max-keys=%d",
 			c.bucketURL(bucket), url.QueryEscape(marker), fetchN)
+		if prefix != "" {
+			url_ += "&prefix=" + url.QueryEscape(prefix)
+		}
 
 		// Try the enumerate three times, since Amazon likes to close
 		// https connections a lot, and Go sucks at dealing with it:
@@ -218,7 +262,9 @@ func (c *Client) ListBucket(bucket string, startAt string, maxKeys int) (items [
 		for try := 1; try <= maxTries; try++ {
 			time.Sleep(time.Duration(try-1) * 100 * time.Millisecond)
 			req := newReq(url_)
-			c.Auth.SignRequest(req)
+			if err := c.signRequest(req); err != nil {
+				return nil, err
+			}
 			res, err := c.transport().RoundTrip(req)
 			if err != nil {
 				if try < maxTries {
@@ -281,7 +327,9 @@ func (c *Client) ListBucket(bucket string, startAt string, maxKeys int) (items [
 
 func (c *Client) Get(bucket, key string) (body io.ReadCloser, size int64, err error) {
 	req := newReq(c.keyURL(bucket, key))
-	c.Auth.SignRequest(req)
+	if err = c.signRequest(req); err != nil {
+		return
+	}
 	var res *http.Response
 	res, err = c.transport().RoundTrip(req)
 	if err != nil {
@@ -306,7 +354,9 @@ func (c *Client) Get(bucket, key string) (body io.ReadCloser, size int64, err er
 func (c *Client) Delete(bucket, key string) error {
 	req := newReq(c.keyURL(bucket, key))
 	req.Method = "DELETE"
-	c.Auth.SignRequest(req)
+	if err := c.signRequest(req); err != nil {
+		return err
+	}
 	res, err := c.transport().RoundTrip(req)
 	if err != nil {
 		return err
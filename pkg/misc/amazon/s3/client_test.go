@@ -33,7 +33,7 @@ func getTestClient(t *testing.T) bool {
 		t.Logf("Skipping test; no AWS_ACCESS_KEY_ID or AWS_ACCESS_KEY_SECRET set in environment")
 		return false
 	}
-	tc = &Client{&Auth{AccessKey: accessKey, SecretAccessKey: secret}, http.DefaultTransport}
+	tc = &Client{Auth: &Auth{AccessKey: accessKey, SecretAccessKey: secret}, Transport: http.DefaultTransport}
 	return true
 }
 
@@ -0,0 +1,119 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package s3
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"camlistore.org/pkg/httputil"
+)
+
+// iamCredsURL is the EC2 instance metadata service URL listing the
+// instance's IAM role; appending the role name yields its temporary
+// credentials.
+const iamCredsURL = "http://169.254.169.254/latest/meta-data/iam/security-credentials/"
+
+// iamRefreshSlack is how long before their stated expiration the IAM
+// role credentials are refreshed.
+const iamRefreshSlack = 5 * time.Minute
+
+// iamCreds is the JSON document served by the instance metadata
+// service for a role.
+type iamCreds struct {
+	Code            string
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+// signRequest signs req with the client's credentials, first
+// refreshing them from the instance metadata service if UseIAMRole is
+// set and they are missing or near expiration.
+func (c *Client) signRequest(req *http.Request) error {
+	if !c.UseIAMRole {
+		c.Auth.SignRequest(req)
+		return nil
+	}
+	c.credsMu.Lock()
+	defer c.credsMu.Unlock()
+	if c.credsExpire.IsZero() || time.Now().After(c.credsExpire.Add(-iamRefreshSlack)) {
+		if err := c.fetchIAMRoleCreds(); err != nil {
+			return fmt.Errorf("s3: fetching IAM role credentials: %v", err)
+		}
+	}
+	c.Auth.SignRequest(req)
+	return nil
+}
+
+// fetchIAMRoleCreds fetches the instance's IAM role credentials from
+// the metadata service into c.Auth. The caller must hold c.credsMu.
+func (c *Client) fetchIAMRoleCreds() error {
+	role, err := c.metadataGet(iamCredsURL)
+	if err != nil {
+		return fmt.Errorf("listing IAM role (is an IAM role attached to this instance?): %v", err)
+	}
+	role = strings.TrimSpace(role)
+	if i := strings.Index(role, "\n"); i != -1 {
+		role = role[:i]
+	}
+	if role == "" {
+		return fmt.Errorf("no IAM role attached to this instance")
+	}
+	body, err := c.metadataGet(iamCredsURL + role)
+	if err != nil {
+		return fmt.Errorf("fetching credentials for role %q: %v", role, err)
+	}
+	var creds iamCreds
+	if err := json.Unmarshal([]byte(body), &creds); err != nil {
+		return fmt.Errorf("parsing credentials for role %q: %v", role, err)
+	}
+	if creds.Code != "Success" {
+		return fmt.Errorf("credentials for role %q have code %q", role, creds.Code)
+	}
+	c.Auth.AccessKey = creds.AccessKeyId
+	c.Auth.SecretAccessKey = creds.SecretAccessKey
+	c.Auth.SecurityToken = creds.Token
+	if creds.Expiration.IsZero() {
+		// Shouldn't happen, but don't refetch on every request.
+		c.credsExpire = time.Now().Add(2 * iamRefreshSlack)
+	} else {
+		c.credsExpire = creds.Expiration
+	}
+	return nil
+}
+
+func (c *Client) metadataGet(url string) (string, error) {
+	res, err := c.transport().RoundTrip(newReq(url))
+	if err != nil {
+		return "", err
+	}
+	defer httputil.CloseBody(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %s from %s", res.Status, url)
+	}
+	slurp, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(slurp), nil
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"camlistore.org/pkg/httputil"
+)
+
+// MinPartSize is the minimum part size accepted by S3 for multipart
+// uploads, except for the final part.
+const MinPartSize = 5 << 20
+
+// defaultPartSize is the part size used by PutMultipart when the
+// caller doesn't specify one.
+const defaultPartSize = 16 << 20
+
+type initiateMultipartResult struct {
+	UploadId string
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completeMultipartResult struct {
+	ETag string
+}
+
+// PutMultipart uploads the size bytes of body to key in bucket using
+// the S3 multipart upload API, in parts of partSize bytes (except the
+// final part). A partSize <= 0 means defaultPartSize; otherwise it
+// must be at least MinPartSize. The upload is aborted on error.
+func (c *Client) PutMultipart(key, bucket string, size, partSize int64, body io.Reader) error {
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+	if partSize < MinPartSize && size > partSize {
+		return fmt.Errorf("s3: part size %d below S3's %d byte minimum", partSize, MinPartSize)
+	}
+	uploadId, err := c.initiateMultipart(key, bucket)
+	if err != nil {
+		return err
+	}
+	var parts []completedPart
+	var buf bytes.Buffer
+	for remain := size; remain > 0; {
+		n := partSize
+		if n > remain {
+			n = remain
+		}
+		buf.Reset()
+		if _, err := io.CopyN(&buf, body, n); err != nil {
+			c.abortMultipart(key, bucket, uploadId)
+			return err
+		}
+		etag, err := c.uploadPart(key, bucket, uploadId, len(parts)+1, &buf)
+		if err != nil {
+			c.abortMultipart(key, bucket, uploadId)
+			return err
+		}
+		parts = append(parts, completedPart{PartNumber: len(parts) + 1, ETag: etag})
+		remain -= n
+	}
+	if err := c.completeMultipart(key, bucket, uploadId, parts); err != nil {
+		c.abortMultipart(key, bucket, uploadId)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) initiateMultipart(key, bucket string) (uploadId string, err error) {
+	req := newReq(c.keyURL(bucket, key) + "?uploads")
+	req.Method = "POST"
+	if c.ServerSideEncryption != "" {
+		req.Header.Set("x-amz-server-side-encryption", c.ServerSideEncryption)
+	}
+	if c.StorageClass != "" {
+		req.Header.Set("x-amz-storage-class", c.StorageClass)
+	}
+	if err := c.signRequest(req); err != nil {
+		return "", err
+	}
+	res, err := c.transport().RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	defer httputil.CloseBody(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("s3: unexpected status code %d initiating multipart upload of %v", res.StatusCode, key)
+	}
+	var xres initiateMultipartResult
+	if err := xml.NewDecoder(res.Body).Decode(&xres); err != nil {
+		return "", err
+	}
+	if xres.UploadId == "" {
+		return "", fmt.Errorf("s3: no upload ID initiating multipart upload of %v", key)
+	}
+	return xres.UploadId, nil
+}
+
+func (c *Client) uploadPart(key, bucket, uploadId string, partNumber int, part *bytes.Buffer) (etag string, err error) {
+	md5h := md5.New()
+	md5h.Write(part.Bytes())
+	req := newReq(fmt.Sprintf("%s?partNumber=%d&uploadId=%s",
+		c.keyURL(bucket, key), partNumber, url.QueryEscape(uploadId)))
+	req.Method = "PUT"
+	req.ContentLength = int64(part.Len())
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5h.Sum(nil)))
+	if err := c.signRequest(req); err != nil {
+		return "", err
+	}
+	req.Body = ioutil.NopCloser(part)
+	res, err := c.transport().RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	defer httputil.CloseBody(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("s3: unexpected status code %d uploading part %d of %v", res.StatusCode, partNumber, key)
+	}
+	etag = res.Header.Get("Etag")
+	if etag == "" {
+		return "", fmt.Errorf("s3: no ETag for part %d of %v", partNumber, key)
+	}
+	return etag, nil
+}
+
+func (c *Client) completeMultipart(key, bucket, uploadId string, parts []completedPart) error {
+	reqBody, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return err
+	}
+	req := newReq(c.keyURL(bucket, key) + "?uploadId=" + url.QueryEscape(uploadId))
+	req.Method = "POST"
+	req.ContentLength = int64(len(reqBody))
+	if err := c.signRequest(req); err != nil {
+		return err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	res, err := c.transport().RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer httputil.CloseBody(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("s3: unexpected status code %d completing multipart upload of %v", res.StatusCode, key)
+	}
+	// S3 may return a 200 with an <Error> body; a successful
+	// completion has an ETag.
+	var xres completeMultipartResult
+	if err := xml.NewDecoder(res.Body).Decode(&xres); err != nil {
+		return err
+	}
+	if xres.ETag == "" {
+		return fmt.Errorf("s3: error completing multipart upload of %v", key)
+	}
+	return nil
+}
+
+// abortMultipart abandons a multipart upload, so S3 drops the parts
+// already stored. Best effort: failures only mean the bucket keeps
+// the orphaned parts until a lifecycle rule removes them.
+func (c *Client) abortMultipart(key, bucket, uploadId string) {
+	req := newReq(c.keyURL(bucket, key) + "?uploadId=" + url.QueryEscape(uploadId))
+	req.Method = "DELETE"
+	if err := c.signRequest(req); err != nil {
+		return
+	}
+	res, err := c.transport().RoundTrip(req)
+	if err != nil {
+		return
+	}
+	httputil.CloseBody(res.Body)
+}
@@ -56,6 +56,11 @@ type PageFile struct {
 	ThumbnailURL string
 	DomID        string
 	Nav          func() *Nav
+	// ScaledURL returns a signed URL to this file scaled down to fit
+	// in maxWidth x maxHeight, as a JPEG of the given quality (1-100;
+	// 0 means the server default). It is nil if the file is not an
+	// image.
+	ScaledURL func(maxWidth, maxHeight, quality int) string
 }
 
 // Nav holds links to the previous, next, and parent elements,
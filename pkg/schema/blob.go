@@ -109,6 +109,9 @@ func (b *Blob) ByteParts() []BytesPart {
 	return s
 }
 
+// Builder returns a Builder populated with b's fields. Numbers are
+// decoded as json.Number so rebuilding the blob reproduces b's bytes
+// exactly, rather than reformatting them through float64.
 func (b *Blob) Builder() *Builder {
 	var m map[string]interface{}
 	dec := json.NewDecoder(strings.NewReader(b.str))
@@ -181,6 +184,9 @@ func (b *Blob) ShareTarget() blob.Ref {
 // ModTime returns the "unixMtime" field, or the zero time.
 func (b *Blob) ModTime() time.Time { return b.ss.ModTime() }
 
+// MIMEType returns the "mimeType" field, or the empty string.
+func (b *Blob) MIMEType() string { return b.ss.MIMEType }
+
 // A Claim is a Blob that is signed.
 type Claim struct {
 	b *Blob
@@ -500,6 +506,14 @@ func (bb *Builder) SetModTime(t time.Time) *Builder {
 	return bb
 }
 
+// SetMIMEType sets the "mimeType" field, the file's declared MIME
+// type. It's informational only, recorded by clients which happen to
+// know it; the indexer still sniffs the actual bytes.
+func (bb *Builder) SetMIMEType(mime string) *Builder {
+	bb.m["mimeType"] = mime
+	return bb
+}
+
 // CapCreationTime caps the "unixCtime" field to be less or equal than "unixMtime"
 func (bb *Builder) CapCreationTime() *Builder {
 	ctime, ok := bb.m["unixCtime"].(string)
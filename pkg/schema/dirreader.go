@@ -81,7 +81,8 @@ func (ss *superset) setFromBlobRef(fetcher blob.Fetcher, blobRef blob.Ref) error
 	return nil
 }
 
-// StaticSet returns the whole of the static set members of that directory
+// StaticSet returns the whole of the static set members of that
+// directory, with any spill-over "mergeSets" flattened in.
 func (dr *DirReader) StaticSet() ([]blob.Ref, error) {
 	if dr.staticSet != nil {
 		return dr.staticSet, nil
@@ -90,7 +91,20 @@ func (dr *DirReader) StaticSet() ([]blob.Ref, error) {
 	if !staticSetBlobref.Valid() {
 		return nil, fmt.Errorf("schema/filereader: Invalid blobref\n")
 	}
-	rsc, _, err := dr.fetcher.Fetch(staticSetBlobref)
+	members, err := staticSet(staticSetBlobref, dr.fetcher)
+	if err != nil {
+		return nil, err
+	}
+	dr.staticSet = members
+	return dr.staticSet, nil
+}
+
+// staticSet returns the members of the static-set blob
+// staticSetBlobref, recursively resolving its spill-over "mergeSets"
+// so that huge directories split over several static-set blobs read
+// back as a single members list.
+func staticSet(staticSetBlobref blob.Ref, fetcher blob.Fetcher) ([]blob.Ref, error) {
+	rsc, _, err := fetcher.Fetch(staticSetBlobref)
 	if err != nil {
 		return nil, fmt.Errorf("schema/filereader: fetching schema blob %s: %v", staticSetBlobref, err)
 	}
@@ -102,13 +116,24 @@ func (dr *DirReader) StaticSet() ([]blob.Ref, error) {
 	if ss.Type != "static-set" {
 		return nil, fmt.Errorf("schema/filereader: expected \"static-set\" schema blob for %s, got %q", staticSetBlobref, ss.Type)
 	}
+	var members []blob.Ref
 	for _, member := range ss.Members {
 		if !member.Valid() {
 			return nil, fmt.Errorf("schema/filereader: invalid (static-set member) blobref\n")
 		}
-		dr.staticSet = append(dr.staticSet, member)
+		members = append(members, member)
 	}
-	return dr.staticSet, nil
+	for _, mergeSet := range ss.MergeSets {
+		if !mergeSet.Valid() {
+			return nil, fmt.Errorf("schema/filereader: invalid (static-set mergeSet) blobref\n")
+		}
+		spilled, err := staticSet(mergeSet, fetcher)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, spilled...)
+	}
+	return members, nil
 }
 
 // Readdir implements the Directory interface.
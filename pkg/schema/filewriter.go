@@ -22,7 +22,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"camlistore.org/pkg/blob"
 	"camlistore.org/pkg/blobserver"
@@ -59,16 +62,71 @@ const (
 	tooSmallThreshold = 64 << 10
 )
 
+// A Chunking selects the strategy for cutting a file's bytes into
+// chunk blobs. The zero value means rolling-checksum chunking.
+type Chunking struct {
+	fixedSize int64 // or 0 for rolling-checksum chunking
+}
+
+// ChunkRolling is the default chunking strategy, cutting chunks at
+// rolling-checksum boundaries, so identical regions of similar files
+// share chunks.
+var ChunkRolling = Chunking{}
+
+// ChunkFixedSize returns a Chunking cutting the file into chunks of n
+// bytes each (the final chunk may be shorter). It skips the rolling
+// checksum work, which buys nothing for already-compressed media
+// that's never appended to. n is capped at 1 MB; n <= 0 means 1 MB.
+func ChunkFixedSize(n int64) Chunking {
+	if n <= 0 || n > maxBlobSize {
+		n = maxBlobSize
+	}
+	return Chunking{fixedSize: n}
+}
+
+// WriteFileOptions are optional parameters to
+// WriteFileFromReaderWithOpts. The zero value means no modtime, no
+// declared MIME type, and rolling-checksum chunking.
+type WriteFileOptions struct {
+	// ModTime, if non-zero, is recorded as the file's modification
+	// time, in the file schema blob's unixMtime field.
+	ModTime time.Time
+
+	// MIMEType, if non-empty, is recorded in the file schema
+	// blob's mimeType field.
+	MIMEType string
+
+	// Chunking is the strategy for cutting the file's bytes into
+	// chunk blobs.
+	Chunking Chunking
+}
+
 // WriteFileFromReader creates and uploads a "file" JSON schema
 // composed of chunks of r, also uploading the chunks.  The returned
 // BlobRef is of the JSON file schema blob.
 // The filename is optional.
 func WriteFileFromReader(bs blobserver.StatReceiver, filename string, r io.Reader) (blob.Ref, error) {
+	return WriteFileFromReaderWithOpts(bs, filename, r, WriteFileOptions{})
+}
+
+// WriteFileFromReaderWithOpts is like WriteFileFromReader, but
+// additionally records the metadata in opts on the file schema blob
+// and cuts chunks with opts.Chunking.
+func WriteFileFromReaderWithOpts(bs blobserver.StatReceiver, filename string, r io.Reader, opts WriteFileOptions) (blob.Ref, error) {
 	if strings.Contains(filename, "/") {
-		return blob.Ref{}, fmt.Errorf("schema.WriteFileFromReader: filename %q shouldn't contain a slash", filename)
+		return blob.Ref{}, fmt.Errorf("schema.WriteFileFromReaderWithOpts: filename %q shouldn't contain a slash", filename)
 	}
 	m := NewFileMap(filename)
-	return WriteFileMap(bs, m, r)
+	if !opts.ModTime.IsZero() {
+		m.SetModTime(opts.ModTime)
+	}
+	if opts.MIMEType != "" {
+		m.SetMIMEType(opts.MIMEType)
+	}
+	if n := opts.Chunking.fixedSize; n != 0 {
+		return writeFileMapFixed(bs, m, r, n)
+	}
+	return writeFileMapRolling(bs, m, r)
 }
 
 // WriteFileMap uploads chunks of r to bs while populating file and
@@ -78,14 +136,15 @@ func WriteFileMap(bs blobserver.StatReceiver, file *Builder, r io.Reader) (blob.
 	return writeFileMapRolling(bs, file, r)
 }
 
-// This is the simple 1MB chunk version. The rolling checksum version is below.
-func writeFileMapOld(bs blobserver.StatReceiver, file *Builder, r io.Reader) (blob.Ref, error) {
+// This is the simple fixed-size chunk version. The rolling checksum
+// version is below.
+func writeFileMapFixed(bs blobserver.StatReceiver, file *Builder, r io.Reader, chunkSize int64) (blob.Ref, error) {
 	parts, size := []BytesPart{}, int64(0)
 
 	var buf bytes.Buffer
 	for {
 		buf.Reset()
-		n, err := io.Copy(&buf, io.LimitReader(r, maxBlobSize))
+		n, err := io.Copy(&buf, io.LimitReader(r, chunkSize))
 		if err != nil {
 			return blob.Ref{}, err
 		}
@@ -334,90 +393,140 @@ func WriteFileChunks(bs blobserver.StatReceiver, file *Builder, r io.Reader) err
 	return file.PopulateParts(size, parts)
 }
 
-func writeFileChunks(bs blobserver.StatReceiver, file *Builder, r io.Reader) (n int64, spans []span, outerr error) {
-	src := &noteEOFReader{r: r}
-	bufr := bufio.NewReaderSize(src, bufioReaderSize)
-	spans = []span{} // the tree of spans, cut on interesting rollsum boundaries
-	rs := rollsum.New()
-	var last int64
-	var buf bytes.Buffer
-	blobSize := 0 // of the next blob being built, should be same as buf.Len()
+// A cutChunk is a chunk of the file cut at a rolling-checksum (or
+// size) boundary by the splitting stage of writeFileChunks, flowing
+// through the hashing stage, which fills in br.
+type cutChunk struct {
+	seq      int      // chunk index, in file order
+	data     string   // the chunk's bytes
+	from, to int64    // the chunk's offsets in the file
+	bits     int      // rollsum node weight; 0 for the final chunk
+	br       blob.Ref // the chunk's blobref, once hashed
+}
 
+// writeFileChunks is a three-stage pipeline: a splitting goroutine
+// cuts r into chunks at rolling-checksum boundaries, a pool of one
+// hashing goroutine per CPU computes the chunks' SHA-1s, and uploads
+// run asynchronously behind a gate bounding the chunks in flight.
+// Chunks re-enter the span tree in file order, so the resulting
+// schema is identical to what a serial writer would produce.
+func writeFileChunks(bs blobserver.StatReceiver, file *Builder, r io.Reader) (n int64, spans []span, outerr error) {
 	const chunksInFlight = 32 // at ~64 KB chunks, this is ~2MB memory per file
 	gatec := syncutil.NewGate(chunksInFlight)
 	firsterrc := make(chan error, 1)
+	abortc := make(chan struct{}) // closed to stop the splitter early
 
-	// uploadLastSpan runs in the same goroutine as the loop below and is responsible for
-	// starting uploading the contents of the buf.  It returns false if there's been
-	// an error and the loop below should be stopped.
-	uploadLastSpan := func() bool {
-		chunk := buf.String()
-		buf.Reset()
-		br := blob.SHA1FromString(chunk)
-		spans[len(spans)-1].br = br
-		select {
-		case outerr = <-firsterrc:
-			return false
-		default:
-			// No error seen so far, continue.
+	cutc := make(chan cutChunk)    // splitter -> hashers
+	hashedc := make(chan cutChunk) // hashers -> the ordering loop below
+
+	// Splitting stage. Each chunk sent takes a gate token, released
+	// when its upload finishes, so at most chunksInFlight chunks are
+	// buffered anywhere in the pipeline.
+	var readErr error
+	go func() {
+		defer close(cutc)
+		src := &noteEOFReader{r: r}
+		bufr := bufio.NewReaderSize(src, bufioReaderSize)
+		rs := rollsum.New()
+		var last, pos int64
+		var buf bytes.Buffer
+		blobSize := 0 // of the next blob being built, should be same as buf.Len()
+		seq := 0
+		emit := func(bits int) bool {
+			select {
+			case <-abortc:
+				return false
+			default:
+			}
+			gatec.Start()
+			cutc <- cutChunk{seq: seq, data: buf.String(), from: last, to: pos, bits: bits}
+			seq++
+			buf.Reset()
+			last = pos
+			return true
 		}
-		gatec.Start()
-		go func() {
-			defer gatec.Done()
-			if _, err := uploadString(bs, br, chunk); err != nil {
-				select {
-				case firsterrc <- err:
-				default:
+		for {
+			c, err := bufr.ReadByte()
+			if err == io.EOF {
+				if pos != last {
+					emit(0)
 				}
+				return
+			}
+			if err != nil {
+				readErr = err
+				return
+			}
+
+			buf.WriteByte(c)
+			pos++
+			blobSize++
+			rs.Roll(c)
+
+			var bits int
+			onRollSplit := rs.OnSplit()
+			switch {
+			case blobSize == maxBlobSize:
+				bits = 20 // arbitrary node weight; 1<<20 == 1MB
+			case src.sawEOF:
+				// Don't split. End is coming soon enough.
+				continue
+			case onRollSplit && pos > firstChunkSize && blobSize > tooSmallThreshold:
+				bits = rs.Bits()
+			case pos == firstChunkSize:
+				bits = 18 // 1 << 18 == 256KB
+			default:
+				// Don't split.
+				continue
+			}
+			blobSize = 0
+			if !emit(bits) {
+				return
+			}
+		}
+	}()
+
+	// Hashing stage, fanned out across the CPUs.
+	var hashWG sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		hashWG.Add(1)
+		go func() {
+			defer hashWG.Done()
+			for c := range cutc {
+				c.br = blob.SHA1FromString(c.data)
+				hashedc <- c
 			}
 		}()
-		return true
 	}
+	go func() {
+		hashWG.Wait()
+		close(hashedc)
+	}()
 
-	for {
-		c, err := bufr.ReadByte()
-		if err == io.EOF {
-			if n != last {
-				spans = append(spans, span{from: last, to: n})
-				if !uploadLastSpan() {
-					return
-				}
+	// Ordering stage: hashed chunks arrive out of order; put them
+	// back in file order, grow the span tree, and start uploads.
+	spans = []span{} // the tree of spans, cut on interesting rollsum boundaries
+	aborted := false
+	handle := func(c cutChunk) {
+		if !aborted {
+			select {
+			case outerr = <-firsterrc:
+				aborted = true
+				close(abortc)
+			default:
 			}
-			break
 		}
-		if err != nil {
-			return 0, nil, err
-		}
-
-		buf.WriteByte(c)
-		n++
-		blobSize++
-		rs.Roll(c)
-
-		var bits int
-		onRollSplit := rs.OnSplit()
-		switch {
-		case blobSize == maxBlobSize:
-			bits = 20 // arbitrary node weight; 1<<20 == 1MB
-		case src.sawEOF:
-			// Don't split. End is coming soon enough.
-			continue
-		case onRollSplit && n > firstChunkSize && blobSize > tooSmallThreshold:
-			bits = rs.Bits()
-		case n == firstChunkSize:
-			bits = 18 // 1 << 18 == 256KB
-		default:
-			// Don't split.
-			continue
+		if aborted {
+			gatec.Done()
+			return
 		}
-		blobSize = 0
 
 		// Take any spans from the end of the spans slice that
 		// have a smaller 'bits' score and make them children
 		// of this node.
 		var children []span
 		childrenFrom := len(spans)
-		for childrenFrom > 0 && spans[childrenFrom-1].bits < bits {
+		for childrenFrom > 0 && spans[childrenFrom-1].bits < c.bits {
 			childrenFrom--
 		}
 		if nCopy := len(spans) - childrenFrom; nCopy > 0 {
@@ -425,17 +534,33 @@ func writeFileChunks(bs blobserver.StatReceiver, file *Builder, r io.Reader) (n
 			copy(children, spans[childrenFrom:])
 			spans = spans[:childrenFrom]
 		}
+		spans = append(spans, span{from: c.from, to: c.to, bits: c.bits, children: children, br: c.br})
+		n = c.to
 
-		spans = append(spans, span{from: last, to: n, bits: bits, children: children})
-		last = n
-		if !uploadLastSpan() {
-			return
-		}
+		chunk, br := c.data, c.br
+		go func() {
+			defer gatec.Done()
+			if _, err := uploadString(bs, br, chunk); err != nil {
+				select {
+				case firsterrc <- err:
+				default:
+				}
+			}
+		}()
 	}
-
-	// Loop was already hit earlier.
-	if outerr != nil {
-		return 0, nil, outerr
+	pending := make(map[int]cutChunk) // bounded by chunksInFlight
+	next := 0
+	for c := range hashedc {
+		pending[c.seq] = c
+		for {
+			c, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			handle(c)
+		}
 	}
 
 	// Wait for all uploads to finish, one way or another, and then
@@ -445,6 +570,12 @@ func writeFileChunks(bs blobserver.StatReceiver, file *Builder, r io.Reader) (n
 	for i := 0; i < chunksInFlight; i++ {
 		gatec.Start()
 	}
+	if outerr != nil {
+		return 0, nil, outerr
+	}
+	if readErr != nil {
+		return 0, nil, readErr
+	}
 	select {
 	case err := <-firsterrc:
 		return 0, nil, err
@@ -452,5 +583,4 @@ func writeFileChunks(bs blobserver.StatReceiver, file *Builder, r io.Reader) (n
 	}
 
 	return n, spans, nil
-
 }
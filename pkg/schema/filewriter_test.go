@@ -22,10 +22,13 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"runtime"
 	"sort"
 	"sync"
 	"testing"
+	"time"
 
+	"camlistore.org/pkg/blob"
 	"camlistore.org/pkg/blobserver/stats"
 	"camlistore.org/pkg/test"
 )
@@ -66,6 +69,104 @@ func TestWriteFileMap(t *testing.T) {
 	}
 }
 
+// TestWriteFileMapParallelism checks that the chunking pipeline's
+// output doesn't depend on how many CPUs the hashing stage fans out
+// across: the root blobref must be the same at any GOMAXPROCS.
+func TestWriteFileMapParallelism(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	var want blob.Ref
+	for i, procs := range []int{1, 8} {
+		runtime.GOMAXPROCS(procs)
+		m := NewFileMap("test-file")
+		r := &randReader{seed: 123, length: 5 << 20}
+		br, err := WriteFileMap(new(stats.Receiver), m, r)
+		if err != nil {
+			t.Fatalf("GOMAXPROCS=%d: %v", procs, err)
+		}
+		if i == 0 {
+			want = br
+			continue
+		}
+		if br != want {
+			t.Errorf("root blobref with GOMAXPROCS=%d = %v; want %v", procs, br, want)
+		}
+	}
+}
+
+// BenchmarkWriteFileMap measures the chunking pipeline's throughput
+// on pseudo-random input, which is dominated by the rolling checksum
+// and the SHA-1 hashing; run it at different GOMAXPROCS to see the
+// hashing stage's fan-out. Each iteration writes a 16 MB file; use
+// -benchtime to approximate larger ones.
+func BenchmarkWriteFileMap(b *testing.B) {
+	const size = 16 << 20
+	data := make([]byte, size)
+	rnd := rand.New(rand.NewSource(123))
+	for i := range data {
+		data[i] = byte(rnd.Intn(256))
+	}
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m := NewFileMap("test-file")
+		if _, err := WriteFileMap(new(stats.Receiver), m, bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestWriteFileFromReaderWithOpts(t *testing.T) {
+	const size = 1 << 20
+	const chunkSize = 256 << 10
+	mtime := time.Unix(1382073153, 0).UTC()
+	r := &randReader{seed: 123, length: size}
+	sto := new(test.Fetcher)
+	var buf bytes.Buffer
+	br, err := WriteFileFromReaderWithOpts(sto, "photo.jpg", io.TeeReader(r, &buf), WriteFileOptions{
+		ModTime:  mtime,
+		MIMEType: "image/jpeg",
+		Chunking: ChunkFixedSize(chunkSize),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, _, err := sto.Fetch(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	b, err := BlobFromReader(br, rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.ModTime(); !got.Equal(mtime) {
+		t.Errorf("unixMtime = %v; want %v", got, mtime)
+	}
+	if got, want := b.MIMEType(), "image/jpeg"; got != want {
+		t.Errorf("mimeType = %q; want %q", got, want)
+	}
+	if got, want := len(b.ss.Parts), size/chunkSize; got != want {
+		t.Errorf("got %d parts; want %d fixed-size chunks", got, want)
+	}
+	for i, part := range b.ss.Parts {
+		if part.Size != chunkSize {
+			t.Errorf("part %d has size %d; want %d", i, part.Size, chunkSize)
+		}
+	}
+
+	var got bytes.Buffer
+	fr, err := NewFileReader(sto, br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(&got, fr); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), got.Bytes()) {
+		t.Error("bytes read back differ from bytes written")
+	}
+}
+
 func TestWriteThenRead(t *testing.T) {
 	m := NewFileMap("test-file")
 	const size = 5 << 20
@@ -55,4 +55,7 @@ const (
 
 	Latitude  = "latitude"
 	Longitude = "longitude"
+
+	// Rating is the item's rating, an integer from 1 to 5.
+	Rating = "rating"
 )
@@ -0,0 +1,154 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeattr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Validator checks one proposed attribute value, returning an error
+// describing why it's malformed, if it is.
+type Validator func(value string) error
+
+var (
+	validatorMu sync.RWMutex
+	validators  = map[string]Validator{}
+)
+
+func init() {
+	Register(DateCreated, Date)
+	Register(StartDate, Date)
+	Register(Latitude, FloatRange(-90, 90))
+	Register(Longitude, FloatRange(-180, 180))
+	Register(Rating, IntRange(1, 5))
+}
+
+// Register sets the validator for values of the named attribute,
+// replacing any previous one.
+func Register(attr string, v Validator) {
+	validatorMu.Lock()
+	defer validatorMu.Unlock()
+	validators[attr] = v
+}
+
+// Validate checks a proposed value for the named attribute. Unknown
+// attributes are permitted freely; only attributes with a registered
+// validator are checked.
+func Validate(attr, value string) error {
+	validatorMu.RLock()
+	v := validators[attr]
+	validatorMu.RUnlock()
+	if v == nil {
+		return nil
+	}
+	if err := v(value); err != nil {
+		return fmt.Errorf("invalid value for attribute %q: %v", attr, err)
+	}
+	return nil
+}
+
+// Date validates an RFC 3339 date string.
+func Date(value string) error {
+	if _, err := time.Parse(time.RFC3339, value); err != nil {
+		return fmt.Errorf("%q is not an RFC 3339 date", value)
+	}
+	return nil
+}
+
+// FloatRange returns a Validator for a floating point number between
+// min and max, inclusive.
+func FloatRange(min, max float64) Validator {
+	return func(value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		if f < min || f > max {
+			return fmt.Errorf("%v is out of the range [%v, %v]", f, min, max)
+		}
+		return nil
+	}
+}
+
+// IntRange returns a Validator for an integer between min and max,
+// inclusive.
+func IntRange(min, max int64) Validator {
+	return func(value string) error {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		if n < min || n > max {
+			return fmt.Errorf("%d is out of the range [%d, %d]", n, min, max)
+		}
+		return nil
+	}
+}
+
+// Enum returns a Validator accepting exactly the given values.
+func Enum(values ...string) Validator {
+	return func(value string) error {
+		for _, v := range values {
+			if value == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %q", value, values)
+	}
+}
+
+// RuleValidator returns the Validator described by a rule string from
+// a handler configuration. Valid rules are "date" (RFC 3339),
+// "float:min:max", "int:min:max", and "enum:value1,value2,...".
+func RuleValidator(rule string) (Validator, error) {
+	f := strings.SplitN(rule, ":", 2)
+	switch f[0] {
+	case "date":
+		if len(f) != 1 {
+			return nil, fmt.Errorf("rule %q: \"date\" takes no arguments", rule)
+		}
+		return Date, nil
+	case "float":
+		var min, max float64
+		if len(f) != 2 {
+			return nil, fmt.Errorf("rule %q: want \"float:min:max\"", rule)
+		}
+		if _, err := fmt.Sscanf(f[1], "%g:%g", &min, &max); err != nil {
+			return nil, fmt.Errorf("rule %q: want \"float:min:max\": %v", rule, err)
+		}
+		return FloatRange(min, max), nil
+	case "int":
+		var min, max int64
+		if len(f) != 2 {
+			return nil, fmt.Errorf("rule %q: want \"int:min:max\"", rule)
+		}
+		if _, err := fmt.Sscanf(f[1], "%d:%d", &min, &max); err != nil {
+			return nil, fmt.Errorf("rule %q: want \"int:min:max\": %v", rule, err)
+		}
+		return IntRange(min, max), nil
+	case "enum":
+		if len(f) != 2 || f[1] == "" {
+			return nil, fmt.Errorf("rule %q: want \"enum:value1,value2,...\"", rule)
+		}
+		return Enum(strings.Split(f[1], ",")...), nil
+	}
+	return nil, fmt.Errorf("unknown validation rule %q", rule)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeattr
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		attr, value string
+		ok          bool
+	}{
+		{DateCreated, "2014-05-27T12:34:56Z", true},
+		{DateCreated, "2014-05-27T12:34:56+02:00", true},
+		{DateCreated, "27/05/2014", false},
+		{DateCreated, "", false},
+		{Latitude, "48.8584", true},
+		{Latitude, "48,8584", false},
+		{Latitude, "91", false},
+		{Longitude, "-179.9", true},
+		{Longitude, "181", false},
+		{Rating, "3", true},
+		{Rating, "0", false},
+		{Rating, "4.5", false},
+		// Unknown attributes are permitted freely.
+		{"madeUpAttribute", "anything at all", true},
+	}
+	for _, tt := range tests {
+		err := Validate(tt.attr, tt.value)
+		if (err == nil) != tt.ok {
+			t.Errorf("Validate(%q, %q) = %v; want ok=%v", tt.attr, tt.value, err, tt.ok)
+		}
+	}
+}
+
+func TestRuleValidator(t *testing.T) {
+	tests := []struct {
+		rule       string
+		ok         []string
+		bad        []string
+		parseFails bool
+	}{
+		{rule: "date", ok: []string{"2014-01-01T00:00:00Z"}, bad: []string{"yesterday"}},
+		{rule: "float:0:100", ok: []string{"0", "99.5"}, bad: []string{"-1", "x"}},
+		{rule: "int:1:5", ok: []string{"1", "5"}, bad: []string{"0", "6", "2.5"}},
+		{rule: "enum:red,green,blue", ok: []string{"green"}, bad: []string{"yellow", ""}},
+		{rule: "float:low:high", parseFails: true},
+		{rule: "enum:", parseFails: true},
+		{rule: "bogus", parseFails: true},
+	}
+	for _, tt := range tests {
+		v, err := RuleValidator(tt.rule)
+		if tt.parseFails {
+			if err == nil {
+				t.Errorf("RuleValidator(%q) succeeded; want error", tt.rule)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("RuleValidator(%q): %v", tt.rule, err)
+			continue
+		}
+		for _, val := range tt.ok {
+			if err := v(val); err != nil {
+				t.Errorf("rule %q rejected %q: %v", tt.rule, val, err)
+			}
+		}
+		for _, val := range tt.bad {
+			if err := v(val); err == nil {
+				t.Errorf("rule %q accepted %q", tt.rule, val)
+			}
+		}
+	}
+}
@@ -18,6 +18,16 @@ limitations under the License.
 //
 // A schema blob is a JSON-encoded blob that describes other blobs.
 // See documentation in Camlistore's doc/schema/ directory.
+//
+// Schema blob serialization is deterministic: building the same
+// logical blob twice, on any machine, yields byte-identical JSON and
+// thus the same blobref, so schema blobs for identical content dedupe.
+// Object keys are written in sorted order, times as RFC 3339 strings
+// (see RFC3339FromTime), and numbers round-trip verbatim through
+// Blob.Builder. The deliberate exceptions are NewUnsignedPermanode,
+// whose "random" field exists precisely so that two permanodes are
+// never the same blob, and any field a caller populates with an
+// unpinned clock reading.
 package schema
 
 import (
@@ -258,12 +268,20 @@ type superset struct {
 	UnixCtime      string `json:"unixCtime"`
 	UnixAtime      string `json:"unixAtime"`
 
+	// MIMEType is the file's optional declared MIME type.
+	MIMEType string `json:"mimeType"`
+
 	// Parts are references to the data chunks of a regular file (or a "bytes" schema blob).
 	// See doc/schema/bytes.txt and doc/schema/files/file.txt.
 	Parts []*BytesPart `json:"parts"`
 
 	Entries blob.Ref   `json:"entries"` // for directories, a blobref to a static-set
 	Members []blob.Ref `json:"members"` // for static sets (for directory static-sets: blobrefs to child dirs/files)
+	// MergeSets contains the blobrefs of additional static-set
+	// blobs whose members (and mergeSets, recursively) spill over
+	// into this set. It's used to represent directories with more
+	// entries than fit in a single schema blob.
+	MergeSets []blob.Ref `json:"mergeSets"`
 
 	// Target is a "share" blob's target (the thing being shared)
 	// Or it is the object being deleted in a DeleteClaim claim.
@@ -538,16 +556,32 @@ func (d *defaultStatHasher) Hash(fileName string) (blob.Ref, error) {
 }
 
 type StaticSet struct {
-	l    sync.Mutex
-	refs []blob.Ref
+	l         sync.Mutex
+	refs      []blob.Ref
+	mergeSets []blob.Ref
 }
 
+// Add adds ref to the set. The serialized "members" list keeps the
+// order in which refs were added, so callers wanting reproducible
+// static-set blobs (and blobrefs) must add members in a stable order,
+// as camput does by walking directory entries in sorted order.
 func (ss *StaticSet) Add(ref blob.Ref) {
 	ss.l.Lock()
 	defer ss.l.Unlock()
 	ss.refs = append(ss.refs, ref)
 }
 
+// AddMergeSet records ref, the blobref of another static-set blob,
+// as a spill-over set whose members also belong to ss. It lets a
+// directory with more entries than fit in a single schema blob be
+// split over several static-sets; readers flatten the sets back
+// into one members list.
+func (ss *StaticSet) AddMergeSet(ref blob.Ref) {
+	ss.l.Lock()
+	defer ss.l.Unlock()
+	ss.mergeSets = append(ss.mergeSets, ref)
+}
+
 func base(version int, ctype string) *Builder {
 	return &Builder{map[string]interface{}{
 		"camliVersion": version,
@@ -601,6 +635,13 @@ func (ss *StaticSet) Blob() *Blob {
 		}
 	}
 	bb.m["members"] = members
+	if len(ss.mergeSets) > 0 {
+		mergeSets := make([]string, 0, len(ss.mergeSets))
+		for _, ref := range ss.mergeSets {
+			mergeSets = append(mergeSets, ref.String())
+		}
+		bb.m["mergeSets"] = mergeSets
+	}
 	return bb.Blob()
 }
 
@@ -610,6 +651,12 @@ func (ss *StaticSet) Blob() *Blob {
 //
 //   {"camliVersion":
 //
+// The encoding is deterministic: encoding/json writes map keys in
+// sorted order, and numbers that entered the map as json.Number (as in
+// maps from Blob.Builder) are written back verbatim. Slices keep their
+// order, so builders must populate list-valued fields (static-set
+// members, file parts) deterministically. TestBlobSerialization locks
+// the exact bytes of representative blobs.
 func mapJSON(m map[string]interface{}) (string, error) {
 	version, hasVersion := m["camliVersion"]
 	if !hasVersion {
@@ -815,6 +862,61 @@ func NewDelAttributeClaim(permaNode blob.Ref, attr, value string) *Builder {
 	})
 }
 
+// NewReorderAttributeClaims returns the claims to move value to
+// position pos (0-based) within the multi-valued attribute attr of
+// permaNode, whose current values, in their stable order, are values.
+// Values at positions before the earliest position that changes are
+// left untouched; the rest are deleted and re-added in the new order.
+// The returned claims have strictly increasing claim dates already
+// set, and must all be signed and uploaded, in order, for the
+// attribute to reach the new order. Duplicate values are not
+// supported: a del-attribute claim removes every occurrence of its
+// value.
+func NewReorderAttributeClaims(permaNode blob.Ref, attr string, values []string, value string, pos int) ([]*Builder, error) {
+	old := -1
+	for i, v := range values {
+		if v == value {
+			if old != -1 {
+				return nil, fmt.Errorf("schema: duplicate value %q of attribute %q", value, attr)
+			}
+			old = i
+		}
+	}
+	if old == -1 {
+		return nil, fmt.Errorf("schema: attribute %q has no value %q", attr, value)
+	}
+	if pos < 0 {
+		pos = 0
+	}
+	if pos >= len(values) {
+		pos = len(values) - 1
+	}
+	if pos == old {
+		return nil, nil
+	}
+	target := make([]string, 0, len(values))
+	target = append(target, values[:old]...)
+	target = append(target, values[old+1:]...)
+	target = append(target, "")
+	copy(target[pos+1:], target[pos:])
+	target[pos] = value
+	first := 0
+	for values[first] == target[first] {
+		first++
+	}
+	var claims []*Builder
+	t := clockNow()
+	for _, v := range values[first:] {
+		claims = append(claims, NewDelAttributeClaim(permaNode, attr, v).SetClaimDate(t))
+		t = t.Add(time.Nanosecond)
+	}
+	for _, v := range target[first:] {
+		claims = append(claims, NewAddAttributeClaim(permaNode, attr, v).SetClaimDate(t))
+		t = t.Add(time.Nanosecond)
+	}
+	return claims, nil
+}
+
 // NewDeleteClaim creates a new claim to delete a target claim or permanode.
 func NewDeleteClaim(target blob.Ref) *Builder {
 	return newClaim(&claimParam{
@@ -950,8 +1052,8 @@ func FileTime(f io.ReaderAt) (time.Time, error) {
 }
 
 // This is basically a copy of the exif.Exif.DateTime() method, except:
-//   * it takes a *time.Location to assume
-//   * the caller already assumes there's no timezone offset or GPS time
+//   - it takes a *time.Location to assume
+//   - the caller already assumes there's no timezone offset or GPS time
 //     in the EXIF, so any of that code can be ignored.
 func exifDateTimeInLocation(x *exif.Exif, loc *time.Location) (time.Time, error) {
 	tag, err := x.Get(exif.DateTimeOriginal)
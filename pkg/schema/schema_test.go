@@ -341,6 +341,76 @@ func TestAttribute(t *testing.T) {
 	}
 }
 
+func TestNewReorderAttributeClaims(t *testing.T) {
+	defer func() { clockNow = time.Now }()
+	clockNow = func() time.Time { return time.Unix(123, 456) }
+	br := blob.MustParse("xxx-1234")
+	s := func(s ...string) []string { return s }
+
+	// apply replays the claims' del/add semantics, like the
+	// indexer would, returning the resulting ordered values.
+	apply := func(values []string, claims []*Builder) []string {
+		got := append([]string(nil), values...)
+		var lastDate time.Time
+		for _, bb := range claims {
+			date, err := time.Parse(time.RFC3339Nano, bb.m["claimDate"].(string))
+			if err != nil {
+				t.Fatalf("bad claimDate: %v", err)
+			}
+			if !date.After(lastDate) {
+				t.Errorf("claim dates not strictly increasing: %v after %v", date, lastDate)
+			}
+			lastDate = date
+			val := bb.m["value"].(string)
+			switch bb.m["claimType"] {
+			case string(DelAttributeClaim):
+				for i := 0; i < len(got); i++ {
+					if got[i] == val {
+						got = append(got[:i], got[i+1:]...)
+						i--
+					}
+				}
+			case string(AddAttributeClaim):
+				got = append(got, val)
+			}
+		}
+		return got
+	}
+
+	tests := []struct {
+		values    []string
+		value     string
+		pos       int
+		want      []string
+		numClaims int
+		wantErr   bool
+	}{
+		{values: s("a", "b", "c", "d"), value: "d", pos: 1, want: s("a", "d", "b", "c"), numClaims: 6},
+		{values: s("a", "b", "c", "d"), value: "b", pos: 3, want: s("a", "c", "d", "b"), numClaims: 6},
+		{values: s("a", "b", "c"), value: "a", pos: 99, want: s("b", "c", "a"), numClaims: 6},
+		{values: s("a", "b", "c"), value: "c", pos: -5, want: s("c", "a", "b"), numClaims: 6},
+		{values: s("a", "b"), value: "b", pos: 1, want: s("a", "b"), numClaims: 0},
+		{values: s("a", "b"), value: "x", pos: 0, wantErr: true},
+		{values: s("a", "b", "a"), value: "a", pos: 1, wantErr: true},
+	}
+	for i, tt := range tests {
+		claims, err := NewReorderAttributeClaims(br, "item", tt.values, tt.value, tt.pos)
+		if tt.wantErr != (err != nil) {
+			t.Errorf("%d. error = %v; want error %v", i, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if len(claims) != tt.numClaims {
+			t.Errorf("%d. got %d claims; want %d", i, len(claims), tt.numClaims)
+		}
+		if got := apply(tt.values, claims); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d. reordered values = %q; want %q", i, got, tt.want)
+		}
+	}
+}
+
 func TestDeleteClaim(t *testing.T) {
 	tm := time.Unix(123, 456)
 	br := blob.MustParse("xxx-1234")
@@ -682,3 +752,135 @@ func TestTimezoneEXIFCorrection(t *testing.T) {
 		}
 	}
 }
+
+// TestBlobSerialization locks the exact serialized bytes of
+// representative schema blobs, so refactors of the Builder or mapJSON
+// can't silently change blobrefs of reproducible content.
+func TestBlobSerialization(t *testing.T) {
+	tm := time.Unix(123, 456)
+	newFile := func() *Builder {
+		bb := NewFileMap("test.txt")
+		bb.SetModTime(time.Unix(1322443956, 0).UTC())
+		if err := bb.PopulateParts(11, []BytesPart{
+			{Size: 5, BlobRef: blob.MustParse("xxx-1")},
+			{Size: 6, BytesRef: blob.MustParse("xxx-2"), Offset: 1},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return bb
+	}
+	tests := []struct {
+		name string
+		bb   func() *Builder
+		want string
+	}{
+		{
+			name: "file",
+			bb:   newFile,
+			want: `{"camliVersion": 1,
+  "camliType": "file",
+  "fileName": "test.txt",
+  "parts": [
+    {
+      "blobRef": "xxx-1",
+      "size": 5
+    },
+    {
+      "bytesRef": "xxx-2",
+      "offset": 1,
+      "size": 6
+    }
+  ],
+  "unixMtime": "2011-11-28T01:32:36Z"
+}`,
+		},
+		{
+			name: "directory",
+			bb: func() *Builder {
+				return NewDirMap("dir").PopulateDirectoryMap(blob.MustParse("xxx-999"))
+			},
+			want: `{"camliVersion": 1,
+  "camliType": "directory",
+  "entries": "xxx-999",
+  "fileName": "dir"
+}`,
+		},
+		{
+			name: "static-set",
+			bb: func() *Builder {
+				ss := new(StaticSet)
+				ss.Add(blob.MustParse("xxx-1"))
+				ss.Add(blob.MustParse("xxx-2"))
+				return ss.Blob().Builder()
+			},
+			want: `{"camliVersion": 1,
+  "camliType": "static-set",
+  "members": [
+    "xxx-1",
+    "xxx-2"
+  ]
+}`,
+		},
+		{
+			name: "planned permanode",
+			bb: func() *Builder {
+				return NewPlannedPermanode("foo")
+			},
+			want: `{"camliVersion": 1,
+  "camliType": "permanode",
+  "key": "foo"
+}`,
+		},
+		{
+			name: "share claim",
+			bb: func() *Builder {
+				return NewShareRef(ShareHaveRef, blob.MustParse("xxx-42"), true).SetClaimDate(tm)
+			},
+			want: `{"camliVersion": 1,
+  "authType": "haveref",
+  "camliType": "claim",
+  "claimDate": "1970-01-01T00:02:03.000000456Z",
+  "claimType": "share",
+  "target": "xxx-42",
+  "transitive": true
+}`,
+		},
+		{
+			name: "set-attribute claim",
+			bb: func() *Builder {
+				return NewSetAttributeClaim(blob.MustParse("xxx-1234"), "tag", "foo").SetClaimDate(tm)
+			},
+			want: `{"camliVersion": 1,
+  "attribute": "tag",
+  "camliType": "claim",
+  "claimDate": "1970-01-01T00:02:03.000000456Z",
+  "claimType": "set-attribute",
+  "permaNode": "xxx-1234",
+  "value": "foo"
+}`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := tt.bb().JSON()
+		if err != nil {
+			t.Errorf("%s: JSON error = %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s:\n got:\n%s\nwant:\n%s", tt.name, got, tt.want)
+			continue
+		}
+		// Building the same logical blob twice must yield the
+		// same bytes and blobref.
+		b1, b2 := tt.bb().Blob(), tt.bb().Blob()
+		if b1.JSON() != b2.JSON() || b1.BlobRef() != b2.BlobRef() {
+			t.Errorf("%s: two builds differ: %v vs %v", tt.name, b1.BlobRef(), b2.BlobRef())
+		}
+		// And a parse/rebuild round trip must preserve the bytes.
+		rt := b1.Builder().Blob()
+		if rt.JSON() != b1.JSON() {
+			t.Errorf("%s: round trip through Builder changed bytes:\n got:\n%s\nwant:\n%s",
+				tt.name, rt.JSON(), b1.JSON())
+		}
+	}
+}
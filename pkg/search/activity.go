@@ -0,0 +1,195 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package search
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/httputil"
+	"camlistore.org/pkg/types"
+	"camlistore.org/pkg/types/camtypes"
+)
+
+// ActivityRequest is a request to get an ActivityResponse.
+type ActivityRequest struct {
+	N int // max number of claims to return; default if zero
+
+	// Continue optionally specifies where to resume iteration, as
+	// returned in a previous ActivityResponse's Continue field.
+	Continue string
+
+	// Type optionally restricts the results to claims of that type
+	// (ex: "set-attribute").
+	Type string
+	// Attr optionally restricts the results to claims on that
+	// attribute (ex: "tag").
+	Attr string
+}
+
+func (r *ActivityRequest) URLSuffix() string {
+	return fmt.Sprintf("camli/search/activity?n=%d&continue=%s&type=%s&attr=%s",
+		r.N, r.Continue, r.Type, r.Attr)
+}
+
+// fromHTTP panics with an httputil value on failure
+func (r *ActivityRequest) fromHTTP(req *http.Request) {
+	r.N, _ = strconv.Atoi(req.FormValue("n"))
+	r.Continue = req.FormValue("continue")
+	r.Type = req.FormValue("type")
+	r.Attr = req.FormValue("attr")
+}
+
+// ActivityResponse is the JSON response from
+// $searchRoot/camli/search/activity.
+type ActivityResponse struct {
+	Claims []*ActivityClaim `json:"claims"`
+
+	// Continue, if non-empty, is an opaque token to pass in a
+	// subsequent request to resume iteration after the last
+	// returned claim.
+	Continue string `json:"continue,omitempty"`
+}
+
+// ActivityClaim describes one claim in an activity feed.
+type ActivityClaim struct {
+	BlobRef   blob.Ref       `json:"blobref"`
+	Signer    blob.Ref       `json:"signer"`
+	Permanode blob.Ref       `json:"permanode"`
+	Date      types.Time3339 `json:"date"`
+	Type      string         `json:"type"`
+	Attr      string         `json:"attr,omitempty"`
+	Value     string         `json:"value,omitempty"`
+	Target    blob.Ref       `json:"target,omitempty"`
+
+	// Deleted is whether the claim itself has since been deleted.
+	Deleted bool `json:"deleted,omitempty"`
+	// Superseded is whether a later claim on the same permanode
+	// and attribute has replaced or removed this claim's value.
+	Superseded bool `json:"superseded,omitempty"`
+}
+
+// GetActivity returns claims in reverse chronological order across all
+// permanodes, for consumers like activity feeds. Deleted and
+// superseded claims are included and flagged as such.
+func (sh *Handler) GetActivity(req *ActivityRequest) (*ActivityResponse, error) {
+	if sh.corpus == nil {
+		return nil, errors.New("activity iteration requires a corpus")
+	}
+	n := req.N
+	if n <= 0 || n > maxResults {
+		n = defaultNumResults
+	}
+	before, beforeRef, err := parseActivityContinue(req.Continue)
+	if err != nil {
+		return nil, err
+	}
+
+	res := new(ActivityResponse)
+	sh.corpus.RLock()
+	defer sh.corpus.RUnlock()
+	sh.corpus.ForeachClaimReverseLocked(before, beforeRef, func(cl *camtypes.Claim) bool {
+		if req.Type != "" && cl.Type != req.Type {
+			return true
+		}
+		if req.Attr != "" && cl.Attr != req.Attr {
+			return true
+		}
+		res.Claims = append(res.Claims, &ActivityClaim{
+			BlobRef:    cl.BlobRef,
+			Signer:     cl.Signer,
+			Permanode:  cl.Permanode,
+			Date:       types.Time3339(cl.Date),
+			Type:       cl.Type,
+			Attr:       cl.Attr,
+			Value:      cl.Value,
+			Target:     cl.Target,
+			Deleted:    sh.corpus.IsDeletedLocked(cl.BlobRef),
+			Superseded: sh.claimSupersededLocked(cl),
+		})
+		if len(res.Claims) == n {
+			res.Continue = activityContinueToken(cl)
+			return false
+		}
+		return true
+	})
+	return res, nil
+}
+
+// claimSupersededLocked reports whether a later claim on the same
+// permanode and attribute has replaced or removed cl's value.
+func (sh *Handler) claimSupersededLocked(cl *camtypes.Claim) bool {
+	if cl.Attr == "" {
+		return false
+	}
+	superseded := false
+	sh.corpus.ForeachClaimLocked(cl.Permanode, func(later *camtypes.Claim) bool {
+		if !later.Date.After(cl.Date) || later.Attr != cl.Attr {
+			return true
+		}
+		switch later.Type {
+		case "set-attribute":
+			superseded = true
+		case "del-attribute":
+			if later.Value == "" || later.Value == cl.Value {
+				superseded = true
+			}
+		}
+		return !superseded
+	})
+	return superseded
+}
+
+func activityContinueToken(cl *camtypes.Claim) string {
+	return cl.Date.UTC().Format(time.RFC3339Nano) + "|" + cl.BlobRef.String()
+}
+
+func parseActivityContinue(token string) (before time.Time, beforeRef blob.Ref, err error) {
+	if token == "" {
+		return
+	}
+	f := strings.SplitN(token, "|", 2)
+	if len(f) != 2 {
+		return before, beforeRef, fmt.Errorf("invalid continuation token %q", token)
+	}
+	before, err = time.Parse(time.RFC3339Nano, f[0])
+	if err != nil {
+		return before, beforeRef, fmt.Errorf("invalid continuation token %q: %v", token, err)
+	}
+	beforeRef, ok := blob.Parse(f[1])
+	if !ok {
+		return before, beforeRef, fmt.Errorf("invalid continuation token %q: bad blobref", token)
+	}
+	return before, beforeRef, nil
+}
+
+func (sh *Handler) serveActivity(rw http.ResponseWriter, req *http.Request) {
+	defer httputil.RecoverJSON(rw, req)
+	var ar ActivityRequest
+	ar.fromHTTP(req)
+	res, err := sh.GetActivity(&ar)
+	if err != nil {
+		httputil.ServeJSONError(rw, err)
+		return
+	}
+	httputil.ReturnJSON(rw, res)
+}
@@ -42,6 +42,7 @@ func (sh *Handler) serveDescribe(rw http.ResponseWriter, req *http.Request) {
 	defer httputil.RecoverJSON(rw, req)
 	var dr DescribeRequest
 	dr.fromHTTP(req)
+	dr.allowedSigners = sh.allowedSigners(req)
 
 	res, err := sh.Describe(&dr)
 	if err != nil {
@@ -116,6 +117,12 @@ type DescribeRequest struct {
 	// longer match or internal limits are hit.
 	Rules []*DescribeRule `json:"rules,omitempty"`
 
+	// allowedSigners optionally restricts the described permanodes to
+	// those with at least one claim signed by one of these signers.
+	// It is set by the search handler from its per-user owners
+	// configuration. If nil, no filtering is done.
+	allowedSigners map[blob.Ref]bool
+
 	// Internal details, used while loading.
 	// Initialized by sh.initDescribeRequest.
 	sh            *Handler
@@ -472,6 +479,12 @@ func (b *DescribedBlob) thumbnail(thumbSize int) (path string, width, height int
 		return "node.png", thumbSize, thumbSize, true
 	}
 
+	if tr := b.Permanode.ThumbnailRef; tr != nil {
+		if path, w, h, ok := b.imageThumbnail(*tr, thumbSize); ok {
+			return path, w, h, true
+		}
+	}
+
 	if b.Permanode.IsContainer() {
 		return "folder.png", thumbSize, thumbSize, true
 	}
@@ -479,14 +492,8 @@ func (b *DescribedBlob) thumbnail(thumbSize int) (path string, width, height int
 	if content, ok := b.ContentRef(); ok {
 		peer := b.peerBlob(content)
 		if peer.File != nil {
-			ii := peer.Image
-			if peer.File.IsImage() && ii != nil && ii.Height > 0 && ii.Width > 0 {
-				image := fmt.Sprintf("thumbnail/%s/%s?mh=%d&tv=%s", peer.BlobRef,
-					url.QueryEscape(peer.File.FileName), thumbSize, images.ThumbnailVersion())
-				mw, mh := images.ScaledDimensions(
-					int(ii.Width), int(ii.Height),
-					MaxImageSize, thumbSize)
-				return image, mw, mh, true
+			if path, w, h, ok := b.imageThumbnail(content, thumbSize); ok {
+				return path, w, h, true
 			}
 
 			// TODO: different thumbnails based on peer.File.MIMEType.
@@ -502,9 +509,41 @@ func (b *DescribedBlob) thumbnail(thumbSize int) (path string, width, height int
 	return "node.png", thumbSize, thumbSize, true
 }
 
+// imageThumbnail returns the thumbnail URL and scaled dimensions for
+// the image file fileRef. ok is false if fileRef wasn't described
+// along with b, or isn't an image with known dimensions.
+//
+// Locking: same requirements as thumbnail.
+func (b *DescribedBlob) imageThumbnail(fileRef blob.Ref, thumbSize int) (path string, width, height int, ok bool) {
+	peer := b.peerBlob(fileRef)
+	if peer.File == nil || !peer.File.IsImage() {
+		return
+	}
+	ii := peer.Image
+	if ii == nil || ii.Height <= 0 || ii.Width <= 0 {
+		return
+	}
+	path = fmt.Sprintf("thumbnail/%s/%s?mh=%d&tv=%s", peer.BlobRef,
+		url.QueryEscape(peer.File.FileName), thumbSize, images.ThumbnailVersion())
+	width, height = images.ScaledDimensions(
+		int(ii.Width), int(ii.Height),
+		MaxImageSize, thumbSize)
+	return path, width, height, true
+}
+
 type DescribedPermanode struct {
+	// Attr maps each attribute to its values. The values of a
+	// multi-valued attribute are in their stable order: the order
+	// of their add-attribute claims (claim date ascending, ties
+	// broken by claim blobref).
 	Attr    url.Values `json:"attr"` // a map[string][]string
 	ModTime time.Time  `json:"modtime,omitempty"`
+
+	// ThumbnailRef is the blobref of the image file to use as the
+	// permanode's cover image, if any, as resolved by
+	// DescribeRequest.thumbnailRef. It is a pointer so the zero
+	// blob.Ref doesn't marshal as JSON null.
+	ThumbnailRef *blob.Ref `json:"thumbnailRef,omitempty"`
 }
 
 // IsContainer returns whether the permanode has either named ("camliPath:"-prefixed) or unnamed
@@ -533,6 +572,9 @@ func (dp *DescribedPermanode) jsonMap() map[string]interface{} {
 			am[k] = vl
 		}
 	}
+	if dp.ThumbnailRef != nil {
+		m["thumbnailRef"] = dp.ThumbnailRef.String()
+	}
 	return m
 }
 
@@ -770,6 +812,25 @@ func (dr *DescribeRequest) addError(br blob.Ref, err error) {
 	dr.errs[br.String()] = err
 }
 
+// signerAllowed reports whether the permanode br has at least one
+// claim signed by one of dr.allowedSigners. If the set is nil, all
+// permanodes are allowed.
+func (dr *DescribeRequest) signerAllowed(br blob.Ref) bool {
+	if dr.allowedSigners == nil {
+		return true
+	}
+	claims, err := dr.sh.index.AppendClaims(nil, br, blob.Ref{}, "")
+	if err != nil {
+		return false
+	}
+	for _, cl := range claims {
+		if dr.allowedSigners[cl.Signer] {
+			return true
+		}
+	}
+	return false
+}
+
 func (dr *DescribeRequest) describeReally(br blob.Ref, depth int) {
 	meta, err := dr.sh.index.GetBlobMeta(br)
 	if err == os.ErrNotExist {
@@ -779,6 +840,11 @@ func (dr *DescribeRequest) describeReally(br blob.Ref, depth int) {
 		dr.addError(br, err)
 		return
 	}
+	if meta.CamliType == "permanode" && !dr.signerAllowed(br) {
+		// The requester may not see this permanode; leave it out of
+		// the response entirely, so it's not expanded either.
+		return
+	}
 
 	// TODO: convert all this in terms of
 	// DescribedBlob/DescribedPermanode/DescribedFile, not json
@@ -793,6 +859,10 @@ func (dr *DescribeRequest) describeReally(br blob.Ref, depth int) {
 	case "permanode":
 		des.Permanode = new(DescribedPermanode)
 		dr.populatePermanodeFields(des.Permanode, br, dr.sh.owner, depth)
+		if tr := dr.thumbnailRef(des.Permanode.Attr, thumbnailRefDepth, map[blob.Ref]bool{br: true}); tr.Valid() {
+			des.Permanode.ThumbnailRef = &tr
+			dr.Describe(tr, 1)
+		}
 	case "file":
 		fi, err := dr.sh.index.GetFileInfo(br)
 		if err != nil {
@@ -906,6 +976,96 @@ claimLoop:
 	}
 }
 
+// thumbnailRefDepth bounds how many member hops thumbnailRef follows
+// when a permanode has no image of its own.
+const thumbnailRefDepth = 3
+
+// thumbnailRef returns the blobref of the image file to use as the
+// cover image for a permanode with attributes attr, trying in order:
+//
+//  1. the "camliContentImage" attribute, if it names an image file;
+//  2. the "camliContent" attribute, if it names an image file;
+//  3. the first member (the "camliMember" values in their stable
+//     order, then the "camliPath:*" values in attribute name order)
+//     that is an image file or, recursively, has a cover image of
+//     its own, following at most depth member hops.
+//
+// If none match, the zero Ref is returned. seen guards against
+// membership cycles.
+func (dr *DescribeRequest) thumbnailRef(attr url.Values, depth int, seen map[blob.Ref]bool) blob.Ref {
+	for _, a := range []string{"camliContentImage", "camliContent"} {
+		if v := attr.Get(a); v != "" {
+			if br, ok := blob.Parse(v); ok && dr.isImageBlob(br) {
+				return br
+			}
+		}
+	}
+	if depth <= 1 {
+		return blob.Ref{}
+	}
+	var members []blob.Ref
+	for _, v := range attr["camliMember"] {
+		if br, ok := blob.Parse(v); ok {
+			members = append(members, br)
+		}
+	}
+	var pathKeys []string
+	for k := range attr {
+		if strings.HasPrefix(k, "camliPath:") {
+			pathKeys = append(pathKeys, k)
+		}
+	}
+	sort.Strings(pathKeys)
+	for _, k := range pathKeys {
+		if br, ok := blob.Parse(attr.Get(k)); ok {
+			members = append(members, br)
+		}
+	}
+	for _, br := range members {
+		if seen[br] {
+			continue
+		}
+		seen[br] = true
+		if dr.isImageBlob(br) {
+			return br
+		}
+		mattr, ok := dr.permanodeAttrs(br)
+		if !ok {
+			continue
+		}
+		if tr := dr.thumbnailRef(mattr, depth-1, seen); tr.Valid() {
+			return tr
+		}
+	}
+	return blob.Ref{}
+}
+
+// isImageBlob reports whether br is a "file" schema blob that the
+// indexer recognized as an image.
+func (dr *DescribeRequest) isImageBlob(br blob.Ref) bool {
+	meta, err := dr.sh.index.GetBlobMeta(br)
+	if err != nil || meta.CamliType != "file" {
+		return false
+	}
+	fi, err := dr.sh.index.GetFileInfo(br)
+	return err == nil && fi.IsImage()
+}
+
+// permanodeAttrs returns the current attributes of the permanode br,
+// as seen by the request (honoring dr.At and dr.allowedSigners). It
+// computes them from the index rather than reading dr.m, as the
+// members visited by thumbnailRef may be getting described
+// concurrently.
+func (dr *DescribeRequest) permanodeAttrs(br blob.Ref) (url.Values, bool) {
+	meta, err := dr.sh.index.GetBlobMeta(br)
+	if err != nil || meta.CamliType != "permanode" || !dr.signerAllowed(br) {
+		return nil, false
+	}
+	pi := new(DescribedPermanode)
+	dr.populatePermanodeFields(pi, br, dr.sh.owner, 0)
+	return pi.Attr, true
+}
+
 func (dr *DescribeRequest) getDirMembers(br blob.Ref, depth int) ([]blob.Ref, error) {
 	limit := dr.maxDirChildren()
 	ch := make(chan blob.Ref)
@@ -18,6 +18,7 @@ package search_test
 
 import (
 	"testing"
+	"time"
 
 	"camlistore.org/pkg/blob"
 	"camlistore.org/pkg/index"
@@ -223,3 +224,72 @@ func TestSearchDescribe(t *testing.T) {
 		ht.test(t)
 	}
 }
+
+func TestDescribeThumbnailRef(t *testing.T) {
+	id, h := querySetup(t)
+
+	imgRef, _ := id.UploadFile("cover.gif", "GIF87afoo", time.Unix(1382073153, 0))
+	txtRef, _ := id.UploadFile("notes.txt", "some notes", time.Unix(1382073153, 0))
+
+	thumbRef := func(br blob.Ref) *blob.Ref {
+		res, err := h.Describe(&search.DescribeRequest{BlobRef: br})
+		if err != nil {
+			t.Fatalf("Describe(%v): %v", br, err)
+		}
+		db := res.Meta.Get(br)
+		if db == nil || db.Permanode == nil {
+			t.Fatalf("Describe(%v): permanode not described", br)
+		}
+		return db.Permanode.ThumbnailRef
+	}
+	wantThumb := func(br, want blob.Ref) {
+		got := thumbRef(br)
+		if !want.Valid() {
+			if got != nil {
+				t.Errorf("thumbnailRef(%v) = %v; want none", br, got)
+			}
+			return
+		}
+		if got == nil || *got != want {
+			t.Errorf("thumbnailRef(%v) = %v; want %v", br, got, want)
+		}
+	}
+
+	// No image content: no thumbnailRef.
+	noImage := id.NewPermanode()
+	id.SetAttribute(noImage, "camliContent", txtRef.String())
+	wantThumb(noImage, blob.Ref{})
+
+	// camliContent naming an image file.
+	withImage := id.NewPermanode()
+	id.SetAttribute(withImage, "camliContent", imgRef.String())
+	wantThumb(withImage, imgRef)
+
+	// The first member with a cover image provides the album's.
+	album := id.NewPermanode()
+	id.AddAttribute(album, "camliMember", noImage.String())
+	id.AddAttribute(album, "camliMember", withImage.String())
+	wantThumb(album, imgRef)
+
+	// ... across several member hops.
+	parent := id.NewPermanode()
+	id.SetAttribute(parent, "camliPath:albums", album.String())
+	wantThumb(parent, imgRef)
+
+	// An explicitly set cover wins over the members, including for
+	// containers further up.
+	coverRef, _ := id.UploadFile("cover2.gif", "GIF89abar", time.Unix(1382073154, 0))
+	id.SetAttribute(album, "camliContentImage", coverRef.String())
+	wantThumb(album, coverRef)
+	wantThumb(parent, coverRef)
+
+	// The cover file is described along with the permanode, so
+	// clients get its file and image info in the same response.
+	res, err := h.Describe(&search.DescribeRequest{BlobRef: album})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db := res.Meta.Get(coverRef); db == nil || db.File == nil {
+		t.Errorf("cover file %v not described along with %v", coverRef, album)
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package search_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"camlistore.org/pkg/blob"
+	. "camlistore.org/pkg/search"
+)
+
+func childNames(children []*DirTreeChild) []string {
+	var names []string
+	for _, c := range children {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func wantNames(t *testing.T, res *DirTreeResponse, want ...string) {
+	got := childNames(res.Children)
+	if len(got) != len(want) {
+		t.Fatalf("got %d children (%q); want %d (%q)", len(got), got, len(want), want)
+	}
+	for i, name := range want {
+		if got[i] != name {
+			t.Errorf("child %d = %q; want %q", i, got[i], name)
+		}
+	}
+}
+
+func TestGetDirTree(t *testing.T) {
+	id, h := querySetup(t)
+
+	base := time.Unix(1410150000, 0).UTC()
+	var children []blob.Ref
+	// Upload in non-sorted name order, with modtimes in upload order.
+	names := []string{"echo.txt", "charlie.txt", "alpha.txt", "delta.txt", "bravo.txt"}
+	for i, name := range names {
+		fileRef, _ := id.UploadFile(name, "contents of "+name, base.Add(time.Duration(i)*time.Minute))
+		children = append(children, fileRef)
+	}
+	subdirFile, _ := id.UploadFile("inner.txt", "inner contents", base)
+	subdir := id.UploadDir("subdir", []blob.Ref{subdirFile}, base.Add(time.Hour))
+	children = append(children, subdir)
+
+	// chunkSize 2 forces the children over several spill-over
+	// static-sets, which the index flattens back into one row set.
+	dir := id.UploadDirChunked("dir", children, 2, base)
+
+	res, err := h.GetDirTree(&DirTreeRequest{Dir: dir, Limit: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != 6 {
+		t.Errorf("Total = %d; want 6", res.Total)
+	}
+	wantNames(t, res, "alpha.txt", "bravo.txt", "charlie.txt", "delta.txt")
+	if res.Continue == "" {
+		t.Fatal("missing continuation token on first page")
+	}
+
+	res, err = h.GetDirTree(&DirTreeRequest{Dir: dir, Limit: 4, Continue: res.Continue})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantNames(t, res, "echo.txt", "subdir")
+	if res.Continue != "" {
+		t.Errorf("unexpected continuation token %q on last page", res.Continue)
+	}
+	for _, c := range res.Children {
+		switch c.Name {
+		case "echo.txt":
+			if c.Type != "file" {
+				t.Errorf("echo.txt type = %q; want \"file\"", c.Type)
+			}
+		case "subdir":
+			if c.Type != "directory" {
+				t.Errorf("subdir type = %q; want \"directory\"", c.Type)
+			}
+			if c.Size != 1 {
+				t.Errorf("subdir size = %d; want 1 entry", c.Size)
+			}
+		}
+	}
+
+	// Oldest first. The subdir sorts before the files: directories
+	// get no filetimes row, so its modtime is unknown (zero).
+	res, err = h.GetDirTree(&DirTreeRequest{Dir: dir, Limit: 3, Sort: "modtime"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantNames(t, res, "subdir", "echo.txt", "charlie.txt")
+
+	if _, err := h.GetDirTree(&DirTreeRequest{Dir: dir, Sort: "size"}); err == nil {
+		t.Error("unknown sort order didn't error")
+	}
+	if _, err := h.GetDirTree(&DirTreeRequest{Dir: dir, Continue: "bogus"}); err == nil {
+		t.Error("bogus continuation token didn't error")
+	}
+	if _, err := h.GetDirTree(&DirTreeRequest{}); err == nil {
+		t.Error("invalid dir ref didn't error")
+	}
+}
+
+func TestGetDirTreeLarge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping 50k-entry directory test in short mode")
+	}
+	id, h := querySetup(t)
+	pageLimit := ExportMaxDirTreeLimitConst()
+
+	const numChildren = 50000
+	var children []blob.Ref
+	for i := 0; i < numChildren; i++ {
+		fileRef, _ := id.UploadFile(fmt.Sprintf("%06d.txt", i), fmt.Sprintf("contents %d", i), time.Time{})
+		children = append(children, fileRef)
+	}
+	dir := id.UploadDirChunked("bigdir", children, 3300, time.Time{})
+
+	seen := make(map[blob.Ref]bool)
+	prevName := ""
+	pages := 0
+	token := ""
+	for {
+		res, err := h.GetDirTree(&DirTreeRequest{Dir: dir, Limit: pageLimit, Continue: token})
+		if err != nil {
+			t.Fatalf("page %d: %v", pages, err)
+		}
+		if res.Total != numChildren {
+			t.Fatalf("page %d: Total = %d; want %d", pages, res.Total, numChildren)
+		}
+		for _, c := range res.Children {
+			if seen[c.BlobRef] {
+				t.Fatalf("duplicate child %v", c.BlobRef)
+			}
+			seen[c.BlobRef] = true
+			if c.Name < prevName {
+				t.Fatalf("name %q out of order after %q", c.Name, prevName)
+			}
+			prevName = c.Name
+		}
+		pages++
+		if res.Continue == "" {
+			break
+		}
+		token = res.Continue
+	}
+	if len(seen) != numChildren {
+		t.Errorf("enumerated %d children; want %d", len(seen), numChildren)
+	}
+	if want := (numChildren + pageLimit - 1) / pageLimit; pages != want {
+		t.Errorf("took %d pages; want %d", pages, want)
+	}
+}
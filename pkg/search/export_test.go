@@ -16,6 +16,8 @@ limitations under the License.
 
 package search
 
+import "camlistore.org/pkg/blob"
+
 func SetTestHookBug121(hook func()) {
 	testHookBug121 = hook
 }
@@ -24,8 +26,19 @@ func ExportSetCandidateSourceHook(fn func(string)) { candSourceHook = fn }
 
 func ExportBufferedConst() int { return buffered }
 
+func ExportMaxDirTreeLimitConst() int { return maxDirTreeLimit }
+
 func (s *SearchQuery) ExportPlannedQuery() *SearchQuery {
 	return s.plannedQuery(nil)
 }
 
 var SortName = sortName
+
+// SetAllowedSigners restricts q's results to permanodes signed by one
+// of signers, as the search handler does for an authenticated request.
+func (q *SearchQuery) SetAllowedSigners(signers ...blob.Ref) {
+	q.allowedSigners = make(map[blob.Ref]bool)
+	for _, br := range signers {
+		q.allowedSigners[br] = true
+	}
+}
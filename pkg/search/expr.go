@@ -356,7 +356,32 @@ func parseExpression(ctx *context.Context, exp string) (*SearchQuery, error) {
 		return nil, newParseExpError(trailingTokens, *lastToken)
 	}
 	if c != nil {
-		sq.Constraint = andConst(base, c)
+		if c.matchesFilesOnly() {
+			// A constraint like "is:orphanfile" can only match
+			// file blobs; ANDing in the default permanode
+			// constraint would yield nothing.
+			sq.Constraint = c
+		} else {
+			sq.Constraint = andConst(base, c)
+		}
 	}
 	return sq, nil
 }
+
+// matchesFilesOnly reports whether c can only match "file" schema
+// blobs, in which case parseExpression leaves out its default
+// permanode constraint.
+func (c *Constraint) matchesFilesOnly() bool {
+	if c.File != nil {
+		return true
+	}
+	if l := c.Logical; l != nil {
+		switch l.Op {
+		case "and":
+			return l.A.matchesFilesOnly() || l.B.matchesFilesOnly()
+		case "or":
+			return l.A.matchesFilesOnly() && l.B.matchesFilesOnly()
+		}
+	}
+	return false
+}
@@ -23,6 +23,7 @@ import (
 	"testing"
 
 	"camlistore.org/pkg/context"
+	"camlistore.org/pkg/types"
 )
 
 var skiphiddenC = &Constraint{
@@ -105,6 +106,19 @@ var parseExpressionTests = []struct {
 		errContains: "No matching opening",
 	},
 
+	{
+		// A file-only constraint skips the default permanode
+		// constraint: the results are file blobs.
+		in: "is:orphanfile",
+		want: &SearchQuery{
+			Constraint: &Constraint{
+				File: &FileConstraint{
+					IsOrphan: true,
+				},
+			},
+		},
+	},
+
 	{
 		in: "width:0-640",
 		want: &SearchQuery{
@@ -191,6 +205,27 @@ var parseExpressionTests = []struct {
 		},
 	},
 
+	{
+		name: "modified attribute search",
+		in:   "modified-attr:tag:2013-02-03",
+		want: &SearchQuery{
+			Constraint: &Constraint{
+				Logical: &LogicalConstraint{
+					Op: "and",
+					A:  skiphiddenC,
+					B: &Constraint{
+						Permanode: &PermanodeConstraint{
+							ModifiedAttr: &ModifiedAttrConstraint{
+								Attr:  "tag",
+								Since: types.Time3339(testtime),
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+
 	{
 		in: "tag:funny",
 		want: &SearchQuery{
@@ -41,6 +41,11 @@ const buffered = 32     // arbitrary channel buffer size
 const maxResults = 1000 // arbitrary limit on the number of search results returned
 const defaultNumResults = 50
 
+const (
+	defaultDirTreeLimit = 1000  // children per dirtree page, if unspecified
+	maxDirTreeLimit     = 10000 // maximum children per dirtree page
+)
+
 // MaxImageSize is the maximum width or height in pixels that we will serve image
 // thumbnails at. It is used in the search result UI.
 const MaxImageSize = 2000
@@ -56,12 +61,28 @@ type Handler struct {
 	index index.Interface
 	owner blob.Ref
 
+	// owners are all the public key blobrefs whose claims this
+	// handler searches over; owners[0] == owner, the primary.
+	// Extra entries come from the "additionalOwners" config, for
+	// servers signing with multiple identities.
+	owners []blob.Ref
+
 	// Corpus optionally specifies the full in-memory metadata corpus
 	// to use.
 	// TODO: this may be required in the future, or folded into the index
 	// interface.
 	corpus *index.Corpus
 
+	// fetcher optionally fetches blobs from the handler's
+	// "blobSource" storage, for queries (like the reachable
+	// endpoint) that need blob contents, not just the index.
+	fetcher blob.Fetcher
+
+	// ownersByUser optionally maps an authenticated username to the
+	// signers whose permanodes that user may see, for indexes shared
+	// by multiple identities. If nil, no permission filtering is done.
+	ownersByUser map[string][]blob.Ref
+
 	// WebSocket hub
 	wsHub *wsHub
 }
@@ -81,8 +102,9 @@ var (
 
 func NewHandler(index index.Interface, owner blob.Ref) *Handler {
 	sh := &Handler{
-		index: index,
-		owner: owner,
+		index:  index,
+		owner:  owner,
+		owners: []blob.Ref{owner},
 	}
 	sh.wsHub = newWebsocketHub(sh)
 	go sh.wsHub.run()
@@ -112,6 +134,9 @@ func newHandlerFromConfig(ld blobserver.Loader, conf jsonconfig.Obj) (http.Handl
 	ownerBlobStr := conf.RequiredString("owner")
 	devBlockStartupPrefix := conf.OptionalString("devBlockStartupOn", "")
 	slurpToMemory := conf.OptionalBool("slurpToMemory", false)
+	additionalOwners := conf.OptionalList("additionalOwners")
+	ownersConf := conf.OptionalObject("owners")
+	blobSource := conf.OptionalString("blobSource", "")
 	if err := conf.Validate(); err != nil {
 		return nil, err
 	}
@@ -137,6 +162,40 @@ func newHandlerFromConfig(ld blobserver.Loader, conf jsonconfig.Obj) (http.Handl
 			ownerBlobStr)
 	}
 	h := NewHandler(indexer, ownerBlobRef)
+	for _, s := range additionalOwners {
+		br, ok := blob.Parse(s)
+		if !ok {
+			return nil, fmt.Errorf("search 'additionalOwners' has malformed blobref %q; expecting e.g. sha1-xxxxxxxxxxxx", s)
+		}
+		h.owners = append(h.owners, br)
+	}
+	if len(ownersConf) > 0 {
+		h.ownersByUser = make(map[string][]blob.Ref)
+		for user, v := range ownersConf {
+			vl, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("search 'owners' entry %q should be a list of signer blobrefs", user)
+			}
+			for _, sv := range vl {
+				s, ok := sv.(string)
+				if !ok {
+					return nil, fmt.Errorf("search 'owners' entry %q should be a list of signer blobrefs", user)
+				}
+				signer, ok := blob.Parse(s)
+				if !ok {
+					return nil, fmt.Errorf("search 'owners' entry %q has malformed blobref %q; expecting e.g. sha1-xxxxxxxxxxxx", user, s)
+				}
+				h.ownersByUser[user] = append(h.ownersByUser[user], signer)
+			}
+		}
+	}
+	if blobSource != "" {
+		bs, err := ld.GetStorage(blobSource)
+		if err != nil {
+			return nil, fmt.Errorf("search config references unknown blobSource %q: %v", blobSource, err)
+		}
+		h.fetcher = bs
+	}
 	if slurpToMemory {
 		ii := indexer.(*index.Index)
 		corpus, err := ii.KeepInMemory()
@@ -155,10 +214,35 @@ func (h *Handler) Owner() blob.Ref {
 	return h.owner
 }
 
+// Owners returns the public key blobrefs of all the handler's owners,
+// the primary first. The result must not be modified.
+func (h *Handler) Owners() []blob.Ref {
+	return h.owners
+}
+
 func (h *Handler) Index() index.Interface {
 	return h.index
 }
 
+// allowedSigners returns the signers whose permanodes the request's
+// authenticated user may see, per the handler's "owners" configuration.
+// A nil map means no filtering: all results are visible. A non-nil
+// empty map means an unknown user, who may see no permanodes.
+func (sh *Handler) allowedSigners(req *http.Request) map[blob.Ref]bool {
+	if sh.ownersByUser == nil {
+		return nil
+	}
+	signers := make(map[blob.Ref]bool)
+	user, _, err := httputil.BasicAuth(req)
+	if err != nil {
+		return signers
+	}
+	for _, br := range sh.ownersByUser[user] {
+		signers[br] = true
+	}
+	return signers
+}
+
 func jsonMap() map[string]interface{} {
 	return make(map[string]interface{})
 }
@@ -169,15 +253,21 @@ var getHandler = map[string]func(*Handler, http.ResponseWriter, *http.Request){
 	"permanodeattr":   (*Handler).servePermanodesWithAttr,
 	"describe":        (*Handler).serveDescribe,
 	"claims":          (*Handler).serveClaims,
+	"dirtree":         (*Handler).serveDirTree,
 	"files":           (*Handler).serveFiles,
+	"activity":        (*Handler).serveActivity,
+	"people":          (*Handler).servePeople,
+	"personphotos":    (*Handler).servePersonPhotos,
 	"signerattrvalue": (*Handler).serveSignerAttrValue,
 	"signerpaths":     (*Handler).serveSignerPaths,
 	"edgesto":         (*Handler).serveEdgesTo,
+	"storagestats":    (*Handler).serveStorageStats,
 }
 
 var postHandler = map[string]func(*Handler, http.ResponseWriter, *http.Request){
-	"describe": (*Handler).serveDescribe,
-	"query":    (*Handler).serveQuery,
+	"describe":  (*Handler).serveDescribe,
+	"query":     (*Handler).serveQuery,
+	"reachable": (*Handler).serveReachable,
 }
 
 func (sh *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
@@ -341,6 +431,58 @@ func (r *ClaimsRequest) fromHTTP(req *http.Request) {
 	r.AttrFilter = req.FormValue("attrFilter")
 }
 
+// DirTreeRequest is a request to get a DirTreeResponse: one page of
+// a static directory's children, for lazily-loading tree views.
+type DirTreeRequest struct {
+	// Dir is the "directory" schema blob whose children to list.
+	Dir blob.Ref
+
+	// Limit is the maximum number of children per page.
+	// If zero, a default is used.
+	Limit int
+
+	// Continue resumes an enumeration at the position returned in
+	// a previous response's Continue field. Empty starts at the
+	// beginning. Directories are immutable, so a token stays valid
+	// as long as the same sort order is requested.
+	Continue string
+
+	// Sort is the order of the children: "name" (the default) or
+	// "modtime".
+	Sort string
+}
+
+func (r *DirTreeRequest) URLSuffix() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "camli/search/dirtree?dir=%v&limit=%d", r.Dir, r.Limit)
+	if r.Continue != "" {
+		fmt.Fprintf(&buf, "&continue=%s", url.QueryEscape(r.Continue))
+	}
+	if r.Sort != "" {
+		fmt.Fprintf(&buf, "&sort=%s", url.QueryEscape(r.Sort))
+	}
+	return buf.String()
+}
+
+// fromHTTP panics with an httputil value on failure
+func (r *DirTreeRequest) fromHTTP(req *http.Request) {
+	r.Dir = httputil.MustGetBlobRef(req, "dir")
+	r.Limit, _ = strconv.Atoi(req.FormValue("limit"))
+	r.Continue = req.FormValue("continue")
+	r.Sort = req.FormValue("sort")
+}
+
+// limit returns the sanitized page size.
+func (r *DirTreeRequest) limit() int {
+	if r.Limit <= 0 {
+		return defaultDirTreeLimit
+	}
+	if r.Limit > maxDirTreeLimit {
+		return maxDirTreeLimit
+	}
+	return r.Limit
+}
+
 // SignerPathsRequest is a request to get a SignerPathsResponse.
 type SignerPathsRequest struct {
 	Signer blob.Ref
@@ -359,6 +501,10 @@ type EdgesRequest struct {
 	ToRef blob.Ref
 }
 
+func (r *EdgesRequest) URLSuffix() string {
+	return fmt.Sprintf("camli/search/edgesto?blobref=%v", r.ToRef)
+}
+
 // fromHTTP panics with an httputil value on failure
 func (r *EdgesRequest) fromHTTP(req *http.Request) {
 	r.ToRef = httputil.MustGetBlobRef(req, "blobref")
@@ -410,6 +556,39 @@ type ClaimsResponse struct {
 	Claims []*ClaimsItem `json:"claims"`
 }
 
+// DirTreeResponse is the JSON response from $searchRoot/camli/search/dirtree.
+type DirTreeResponse struct {
+	Dir blob.Ref `json:"dir"`
+
+	// Total is the number of children in the whole directory,
+	// not just this page.
+	Total int `json:"total"`
+
+	Children []*DirTreeChild `json:"children,omitempty"`
+
+	// Continue, if non-empty, is the token to pass as the
+	// "continue" parameter to get the next page. It is empty on
+	// the last page.
+	Continue string `json:"continue,omitempty"`
+}
+
+// A DirTreeChild is one child in a DirTreeResponse.
+type DirTreeChild struct {
+	BlobRef blob.Ref `json:"blobRef"`
+	Name    string   `json:"name,omitempty"`
+	Type    string   `json:"type,omitempty"` // "file", "directory", "symlink", ...
+
+	// Size is the child's size in bytes for files, or its number
+	// of entries for directories.
+	Size int64 `json:"size,omitempty"`
+
+	ModTime *types.Time3339 `json:"modTime,omitempty"`
+
+	// ThumbHint is "image" if the child is known to be an image,
+	// so a UI can request a real thumbnail for it.
+	ThumbHint string `json:"thumbHint,omitempty"`
+}
+
 // SignerPathsResponse is the JSON response from $searchRoot/camli/search/signerpaths.
 type SignerPathsResponse struct {
 	Paths []*SignerPathsItem `json:"paths"`
@@ -480,33 +659,58 @@ func thumbnailSizeStr(s string) int {
 
 var testHookBug121 = func() {}
 
+// recentByModTime sorts RecentItems by modification time, newest first.
+type recentByModTime []*RecentItem
+
+func (s recentByModTime) Len() int      { return len(s) }
+func (s recentByModTime) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s recentByModTime) Less(i, j int) bool {
+	return time.Time(s[i].ModTime).After(time.Time(s[j].ModTime))
+}
+
 // GetRecentPermanodes returns recently-modified permanodes.
 func (sh *Handler) GetRecentPermanodes(req *RecentRequest) (*RecentResponse, error) {
-	ch := make(chan camtypes.RecentPermanode)
-	errch := make(chan error, 1)
 	before := time.Now()
 	if !req.Before.IsZero() {
 		before = req.Before
 	}
-	go func() {
-		errch <- sh.index.GetRecentPermanodes(ch, sh.owner, req.n(), before)
-	}()
 
 	dr := sh.NewDescribeRequest()
 
 	var recent []*RecentItem
-	for res := range ch {
-		dr.Describe(res.Permanode, 2)
-		recent = append(recent, &RecentItem{
-			BlobRef: res.Permanode,
-			Owner:   res.Signer,
-			ModTime: types.Time3339(res.LastModTime),
-		})
-		testHookBug121() // http://camlistore.org/issue/121
-	}
+	seen := make(map[blob.Ref]bool) // dups across owners
+	for _, owner := range sh.owners {
+		ch := make(chan camtypes.RecentPermanode)
+		errch := make(chan error, 1)
+		go func(owner blob.Ref) {
+			errch <- sh.index.GetRecentPermanodes(ch, owner, req.n(), before)
+		}(owner)
+
+		for res := range ch {
+			if seen[res.Permanode] {
+				continue
+			}
+			seen[res.Permanode] = true
+			dr.Describe(res.Permanode, 2)
+			recent = append(recent, &RecentItem{
+				BlobRef: res.Permanode,
+				Owner:   res.Signer,
+				ModTime: types.Time3339(res.LastModTime),
+			})
+			testHookBug121() // http://camlistore.org/issue/121
+		}
 
-	if err := <-errch; err != nil {
-		return nil, err
+		if err := <-errch; err != nil {
+			return nil, err
+		}
+	}
+	if len(sh.owners) > 1 {
+		// Each owner's results arrived in order, but the merge
+		// isn't sorted, and may exceed the requested count.
+		sort.Sort(recentByModTime(recent))
+		if len(recent) > req.n() {
+			recent = recent[:req.n()]
+		}
 	}
 
 	metaMap, err := dr.metaMapThumbs(req.thumbnailSize())
@@ -596,15 +800,19 @@ func (sh *Handler) servePermanodesWithAttr(rw http.ResponseWriter, req *http.Req
 	httputil.ReturnJSON(rw, res)
 }
 
-// GetClaims returns the claims on req.Permanode signed by sh.owner.
+// GetClaims returns the claims on req.Permanode signed by any of
+// sh.owners.
 func (sh *Handler) GetClaims(req *ClaimsRequest) (*ClaimsResponse, error) {
 	if !req.Permanode.Valid() {
 		return nil, errors.New("Error getting claims: nil permanode.")
 	}
 	var claims []camtypes.Claim
-	claims, err := sh.index.AppendClaims(claims, req.Permanode, sh.owner, req.AttrFilter)
-	if err != nil {
-		return nil, fmt.Errorf("Error getting claims of %s: %v", req.Permanode.String(), err)
+	var err error
+	for _, owner := range sh.owners {
+		claims, err = sh.index.AppendClaims(claims, req.Permanode, owner, req.AttrFilter)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting claims of %s: %v", req.Permanode.String(), err)
+		}
 	}
 	sort.Sort(camtypes.ClaimsByDate(claims))
 	var jclaims []*ClaimsItem
@@ -639,6 +847,119 @@ func (sh *Handler) serveClaims(rw http.ResponseWriter, req *http.Request) {
 	httputil.ReturnJSON(rw, res)
 }
 
+// childrenByName sorts DirTreeChildren by name, ties broken by blobref.
+type childrenByName []*DirTreeChild
+
+func (s childrenByName) Len() int      { return len(s) }
+func (s childrenByName) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s childrenByName) Less(i, j int) bool {
+	if s[i].Name != s[j].Name {
+		return s[i].Name < s[j].Name
+	}
+	return s[i].BlobRef.String() < s[j].BlobRef.String()
+}
+
+// childrenByModTime sorts DirTreeChildren by modification time, oldest
+// first, ties broken by name then blobref.
+type childrenByModTime []*DirTreeChild
+
+func (s childrenByModTime) Len() int      { return len(s) }
+func (s childrenByModTime) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s childrenByModTime) Less(i, j int) bool {
+	var ti, tj time.Time
+	if s[i].ModTime != nil {
+		ti = time.Time(*s[i].ModTime)
+	}
+	if s[j].ModTime != nil {
+		tj = time.Time(*s[j].ModTime)
+	}
+	if !ti.Equal(tj) {
+		return ti.Before(tj)
+	}
+	return childrenByName(s).Less(i, j)
+}
+
+// GetDirTree returns one page of the children of the static directory
+// req.Dir, from the index's directory rows, without describing them.
+func (sh *Handler) GetDirTree(req *DirTreeRequest) (*DirTreeResponse, error) {
+	if !req.Dir.Valid() {
+		return nil, errors.New("Error getting dir tree: nil dir.")
+	}
+	offset := 0
+	if req.Continue != "" {
+		o, err := strconv.Atoi(req.Continue)
+		if err != nil || o < 0 {
+			return nil, fmt.Errorf("invalid continuation token %q", req.Continue)
+		}
+		offset = o
+	}
+
+	ch := make(chan blob.Ref, buffered)
+	errch := make(chan error, 1)
+	go func() {
+		errch <- sh.index.GetDirMembers(req.Dir, ch, -1)
+	}()
+	var children []*DirTreeChild
+	for ref := range ch {
+		c := &DirTreeChild{BlobRef: ref}
+		if meta, err := sh.index.GetBlobMeta(ref); err == nil {
+			c.Type = meta.CamliType
+		}
+		if fi, err := sh.index.GetFileInfo(ref); err == nil {
+			c.Name = fi.FileName
+			c.Size = fi.Size
+			c.ModTime = fi.ModTime
+			if c.ModTime == nil {
+				c.ModTime = fi.Time
+			}
+			if fi.IsImage() {
+				c.ThumbHint = "image"
+			}
+		}
+		children = append(children, c)
+	}
+	if err := <-errch; err != nil {
+		return nil, err
+	}
+
+	switch req.Sort {
+	case "", "name":
+		sort.Sort(childrenByName(children))
+	case "modtime":
+		sort.Sort(childrenByModTime(children))
+	default:
+		return nil, fmt.Errorf("unknown sort order %q; want \"name\" or \"modtime\"", req.Sort)
+	}
+
+	res := &DirTreeResponse{
+		Dir:   req.Dir,
+		Total: len(children),
+	}
+	if offset > len(children) {
+		offset = len(children)
+	}
+	end := offset + req.limit()
+	if end < len(children) {
+		res.Continue = strconv.Itoa(end)
+	} else {
+		end = len(children)
+	}
+	res.Children = children[offset:end]
+	return res, nil
+}
+
+func (sh *Handler) serveDirTree(rw http.ResponseWriter, req *http.Request) {
+	defer httputil.RecoverJSON(rw, req)
+	var dr DirTreeRequest
+	dr.fromHTTP(req)
+	res, err := sh.GetDirTree(&dr)
+	if err != nil {
+		httputil.ServeJSONError(rw, err)
+		return
+	}
+	httputil.ReturnJSON(rw, res)
+}
+
 func (sh *Handler) serveFiles(rw http.ResponseWriter, req *http.Request) {
 	ret := jsonMap()
 	defer httputil.ReturnJSON(rw, ret)
@@ -784,6 +1105,21 @@ func (sh *Handler) serveEdgesTo(rw http.ResponseWriter, req *http.Request) {
 	httputil.ReturnJSON(rw, res)
 }
 
+// StorageStatsResponse is the JSON response from $searchRoot/camli/search/storagestats.
+type StorageStatsResponse struct {
+	Stats *camtypes.BlobStorageStats `json:"stats"`
+}
+
+func (sh *Handler) serveStorageStats(rw http.ResponseWriter, req *http.Request) {
+	defer httputil.RecoverJSON(rw, req)
+	stats, err := sh.index.StorageStats()
+	if err != nil {
+		httputil.ServeJSONError(rw, err)
+		return
+	}
+	httputil.ReturnJSON(rw, &StorageStatsResponse{Stats: stats})
+}
+
 func (sh *Handler) serveQuery(rw http.ResponseWriter, req *http.Request) {
 	defer httputil.RecoverJSON(rw, req)
 
@@ -792,6 +1128,7 @@ func (sh *Handler) serveQuery(rw http.ResponseWriter, req *http.Request) {
 		httputil.ServeJSONError(rw, err)
 		return
 	}
+	sq.allowedSigners = sh.allowedSigners(req)
 
 	sr, err := sh.Query(&sq)
 	if err != nil {
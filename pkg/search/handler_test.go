@@ -469,7 +469,8 @@ var handlerTests = []handlerTest{
 					"sha1-e3f0ee86622dda4d7e8a4a4af51117fb79dbdbbb"
 				  ]
 				},
-				"modtime": "` + addToClockOrigin(1*time.Second) + `"
+				"modtime": "` + addToClockOrigin(1*time.Second) + `",
+				"thumbnailRef": "sha1-e3f0ee86622dda4d7e8a4a4af51117fb79dbdbbb"
 			  },
 				 "size": 534
 					 },
@@ -484,7 +485,8 @@ var handlerTests = []handlerTest{
 			  },
 			  "image": {
 				"width": 50,
-				"height": 100
+				"height": 100,
+				"tinyPreview": "c4c4c4b3b3b3a2a2a2d4d4d4cfcfcf5656569b9b9bd2d2d2fcfcfc7d7d7dc5c5c5fefefeaeaeaeefefefd3d3d3b8b8b8"
 			  }
 			}
 				 }
@@ -549,7 +551,8 @@ var handlerTests = []handlerTest{
 					"sha1-7ca7743e38854598680d94ef85348f2c48a44513"
 				  ]
 				},
-				"modtime": "` + addToClockOrigin(2*time.Second) + `"
+				"modtime": "` + addToClockOrigin(2*time.Second) + `",
+				"thumbnailRef": "sha1-e3f0ee86622dda4d7e8a4a4af51117fb79dbdbbb"
 			  }
 			},
 			"sha1-7ca7743e38854598680d94ef85348f2c48a44513": {
@@ -562,7 +565,8 @@ var handlerTests = []handlerTest{
 					"sha1-e3f0ee86622dda4d7e8a4a4af51117fb79dbdbbb"
 				  ]
 				},
-				"modtime": "` + addToClockOrigin(1*time.Second) + `"
+				"modtime": "` + addToClockOrigin(1*time.Second) + `",
+				"thumbnailRef": "sha1-e3f0ee86622dda4d7e8a4a4af51117fb79dbdbbb"
 			  }
 			},
 			"sha1-e3f0ee86622dda4d7e8a4a4af51117fb79dbdbbb": {
@@ -576,7 +580,8 @@ var handlerTests = []handlerTest{
 			  },
 			  "image": {
 				"width": 50,
-				"height": 100
+				"height": 100,
+				"tinyPreview": "c4c4c4b3b3b3a2a2a2d4d4d4cfcfcf5656569b9b9bd2d2d2fcfcfc7d7d7dc5c5c5fefefeaeaeaeefefefd3d3d3b8b8b8"
 			  }
 			}
 		  }
@@ -0,0 +1,212 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package search
+
+import (
+	"errors"
+	"time"
+
+	"camlistore.org/pkg/blob"
+)
+
+// ErrTimeout is returned by QueryIterator when an underlying request
+// took longer than the iterator's Timeout.
+var ErrTimeout = errors.New("search: request timed out")
+
+// defaultBackoff is the delay before the first retry of a failed
+// request; it doubles with each subsequent retry.
+const defaultBackoff = 500 * time.Millisecond
+
+// A QueryIterator iterates over the results of a search query one
+// blob at a time, transparently fetching subsequent pages using the
+// server's continuation tokens. The query's Limit acts as the page
+// size. The zero value of the option fields means no timeout, no
+// retries, and no describing.
+//
+// A QueryIterator is not safe for concurrent use.
+type QueryIterator struct {
+	// Timeout optionally bounds how long one underlying request
+	// may take before the iterator gives up on it with ErrTimeout.
+	Timeout time.Duration
+
+	// MaxTries is how many times a failed request is attempted in
+	// total. Values below 2 mean no retries. Search queries are
+	// idempotent, so retrying is always safe.
+	MaxTries int
+
+	// Backoff is the delay before the first retry, doubling with
+	// each subsequent one. Zero means defaultBackoff.
+	Backoff time.Duration
+
+	// DescribeEach optionally specifies a template of a describe
+	// request to run against each page of results, one batched
+	// request per page. Only its exported fields (Rules, Depth,
+	// At, etc) are used; BlobRef and BlobRefs are ignored. The
+	// description of the current blob is available from the
+	// Described method.
+	DescribeEach *DescribeRequest
+
+	qd   QueryDescriber
+	q    SearchQuery // copy of the caller's query; Continue advances with each page
+	page *SearchResult
+	meta MetaMap // merged DescribeEach results
+	i    int     // index of the current blob in page.Blobs
+	err  error
+}
+
+// NewQueryIterator returns an iterator over the results of q, as
+// answered by qd (usually a *client.Client). Option fields on the
+// returned iterator may be set before the first call to Next.
+func NewQueryIterator(qd QueryDescriber, q *SearchQuery) *QueryIterator {
+	return &QueryIterator{
+		qd: qd,
+		q:  *q,
+		i:  -1,
+	}
+}
+
+// Next advances to the next result blob, fetching the next page from
+// the server when the current one is exhausted. It returns false when
+// the results are exhausted or an error occurs; Err distinguishes the
+// two.
+func (it *QueryIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	it.i++
+	for it.page == nil || it.i >= len(it.page.Blobs) {
+		if it.page != nil {
+			if it.page.Continue == "" {
+				return false
+			}
+			it.q.Continue = it.page.Continue
+		}
+		if !it.fetchPage() {
+			return false
+		}
+		it.i = 0
+	}
+	return true
+}
+
+// Err returns the error, if any, that stopped the iterator.
+func (it *QueryIterator) Err() error { return it.err }
+
+// Blob returns the current result blob. It must only be called after
+// a call to Next that returned true.
+func (it *QueryIterator) Blob() *SearchResultBlob {
+	return it.page.Blobs[it.i]
+}
+
+// Described returns the description of the current result blob, or
+// nil if the DescribeEach option wasn't set or the blob wasn't
+// described.
+func (it *QueryIterator) Described() *DescribedBlob {
+	if it.meta == nil {
+		return nil
+	}
+	return it.meta[it.Blob().Blob.String()]
+}
+
+func (it *QueryIterator) fetchPage() bool {
+	q := it.q
+	var res *SearchResult
+	err := it.retry(func() (err error) {
+		res, err = it.qd.Query(&q)
+		return
+	})
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.page = res
+	if it.DescribeEach == nil || len(res.Blobs) == 0 {
+		return true
+	}
+	refs := make([]blob.Ref, len(res.Blobs))
+	for i, b := range res.Blobs {
+		refs[i] = b.Blob
+	}
+	// Copy the template's exported fields only; DescribeRequest
+	// has internal state which must start out zero.
+	t := it.DescribeEach
+	dreq := &DescribeRequest{
+		BlobRefs:       refs,
+		Depth:          t.Depth,
+		MaxDirChildren: t.MaxDirChildren,
+		At:             t.At,
+		ThumbnailSize:  t.ThumbnailSize,
+		Rules:          t.Rules,
+	}
+	var dres *DescribeResponse
+	err = it.retry(func() (err error) {
+		dres, err = it.qd.Describe(dreq)
+		return
+	})
+	if err != nil {
+		it.err = err
+		return false
+	}
+	if it.meta == nil {
+		it.meta = make(MetaMap)
+	}
+	for brStr, db := range dres.Meta {
+		it.meta[brStr] = db
+	}
+	return true
+}
+
+// retry runs fn, retrying failures (including timeouts) up to
+// MaxTries attempts with exponential backoff.
+func (it *QueryIterator) retry(fn func() error) error {
+	tries := it.MaxTries
+	if tries < 1 {
+		tries = 1
+	}
+	backoff := it.Backoff
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+	var err error
+	for i := 0; i < tries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = it.withTimeout(fn); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// withTimeout runs fn, giving up with ErrTimeout after it.Timeout if
+// that's non-zero. The abandoned request keeps running; its result is
+// discarded.
+func (it *QueryIterator) withTimeout(fn func() error) error {
+	if it.Timeout <= 0 {
+		return fn()
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- fn() }()
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(it.Timeout):
+		return ErrTimeout
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package search
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"camlistore.org/pkg/blob"
+)
+
+// pagedQueryDescriber serves canned pages of results, failing each
+// query failPerPage times before succeeding.
+type pagedQueryDescriber struct {
+	pages       [][]blob.Ref
+	failPerPage int
+
+	queries   int
+	failures  int
+	describes int
+}
+
+func (qd *pagedQueryDescriber) Query(q *SearchQuery) (*SearchResult, error) {
+	if qd.failures < qd.failPerPage {
+		qd.failures++
+		return nil, errors.New("flaky network error")
+	}
+	qd.failures = 0
+	page := qd.queries
+	qd.queries++
+	if page >= len(qd.pages) {
+		return nil, fmt.Errorf("query for unexpected page %d", page)
+	}
+	res := new(SearchResult)
+	for _, br := range qd.pages[page] {
+		res.Blobs = append(res.Blobs, &SearchResultBlob{Blob: br})
+	}
+	if page < len(qd.pages)-1 {
+		res.Continue = fmt.Sprintf("page-%d", page+1)
+	}
+	return res, nil
+}
+
+func (qd *pagedQueryDescriber) Describe(dr *DescribeRequest) (*DescribeResponse, error) {
+	qd.describes++
+	meta := make(MetaMap)
+	for _, br := range dr.BlobRefs {
+		meta[br.String()] = &DescribedBlob{BlobRef: br, CamliType: "permanode"}
+	}
+	return &DescribeResponse{Meta: meta}, nil
+}
+
+func testRefs(n int) []blob.Ref {
+	refs := make([]blob.Ref, n)
+	for i := range refs {
+		refs[i] = blob.RefFromString(fmt.Sprintf("blob %d", i))
+	}
+	return refs
+}
+
+func TestQueryIterator(t *testing.T) {
+	refs := testRefs(5)
+	qd := &pagedQueryDescriber{
+		pages: [][]blob.Ref{refs[:2], refs[2:4], refs[4:]},
+	}
+	it := NewQueryIterator(qd, &SearchQuery{Limit: 2})
+	it.DescribeEach = &DescribeRequest{}
+	var got []blob.Ref
+	for it.Next() {
+		got = append(got, it.Blob().Blob)
+		des := it.Described()
+		if des == nil || des.BlobRef != it.Blob().Blob {
+			t.Errorf("bad description for %v: %+v", it.Blob().Blob, des)
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(refs) {
+		t.Fatalf("got %d blobs; want %d", len(got), len(refs))
+	}
+	for i, br := range got {
+		if br != refs[i] {
+			t.Errorf("blob %d = %v; want %v", i, br, refs[i])
+		}
+	}
+	if qd.queries != 3 {
+		t.Errorf("%d queries; want 3", qd.queries)
+	}
+	if qd.describes != 3 {
+		t.Errorf("%d describe batches; want 3", qd.describes)
+	}
+}
+
+func TestQueryIteratorRetry(t *testing.T) {
+	qd := &pagedQueryDescriber{
+		pages:       [][]blob.Ref{testRefs(2)},
+		failPerPage: 2,
+	}
+	it := NewQueryIterator(qd, &SearchQuery{})
+	it.MaxTries = 3
+	it.Backoff = 1 // no need to wait in tests
+	n := 0
+	for it.Next() {
+		n++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("with retries: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("got %d blobs; want 2", n)
+	}
+
+	// Without retries the first failure is fatal.
+	qd = &pagedQueryDescriber{
+		pages:       [][]blob.Ref{testRefs(2)},
+		failPerPage: 1,
+	}
+	it = NewQueryIterator(qd, &SearchQuery{})
+	if it.Next() {
+		t.Error("Next succeeded; want failure without retries")
+	}
+	if it.Err() == nil {
+		t.Error("no error without retries")
+	}
+}
@@ -204,6 +204,13 @@ func readColon(l *lexer) stateFn {
 
 func readPredicate(l *lexer) stateFn {
 	l.acceptRunFn(unicode.IsLetter)
+	// Also accept interior dashes, for predicates like
+	// "modified-attr". A leading dash (negation) has already been
+	// consumed before this point.
+	for l.peek() == '-' {
+		l.accept("-")
+		l.acceptRunFn(unicode.IsLetter)
+	}
 	switch l.peek() {
 	case ':':
 		l.emit(tokenPredicate)
@@ -0,0 +1,241 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package search
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/httputil"
+	"camlistore.org/pkg/types"
+)
+
+// PersonAttr is the permanode attribute naming a person (by their
+// person permanode ref) appearing in a photo. A photo permanode may
+// have multiple such values. The region of the photo showing that
+// person is optionally stored in the attribute named PersonAttr +
+// ":rect:" + the person's ref, as "x,y,w,h" with all four values
+// normalized to [0,1] relative to the image dimensions. Both
+// attributes are plain permanode attributes, so they are included in
+// describe responses without any special handling.
+const PersonAttr = "inPicture"
+
+// PersonRectAttr returns the name of the photo permanode attribute
+// holding the normalized bounding box of person in that photo.
+func PersonRectAttr(person blob.Ref) string {
+	return PersonAttr + ":rect:" + person.String()
+}
+
+// PeopleRequest is a request to get a PeopleResponse.
+type PeopleRequest struct {
+	N             int // max number of results; default if zero
+	ThumbnailSize int // if zero, no thumbnails
+}
+
+func (r *PeopleRequest) URLSuffix() string {
+	return fmt.Sprintf("camli/search/people?n=%d&thumbnails=%d", r.N, r.ThumbnailSize)
+}
+
+// fromHTTP panics with an httputil value on failure
+func (r *PeopleRequest) fromHTTP(req *http.Request) {
+	r.N, _ = strconv.Atoi(req.FormValue("n"))
+	r.ThumbnailSize = thumbnailSize(req)
+}
+
+// PeopleResponse is the JSON response from $searchRoot/camli/search/people.
+type PeopleResponse struct {
+	People []*PersonItem `json:"people"`
+	Meta   MetaMap       `json:"meta"`
+}
+
+// PersonItem describes a person permanode and the number of photos
+// currently referencing it with an inPicture attribute.
+type PersonItem struct {
+	Permanode  blob.Ref `json:"permanode"`
+	PhotoCount int      `json:"photoCount"`
+}
+
+// GetPeople returns the person permanodes referenced by the inPicture
+// attribute of any photo permanode, most-photographed first. The
+// counts come from the in-memory corpus, so the whole listing is a
+// single pass, not a query per person.
+func (sh *Handler) GetPeople(req *PeopleRequest) (*PeopleResponse, error) {
+	if sh.corpus == nil {
+		return nil, errors.New("listing people requires a corpus")
+	}
+	n := req.N
+	if n <= 0 || n > maxResults {
+		n = defaultNumResults
+	}
+	sh.corpus.RLock()
+	counts := sh.corpus.AttrValueRefCountsLocked(time.Time{}, PersonAttr)
+	sh.corpus.RUnlock()
+
+	people := make([]*PersonItem, 0, len(counts))
+	for pn, count := range counts {
+		people = append(people, &PersonItem{
+			Permanode:  pn,
+			PhotoCount: count,
+		})
+	}
+	sort.Sort(personItemsByCount(people))
+	if len(people) > n {
+		people = people[:n]
+	}
+
+	dr := sh.NewDescribeRequest()
+	for _, p := range people {
+		dr.Describe(p.Permanode, 2)
+	}
+	metaMap, err := dr.metaMapThumbs(req.ThumbnailSize)
+	if err != nil {
+		return nil, err
+	}
+	return &PeopleResponse{
+		People: people,
+		Meta:   metaMap,
+	}, nil
+}
+
+type personItemsByCount []*PersonItem
+
+func (s personItemsByCount) Len() int      { return len(s) }
+func (s personItemsByCount) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s personItemsByCount) Less(i, j int) bool {
+	if s[i].PhotoCount != s[j].PhotoCount {
+		return s[i].PhotoCount > s[j].PhotoCount
+	}
+	return s[i].Permanode.String() < s[j].Permanode.String()
+}
+
+func (sh *Handler) servePeople(rw http.ResponseWriter, req *http.Request) {
+	defer httputil.RecoverJSON(rw, req)
+	var pr PeopleRequest
+	pr.fromHTTP(req)
+	res, err := sh.GetPeople(&pr)
+	if err != nil {
+		httputil.ServeJSONError(rw, err)
+		return
+	}
+	httputil.ReturnJSON(rw, res)
+}
+
+// PersonPhotosRequest is a request to get a PersonPhotosResponse.
+type PersonPhotosRequest struct {
+	Person        blob.Ref // required: the person permanode
+	N             int      // max number of results; default if zero
+	ThumbnailSize int      // if zero, no thumbnails
+}
+
+func (r *PersonPhotosRequest) URLSuffix() string {
+	return fmt.Sprintf("camli/search/personphotos?person=%v&n=%d&thumbnails=%d",
+		r.Person, r.N, r.ThumbnailSize)
+}
+
+// fromHTTP panics with an httputil value on failure
+func (r *PersonPhotosRequest) fromHTTP(req *http.Request) {
+	r.Person = blob.ParseOrZero(req.FormValue("person"))
+	r.N, _ = strconv.Atoi(req.FormValue("n"))
+	r.ThumbnailSize = thumbnailSize(req)
+}
+
+// PersonPhotosResponse is the JSON response from
+// $searchRoot/camli/search/personphotos.
+type PersonPhotosResponse struct {
+	Photos []*PersonPhotoItem `json:"photos"`
+	Meta   MetaMap            `json:"meta"`
+}
+
+// PersonPhotoItem describes one photo permanode showing the requested
+// person.
+type PersonPhotoItem struct {
+	Permanode blob.Ref       `json:"permanode"`
+	Time      types.Time3339 `json:"time"`
+}
+
+// GetPersonPhotos returns the photo permanodes which currently have
+// req.Person as a value of their inPicture attribute, newest first.
+func (sh *Handler) GetPersonPhotos(req *PersonPhotosRequest) (*PersonPhotosResponse, error) {
+	if sh.corpus == nil {
+		return nil, errors.New("listing a person's photos requires a corpus")
+	}
+	if !req.Person.Valid() {
+		return nil, errors.New("missing required person blobref")
+	}
+	n := req.N
+	if n <= 0 || n > maxResults {
+		n = defaultNumResults
+	}
+	sh.corpus.RLock()
+	pns := sh.corpus.AppendClaimBackRefsLocked(nil, req.Person, time.Time{}, PersonAttr)
+	photos := make([]*PersonPhotoItem, 0, len(pns))
+	for _, pn := range pns {
+		t, _ := sh.corpus.PermanodeAnyTimeLocked(pn)
+		photos = append(photos, &PersonPhotoItem{
+			Permanode: pn,
+			Time:      types.Time3339(t),
+		})
+	}
+	sh.corpus.RUnlock()
+
+	sort.Sort(personPhotosByTime(photos))
+	if len(photos) > n {
+		photos = photos[:n]
+	}
+
+	dr := sh.NewDescribeRequest()
+	for _, p := range photos {
+		dr.Describe(p.Permanode, 2)
+	}
+	metaMap, err := dr.metaMapThumbs(req.ThumbnailSize)
+	if err != nil {
+		return nil, err
+	}
+	return &PersonPhotosResponse{
+		Photos: photos,
+		Meta:   metaMap,
+	}, nil
+}
+
+type personPhotosByTime []*PersonPhotoItem
+
+func (s personPhotosByTime) Len() int      { return len(s) }
+func (s personPhotosByTime) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s personPhotosByTime) Less(i, j int) bool {
+	ti, tj := time.Time(s[i].Time), time.Time(s[j].Time)
+	if !ti.Equal(tj) {
+		return ti.After(tj)
+	}
+	return s[i].Permanode.String() < s[j].Permanode.String()
+}
+
+func (sh *Handler) servePersonPhotos(rw http.ResponseWriter, req *http.Request) {
+	defer httputil.RecoverJSON(rw, req)
+	var pr PersonPhotosRequest
+	pr.fromHTTP(req)
+	res, err := sh.GetPersonPhotos(&pr)
+	if err != nil {
+		httputil.ServeJSONError(rw, err)
+		return
+	}
+	httputil.ReturnJSON(rw, res)
+}
@@ -104,11 +104,15 @@ func init() {
 	registerKeyword(newAfter())
 	registerKeyword(newBefore())
 	registerKeyword(newAttribute())
+	registerKeyword(newModifiedAttr())
 	registerKeyword(newChildrenOf())
 	registerKeyword(newFormat())
 	registerKeyword(newTag())
 	registerKeyword(newTitle())
 
+	// File predicates
+	registerKeyword(newIsOrphanFile())
+
 	// Image predicates
 	registerKeyword(newIsImage())
 	registerKeyword(newHeight())
@@ -250,6 +254,38 @@ func (a attribute) Predicate(ctx *context.Context, args []string) (*Constraint,
 	return c, nil
 }
 
+type modifiedAttr struct {
+	matchPrefix
+}
+
+func newModifiedAttr() keyword {
+	return modifiedAttr{matchPrefix{"modified-attr", 2}}
+}
+
+func (m modifiedAttr) Description() string {
+	return "match permanodes whose attribute changed since a time.\n" +
+		"modified-attr:tag:2014-01-01 matches permanodes with at least one\n" +
+		"claim since Jan 1 of year 2014 setting, adding or deleting a \"tag\"\n" +
+		"value. Leave the attribute empty to match a change of any attribute.\n" +
+		"The date format is the same as for after: and before:."
+}
+
+func (m modifiedAttr) Predicate(ctx *context.Context, args []string) (*Constraint, error) {
+	t, err := parseTimePrefix(args[1])
+	if err != nil {
+		return nil, err
+	}
+	c := &Constraint{
+		Permanode: &PermanodeConstraint{
+			ModifiedAttr: &ModifiedAttrConstraint{
+				Attr:  args[0],
+				Since: types.Time3339(t),
+			},
+		},
+	}
+	return c, nil
+}
+
 type childrenOf struct {
 	matchPrefix
 }
@@ -344,6 +380,30 @@ func (t title) Predicate(ctx *context.Context, args []string) (*Constraint, erro
 	return c, nil
 }
 
+// File predicates
+
+type isOrphanFile struct {
+	matchEqual
+}
+
+func newIsOrphanFile() keyword {
+	return isOrphanFile{"is:orphanfile"}
+}
+
+func (k isOrphanFile) Description() string {
+	return "indexed file that no permanode references as its camliContent.\n" +
+		"Unlike most predicates, the results are file blobs, not permanodes."
+}
+
+func (k isOrphanFile) Predicate(ctx *context.Context, args []string) (*Constraint, error) {
+	c := &Constraint{
+		File: &FileConstraint{
+			IsOrphan: true,
+		},
+	}
+	return c, nil
+}
+
 // Image predicates
 
 type isImage struct {
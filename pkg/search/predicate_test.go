@@ -188,6 +188,25 @@ var keywordTests = []keywordTestcase{
 		want:   attrfoobarC,
 	},
 
+	{
+		object:      newModifiedAttr(),
+		args:        []string{"tag", "faulty"},
+		errContains: "faulty",
+	},
+
+	{
+		object: newModifiedAttr(),
+		args:   []string{"tag", "2013-02-03"},
+		want: &Constraint{
+			Permanode: &PermanodeConstraint{
+				ModifiedAttr: &ModifiedAttrConstraint{
+					Attr:  "tag",
+					Since: types.Time3339(testtime),
+				},
+			},
+		},
+	},
+
 	{
 		object: newAttribute(),
 		args:   []string{"foo", ""},
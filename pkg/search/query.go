@@ -100,6 +100,17 @@ type SearchQuery struct {
 	// If Describe is specified, the matched blobs are also described,
 	// as if the Describe.BlobRefs field was populated.
 	Describe *DescribeRequest `json:"describe,omitempty"`
+
+	// If Snippets is true, each matched blob also gets up to a few
+	// highlighted text fragments showing where the query's string
+	// terms matched, in its Snippets field.
+	Snippets bool `json:"snippets,omitempty"`
+
+	// allowedSigners optionally restricts the results to permanodes
+	// with at least one claim signed by one of these signers. It is
+	// set by the search handler from its per-user owners
+	// configuration. If nil, no filtering is done.
+	allowedSigners map[blob.Ref]bool
 }
 
 func (q *SearchQuery) URLSuffix() string { return "camli/search/query" }
@@ -247,6 +258,16 @@ type SearchResult struct {
 
 type SearchResultBlob struct {
 	Blob blob.Ref `json:"blob"`
+
+	// ModifiedAttrTime, if non-nil, is the time of the latest
+	// claim satisfying the query's ModifiedAttr constraint, for
+	// incremental consumers to checkpoint on.
+	ModifiedAttrTime *types.Time3339 `json:"modifiedAttrTime,omitempty"`
+
+	// Snippets are highlighted text fragments showing where the
+	// query's string terms matched this blob. It is only populated
+	// when the query's Snippets flag is set.
+	Snippets []*Snippet `json:"snippets,omitempty"`
 	// ... file info, permanode info, blob info ... ?
 }
 
@@ -337,6 +358,17 @@ type FileConstraint struct {
 
 	// MediaTag is for ID3 (and similar) embedded metadata in files.
 	MediaTag *MediaTagConstraint `json:"mediaTag,omitempty"`
+
+	// ReferencedBy, if non-nil, matches only files that some
+	// permanode currently references as its "camliContent", with
+	// the referencing permanode matching the sub-constraint.
+	// It requires the in-memory corpus.
+	ReferencedBy *Constraint `json:"referencedBy,omitempty"`
+
+	// IsOrphan, if true, matches only files that no permanode
+	// currently references as its "camliContent": the inverse of
+	// ReferencedBy. It requires the in-memory corpus.
+	IsOrphan bool `json:"isOrphan,omitempty"`
 }
 
 type MediaTagConstraint struct {
@@ -614,6 +646,11 @@ type PermanodeConstraint struct {
 	// type to have an lat/long location.
 	Location *LocationConstraint `json:"location,omitempty"`
 
+	// ModifiedAttr optionally matches permanodes having at least
+	// one claim newer than ModifiedAttr.Since touching
+	// ModifiedAttr.Attr. It requires an in-memory corpus.
+	ModifiedAttr *ModifiedAttrConstraint `json:"modifiedAttr,omitempty"`
+
 	// Continue is for internal use.
 	Continue *PermanodeContinueConstraint `json:"-"`
 
@@ -649,6 +686,28 @@ func (pcc *PermanodeContinueConstraint) checkValid() error {
 	return nil
 }
 
+// ModifiedAttrConstraint matches permanodes having at least one
+// set/add/del-attribute claim strictly newer than Since touching the
+// attribute Attr (or any attribute, if Attr is empty). Incremental
+// consumers can use it to poll for changes: the time of the latest
+// matching claim is reported in each result's ModifiedAttrTime, to be
+// used as the Since of the next query.
+type ModifiedAttrConstraint struct {
+	// Attr is the attribute to watch, e.g. "camliContent" or
+	// "tag". An empty Attr matches a change of any attribute.
+	Attr string `json:"attr,omitempty"`
+
+	// Since is the exclusive lower bound on the claim time.
+	Since types.Time3339 `json:"since"`
+}
+
+func (mc *ModifiedAttrConstraint) checkValid() error {
+	if mc.Since.Time().IsZero() {
+		return errors.New("ModifiedAttrConstraint requires a non-zero Since time")
+	}
+	return nil
+}
+
 type RelationConstraint struct {
 	// Relation must be one of:
 	//   * "child"
@@ -772,6 +831,11 @@ type search struct {
 	// We assume (at least so far) that only 1 goroutine is used
 	// for a given search, so anything can use this.
 	ss []string // scratch
+
+	// modAttrTime, if non-nil, maps matched permanodes to the
+	// time of the latest claim satisfying the query's
+	// ModifiedAttr constraint.
+	modAttrTime map[blob.Ref]time.Time
 }
 
 func (s *search) blobMeta(br blob.Ref) (camtypes.BlobMeta, error) {
@@ -790,6 +854,32 @@ func (s *search) fileInfo(br blob.Ref) (camtypes.FileInfo, error) {
 	}
 }
 
+// signerAllowed reports whether the blob br may appear in the results,
+// given the query's allowed signer set. Non-permanode blobs are always
+// visible. The corpus, if any, is locked for reads.
+func (s *search) signerAllowed(br blob.Ref, bm camtypes.BlobMeta) bool {
+	allowed := s.q.allowedSigners
+	if allowed == nil {
+		return true
+	}
+	if bm.CamliType != "permanode" {
+		return true
+	}
+	if c := s.h.corpus; c != nil {
+		return c.PermanodeSignedByLocked(br, allowed)
+	}
+	claims, err := s.h.index.AppendClaims(nil, br, blob.Ref{}, "")
+	if err != nil {
+		return false
+	}
+	for _, cl := range claims {
+		if allowed[cl.Signer] {
+			return true
+		}
+	}
+	return false
+}
+
 // optimizePlan returns an optimized version of c which will hopefully
 // execute faster than executing c literally.
 func optimizePlan(c *Constraint) *Constraint {
@@ -839,9 +929,15 @@ func (h *Handler) Query(rawq *SearchQuery) (*SearchResult, error) {
 			return nil, err
 		}
 		if match {
-			res.Blobs = append(res.Blobs, &SearchResultBlob{
-				Blob: meta.Ref,
-			})
+			if !s.signerAllowed(meta.Ref, meta) {
+				continue
+			}
+			srb := &SearchResultBlob{Blob: meta.Ref}
+			if t, ok := s.modAttrTime[meta.Ref]; ok {
+				t3 := types.Time3339(t)
+				srb.ModifiedAttrTime = &t3
+			}
+			res.Blobs = append(res.Blobs, srb)
 			if q.Limit > 0 && len(res.Blobs) == q.Limit && cands.sorted {
 				sendCtx.Cancel()
 				break
@@ -857,6 +953,9 @@ func (h *Handler) Query(rawq *SearchQuery) (*SearchResult, error) {
 			res.Blobs = res.Blobs[:q.Limit]
 		}
 	}
+	if q.Snippets {
+		s.populateSnippets()
+	}
 	if corpus != nil {
 		q.setResultContinue(corpus, res)
 		unlockOnce.Do(corpus.RUnlock)
@@ -864,6 +963,7 @@ func (h *Handler) Query(rawq *SearchQuery) (*SearchResult, error) {
 
 	if q.Describe != nil {
 		q.Describe.BlobRef = blob.Ref{} // zero this out, if caller set it
+		q.Describe.allowedSigners = q.allowedSigners
 		blobs := make([]blob.Ref, 0, len(res.Blobs))
 		for _, srb := range res.Blobs {
 			blobs = append(blobs, srb.Blob)
@@ -1154,6 +1254,11 @@ func (c *PermanodeConstraint) checkValid() error {
 			return err
 		}
 	}
+	if mc := c.ModifiedAttr; mc != nil {
+		if err := mc.checkValid(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -1162,7 +1267,7 @@ var numPermanodeFields = reflect.TypeOf(PermanodeConstraint{}).NumField()
 // hasValueConstraint returns true if one or more constraints that check an attribute's value are set.
 func (c *PermanodeConstraint) hasValueConstraint() bool {
 	// If a field has been added or removed, update this after adding the new field to the return statement if necessary.
-	const expectedFields = 15
+	const expectedFields = 16
 	if numPermanodeFields != expectedFields {
 		panic(fmt.Sprintf("PermanodeConstraint field count changed (now %v rather than %v)", numPermanodeFields, expectedFields))
 	}
@@ -1264,6 +1369,22 @@ func (c *PermanodeConstraint) blobMatches(s *search, br blob.Ref, bm camtypes.Bl
 		}
 	}
 
+	if mc := c.ModifiedAttr; mc != nil {
+		if corpus == nil {
+			// TODO: implement without an in-memory corpus,
+			// scanning the claim rows instead.
+			return false, nil
+		}
+		changed, ok := corpus.PermanodeAttrChangedLocked(br, mc.Attr, mc.Since.Time())
+		if !ok {
+			return false, nil
+		}
+		if s.modAttrTime == nil {
+			s.modAttrTime = make(map[blob.Ref]time.Time)
+		}
+		s.modAttrTime[br] = changed
+	}
+
 	if cc := c.Continue; cc != nil {
 		if corpus == nil {
 			// Requires an in-memory index for infinite
@@ -1366,6 +1487,9 @@ func (c *PermanodeConstraint) permanodeMatchesAttrVal(s *search, val string) (bo
 }
 
 func (c *FileConstraint) checkValid() error {
+	if c.IsOrphan && c.ReferencedBy != nil {
+		return errors.New(`file constraint can't set both "isOrphan" and "referencedBy"`)
+	}
 	return nil
 }
 
@@ -1455,6 +1579,35 @@ func (c *FileConstraint) blobMatches(s *search, br blob.Ref, bm camtypes.BlobMet
 			return false, nil
 		}
 	}
+	if c.IsOrphan || c.ReferencedBy != nil {
+		if corpus == nil {
+			return false, nil
+		}
+		refs := corpus.AppendClaimBackRefsLocked(nil, br, time.Time{}, "camliContent")
+		if c.IsOrphan && len(refs) > 0 {
+			return false, nil
+		}
+		if subc := c.ReferencedBy; subc != nil {
+			matches := false
+			for _, pn := range refs {
+				meta, err := s.blobMeta(pn)
+				if err != nil {
+					continue
+				}
+				ok, err := subc.matcher()(s, pn, meta)
+				if err != nil {
+					return false, err
+				}
+				if ok {
+					matches = true
+					break
+				}
+			}
+			if !matches {
+				return false, nil
+			}
+		}
+	}
 	// TOOD: EXIF timeconstraint
 	return true, nil
 }
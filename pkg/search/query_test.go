@@ -569,6 +569,68 @@ func TestQueryFileConstraint(t *testing.T) {
 	})
 }
 
+func TestQueryFileIsOrphan(t *testing.T) {
+	testQueryTypes(t, memIndexTypes, func(qt *queryTest) {
+		id := qt.id
+		orphanRef, _ := id.UploadFile("orphan.txt", "hello", time.Unix(123, 0))
+		fileRef, _ := id.UploadFile("referenced.txt", "hellooooo", time.Unix(456, 0))
+		p1 := id.NewPlannedPermanode("1")
+		claimRef := id.SetAttribute(p1, "camliContent", fileRef.String())
+
+		sq := &SearchQuery{
+			Constraint: &Constraint{
+				File: &FileConstraint{
+					IsOrphan: true,
+				},
+			},
+		}
+		qt.wantRes(sq, orphanRef)
+
+		// Deleting the referencing claim orphans the file again.
+		id.Delete(claimRef)
+		qt.wantRes(sq, orphanRef, fileRef)
+	})
+}
+
+func TestQueryFileReferencedBy(t *testing.T) {
+	testQueryTypes(t, memIndexTypes, func(qt *queryTest) {
+		id := qt.id
+		fileRef, _ := id.UploadFile("photo.jpg", "some photo", time.Unix(123, 0))
+		id.UploadFile("other.txt", "other stuff", time.Unix(456, 0))
+		p1 := id.NewPlannedPermanode("1")
+		id.SetAttribute(p1, "camliContent", fileRef.String())
+		id.SetAttribute(p1, "tag", "vacation")
+
+		// Referenced by any permanode at all.
+		sq := &SearchQuery{
+			Constraint: &Constraint{
+				File: &FileConstraint{
+					ReferencedBy: &Constraint{Anything: true},
+				},
+			},
+		}
+		qt.wantRes(sq, fileRef)
+
+		// The referencing permanode must match the sub-constraint.
+		byTag := func(tag string) *SearchQuery {
+			return &SearchQuery{
+				Constraint: &Constraint{
+					File: &FileConstraint{
+						ReferencedBy: &Constraint{
+							Permanode: &PermanodeConstraint{
+								Attr:  "tag",
+								Value: tag,
+							},
+						},
+					},
+				},
+			}
+		}
+		qt.wantRes(byTag("vacation"), fileRef)
+		qt.wantRes(byTag("work"))
+	})
+}
+
 func TestQueryPermanodeModtime(t *testing.T) {
 	testQuery(t, func(qt *queryTest) {
 		id := qt.id
@@ -595,6 +657,64 @@ func TestQueryPermanodeModtime(t *testing.T) {
 	})
 }
 
+func TestQueryPermanodeModifiedAttr(t *testing.T) {
+	testQueryTypes(t, memIndexTypes, func(qt *queryTest) {
+		id := qt.id
+
+		p1 := id.NewPlannedPermanode("1")
+		p2 := id.NewPlannedPermanode("2")
+		id.SetAttribute(p1, "tag", "old")
+		id.SetAttribute(p2, "tag", "old")
+		cutoff := id.LastTime()
+
+		id.SetAttribute(p2, "tag", "new")
+		wantTime := id.LastTime()
+		id.SetAttribute(p1, "other", "x") // not "tag"; only matters to the any-attribute query
+
+		handler := qt.Handler()
+		res, err := handler.Query(&SearchQuery{
+			Constraint: &Constraint{
+				Permanode: &PermanodeConstraint{
+					ModifiedAttr: &ModifiedAttrConstraint{
+						Attr:  "tag",
+						Since: types.Time3339(cutoff),
+					},
+				},
+			},
+		})
+		if err != nil {
+			qt.t.Fatal(err)
+		}
+		if len(res.Blobs) != 1 || res.Blobs[0].Blob != p2 {
+			qt.t.Fatalf("got search results %v; want just %v", res.Blobs, p2)
+		}
+		if mt := res.Blobs[0].ModifiedAttrTime; mt == nil || !mt.Time().Equal(wantTime) {
+			qt.t.Errorf("ModifiedAttrTime = %v; want %v", mt, wantTime)
+		}
+
+		// With no attribute named, any attribute change counts.
+		res, err = handler.Query(&SearchQuery{
+			Constraint: &Constraint{
+				Permanode: &PermanodeConstraint{
+					ModifiedAttr: &ModifiedAttrConstraint{
+						Since: types.Time3339(cutoff),
+					},
+				},
+			},
+		})
+		if err != nil {
+			qt.t.Fatal(err)
+		}
+		got := map[blob.Ref]bool{}
+		for _, srb := range res.Blobs {
+			got[srb.Blob] = true
+		}
+		if len(got) != 2 || !got[p1] || !got[p2] {
+			qt.t.Errorf("got search results %v; want %v and %v", res.Blobs, p1, p2)
+		}
+	})
+}
+
 // This really belongs in pkg/index for the index-vs-corpus tests, but
 // it's easier here for now.
 // TODO: make all the indextest/tests.go
@@ -1235,3 +1355,89 @@ func BenchmarkQueryRecentPermanodes(b *testing.B) {
 		}
 	})
 }
+
+func TestQueryAllowedSigners(t *testing.T) {
+	testQuery(t, func(qt *queryTest) {
+		id := qt.id
+
+		// Interleaved permanodes from two signers sharing the index.
+		p1 := id.NewPermanode()
+		id.SetAttribute(p1, "tag", "a")
+		p2 := id.NewPermanodeAs(id.SignerBlobRef2)
+		id.SetAttributeAs(id.SignerBlobRef2, p2, "tag", "b")
+		p3 := id.NewPermanode()
+		// p3 references the other signer's permanode.
+		id.SetAttribute(p3, "camliContent", p2.String())
+
+		h := qt.Handler()
+
+		newQuery := func() *SearchQuery {
+			return &SearchQuery{
+				Constraint: &Constraint{CamliType: "permanode"},
+				Describe: &DescribeRequest{
+					Rules: []*DescribeRule{
+						{Attrs: []string{"camliContent"}},
+					},
+				},
+			}
+		}
+		wantBlobs := func(res *SearchResult, wanted ...blob.Ref) {
+			want := make(map[blob.Ref]bool)
+			for _, br := range wanted {
+				want[br] = true
+			}
+			for _, bi := range res.Blobs {
+				if !want[bi.Blob] {
+					qt.t.Errorf("unexpected search result: %v", bi.Blob)
+				}
+				delete(want, bi.Blob)
+			}
+			for br := range want {
+				qt.t.Errorf("missing from search result: %v", br)
+			}
+		}
+
+		// With no signer restriction, both signers' permanodes are
+		// returned, and p2 is described via p3's reference to it.
+		res, err := h.Query(newQuery())
+		if err != nil {
+			qt.t.Fatal(err)
+		}
+		wantBlobs(res, p1, p2, p3)
+		if res.Describe.Meta[p2.String()] == nil {
+			qt.t.Errorf("unrestricted query: %v not described", p2)
+		}
+
+		// Restricted to the first signer, the second signer's
+		// permanode must appear neither in the results nor in the
+		// describe expansion of p3's reference to it.
+		sq := newQuery()
+		sq.SetAllowedSigners(id.SignerBlobRef)
+		if res, err = h.Query(sq); err != nil {
+			qt.t.Fatal(err)
+		}
+		wantBlobs(res, p1, p3)
+		if res.Describe.Meta[p3.String()] == nil {
+			qt.t.Errorf("restricted query: %v not described", p3)
+		}
+		if res.Describe.Meta[p2.String()] != nil {
+			qt.t.Errorf("restricted query: %v leaked into describe results", p2)
+		}
+
+		// Restricted to the second signer, only its permanode shows.
+		sq = newQuery()
+		sq.SetAllowedSigners(id.SignerBlobRef2)
+		if res, err = h.Query(sq); err != nil {
+			qt.t.Fatal(err)
+		}
+		wantBlobs(res, p2)
+
+		// An unknown user gets an empty signer set and sees nothing.
+		sq = newQuery()
+		sq.SetAllowedSigners()
+		if res, err = h.Query(sq); err != nil {
+			qt.t.Fatal(err)
+		}
+		wantBlobs(res)
+	})
+}
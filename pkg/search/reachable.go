@@ -0,0 +1,144 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package search
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/httputil"
+	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/types/camtypes"
+)
+
+// ReachableRequest is a request to stream the closure of blobs
+// reachable from a set of root blobrefs: for permanodes, their claims
+// (signed by one of the handler's owners) and the blobs those claims
+// reference; for schema blobs, their parts, static set members, and
+// directory entries, recursively. Claims signed by other signers are
+// not included, since the handler only indexes its owners' claims.
+type ReachableRequest struct {
+	Roots []blob.Ref `json:"roots"`
+}
+
+// ReachableItem is one line of the newline-delimited JSON stream
+// returned by the reachable endpoint.
+type ReachableItem struct {
+	BlobRef blob.Ref `json:"blobRef"`
+	Size    uint32   `json:"size"`
+}
+
+const reachableBuffered = 32 // arbitrary
+
+// serveReachable streams the closure of blobs reachable from the
+// request's roots as newline-delimited ReachableItem JSON objects.
+// It requires the handler to be configured with a blob source.
+func (sh *Handler) serveReachable(rw http.ResponseWriter, req *http.Request) {
+	if sh.fetcher == nil {
+		httputil.ServeJSONError(rw, errors.New(`search handler has no "blobSource" configured; can't compute reachable blobs`))
+		return
+	}
+	var rr ReachableRequest
+	if err := json.NewDecoder(req.Body).Decode(&rr); err != nil {
+		httputil.ServeJSONError(rw, err)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	enc := json.NewEncoder(rw)
+	sh.streamReachable(rr.Roots, func(item ReachableItem) error {
+		return enc.Encode(item)
+	})
+}
+
+// streamReachable walks the closure of blobs reachable from roots,
+// calling fn once per blob. Blobs that can't be fetched or parsed are
+// logged and skipped, so the closure is best effort.
+func (sh *Handler) streamReachable(roots []blob.Ref, fn func(ReachableItem) error) error {
+	seen := make(map[blob.Ref]bool)
+	queue := make([]blob.Ref, 0, len(roots))
+	queue = append(queue, roots...)
+	for len(queue) > 0 {
+		br := queue[0]
+		queue = queue[1:]
+		if !br.Valid() || seen[br] {
+			continue
+		}
+		seen[br] = true
+		size, refs, err := sh.outgoingRefs(br)
+		if err != nil {
+			log.Printf("search: reachable walk skipping %v: %v", br, err)
+			continue
+		}
+		if err := fn(ReachableItem{BlobRef: br, Size: size}); err != nil {
+			return err
+		}
+		queue = append(queue, refs...)
+	}
+	return nil
+}
+
+// outgoingRefs returns the size of the blob br and the blobs it
+// references: claims and their targets for permanodes (only claims
+// signed by sh.owners), parts and members for schema blobs, nothing
+// for leaf blobs.
+func (sh *Handler) outgoingRefs(br blob.Ref) (size uint32, refs []blob.Ref, err error) {
+	if bm, err := sh.index.GetBlobMeta(br); err == nil && bm.CamliType == "permanode" {
+		var claims []camtypes.Claim
+		for _, owner := range sh.owners {
+			claims, err = sh.index.AppendClaims(claims, br, owner, "")
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+		for _, cl := range claims {
+			refs = append(refs, cl.BlobRef)
+			if cl.Target.Valid() {
+				refs = append(refs, cl.Target)
+			}
+			if v, ok := blob.Parse(cl.Value); ok {
+				refs = append(refs, v)
+			}
+		}
+		return bm.Size, refs, nil
+	}
+	rc, fsize, err := sh.fetcher.Fetch(br)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rc.Close()
+	b, err := schema.BlobFromReader(br, rc)
+	if err != nil || b.Type() == "" {
+		// Not a schema blob; a leaf chunk.
+		return fsize, nil, nil
+	}
+	for _, part := range b.ByteParts() {
+		if part.BlobRef.Valid() {
+			refs = append(refs, part.BlobRef)
+		}
+		if part.BytesRef.Valid() {
+			refs = append(refs, part.BytesRef)
+		}
+	}
+	refs = append(refs, b.StaticSetMembers()...)
+	if entries, ok := b.DirectoryEntries(); ok {
+		refs = append(refs, entries)
+	}
+	return fsize, refs, nil
+}
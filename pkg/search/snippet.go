@@ -0,0 +1,418 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package search
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/types/camtypes"
+)
+
+const (
+	// maxSnippetsPerResult caps how many snippets are returned for a
+	// single search result.
+	maxSnippetsPerResult = 3
+
+	// snippetContext is roughly how many bytes of context (extended
+	// to rune boundaries) are kept on either side of a match within
+	// a snippet's fragment.
+	snippetContext = 40
+
+	// maxSnippetSourceBytes bounds how much text is read per result
+	// when generating snippets, over all of the result's attribute
+	// values and file contents combined.
+	maxSnippetSourceBytes = 64 << 10
+)
+
+// A Snippet is a fragment of text from a search result, giving context
+// around the query terms that matched it. Snippets are returned by the
+// query handler when SearchQuery.Snippets is set.
+type Snippet struct {
+	// Fragment is the text fragment. It always begins and ends on
+	// rune boundaries.
+	Fragment string `json:"fragment"`
+
+	// Offset is the byte offset of Fragment within the text it was
+	// drawn from.
+	Offset int `json:"offset"`
+
+	// Attr names the permanode attribute Fragment was drawn from.
+	// It is "fileName" for a file's name and empty for file
+	// contents.
+	Attr string `json:"attr,omitempty"`
+
+	// Matches are the matched terms within Fragment, in order.
+	Matches []SnippetMatch `json:"matches"`
+}
+
+// A SnippetMatch marks one matched query term within a Snippet's
+// fragment.
+type SnippetMatch struct {
+	// Term is the query term that matched.
+	Term string `json:"term"`
+
+	// Start and End are the byte offsets of the matched text within
+	// the snippet's Fragment.
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// A snippetTerm is one string a constraint requires, remembered so
+// matching text can be highlighted in the results.
+type snippetTerm struct {
+	term            string
+	attr            string // permanode attribute the constraint applied to, if any
+	caseInsensitive bool
+}
+
+func appendStringConstraintTerms(dst []snippetTerm, sc *StringConstraint, attr string) []snippetTerm {
+	if sc == nil {
+		return dst
+	}
+	for _, v := range []string{sc.Equals, sc.Contains, sc.HasPrefix, sc.HasSuffix} {
+		if v != "" {
+			dst = append(dst, snippetTerm{term: v, attr: attr, caseInsensitive: sc.CaseInsensitive})
+		}
+	}
+	return dst
+}
+
+// appendSnippetTerms appends to dst the string terms that c requires of
+// matching blobs. Terms under a "not" are excluded text and are not
+// collected.
+func (c *Constraint) appendSnippetTerms(dst []snippetTerm) []snippetTerm {
+	if c == nil {
+		return dst
+	}
+	if lc := c.Logical; lc != nil {
+		if lc.Op == "not" {
+			return dst
+		}
+		dst = lc.A.appendSnippetTerms(dst)
+		dst = lc.B.appendSnippetTerms(dst)
+	}
+	if pc := c.Permanode; pc != nil {
+		if pc.Value != "" {
+			dst = append(dst, snippetTerm{term: pc.Value, attr: pc.Attr})
+		}
+		dst = appendStringConstraintTerms(dst, pc.ValueMatches, pc.Attr)
+	}
+	if fc := c.File; fc != nil {
+		dst = appendStringConstraintTerms(dst, fc.FileName, "fileName")
+		if fc.MediaTag != nil {
+			dst = appendStringConstraintTerms(dst, fc.MediaTag.String, "")
+		}
+	}
+	return dst
+}
+
+// A snippetSource is one piece of text snippets may be drawn from.
+type snippetSource struct {
+	attr string // as in Snippet.Attr
+	text string
+}
+
+// populateSnippets fills in the Snippets field of the result blobs from
+// the planned query's string terms. The corpus, if any, is locked for
+// reads.
+func (s *search) populateSnippets() {
+	terms := s.q.Constraint.appendSnippetTerms(nil)
+	if len(terms) == 0 {
+		return
+	}
+	for _, srb := range s.res.Blobs {
+		bm, err := s.blobMeta(srb.Blob)
+		if err != nil {
+			continue
+		}
+		for _, src := range s.snippetSources(srb.Blob, bm, terms) {
+			srb.Snippets = appendSnippets(srb.Snippets, src, terms, maxSnippetsPerResult-len(srb.Snippets))
+			if len(srb.Snippets) >= maxSnippetsPerResult {
+				break
+			}
+		}
+	}
+}
+
+// snippetSources returns the texts for br that snippets may be drawn
+// from: the values of the permanode attributes the query constrained,
+// or a matched file's name and the beginning of its contents. At most
+// maxSnippetSourceBytes of text is returned in total.
+func (s *search) snippetSources(br blob.Ref, bm camtypes.BlobMeta, terms []snippetTerm) (srcs []snippetSource) {
+	budget := maxSnippetSourceBytes
+	add := func(attr, text string) {
+		if text == "" || budget <= 0 {
+			return
+		}
+		if len(text) > budget {
+			text = trimPartialRune(text[:budget])
+		}
+		budget -= len(text)
+		srcs = append(srcs, snippetSource{attr: attr, text: text})
+	}
+	switch bm.CamliType {
+	case "permanode":
+		for _, attr := range snippetAttrs(terms) {
+			for _, v := range s.permanodeAttrValues(br, attr) {
+				add(attr, v)
+			}
+		}
+	case "file":
+		fi, err := s.fileInfo(br)
+		if err != nil {
+			return
+		}
+		add("fileName", fi.FileName)
+		if strings.HasPrefix(fi.MIMEType, "text/") && s.h.fetcher != nil {
+			add("", s.fileSnippetText(br, budget))
+		}
+	}
+	return
+}
+
+// snippetAttrs returns the distinct non-empty permanode attributes
+// named by terms, in first-seen order.
+func snippetAttrs(terms []snippetTerm) (attrs []string) {
+	seen := map[string]bool{}
+	for _, t := range terms {
+		if t.attr == "" || t.attr == "fileName" || seen[t.attr] {
+			continue
+		}
+		seen[t.attr] = true
+		attrs = append(attrs, t.attr)
+	}
+	return
+}
+
+// permanodeAttrValues returns the current values of attr on the
+// permanode br. The corpus, if any, is locked for reads.
+func (s *search) permanodeAttrValues(br blob.Ref, attr string) []string {
+	if c := s.h.corpus; c != nil {
+		s.ss = c.AppendPermanodeAttrValuesLocked(s.ss[:0], br, attr, time.Time{}, s.h.owner)
+		return s.ss
+	}
+	dr, err := s.h.Describe(&DescribeRequest{BlobRef: br})
+	if err != nil {
+		return nil
+	}
+	db := dr.Meta[br.String()]
+	if db == nil || db.Permanode == nil {
+		return nil
+	}
+	return db.Permanode.Attr[attr]
+}
+
+// fileSnippetText reads up to max bytes of the file br's contents,
+// trimming any trailing partial rune.
+func (s *search) fileSnippetText(br blob.Ref, max int) string {
+	fr, err := schema.NewFileReader(s.h.fetcher, br)
+	if err != nil {
+		return ""
+	}
+	defer fr.Close()
+	buf := make([]byte, max)
+	n, _ := io.ReadFull(fr, buf)
+	return trimPartialRune(string(buf[:n]))
+}
+
+// trimPartialRune removes a trailing incomplete UTF-8 sequence from s,
+// as left by truncating text at an arbitrary byte offset.
+func trimPartialRune(s string) string {
+	if len(s) == 0 || s[len(s)-1] < utf8.RuneSelf {
+		return s
+	}
+	start := len(s) - 1
+	for start > 0 && len(s)-start < utf8.UTFMax && !utf8.RuneStart(s[start]) {
+		start--
+	}
+	if r, size := utf8.DecodeRuneInString(s[start:]); r == utf8.RuneError && size <= 1 {
+		return s[:start]
+	}
+	return s
+}
+
+// a matchSpan is one occurrence of a term in a source text, in byte
+// offsets of that text.
+type matchSpan struct {
+	start, end int
+	term       string
+}
+
+// appendSnippets appends to dst up to limit snippets built from the
+// occurrences of terms in src's text. Matches whose context windows
+// touch are merged into a single fragment.
+func appendSnippets(dst []*Snippet, src snippetSource, terms []snippetTerm, limit int) []*Snippet {
+	if limit <= 0 {
+		return dst
+	}
+	var spans []matchSpan
+	for _, t := range terms {
+		spans = appendTermSpans(spans, src.text, t)
+	}
+	if len(spans) == 0 {
+		return dst
+	}
+	sort.Sort(bySpanStart(spans))
+
+	text := src.text
+	var sn *Snippet
+	fragStart, fragEnd := 0, 0
+	for _, sp := range spans {
+		if sn != nil && runeStart(text, sp.start-snippetContext) <= fragEnd {
+			// Extend the current fragment over this match.
+			fragEnd = runeEnd(text, min(len(text), sp.end+snippetContext))
+		} else {
+			if sn != nil {
+				finishSnippet(sn, text, fragStart, fragEnd)
+			}
+			if len(dst) == limit {
+				return dst
+			}
+			sn = &Snippet{Attr: src.attr}
+			dst = append(dst, sn)
+			fragStart = runeStart(text, max(0, sp.start-snippetContext))
+			fragEnd = runeEnd(text, min(len(text), sp.end+snippetContext))
+			sn.Offset = fragStart
+		}
+		m := SnippetMatch{
+			Term:  sp.term,
+			Start: sp.start - fragStart,
+			End:   sp.end - fragStart,
+		}
+		if n := len(sn.Matches); n > 0 && sn.Matches[n-1] == m {
+			// The same span, required by more than one constraint.
+			continue
+		}
+		sn.Matches = append(sn.Matches, m)
+	}
+	finishSnippet(sn, text, fragStart, fragEnd)
+	return dst
+}
+
+func finishSnippet(sn *Snippet, text string, start, end int) {
+	sn.Fragment = text[start:end]
+}
+
+// appendTermSpans appends the occurrences of t in text to dst.
+func appendTermSpans(dst []matchSpan, text string, t snippetTerm) []matchSpan {
+	for i := 0; i < len(text); {
+		var off, n int
+		if t.caseInsensitive {
+			off, n = foldIndex(text, t.term, i)
+		} else {
+			rel := strings.Index(text[i:], t.term)
+			if rel >= 0 {
+				off, n = i+rel, len(t.term)
+			} else {
+				off = -1
+			}
+		}
+		if off < 0 {
+			break
+		}
+		dst = append(dst, matchSpan{start: off, end: off + n, term: t.term})
+		i = off + n
+	}
+	return dst
+}
+
+// foldIndex is like strings.Index but case-insensitive under Unicode
+// simple folding. It returns the byte offset in text of the first
+// occurrence of term at or after start, and the byte length of the
+// matched text, or (-1, 0).
+func foldIndex(text, term string, start int) (off, n int) {
+	if term == "" {
+		return -1, 0
+	}
+	for i := start; i < len(text); {
+		if n, ok := foldMatchAt(text[i:], term); ok {
+			return i, n
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+	}
+	return -1, 0
+}
+
+// foldMatchAt reports whether text begins with term under simple case
+// folding, and if so how many bytes of text the match spans.
+func foldMatchAt(text, term string) (n int, ok bool) {
+	for _, tr := range term {
+		r, size := utf8.DecodeRuneInString(text[n:])
+		if size == 0 {
+			return 0, false
+		}
+		if r != tr && unicode.SimpleFold(r) != tr &&
+			unicode.ToLower(r) != unicode.ToLower(tr) {
+			return 0, false
+		}
+		n += size
+	}
+	return n, true
+}
+
+// runeStart returns the closest rune start offset at or before i in s,
+// treating a negative i as 0.
+func runeStart(s string, i int) int {
+	if i < 0 {
+		return 0
+	}
+	for i > 0 && i < len(s) && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return i
+}
+
+// runeEnd returns the closest rune boundary offset at or after i in s.
+func runeEnd(s string, i int) int {
+	for i < len(s) && !utf8.RuneStart(s[i]) {
+		i++
+	}
+	return i
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+type bySpanStart []matchSpan
+
+func (s bySpanStart) Len() int      { return len(s) }
+func (s bySpanStart) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s bySpanStart) Less(i, j int) bool {
+	if s[i].start != s[j].start {
+		return s[i].start < s[j].start
+	}
+	return s[i].end < s[j].end
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package search
+
+import (
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestAppendSnippets(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		terms []snippetTerm
+		want  []*Snippet
+	}{
+		{
+			name:  "no match",
+			text:  "nothing to see here",
+			terms: []snippetTerm{{term: "hawaii"}},
+		},
+		{
+			name:  "single match with context",
+			text:  "short note",
+			terms: []snippetTerm{{term: "note"}},
+			want: []*Snippet{
+				{
+					Fragment: "short note",
+					Offset:   0,
+					Matches:  []SnippetMatch{{Term: "note", Start: 6, End: 10}},
+				},
+			},
+		},
+		{
+			name:  "overlapping matches merge into one fragment",
+			text:  "aloha from hawaii, hawaii is sunny",
+			terms: []snippetTerm{{term: "hawaii"}, {term: "aloha"}},
+			want: []*Snippet{
+				{
+					Fragment: "aloha from hawaii, hawaii is sunny",
+					Offset:   0,
+					Matches: []SnippetMatch{
+						{Term: "aloha", Start: 0, End: 5},
+						{Term: "hawaii", Start: 11, End: 17},
+						{Term: "hawaii", Start: 19, End: 25},
+					},
+				},
+			},
+		},
+		{
+			name:  "case insensitive",
+			text:  "Aloha From HAWAII",
+			terms: []snippetTerm{{term: "hawaii", caseInsensitive: true}},
+			want: []*Snippet{
+				{
+					Fragment: "Aloha From HAWAII",
+					Offset:   0,
+					Matches:  []SnippetMatch{{Term: "hawaii", Start: 11, End: 17}},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got := appendSnippets(nil, snippetSource{text: tt.text}, tt.terms, maxSnippetsPerResult)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: appendSnippets = %#v; want %#v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSnippetRuneBoundaries(t *testing.T) {
+	// A match in the middle of multibyte text must not split the
+	// fragment mid-rune, whatever snippetContext lands on.
+	var text string
+	for len(text) < 3*snippetContext {
+		text += "héllo wörld "
+	}
+	text += "hawaii"
+	for len(text) < 6*snippetContext {
+		text += " héllo wörld"
+	}
+	sns := appendSnippets(nil, snippetSource{text: text}, []snippetTerm{{term: "hawaii"}}, 1)
+	if len(sns) != 1 {
+		t.Fatalf("got %d snippets; want 1", len(sns))
+	}
+	sn := sns[0]
+	if !utf8.ValidString(sn.Fragment) {
+		t.Errorf("fragment %q is not valid UTF-8", sn.Fragment)
+	}
+	if sn.Offset == 0 || sn.Offset+len(sn.Fragment) == len(text) {
+		t.Errorf("fragment spans the whole text; context not applied")
+	}
+	if got := sn.Fragment[sn.Matches[0].Start:sn.Matches[0].End]; got != "hawaii" {
+		t.Errorf("match offsets select %q; want %q", got, "hawaii")
+	}
+}
+
+func TestTrimPartialRune(t *testing.T) {
+	full := "wörld"
+	for cut := 1; cut <= len(full); cut++ {
+		got := trimPartialRune(full[:cut])
+		if !utf8.ValidString(got) {
+			t.Errorf("trimPartialRune(%q[:%d]) = %q; not valid UTF-8", full, cut, got)
+		}
+	}
+	if got := trimPartialRune(full); got != full {
+		t.Errorf("trimPartialRune(%q) = %q; want unchanged", full, got)
+	}
+}
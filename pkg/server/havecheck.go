@@ -0,0 +1,101 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/httputil"
+)
+
+// haveCheckMaxRefs caps how many refs one havecheck request may ask
+// about, between blobRefs and wholeRefs combined.
+const haveCheckMaxRefs = 1000
+
+// haveCheckRequest is the JSON body of a havecheck request, as POSTed
+// by the web UI's uploader before sending file contents.
+type haveCheckRequest struct {
+	// BlobRefs are client-computed blobref candidates (e.g. hashed
+	// with WebCrypto) to batch-stat against the blob root.
+	BlobRefs []blob.Ref `json:"blobRefs"`
+
+	// WholeRefs are digests of whole file contents, looked up
+	// against the index's map of known files.
+	WholeRefs []blob.Ref `json:"wholeRefs"`
+}
+
+// haveCheckResponse is the JSON response to a havecheck request.
+type haveCheckResponse struct {
+	// Have maps each already-present blobref from the request's
+	// blobRefs to its size.
+	Have map[string]uint32 `json:"have"`
+
+	// Files maps each wholeRef from the request with known file
+	// schemas to those file schema blobrefs. WholeRefs are only
+	// looked up when a search handler is configured.
+	Files map[string][]blob.Ref `json:"files,omitempty"`
+}
+
+// serveHaveCheck handles the "havecheck" camli.mode. It runs under the
+// UI handler, so it's behind the same auth as the upload helper.
+func (ui *UIHandler) serveHaveCheck(rw http.ResponseWriter, req *http.Request) {
+	if ui.root.Storage == nil {
+		httputil.ServeJSONError(rw, httputil.ServerError("No BlobRoot configured"))
+		return
+	}
+	var hcr haveCheckRequest
+	if err := json.NewDecoder(http.MaxBytesReader(rw, req.Body, 1<<20)).Decode(&hcr); err != nil {
+		httputil.BadRequestError(rw, "reading body: "+err.Error())
+		return
+	}
+	if n := len(hcr.BlobRefs) + len(hcr.WholeRefs); n > haveCheckMaxRefs {
+		httputil.BadRequestError(rw, fmt.Sprintf("too many refs: %d; max is %d", n, haveCheckMaxRefs))
+		return
+	}
+
+	res := &haveCheckResponse{Have: make(map[string]uint32)}
+	if len(hcr.BlobRefs) > 0 {
+		ch := make(chan blob.SizedRef, len(hcr.BlobRefs))
+		if err := ui.root.Storage.StatBlobs(ch, hcr.BlobRefs); err != nil {
+			httputil.ServeJSONError(rw, httputil.ServerError("stat error: "+err.Error()))
+			return
+		}
+		close(ch)
+		for sb := range ch {
+			res.Have[sb.Ref.String()] = sb.Size
+		}
+	}
+	if len(hcr.WholeRefs) > 0 {
+		if sh, ok := ui.root.SearchHandler(); ok {
+			res.Files = make(map[string][]blob.Ref)
+			for _, wr := range hcr.WholeRefs {
+				files, err := sh.Index().ExistingFileSchemas(wr)
+				if err != nil {
+					httputil.ServeJSONError(rw, httputil.ServerError("index error: "+err.Error()))
+					return
+				}
+				if len(files) > 0 {
+					res.Files[wr.String()] = files
+				}
+			}
+		}
+	}
+	httputil.ReturnJSON(rw, res)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/test"
+)
+
+func newHaveCheckUI(sto *test.Fetcher) *UIHandler {
+	return &UIHandler{
+		root: &RootHandler{
+			Storage:    sto,
+			searchInit: func() {}, // no search handler
+		},
+	}
+}
+
+func postHaveCheck(t *testing.T, ui *UIHandler, body string) *httptest.ResponseRecorder {
+	wr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/ui/?camli.mode=havecheck", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ui.serveHaveCheck(wr, req)
+	return wr
+}
+
+func TestHaveCheck(t *testing.T) {
+	sto := &test.Fetcher{}
+	present := &test.Blob{Contents: "present blob"}
+	sto.AddBlob(present)
+	absent := blob.SHA1FromString("absent blob")
+
+	ui := newHaveCheckUI(sto)
+	body, err := json.Marshal(&haveCheckRequest{
+		BlobRefs: []blob.Ref{present.BlobRef(), absent},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wr := postHaveCheck(t, ui, string(body))
+	if wr.Code != 200 {
+		t.Fatalf("status = %d; want 200; body: %s", wr.Code, wr.Body)
+	}
+	var res haveCheckResponse
+	if err := json.Unmarshal(wr.Body.Bytes(), &res); err != nil {
+		t.Fatalf("bad response %s: %v", wr.Body, err)
+	}
+	if len(res.Have) != 1 {
+		t.Fatalf("have = %v; want 1 entry", res.Have)
+	}
+	size, ok := res.Have[present.BlobRef().String()]
+	if !ok || size != uint32(len(present.Contents)) {
+		t.Errorf("have[%v] = %d, %v; want %d, true", present.BlobRef(), size, ok, len(present.Contents))
+	}
+	if _, ok := res.Have[absent.String()]; ok {
+		t.Errorf("absent blob %v reported as present", absent)
+	}
+}
+
+func TestHaveCheckLimits(t *testing.T) {
+	ui := newHaveCheckUI(&test.Fetcher{})
+
+	var refs []string
+	for i := 0; i <= haveCheckMaxRefs; i++ {
+		refs = append(refs, fmt.Sprintf("%q", blob.SHA1FromString(fmt.Sprint(i))))
+	}
+	wr := postHaveCheck(t, ui, fmt.Sprintf(`{"blobRefs":[%s]}`, strings.Join(refs, ",")))
+	if wr.Code != 400 {
+		t.Errorf("over-limit request: status = %d; want 400", wr.Code)
+	}
+
+	wr = postHaveCheck(t, ui, "{not json")
+	if wr.Code != 400 {
+		t.Errorf("bad JSON: status = %d; want 400", wr.Code)
+	}
+}
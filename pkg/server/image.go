@@ -63,10 +63,15 @@ type ImageHandler struct {
 	Cache               blobserver.Storage // optional
 	MaxWidth, MaxHeight int
 	Square              bool
+	Quality             int           // JPEG quality (1-100); 0 means the default, 90.
 	ThumbMeta           *ThumbMeta    // optional cache index for scaled images
 	ResizeSem           *syncutil.Sem // Limit peak RAM used by concurrent image thumbnail calls.
 }
 
+// defaultThumbQuality is the JPEG quality scaled images are encoded
+// at when ImageHandler.Quality is unset.
+const defaultThumbQuality = 90
+
 type subImager interface {
 	SubImage(image.Rectangle) image.Image
 }
@@ -165,10 +170,15 @@ func (ih *ImageHandler) cached(br blob.Ref) (io.ReadCloser, error) {
 	return fr, nil
 }
 
-// Key format: "scaled:" + bref + ":" + width "x" + height
-// where bref is the blobref of the unscaled image.
-func cacheKey(bref string, width int, height int) string {
-	return fmt.Sprintf("scaled:%v:%dx%d:tv%v", bref, width, height, images.ThumbnailVersion())
+// Key format: "scaled:" + bref + ":" + width "x" + height,
+// where bref is the blobref of the unscaled image, plus a ":q" +
+// quality suffix for non-default JPEG qualities.
+func cacheKey(bref string, width, height, quality int) string {
+	key := fmt.Sprintf("scaled:%v:%dx%d:tv%v", bref, width, height, images.ThumbnailVersion())
+	if quality != 0 && quality != defaultThumbQuality {
+		key += fmt.Sprintf(":q%d", quality)
+	}
+	return key
 }
 
 // ScaledCached reads the scaled version of the image in file,
@@ -177,7 +187,7 @@ func cacheKey(bref string, width int, height int) string {
 // On successful read and population of buf, the returned format is non-empty.
 // Almost all errors are not interesting. Real errors will be logged.
 func (ih *ImageHandler) scaledCached(buf *bytes.Buffer, file blob.Ref) (format string) {
-	key := cacheKey(file.String(), ih.MaxWidth, ih.MaxHeight)
+	key := cacheKey(file.String(), ih.MaxWidth, ih.MaxHeight, ih.Quality)
 	br, err := ih.ThumbMeta.Get(key)
 	if err == errCacheMiss {
 		return
@@ -281,8 +291,12 @@ func (ih *ImageHandler) scaleImage(fileRef blob.Ref) (*formatAndImage, error) {
 		format = "jpeg"
 		fallthrough
 	default:
+		quality := ih.Quality
+		if quality <= 0 {
+			quality = defaultThumbQuality
+		}
 		err = jpeg.Encode(&buf, i, &jpeg.Options{
-			Quality: 90,
+			Quality: quality,
 		})
 	}
 	if err != nil {
@@ -308,7 +322,7 @@ func (ih *ImageHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request, fil
 		return
 	}
 
-	key := cacheKey(file.String(), mw, mh)
+	key := cacheKey(file.String(), mw, mh, ih.Quality)
 	etag := blob.SHA1FromString(key).String()[5:]
 	inm := req.Header.Get("If-None-Match")
 	if inm != "" {
@@ -0,0 +1,133 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/httputil"
+	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/search"
+)
+
+func wantsInPicture(req *http.Request) bool {
+	return req.Method == "POST" && camliMode(req) == "inpicture"
+}
+
+// serveInPicture handles the "inpicture" camli.mode POST requests from
+// the UI, tagging a person in a photo in one call. It adds an
+// inPicture attribute with the person's permanode to the photo
+// permanode, and, if a bounding box is given, sets the corresponding
+// rect attribute with the coordinates normalized to [0,1]. Both claims
+// are signed server-side with the UI's configured jsonsign handler.
+//
+// Parameters:
+//
+//	photo: blobref of the photo permanode (required)
+//	person: blobref of the person permanode (required)
+//	x, y, w, h: normalized bounding box of the person in the
+//	  photo, all in [0,1] (optional, but all or none)
+func (ui *UIHandler) serveInPicture(rw http.ResponseWriter, req *http.Request) {
+	if ui.sigh == nil {
+		httputil.ServeJSONError(rw, fmt.Errorf("No signing handler configured for the UI handler"))
+		return
+	}
+	if ui.root.Storage == nil {
+		httputil.ServeJSONError(rw, fmt.Errorf("No root storage configured for the UI handler"))
+		return
+	}
+	photo, ok := blob.Parse(req.FormValue("photo"))
+	if !ok {
+		httputil.BadRequestError(rw, "Missing or invalid photo blobref")
+		return
+	}
+	person, ok := blob.Parse(req.FormValue("person"))
+	if !ok {
+		httputil.BadRequestError(rw, "Missing or invalid person blobref")
+		return
+	}
+	rect, haveRect, err := normalizedRect(req)
+	if err != nil {
+		httputil.BadRequestError(rw, err.Error())
+		return
+	}
+
+	claims := []*schema.Builder{
+		schema.NewAddAttributeClaim(photo, search.PersonAttr, person.String()),
+	}
+	if haveRect {
+		claims = append(claims,
+			schema.NewSetAttributeClaim(photo, search.PersonRectAttr(person), rect))
+	}
+	var refs []string
+	for _, bb := range claims {
+		signed, err := ui.sigh.Sign(bb)
+		if err != nil {
+			httputil.ServeJSONError(rw, fmt.Errorf("Signing error: %v", err))
+			return
+		}
+		sb, err := blobserver.ReceiveString(ui.root.Storage, signed)
+		if err != nil {
+			httputil.ServeJSONError(rw, fmt.Errorf("Receive error: %v", err))
+			return
+		}
+		refs = append(refs, sb.Ref.String())
+	}
+	ret := map[string]interface{}{
+		"photo":  photo.String(),
+		"person": person.String(),
+		"claims": refs,
+	}
+	if haveRect {
+		ret["rect"] = rect
+	}
+	httputil.ReturnJSON(rw, ret)
+}
+
+// normalizedRect parses the optional x, y, w, h form values of req. It
+// reports whether a bounding box was provided, after checking that all
+// four values are present and within [0,1].
+func normalizedRect(req *http.Request) (rect string, ok bool, err error) {
+	vs := []string{req.FormValue("x"), req.FormValue("y"), req.FormValue("w"), req.FormValue("h")}
+	n := 0
+	for _, v := range vs {
+		if v != "" {
+			n++
+		}
+	}
+	if n == 0 {
+		return "", false, nil
+	}
+	if n != len(vs) {
+		return "", false, fmt.Errorf("x, y, w, h must be provided together")
+	}
+	for i, v := range vs {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return "", false, fmt.Errorf("invalid bounding box value %q: %v", v, err)
+		}
+		if f < 0 || f > 1 {
+			return "", false, fmt.Errorf("bounding box value %q out of range [0,1]", v)
+		}
+		vs[i] = strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return vs[0] + "," + vs[1] + "," + vs[2] + "," + vs[3], true, nil
+}
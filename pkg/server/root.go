@@ -31,6 +31,7 @@ import (
 	"camlistore.org/pkg/buildinfo"
 	"camlistore.org/pkg/images"
 	"camlistore.org/pkg/jsonconfig"
+	"camlistore.org/pkg/maintenance"
 	"camlistore.org/pkg/osutil"
 	"camlistore.org/pkg/search"
 )
@@ -180,13 +181,14 @@ func (b byFromTo) Less(i, j int) bool {
 
 func (rh *RootHandler) serveDiscovery(rw http.ResponseWriter, req *http.Request) {
 	m := map[string]interface{}{
-		"blobRoot":     rh.BlobRoot,
-		"searchRoot":   rh.SearchRoot,
-		"ownerName":    rh.OwnerName,
-		"username":     rh.Username,
-		"statusRoot":   rh.statusRoot,
-		"wsAuthToken":  auth.ProcessRandom(),
-		"thumbVersion": images.ThumbnailVersion(),
+		"blobRoot":        rh.BlobRoot,
+		"searchRoot":      rh.SearchRoot,
+		"ownerName":       rh.OwnerName,
+		"username":        rh.Username,
+		"statusRoot":      rh.statusRoot,
+		"wsAuthToken":     auth.ProcessRandom(),
+		"thumbVersion":    images.ThumbnailVersion(),
+		"resumableUpload": true,
 	}
 	if gener, ok := rh.Storage.(blobserver.Generationer); ok {
 		initTime, gen, err := gener.StorageGeneration()
@@ -199,6 +201,10 @@ func (rh *RootHandler) serveDiscovery(rw http.ResponseWriter, req *http.Request)
 	} else {
 		log.Printf("Storage type %T is not a blobserver.Generationer; not sending storageGeneration", rh.Storage)
 	}
+	if maintenance.Enabled() {
+		m["readOnly"] = true
+		m["maintenanceMessage"] = maintenance.Message()
+	}
 	if rh.ui != nil {
 		rh.ui.populateDiscoveryMap(m)
 	}
@@ -23,13 +23,17 @@ import (
 	"log"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"camlistore.org/pkg/blobserver"
 	"camlistore.org/pkg/buildinfo"
+	"camlistore.org/pkg/diskcheck"
 	"camlistore.org/pkg/httputil"
 	"camlistore.org/pkg/index"
+	"camlistore.org/pkg/maintenance"
 	"camlistore.org/pkg/jsonconfig"
+	"camlistore.org/pkg/serverinit"
 )
 
 // StatusHandler publishes server status information.
@@ -43,9 +47,15 @@ func init() {
 }
 
 func newStatusFromConfig(ld blobserver.Loader, conf jsonconfig.Obj) (h http.Handler, err error) {
+	diskCheckDirs := conf.OptionalList("diskCheckDirs")
+	diskFreeWarn := conf.OptionalInt("diskFreeWarn", 0)
+	diskFreeRefuse := conf.OptionalInt("diskFreeRefuse", 0)
 	if err := conf.Validate(); err != nil {
 		return nil, err
 	}
+	if len(diskCheckDirs) > 0 {
+		diskcheck.Monitor(diskCheckDirs, diskFreeWarn, diskFreeRefuse)
+	}
 	return &StatusHandler{
 		prefix:        ld.MyPrefix(),
 		handlerFinder: ld,
@@ -54,6 +64,18 @@ func newStatusFromConfig(ld blobserver.Loader, conf jsonconfig.Obj) (h http.Hand
 
 func (sh *StatusHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	suffix := httputil.PathSuffix(req)
+	if req.Method == "POST" && suffix == "reset-backend-health" {
+		sh.serveResetBackendHealth(rw, req)
+		return
+	}
+	if req.Method == "POST" && suffix == "run-task" {
+		sh.serveRunTask(rw, req)
+		return
+	}
+	if req.Method == "POST" && suffix == "maintenance" {
+		sh.serveMaintenance(rw, req)
+		return
+	}
 	if !httputil.IsGet(req) {
 		http.Error(rw, "Illegal status method.", http.StatusMethodNotAllowed)
 		return
@@ -61,6 +83,8 @@ func (sh *StatusHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	switch suffix {
 	case "status.json":
 		sh.serveStatusJSON(rw, req)
+	case "health.json":
+		sh.serveHealthJSON(rw, req)
 	case "":
 		sh.serveStatusHTML(rw, req)
 	default:
@@ -69,10 +93,26 @@ func (sh *StatusHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 }
 
 type status struct {
-	Version    string                   `json:"version"`
-	Error      string                   `json:"error,omitempty"`
-	Sync       map[string]syncStatus    `json:"sync"`
-	Storage    map[string]storageStatus `json:"storage"`
+	Version string                   `json:"version"`
+	Error   string                   `json:"error,omitempty"`
+	Sync    map[string]syncStatus    `json:"sync"`
+	Storage map[string]storageStatus `json:"storage"`
+	Disk    []diskcheck.Status       `json:"disk,omitempty"`
+	// Backends is the tracked health of the storage backends
+	// behind the replica and proxycache wrappers, if any.
+	Backends []blobserver.BackendHealth `json:"backendHealth,omitempty"`
+	// Panics lists the handler prefixes that have crashed serving
+	// a request, with repeatedly crashing ones marked degraded.
+	Panics []httputil.PanicStatus `json:"handlerPanics,omitempty"`
+	// Tasks is the state of the periodic tasks registered with the
+	// server's scheduler.
+	Tasks []serverinit.TaskStatus `json:"scheduledTasks,omitempty"`
+	// ReadOnly reports whether the server is in read-only
+	// maintenance mode, with MaintenanceMessage the explanation
+	// sent with refused writes.
+	ReadOnly           bool   `json:"readOnly,omitempty"`
+	MaintenanceMessage string `json:"maintenanceMessage,omitempty"`
+
 	rootPrefix string
 }
 
@@ -127,13 +167,24 @@ func (sh *StatusHandler) currentStatus() *status {
 		}
 		h := handlers[pfx]
 		_, isIndex := h.(*index.Index)
-		res.Storage[pfx] = storageStatus{
+		ss := storageStatus{
 			Type:    strings.TrimPrefix(typ, "storage-"),
 			Primary: pfx == rh.BlobRoot,
 			IsIndex: isIndex,
 		}
+		if sr, ok := h.(blobserver.StorageStatusReporter); ok {
+			ss.ImplStatus = sr.StorageStatus()
+		}
+		res.Storage[pfx] = ss
 	}
 
+	res.Disk = diskcheck.Statuses()
+	res.Backends = blobserver.BackendHealths()
+	res.Panics = httputil.PanicStatuses()
+	res.Tasks = serverinit.TaskStatuses()
+	res.ReadOnly = maintenance.Enabled()
+	res.MaintenanceMessage = maintenance.Message()
+
 	return res
 }
 
@@ -141,6 +192,70 @@ func (sh *StatusHandler) serveStatusJSON(rw http.ResponseWriter, req *http.Reque
 	httputil.ReturnJSON(rw, sh.currentStatus())
 }
 
+// health describes whether the server is accepting writes, for
+// monitoring tools. It's a small subset of status.json.
+type health struct {
+	Writeable bool                       `json:"writeable"`
+	Error     string                     `json:"error,omitempty"`
+	Disk      []diskcheck.Status         `json:"disk,omitempty"`
+	Backends  []blobserver.BackendHealth `json:"backendHealth,omitempty"`
+}
+
+func (sh *StatusHandler) serveHealthJSON(rw http.ResponseWriter, req *http.Request) {
+	h := health{
+		Writeable: true,
+		Disk:      diskcheck.Statuses(),
+		Backends:  blobserver.BackendHealths(),
+	}
+	if err := maintenance.CanWrite(); err != nil {
+		h.Writeable = false
+		h.Error = err.Error()
+	} else if err := diskcheck.CanWrite(); err != nil {
+		h.Writeable = false
+		h.Error = err.Error()
+	}
+	httputil.ReturnJSON(rw, h)
+}
+
+// serveResetBackendHealth closes the circuit breaker of the storage
+// backend named by the "prefix" form value, or of all backends if
+// empty, and returns the new state.
+func (sh *StatusHandler) serveResetBackendHealth(rw http.ResponseWriter, req *http.Request) {
+	blobserver.ResetBackendHealth(req.FormValue("prefix"))
+	httputil.ReturnJSON(rw, blobserver.BackendHealths())
+}
+
+// serveRunTask requests an immediate run of the scheduled task named
+// by the "name" form value, for the "Run now" buttons on the status
+// page.
+func (sh *StatusHandler) serveRunTask(rw http.ResponseWriter, req *http.Request) {
+	if err := serverinit.RunTaskNow(req.FormValue("name")); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(rw, req, sh.prefix, http.StatusFound)
+}
+
+// serveMaintenance toggles read-only maintenance mode: the "on" form
+// value is a boolean, and "msg" optionally replaces the default
+// explanation sent with refused writes. The new state is returned as
+// JSON.
+func (sh *StatusHandler) serveMaintenance(rw http.ResponseWriter, req *http.Request) {
+	on, err := strconv.ParseBool(req.FormValue("on"))
+	if err != nil {
+		http.Error(rw, `invalid "on" value; want a boolean`, http.StatusBadRequest)
+		return
+	}
+	maintenance.Set(on, req.FormValue("msg"))
+	httputil.ReturnJSON(rw, &struct {
+		ReadOnly           bool   `json:"readOnly"`
+		MaintenanceMessage string `json:"maintenanceMessage,omitempty"`
+	}{
+		ReadOnly:           maintenance.Enabled(),
+		MaintenanceMessage: maintenance.Message(),
+	})
+}
+
 var quotedPrefix = regexp.MustCompile(`[;"]/(\S+?/)[&"]`)
 
 func (sh *StatusHandler) serveStatusHTML(rw http.ResponseWriter, req *http.Request) {
@@ -151,7 +266,29 @@ func (sh *StatusHandler) serveStatusHTML(rw http.ResponseWriter, req *http.Reque
 	f("<html><head><title>Status</title></head>")
 	f("<body><h2>Status</h2>")
 	f("<p>As JSON: <a href='status.json'>status.json</a>; and the <a href='%s?camli.mode=config'>discovery JSON</a>.</p>", st.rootPrefix)
+	if st.ReadOnly {
+		f("<p><b>Read-only maintenance mode</b>: %s", html.EscapeString(st.MaintenanceMessage))
+		f(" <form style='display:inline' method='POST' action='maintenance'><input type='hidden' name='on' value='false'><input type='submit' value='Leave maintenance mode'></form></p>")
+	} else {
+		f("<p><form style='display:inline' method='POST' action='maintenance'><input type='hidden' name='on' value='true'><input type='submit' value='Enter read-only maintenance mode'></form></p>")
+	}
 	f("<p>TODO: not yet integrated into status:</p><ul><li><a href='/importer/'>Importers</a></li></ul>")
+	if len(st.Tasks) > 0 {
+		f("<h2>Scheduled tasks</h2><ul>")
+		for _, ts := range st.Tasks {
+			f("<li>%s (every %s)", html.EscapeString(ts.Name), ts.Interval)
+			if ts.Running {
+				f(", running now")
+			} else if ts.LastRun != "" {
+				f(", last ran %s in %s", ts.LastRun, ts.LastRunTook)
+			}
+			if ts.LastError != "" {
+				f(", last error: %s", html.EscapeString(ts.LastError))
+			}
+			f(" <form style='display:inline' method='POST' action='run-task'><input type='hidden' name='name' value='%s'><input type='submit' value='Run now'></form></li>", html.EscapeString(ts.Name))
+		}
+		f("</ul>")
+	}
 	f("<p>Not yet pretty HTML UI:</p>")
 	js, err := json.MarshalIndent(st, "", "  ")
 	if err != nil {
@@ -35,10 +35,12 @@ import (
 	"camlistore.org/pkg/auth"
 	"camlistore.org/pkg/blob"
 	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/camlog"
 	"camlistore.org/pkg/constants"
 	"camlistore.org/pkg/context"
 	"camlistore.org/pkg/index"
 	"camlistore.org/pkg/jsonconfig"
+	"camlistore.org/pkg/serverinit"
 	"camlistore.org/pkg/sorted"
 	"camlistore.org/pkg/syncutil"
 	"camlistore.org/third_party/code.google.com/p/xsrftoken"
@@ -70,10 +72,8 @@ type SyncHandler struct {
 	idle             bool // if true, the handler does nothing other than providing the discovery.
 	copierPoolSize   int
 
-	// wakec wakes up the blob syncer loop when a blob is received.
-	wakec chan bool
-
-	mu             sync.Mutex // protects following
+	mu             sync.Mutex             // protects following
+	periodic       *serverinit.TaskHandle // queue-draining task; nil until registered
 	status         string
 	copying        map[blob.Ref]*copyStatus // to start time
 	needCopy       map[blob.Ref]uint32      // blobs needing to be copied. some might be in lastFail too.
@@ -87,11 +87,20 @@ type SyncHandler struct {
 	vshards        []string // validation shards. if 0, validation not running
 	vshardDone     int      // shards validated
 	vshardErrs     []string
-	vmissing       int64 // missing blobs found during validat
-	vdestCount     int   // number of blobs seen on dest during validate
-	vdestBytes     int64 // number of blob bytes seen on dest during validate
-	vsrcCount      int   // number of blobs seen on src during validate
-	vsrcBytes      int64 // number of blob bytes seen on src during validate
+	vmissing       int64    // missing blobs found during validat
+	vdestCount     int      // number of blobs seen on dest during validate
+	vdestBytes     int64    // number of blob bytes seen on dest during validate
+	vsrcCount      int      // number of blobs seen on src during validate
+	vsrcBytes      int64    // number of blob bytes seen on src during validate
+	vverified      int      // dest blobs whose contents were spot-verified
+	vrepaired      int      // corrupt dest blobs overwritten with a good source copy
+	vunrepairable  []string // descriptions of corrupt dest blobs that couldn't be repaired
+
+	// verifyDest, if set, makes the validation pass also re-read
+	// destination blob contents (sampled, and always on a
+	// size mismatch) and check that they hash to their ref,
+	// repairing corrupt ones from the source when possible.
+	verifyDest bool
 }
 
 var (
@@ -103,8 +112,16 @@ func (sh *SyncHandler) String() string {
 	return fmt.Sprintf("[SyncHandler %v -> %v]", sh.fromName, sh.toName)
 }
 
+var syncLog = camlog.New("sync")
+
 func (sh *SyncHandler) logf(format string, args ...interface{}) {
-	log.Printf(sh.String()+" "+format, args...)
+	syncLog.Printf(sh.String()+" "+format, args...)
+}
+
+// debugf is like logf, but only logs when the "sync" subsystem is at
+// debug level.
+func (sh *SyncHandler) debugf(format string, args ...interface{}) {
+	syncLog.Debugf(sh.String()+" "+format, args...)
 }
 
 func init() {
@@ -125,6 +142,7 @@ func newSyncFromConfig(ld blobserver.Loader, conf jsonconfig.Obj) (http.Handler,
 		queueConf      = conf.OptionalObject("queue")
 		copierPoolSize = conf.OptionalInt("copierPoolSize", 5)
 		validate       = conf.OptionalBool("validateOnStart", validateOnStartDefault)
+		verifyDest     = conf.OptionalBool("verifyDestContents", false)
 	)
 	if err := conf.Validate(); err != nil {
 		return nil, err
@@ -158,6 +176,7 @@ func newSyncFromConfig(ld blobserver.Loader, conf jsonconfig.Obj) (http.Handler,
 	sh := newSyncHandler(from, to, fromBs, toBs, q)
 	sh.toIndex = isToIndex
 	sh.copierPoolSize = copierPoolSize
+	sh.verifyDest = verifyDest
 	if err := sh.readQueueToMemory(); err != nil {
 		return nil, fmt.Errorf("Error reading sync queue to memory: %v", err)
 	}
@@ -177,7 +196,7 @@ func newSyncFromConfig(ld blobserver.Loader, conf jsonconfig.Obj) (http.Handler,
 			n := sh.runSync("full", blobserverEnumerator(context.TODO(), fromBs))
 			sh.logf("Full sync copied %d blobs", n)
 			didFullSync <- true
-			sh.syncLoop()
+			sh.registerPeriodicSync()
 		}()
 		if blockFullSync {
 			sh.logf("Blocking startup, waiting for full sync from %q to %q", from, to)
@@ -185,7 +204,7 @@ func newSyncFromConfig(ld blobserver.Loader, conf jsonconfig.Obj) (http.Handler,
 			sh.logf("Full sync complete.")
 		}
 	} else {
-		go sh.syncLoop()
+		sh.registerPeriodicSync()
 	}
 
 	if validate {
@@ -219,7 +238,6 @@ func newSyncHandler(fromName, toName string,
 		fromName:       fromName,
 		toName:         toName,
 		queue:          queue,
-		wakec:          make(chan bool),
 		status:         "not started",
 		needCopy:       make(map[blob.Ref]uint32),
 		lastFail:       make(map[blob.Ref]failDetail),
@@ -366,6 +384,13 @@ func (sh *SyncHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		f("<li>Dest blobs seen: %d</li>", sh.vdestCount)
 		f("<li>Dest bytes seen: %d</li>", sh.vdestBytes)
 		f("<li>Blobs found missing &amp; enqueued: %d</li>", sh.vmissing)
+		if sh.verifyDest {
+			f("<li>Dest blob contents verified: %d</li>", sh.vverified)
+			f("<li>Corrupt dest blobs repaired: %d</li>", sh.vrepaired)
+			for _, s := range sh.vunrepairable {
+				f("<li>Unrepairable corrupt dest blob: %s</li>", html.EscapeString(s))
+			}
+		}
 		if len(sh.vshardErrs) > 0 {
 			f("<li>Validation errors: %s</li>", sh.vshardErrs)
 		}
@@ -523,21 +548,31 @@ FeedWork:
 	return nCopied
 }
 
-func (sh *SyncHandler) syncLoop() {
-	for {
-		t0 := time.Now()
-
-		for sh.runSync(sh.fromName, sh.enumeratePendingBlobs) > 0 {
-			// Loop, before sleeping.
-		}
-		sh.setStatusf("Sleeping briefly before next long poll.")
+// registerPeriodicSync registers the queue-draining pass with the
+// server's task scheduler, which owns the timer, shows the task on the
+// status page, and cancels it on shutdown.
+func (sh *SyncHandler) registerPeriodicSync() {
+	h := serverinit.RegisterTask(serverinit.Task{
+		Name:     fmt.Sprintf("sync %s to %s", sh.fromName, sh.toName),
+		Interval: queueSyncInterval,
+		Run:      sh.runPeriodicSync,
+	})
+	sh.mu.Lock()
+	sh.periodic = h
+	sh.mu.Unlock()
+	h.Wake()
+}
 
-		d := queueSyncInterval - time.Since(t0)
-		select {
-		case <-time.After(d):
-		case <-sh.wakec:
+// runPeriodicSync does one scheduler pass: it drains the pending list
+// until a pass copies nothing.
+func (sh *SyncHandler) runPeriodicSync(ctx *context.Context) error {
+	for sh.runSync(sh.fromName, sh.enumeratePendingBlobs) > 0 {
+		if ctx.IsCanceled() {
+			return context.ErrCanceled
 		}
 	}
+	sh.setStatusf("Sleeping until next sync pass.")
+	return nil
 }
 
 func (sh *SyncHandler) copyWorker(res chan<- copyResult, work <-chan blob.SizedRef) {
@@ -559,6 +594,10 @@ func (sh *SyncHandler) copyBlob(sb blob.SizedRef) (err error) {
 		return fmt.Errorf("blob size %d too large; max blob size is %d", sb.Size, constants.MaxBlobSize)
 	}
 
+	if syncLog.Debugging() {
+		sh.debugf("copying %v (%d bytes)", br, sb.Size)
+	}
+
 	cs.setStatus(statusFetching)
 	rc, fromSize, err := sh.from.Fetch(br)
 	if err != nil {
@@ -617,11 +656,9 @@ func (sh *SyncHandler) addBlobToCopy(sb blob.SizedRef) bool {
 	sh.needCopy[sb.Ref] = sb.Size
 	sh.bytesRemain += int64(sb.Size)
 
-	// Non-blocking send to wake up looping goroutine if it's
-	// sleeping...
-	select {
-	case sh.wakec <- true:
-	default:
+	// Wake up the periodic sync task if it's sleeping.
+	if sh.periodic != nil {
+		sh.periodic.Wake()
 	}
 	return true
 }
@@ -718,8 +755,40 @@ func (sh *SyncHandler) validateShardPrefix(pfx string) (err error) {
 		},
 	}
 
+	var (
+		verifyMu   sync.Mutex
+		toVerify   []blob.SizedRef // sampled or size-mismatched dest blobs to spot-verify
+		verifyDone chan struct{}
+	)
+	sizeMismatch := func(blob.Ref) {}
+	if sh.verifyDest {
+		// Wrap the dest stream to sample blobs for content
+		// verification as they stream by.
+		raw := dst
+		c := make(chan blob.SizedRef, 8)
+		verifyDone = make(chan struct{})
+		go func() {
+			defer close(verifyDone)
+			defer close(c)
+			for sb := range raw {
+				if sb.Ref.Valid() && sb.Ref.Sum32()%verifySampleRate == 0 {
+					verifyMu.Lock()
+					toVerify = append(toVerify, sb)
+					verifyMu.Unlock()
+				}
+				c <- sb
+			}
+		}()
+		dst = c
+		sizeMismatch = func(br blob.Ref) {
+			verifyMu.Lock()
+			toVerify = append(toVerify, blob.SizedRef{Ref: br})
+			verifyMu.Unlock()
+		}
+	}
+
 	missingc := make(chan blob.SizedRef, 8)
-	go blobserver.ListMissingDestinationBlobs(missingc, func(blob.Ref) {}, src, dst)
+	go blobserver.ListMissingDestinationBlobs(missingc, sizeMismatch, src, dst)
 
 	var missing []blob.SizedRef
 	for sb := range missingc {
@@ -744,9 +813,107 @@ func (sh *SyncHandler) validateShardPrefix(pfx string) (err error) {
 			sh.mu.Unlock()
 		}
 	}
+
+	if sh.verifyDest {
+		// The dest stream may not have been fully consumed if the
+		// source ran out first; drain the remainder so the sampler
+		// above can finish.
+		go func() {
+			for range dst {
+			}
+		}()
+		<-verifyDone
+		verifyMu.Lock()
+		blobs := toVerify
+		verifyMu.Unlock()
+		seen := make(map[blob.Ref]bool)
+		for _, sb := range blobs {
+			if seen[sb.Ref] {
+				continue
+			}
+			seen[sb.Ref] = true
+			sh.verifyDestBlob(sb.Ref)
+		}
+	}
 	return err
 }
 
+// verifySampleRate controls which destination blobs have their contents
+// spot-verified during validation when verifyDestContents is enabled:
+// one out of every verifySampleRate, picked by a stable hash of the
+// blobref, so repeated validation runs re-check the same sample.
+const verifySampleRate = 64
+
+// verifyDestBlob re-reads the blob br from the destination and checks
+// that its contents hash to its ref. If they don't, it overwrites the
+// destination copy with a good copy from the source, for destination
+// storages that support overwrites. Blobs that are still corrupt after
+// the repair attempt are recorded as unrepairable.
+func (sh *SyncHandler) verifyDestBlob(br blob.Ref) {
+	fetcher, ok := sh.to.(blob.Fetcher)
+	if !ok {
+		// Can't read destination contents (e.g. an index); nothing to verify.
+		return
+	}
+	if sh.destBlobValid(fetcher, br) {
+		sh.mu.Lock()
+		sh.vverified++
+		sh.mu.Unlock()
+		return
+	}
+	sh.logf("corrupt blob %v on destination; repairing from source", br)
+	if err := sh.repairDestBlob(fetcher, br); err != nil {
+		sh.logf("could not repair corrupt blob %v on destination: %v", br, err)
+		sh.mu.Lock()
+		sh.vunrepairable = append(sh.vunrepairable, fmt.Sprintf("%v: %v", br, err))
+		sh.mu.Unlock()
+		return
+	}
+	sh.mu.Lock()
+	sh.vrepaired++
+	sh.mu.Unlock()
+}
+
+// destBlobValid reports whether the destination's copy of br hashes to br.
+func (sh *SyncHandler) destBlobValid(fetcher blob.Fetcher, br blob.Ref) bool {
+	rc, _, err := fetcher.Fetch(br)
+	if err != nil {
+		return false
+	}
+	defer rc.Close()
+	hash := br.Hash()
+	if _, err := io.Copy(hash, rc); err != nil {
+		return false
+	}
+	return br.HashMatches(hash)
+}
+
+// repairDestBlob overwrites the destination's copy of br with the
+// source's copy, then re-reads it to check the overwrite took.
+func (sh *SyncHandler) repairDestBlob(fetcher blob.Fetcher, br blob.Ref) error {
+	rc, size, err := sh.from.Fetch(br)
+	if err != nil {
+		return fmt.Errorf("source fetch: %v", err)
+	}
+	buf := make([]byte, size)
+	hash := br.Hash()
+	_, err = io.ReadFull(io.TeeReader(rc, hash), buf)
+	rc.Close()
+	if err != nil {
+		return fmt.Errorf("source read: %v", err)
+	}
+	if !br.HashMatches(hash) {
+		return fmt.Errorf("source copy is corrupt too; unexpected digest %x", hash.Sum(nil))
+	}
+	if _, err := sh.to.ReceiveBlob(br, bytes.NewReader(buf)); err != nil {
+		return fmt.Errorf("dest write: %v", err)
+	}
+	if !sh.destBlobValid(fetcher, br) {
+		return errors.New("still corrupt after overwrite; destination storage likely doesn't support overwrites")
+	}
+	return nil
+}
+
 var errNotPrefix = errors.New("sentinel error: hit blob into the next shard")
 
 // doDest is false for source and true for dest.
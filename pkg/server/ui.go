@@ -397,6 +397,10 @@ func wantsUploadHelper(req *http.Request) bool {
 	return req.Method == "POST" && camliMode(req) == "uploadhelper"
 }
 
+func wantsHaveCheck(req *http.Request) bool {
+	return req.Method == "POST" && camliMode(req) == "havecheck"
+}
+
 func wantsPermanode(req *http.Request) bool {
 	if httputil.IsGet(req) && blob.ValidRefString(req.FormValue("p")) {
 		// The new UI is handled by index.html.
@@ -432,6 +436,10 @@ func (ui *UIHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		ui.root.serveDiscovery(rw, req)
 	case wantsUploadHelper(req):
 		ui.serveUploadHelper(rw, req)
+	case wantsHaveCheck(req):
+		ui.serveHaveCheck(rw, req)
+	case wantsInPicture(req):
+		ui.serveInPicture(rw, req)
 	case strings.HasPrefix(suffix, "download/"):
 		ui.serveDownload(rw, req)
 	case strings.HasPrefix(suffix, "thumbnail/"):
@@ -511,6 +519,7 @@ func (ui *UIHandler) populateDiscoveryMap(m map[string]interface{}) {
 		"jsonSignRoot":    ui.JSONSignRoot,
 		"uiRoot":          ui.prefix,
 		"uploadHelper":    ui.prefix + "?camli.mode=uploadhelper", // hack; remove with better javascript
+		"haveCheck":       ui.prefix + "?camli.mode=havecheck",
 		"downloadHelper":  path.Join(ui.prefix, "download") + "/",
 		"directoryHelper": path.Join(ui.prefix, "tree") + "/",
 		"publishRoots":    pubRoots,
@@ -22,6 +22,7 @@ import (
 
 	"camlistore.org/pkg/blob"
 	"camlistore.org/pkg/httputil"
+	"camlistore.org/pkg/maintenance"
 	"camlistore.org/pkg/schema"
 )
 
@@ -41,6 +42,10 @@ func (ui *UIHandler) serveUploadHelper(rw http.ResponseWriter, req *http.Request
 		httputil.ServeJSONError(rw, httputil.ServerError("No BlobRoot configured"))
 		return
 	}
+	if maintenance.Enabled() {
+		maintenance.Refuse(rw)
+		return
+	}
 
 	mr, err := req.MultipartReader()
 	if err != nil {
@@ -16,12 +16,23 @@ limitations under the License.
 
 package serverinit
 
-var GenLowLevelConfig = genLowLevelConfig
+var (
+	GenLowLevelConfig = genLowLevelConfig
+	ApplyRole         = applyRole
+)
 
 func SetTempDirFunc(f func() string) {
 	tempDir = f
 }
 
+func SetVarDirFunc(f func() string) {
+	varDir = f
+}
+
 func SetNoMkdir(v bool) {
 	noMkdir = v
 }
+
+func SetOnGCEFunc(f func() bool) {
+	onGCE = f
+}
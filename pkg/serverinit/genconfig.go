@@ -17,22 +17,29 @@ limitations under the License.
 package serverinit
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/camlog"
+	"camlistore.org/pkg/googlestorage"
 	"camlistore.org/pkg/jsonconfig"
 	"camlistore.org/pkg/jsonsign"
 	"camlistore.org/pkg/osutil"
 	"camlistore.org/pkg/types/serverconfig"
+	"camlistore.org/pkg/webserver"
 )
 
 // various parameters derived from the high-level user config
@@ -42,20 +49,119 @@ type configPrefixesParams struct {
 	keyId            string
 	haveIndex        bool
 	haveSQLite       bool
+	haveLevelDB      bool
 	blobPath         string
-	packBlobs        bool
+	blobLayout       string // "loose", "diskpacked", or "blobpacked"; how blobs are stored under blobPath.
 	searchOwner      blob.Ref
 	shareHandlerPath string
 	flickr           string
 	picasa           string
 	memoryIndex      bool
+	memoryStorage    bool // the index itself is in memory; not to be confused with memoryIndex.
+	diskFreeWarn     int // percent free disk space under which to warn. 0 means unset.
+	diskFreeRefuse   int // percent free disk space under which to refuse uploads. 0 means unset.
 
-	indexFileDir string // if sqlite or kvfile, its directory. else "".
+	indexFileDir string // if sqlite, kvfile, or leveldb, its directory. else "".
+	indexURL     string // if non-empty, the index is on a remote server at this URL.
+
+	primaryStorage string          // explicit "primaryStorage" name from the config, or "".
+	mirrorTo       map[string]bool // explicit "mirrorTo" set; nil means all non-primary storages.
+	localCache     string          // "diskpacked" keeps /bs/ on local disk even with a remote primaryStorage.
+	cacheDir       string          // explicit "cacheDir" for the /cache/ handler, or "" for the default.
+
+	thumbCache *serverconfig.ThumbCache // explicit "thumbCache" for the UI's thumbnail meta store, or nil for the default.
+}
+
+// localCacheDir returns the directory for the local /cache/ handler:
+// the high-level "cacheDir" if set, else a "camli-cache" directory
+// under the system temp dir.
+func (p *configPrefixesParams) localCacheDir() string {
+	if p.cacheDir != "" {
+		return p.cacheDir
+	}
+	return filepath.Join(tempDir(), "camli-cache")
+}
+
+// scaledImageConfig returns the sorted.KeyValue config for the UI
+// handler's thumbnail meta store ("scaledImage"): the high-level
+// "thumbCache" if set, else a kv file named thumbmeta.kv under the
+// blob path, or under the var directory when the blobs aren't on
+// local disk.
+func (p *configPrefixesParams) scaledImageConfig() map[string]interface{} {
+	if tc := p.thumbCache; tc != nil {
+		if tc.Type == "memory" {
+			return map[string]interface{}{
+				"type": "memory",
+			}
+		}
+		return map[string]interface{}{
+			"type": tc.Type,
+			"file": tc.File,
+		}
+	}
+	dir := p.blobPath
+	if dir == "" {
+		dir = varDir()
+	}
+	return map[string]interface{}{
+		"type": "kv",
+		"file": filepath.Join(dir, "thumbmeta.kv"),
+	}
+}
+
+// storageIsPrimary reports whether the storage named name ("s3", "b2",
+// ...) should serve the blobs at /bs/. With an explicit "primaryStorage"
+// in the high-level config only that name is the primary; otherwise the
+// first configured storage to claim /bs/ wins, which is the historical,
+// call-order dependent behavior.
+func (p *configPrefixesParams) storageIsPrimary(prefixes jsonconfig.Obj, name string) bool {
+	if p.localCache != "" {
+		// /bs/ stays on local disk for staging; every remote
+		// storage, the declared primary included, is a mirror fed
+		// by its sync handler.
+		return false
+	}
+	if p.primaryStorage != "" {
+		return p.primaryStorage == name
+	}
+	_, ok := prefixes["/bs/"]
+	return !ok
+}
+
+// mirrors reports whether a sync handler replicating /bs/ to the
+// storage named name should be generated.
+func (p *configPrefixesParams) mirrors(name string) bool {
+	return p.mirrorTo == nil || p.mirrorTo[name]
+}
+
+// addMirrorSync adds the /sync-to-<name>/ handler replicating /bs/ to
+// toPrefix, with its own kv queue file named queueFile.
+func addMirrorSync(params *configPrefixesParams, prefixes jsonconfig.Obj, name, toPrefix, queueFile string) {
+	queueDir := params.blobPath
+	if queueDir == "" {
+		// The primary is a remote storage, so the sync queue
+		// can't live in the local blob directory; keep it in
+		// the Camlistore var directory instead.
+		queueDir = varDir()
+	}
+	prefixes["/sync-to-"+name+"/"] = map[string]interface{}{
+		"handler": "sync",
+		"handlerArgs": map[string]interface{}{
+			"from": "/bs/",
+			"to":   toPrefix,
+			"queue": map[string]interface{}{
+				"type": "kv",
+				"file": filepath.Join(queueDir, queueFile),
+			},
+		},
+	}
 }
 
 var (
 	tempDir = os.TempDir
-	noMkdir bool // for tests to not call os.Mkdir
+	varDir  = osutil.CamliVarDir
+	noMkdir bool                  // for tests to not call os.Mkdir
+	onGCE   = googlestorage.OnGCE // for tests to stub GCE detection
 )
 
 type tlsOpts struct {
@@ -127,76 +233,218 @@ func addUIConfig(params *configPrefixesParams,
 	if sourceRoot != "" {
 		args["sourceRoot"] = sourceRoot
 	}
-	if params.blobPath != "" {
-		args["scaledImage"] = map[string]interface{}{
-			"type": "kv",
-			"file": filepath.Join(params.blobPath, "thumbmeta.kv"),
-		}
-	}
+	args["scaledImage"] = params.scaledImageConfig()
 	prefixes[uiPrefix] = map[string]interface{}{
 		"handler":     "ui",
 		"handlerArgs": args,
 	}
 }
 
-func addMongoConfig(prefixes jsonconfig.Obj, dbname string, dbinfo string) {
-	fields := strings.Split(dbinfo, "@")
-	if len(fields) != 2 {
-		exitFailure("Malformed mongo config string. Got \"%v\", want: \"user:password@host\"", dbinfo)
+// normalizedMongoConnection returns the "mongo" config value in its
+// object form, parsing the legacy "user:password@host" string and
+// "mongodb://" connection URIs. It returns (nil, nil) if conn is unset.
+func normalizedMongoConnection(conn *serverconfig.MongoConnection) (*serverconfig.MongoConnection, error) {
+	if conn == nil || (conn.Legacy == "" && conn.User == "" && conn.Password == "" &&
+		len(conn.Hosts) == 0 && conn.AuthSource == "" && !conn.TLS) {
+		return nil, nil
 	}
-	host := fields[1]
-	fields = strings.Split(fields[0], ":")
-	if len(fields) != 2 {
-		exitFailure("Malformed mongo config string. Got \"%v\", want: \"user:password\"", fields[0])
+	if strings.HasPrefix(conn.Legacy, "mongodb://") {
+		u, err := url.Parse(conn.Legacy)
+		if err != nil {
+			return nil, fmt.Errorf(`genconfig: malformed "mongo" URI %q: %v`, conn.Legacy, err)
+		}
+		nc := &serverconfig.MongoConnection{
+			Hosts:      strings.Split(u.Host, ","),
+			AuthSource: strings.TrimPrefix(u.Path, "/"),
+		}
+		if u.User != nil {
+			nc.User = u.User.Username()
+			nc.Password, _ = u.User.Password()
+		}
+		for k, vv := range u.Query() {
+			v := vv[len(vv)-1]
+			switch k {
+			case "authSource":
+				nc.AuthSource = v
+			case "ssl", "tls":
+				b, err := strconv.ParseBool(v)
+				if err != nil {
+					return nil, fmt.Errorf(`genconfig: bad %q value %q in "mongo" URI %q`, k, v, conn.Legacy)
+				}
+				nc.TLS = b
+			default:
+				return nil, fmt.Errorf(`genconfig: unsupported option %q in "mongo" URI %q`, k, conn.Legacy)
+			}
+		}
+		return nc, nil
+	}
+	if conn.Legacy != "" {
+		fields := strings.Split(conn.Legacy, "@")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf(`genconfig: malformed "mongo" config string %q; want "user:password@host", a "mongodb://" URI, or an object`, conn.Legacy)
+		}
+		host := fields[1]
+		fields = strings.Split(fields[0], ":")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf(`genconfig: malformed "mongo" config string %q; want "user:password@host", a "mongodb://" URI, or an object`, conn.Legacy)
+		}
+		return &serverconfig.MongoConnection{
+			User:     fields[0],
+			Password: fields[1],
+			Hosts:    []string{host},
+		}, nil
+	}
+	return conn, nil
+}
+
+// addMongoConfig emits the storage-index prefix for a MongoDB-backed
+// index. conn must already have passed through
+// normalizedMongoConnection, which did all the validation, so
+// addMongoConfig cannot fail.
+func addMongoConfig(prefixes jsonconfig.Obj, dbname string, conn *serverconfig.MongoConnection) {
+	storage := map[string]interface{}{
+		"type":     "mongo",
+		"user":     conn.User,
+		"password": conn.Password,
+		"database": dbname,
+	}
+	switch len(conn.Hosts) {
+	case 0:
+		// Let the mongo driver default to localhost.
+	case 1:
+		storage["host"] = conn.Hosts[0]
+	default:
+		storage["hosts"] = conn.Hosts
+	}
+	if conn.AuthSource != "" {
+		storage["authSource"] = conn.AuthSource
+	}
+	if conn.TLS {
+		storage["tls"] = true
 	}
 	ob := map[string]interface{}{}
 	ob["enabled"] = true
 	ob["handler"] = "storage-index"
 	ob["handlerArgs"] = map[string]interface{}{
 		"blobSource": "/bs/",
-		"storage": map[string]interface{}{
-			"type":     "mongo",
-			"host":     host,
-			"user":     fields[0],
-			"password": fields[1],
-			"database": dbname,
-		},
+		"storage":    storage,
 	}
 	prefixes["/index/"] = ob
 }
 
-func addSQLConfig(rdbms string, prefixes jsonconfig.Obj, dbname string, dbinfo string) {
-	fields := strings.Split(dbinfo, "@")
-	if len(fields) != 2 {
-		exitFailure("Malformed " + rdbms + " config string. Want: \"user@host:password\"")
+// normalizedDBConnection returns conn with its legacy
+// "user@host:password" form, if any, parsed into the structured
+// fields, and validates the object form. field is the high-level
+// field name, for error messages. A nil or empty conn returns nil.
+func normalizedDBConnection(field string, conn *serverconfig.DBConnection) (*serverconfig.DBConnection, error) {
+	if conn == nil || *conn == (serverconfig.DBConnection{}) {
+		return nil, nil
+	}
+	if conn.Legacy != "" {
+		fields := strings.Split(conn.Legacy, "@")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf(`genconfig: malformed %q config string %q; want "user@host:password" or an object`, field, conn.Legacy)
+		}
+		user := fields[0]
+		fields = strings.Split(fields[1], ":")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf(`genconfig: malformed %q config string %q; want "user@host:password" or an object`, field, conn.Legacy)
+		}
+		return &serverconfig.DBConnection{
+			User:     user,
+			Host:     fields[0],
+			Password: fields[1],
+		}, nil
+	}
+	if conn.User == "" {
+		return nil, fmt.Errorf(`genconfig: %q config object requires a "user"`, field)
 	}
-	user := fields[0]
-	fields = strings.Split(fields[1], ":")
-	if len(fields) != 2 {
-		exitFailure("Malformed " + rdbms + " config string. Want: \"user@host:password\"")
+	if conn.UnixSocket != "" && (conn.Host != "" || conn.Port != 0) {
+		return nil, fmt.Errorf(`genconfig: %q config object can't combine "unixSocket" with "host" or "port"`, field)
+	}
+	if conn.SSLMode != "" {
+		if conn.TLS != nil {
+			return nil, fmt.Errorf(`genconfig: %q config object can't combine "sslMode" with "tls"`, field)
+		}
+		switch conn.SSLMode {
+		case "disable", "require", "verify-ca", "verify-full":
+		default:
+			return nil, fmt.Errorf(`genconfig: %q config object has unknown "sslMode" %q; want "disable", "require", "verify-ca", or "verify-full"`, field, conn.SSLMode)
+		}
+	}
+	return conn, nil
+}
+
+// addSQLConfig emits the storage-index prefix for an RDBMS-backed
+// index. conn must already have passed through normalizedDBConnection,
+// which did all the validation, so addSQLConfig cannot fail.
+func addSQLConfig(rdbms string, prefixes jsonconfig.Obj, dbname string, conn *serverconfig.DBConnection) {
+	storage := map[string]interface{}{
+		"type":     rdbms,
+		"user":     conn.User,
+		"password": conn.Password,
+		"database": dbname,
+	}
+	switch rdbms {
+	case "mysql":
+		host := conn.Host
+		if conn.Port != 0 {
+			host = fmt.Sprintf("%s:%d", host, conn.Port)
+		}
+		if host != "" {
+			storage["host"] = host
+		}
+		if conn.UnixSocket != "" {
+			storage["unixSocket"] = conn.UnixSocket
+		}
+		if conn.TLS != nil && *conn.TLS {
+			storage["tls"] = true
+		}
+	case "postgres":
+		if conn.Host != "" {
+			storage["host"] = conn.Host
+		}
+		if conn.Port != 0 {
+			storage["port"] = conn.Port
+		}
+		if conn.UnixSocket != "" {
+			storage["unixSocket"] = conn.UnixSocket
+		}
+		switch {
+		case conn.SSLMode != "":
+			storage["sslmode"] = conn.SSLMode
+		case conn.TLS != nil && *conn.TLS:
+			storage["sslmode"] = "require"
+		case conn.TLS != nil:
+			storage["sslmode"] = "disable"
+		}
 	}
 	ob := map[string]interface{}{}
 	ob["enabled"] = true
 	ob["handler"] = "storage-index"
 	ob["handlerArgs"] = map[string]interface{}{
 		"blobSource": "/bs/",
-		"storage": map[string]interface{}{
-			"type":     rdbms,
-			"host":     fields[0],
-			"user":     user,
-			"password": fields[1],
-			"database": dbname,
-		},
+		"storage":    storage,
 	}
 	prefixes["/index/"] = ob
 }
 
-func addPostgresConfig(prefixes jsonconfig.Obj, dbname string, dbinfo string) {
-	addSQLConfig("postgres", prefixes, dbname, dbinfo)
+func addPostgresConfig(prefixes jsonconfig.Obj, dbname string, conn *serverconfig.DBConnection) {
+	addSQLConfig("postgres", prefixes, dbname, conn)
 }
 
-func addMySQLConfig(prefixes jsonconfig.Obj, dbname string, dbinfo string) {
-	addSQLConfig("mysql", prefixes, dbname, dbinfo)
+func addMySQLConfig(prefixes jsonconfig.Obj, dbname string, conn *serverconfig.DBConnection) {
+	addSQLConfig("mysql", prefixes, dbname, conn)
+}
+
+// indexDBName returns the database name for one indexer: the
+// per-indexer override ("mysqlDBName", "postgresDBName",
+// "mongoDBName") if set, otherwise the shared "dbname".
+func indexDBName(override, dbname string) string {
+	if override != "" {
+		return override
+	}
+	return dbname
 }
 
 func addSQLiteConfig(prefixes jsonconfig.Obj, file string) {
@@ -225,20 +473,100 @@ func addKVConfig(prefixes jsonconfig.Obj, file string) {
 	}
 }
 
-func addS3Config(params *configPrefixesParams, prefixes jsonconfig.Obj, s3 string) error {
-	f := strings.SplitN(s3, ":", 4)
-	if len(f) < 3 {
-		return errors.New(`genconfig: expected "s3" field to be of form "access_key_id:secret_access_key:bucket"`)
+func addLevelDBConfig(prefixes jsonconfig.Obj, file string) {
+	prefixes["/index/"] = map[string]interface{}{
+		"handler": "storage-index",
+		"handlerArgs": map[string]interface{}{
+			"blobSource": "/bs/",
+			"storage": map[string]interface{}{
+				"type": "leveldb",
+				"file": file,
+			},
+		},
+	}
+}
+
+// addMemoryIndexConfig emits an index kept entirely in memory, for
+// throwaway or demo servers. The "_warning" key is a comment for
+// people reading the generated config; jsonconfig ignores it.
+func addMemoryIndexConfig(prefixes jsonconfig.Obj) {
+	prefixes["/index/"] = map[string]interface{}{
+		"handler": "storage-index",
+		"handlerArgs": map[string]interface{}{
+			"_warning":   "The index is in memory only; it is rebuilt from /bs/ on every server start.",
+			"blobSource": "/bs/",
+			"storage": map[string]interface{}{
+				"type": "memory",
+			},
+		},
 	}
-	accessKey, secret, bucket := f[0], f[1], f[2]
-	var hostname string
-	if len(f) == 4 {
-		hostname = f[3]
+}
+
+func addRemoteIndexConfig(prefixes jsonconfig.Obj, urlStr, auth string) error {
+	u, err := url.Parse(urlStr)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf(`genconfig: "indexURL" %q is not a valid http(s) URL`, urlStr)
+	}
+	if auth == "" {
+		auth = "none"
 	}
-	isPrimary := false
-	if _, ok := prefixes["/bs/"]; !ok {
-		isPrimary = true
+	prefixes["/index/"] = map[string]interface{}{
+		"handler": "storage-remote",
+		"handlerArgs": map[string]interface{}{
+			"url":  urlStr,
+			"auth": auth,
+		},
 	}
+	return nil
+}
+
+func addS3Config(params *configPrefixesParams, prefixes jsonconfig.Obj, s3 *serverconfig.S3, cacheBucket string) error {
+	accessKey, secret, bucket, hostname := s3.AccessKey, s3.SecretKey, s3.Bucket, s3.Hostname
+	if s3.Legacy != "" {
+		f := strings.SplitN(s3.Legacy, ":", 4)
+		if len(f) < 3 {
+			return errors.New(`genconfig: expected "s3" field to be of form "access_key_id:secret_access_key:bucket"`)
+		}
+		accessKey, secret, bucket = f[0], f[1], f[2]
+		if len(f) == 4 {
+			hostname = f[3]
+		}
+	}
+	useIAMRole := s3.UseIAMRole || accessKey == "auto"
+	if useIAMRole {
+		if (accessKey != "" && accessKey != "auto") || secret != "" {
+			return errors.New(`genconfig: "s3" cannot combine static "accessKey"/"secretKey" credentials with "useIAMRole"`)
+		}
+		if bucket == "" {
+			return errors.New(`genconfig: "s3" with "useIAMRole" needs a non-empty "bucket" field`)
+		}
+	} else if accessKey == "" || secret == "" || bucket == "" {
+		return errors.New(`genconfig: "s3" object needs non-empty "accessKey", "secretKey", and "bucket" fields`)
+	}
+	switch s3.StorageClass {
+	case "", "STANDARD", "REDUCED_REDUNDANCY", "STANDARD_IA":
+	default:
+		return fmt.Errorf(`genconfig: invalid "storageClass" %q; accepted values are "STANDARD", "REDUCED_REDUNDANCY", and "STANDARD_IA"`, s3.StorageClass)
+	}
+	if s3.PartSize != 0 && s3.PartSize < 5<<20 {
+		return fmt.Errorf(`genconfig: "s3" option "partSize" is %d bytes; S3 requires at least 5 MB parts`, s3.PartSize)
+	}
+	var dirPrefix string
+	// The hostname may be followed by a bucket subdirectory,
+	// as in "my-s3-server.example.com/camli".
+	if i := strings.Index(hostname, "/"); i != -1 {
+		hostname, dirPrefix = hostname[:i], hostname[i+1:]
+	}
+	pathStyle := false
+	if s3.PathStyle != nil {
+		pathStyle = *s3.PathStyle
+	} else if hostname != "" {
+		// Most custom (non-AWS) endpoints only support
+		// path-style bucket addressing.
+		pathStyle = true
+		log.Printf(`genconfig: defaulting "pathStyle" to true for S3 endpoint %q; set "pathStyle": false to use virtual-hosted addressing`, hostname)
+	}
+	isPrimary := params.storageIsPrimary(prefixes, "s3")
 	s3Prefix := ""
 	if isPrimary {
 		s3Prefix = "/bs/"
@@ -246,155 +574,914 @@ func addS3Config(params *configPrefixesParams, prefixes jsonconfig.Obj, s3 strin
 		s3Prefix = "/sto-s3/"
 	}
 	args := map[string]interface{}{
-		"aws_access_key":        accessKey,
-		"aws_secret_access_key": secret,
-		"bucket":                bucket,
+		"bucket": bucket,
+	}
+	if useIAMRole {
+		args["useIAMRole"] = true
+	} else {
+		args["aws_access_key"] = accessKey
+		args["aws_secret_access_key"] = secret
 	}
 	if hostname != "" {
 		args["hostname"] = hostname
 	}
+	if dirPrefix != "" {
+		args["dirPrefix"] = dirPrefix
+	}
+	if pathStyle {
+		args["pathStyle"] = true
+	}
+	if s3.ServerSideEncryption != "" {
+		args["serverSideEncryption"] = s3.ServerSideEncryption
+	}
+	if s3.StorageClass != "" {
+		args["storageClass"] = s3.StorageClass
+	}
+	// float64, as jsonconfig only deals with JSON number types.
+	if s3.MultipartThreshold != 0 {
+		args["multipartThreshold"] = float64(s3.MultipartThreshold)
+	}
+	if s3.PartSize != 0 {
+		args["partSize"] = float64(s3.PartSize)
+	}
 	prefixes[s3Prefix] = map[string]interface{}{
 		"handler":     "storage-s3",
 		"handlerArgs": args,
 	}
 	if isPrimary {
-		// TODO(mpl): s3CacheBucket
-		// See http://code.google.com/p/camlistore/issues/detail?id=85
+		if cacheBucket != "" {
+			cbucket, cdirPrefix := cacheBucket, ""
+			if i := strings.Index(cacheBucket, "/"); i != -1 {
+				cbucket, cdirPrefix = cacheBucket[:i], cacheBucket[i+1:]
+			}
+			if cbucket == bucket && cdirPrefix == "" {
+				return errors.New(`genconfig: "s3CacheBucket" must be a different bucket than "s3", or include a directory prefix, to not mix the cache with the blobs`)
+			}
+			cacheArgs := map[string]interface{}{
+				"bucket": cbucket,
+			}
+			if useIAMRole {
+				cacheArgs["useIAMRole"] = true
+			} else {
+				cacheArgs["aws_access_key"] = accessKey
+				cacheArgs["aws_secret_access_key"] = secret
+			}
+			if hostname != "" {
+				cacheArgs["hostname"] = hostname
+			}
+			if cdirPrefix != "" {
+				cacheArgs["dirPrefix"] = cdirPrefix
+			}
+			if pathStyle {
+				cacheArgs["pathStyle"] = true
+			}
+			prefixes["/cache/"] = map[string]interface{}{
+				"handler":     "storage-s3",
+				"handlerArgs": cacheArgs,
+			}
+		} else {
+			prefixes["/cache/"] = map[string]interface{}{
+				"handler": "storage-filesystem",
+				"handlerArgs": map[string]interface{}{
+					"path": params.localCacheDir(),
+				},
+			}
+		}
+	} else {
+		if cacheBucket != "" {
+			return errors.New(`genconfig: "s3CacheBucket" is only used when "s3" is the primary blob storage (no blobPath)`)
+		}
+		if params.mirrors("s3") {
+			addMirrorSync(params, prefixes, "s3", s3Prefix, "sync-to-s3-queue.kv")
+		}
+	}
+	return nil
+}
+
+func addAzureConfig(params *configPrefixesParams, prefixes jsonconfig.Obj, azure string) error {
+	f := strings.SplitN(azure, ":", 3)
+	if len(f) != 3 {
+		return errors.New(`genconfig: expected "azure" field to be of form "account:access_key:container"`)
+	}
+	account, accessKey, container := f[0], f[1], f[2]
+	isPrimary := params.storageIsPrimary(prefixes, "azure")
+	azurePrefix := ""
+	if isPrimary {
+		azurePrefix = "/bs/"
+	} else {
+		azurePrefix = "/sto-azure/"
+	}
+	prefixes[azurePrefix] = map[string]interface{}{
+		"handler": "storage-azure",
+		"handlerArgs": map[string]interface{}{
+			"account":    account,
+			"access_key": accessKey,
+			"container":  container,
+		},
+	}
+	if isPrimary {
+		prefixes["/cache/"] = map[string]interface{}{
+			"handler": "storage-filesystem",
+			"handlerArgs": map[string]interface{}{
+				"path": params.localCacheDir(),
+			},
+		}
+	} else {
+		if params.mirrors("azure") {
+			addMirrorSync(params, prefixes, "azure", azurePrefix, "sync-to-azure-queue.kv")
+		}
+	}
+	return nil
+}
+
+func addSwiftConfig(params *configPrefixesParams, prefixes jsonconfig.Obj, swift *serverconfig.Swift) error {
+	if swift.AuthURL == "" || swift.User == "" || swift.APIKey == "" || swift.Container == "" {
+		return errors.New(`genconfig: the "swift" config section requires "authURL", "user", "apiKey", and "container"`)
+	}
+	isPrimary := params.storageIsPrimary(prefixes, "swift")
+	swiftPrefix := ""
+	if isPrimary {
+		swiftPrefix = "/bs/"
+	} else {
+		swiftPrefix = "/sto-swift/"
+	}
+	args := map[string]interface{}{
+		"auth_url":  swift.AuthURL,
+		"user":      swift.User,
+		"api_key":   swift.APIKey,
+		"container": swift.Container,
+	}
+	if swift.Region != "" {
+		args["region"] = swift.Region
+	}
+	if swift.Tenant != "" {
+		args["tenant"] = swift.Tenant
+	}
+	prefixes[swiftPrefix] = map[string]interface{}{
+		"handler":     "storage-swift",
+		"handlerArgs": args,
+	}
+	if isPrimary {
+		prefixes["/cache/"] = map[string]interface{}{
+			"handler": "storage-filesystem",
+			"handlerArgs": map[string]interface{}{
+				"path": params.localCacheDir(),
+			},
+		}
+	} else {
+		if params.mirrors("swift") {
+			addMirrorSync(params, prefixes, "swift", swiftPrefix, "sync-to-swift-queue.kv")
+		}
+	}
+	return nil
+}
+
+func addSFTPConfig(params *configPrefixesParams, prefixes jsonconfig.Obj, sftp *serverconfig.SFTP) error {
+	if sftp.Host == "" || sftp.User == "" || sftp.Dir == "" {
+		return errors.New(`genconfig: the "sftp" config section requires "host", "user", and "dir"`)
+	}
+	if _, _, err := net.SplitHostPort(sftp.Host); err != nil {
+		return fmt.Errorf(`genconfig: invalid "sftp" host %q; want "host:port" (ex: "nas.example.com:22")`, sftp.Host)
+	}
+	if (sftp.Password == "") == (sftp.PrivateKeyFile == "") {
+		return errors.New(`genconfig: the "sftp" config section requires exactly one of "password" or "privateKeyFile"`)
+	}
+	isPrimary := params.storageIsPrimary(prefixes, "sftp")
+	sftpPrefix := ""
+	if isPrimary {
+		sftpPrefix = "/bs/"
+	} else {
+		sftpPrefix = "/sto-sftp/"
+	}
+	args := map[string]interface{}{
+		"host": sftp.Host,
+		"user": sftp.User,
+		"dir":  sftp.Dir,
+	}
+	if sftp.Password != "" {
+		args["password"] = sftp.Password
+	}
+	if sftp.PrivateKeyFile != "" {
+		args["privateKeyFile"] = sftp.PrivateKeyFile
+	}
+	prefixes[sftpPrefix] = map[string]interface{}{
+		"handler":     "storage-sftp",
+		"handlerArgs": args,
+	}
+	if isPrimary {
+		prefixes["/cache/"] = map[string]interface{}{
+			"handler": "storage-filesystem",
+			"handlerArgs": map[string]interface{}{
+				"path": params.localCacheDir(),
+			},
+		}
+	} else {
+		if params.mirrors("sftp") {
+			addMirrorSync(params, prefixes, "sftp", sftpPrefix, "sync-to-sftp-queue.kv")
+		}
+	}
+	return nil
+}
+
+func addWebDAVConfig(params *configPrefixesParams, prefixes jsonconfig.Obj, dav *serverconfig.WebDAV) error {
+	if dav.URL == "" {
+		return errors.New(`genconfig: the "webdav" config section requires "url"`)
+	}
+	u, err := url.Parse(dav.URL)
+	if err != nil {
+		return fmt.Errorf(`genconfig: invalid "webdav" url %q: %v`, dav.URL, err)
+	}
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if !dav.Insecure {
+			return fmt.Errorf(`genconfig: refusing "webdav" url %q without https; set "insecure": true to allow it`, dav.URL)
+		}
+	default:
+		return fmt.Errorf(`genconfig: invalid "webdav" url %q; "https" (or "http") scheme required`, dav.URL)
+	}
+	isPrimary := params.storageIsPrimary(prefixes, "webdav")
+	davPrefix := ""
+	if isPrimary {
+		davPrefix = "/bs/"
+	} else {
+		davPrefix = "/sto-webdav/"
+	}
+	args := map[string]interface{}{
+		"url": dav.URL,
+	}
+	if dav.Username != "" {
+		args["username"] = dav.Username
+	}
+	if dav.Password != "" {
+		args["password"] = dav.Password
+	}
+	prefixes[davPrefix] = map[string]interface{}{
+		"handler":     "storage-webdav",
+		"handlerArgs": args,
+	}
+	if isPrimary {
+		prefixes["/cache/"] = map[string]interface{}{
+			"handler": "storage-filesystem",
+			"handlerArgs": map[string]interface{}{
+				"path": params.localCacheDir(),
+			},
+		}
+	} else {
+		if params.mirrors("webdav") {
+			addMirrorSync(params, prefixes, "webdav", davPrefix, "sync-to-webdav-queue.kv")
+		}
+	}
+	return nil
+}
+
+func addRADOSConfig(params *configPrefixesParams, prefixes jsonconfig.Obj, rados *serverconfig.RADOS) error {
+	if len(rados.Monitors) == 0 || rados.Pool == "" {
+		return errors.New(`genconfig: the "rados" config section requires "monitors" and "pool"`)
+	}
+	isPrimary := params.storageIsPrimary(prefixes, "rados")
+	radosPrefix := ""
+	if isPrimary {
+		radosPrefix = "/bs/"
+	} else {
+		radosPrefix = "/sto-rados/"
+	}
+	monitors := make([]interface{}, len(rados.Monitors))
+	for i, mon := range rados.Monitors {
+		monitors[i] = mon
+	}
+	args := map[string]interface{}{
+		"monitors": monitors,
+		"pool":     rados.Pool,
+	}
+	if rados.User != "" {
+		args["user"] = rados.User
+	}
+	if rados.Keyring != "" {
+		args["keyring"] = rados.Keyring
+	}
+	prefixes[radosPrefix] = map[string]interface{}{
+		"handler":     "storage-rados",
+		"handlerArgs": args,
+	}
+	if isPrimary {
 		prefixes["/cache/"] = map[string]interface{}{
 			"handler": "storage-filesystem",
 			"handlerArgs": map[string]interface{}{
-				"path": filepath.Join(tempDir(), "camli-cache"),
+				"path": params.localCacheDir(),
+			},
+		}
+	} else {
+		if params.mirrors("rados") {
+			addMirrorSync(params, prefixes, "rados", radosPrefix, "sync-to-rados-queue.kv")
+		}
+	}
+	return nil
+}
+
+func addB2Config(params *configPrefixesParams, prefixes jsonconfig.Obj, b2 string) error {
+	f := strings.SplitN(b2, ":", 3)
+	if len(f) != 3 {
+		return errors.New(`genconfig: expected "b2" field to be of form "account_id:application_key:bucket"`)
+	}
+	accountID, appKey, bucket := f[0], f[1], f[2]
+	isPrimary := params.storageIsPrimary(prefixes, "b2")
+	b2Prefix := ""
+	if isPrimary {
+		b2Prefix = "/bs/"
+	} else {
+		b2Prefix = "/sto-b2/"
+	}
+	prefixes[b2Prefix] = map[string]interface{}{
+		"handler": "storage-b2",
+		"handlerArgs": map[string]interface{}{
+			"account_id":      accountID,
+			"application_key": appKey,
+			"bucket":          bucket,
+		},
+	}
+	if isPrimary {
+		prefixes["/cache/"] = map[string]interface{}{
+			"handler": "storage-filesystem",
+			"handlerArgs": map[string]interface{}{
+				"path": params.localCacheDir(),
+			},
+		}
+	} else {
+		if params.mirrors("b2") {
+			addMirrorSync(params, prefixes, "b2", b2Prefix, "sync-to-b2-queue.kv")
+		}
+	}
+	return nil
+}
+
+func addGoogleDriveConfig(params *configPrefixesParams, prefixes jsonconfig.Obj, gdrive *serverconfig.GoogleDrive) error {
+	highCfg := gdrive.Legacy
+	if highCfg == "" {
+		// Object form: synthesize the equivalent legacy string.
+		highCfg = gdrive.Auth + ":" + gdrive.Parent
+	}
+	f := strings.SplitN(highCfg, ":", 4)
+	if len(f) != 4 {
+		return errors.New(`genconfig: expected "googledrive" field to be of form "client_id:client_secret:refresh_token:parent_id" (or "name=<folder name>" as the fourth component)`)
+	}
+	clientId, secret, refreshToken, parent := f[0], f[1], f[2], f[3]
+	parentId, parentName := parent, ""
+	if strings.HasPrefix(parent, "name=") {
+		parentId, parentName = "", strings.TrimPrefix(parent, "name=")
+	}
+	if parentId == "" && parentName == "" {
+		return errors.New(`genconfig: "googledrive" needs a parent folder: either its opaque ID as the fourth component, or "name=<folder name>" to have the folder resolved or created by name on start`)
+	}
+
+	isPrimary := params.storageIsPrimary(prefixes, "googledrive")
+
+	prefix := ""
+	if isPrimary {
+		prefix = "/bs/"
+	} else {
+		prefix = "/sto-googledrive/"
+	}
+	args := map[string]interface{}{
+		"auth": map[string]interface{}{
+			"client_id":     clientId,
+			"client_secret": secret,
+			"refresh_token": refreshToken,
+		},
+	}
+	if parentName != "" {
+		// The storage-googledrive handler resolves the folder by
+		// name on start, creating it if it does not exist yet.
+		args["parent_name"] = parentName
+	} else {
+		args["parent_id"] = parentId
+	}
+	if gdrive.ChunkSize != 0 {
+		if gdrive.ChunkSize < 0 || gdrive.ChunkSize%(256<<10) != 0 {
+			return errors.New(`genconfig: "googledrive" chunkSize must be a positive multiple of 256KiB`)
+		}
+		args["chunkSize"] = float64(gdrive.ChunkSize) // float64, as jsonconfig only deals with JSON number types.
+	}
+	if gdrive.MaxRetries != 0 {
+		if gdrive.MaxRetries < 0 {
+			return errors.New(`genconfig: "googledrive" maxRetries must not be negative`)
+		}
+		args["maxRetries"] = float64(gdrive.MaxRetries) // float64, as jsonconfig only deals with JSON number types.
+	}
+	if gdrive.RetryBackoff != "" {
+		if _, err := time.ParseDuration(gdrive.RetryBackoff); err != nil {
+			return fmt.Errorf(`genconfig: invalid "googledrive" retryBackoff: %v`, err)
+		}
+		args["retryBackoff"] = gdrive.RetryBackoff
+	}
+	prefixes[prefix] = map[string]interface{}{
+		"handler":     "storage-googledrive",
+		"handlerArgs": args,
+	}
+
+	if isPrimary {
+		prefixes["/cache/"] = map[string]interface{}{
+			"handler": "storage-filesystem",
+			"handlerArgs": map[string]interface{}{
+				"path": params.localCacheDir(),
+			},
+		}
+	} else {
+		if params.mirrors("googledrive") {
+			addMirrorSync(params, prefixes, "googledrive", prefix, "sync-to-googledrive-queue.kv")
+		}
+	}
+
+	return nil
+}
+
+func addGoogleCloudStorageConfig(params *configPrefixesParams, prefixes jsonconfig.Obj, gcs *serverconfig.GoogleCloudStorage, keyFile, cacheBucket string) error {
+	highCfg := gcs.Legacy
+	if highCfg == "" {
+		// Object form: synthesize the equivalent legacy string.
+		switch gcs.Auth {
+		case "gce":
+			highCfg = ":" + gcs.Bucket
+		case "":
+			highCfg = gcs.Bucket
+		default:
+			highCfg = gcs.Auth + ":" + gcs.Bucket
+		}
+	}
+	// fullSyncArgs adds the full-sync booleans, when explicitly set,
+	// to the args of the sync handler at syncPrefix.
+	fullSyncArgs := func(syncPrefix string) {
+		if !gcs.FullSyncOnStart && !gcs.BlockingFullSyncOnStart {
+			return
+		}
+		p, ok := prefixes[syncPrefix].(map[string]interface{})
+		if !ok {
+			return
+		}
+		args := p["handlerArgs"].(map[string]interface{})
+		if gcs.FullSyncOnStart {
+			args["fullSyncOnStart"] = true
+		}
+		if gcs.BlockingFullSyncOnStart {
+			args["blockingFullSyncOnStart"] = true
+		}
+	}
+	var bucket string
+	// authArgs returns a fresh "auth" object for the handler args,
+	// either the service account key file or the OAuth triplet.
+	var authArgs func() map[string]interface{}
+	if keyFile != "" {
+		if strings.Contains(highCfg, ":") {
+			return errors.New(`genconfig: with "gcsServiceAccountFile", the "googlecloudstorage" field is just the bucket name, not the "client_id:client_secret:refresh_token:bucket" form`)
+		}
+		bucket = highCfg
+		if bucket == "" {
+			return errors.New(`genconfig: "gcsServiceAccountFile" needs a bucket name in the "googlecloudstorage" field`)
+		}
+		f, err := os.Open(keyFile)
+		if err != nil {
+			return fmt.Errorf(`genconfig: could not read "gcsServiceAccountFile": %v`, err)
+		}
+		f.Close()
+		authArgs = func() map[string]interface{} {
+			return map[string]interface{}{
+				"service_account_file": keyFile,
+			}
+		}
+	} else if strings.HasPrefix(highCfg, ":") {
+		// Empty auth parts: use the GCE metadata-service
+		// credentials of the VM's default service account.
+		bucket = strings.TrimPrefix(highCfg, ":")
+		if bucket == "" || strings.Contains(bucket, ":") {
+			return errors.New(`genconfig: expected "googlecloudstorage" in ":bucketname" form to name just a bucket`)
+		}
+		if !onGCE() {
+			return errors.New(`genconfig: "googlecloudstorage" is configured to use GCE metadata-service credentials (auth "gce", or a ":bucketname" value), but this server does not appear to be running on Google Compute Engine`)
+		}
+		authArgs = func() map[string]interface{} {
+			return map[string]interface{}{
+				"gce": true,
+			}
+		}
+	} else {
+		f := strings.SplitN(highCfg, ":", 4)
+		if len(f) != 4 {
+			return errors.New(`genconfig: expected "googlecloudstorage" field to be of form "client_id:client_secret:refresh_token:bucket"`)
+		}
+		clientId, secret, refreshToken := f[0], f[1], f[2]
+		bucket = f[3]
+		authArgs = func() map[string]interface{} {
+			return map[string]interface{}{
+				"client_id":     clientId,
+				"client_secret": secret,
+				"refresh_token": refreshToken,
+			}
+		}
+	}
+
+	isPrimary := params.storageIsPrimary(prefixes, "googlecloudstorage")
+
+	gsPrefix := ""
+	if isPrimary {
+		gsPrefix = "/bs/"
+	} else {
+		gsPrefix = "/sto-googlecloudstorage/"
+	}
+
+	prefixes[gsPrefix] = map[string]interface{}{
+		"handler": "storage-googlecloudstorage",
+		"handlerArgs": map[string]interface{}{
+			"bucket": bucket,
+			"auth":   authArgs(),
+		},
+	}
+
+	if isPrimary {
+		// The full-sync booleans apply to the sync from the
+		// primary to the index.
+		fullSyncArgs("/sync/")
+		if cacheBucket != "" {
+			if strings.Contains(cacheBucket, "/") {
+				return errors.New(`genconfig: "gcsCacheBucket" must be a plain bucket name, without a directory prefix`)
+			}
+			if cacheBucket == bucket {
+				return errors.New(`genconfig: "gcsCacheBucket" must be a different bucket than "googlecloudstorage", to not mix the cache with the blobs`)
+			}
+			prefixes["/cache/"] = map[string]interface{}{
+				"handler": "storage-googlecloudstorage",
+				"handlerArgs": map[string]interface{}{
+					"bucket": cacheBucket,
+					"auth":   authArgs(),
+				},
+			}
+		} else {
+			prefixes["/cache/"] = map[string]interface{}{
+				"handler": "storage-filesystem",
+				"handlerArgs": map[string]interface{}{
+					"path": params.localCacheDir(),
+				},
+			}
+		}
+	} else {
+		if cacheBucket != "" {
+			return errors.New(`genconfig: "gcsCacheBucket" is only used when "googlecloudstorage" is the primary blob storage (no blobPath)`)
+		}
+		if params.mirrors("googlecloudstorage") {
+			addMirrorSync(params, prefixes, "googlecloudstorage", gsPrefix, "sync-to-googlecloud-queue.kv")
+			fullSyncArgs("/sync-to-googlecloudstorage/")
+		}
+	}
+	return nil
+}
+
+// addSyncsConfig adds the additional sync handlers declared in the
+// high-level "syncs" section to prefixes. It must be called after all
+// the storage handlers (and the automatically generated sync handlers)
+// have been added, so the source and destination prefixes, as well as
+// name collisions, can be checked.
+func addSyncsConfig(params *configPrefixesParams, prefixes jsonconfig.Obj, syncs map[string]*serverconfig.Sync) error {
+	// Deterministic order, for repeatable error messages and tests.
+	var syncPrefixes []string
+	for k := range syncs {
+		syncPrefixes = append(syncPrefixes, k)
+	}
+	sort.Strings(syncPrefixes)
+	for _, syncPrefix := range syncPrefixes {
+		v := syncs[syncPrefix]
+		if !strings.HasPrefix(syncPrefix, "/") || !strings.HasSuffix(syncPrefix, "/") {
+			return fmt.Errorf("Invalid sync prefix %q; must start and end with a slash.", syncPrefix)
+		}
+		if _, ok := prefixes[syncPrefix]; ok {
+			return fmt.Errorf("Sync prefix %q would overwrite an existing handler at that prefix.", syncPrefix)
+		}
+		if v.From == "" {
+			return fmt.Errorf("Missing \"from\" key in configuration for sync prefix %q.", syncPrefix)
+		}
+		if _, ok := prefixes[v.From]; !ok {
+			return fmt.Errorf("Unknown \"from\" prefix %q in configuration for sync prefix %q.", v.From, syncPrefix)
+		}
+		if (v.To != "") == (len(v.ToStorage) != 0) {
+			return fmt.Errorf("Exactly one of \"to\" and \"toStorage\" must be set for sync prefix %q.", syncPrefix)
+		}
+		to := v.To
+		if to != "" {
+			if _, ok := prefixes[to]; !ok {
+				return fmt.Errorf("Unknown \"to\" prefix %q in configuration for sync prefix %q.", to, syncPrefix)
+			}
+		} else {
+			to = strings.TrimSuffix(syncPrefix, "/") + "-storage/"
+			if _, ok := prefixes[to]; ok {
+				return fmt.Errorf("Storage prefix %q for sync prefix %q would overwrite an existing handler at that prefix.", to, syncPrefix)
+			}
+			typ, ok := v.ToStorage["type"].(string)
+			if !ok || typ == "" {
+				return fmt.Errorf("Missing \"type\" in \"toStorage\" configuration for sync prefix %q.", syncPrefix)
+			}
+			args := map[string]interface{}{}
+			for k, av := range v.ToStorage {
+				if k != "type" {
+					args[k] = av
+				}
+			}
+			prefixes[to] = map[string]interface{}{
+				"handler":     "storage-" + typ,
+				"handlerArgs": args,
+			}
+		}
+		queue := v.Queue
+		if queue == "" {
+			if params.blobPath == "" {
+				return fmt.Errorf("Missing \"queue\" key in configuration for sync prefix %q; required when there is no blobPath to derive it from.", syncPrefix)
+			}
+			queue = filepath.Join(params.blobPath,
+				strings.Replace(strings.Trim(syncPrefix, "/"), "/", "-", -1)+"-queue.kv")
+		}
+		prefixes[syncPrefix] = map[string]interface{}{
+			"handler": "sync",
+			"handlerArgs": map[string]interface{}{
+				"from": v.From,
+				"to":   to,
+				"queue": map[string]interface{}{
+					"type": "kv",
+					"file": queue,
+				},
+			},
+		}
+	}
+	return nil
+}
+
+// addReplicateToConfig adds, for each "replicateTo" entry, a
+// "storage-remote" prefix for the remote Camlistore server at that URL
+// and a sync handler replicating /bs/ to it. Credentials may be given
+// in the URL's user:password section; they become the remote handler's
+// "auth" argument.
+func addReplicateToConfig(params *configPrefixesParams, prefixes jsonconfig.Obj, entries []interface{}) error {
+	if params.blobPath == "" {
+		return errors.New(`genconfig: "replicateTo" requires a local "blobPath" to replicate from`)
+	}
+	for i, ei := range entries {
+		s, ok := ei.(string)
+		if !ok {
+			return fmt.Errorf(`genconfig: "replicateTo" entry %d is a %T; it must be the URL of a remote Camlistore server`, i, ei)
+		}
+		u, err := url.Parse(s)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf(`genconfig: "replicateTo" entry %q is not a valid http(s) URL`, s)
+		}
+		auth := "none"
+		if ui := u.User; ui != nil {
+			pass, _ := ui.Password()
+			auth = "userpass:" + ui.Username() + ":" + pass
+			u.User = nil
+		}
+		name := fmt.Sprintf("remote-%d", i)
+		prefixes["/sto-"+name+"/"] = map[string]interface{}{
+			"handler": "storage-remote",
+			"handlerArgs": map[string]interface{}{
+				"url":  u.String(),
+				"auth": auth,
+			},
+		}
+		addMirrorSync(params, prefixes, name, "/sto-"+name+"/", "sync-to-"+name+"-queue.kv")
+	}
+	return nil
+}
+
+// encryptAgreement is the acknowledgment string the "encrypt" storage
+// constructor requires in its I_AGREE argument.
+const encryptAgreement = "that encryption support hasn't been peer-reviewed, isn't finished, and its format might change."
+
+// addEncryptConfig wraps the storage prefixes named by encrypt.Targets
+// in "storage-encrypt" handlers. The raw storage at /sto-<name>/
+// becomes the encrypted-blobs backend of /sto-<name>-encrypted/, the
+// meta blobs and meta index are kept under the Camlistore var
+// directory, and the target's sync handler is repointed at the
+// encrypting prefix so mirrored blobs are encrypted before they leave
+// the server.
+func addEncryptConfig(prefixes jsonconfig.Obj, encrypt *serverconfig.Encrypt) error {
+	if encrypt.KeyFile == "" {
+		return errors.New(`genconfig: "encrypt" needs a non-empty "keyFile"`)
+	}
+	if len(encrypt.Targets) == 0 {
+		return errors.New(`genconfig: "encrypt" needs at least one "targets" entry`)
+	}
+	if !noMkdir {
+		if _, err := os.Stat(encrypt.KeyFile); err != nil {
+			if !os.IsNotExist(err) {
+				return fmt.Errorf(`genconfig: "encrypt" key file %v: %v`, encrypt.KeyFile, err)
+			}
+			if !encrypt.AutoKeygen {
+				return fmt.Errorf(`genconfig: "encrypt" key file %v does not exist; create it with 16 random bytes, or set "autoKeygen" to generate it`, encrypt.KeyFile)
+			}
+			if err := writeEncryptKey(encrypt.KeyFile); err != nil {
+				return err
+			}
+		}
+	}
+	seen := make(map[string]bool)
+	for _, name := range encrypt.Targets {
+		if name == "localdisk" {
+			return errors.New(`genconfig: "localdisk" can't be an "encrypt" target; only mirrored storages are encrypted`)
+		}
+		if seen[name] {
+			return fmt.Errorf(`genconfig: duplicate "encrypt" target %q`, name)
+		}
+		seen[name] = true
+		rawPrefix := "/sto-" + name + "/"
+		if _, ok := prefixes[rawPrefix]; !ok {
+			return fmt.Errorf(`genconfig: "encrypt" target %q is not a configured mirrored storage (no %v prefix)`, name, rawPrefix)
+		}
+		metaPrefix := "/sto-" + name + "-encmeta/"
+		prefixes[metaPrefix] = map[string]interface{}{
+			"handler": "storage-filesystem",
+			"handlerArgs": map[string]interface{}{
+				"path": filepath.Join(varDir(), "encrypt", name, "meta"),
 			},
 		}
-	} else {
-		if params.blobPath == "" {
-			panic("unexpected empty blobpath with sync-to-s3")
-		}
-		prefixes["/sync-to-s3/"] = map[string]interface{}{
-			"handler": "sync",
+		encPrefix := "/sto-" + name + "-encrypted/"
+		prefixes[encPrefix] = map[string]interface{}{
+			"handler": "storage-encrypt",
 			"handlerArgs": map[string]interface{}{
-				"from": "/bs/",
-				"to":   s3Prefix,
-				"queue": map[string]interface{}{
+				"I_AGREE": encryptAgreement,
+				"keyFile": encrypt.KeyFile,
+				"blobs":   rawPrefix,
+				"meta":    metaPrefix,
+				"metaIndex": map[string]interface{}{
 					"type": "kv",
-					"file": filepath.Join(params.blobPath, "sync-to-s3-queue.kv"),
+					"file": filepath.Join(varDir(), "encrypt", name, "metaindex.kv"),
 				},
 			},
 		}
+		// Mirrored blobs must be written through the encryption
+		// layer, not to the raw storage.
+		if sync, ok := prefixes["/sync-to-"+name+"/"].(map[string]interface{}); ok {
+			if args, ok := sync["handlerArgs"].(map[string]interface{}); ok && args["to"] == rawPrefix {
+				args["to"] = encPrefix
+			}
+		}
 	}
 	return nil
 }
 
-func addGoogleDriveConfig(params *configPrefixesParams, prefixes jsonconfig.Obj, highCfg string) error {
-	f := strings.SplitN(highCfg, ":", 4)
-	if len(f) != 4 {
-		return errors.New(`genconfig: expected "googledrive" field to be of form "client_id:client_secret:refresh_token:parent_id"`)
+// writeEncryptKey generates a fresh random AES-128 key and writes it to
+// path, which must not exist yet.
+func writeEncryptKey(path string) error {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("genconfig: generating encryption key: %v", err)
 	}
-	clientId, secret, refreshToken, parentId := f[0], f[1], f[2], f[3]
-
-	isPrimary := false
-	if _, ok := prefixes["/bs/"]; !ok {
-		isPrimary = true
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("genconfig: creating encryption key directory: %v", err)
 	}
-
-	prefix := ""
-	if isPrimary {
-		prefix = "/bs/"
-	} else {
-		prefix = "/sto-googledrive/"
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("genconfig: creating encryption key file: %v", err)
 	}
-	prefixes[prefix] = map[string]interface{}{
-		"handler": "storage-googledrive",
-		"handlerArgs": map[string]interface{}{
-			"parent_id": parentId,
-			"auth": map[string]interface{}{
-				"client_id":     clientId,
-				"client_secret": secret,
-				"refresh_token": refreshToken,
-			},
-		},
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		return fmt.Errorf("genconfig: writing encryption key file %v: %v", path, err)
 	}
+	return f.Close()
+}
 
-	if isPrimary {
-		prefixes["/cache/"] = map[string]interface{}{
-			"handler": "storage-filesystem",
+// blobStorePrefix returns the low-level config for a local blob store
+// rooted at path, using the given layout: "loose" (storage-filesystem),
+// "diskpacked", or "blobpacked". The blobpacked form keeps its loose
+// blobs, pack files, and meta index in sub-paths of path.
+func blobStorePrefix(layout, path string) map[string]interface{} {
+	switch layout {
+	case "diskpacked":
+		return map[string]interface{}{
+			"handler": "storage-diskpacked",
 			"handlerArgs": map[string]interface{}{
-				"path": filepath.Join(tempDir(), "camli-cache"),
+				"path": path,
 			},
 		}
-	} else {
-		prefixes["/sync-to-googledrive/"] = map[string]interface{}{
-			"handler": "sync",
+	case "blobpacked":
+		return map[string]interface{}{
+			"handler": "storage-blobpacked",
 			"handlerArgs": map[string]interface{}{
-				"from": "/bs/",
-				"to":   prefix,
-				"queue": map[string]interface{}{
+				"smallBlobs": filepath.Join(path, "loose"),
+				"largeBlobs": filepath.Join(path, "packed"),
+				"metaIndex": map[string]interface{}{
 					"type": "kv",
-					"file": filepath.Join(params.blobPath,
-						"sync-to-googledrive-queue.kv"),
+					"file": filepath.Join(path, "packed", "packindex.kv"),
 				},
 			},
 		}
 	}
+	return map[string]interface{}{
+		"handler": "storage-filesystem",
+		"handlerArgs": map[string]interface{}{
+			"path": path,
+		},
+	}
+}
 
+// ensureWritableDir creates dir with mode 0700 if it doesn't exist,
+// then verifies it's writable by creating and removing a probe file.
+// what names the directory's origin in the high-level config (e.g.
+// `"blobPath" directory`); errors include it along with the owner and
+// mode of the closest existing path, so a fresh install fails here
+// with an actionable message instead of at handler initialization.
+func ensureWritableDir(what, dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("genconfig: could not create %s %s: %v%s", what, dir, err, ownerModeOf(dir))
+	}
+	probe := filepath.Join(dir, ".camli-probe")
+	f, err := os.Create(probe)
+	if err != nil {
+		return fmt.Errorf("genconfig: %s %s is not writable: %v%s", what, dir, err, ownerModeOf(dir))
+	}
+	f.Close()
+	os.Remove(probe)
 	return nil
 }
 
-func addGoogleCloudStorageConfig(params *configPrefixesParams, prefixes jsonconfig.Obj, highCfg string) error {
-	f := strings.SplitN(highCfg, ":", 4)
-	if len(f) != 4 {
-		return errors.New(`genconfig: expected "googlecloudstorage" field to be of form "client_id:client_secret:refresh_token:bucket"`)
+// ownerModeOf describes the closest existing ancestor of path, as
+// " (<path> is mode <perm>, owned by uid <uid> gid <gid>)", for
+// ensureWritableDir errors. The ownership part is omitted on
+// platforms that don't report it.
+func ownerModeOf(path string) string {
+	for {
+		fi, err := os.Stat(path)
+		if err == nil {
+			return fmt.Sprintf(" (%s is mode %#o%s)", path, fi.Mode().Perm(), fileOwner(fi))
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return ""
+		}
+		path = parent
 	}
-	clientId, secret, refreshToken, bucket := f[0], f[1], f[2], f[3]
+}
 
-	isPrimary := false
-	if _, ok := prefixes["/bs/"]; !ok {
-		isPrimary = true
+// ensureServerDirs pre-creates and write-probes the directories the
+// generated config will need at runtime: the index file directory,
+// the blob path (loose or diskpacked), and the queue directory of
+// each sync handler. It also rejects an index file path that names an
+// existing directory. It must be called after all prefixes have been
+// added.
+func ensureServerDirs(conf *serverconfig.Config, prefixes jsonconfig.Obj, indexFileDir string) error {
+	what := map[string]string{}
+	add := func(dir, w string) {
+		if dir != "" {
+			if _, dup := what[dir]; !dup {
+				what[dir] = w
+			}
+		}
 	}
-
-	gsPrefix := ""
-	if isPrimary {
-		gsPrefix = "/bs/"
-	} else {
-		gsPrefix = "/sto-googlecloudstorage/"
+	add(conf.BlobPath, `"blobPath" directory`)
+	switch {
+	case conf.SQLite != "":
+		add(indexFileDir, `"sqlite" index directory`)
+	case conf.KVFile != "":
+		add(indexFileDir, `"kvIndexFile" index directory`)
+	case conf.LevelDB != "":
+		add(indexFileDir, `"levelDB" index directory`)
+	}
+	// The index file itself must be a file, not a directory.
+	// (levelDB is excluded: its path is a directory by design.)
+	for _, f := range []struct{ path, what string }{
+		{conf.SQLite, `"sqlite"`},
+		{conf.KVFile, `"kvIndexFile"`},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if fi, err := os.Stat(f.path); err == nil && fi.IsDir() {
+			return fmt.Errorf("genconfig: %s index file %s is a directory; it must be a file path", f.what, f.path)
+		}
 	}
-
-	prefixes[gsPrefix] = map[string]interface{}{
-		"handler": "storage-googlecloudstorage",
-		"handlerArgs": map[string]interface{}{
-			"bucket": bucket,
-			"auth": map[string]interface{}{
-				"client_id":     clientId,
-				"client_secret": secret,
-				"refresh_token": refreshToken,
-				// If high-level config is for the common user then fullSyncOnStart = true
-				// Then the default just works.
-				//"fullSyncOnStart": true,
-				//"blockingFullSyncOnStart": false
-			},
-		},
+	var syncPrefixes []string
+	for p, v := range prefixes {
+		if h, ok := v.(map[string]interface{}); ok && h["handler"] == "sync" {
+			syncPrefixes = append(syncPrefixes, p)
+		}
 	}
-
-	if isPrimary {
-		// TODO: cacheBucket like s3CacheBucket?
-		prefixes["/cache/"] = map[string]interface{}{
-			"handler": "storage-filesystem",
-			"handlerArgs": map[string]interface{}{
-				"path": filepath.Join(tempDir(), "camli-cache"),
-			},
+	sort.Strings(syncPrefixes)
+	for _, p := range syncPrefixes {
+		args, ok := prefixes[p].(map[string]interface{})["handlerArgs"].(map[string]interface{})
+		if !ok {
+			continue
 		}
-	} else {
-		prefixes["/sync-to-googlecloudstorage/"] = map[string]interface{}{
-			"handler": "sync",
-			"handlerArgs": map[string]interface{}{
-				"from": "/bs/",
-				"to":   gsPrefix,
-				"queue": map[string]interface{}{
-					"type": "kv",
-					"file": filepath.Join(params.blobPath,
-						"sync-to-googlecloud-queue.kv"),
-				},
-			},
+		queue, ok := args["queue"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if file, ok := queue["file"].(string); ok && file != "" {
+			add(filepath.Dir(file), fmt.Sprintf("sync queue directory for %q", p))
+		}
+	}
+	var dirs []string
+	for dir := range what {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		if err := ensureWritableDir(what[dir], dir); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -431,9 +1518,30 @@ func genLowLevelPrefixes(params *configPrefixesParams, ownerName string) (m json
 		"handler": "setup",
 	}
 
-	m["/status/"] = map[string]interface{}{
+	statusHandler := map[string]interface{}{
 		"handler": "status",
 	}
+	m["/status/"] = statusHandler
+	if params.diskFreeWarn > 0 || params.diskFreeRefuse > 0 {
+		dirs := []interface{}{}
+		if params.blobPath != "" {
+			dirs = append(dirs, params.blobPath)
+		}
+		if params.indexFileDir != "" {
+			dirs = append(dirs, params.indexFileDir)
+		}
+		statusArgs := map[string]interface{}{
+			"diskCheckDirs": dirs,
+		}
+		// float64, as jsonconfig only deals with JSON number types.
+		if params.diskFreeWarn > 0 {
+			statusArgs["diskFreeWarn"] = float64(params.diskFreeWarn)
+		}
+		if params.diskFreeRefuse > 0 {
+			statusArgs["diskFreeRefuse"] = float64(params.diskFreeRefuse)
+		}
+		statusHandler["handlerArgs"] = statusArgs
+	}
 	importerArgs := map[string]interface{}{}
 	if haveIndex {
 		m["/importer/"] = map[string]interface{}{
@@ -460,24 +1568,17 @@ func genLowLevelPrefixes(params *configPrefixesParams, ownerName string) (m json
 		},
 	}
 
-	storageType := "filesystem"
-	if params.packBlobs {
-		storageType = "diskpacked"
+	layout := params.blobLayout
+	if params.localCache == "diskpacked" {
+		layout = "diskpacked"
 	}
 	if params.blobPath != "" {
-		m["/bs/"] = map[string]interface{}{
-			"handler": "storage-" + storageType,
-			"handlerArgs": map[string]interface{}{
-				"path": params.blobPath,
-			},
-		}
-
-		m["/cache/"] = map[string]interface{}{
-			"handler": "storage-" + storageType,
-			"handlerArgs": map[string]interface{}{
-				"path": filepath.Join(params.blobPath, "/cache"),
-			},
+		m["/bs/"] = blobStorePrefix(layout, params.blobPath)
+		cacheDir := params.cacheDir
+		if cacheDir == "" {
+			cacheDir = filepath.Join(params.blobPath, "/cache")
 		}
+		m["/cache/"] = blobStorePrefix(layout, cacheDir)
 	}
 
 	if params.flickr != "" {
@@ -500,19 +1601,32 @@ func genLowLevelPrefixes(params *configPrefixesParams, ownerName string) (m json
 		// TODO: currently when using s3, the index must be
 		// sqlite or kvfile, since only through one of those
 		// can we get a directory.
-		if params.blobPath == "" && params.indexFileDir == "" {
+		switch {
+		case params.blobPath == "" && params.indexFileDir == "" && params.indexURL == "":
 			// We don't actually have a working sync handler, but we keep a stub registered
 			// so it can be referred to from other places.
 			// See http://camlistore.org/issue/201
 			syncArgs["idle"] = true
-		} else {
+		case params.memoryStorage:
+			// A memory-only index is rebuilt from /bs/ on every
+			// start, so a persistent sync queue would be pointless.
+			syncArgs["idle"] = true
+		default:
 			dir := params.blobPath
 			if dir == "" {
 				dir = params.indexFileDir
 			}
+			if dir == "" {
+				// Remote index with a remote primary storage:
+				// keep the queue in the Camlistore var directory.
+				dir = varDir()
+			}
 			typ := "kv"
-			if params.haveSQLite {
+			switch {
+			case params.haveSQLite:
 				typ = "sqlite"
+			case params.haveLevelDB:
+				typ = "leveldb"
 			}
 			syncArgs["queue"] = map[string]interface{}{
 				"type": typ,
@@ -544,8 +1658,9 @@ func genLowLevelPrefixes(params *configPrefixesParams, ownerName string) (m json
 		}
 
 		searchArgs := map[string]interface{}{
-			"index": "/index/",
-			"owner": params.searchOwner.String(),
+			"index":      "/index/",
+			"owner":      params.searchOwner.String(),
+			"blobSource": "/bs/",
 		}
 		if params.memoryIndex {
 			searchArgs["slurpToMemory"] = true
@@ -559,21 +1674,129 @@ func genLowLevelPrefixes(params *configPrefixesParams, ownerName string) (m json
 	return
 }
 
+// autocertDomain returns the hostname to obtain an ACME (Let's
+// Encrypt) certificate for, derived from the high-level config's
+// baseURL. The ACME CA validates domain ownership over port 443, so
+// any other explicit port in baseURL is an error, as are hosts no
+// public CA would issue a certificate for (IP addresses, localhost).
+func autocertDomain(baseURL string) (string, error) {
+	if baseURL == "" {
+		return "", errors.New(`genconfig: "httpsCert": "auto" requires a "baseURL" to derive the certificate's domain from`)
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing baseURL %q as a URL: %v", baseURL, err)
+	}
+	host := u.Host
+	if h, port, err := net.SplitHostPort(u.Host); err == nil {
+		if port != "443" {
+			return "", fmt.Errorf(`genconfig: "httpsCert": "auto" requires the server to be reachable on port 443 for ACME validation; baseURL %q has port %s`, baseURL, port)
+		}
+		host = h
+	}
+	if host == "" {
+		return "", fmt.Errorf(`genconfig: "httpsCert": "auto": no hostname in baseURL %q`, baseURL)
+	}
+	if host == "localhost" || net.ParseIP(host) != nil {
+		return "", fmt.Errorf(`genconfig: "httpsCert": "auto" requires a public domain name in baseURL; can't get an ACME certificate for %q`, host)
+	}
+	return host, nil
+}
+
+// httpRedirectTarget derives the URL the "httpRedirect" listener
+// redirects to: the baseURL (with an https scheme) when set, otherwise
+// the listen host plus the HTTPS port.
+func httpRedirectTarget(conf *serverconfig.Config) (string, error) {
+	if conf.BaseURL != "" {
+		u, err := url.Parse(conf.BaseURL)
+		if err != nil {
+			return "", fmt.Errorf("Error parsing baseURL %q as a URL: %v", conf.BaseURL, err)
+		}
+		u.Scheme = "https"
+		u.Path = ""
+		return u.String(), nil
+	}
+	listenAddr := conf.Listen.Addr
+	if listenAddr == "" {
+		for _, l := range conf.Listen.Listeners {
+			if l.HTTPS {
+				listenAddr = l.Addr
+				break
+			}
+		}
+	}
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil || host == "" {
+		return "", fmt.Errorf(`genconfig: can't derive the "httpRedirect" target from listen address %q; set "baseURL"`, listenAddr)
+	}
+	if port == "443" {
+		return "https://" + host, nil
+	}
+	return "https://" + net.JoinHostPort(host, port), nil
+}
+
 // genLowLevelConfig returns a low-level config from a high-level config.
 func genLowLevelConfig(conf *serverconfig.Config) (lowLevelConf *Config, err error) {
+	if conf.S3 != nil && *conf.S3 == (serverconfig.S3{}) {
+		// An empty "s3" entry (such as the legacy "") means not configured.
+		conf.S3 = nil
+	}
 	obj := jsonconfig.Obj{}
-	if conf.HTTPS {
-		if (conf.HTTPSCert != "") != (conf.HTTPSKey != "") {
-			return nil, errors.New("Must set both httpsCert and httpsKey (or neither to generate a self-signed cert)")
+	// With the list form of "listen", each listener says itself
+	// whether it serves TLS; the top-level "https" bool only makes
+	// sense with the single-address form.
+	httpsListener := false
+	if len(conf.Listen.Listeners) > 0 {
+		if conf.HTTPS {
+			return nil, errors.New(`genconfig: with the list form of "listen", drop the top-level "https" and set it per listener`)
 		}
-		if conf.HTTPSCert != "" {
+		for _, l := range conf.Listen.Listeners {
+			if l.HTTPS {
+				httpsListener = true
+			}
+		}
+	}
+	if conf.HTTPS || httpsListener {
+		switch {
+		case conf.HTTPSCert == "auto":
+			// Let's Encrypt mode: the server obtains and renews
+			// its certificate itself via ACME.
+			if conf.HTTPSKey != "" {
+				return nil, errors.New(`genconfig: "httpsKey" must be empty when "httpsCert" is "auto"`)
+			}
+			domain, err := autocertDomain(conf.BaseURL)
+			if err != nil {
+				return nil, err
+			}
+			obj["httpsCert"] = "auto"
+			obj["autocertDomain"] = domain
+			obj["autocertCacheDir"] = filepath.Join(varDir(), "letsencrypt")
+		case (conf.HTTPSCert != "") != (conf.HTTPSKey != ""):
+			return nil, errors.New("Must set both httpsCert and httpsKey (or neither to generate a self-signed cert)")
+		case conf.HTTPSCert != "":
 			obj["httpsCert"] = conf.HTTPSCert
 			obj["httpsKey"] = conf.HTTPSKey
-		} else {
+		default:
 			obj["httpsCert"] = osutil.DefaultTLSCert()
 			obj["httpsKey"] = osutil.DefaultTLSKey()
 		}
 	}
+	if conf.TLSMinVersion != "" {
+		if _, err := webserver.ParseTLSVersion(conf.TLSMinVersion); err != nil {
+			return nil, fmt.Errorf(`genconfig: invalid "tlsMinVersion": %v`, err)
+		}
+		obj["tlsMinVersion"] = conf.TLSMinVersion
+	}
+	if len(conf.TLSCiphers) > 0 {
+		ciphers := make([]interface{}, len(conf.TLSCiphers))
+		for i, name := range conf.TLSCiphers {
+			if _, err := webserver.ParseTLSCipherSuite(name); err != nil {
+				return nil, fmt.Errorf(`genconfig: invalid "tlsCiphers" entry: %v`, err)
+			}
+			ciphers[i] = name
+		}
+		obj["tlsCiphers"] = ciphers
+	}
 
 	if conf.BaseURL != "" {
 		u, err := url.Parse(conf.BaseURL)
@@ -586,11 +1809,68 @@ func genLowLevelConfig(conf *serverconfig.Config) (lowLevelConf *Config, err err
 		u.Path = ""
 		obj["baseURL"] = u.String()
 	}
-	if conf.Listen != "" {
-		obj["listen"] = conf.Listen
+	if len(conf.Listen.Listeners) > 0 {
+		seen := make(map[string]bool)
+		listeners := make([]interface{}, 0, len(conf.Listen.Listeners))
+		for _, l := range conf.Listen.Listeners {
+			if l.Addr == "" {
+				return nil, errors.New(`genconfig: missing "addr" in "listen" entry`)
+			}
+			if seen[l.Addr] {
+				return nil, fmt.Errorf(`genconfig: duplicate address %q in "listen"`, l.Addr)
+			}
+			seen[l.Addr] = true
+			scheme := "http"
+			if l.HTTPS {
+				scheme = "https"
+			}
+			listeners = append(listeners, scheme+"://"+l.Addr)
+		}
+		obj["listeners"] = listeners
+	} else {
+		if conf.Listen.Addr != "" {
+			obj["listen"] = conf.Listen.Addr
+		}
+		obj["https"] = conf.HTTPS
+	}
+	if conf.HTTPRedirect != "" {
+		if !conf.HTTPS && !httpsListener {
+			return nil, errors.New(`genconfig: "httpRedirect" requires "https"`)
+		}
+		if conf.HTTPRedirect == conf.Listen.Addr {
+			return nil, fmt.Errorf(`genconfig: "httpRedirect" address %q is already the "listen" address`, conf.HTTPRedirect)
+		}
+		for _, l := range conf.Listen.Listeners {
+			if conf.HTTPRedirect == l.Addr {
+				return nil, fmt.Errorf(`genconfig: "httpRedirect" address %q is already a "listen" address`, conf.HTTPRedirect)
+			}
+		}
+		target, err := httpRedirectTarget(conf)
+		if err != nil {
+			return nil, err
+		}
+		obj["httpRedirect"] = map[string]interface{}{
+			"listen": conf.HTTPRedirect,
+			"target": target,
+		}
 	}
-	obj["https"] = conf.HTTPS
 	obj["auth"] = conf.Auth
+	if conf.AdvertiseLAN {
+		obj["advertiseLAN"] = true
+	}
+	if conf.ReadOnly {
+		obj["readonly"] = true
+	}
+	if len(conf.Logging) > 0 {
+		logging := make(map[string]interface{}, len(conf.Logging))
+		for sub, level := range conf.Logging {
+			if _, err := camlog.ParseLevel(level); err != nil {
+				return nil, fmt.Errorf(`genconfig: in "logging", subsystem %q: %v`, sub, err)
+			}
+			logging[sub] = level
+		}
+		obj["logging"] = logging
+	}
 
 	username := ""
 	if conf.DBName == "" {
@@ -601,23 +1881,51 @@ func genLowLevelConfig(conf *serverconfig.Config) (lowLevelConf *Config, err err
 		conf.DBName = "camli" + username
 	}
 
-	var haveSQLite bool
+	mysql, err := normalizedDBConnection("mysql", conf.MySQL)
+	if err != nil {
+		return nil, err
+	}
+	postgres, err := normalizedDBConnection("postgres", conf.PostgreSQL)
+	if err != nil {
+		return nil, err
+	}
+	mongo, err := normalizedMongoConnection(conf.Mongo)
+	if err != nil {
+		return nil, err
+	}
+
+	// The per-indexer database name overrides only make sense with
+	// their own indexer configured.
+	if conf.MySQLDBName != "" && mysql == nil {
+		return nil, errors.New(`genconfig: "mysqlDBName" requires "mysql"`)
+	}
+	if conf.PostgreSQLDBName != "" && postgres == nil {
+		return nil, errors.New(`genconfig: "postgresDBName" requires "postgres"`)
+	}
+	if conf.MongoDBName != "" && mongo == nil {
+		return nil, errors.New(`genconfig: "mongoDBName" requires "mongo"`)
+	}
+
+	var haveSQLite, haveLevelDB bool
 	var indexFileDir string // filesystem directory of sqlite, kv, or similar
-	numIndexers := numSet(conf.Mongo, conf.MySQL, conf.PostgreSQL, conf.SQLite, conf.KVFile)
+	numIndexers := numSet(mongo != nil, mysql != nil, postgres != nil, conf.SQLite, conf.KVFile, conf.LevelDB, conf.IndexURL, conf.MemoryStorage)
 	runIndex := conf.RunIndex.Get()
 
 	switch {
 	case runIndex && numIndexers == 0:
-		return nil, fmt.Errorf("Unless runIndex is set to false, you must specify an index option (kvIndexFile, mongo, mysql, postgres, sqlite).")
+		return nil, fmt.Errorf("Unless runIndex is set to false, you must specify an index option (indexURL, kvIndexFile, levelDB, memoryStorage, mongo, mysql, postgres, sqlite).")
 	case runIndex && numIndexers != 1:
-		return nil, fmt.Errorf("With runIndex set true, you can only pick exactly one indexer (mongo, mysql, postgres, sqlite).")
+		return nil, fmt.Errorf("With runIndex set true, you can only pick exactly one indexer (indexURL, kvIndexFile, levelDB, memoryStorage, mongo, mysql, postgres, sqlite).")
 	case !runIndex && numIndexers != 0:
-		return nil, fmt.Errorf("With runIndex disabled, you can't specify any of mongo, mysql, postgres, sqlite.")
+		return nil, fmt.Errorf("With runIndex disabled, you can't specify any of indexURL, kvIndexFile, levelDB, memoryStorage, mongo, mysql, postgres, sqlite.")
 	case conf.SQLite != "":
 		haveSQLite = true
 		indexFileDir = filepath.Dir(conf.SQLite)
 	case conf.KVFile != "":
 		indexFileDir = filepath.Dir(conf.KVFile)
+	case conf.LevelDB != "":
+		haveLevelDB = true
+		indexFileDir = filepath.Dir(conf.LevelDB)
 	}
 
 	entity, err := jsonsign.EntityFromSecring(conf.Identity, conf.IdentitySecretRing)
@@ -631,42 +1939,175 @@ func genLowLevelConfig(conf *serverconfig.Config) (lowLevelConf *Config, err err
 
 	nolocaldisk := conf.BlobPath == ""
 	if nolocaldisk {
-		if conf.S3 == "" && conf.GoogleCloudStorage == "" {
-			return nil, errors.New("You need at least one of blobPath (for localdisk) or s3 or googlecloudstorage configured for a blobserver.")
+		if conf.S3 == nil && conf.Azure == "" && conf.B2 == "" && conf.Swift == nil && conf.SFTP == nil && conf.WebDAV == nil && conf.RADOS == nil && conf.GoogleCloudStorage == nil {
+			return nil, errors.New("You need at least one of blobPath (for localdisk) or s3 or azure or b2 or swift or sftp or webdav or rados or googlecloudstorage configured for a blobserver.")
+		}
+	}
+	if nolocaldisk && conf.PrimaryStorage == "" {
+		// Without an explicit "primaryStorage", the primary would be
+		// whichever remote storage happens to claim /bs/ first, so
+		// refuse the ambiguous combinations.
+		if conf.Swift != nil && conf.S3 != nil {
+			return nil, errors.New("Swift and S3 can't both be the primary storage; use blobPath to make one a mirror.")
+		}
+		if conf.Azure != "" && (conf.S3 != nil || conf.GoogleCloudStorage != nil) {
+			return nil, errors.New("Using Azure together with S3 or Google Cloud Storage is not supported for now.")
+		}
+		if conf.B2 != "" && (conf.S3 != nil || conf.Azure != "" || conf.GoogleCloudStorage != nil) {
+			return nil, errors.New("Using B2 together with another remote blobserver is not supported for now.")
+		}
+		if conf.SFTP != nil && (conf.S3 != nil || conf.Azure != "" || conf.B2 != "" || conf.Swift != nil || conf.GoogleCloudStorage != nil) {
+			return nil, errors.New("SFTP and another remote blobserver can't both be the primary storage; use blobPath to make one a mirror.")
+		}
+		if conf.WebDAV != nil && (conf.S3 != nil || conf.Azure != "" || conf.B2 != "" || conf.Swift != nil || conf.SFTP != nil || conf.GoogleCloudStorage != nil) {
+			return nil, errors.New("WebDAV and another remote blobserver can't both be the primary storage; use blobPath to make one a mirror.")
+		}
+		if conf.RADOS != nil && (conf.S3 != nil || conf.Azure != "" || conf.B2 != "" || conf.Swift != nil || conf.SFTP != nil || conf.WebDAV != nil || conf.GoogleCloudStorage != nil) {
+			return nil, errors.New("RADOS and another remote blobserver can't both be the primary storage; use blobPath to make one a mirror.")
+		}
+	}
+
+	configuredStorages := map[string]bool{
+		"localdisk":          conf.BlobPath != "",
+		"s3":                 conf.S3 != nil,
+		"azure":              conf.Azure != "",
+		"b2":                 conf.B2 != "",
+		"swift":              conf.Swift != nil,
+		"sftp":               conf.SFTP != nil,
+		"webdav":             conf.WebDAV != nil,
+		"rados":              conf.RADOS != nil,
+		"googledrive":        conf.GoogleDrive != nil,
+		"googlecloudstorage": conf.GoogleCloudStorage != nil,
+	}
+	primaryStorage := conf.PrimaryStorage
+	if primaryStorage != "" {
+		if _, ok := configuredStorages[primaryStorage]; !ok {
+			return nil, fmt.Errorf(`genconfig: unknown "primaryStorage" value %q`, primaryStorage)
+		}
+		if !configuredStorages[primaryStorage] {
+			return nil, fmt.Errorf(`genconfig: "primaryStorage" is %q, but that storage is not configured`, primaryStorage)
+		}
+		if conf.BlobPath != "" && primaryStorage != "localdisk" && conf.LocalCache == "" {
+			return nil, errors.New(`genconfig: with "blobPath" set, "primaryStorage" must be "localdisk"; use "mirrorTo" to replicate to the other storages, or "localCache" to stage blobs locally`)
+		}
+	} else if conf.BlobPath != "" {
+		primaryStorage = "localdisk"
+	}
+	var mirrorTo map[string]bool
+	if len(conf.MirrorTo) > 0 {
+		mirrorTo = make(map[string]bool)
+		for _, name := range conf.MirrorTo {
+			if _, ok := configuredStorages[name]; !ok {
+				return nil, fmt.Errorf(`genconfig: unknown "mirrorTo" entry %q`, name)
+			}
+			if !configuredStorages[name] {
+				return nil, fmt.Errorf(`genconfig: "mirrorTo" entry %q is not a configured storage`, name)
+			}
+			if name == primaryStorage {
+				return nil, fmt.Errorf(`genconfig: "mirrorTo" entry %q is already the primary storage`, name)
+			}
+			if name == "localdisk" {
+				return nil, errors.New(`genconfig: "localdisk" can't be a "mirrorTo" target; use the "syncs" section instead`)
+			}
+			if mirrorTo[name] {
+				return nil, fmt.Errorf(`genconfig: duplicate "mirrorTo" entry %q`, name)
+			}
+			mirrorTo[name] = true
+		}
+	}
+
+	if conf.LocalCache != "" {
+		if conf.LocalCache != "diskpacked" {
+			return nil, fmt.Errorf(`genconfig: unknown "localCache" value %q; only "diskpacked" is supported`, conf.LocalCache)
 		}
-		if conf.S3 != "" && conf.GoogleCloudStorage != "" {
-			return nil, errors.New("Using S3 as a primary storage and Google Cloud Storage as a mirror is not supported for now.")
+		if conf.BlobPath == "" {
+			return nil, errors.New(`genconfig: "localCache" requires "blobPath", for the local pack files`)
 		}
+		if conf.PrimaryStorage == "" || conf.PrimaryStorage == "localdisk" {
+			return nil, errors.New(`genconfig: "localCache" only makes sense with a remote "primaryStorage"`)
+		}
+	}
+
+	blobLayout := conf.BlobLayout
+	if conf.PackBlobs {
+		if blobLayout != "" {
+			return nil, errors.New(`genconfig: "packBlobs" and "blobLayout" are mutually exclusive; "packBlobs" is a deprecated alias for a "blobLayout" of "diskpacked"`)
+		}
+		blobLayout = "diskpacked"
+	}
+	switch blobLayout {
+	case "":
+		blobLayout = "loose"
+	case "loose", "diskpacked", "blobpacked":
+	default:
+		return nil, fmt.Errorf(`genconfig: unknown "blobLayout" value %q; valid layouts are "loose", "diskpacked", and "blobpacked"`, conf.BlobLayout)
 	}
 
 	if conf.ShareHandler && conf.ShareHandlerPath == "" {
 		conf.ShareHandlerPath = "/share/"
 	}
 
+	if conf.CacheDir != "" {
+		if !filepath.IsAbs(conf.CacheDir) {
+			return nil, fmt.Errorf(`genconfig: "cacheDir" must be an absolute path; got %q`, conf.CacheDir)
+		}
+		if conf.S3CacheBucket != "" || conf.GCSCacheBucket != "" {
+			return nil, errors.New(`genconfig: "cacheDir" is unused when "s3CacheBucket" or "gcsCacheBucket" moves the cache to remote storage`)
+		}
+	}
+
+	if tc := conf.ThumbCache; tc != nil {
+		switch tc.Type {
+		case "kv", "sqlite", "leveldb", "memory":
+		default:
+			return nil, fmt.Errorf(`genconfig: unknown "thumbCache" type %q; valid types are "kv", "sqlite", "leveldb", and "memory"`, tc.Type)
+		}
+		if tc.Type == "memory" {
+			if tc.File != "" {
+				return nil, errors.New(`genconfig: a "thumbCache" of type "memory" takes no "file"`)
+			}
+		} else if tc.File == "" {
+			return nil, fmt.Errorf(`genconfig: a "thumbCache" of type %q requires a "file"`, tc.Type)
+		}
+	}
+
 	prefixesParams := &configPrefixesParams{
 		secretRing:       conf.IdentitySecretRing,
 		keyId:            conf.Identity,
 		haveIndex:        runIndex,
 		haveSQLite:       haveSQLite,
+		haveLevelDB:      haveLevelDB,
 		blobPath:         conf.BlobPath,
-		packBlobs:        conf.PackBlobs,
+		blobLayout:       blobLayout,
 		searchOwner:      blob.SHA1FromString(armoredPublicKey),
 		shareHandlerPath: conf.ShareHandlerPath,
 		flickr:           conf.Flickr,
 		picasa:           conf.Picasa,
 		memoryIndex:      conf.MemoryIndex.Get(),
+		memoryStorage:    conf.MemoryStorage,
+		diskFreeWarn:     conf.DiskFreeWarn,
+		diskFreeRefuse:   conf.DiskFreeRefuse,
 		indexFileDir:     indexFileDir,
+		indexURL:         conf.IndexURL,
+		primaryStorage:   primaryStorage,
+		mirrorTo:         mirrorTo,
+		localCache:       conf.LocalCache,
+		cacheDir:         conf.CacheDir,
+		thumbCache:       conf.ThumbCache,
 	}
 
 	prefixes := genLowLevelPrefixes(prefixesParams, conf.OwnerName)
 	var cacheDir string
-	if nolocaldisk {
+	switch {
+	case conf.CacheDir != "":
+		cacheDir = conf.CacheDir
+	case nolocaldisk:
 		// Whether camlistored is run from EC2 or not, we use
-		// a temp dir as the cache when primary storage is S3.
-		// TODO(mpl): s3CacheBucket
-		// See http://code.google.com/p/camlistore/issues/detail?id=85
+		// a temp dir as the cache when primary storage is S3,
+		// unless a cache bucket (s3CacheBucket, gcsCacheBucket)
+		// moves the cache handler to remote storage too.
 		cacheDir = filepath.Join(tempDir(), "camli-cache")
-	} else {
+	default:
 		cacheDir = filepath.Join(conf.BlobPath, "cache")
 	}
 	if !noMkdir {
@@ -674,6 +2115,15 @@ func genLowLevelConfig(conf *serverconfig.Config) (lowLevelConf *Config, err err
 			return nil, fmt.Errorf("Could not create blobs cache dir %s: %v", cacheDir, err)
 		}
 	}
+	if conf.DiskFreeWarn > 0 || conf.DiskFreeRefuse > 0 {
+		// The cache dir is only known here, so add it to the dirs
+		// that genLowLevelPrefixes set up for disk monitoring.
+		if st, ok := prefixes["/status/"].(map[string]interface{}); ok {
+			if args, ok := st["handlerArgs"].(map[string]interface{}); ok {
+				args["diskCheckDirs"] = append(args["diskCheckDirs"].([]interface{}), cacheDir)
+			}
+		}
+	}
 
 	if len(conf.Publish) > 0 {
 		if !runIndex {
@@ -698,14 +2148,14 @@ func genLowLevelConfig(conf *serverconfig.Config) (lowLevelConf *Config, err err
 		addUIConfig(prefixesParams, prefixes, "/ui/", conf.SourceRoot)
 	}
 
-	if conf.MySQL != "" {
-		addMySQLConfig(prefixes, conf.DBName, conf.MySQL)
+	if mysql != nil {
+		addMySQLConfig(prefixes, indexDBName(conf.MySQLDBName, conf.DBName), mysql)
 	}
-	if conf.PostgreSQL != "" {
-		addPostgresConfig(prefixes, conf.DBName, conf.PostgreSQL)
+	if postgres != nil {
+		addPostgresConfig(prefixes, indexDBName(conf.PostgreSQLDBName, conf.DBName), postgres)
 	}
-	if conf.Mongo != "" {
-		addMongoConfig(prefixes, conf.DBName, conf.Mongo)
+	if mongo != nil {
+		addMongoConfig(prefixes, indexDBName(conf.MongoDBName, conf.DBName), mongo)
 	}
 	if conf.SQLite != "" {
 		addSQLiteConfig(prefixes, conf.SQLite)
@@ -713,18 +2163,94 @@ func genLowLevelConfig(conf *serverconfig.Config) (lowLevelConf *Config, err err
 	if conf.KVFile != "" {
 		addKVConfig(prefixes, conf.KVFile)
 	}
-	if conf.S3 != "" {
-		if err := addS3Config(prefixesParams, prefixes, conf.S3); err != nil {
+	if conf.LevelDB != "" {
+		addLevelDBConfig(prefixes, conf.LevelDB)
+	}
+	if conf.MemoryStorage {
+		addMemoryIndexConfig(prefixes)
+	}
+	if conf.IndexURLAuth != "" && conf.IndexURL == "" {
+		return nil, errors.New(`genconfig: "indexURLAuth" requires "indexURL"`)
+	}
+	if conf.IndexURL != "" {
+		if err := addRemoteIndexConfig(prefixes, conf.IndexURL, conf.IndexURLAuth); err != nil {
+			return nil, err
+		}
+	}
+	if conf.S3CacheBucket != "" && conf.S3 == nil {
+		return nil, errors.New(`genconfig: "s3CacheBucket" requires "s3"`)
+	}
+	if conf.GCSCacheBucket != "" && conf.GoogleCloudStorage == nil {
+		return nil, errors.New(`genconfig: "gcsCacheBucket" requires "googlecloudstorage"`)
+	}
+	if conf.S3 != nil {
+		if err := addS3Config(prefixesParams, prefixes, conf.S3, conf.S3CacheBucket); err != nil {
+			return nil, err
+		}
+	}
+	if conf.Azure != "" {
+		if err := addAzureConfig(prefixesParams, prefixes, conf.Azure); err != nil {
 			return nil, err
 		}
 	}
-	if conf.GoogleDrive != "" {
+	if conf.B2 != "" {
+		if err := addB2Config(prefixesParams, prefixes, conf.B2); err != nil {
+			return nil, err
+		}
+	}
+	if conf.Swift != nil {
+		if err := addSwiftConfig(prefixesParams, prefixes, conf.Swift); err != nil {
+			return nil, err
+		}
+	}
+	if conf.SFTP != nil {
+		if err := addSFTPConfig(prefixesParams, prefixes, conf.SFTP); err != nil {
+			return nil, err
+		}
+	}
+	if conf.WebDAV != nil {
+		if err := addWebDAVConfig(prefixesParams, prefixes, conf.WebDAV); err != nil {
+			return nil, err
+		}
+	}
+	if conf.RADOS != nil {
+		if err := addRADOSConfig(prefixesParams, prefixes, conf.RADOS); err != nil {
+			return nil, err
+		}
+	}
+	if conf.GoogleDrive != nil {
 		if err := addGoogleDriveConfig(prefixesParams, prefixes, conf.GoogleDrive); err != nil {
 			return nil, err
 		}
 	}
-	if conf.GoogleCloudStorage != "" {
-		if err := addGoogleCloudStorageConfig(prefixesParams, prefixes, conf.GoogleCloudStorage); err != nil {
+	if conf.GoogleCloudStorage != nil {
+		if err := addGoogleCloudStorageConfig(prefixesParams, prefixes, conf.GoogleCloudStorage, conf.GCSServiceAccountFile, conf.GCSCacheBucket); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(conf.ReplicateTo) > 0 {
+		if err := addReplicateToConfig(prefixesParams, prefixes, conf.ReplicateTo); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(conf.Syncs) > 0 {
+		if err := addSyncsConfig(prefixesParams, prefixes, conf.Syncs); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.Encrypt != nil {
+		// Last, so the storage and sync prefixes to wrap and
+		// repoint all exist.
+		if err := addEncryptConfig(prefixes, conf.Encrypt); err != nil {
+			return nil, err
+		}
+	}
+
+	if !noMkdir {
+		if err := ensureServerDirs(conf, prefixes, indexFileDir); err != nil {
 			return nil, err
 		}
 	}
@@ -771,7 +2297,7 @@ func setMap(m map[string]interface{}, v ...interface{}) {
 // kv. If filePath already exists, it is overwritten.
 func WriteDefaultConfigFile(filePath string, useSQLite bool) error {
 	conf := serverconfig.Config{
-		Listen:      ":3179",
+		Listen:      serverconfig.Listen{Addr: ":3179"},
 		HTTPS:       false,
 		Auth:        "localhost",
 		ReplicateTo: make([]interface{}, 0),
@@ -0,0 +1,92 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serverinit_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"camlistore.org/pkg/serverinit"
+	"camlistore.org/pkg/types/serverconfig"
+
+	// Handler and storage types the generated configs use:
+	_ "camlistore.org/pkg/blobserver/blobpacked"
+	_ "camlistore.org/pkg/blobserver/diskpacked"
+	_ "camlistore.org/pkg/blobserver/localdisk"
+	_ "camlistore.org/pkg/search"
+	_ "camlistore.org/pkg/server"
+	_ "camlistore.org/pkg/sorted/kvfile"
+)
+
+// TestInstallGeneratedHandlers generates a low-level config from a
+// high-level one and then instantiates every handler in it, once per
+// blob layout. Unlike the golden-file tests, which only compare the
+// generated JSON, this catches genconfig emitting a "handler" type
+// with no registered constructor.
+func TestInstallGeneratedHandlers(t *testing.T) {
+	ringPath, err := filepath.Abs(relativeRing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverinit.SetNoMkdir(false)
+	defer serverinit.SetNoMkdir(true)
+	defer serverinit.SetVarDirFunc(func() string { return "/camli-var" })
+
+	for _, layout := range []string{"loose", "diskpacked", "blobpacked"} {
+		tmp, err := ioutil.TempDir("", "serverinit-install-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmp)
+		serverinit.SetVarDirFunc(func() string { return filepath.Join(tmp, "var") })
+
+		confData, err := json.Marshal(&serverconfig.Config{
+			Listen:             serverconfig.Listen{Addr: "localhost:3179"},
+			Auth:               "userpass:camlistore:pass3179",
+			Identity:           "26F5ABDA",
+			IdentitySecretRing: ringPath,
+			BlobPath:           filepath.Join(tmp, "blobs"),
+			BlobLayout:         layout,
+			KVFile:             filepath.Join(tmp, "index.kv"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		confFile := filepath.Join(tmp, "server-config.json")
+		if err := ioutil.WriteFile(confFile, confData, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		conf, err := serverinit.Load(confFile)
+		if err != nil {
+			t.Errorf("layout %q: Load: %v", layout, err)
+			continue
+		}
+		shutdown, err := conf.InstallHandlers(http.NewServeMux(), "http://localhost:3179", false, nil)
+		if err != nil {
+			t.Errorf("layout %q: InstallHandlers: %v", layout, err)
+			continue
+		}
+		if shutdown != nil {
+			shutdown.Close()
+		}
+	}
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serverinit
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"camlistore.org/pkg/types/serverconfig"
+)
+
+// applyRole applies the "roles" section of the high-level config to the
+// generated low-level config conf. It verifies that every generated
+// prefix is assigned to exactly one role, and, if a role was selected
+// with --role, removes the prefixes of the other roles, rewriting any
+// reference to them into a remote storage handler pointed at the owning
+// role's baseURL. With no role selected, all prefixes are kept, but the
+// assignment is still validated.
+func applyRole(hi *serverconfig.Config, role string, conf *Config) error {
+	if len(hi.Roles) == 0 {
+		if role != "" {
+			return fmt.Errorf("a --role of %q was requested, but the config file has no \"roles\" section", role)
+		}
+		return nil
+	}
+	prefixes, ok := conf.Obj["prefixes"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("generated config has no \"prefixes\" object")
+	}
+
+	// Which role owns each prefix.
+	owner := make(map[string]string)
+	for rname, r := range hi.Roles {
+		if r == nil || r.BaseURL == "" {
+			return fmt.Errorf("role %q has no baseURL", rname)
+		}
+		if _, err := url.Parse(r.BaseURL); err != nil {
+			return fmt.Errorf("role %q has an invalid baseURL %q: %v", rname, r.BaseURL, err)
+		}
+		for _, pfx := range r.Prefixes {
+			if _, ok := prefixes[pfx]; !ok {
+				return fmt.Errorf("role %q includes prefix %q, which is not in the generated configuration", rname, pfx)
+			}
+			if prev, dup := owner[pfx]; dup {
+				return fmt.Errorf("prefix %q is assigned to both role %q and role %q", pfx, prev, rname)
+			}
+			owner[pfx] = rname
+		}
+	}
+	var unassigned []string
+	for pfx := range prefixes {
+		if _, ok := owner[pfx]; !ok {
+			unassigned = append(unassigned, pfx)
+		}
+	}
+	if len(unassigned) > 0 {
+		sort.Strings(unassigned)
+		return fmt.Errorf("prefixes %q are not assigned to any role; each generated prefix must belong to exactly one role", unassigned)
+	}
+	if role == "" {
+		// Running all roles in a single process.
+		return nil
+	}
+	r, ok := hi.Roles[role]
+	if !ok {
+		var known []string
+		for rname := range hi.Roles {
+			known = append(known, rname)
+		}
+		sort.Strings(known)
+		return fmt.Errorf("unknown role %q; the config defines roles %q", role, known)
+	}
+
+	kept := make(map[string]interface{})
+	for _, pfx := range r.Prefixes {
+		kept[pfx] = prefixes[pfx]
+	}
+	// Rewrite references from this role's handlers to prefixes served
+	// by other roles into remote storage handlers.
+	rw := &roleRewriter{
+		auth:     hi.Auth,
+		roles:    hi.Roles,
+		owner:    owner,
+		prefixes: prefixes,
+		kept:     kept,
+	}
+	for _, pfx := range r.Prefixes {
+		h, ok := kept[pfx].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if args, ok := h["handlerArgs"].(map[string]interface{}); ok {
+			rw.rewriteMap(args)
+		}
+	}
+	for refPfx, remotePfx := range rw.remotes {
+		kept[remotePfx] = map[string]interface{}{
+			"handler": "storage-remote",
+			"handlerArgs": map[string]interface{}{
+				"url":  strings.TrimSuffix(hi.Roles[owner[refPfx]].BaseURL, "/") + refPfx,
+				"auth": hi.Auth,
+				// The remote process may not be up yet.
+				"skipStartupCheck": true,
+			},
+		}
+	}
+	conf.Obj["prefixes"] = kept
+	return nil
+}
+
+// A roleRewriter replaces, in the handler arguments of the kept
+// prefixes, any string value naming a prefix served by another role
+// with the prefix of a generated remote storage handler for it.
+type roleRewriter struct {
+	auth     string
+	roles    map[string]*serverconfig.Role
+	owner    map[string]string      // prefix -> role name
+	prefixes map[string]interface{} // all generated prefixes
+	kept     map[string]interface{} // prefixes of the selected role
+	remotes  map[string]string      // referenced prefix -> remote handler prefix
+}
+
+func (rw *roleRewriter) rewriteMap(m map[string]interface{}) {
+	for k, v := range m {
+		m[k] = rw.rewrite(v)
+	}
+}
+
+func (rw *roleRewriter) rewrite(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		rw.rewriteMap(v)
+	case []interface{}:
+		for i, e := range v {
+			v[i] = rw.rewrite(e)
+		}
+	case string:
+		if _, isPrefix := rw.prefixes[v]; !isPrefix {
+			return v
+		}
+		if _, local := rw.kept[v]; local {
+			return v
+		}
+		return rw.remoteFor(v)
+	}
+	return v
+}
+
+// remoteFor returns the prefix of the remote storage handler standing
+// in for refPfx, allocating one on first use.
+func (rw *roleRewriter) remoteFor(refPfx string) string {
+	if remotePfx, ok := rw.remotes[refPfx]; ok {
+		return remotePfx
+	}
+	if rw.remotes == nil {
+		rw.remotes = make(map[string]string)
+	}
+	remotePfx := "/remote" + refPfx
+	rw.remotes[refPfx] = remotePfx
+	return remotePfx
+}
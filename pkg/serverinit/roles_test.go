@@ -0,0 +1,164 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serverinit_test
+
+import (
+	"strings"
+	"testing"
+
+	"camlistore.org/pkg/jsonconfig"
+	"camlistore.org/pkg/serverinit"
+	"camlistore.org/pkg/types/serverconfig"
+)
+
+// rolesTestConfig returns a fresh high-level config with two roles and
+// the low-level config its prefixes stand in for. Fresh each time,
+// because applying a role rewrites the prefixes in place.
+func rolesTestConfig() (*serverconfig.Config, *serverinit.Config) {
+	hi := &serverconfig.Config{
+		Auth: "userpass:camlistore:pass3179",
+		Roles: map[string]*serverconfig.Role{
+			"storage": {
+				BaseURL:  "https://storage.example.com",
+				Prefixes: []string{"/bs/"},
+			},
+			"frontend": {
+				BaseURL:  "https://front.example.com",
+				Prefixes: []string{"/", "/my-search/", "/index/"},
+			},
+		},
+	}
+	low := &serverinit.Config{
+		Obj: jsonconfig.Obj{
+			"prefixes": map[string]interface{}{
+				"/": map[string]interface{}{
+					"handler": "root",
+					"handlerArgs": map[string]interface{}{
+						"blobRoot":   "/bs/",
+						"searchRoot": "/my-search/",
+					},
+				},
+				"/bs/": map[string]interface{}{
+					"handler": "storage-filesystem",
+					"handlerArgs": map[string]interface{}{
+						"path": "/tmp/blobs",
+					},
+				},
+				"/my-search/": map[string]interface{}{
+					"handler": "search",
+					"handlerArgs": map[string]interface{}{
+						"index": "/index/",
+						"owner": "sha1-f2b0b7da718b97ce8c31591d8ed4645c777f3ef4",
+					},
+				},
+				"/index/": map[string]interface{}{
+					"handler": "storage-index",
+					"handlerArgs": map[string]interface{}{
+						"blobSource": "/bs/",
+					},
+				},
+			},
+		},
+	}
+	return hi, low
+}
+
+func TestApplyRoleAll(t *testing.T) {
+	hi, low := rolesTestConfig()
+	if err := serverinit.ApplyRole(hi, "", low); err != nil {
+		t.Fatal(err)
+	}
+	prefixes := low.Obj["prefixes"].(map[string]interface{})
+	if len(prefixes) != 4 {
+		t.Errorf("got %d prefixes with no role selected; want all 4", len(prefixes))
+	}
+}
+
+func TestApplyRoleFrontend(t *testing.T) {
+	hi, low := rolesTestConfig()
+	if err := serverinit.ApplyRole(hi, "frontend", low); err != nil {
+		t.Fatal(err)
+	}
+	prefixes := low.Obj["prefixes"].(map[string]interface{})
+	if _, ok := prefixes["/bs/"]; ok {
+		t.Error("storage role's /bs/ prefix still present in frontend config")
+	}
+	root := prefixes["/"].(map[string]interface{})["handlerArgs"].(map[string]interface{})
+	if got, want := root["blobRoot"], "/remote/bs/"; got != want {
+		t.Errorf("blobRoot = %q; want %q", got, want)
+	}
+	if got, want := root["searchRoot"], "/my-search/"; got != want {
+		t.Errorf("searchRoot = %q; want %q (same role, no rewrite)", got, want)
+	}
+	remote, ok := prefixes["/remote/bs/"].(map[string]interface{})
+	if !ok {
+		t.Fatal("no /remote/bs/ prefix generated")
+	}
+	if got, want := remote["handler"], "storage-remote"; got != want {
+		t.Errorf("remote handler = %q; want %q", got, want)
+	}
+	args := remote["handlerArgs"].(map[string]interface{})
+	if got, want := args["url"], "https://storage.example.com/bs/"; got != want {
+		t.Errorf("remote url = %q; want %q", got, want)
+	}
+	if got, want := args["auth"], hi.Auth; got != want {
+		t.Errorf("remote auth = %q; want %q", got, want)
+	}
+	// The index handler's blobSource goes through the same remote.
+	index := prefixes["/index/"].(map[string]interface{})["handlerArgs"].(map[string]interface{})
+	if got, want := index["blobSource"], "/remote/bs/"; got != want {
+		t.Errorf("index blobSource = %q; want %q", got, want)
+	}
+}
+
+func TestApplyRoleStorage(t *testing.T) {
+	hi, low := rolesTestConfig()
+	if err := serverinit.ApplyRole(hi, "storage", low); err != nil {
+		t.Fatal(err)
+	}
+	prefixes := low.Obj["prefixes"].(map[string]interface{})
+	if len(prefixes) != 1 {
+		t.Errorf("got %d prefixes for storage role; want just /bs/: %v", len(prefixes), prefixes)
+	}
+	if _, ok := prefixes["/bs/"]; !ok {
+		t.Error("storage role is missing /bs/")
+	}
+}
+
+func TestApplyRoleErrors(t *testing.T) {
+	hi, low := rolesTestConfig()
+	hi.Roles["frontend"].Prefixes = []string{"/", "/my-search/"} // /index/ left out
+	err := serverinit.ApplyRole(hi, "frontend", low)
+	if err == nil || !strings.Contains(err.Error(), "/index/") {
+		t.Errorf("unassigned prefix: got %v; want error naming /index/", err)
+	}
+
+	hi, low = rolesTestConfig()
+	err = serverinit.ApplyRole(hi, "backend", low)
+	if err == nil || !strings.Contains(err.Error(), "backend") {
+		t.Errorf("unknown role: got %v; want error naming the role", err)
+	}
+
+	hi, low = rolesTestConfig()
+	hi.Roles = nil
+	if err := serverinit.ApplyRole(hi, "frontend", low); err == nil {
+		t.Error("no roles section: got nil error with a role selected")
+	}
+	if err := serverinit.ApplyRole(hi, "", low); err != nil {
+		t.Errorf("no roles section, no role: got %v; want nil", err)
+	}
+}
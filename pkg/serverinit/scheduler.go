@@ -0,0 +1,340 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serverinit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"camlistore.org/pkg/context"
+	"camlistore.org/pkg/sorted"
+	"camlistore.org/pkg/syncutil"
+)
+
+// maxConcurrentTasks is the global cap on scheduled tasks running at
+// once, so a pile-up of slow background jobs (scrubs, sweeps, imports)
+// can't starve the handlers serving requests.
+const maxConcurrentTasks = 4
+
+// A Task is a named periodic job run by the server's task scheduler.
+// Handlers register their background work as Tasks instead of running
+// their own timer loops, so all periodic work shares one concurrency
+// cap, shows up on the status handler, and is stopped on shutdown.
+type Task struct {
+	// Name uniquely identifies the task, for the status page and
+	// for RunTaskNow. Registering a task with the name of an
+	// existing one replaces it.
+	Name string
+
+	// Interval is how long after the end of one run the next run
+	// starts.
+	Interval time.Duration
+
+	// Jitter, if positive, is the upper bound of a random extra
+	// delay added to each Interval, to spread out tasks that would
+	// otherwise fire together.
+	Jitter time.Duration
+
+	// Run does one pass of the task's work. The context is
+	// canceled when the server is shutting down; Run should return
+	// promptly once it's done.
+	Run func(ctx *context.Context) error
+}
+
+// TaskStatus describes the last known state of a registered task, for
+// the status handler.
+type TaskStatus struct {
+	Name        string `json:"name"`
+	Interval    string `json:"interval"`
+	Running     bool   `json:"running,omitempty"`
+	LastRun     string `json:"lastRun,omitempty"` // RFC 3339, or empty if never run
+	LastRunTook string `json:"lastRunTook,omitempty"`
+	LastError   string `json:"lastError,omitempty"`
+}
+
+// taskRecord is the JSON value stored in the scheduler's
+// sorted.KeyValue under "task:<name>" after each run.
+type taskRecord struct {
+	LastRun   time.Time `json:"lastRun"`
+	TookNanos int64     `json:"tookNanos"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// A TaskHandle controls a registered task.
+type TaskHandle struct {
+	st *scheduledTask
+}
+
+// Wake requests that the task run as soon as possible, without waiting
+// for the rest of its current interval. It does not block; if the task
+// is already running, it will run once more after the current run.
+func (h *TaskHandle) Wake() {
+	select {
+	case h.st.wakec <- true:
+	default:
+	}
+}
+
+// A Scheduler runs registered Tasks at their intervals. Most callers
+// use the package-level RegisterTask and friends, which operate on a
+// shared scheduler, since handlers are built by constructor functions
+// with no access to a common object.
+type Scheduler struct {
+	gate *syncutil.Gate
+
+	mu    sync.Mutex
+	tasks map[string]*scheduledTask
+	kv    sorted.KeyValue
+	wg    sync.WaitGroup
+}
+
+type scheduledTask struct {
+	s     *Scheduler
+	task  Task
+	wakec chan bool
+	stopc chan struct{}
+
+	mu       sync.Mutex
+	running  bool
+	ctx      *context.Context // non-nil while running
+	lastRun  time.Time
+	lastTook time.Duration
+	lastErr  error
+}
+
+// NewScheduler returns a Scheduler running at most maxConcurrent tasks
+// at once. Task history is kept in an in-memory sorted.KeyValue until
+// SetStorage replaces it.
+func NewScheduler(maxConcurrent int) *Scheduler {
+	return &Scheduler{
+		gate:  syncutil.NewGate(maxConcurrent),
+		tasks: make(map[string]*scheduledTask),
+		kv:    sorted.NewMemoryKeyValue(),
+	}
+}
+
+// SetStorage sets the sorted.KeyValue recording each task's last run
+// time, duration, and error, so the history can survive restarts.
+// It's optional; the default is in-memory only.
+func (s *Scheduler) SetStorage(kv sorted.KeyValue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kv = kv
+}
+
+// Register adds t to the scheduler and starts its timer loop. If a
+// task named t.Name is already registered, it is stopped and replaced.
+func (s *Scheduler) Register(t Task) *TaskHandle {
+	if t.Name == "" || t.Run == nil || t.Interval <= 0 {
+		panic("serverinit: invalid Task registration")
+	}
+	st := &scheduledTask{
+		s:     s,
+		task:  t,
+		wakec: make(chan bool, 1),
+		stopc: make(chan struct{}),
+	}
+	s.mu.Lock()
+	if old, ok := s.tasks[t.Name]; ok {
+		old.stop()
+	}
+	s.tasks[t.Name] = st
+	if rec, ok := s.loadRecord(t.Name); ok {
+		st.lastRun = rec.LastRun
+		st.lastTook = time.Duration(rec.TookNanos)
+		if rec.LastError != "" {
+			st.lastErr = fmt.Errorf("%s", rec.LastError)
+		}
+	}
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go st.loop()
+	return &TaskHandle{st: st}
+}
+
+// RunNow requests an immediate run of the named task.
+func (s *Scheduler) RunNow(name string) error {
+	s.mu.Lock()
+	st, ok := s.tasks[name]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("serverinit: no scheduled task %q", name)
+	}
+	(&TaskHandle{st: st}).Wake()
+	return nil
+}
+
+// Statuses returns the status of all registered tasks, sorted by name.
+func (s *Scheduler) Statuses() []TaskStatus {
+	s.mu.Lock()
+	tasks := make([]*scheduledTask, 0, len(s.tasks))
+	for _, st := range s.tasks {
+		tasks = append(tasks, st)
+	}
+	s.mu.Unlock()
+	sts := make([]TaskStatus, 0, len(tasks))
+	for _, st := range tasks {
+		sts = append(sts, st.status())
+	}
+	sort.Sort(byTaskName(sts))
+	return sts
+}
+
+type byTaskName []TaskStatus
+
+func (s byTaskName) Len() int           { return len(s) }
+func (s byTaskName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s byTaskName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Close stops all tasks, cancels the contexts of any that are running,
+// and waits for them to finish. The scheduler accepts new
+// registrations again afterwards.
+func (s *Scheduler) Close() error {
+	s.mu.Lock()
+	for name, st := range s.tasks {
+		st.stop()
+		delete(s.tasks, name)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// loadRecord returns the stored record for the named task, if any.
+// s.mu must be held.
+func (s *Scheduler) loadRecord(name string) (rec taskRecord, ok bool) {
+	v, err := s.kv.Get("task:" + name)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal([]byte(v), &rec); err != nil {
+		return
+	}
+	return rec, true
+}
+
+func (s *Scheduler) saveRecord(name string, rec taskRecord) {
+	v, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	kv := s.kv
+	s.mu.Unlock()
+	if err := kv.Set("task:"+name, string(v)); err != nil {
+		serverLog.Printf("error recording run of task %q: %v", name, err)
+	}
+}
+
+func (st *scheduledTask) stop() {
+	close(st.stopc)
+	st.mu.Lock()
+	if st.ctx != nil {
+		st.ctx.Cancel()
+	}
+	st.mu.Unlock()
+}
+
+func (st *scheduledTask) loop() {
+	defer st.s.wg.Done()
+	for {
+		d := st.task.Interval
+		if j := st.task.Jitter; j > 0 {
+			d += time.Duration(rand.Int63n(int64(j)))
+		}
+		select {
+		case <-time.After(d):
+		case <-st.wakec:
+		case <-st.stopc:
+			return
+		}
+		select {
+		case <-st.stopc:
+			return
+		default:
+		}
+		st.run()
+	}
+}
+
+func (st *scheduledTask) run() {
+	st.s.gate.Start()
+	defer st.s.gate.Done()
+	ctx := context.New()
+	st.mu.Lock()
+	st.running = true
+	st.ctx = ctx
+	st.mu.Unlock()
+
+	t0 := time.Now()
+	err := st.task.Run(ctx)
+	took := time.Since(t0)
+	ctx.Cancel()
+
+	st.mu.Lock()
+	st.running = false
+	st.ctx = nil
+	st.lastRun = t0
+	st.lastTook = took
+	st.lastErr = err
+	st.mu.Unlock()
+	if err != nil {
+		serverLog.Printf("scheduled task %q: %v", st.task.Name, err)
+	}
+	rec := taskRecord{LastRun: t0, TookNanos: int64(took)}
+	if err != nil {
+		rec.LastError = err.Error()
+	}
+	st.s.saveRecord(st.task.Name, rec)
+}
+
+func (st *scheduledTask) status() TaskStatus {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ts := TaskStatus{
+		Name:     st.task.Name,
+		Interval: st.task.Interval.String(),
+		Running:  st.running,
+	}
+	if !st.lastRun.IsZero() {
+		ts.LastRun = st.lastRun.UTC().Format(time.RFC3339)
+		ts.LastRunTook = st.lastTook.String()
+	}
+	if st.lastErr != nil {
+		ts.LastError = st.lastErr.Error()
+	}
+	return ts
+}
+
+// taskScheduler is the shared scheduler used by the package-level task
+// functions.
+var taskScheduler = NewScheduler(maxConcurrentTasks)
+
+// RegisterTask adds t to the shared scheduler. See Scheduler.Register.
+func RegisterTask(t Task) *TaskHandle { return taskScheduler.Register(t) }
+
+// RunTaskNow requests an immediate run of the named task on the shared
+// scheduler.
+func RunTaskNow(name string) error { return taskScheduler.RunNow(name) }
+
+// TaskStatuses returns the status of all tasks registered with the
+// shared scheduler, sorted by name.
+func TaskStatuses() []TaskStatus { return taskScheduler.Statuses() }
@@ -0,0 +1,94 @@
+/*
+Copyright 2014 The Camlistore Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serverinit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"camlistore.org/pkg/context"
+)
+
+func TestScheduler(t *testing.T) {
+	s := NewScheduler(2)
+	defer s.Close()
+
+	ran := make(chan bool, 1)
+	h := s.Register(Task{
+		Name:     "test-task",
+		Interval: time.Hour, // only runs when woken
+		Run: func(ctx *context.Context) error {
+			ran <- true
+			return errors.New("task boom")
+		},
+	})
+	h.Wake()
+	select {
+	case <-ran:
+	case <-time.After(10 * time.Second):
+		t.Fatal("task didn't run after Wake")
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		sts := s.Statuses()
+		if len(sts) != 1 {
+			t.Fatalf("got %d task statuses; want 1", len(sts))
+		}
+		ts := sts[0]
+		if ts.LastRun != "" && !ts.Running {
+			if ts.Name != "test-task" {
+				t.Errorf("task name = %q; want %q", ts.Name, "test-task")
+			}
+			if ts.LastError != "task boom" {
+				t.Errorf("task last error = %q; want %q", ts.LastError, "task boom")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task never recorded a completed run; last status: %+v", ts)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := s.RunNow("test-task"); err != nil {
+		t.Errorf("RunNow(test-task) = %v; want nil", err)
+	}
+	if err := s.RunNow("nope"); err == nil {
+		t.Error("RunNow of unknown task succeeded; want error")
+	}
+}
+
+func TestSchedulerReplaceTask(t *testing.T) {
+	s := NewScheduler(2)
+	defer s.Close()
+
+	s.Register(Task{
+		Name:     "dup",
+		Interval: time.Hour,
+		Run:      func(ctx *context.Context) error { return nil },
+	})
+	s.Register(Task{
+		Name:     "dup",
+		Interval: time.Hour,
+		Run:      func(ctx *context.Context) error { return nil },
+	})
+	if got := len(s.Statuses()); got != 1 {
+		t.Errorf("got %d tasks after duplicate registration; want 1", got)
+	}
+}
@@ -35,10 +35,13 @@ import (
 	rpprof "runtime/pprof"
 	"strconv"
 	"strings"
+	"time"
 
 	"camlistore.org/pkg/auth"
 	"camlistore.org/pkg/blobserver"
 	"camlistore.org/pkg/blobserver/handlers"
+	"camlistore.org/pkg/blobserver/instrument"
+	"camlistore.org/pkg/camlog"
 	"camlistore.org/pkg/httputil"
 	"camlistore.org/pkg/index"
 	"camlistore.org/pkg/jsonconfig"
@@ -50,6 +53,10 @@ const camliPrefix = "/camli/"
 
 var ErrCamliPath = errors.New("Invalid Camlistore request path")
 
+// serverLog is the "serverinit" logging subsystem. Its level can be
+// raised to "debug" in the config or via /debug/logging.
+var serverLog = camlog.New("serverinit")
+
 type handlerConfig struct {
 	prefix   string         // "/foo/"
 	htype    string         // "localdisk", etc
@@ -68,6 +75,7 @@ type handlerLoader struct {
 	closers     []io.Closer
 	prefixStack []string
 	reindex     bool
+	slowOp      time.Duration // latency instrumentation slow-op logging threshold; 0 means no logging
 
 	// optional context (for App Engine, the first request that
 	// started up the process).  we may need this if setting up
@@ -118,6 +126,9 @@ func (s *storageAndConfig) GetStorage() blobserver.Storage {
 func camliHandlerUsingStorage(req *http.Request, action string, storage blobserver.StorageConfiger) (http.Handler, auth.Operation) {
 	var handler http.Handler
 	op := auth.OpAll
+	if action == "resumable-upload" || strings.HasPrefix(action, "resumable-upload/") {
+		return handlers.CreateResumableUploadHandler(storage), auth.OpUpload
+	}
 	switch req.Method {
 	case "GET", "HEAD":
 		switch action {
@@ -178,7 +189,7 @@ func makeCamliHandler(prefix, baseURL string, storage blobserver.Storage, hf blo
 	return http.HandlerFunc(func(conn http.ResponseWriter, req *http.Request) {
 		action, err := parseCamliPath(req.URL.Path[len(prefix)-1:])
 		if err != nil {
-			log.Printf("Invalid request for method %q, path %q",
+			serverLog.Debugf("Invalid request for method %q, path %q",
 				req.Method, req.URL.Path)
 			unsupportedHandler(conn, req)
 			return
@@ -317,16 +328,21 @@ func (hl *handlerLoader) setupHandler(prefix string) {
 				h.prefix, stype, err)
 		}
 		if ix, ok := pstorage.(*index.Index); ok && hl.reindex {
-			log.Printf("Reindexing %s ...", h.prefix)
+			serverLog.Printf("Reindexing %s ...", h.prefix)
 			if err := ix.Reindex(); err != nil {
 				exitFailure("Error reindexing %s: %v", h.prefix, err)
 			}
 		}
+		// Record per-operation latencies. The index is left
+		// unwrapped: its users type-assert on *index.Index.
+		if _, isIndex := pstorage.(*index.Index); !isIndex {
+			pstorage = instrument.NewStorage(h.prefix, pstorage, hl.slowOp)
+		}
 		hl.handler[h.prefix] = pstorage
 		if h.internal {
 			hl.installer.Handle(prefix, unauthorizedHandler{})
 		} else {
-			hl.installer.Handle(prefix+"camli/", makeCamliHandler(prefix, hl.baseURL, pstorage, hl))
+			hl.installer.Handle(prefix+"camli/", httputil.RecoverHandler(prefix, makeCamliHandler(prefix, hl.baseURL, pstorage, hl)))
 		}
 		if cl, ok := pstorage.(blobserver.ShutdownStorage); ok {
 			hl.closers = append(hl.closers, cl)
@@ -365,6 +381,9 @@ func (hl *handlerLoader) setupHandler(prefix string) {
 		if handlerTypeWantsAuth(h.htype) {
 			wrappedHandler = auth.Handler{wrappedHandler}
 		}
+		// Outermost, so a panic anywhere below (including in
+		// auth) only kills this request.
+		wrappedHandler = httputil.RecoverHandler(prefix, wrappedHandler)
 	}
 	hl.installer.Handle(prefix, wrappedHandler)
 }
@@ -413,6 +432,14 @@ func detectConfigChange(conf jsonconfig.Obj) error {
 // with boolean value true, the configuration is assumed to be a high-level
 // "user config" file, and transformed into a low-level config.
 func Load(filename string) (*Config, error) {
+	return LoadWithRole(filename, "")
+}
+
+// LoadWithRole is like Load, but when role is non-empty and the
+// high-level config has a "roles" section, only the prefixes assigned
+// to that role are kept, with references to other roles' prefixes
+// rewritten to reach them over HTTP at their baseURL.
+func LoadWithRole(filename, role string) (*Config, error) {
 	obj, err := jsonconfig.ReadFile(filename)
 	if err != nil {
 		return nil, err
@@ -423,6 +450,9 @@ func Load(filename string) (*Config, error) {
 	}
 
 	if lowLevel := obj.OptionalBool("handlerConfig", false); lowLevel {
+		if role != "" {
+			return nil, fmt.Errorf("--role is only supported with a high-level config file; %s is a low-level one", filename)
+		}
 		return conf, nil
 	}
 
@@ -442,6 +472,16 @@ func Load(filename string) (*Config, error) {
 	if err := json.Unmarshal(b, &hiLevelConf); err != nil {
 		return nil, fmt.Errorf("Could not unmarshal %s into a serverconfig.Config: %v", absConfigPath, err)
 	}
+	if !hiLevelConf.AllowUnknownFields {
+		unknown, err := serverconfig.UnknownFields(b)
+		if err != nil {
+			return nil, fmt.Errorf("Could not check %s for unknown config keys: %v", absConfigPath, err)
+		}
+		if len(unknown) > 0 {
+			return nil, fmt.Errorf("%s: %s (to ignore, set \"allowUnknownFields\": true)",
+				absConfigPath, strings.Join(unknown, "; "))
+		}
+	}
 
 	conf, err = genLowLevelConfig(&hiLevelConf)
 	if err != nil {
@@ -449,9 +489,12 @@ func Load(filename string) (*Config, error) {
 			"Failed to transform user config file %q into internal handler configuration: %v",
 			filename, err)
 	}
+	if err := applyRole(&hiLevelConf, role, conf); err != nil {
+		return nil, fmt.Errorf("Failed to apply roles from %q: %v", filename, err)
+	}
 	if v, _ := strconv.ParseBool(os.Getenv("CAMLI_DEBUG_CONFIG")); v {
 		jsconf, _ := json.MarshalIndent(conf.Obj, "", "  ")
-		log.Printf("From high-level config, generated low-level config: %s", jsconf)
+		serverLog.Debugf("From high-level config, generated low-level config: %s", jsconf)
 	}
 	conf.configPath = absConfigPath
 
@@ -478,7 +521,7 @@ func (config *Config) checkValidAuth() error {
 func (config *Config) InstallHandlers(hi HandlerInstaller, baseURL string, reindex bool, context *http.Request) (shutdown io.Closer, err error) {
 	defer func() {
 		if e := recover(); e != nil {
-			log.Printf("Caught panic installer handlers: %v", e)
+			serverLog.Printf("Caught panic installer handlers: %v", e)
 			err = fmt.Errorf("Caught panic: %v", e)
 		}
 	}()
@@ -487,9 +530,28 @@ func (config *Config) InstallHandlers(hi HandlerInstaller, baseURL string, reind
 		return nil, fmt.Errorf("error while configuring auth: %v", err)
 	}
 	prefixes := config.RequiredObject("prefixes")
+	slowOpStr := config.OptionalString("slowOpThreshold", "")
+	loggingConf := config.OptionalObject("logging")
 	if err := config.Validate(); err != nil {
 		return nil, fmt.Errorf("configuration error in root object's keys: %v", err)
 	}
+	var slowOp time.Duration
+	if slowOpStr != "" {
+		if slowOp, err = time.ParseDuration(slowOpStr); err != nil {
+			return nil, fmt.Errorf("invalid slowOpThreshold %q: %v", slowOpStr, err)
+		}
+	}
+	for sub, vei := range loggingConf {
+		levelStr, ok := vei.(string)
+		if !ok {
+			return nil, fmt.Errorf(`logging level for subsystem %q is a %T; expected a string`, sub, vei)
+		}
+		level, err := camlog.ParseLevel(levelStr)
+		if err != nil {
+			return nil, fmt.Errorf(`in "logging", subsystem %q: %v`, sub, err)
+		}
+		camlog.SetLevel(sub, level)
+	}
 
 	if v := os.Getenv("CAMLI_PPROF_START"); v != "" {
 		cpuf := mustCreate(v + ".cpu")
@@ -508,6 +570,7 @@ func (config *Config) InstallHandlers(hi HandlerInstaller, baseURL string, reind
 		handler:   make(map[string]interface{}),
 		context:   context,
 		reindex:   reindex,
+		slowOp:    slowOp,
 	}
 
 	for prefix, vei := range prefixes {
@@ -566,6 +629,11 @@ func (config *Config) InstallHandlers(hi HandlerInstaller, baseURL string, reind
 	if v, _ := strconv.ParseBool(os.Getenv("CAMLI_HTTP_PPROF")); v {
 		hi.Handle("/debug/pprof/", profileHandler{})
 	}
+	hi.Handle("/debug/logging", auth.RequireAuth(camlog.Handler(), auth.OpAll))
+
+	// Handlers' InitHandler methods may have registered periodic
+	// tasks; stop those too on shutdown.
+	hl.closers = append(hl.closers, taskScheduler)
 	return multiCloser(hl.closers), nil
 }
 
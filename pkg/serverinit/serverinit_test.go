@@ -26,6 +26,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strings"
 	"testing"
@@ -33,6 +34,7 @@ import (
 	"camlistore.org/pkg/jsonconfig"
 	"camlistore.org/pkg/serverinit"
 	"camlistore.org/pkg/test"
+	"camlistore.org/pkg/types"
 	"camlistore.org/pkg/types/serverconfig"
 )
 
@@ -52,7 +54,11 @@ const (
 func init() {
 	// Avoid Linux vs. OS X differences in tests.
 	serverinit.SetTempDirFunc(func() string { return "/tmp" })
+	serverinit.SetVarDirFunc(func() string { return "/camli-var" })
 	serverinit.SetNoMkdir(true)
+	// Pretend we're on GCE, so the testdata configs using GCE
+	// metadata-service credentials generate instead of failing.
+	serverinit.SetOnGCEFunc(func() bool { return true })
 }
 
 func sortedKeys(m map[string]interface{}) (keys []string) {
@@ -93,6 +99,710 @@ func TestConfigs(t *testing.T) {
 	}
 }
 
+// TestGCSAuthOffGCE tests that GCE metadata-service credentials for
+// Google Cloud Storage are refused when we're not running on GCE.
+func TestGCSAuthOffGCE(t *testing.T) {
+	serverinit.SetOnGCEFunc(func() bool { return false })
+	defer serverinit.SetOnGCEFunc(func() bool { return true })
+	ringPath, err := filepath.Abs(relativeRing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &serverconfig.Config{
+		Listen:             serverconfig.Listen{Addr: "localhost:3179"},
+		Auth:               "userpass:camlistore:pass3179",
+		Identity:           "26F5ABDA",
+		IdentitySecretRing: ringPath,
+		KVFile:             "/path/to/indexkv.db",
+		GoogleCloudStorage: &serverconfig.GoogleCloudStorage{Legacy: ":bucketName"},
+	}
+	_, err = serverinit.GenLowLevelConfig(conf)
+	if err == nil || !strings.Contains(err.Error(), "running on Google Compute Engine") {
+		t.Errorf("GenLowLevelConfig = %v; want error about not running on Google Compute Engine", err)
+	}
+}
+
+// TestDBNameFallback tests the index database name fallback order:
+// the per-indexer override, then "dbname", then "camli" + username.
+func TestDBNameFallback(t *testing.T) {
+	ringPath, err := filepath.Abs(relativeRing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("USER", os.Getenv("USER"))
+	os.Setenv("USER", "tester")
+
+	newConf := func() *serverconfig.Config {
+		return &serverconfig.Config{
+			Listen:             serverconfig.Listen{Addr: "localhost:3179"},
+			Auth:               "userpass:camlistore:pass3179",
+			Identity:           "26F5ABDA",
+			IdentitySecretRing: ringPath,
+			BlobPath:           "/path/to/blobs",
+			MySQL:              &serverconfig.DBConnection{Legacy: "foo@localhost:bar"},
+		}
+	}
+	indexDB := func(conf *serverconfig.Config) string {
+		lowLevelConf, err := serverinit.GenLowLevelConfig(conf)
+		if err != nil {
+			t.Fatalf("GenLowLevelConfig: %v", err)
+		}
+		prefixes := lowLevelConf.Obj["prefixes"].(map[string]interface{})
+		args := prefixes["/index/"].(map[string]interface{})["handlerArgs"].(map[string]interface{})
+		db, _ := args["storage"].(map[string]interface{})["database"].(string)
+		return db
+	}
+
+	// No dbname anywhere: fall back to "camli" + username.
+	if got, want := indexDB(newConf()), "camlitester"; got != want {
+		t.Errorf("default database = %q; want %q", got, want)
+	}
+
+	// "dbname" beats the username default.
+	conf := newConf()
+	conf.DBName = "camliprod"
+	if got, want := indexDB(conf), "camliprod"; got != want {
+		t.Errorf(`database with "dbname" = %q; want %q`, got, want)
+	}
+
+	// The per-indexer override beats "dbname".
+	conf = newConf()
+	conf.DBName = "camliprod"
+	conf.MySQLDBName = "camliprodindex"
+	if got, want := indexDB(conf), "camliprodindex"; got != want {
+		t.Errorf(`database with "mysqlDBName" = %q; want %q`, got, want)
+	}
+
+	// An override for an indexer that isn't selected is refused.
+	conf = newConf()
+	conf.MySQL = nil
+	conf.KVFile = "/path/to/indexkv.db"
+	conf.MySQLDBName = "camliprodindex"
+	_, err = serverinit.GenLowLevelConfig(conf)
+	if err == nil || !strings.Contains(err.Error(), `"mysqlDBName" requires "mysql"`) {
+		t.Errorf(`GenLowLevelConfig with stray "mysqlDBName" = %v; want error mentioning it`, err)
+	}
+	conf = newConf()
+	conf.MongoDBName = "camlimongo"
+	_, err = serverinit.GenLowLevelConfig(conf)
+	if err == nil || !strings.Contains(err.Error(), `"mongoDBName" requires "mongo"`) {
+		t.Errorf(`GenLowLevelConfig with stray "mongoDBName" = %v; want error mentioning it`, err)
+	}
+}
+
+// TestMemoryStorageIndex tests the "memoryStorage" throwaway index:
+// /index/ is of type "memory" with a warning that it's rebuilt on
+// every start, and the sync handler stays an idle stub instead of
+// requiring a queue directory. "memoryIndex" (the search handler's
+// slurpToMemory) is a separate concept and composes with it.
+func TestMemoryStorageIndex(t *testing.T) {
+	ringPath, err := filepath.Abs(relativeRing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConf := func() *serverconfig.Config {
+		return &serverconfig.Config{
+			Listen:             serverconfig.Listen{Addr: "localhost:3179"},
+			Auth:               "userpass:camlistore:pass3179",
+			Identity:           "26F5ABDA",
+			IdentitySecretRing: ringPath,
+			BlobPath:           "/path/to/blobs",
+			MemoryStorage:      true,
+		}
+	}
+	genPrefixes := func(conf *serverconfig.Config) map[string]interface{} {
+		lowLevelConf, err := serverinit.GenLowLevelConfig(conf)
+		if err != nil {
+			t.Fatalf("GenLowLevelConfig: %v", err)
+		}
+		return lowLevelConf.Obj["prefixes"].(map[string]interface{})
+	}
+	handlerArgs := func(prefixes map[string]interface{}, prefix string) map[string]interface{} {
+		return prefixes[prefix].(map[string]interface{})["handlerArgs"].(map[string]interface{})
+	}
+
+	prefixes := genPrefixes(newConf())
+	indexArgs := handlerArgs(prefixes, "/index/")
+	if typ, _ := indexArgs["storage"].(map[string]interface{})["type"].(string); typ != "memory" {
+		t.Errorf("index storage type = %q; want \"memory\"", typ)
+	}
+	if warn, _ := indexArgs["_warning"].(string); !strings.Contains(warn, "rebuilt from /bs/") {
+		t.Errorf(`index "_warning" = %q; want a rebuilt-on-start warning`, warn)
+	}
+	syncArgs := handlerArgs(prefixes, "/sync/")
+	if idle, _ := syncArgs["idle"].(bool); !idle {
+		t.Errorf("sync handler idle = %v; want true", syncArgs["idle"])
+	}
+	if q, ok := syncArgs["queue"]; ok {
+		t.Errorf("sync handler has queue %v; want none", q)
+	}
+	// memoryIndex defaults to on, independently of memoryStorage.
+	if slurp, _ := handlerArgs(prefixes, "/my-search/")["slurpToMemory"].(bool); !slurp {
+		t.Error("slurpToMemory not set with the default memoryIndex")
+	}
+
+	// Disabling memoryIndex only affects the search handler; the
+	// index storage stays in memory.
+	conf := newConf()
+	conf.MemoryIndex = types.InvertedBool(true) // logically false
+	prefixes = genPrefixes(conf)
+	if _, ok := handlerArgs(prefixes, "/my-search/")["slurpToMemory"]; ok {
+		t.Error("slurpToMemory set despite memoryIndex being disabled")
+	}
+	if typ, _ := handlerArgs(prefixes, "/index/")["storage"].(map[string]interface{})["type"].(string); typ != "memory" {
+		t.Errorf("index storage type = %q; want \"memory\"", typ)
+	}
+
+	// memoryStorage counts as an indexer, so it can't be combined
+	// with another one.
+	conf = newConf()
+	conf.KVFile = "/path/to/indexkv.db"
+	_, err = serverinit.GenLowLevelConfig(conf)
+	if err == nil || !strings.Contains(err.Error(), "exactly one indexer") {
+		t.Errorf("GenLowLevelConfig with memoryStorage and kvIndexFile = %v; want error about picking one indexer", err)
+	}
+}
+
+func TestCacheDir(t *testing.T) {
+	ringPath, err := filepath.Abs(relativeRing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConf := func(cacheDir string) *serverconfig.Config {
+		return &serverconfig.Config{
+			Listen:             serverconfig.Listen{Addr: "localhost:3179"},
+			Auth:               "userpass:camlistore:pass3179",
+			Identity:           "26F5ABDA",
+			IdentitySecretRing: ringPath,
+			BlobPath:           "/path/to/blobs",
+			KVFile:             "/path/to/indexkv.db",
+			CacheDir:           cacheDir,
+		}
+	}
+	cachePath := func(conf *serverconfig.Config) string {
+		lowLevelConf, err := serverinit.GenLowLevelConfig(conf)
+		if err != nil {
+			t.Fatalf("GenLowLevelConfig: %v", err)
+		}
+		prefixes := lowLevelConf.Obj["prefixes"].(map[string]interface{})
+		args := prefixes["/cache/"].(map[string]interface{})["handlerArgs"].(map[string]interface{})
+		path, _ := args["path"].(string)
+		return path
+	}
+
+	// Default: the cache lives under blobPath.
+	if got, want := cachePath(newConf("")), filepath.Join("/path/to/blobs", "cache"); got != want {
+		t.Errorf("default cache path = %q; want %q", got, want)
+	}
+
+	// An explicit cacheDir overrides it.
+	if got, want := cachePath(newConf("/ssd/camli-cache")), "/ssd/camli-cache"; got != want {
+		t.Errorf("cache path with cacheDir = %q; want %q", got, want)
+	}
+
+	// A relative cacheDir is rejected.
+	_, err = serverinit.GenLowLevelConfig(newConf("relative/cache"))
+	if err == nil || !strings.Contains(err.Error(), `"cacheDir" must be an absolute path`) {
+		t.Errorf("GenLowLevelConfig with a relative cacheDir = %v; want an absolute-path error", err)
+	}
+}
+
+func TestThumbCache(t *testing.T) {
+	ringPath, err := filepath.Abs(relativeRing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConf := func(local bool, tc *serverconfig.ThumbCache) *serverconfig.Config {
+		conf := &serverconfig.Config{
+			Listen:             serverconfig.Listen{Addr: "localhost:3179"},
+			Auth:               "userpass:camlistore:pass3179",
+			Identity:           "26F5ABDA",
+			IdentitySecretRing: ringPath,
+			KVFile:             "/path/to/indexkv.db",
+			ThumbCache:         tc,
+		}
+		if local {
+			conf.BlobPath = "/path/to/blobs"
+		} else {
+			conf.S3 = &serverconfig.S3{
+				AccessKey: "key",
+				SecretKey: "secret",
+				Bucket:    "bucket",
+			}
+		}
+		return conf
+	}
+	scaledImage := func(conf *serverconfig.Config) map[string]interface{} {
+		lowLevelConf, err := serverinit.GenLowLevelConfig(conf)
+		if err != nil {
+			t.Fatalf("GenLowLevelConfig: %v", err)
+		}
+		prefixes := lowLevelConf.Obj["prefixes"].(map[string]interface{})
+		args := prefixes["/ui/"].(map[string]interface{})["handlerArgs"].(map[string]interface{})
+		si, _ := args["scaledImage"].(map[string]interface{})
+		return si
+	}
+
+	// Default with local blobs: a kv file under blobPath.
+	got := scaledImage(newConf(true, nil))
+	want := map[string]interface{}{
+		"type": "kv",
+		"file": filepath.Join("/path/to/blobs", "thumbmeta.kv"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("local-disk scaledImage = %v; want %v", got, want)
+	}
+
+	// Default with cloud-only blobs: a kv file under the var dir,
+	// not no meta store at all. (varDir is stubbed in init.)
+	got = scaledImage(newConf(false, nil))
+	want = map[string]interface{}{
+		"type": "kv",
+		"file": filepath.Join("/camli-var", "thumbmeta.kv"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cloud-primary scaledImage = %v; want %v", got, want)
+	}
+
+	// An explicit thumbCache wins, whatever the primary storage.
+	got = scaledImage(newConf(true, &serverconfig.ThumbCache{Type: "leveldb", File: "/ssd/thumbmeta.ldb"}))
+	want = map[string]interface{}{
+		"type": "leveldb",
+		"file": "/ssd/thumbmeta.ldb",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("explicit scaledImage = %v; want %v", got, want)
+	}
+	got = scaledImage(newConf(false, &serverconfig.ThumbCache{Type: "memory"}))
+	want = map[string]interface{}{
+		"type": "memory",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("memory scaledImage = %v; want %v", got, want)
+	}
+
+	// Bad thumbCache configs are rejected.
+	for _, tt := range []struct {
+		tc      *serverconfig.ThumbCache
+		wantErr string
+	}{
+		{&serverconfig.ThumbCache{Type: "mysql", File: "/x"}, `unknown "thumbCache" type`},
+		{&serverconfig.ThumbCache{Type: "memory", File: "/x"}, `takes no "file"`},
+		{&serverconfig.ThumbCache{Type: "sqlite"}, `requires a "file"`},
+	} {
+		_, err := serverinit.GenLowLevelConfig(newConf(true, tt.tc))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("GenLowLevelConfig with thumbCache %+v = %v; want error containing %q", tt.tc, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAutocert(t *testing.T) {
+	ringPath, err := filepath.Abs(relativeRing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConf := func(baseURL, httpsKey string) *serverconfig.Config {
+		return &serverconfig.Config{
+			Listen:             serverconfig.Listen{Addr: "localhost:3179"},
+			Auth:               "userpass:camlistore:pass3179",
+			Identity:           "26F5ABDA",
+			IdentitySecretRing: ringPath,
+			BlobPath:           "/path/to/blobs",
+			KVFile:             "/path/to/indexkv.db",
+			HTTPS:              true,
+			HTTPSCert:          "auto",
+			HTTPSKey:           httpsKey,
+			BaseURL:            baseURL,
+		}
+	}
+
+	for _, baseURL := range []string{"https://foo.example.com", "https://foo.example.com:443"} {
+		lowLevelConf, err := serverinit.GenLowLevelConfig(newConf(baseURL, ""))
+		if err != nil {
+			t.Fatalf("GenLowLevelConfig with baseURL %q: %v", baseURL, err)
+		}
+		obj := lowLevelConf.Obj
+		if got, want := obj["httpsCert"], "auto"; got != want {
+			t.Errorf("with baseURL %q, httpsCert = %v; want %v", baseURL, got, want)
+		}
+		if got, want := obj["autocertDomain"], "foo.example.com"; got != want {
+			t.Errorf("with baseURL %q, autocertDomain = %v; want %v", baseURL, got, want)
+		}
+		if got, want := obj["autocertCacheDir"], filepath.Join("/camli-var", "letsencrypt"); got != want {
+			t.Errorf("with baseURL %q, autocertCacheDir = %v; want %v", baseURL, got, want)
+		}
+		if _, ok := obj["httpsKey"]; ok {
+			t.Errorf("with baseURL %q, unexpected httpsKey %v", baseURL, obj["httpsKey"])
+		}
+	}
+
+	for _, tt := range []struct {
+		baseURL  string
+		httpsKey string
+		wantErr  string
+	}{
+		{"", "", `requires a "baseURL"`},
+		{"https://foo.example.com:8443", "", "has port 8443"},
+		{"https://10.0.0.1", "", `can't get an ACME certificate for "10.0.0.1"`},
+		{"https://localhost", "", `can't get an ACME certificate for "localhost"`},
+		{"https://foo.example.com", "/path/to/tls.key", `"httpsKey" must be empty`},
+	} {
+		_, err := serverinit.GenLowLevelConfig(newConf(tt.baseURL, tt.httpsKey))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf(`GenLowLevelConfig with "auto" cert, baseURL %q, httpsKey %q = %v; want error containing %q`, tt.baseURL, tt.httpsKey, err, tt.wantErr)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	ringPath, err := filepath.Abs(relativeRing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConf := func(readOnly bool) *serverconfig.Config {
+		return &serverconfig.Config{
+			Listen:             serverconfig.Listen{Addr: "localhost:3179"},
+			Auth:               "userpass:camlistore:pass3179",
+			Identity:           "26F5ABDA",
+			IdentitySecretRing: ringPath,
+			BlobPath:           "/path/to/blobs",
+			KVFile:             "/path/to/indexkv.db",
+			ReadOnly:           readOnly,
+		}
+	}
+	lowLevelConf, err := serverinit.GenLowLevelConfig(newConf(true))
+	if err != nil {
+		t.Fatalf("GenLowLevelConfig: %v", err)
+	}
+	if got, want := lowLevelConf.Obj["readonly"], true; got != want {
+		t.Errorf("readonly = %v; want %v", got, want)
+	}
+	lowLevelConf, err = serverinit.GenLowLevelConfig(newConf(false))
+	if err != nil {
+		t.Fatalf("GenLowLevelConfig: %v", err)
+	}
+	if _, ok := lowLevelConf.Obj["readonly"]; ok {
+		t.Errorf("unexpected readonly key %v in default config", lowLevelConf.Obj["readonly"])
+	}
+}
+
+// TestHTTPRedirect tests the high-level "httpRedirect" key, which
+// generates a low-level redirect block answering plain HTTP with a
+// permanent redirect to the server's HTTPS URL.
+func TestHTTPRedirect(t *testing.T) {
+	ringPath, err := filepath.Abs(relativeRing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConf := func(https bool, listen, baseURL string) *serverconfig.Config {
+		return &serverconfig.Config{
+			Listen:             serverconfig.Listen{Addr: listen},
+			HTTPS:              https,
+			HTTPSCert:          "/path/to/tls.crt",
+			HTTPSKey:           "/path/to/tls.key",
+			HTTPRedirect:       ":80",
+			BaseURL:            baseURL,
+			Auth:               "userpass:camlistore:pass3179",
+			Identity:           "26F5ABDA",
+			IdentitySecretRing: ringPath,
+			BlobPath:           "/path/to/blobs",
+			KVFile:             "/path/to/indexkv.db",
+		}
+	}
+
+	tests := []struct {
+		name       string
+		conf       *serverconfig.Config
+		wantTarget string
+	}{
+		{
+			name:       "target from baseURL",
+			conf:       newConf(true, "localhost:3179", "https://foo.example.com"),
+			wantTarget: "https://foo.example.com",
+		},
+		{
+			name:       "target from listen host and port",
+			conf:       newConf(true, "foo.example.com:3179", ""),
+			wantTarget: "https://foo.example.com:3179",
+		},
+		{
+			name:       "port 443 elided",
+			conf:       newConf(true, "foo.example.com:443", ""),
+			wantTarget: "https://foo.example.com",
+		},
+	}
+	for _, tt := range tests {
+		lowLevelConf, err := serverinit.GenLowLevelConfig(tt.conf)
+		if err != nil {
+			t.Errorf("%s: GenLowLevelConfig: %v", tt.name, err)
+			continue
+		}
+		redirect, ok := lowLevelConf.Obj["httpRedirect"].(map[string]interface{})
+		if !ok {
+			t.Errorf("%s: no httpRedirect block in generated config", tt.name)
+			continue
+		}
+		if got, want := redirect["listen"], ":80"; got != want {
+			t.Errorf("%s: httpRedirect listen = %v; want %v", tt.name, got, want)
+		}
+		if got := redirect["target"]; got != tt.wantTarget {
+			t.Errorf("%s: httpRedirect target = %v; want %v", tt.name, got, tt.wantTarget)
+		}
+	}
+
+	errTests := []struct {
+		name      string
+		conf      *serverconfig.Config
+		wantError string
+	}{
+		{
+			name:      "https off",
+			conf:      newConf(false, "localhost:3179", ""),
+			wantError: `"httpRedirect" requires "https"`,
+		},
+		{
+			name:      "redirect address is the listen address",
+			conf:      newConf(true, ":80", "https://foo.example.com"),
+			wantError: `"httpRedirect" address ":80" is already the "listen" address`,
+		},
+		{
+			name:      "no baseURL and no listen host",
+			conf:      newConf(true, ":3179", ""),
+			wantError: `can't derive the "httpRedirect" target`,
+		},
+	}
+	for _, tt := range errTests {
+		_, err := serverinit.GenLowLevelConfig(tt.conf)
+		if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+			t.Errorf("%s: GenLowLevelConfig = %v; want error containing %q", tt.name, err, tt.wantError)
+		}
+	}
+}
+
+// TestListeners tests the list form of the high-level "listen" key,
+// which generates a low-level "listeners" array instead of the
+// "listen"/"https" pair of the single-address form.
+func TestListeners(t *testing.T) {
+	ringPath, err := filepath.Abs(relativeRing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConf := func(https bool, listeners ...serverconfig.Listener) *serverconfig.Config {
+		return &serverconfig.Config{
+			Listen:             serverconfig.Listen{Listeners: listeners},
+			HTTPS:              https,
+			HTTPSCert:          "/path/to/tls.crt",
+			HTTPSKey:           "/path/to/tls.key",
+			Auth:               "userpass:camlistore:pass3179",
+			Identity:           "26F5ABDA",
+			IdentitySecretRing: ringPath,
+			BlobPath:           "/path/to/blobs",
+			KVFile:             "/path/to/indexkv.db",
+		}
+	}
+
+	lowLevelConf, err := serverinit.GenLowLevelConfig(newConf(false,
+		serverconfig.Listener{Addr: ":443", HTTPS: true},
+		serverconfig.Listener{Addr: "127.0.0.1:3179"},
+	))
+	if err != nil {
+		t.Fatalf("GenLowLevelConfig: %v", err)
+	}
+	want := []interface{}{"https://:443", "http://127.0.0.1:3179"}
+	got, ok := lowLevelConf.Obj["listeners"].([]interface{})
+	if !ok || len(got) != len(want) {
+		t.Fatalf("listeners = %#v; want %#v", lowLevelConf.Obj["listeners"], want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("listeners[%d] = %v; want %v", i, got[i], want[i])
+		}
+	}
+	for _, key := range []string{"listen", "https"} {
+		if v, ok := lowLevelConf.Obj[key]; ok {
+			t.Errorf("unexpected %q key %v with the list form of listen", key, v)
+		}
+	}
+	if got, want := lowLevelConf.Obj["httpsCert"], "/path/to/tls.crt"; got != want {
+		t.Errorf("httpsCert = %v; want %v", got, want)
+	}
+
+	errTests := []struct {
+		name      string
+		conf      *serverconfig.Config
+		wantError string
+	}{
+		{
+			name: "duplicate address",
+			conf: newConf(false,
+				serverconfig.Listener{Addr: ":443", HTTPS: true},
+				serverconfig.Listener{Addr: ":443"},
+			),
+			wantError: `duplicate address ":443" in "listen"`,
+		},
+		{
+			name:      "missing addr",
+			conf:      newConf(false, serverconfig.Listener{HTTPS: true}),
+			wantError: `missing "addr" in "listen" entry`,
+		},
+		{
+			name:      "top-level https with list form",
+			conf:      newConf(true, serverconfig.Listener{Addr: ":443", HTTPS: true}),
+			wantError: `drop the top-level "https"`,
+		},
+	}
+	for _, tt := range errTests {
+		_, err := serverinit.GenLowLevelConfig(tt.conf)
+		if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+			t.Errorf("%s: GenLowLevelConfig = %v; want error containing %q", tt.name, err, tt.wantError)
+		}
+	}
+}
+
+// TestUnknownConfigKeys tests that loading a high-level config with a
+// typo'd key fails with the file path and a suggestion, unless
+// "allowUnknownFields" is set.
+func TestUnknownConfigKeys(t *testing.T) {
+	ringPath, err := filepath.Abs(relativeRing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "serverinit-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeConfig := func(name, extraLine string) string {
+		path := filepath.Join(dir, name)
+		conf := fmt.Sprintf(`{
+	"listen": "localhost:3179",
+	"auth": "userpass:camlistore:pass3179",
+	"identity": "26F5ABDA",
+	"identitySecretRing": %q,
+	"kvIndexFile": %q,
+	"blobPath": %q,
+	%s
+	"shareHandler": true
+}`, ringPath, filepath.Join(dir, "index.kv"), filepath.Join(dir, "blobs"), extraLine)
+		if err := ioutil.WriteFile(path, []byte(conf), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	path := writeConfig("typo.json", `"httpCert": "/cert",`)
+	_, err = serverinit.Load(path)
+	if err == nil {
+		t.Fatalf("expected Load(%v) to fail on the unknown key", path)
+	}
+	for _, want := range []string{path, `unknown key "httpCert"`, `did you mean "httpsCert"?`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Load error %q doesn't mention %q", err, want)
+		}
+	}
+
+	path = writeConfig("allowed.json", `"httpCert": "/cert", "allowUnknownFields": true,`)
+	if _, err := serverinit.Load(path); err != nil {
+		t.Errorf("Load with allowUnknownFields = %v; want success", err)
+	}
+}
+
+// TestDirValidation tests that config generation pre-creates the
+// directories the server will need at runtime and reports unwritable
+// ones with the responsible high-level field and the path's owner and
+// mode, instead of leaving the failure to handler initialization.
+func TestDirValidation(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("directory permissions aren't enforced for root")
+	}
+	serverinit.SetNoMkdir(false)
+	defer serverinit.SetNoMkdir(true)
+	ringPath, err := filepath.Abs(relativeRing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "serverinit-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	roDir := filepath.Join(dir, "readonly")
+	if err := os.Mkdir(roDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(roDir, 0500); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(roDir, 0700) // so RemoveAll can clean up
+
+	newConf := func(indexFile string) *serverconfig.Config {
+		return &serverconfig.Config{
+			Listen:             serverconfig.Listen{Addr: "localhost:3179"},
+			Auth:               "userpass:camlistore:pass3179",
+			Identity:           "26F5ABDA",
+			IdentitySecretRing: ringPath,
+			KVFile:             indexFile,
+			BlobPath:           filepath.Join(dir, "blobs"),
+		}
+	}
+
+	// Missing directories are created.
+	indexDir := filepath.Join(dir, "index")
+	if _, err := serverinit.GenLowLevelConfig(newConf(filepath.Join(indexDir, "index.kv"))); err != nil {
+		t.Fatalf("GenLowLevelConfig = %v; want success", err)
+	}
+	if fi, err := os.Stat(indexDir); err != nil || !fi.IsDir() {
+		t.Errorf("Stat(%v) = %v, %v; want the index directory to have been created", indexDir, fi, err)
+	}
+
+	// An index directory under a read-only parent fails with the
+	// responsible field and the parent's owner and mode.
+	_, err = serverinit.GenLowLevelConfig(newConf(filepath.Join(roDir, "index", "index.kv")))
+	if err == nil {
+		t.Fatal("GenLowLevelConfig with a read-only index parent dir succeeded; want error")
+	}
+	for _, want := range []string{
+		`could not create "kvIndexFile" index directory`,
+		filepath.Join(roDir, "index"),
+		"mode 0500",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %q", err, want)
+		}
+	}
+
+	// An existing but unwritable directory fails the probe write.
+	_, err = serverinit.GenLowLevelConfig(newConf(filepath.Join(roDir, "index.kv")))
+	if err == nil {
+		t.Fatal("GenLowLevelConfig with a read-only index dir succeeded; want error")
+	}
+	for _, want := range []string{
+		`"kvIndexFile" index directory`,
+		"is not writable",
+		"mode 0500",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %q", err, want)
+		}
+	}
+
+	// An index file path that names an existing directory is rejected.
+	dirAsFile := filepath.Join(dir, "index.kv")
+	if err := os.Mkdir(dirAsFile, 0700); err != nil {
+		t.Fatal(err)
+	}
+	_, err = serverinit.GenLowLevelConfig(newConf(dirAsFile))
+	if err == nil {
+		t.Fatal("GenLowLevelConfig with a directory as the index file succeeded; want error")
+	}
+	for _, want := range []string{`"kvIndexFile" index file`, dirAsFile, "is a directory"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %q", err, want)
+		}
+	}
+}
+
 type namedReadSeeker struct {
 	name string
 	io.ReadSeeker
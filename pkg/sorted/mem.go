@@ -142,7 +142,8 @@ func (mk *memKeys) CommitBatch(bm BatchMutation) error {
 	defer mk.mu.Unlock()
 	for _, m := range b.Mutations() {
 		if m.IsDelete() {
-			if err := mk.db.Delete([]byte(m.Key()), nil); err != nil {
+			// Deleting an absent key is a no-op, as in Delete.
+			if err := mk.db.Delete([]byte(m.Key()), nil); err != nil && err != db.ErrNotFound {
 				return err
 			}
 		} else {
@@ -20,7 +20,9 @@ package mongo
 
 import (
 	"bytes"
+	"crypto/tls"
 	"errors"
+	"net"
 	"sync"
 	"time"
 
@@ -48,10 +50,13 @@ func init() {
 
 func newKeyValueFromJSONConfig(cfg jsonconfig.Obj) (sorted.KeyValue, error) {
 	ins := &instance{
-		server:   cfg.OptionalString("host", "localhost"),
-		database: cfg.RequiredString("database"),
-		user:     cfg.OptionalString("user", ""),
-		password: cfg.OptionalString("password", ""),
+		server:     cfg.OptionalString("host", "localhost"),
+		hosts:      cfg.OptionalList("hosts"),
+		database:   cfg.RequiredString("database"),
+		user:       cfg.OptionalString("user", ""),
+		password:   cfg.OptionalString("password", ""),
+		authSource: cfg.OptionalString("authSource", ""),
+		useTLS:     cfg.OptionalBool("tls", false),
 	}
 	if err := cfg.Validate(); err != nil {
 		return nil, err
@@ -213,11 +218,23 @@ func Ping(host string, timeout time.Duration) bool {
 // instance helps with the low level details about
 // the connection to MongoDB.
 type instance struct {
-	server   string
-	database string
-	user     string
-	password string
-	session  *mgo.Session
+	server     string   // single "host" config value; ignored if hosts is set.
+	hosts      []string // replica set seed list of "host" or "host:port".
+	database   string
+	user       string
+	password   string
+	authSource string // database to authenticate against, if not database.
+	useTLS     bool
+	session    *mgo.Session
+}
+
+// addrs returns the seed servers to dial: the "hosts" list if set,
+// otherwise the single "host" value.
+func (ins *instance) addrs() []string {
+	if len(ins.hosts) > 0 {
+		return ins.hosts
+	}
+	return []string{ins.server}
 }
 
 func (ins *instance) url() string {
@@ -246,10 +263,31 @@ func (ins *instance) getConnection() (*mgo.Session, error) {
 		return ins.session, nil
 	}
 	// TODO(mpl): do some "client caching" as in mysql, to avoid systematically dialing?
-	session, err := mgo.Dial(ins.url())
+	info := &mgo.DialInfo{
+		Addrs:    ins.addrs(),
+		Database: ins.database,
+	}
+	if ins.authSource == "" {
+		info.Username = ins.user
+		info.Password = ins.password
+	}
+	if ins.useTLS {
+		info.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+			return tls.Dial("tcp", addr.String(), nil)
+		}
+	}
+	session, err := mgo.DialWithInfo(info)
 	if err != nil {
 		return nil, err
 	}
+	if ins.authSource != "" && ins.user != "" {
+		// mgo's DialInfo can only authenticate against the session
+		// database, so log in against the auth database by hand.
+		if err := session.DB(ins.authSource).Login(ins.user, ins.password); err != nil {
+			session.Close()
+			return nil, err
+		}
+	}
 	session.SetMode(mgo.Monotonic, true)
 	session.SetSafe(&mgo.Safe{}) // so we get an ErrNotFound error when deleting an absent key
 	ins.session = session
@@ -37,6 +37,8 @@ func init() {
 
 func newKeyValueFromJSONConfig(cfg jsonconfig.Obj) (sorted.KeyValue, error) {
 	host := cfg.OptionalString("host", "")
+	unixSocket := cfg.OptionalString("unixSocket", "")
+	useTLS := cfg.OptionalBool("tls", false)
 	dsn := fmt.Sprintf("%s:%s@/%s",
 		cfg.RequiredString("user"),
 		cfg.OptionalString("password", ""),
@@ -45,13 +47,19 @@ func newKeyValueFromJSONConfig(cfg jsonconfig.Obj) (sorted.KeyValue, error) {
 	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
-	if host != "" {
+	switch {
+	case unixSocket != "":
+		dsn = strings.Replace(dsn, "@", fmt.Sprintf("@unix(%v)", unixSocket), 1)
+	case host != "":
 		// TODO(mpl): document that somewhere
 		if !strings.Contains(host, ":") {
 			host = host + ":3306"
 		}
 		dsn = strings.Replace(dsn, "@", fmt.Sprintf("@tcp(%v)", host), 1)
 	}
+	if useTLS {
+		dsn += "?tls=true"
+	}
 
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
@@ -36,13 +36,22 @@ func init() {
 }
 
 func newKeyValueFromJSONConfig(cfg jsonconfig.Obj) (sorted.KeyValue, error) {
+	host := cfg.OptionalString("host", "localhost")
+	if socket := cfg.OptionalString("unixSocket", ""); socket != "" {
+		// lib/pq interprets a host starting with "/" as the
+		// directory holding the server's unix socket.
+		host = socket
+	}
 	conninfo := fmt.Sprintf("user=%s dbname=%s host=%s password=%s sslmode=%s",
 		cfg.RequiredString("user"),
 		cfg.RequiredString("database"),
-		cfg.OptionalString("host", "localhost"),
+		host,
 		cfg.OptionalString("password", ""),
 		cfg.OptionalString("sslmode", "require"),
 	)
+	if port := cfg.OptionalInt("port", 0); port != 0 {
+		conninfo += fmt.Sprintf(" port=%d", port)
+	}
 	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
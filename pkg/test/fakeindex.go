@@ -223,3 +223,7 @@ func (fi *FakeIndex) EdgesTo(ref blob.Ref, opts *camtypes.EdgesToOpts) ([]*camty
 func (fi *FakeIndex) EnumerateBlobMeta(ctx *context.Context, ch chan<- camtypes.BlobMeta) error {
 	panic("NOIMPL")
 }
+
+func (fi *FakeIndex) StorageStats() (*camtypes.BlobStorageStats, error) {
+	panic("NOIMPL")
+}
@@ -61,17 +61,33 @@ func (c *Claim) String() string {
 		c.BlobRef, c.Signer, c.Permanode, c.Date, c.Type, c.Attr, c.Value)
 }
 
+// ClaimPtrsByDate sorts claims into their canonical application
+// order: by claim date ascending, with ties broken by the claim's
+// blobref. This order also defines the order of a multi-valued
+// attribute's values: each value appears in the position of the
+// add-attribute claim that (last) added it.
 type ClaimPtrsByDate []*Claim
 
-func (cl ClaimPtrsByDate) Len() int           { return len(cl) }
-func (cl ClaimPtrsByDate) Less(i, j int) bool { return cl[i].Date.Before(cl[j].Date) }
-func (cl ClaimPtrsByDate) Swap(i, j int)      { cl[i], cl[j] = cl[j], cl[i] }
+func (cl ClaimPtrsByDate) Len() int { return len(cl) }
+func (cl ClaimPtrsByDate) Less(i, j int) bool {
+	if !cl[i].Date.Equal(cl[j].Date) {
+		return cl[i].Date.Before(cl[j].Date)
+	}
+	return cl[i].BlobRef.Less(cl[j].BlobRef)
+}
+func (cl ClaimPtrsByDate) Swap(i, j int) { cl[i], cl[j] = cl[j], cl[i] }
 
+// ClaimsByDate sorts claims like ClaimPtrsByDate.
 type ClaimsByDate []Claim
 
-func (cl ClaimsByDate) Len() int           { return len(cl) }
-func (cl ClaimsByDate) Less(i, j int) bool { return cl[i].Date.Before(cl[j].Date) }
-func (cl ClaimsByDate) Swap(i, j int)      { cl[i], cl[j] = cl[j], cl[i] }
+func (cl ClaimsByDate) Len() int { return len(cl) }
+func (cl ClaimsByDate) Less(i, j int) bool {
+	if !cl[i].Date.Equal(cl[j].Date) {
+		return cl[i].Date.Before(cl[j].Date)
+	}
+	return cl[i].BlobRef.Less(cl[j].BlobRef)
+}
+func (cl ClaimsByDate) Swap(i, j int) { cl[i], cl[j] = cl[j], cl[i] }
 
 func (cl ClaimsByDate) String() string {
 	var buf bytes.Buffer
@@ -121,6 +137,10 @@ type ImageInfo struct {
 	Width uint16 `json:"width"`
 	// Height is the visible height of the image (after any necessary EXIF rotation).
 	Height uint16 `json:"height"`
+	// TinyPreview, if non-empty, is a very small representation of the
+	// image (see images.TinyPreview), so a UI can paint a placeholder
+	// before the real thumbnail loads.
+	TinyPreview string `json:"tinyPreview,omitempty"`
 }
 
 type Path struct {
@@ -181,3 +201,27 @@ type BlobMeta struct {
 
 	// TODO(bradfitz): change CamliTypethis *string to save 8 bytes
 }
+
+// BlobStorageStats summarizes how the data blobs known to the index
+// are shared between the "file" and "bytes" schema blobs that
+// reference them.
+type BlobStorageStats struct {
+	// Blobs is the number of distinct referenced data blobs.
+	Blobs int `json:"blobs"`
+
+	// Refs is the total number of references to them.
+	Refs int `json:"refs"`
+
+	// PhysicalBytes is the sum of the sizes of the referenced
+	// blobs, counting each blob once.
+	PhysicalBytes int64 `json:"physicalBytes"`
+
+	// LogicalBytes is the sum of the sizes of the referenced
+	// blobs, counting each blob once per reference. The difference
+	// with PhysicalBytes is the space saved by deduplication.
+	LogicalBytes int64 `json:"logicalBytes"`
+
+	// RefHistogram maps a reference count to the number of data
+	// blobs referenced exactly that many times.
+	RefHistogram map[int]int `json:"refHistogram"`
+}
@@ -18,16 +18,19 @@ limitations under the License.
 package serverconfig
 
 import (
+	"encoding/json"
+
 	"camlistore.org/pkg/types"
 )
 
 // Config holds the values from the JSON (high-level) server config file that is exposed to users (and is by default at osutil.UserServerConfigPath). From this simpler configuration, a complete, low-level one, is generated by serverinit.genLowLevelConfig, and used to configure the various Camlistore components.
 type Config struct {
-	Auth               string `json:"auth"`               // auth scheme and values (ex: userpass:foo:bar).
-	BaseURL            string `json:"baseURL,omitempty"`  // Base URL the server advertizes. For when behind a proxy.
-	Listen             string `json:"listen"`             // address (of the form host|ip:port) on which the server will listen on.
-	Identity           string `json:"identity"`           // GPG identity.
-	IdentitySecretRing string `json:"identitySecretRing"` // path to the secret ring file.
+	Auth               string `json:"auth"`                   // auth scheme and values (ex: userpass:foo:bar).
+	BaseURL            string `json:"baseURL,omitempty"`      // Base URL the server advertizes. For when behind a proxy.
+	Listen             Listen `json:"listen"`                 // address(es) on which the server will listen on; a host|ip:port string or a list, see the Listen type.
+	AdvertiseLAN       bool   `json:"advertiseLAN,omitempty"` // advertise the server with mDNS on the local network. Ignored with localhost auth.
+	Identity           string `json:"identity"`               // GPG identity.
+	IdentitySecretRing string `json:"identitySecretRing"`     // path to the secret ring file.
 	// alternative source tree, to override the embedded ui and/or closure resources.
 	// If non empty, the ui files will be expected at
 	// sourceRoot + "/server/camlistored/ui" and the closure library at
@@ -35,40 +38,540 @@ type Config struct {
 	// Also used by the publish handler.
 	SourceRoot string `json:"sourceRoot,omitempty"`
 	OwnerName  string `json:"ownerName,omitempty"`
+	// AllowUnknownFields disables the default strict checking of
+	// this config's keys on startup, for forward compatibility with
+	// configs mentioning fields this server doesn't know yet.
+	AllowUnknownFields bool `json:"allowUnknownFields,omitempty"`
 
 	// Blob storage.
-	BlobPath           string `json:"blobPath,omitempty"`           // path to the directory containing the blobs.
-	PackBlobs          bool   `json:"packBlobs,omitempty"`          // use diskpacked instead of the default filestorage.
-	S3                 string `json:"s3,omitempty"`                 // Amazon S3 credentials: access_key_id:secret_access_key:bucket[:hostname].
-	GoogleCloudStorage string `json:"googlecloudstorage,omitempty"` // Google Cloud credentials: clientId:clientSecret:refreshToken:bucket.
-	GoogleDrive        string `json:"googledrive,omitempty"`        // Google Drive credentials: clientId:clientSecret:refreshToken:parentId.
-	ShareHandler       bool   `json:"shareHandler,omitempty"`       // enable the share handler. If true, and shareHandlerPath is empty then shareHandlerPath will default to "/share/" when generating the low-level config.
-	ShareHandlerPath   string `json:"shareHandlerPath,omitempty"`   // URL prefix for the share handler. If set, overrides shareHandler.
+	BlobPath  string `json:"blobPath,omitempty"`  // path to the directory containing the blobs.
+	PackBlobs bool   `json:"packBlobs,omitempty"` // deprecated alias for a blobLayout of "diskpacked".
+	// BlobLayout is how blobs are stored under blobPath: "loose"
+	// (the default; one file per blob), "diskpacked" (packed into
+	// large files with a separate index), or "blobpacked" (loose
+	// and packed sub-directories with a meta index).
+	BlobLayout string `json:"blobLayout,omitempty"`
+	// PrimaryStorage names the configured storage ("localdisk", "s3",
+	// "googlecloudstorage", ...) that serves the blobs at /bs/. If empty,
+	// it defaults to "localdisk" when blobPath is set; otherwise the first
+	// configured remote storage is the primary.
+	PrimaryStorage string `json:"primaryStorage,omitempty"`
+	// MirrorTo names the configured storages ("s3", "b2", ...) to
+	// replicate the primary storage to, each with its own sync handler
+	// and queue. If empty, every configured non-primary storage is
+	// mirrored to.
+	MirrorTo []string `json:"mirrorTo,omitempty"`
+	// LocalCache, when set to "diskpacked" (the only supported value),
+	// keeps /bs/ on local disk under blobPath even when primaryStorage
+	// names a remote storage. The remote storage then becomes a mirror
+	// fed by its sync handler, so small blobs are batched into local
+	// pack files instead of each costing a remote write.
+	// TODO: a knob to garbage-collect the local copies once synced.
+	LocalCache    string  `json:"localCache,omitempty"`
+	S3            *S3     `json:"s3,omitempty"`            // Amazon S3 credentials; a legacy string or an object, see the S3 type.
+	S3CacheBucket string  `json:"s3CacheBucket,omitempty"` // if s3 is the primary storage, optional bucket[/dirPrefix] to use for the cache, instead of a temp directory.
+	Azure         string  `json:"azure,omitempty"`         // Azure Blob Storage credentials: account:access_key:container.
+	B2            string  `json:"b2,omitempty"`            // Backblaze B2 credentials: account_id:application_key:bucket.
+	Swift         *Swift  `json:"swift,omitempty"`         // OpenStack Swift configuration. A section, not a string, as Swift auth URLs contain colons.
+	SFTP          *SFTP   `json:"sftp,omitempty"`          // SFTP (SSH) server to store blobs on.
+	WebDAV        *WebDAV `json:"webdav,omitempty"`        // WebDAV server to store blobs on.
+	RADOS         *RADOS  `json:"rados,omitempty"`         // Ceph RADOS pool to store blobs in.
+	// CacheDir is the directory used by the local /cache/ handler
+	// (thumbnails and other derived data), whether the primary blob
+	// storage is local or cloud. It must be an absolute path. If
+	// empty, it defaults to "cache" under blobPath, or to a temp
+	// directory when the blobs aren't on local disk.
+	CacheDir string `json:"cacheDir,omitempty"`
+	// ThumbCache optionally configures the key-value store holding
+	// the UI's thumbnail metadata ("scaledImage" in the low-level
+	// config); a section, see the ThumbCache type. If unset, it's a
+	// kv file named thumbmeta.kv under blobPath, or under the
+	// Camlistore var directory when the blobs aren't on local disk.
+	ThumbCache *ThumbCache `json:"thumbCache,omitempty"`
+	// Encrypt optionally wraps mirrored storages in the "encrypt"
+	// at-rest encryption layer; a section, see the Encrypt type.
+	Encrypt *Encrypt `json:"encrypt,omitempty"`
+	// GoogleCloudStorage credentials; a legacy string or an object, see the GoogleCloudStorage type.
+	GoogleCloudStorage *GoogleCloudStorage `json:"googlecloudstorage,omitempty"`
+	GCSCacheBucket     string              `json:"gcsCacheBucket,omitempty"` // if googlecloudstorage is the primary storage, optional bucket to use for the cache, instead of a temp directory.
+
+	// GCSServiceAccountFile is the path to a service account JSON key
+	// file, for authenticating to Google Cloud Storage without an
+	// interactive OAuth flow. If set, googlecloudstorage is just the
+	// bucket name.
+	GCSServiceAccountFile string `json:"gcsServiceAccountFile,omitempty"`
+	// GoogleDrive credentials; a legacy string or an object, see the GoogleDrive type.
+	GoogleDrive      *GoogleDrive `json:"googledrive,omitempty"`
+	ShareHandler     bool         `json:"shareHandler,omitempty"`     // enable the share handler. If true, and shareHandlerPath is empty then shareHandlerPath will default to "/share/" when generating the low-level config.
+	ShareHandlerPath string       `json:"shareHandlerPath,omitempty"` // URL prefix for the share handler. If set, overrides shareHandler.
+
+	// Logging maps a logging subsystem name ("sync", "importer",
+	// "index", ...) to its verbosity: "info" (the default) or
+	// "debug". Levels may also be changed at runtime, without a
+	// restart, through the auth-gated /debug/logging endpoint.
+	Logging map[string]string `json:"logging,omitempty"`
+
+	// ReadOnly starts the server in read-only maintenance mode:
+	// blob uploads and removes, signing, and importer runs are
+	// refused with a 503 while reads and search keep working. The
+	// mode can also be toggled at runtime with an auth-gated POST
+	// to the status handler's "maintenance" endpoint.
+	ReadOnly bool `json:"readonly,omitempty"`
+
+	// Disk space monitoring of the filesystems holding the blobs, the index, and the cache.
+	DiskFreeWarn   int `json:"diskFreeWarn,omitempty"`   // percent free space below which to warn. Default is 5.
+	DiskFreeRefuse int `json:"diskFreeRefuse,omitempty"` // percent free space below which to refuse new blob uploads. Default is 1.
 
 	// HTTPS.
-	HTTPS     bool   `json:"https,omitempty"`     // enable HTTPS.
-	HTTPSCert string `json:"httpsCert,omitempty"` // path to the HTTPS certificate file.
-	HTTPSKey  string `json:"httpsKey,omitempty"`  // path to the HTTPS key file.
+	HTTPS bool `json:"https,omitempty"` // enable HTTPS. Unused with the list form of "listen", which sets it per listener.
+	// HTTPSCert is the path to the HTTPS certificate file. The
+	// special value "auto" instead makes the server obtain and
+	// renew a certificate itself, via ACME (Let's Encrypt), for
+	// the domain in baseURL; httpsKey must then be empty. If
+	// neither httpsCert nor httpsKey is set, a self-signed
+	// certificate is generated.
+	HTTPSCert string `json:"httpsCert,omitempty"`
+	HTTPSKey  string `json:"httpsKey,omitempty"` // path to the HTTPS key file.
+	// HTTPRedirect is an address (of the form host|ip:port, e.g.
+	// ":80") to answer plain HTTP on with a permanent redirect to
+	// the HTTPS URL of the server, for old bookmarks and bare
+	// hostname typing. The redirect target is derived from baseURL
+	// when set, otherwise from the listen host and HTTPS port.
+	// Requires HTTPS.
+	HTTPRedirect string `json:"httpRedirect,omitempty"`
+	// TLSMinVersion optionally restricts the minimum TLS version
+	// offered: "TLS1.0", "TLS1.1", or "TLS1.2". If unset, the
+	// crypto/tls default applies.
+	TLSMinVersion string `json:"tlsMinVersion,omitempty"`
+	// TLSCiphers optionally restricts the offered cipher suites
+	// to the named ones, in preference order, using the crypto/tls
+	// constant names (e.g.
+	// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"). If unset, the
+	// crypto/tls default applies.
+	TLSCiphers []string `json:"tlsCiphers,omitempty"`
 
 	// Index.
-	MemoryIndex types.InvertedBool `json:"memoryIndex,omitempty"` // copy disk-based index to memory on start-up.
-	RunIndex    types.InvertedBool `json:"runIndex,omitempty"`    // if logically false: no search, no UI, etc.
-	DBName      string             `json:"dbname,omitempty"`      // name of the database for mysql, postgres, mongo.
-	KVFile      string             `json:"kvIndexFile,omitempty"` // path to the kv file, for indexing with github.com/cznic/kv.
-	MySQL       string             `json:"mysql,omitempty"`       // MySQL credentials (username@host:password), for indexing with MySQL.
-	Mongo       string             `json:"mongo,omitempty"`       // MongoDB credentials ([username:password@]host), for indexing with MongoDB.
-	PostgreSQL  string             `json:"postgres,omitempty"`    // PostgreSQL credentials (username@host:password), for indexing with PostgreSQL.
-	SQLite      string             `json:"sqlite,omitempty"`      // path to the SQLite file, for indexing with SQLite.
-
-	ReplicateTo []interface{} `json:"replicateTo,omitempty"` // NOOP for now.
+	MemoryIndex      types.InvertedBool `json:"memoryIndex,omitempty"`    // copy disk-based index to memory on start-up.
+	MemoryStorage    bool               `json:"memoryStorage,omitempty"`  // keep the index only in memory, rebuilt from the blobserver on every start. For demos and throwaway servers.
+	RunIndex         types.InvertedBool `json:"runIndex,omitempty"`       // if logically false: no search, no UI, etc.
+	DBName           string             `json:"dbname,omitempty"`         // name of the database for mysql, postgres, mongo. Defaults to "camli" + username.
+	KVFile           string             `json:"kvIndexFile,omitempty"`    // path to the kv file, for indexing with github.com/cznic/kv.
+	LevelDB          string             `json:"levelDB,omitempty"`        // path to the LevelDB directory, for indexing with LevelDB.
+	MySQL            *DBConnection      `json:"mysql,omitempty"`          // MySQL index connection; an object or the legacy "username@host:password" string.
+	MySQLDBName      string             `json:"mysqlDBName,omitempty"`    // overrides dbname for the MySQL indexer.
+	Mongo            *MongoConnection   `json:"mongo,omitempty"`          // MongoDB index connection; an object, a "mongodb://" URI, or the legacy "user:password@host" string.
+	MongoDBName      string             `json:"mongoDBName,omitempty"`    // overrides dbname for the MongoDB indexer.
+	PostgreSQL       *DBConnection      `json:"postgres,omitempty"`       // PostgreSQL index connection; same forms as mysql.
+	PostgreSQLDBName string             `json:"postgresDBName,omitempty"` // overrides dbname for the PostgreSQL indexer.
+	SQLite           string             `json:"sqlite,omitempty"`         // path to the SQLite file, for indexing with SQLite.
+
+	// IndexURL, if set, is the URL of a remote index handler
+	// (e.g. "https://indexhost:3179/index/") to use instead of a
+	// local indexer, for keeping the index on another machine.
+	// IndexURLAuth is its auth scheme and values, as in the
+	// low-level "auth" field; it defaults to "none".
+	IndexURL     string `json:"indexURL,omitempty"`
+	IndexURLAuth string `json:"indexURLAuth,omitempty"`
+
+	// ReplicateTo lists the URLs of remote Camlistore servers to
+	// replicate the blobs of the local primary storage to, each
+	// with its own sync handler and queue. Credentials may be
+	// given in the URL's user:password section.
+	ReplicateTo []interface{} `json:"replicateTo,omitempty"`
 	// Publish maps a URL prefix path used as a root for published paths (a.k.a. a camliRoot path), to the configuration of the publish handler that serves all the published paths under this root.
 	Publish map[string]*Publish `json:"publish,omitempty"`
+	// Syncs maps a URL prefix path for a sync handler (ex: "/sync-index-backup/"), to the configuration of that additional sync handler. Such sync handlers come in addition to the ones automatically generated for the index and for the mirrored storages.
+	Syncs map[string]*Sync `json:"syncs,omitempty"`
+	// Roles maps a role name (ex: "storage", "frontend") to the subset of the generated prefixes that a camlistored process started with --role=<name> serves. When set, every generated prefix must be assigned to a role, and references between prefixes of different roles are rewritten to go over HTTP to the owning role's baseURL.
+	Roles map[string]*Role `json:"roles,omitempty"`
 
 	// TODO(mpl): map of importers instead?
 	Flickr string `json:"flickr,omitempty"` // flicker importer.
 	Picasa string `json:"picasa,omitempty"` // picasa importer.
 }
 
+// A Listener is one address for the server to listen on, in the list
+// form of the "listen" key.
+type Listener struct {
+	Addr  string `json:"addr"`            // address (of the form host|ip:port) to listen on.
+	HTTPS bool   `json:"https,omitempty"` // serve TLS (instead of plain HTTP) on this address.
+}
+
+// Listen is the value of the config's "listen" key: either a single
+// "host|ip:port" string, or a list of {addr, https} objects to listen
+// on several addresses at once (e.g. public HTTPS plus plain HTTP on
+// localhost for local tools). With the list form, the top-level
+// "https" key is not used; each listener says itself whether it
+// serves TLS.
+type Listen struct {
+	Addr      string     // single-address form; empty with the list form.
+	Listeners []Listener // list form; nil with the single-address form.
+}
+
+func (l *Listen) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '[' {
+		return json.Unmarshal(b, &l.Listeners)
+	}
+	return json.Unmarshal(b, &l.Addr)
+}
+
+func (l Listen) MarshalJSON() ([]byte, error) {
+	if l.Listeners != nil {
+		return json.Marshal(l.Listeners)
+	}
+	return json.Marshal(l.Addr)
+}
+
+// DBConnection holds the high-level connection settings for a MySQL
+// or PostgreSQL indexer, declared in Config.MySQL and
+// Config.PostgreSQL. In the JSON config it is either an object with
+// the fields below, or the legacy "username@host:password" string.
+// Only the object form can express a unix socket, a non-default port,
+// TLS, or a password containing ':'.
+type DBConnection struct {
+	User       string `json:"user"`
+	Password   string `json:"password,omitempty"`
+	Host       string `json:"host,omitempty"`       // hostname or IP, without the port.
+	Port       int    `json:"port,omitempty"`       // TCP port, if not the database's default.
+	UnixSocket string `json:"unixSocket,omitempty"` // path to the database's unix socket, instead of host/port.
+	TLS        *bool  `json:"tls,omitempty"`        // whether to require TLS; unset keeps the driver's default.
+	// SSLMode is the PostgreSQL sslmode connection option: one of
+	// "disable", "require", "verify-ca", or "verify-full". It is
+	// more precise than TLS, which it can't be combined with, and
+	// is ignored for MySQL.
+	SSLMode string `json:"sslMode,omitempty"`
+
+	// Legacy is the legacy "username@host:password" form, kept
+	// verbatim for serverinit.genLowLevelConfig to parse.
+	Legacy string `json:"-"`
+}
+
+// dbConnNoMethods is DBConnection without its UnmarshalJSON and
+// MarshalJSON methods, so the object form can use the default
+// (un)marshaling.
+type dbConnNoMethods DBConnection
+
+func (c *DBConnection) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, &c.Legacy)
+	}
+	return json.Unmarshal(b, (*dbConnNoMethods)(c))
+}
+
+func (c *DBConnection) MarshalJSON() ([]byte, error) {
+	if c.Legacy != "" {
+		return json.Marshal(c.Legacy)
+	}
+	return json.Marshal((*dbConnNoMethods)(c))
+}
+
+// MongoConnection holds the high-level connection settings for a
+// MongoDB indexer, declared in Config.Mongo. In the JSON config it is
+// either an object with the fields below, a standard "mongodb://"
+// connection URI, or the legacy "user:password@host" string. Only the
+// object and URI forms can express a replica set (multiple hosts), an
+// authSource database, or TLS.
+type MongoConnection struct {
+	User       string   `json:"user"`
+	Password   string   `json:"password,omitempty"`
+	Hosts      []string `json:"hosts,omitempty"`      // "host" or "host:port" seed list; one entry for a standalone server.
+	AuthSource string   `json:"authSource,omitempty"` // database to authenticate against, if not the index database.
+	TLS        bool     `json:"tls,omitempty"`        // whether to connect over TLS.
+
+	// Legacy is the "user:password@host" or "mongodb://" string
+	// form, kept verbatim for serverinit.genLowLevelConfig to parse.
+	Legacy string `json:"-"`
+}
+
+// mongoConnNoMethods is MongoConnection without its UnmarshalJSON and
+// MarshalJSON methods, so the object form can use the default
+// (un)marshaling.
+type mongoConnNoMethods MongoConnection
+
+func (c *MongoConnection) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, &c.Legacy)
+	}
+	return json.Unmarshal(b, (*mongoConnNoMethods)(c))
+}
+
+func (c *MongoConnection) MarshalJSON() ([]byte, error) {
+	if c.Legacy != "" {
+		return json.Marshal(c.Legacy)
+	}
+	return json.Marshal((*mongoConnNoMethods)(c))
+}
+
+// S3 holds the high-level configuration values for an Amazon S3 (or
+// S3-compatible) blobserver, declared in Config.S3. In the JSON config
+// it is either an object with the fields below, or the legacy
+// "access_key_id:secret_access_key:bucket[:hostname[/dirPrefix]]"
+// string. Only the object form can hold a secret key containing
+// colons.
+type S3 struct {
+	AccessKey string `json:"accessKey"`          // AWS access key ID.
+	SecretKey string `json:"secretKey"`          // AWS secret access key.
+	Bucket    string `json:"bucket"`             // bucket to store blobs in.
+	Hostname  string `json:"hostname,omitempty"` // optional S3-compatible endpoint, with optional "/dirPrefix".
+
+	// PathStyle addresses the bucket as "hostname/bucket/" instead
+	// of "bucket.hostname/", as needed by most S3-compatible
+	// servers (MinIO, Ceph radosgw). If absent and Hostname is
+	// set, it defaults to true.
+	PathStyle *bool `json:"pathStyle,omitempty"`
+	// ServerSideEncryption optionally requests server-side encryption
+	// of the uploaded blobs: "AES256" (SSE-S3) or "aws:kms" (SSE-KMS).
+	ServerSideEncryption string `json:"serverSideEncryption,omitempty"`
+	// StorageClass optionally selects the S3 storage class the blobs
+	// are stored with, e.g. "STANDARD_IA".
+	StorageClass string `json:"storageClass,omitempty"`
+	// MultipartThreshold optionally sets the object size in bytes
+	// above which uploads use the S3 multipart API instead of a
+	// single PUT. Zero means no multipart uploads.
+	MultipartThreshold int64 `json:"multipartThreshold,omitempty"`
+	// PartSize optionally sets the part size in bytes for multipart
+	// uploads. S3 requires at least 5 MB.
+	PartSize int64 `json:"partSize,omitempty"`
+	// UseIAMRole makes the server fetch temporary credentials for the
+	// EC2 instance's IAM role from the instance metadata service,
+	// instead of using static keys. It cannot be combined with
+	// AccessKey and SecretKey. Setting AccessKey to "auto" (with no
+	// SecretKey) is equivalent.
+	UseIAMRole bool `json:"useIAMRole,omitempty"`
+
+	// Legacy is the legacy colon-separated string form, kept verbatim
+	// for serverinit.genLowLevelConfig to parse, so old configs keep
+	// generating byte-for-byte identical low-level output.
+	Legacy string `json:"-"`
+}
+
+// s3NoMethods is S3 without its UnmarshalJSON and MarshalJSON methods,
+// so the object form can use the default (un)marshaling.
+type s3NoMethods S3
+
+func (s *S3) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, &s.Legacy)
+	}
+	return json.Unmarshal(b, (*s3NoMethods)(s))
+}
+
+func (s *S3) MarshalJSON() ([]byte, error) {
+	if s.Legacy != "" {
+		return json.Marshal(s.Legacy)
+	}
+	return json.Marshal((*s3NoMethods)(s))
+}
+
+// GoogleCloudStorage holds the high-level configuration values for a
+// Google Cloud Storage blobserver, declared in
+// Config.GoogleCloudStorage. In the JSON config it is either an object
+// with the fields below, or the legacy
+// "clientId:clientSecret:refreshToken:bucket" string (or just "bucket"
+// with gcsServiceAccountFile, or ":bucket" to use the GCE
+// metadata-service credentials when running on Google Compute Engine).
+type GoogleCloudStorage struct {
+	Bucket string `json:"bucket"` // bucket to store blobs in.
+	// Auth is "gce" to use GCE metadata-service credentials,
+	// the legacy "clientId:clientSecret:refreshToken" triplet,
+	// or empty to use gcsServiceAccountFile.
+	Auth string `json:"auth,omitempty"`
+
+	// FullSyncOnStart makes the sync handler attached to this storage
+	// (to the index when it's the primary storage, from the primary
+	// otherwise) enumerate both sides on start-up and sync any
+	// missing blobs, instead of only the queued ones.
+	FullSyncOnStart bool `json:"fullSyncOnStart,omitempty"`
+	// BlockingFullSyncOnStart makes the server wait for the start-up
+	// full sync to complete before serving.
+	BlockingFullSyncOnStart bool `json:"blockingFullSyncOnStart,omitempty"`
+
+	// Legacy is the legacy colon-separated string form, kept verbatim
+	// for serverinit.genLowLevelConfig to parse.
+	Legacy string `json:"-"`
+}
+
+// gcsNoMethods is GoogleCloudStorage without its UnmarshalJSON and
+// MarshalJSON methods, so the object form can use the default
+// (un)marshaling.
+type gcsNoMethods GoogleCloudStorage
+
+func (gcs *GoogleCloudStorage) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, &gcs.Legacy)
+	}
+	return json.Unmarshal(b, (*gcsNoMethods)(gcs))
+}
+
+func (gcs *GoogleCloudStorage) MarshalJSON() ([]byte, error) {
+	if gcs.Legacy != "" {
+		return json.Marshal(gcs.Legacy)
+	}
+	return json.Marshal((*gcsNoMethods)(gcs))
+}
+
+// GoogleDrive holds the high-level configuration values for a Google
+// Drive blobserver, declared in Config.GoogleDrive. In the JSON config
+// it is either an object with the fields below, or the legacy
+// "clientId:clientSecret:refreshToken:parent" string, where parent is
+// the destination folder's opaque ID, or "name=<folder name>" to have
+// it resolved or created by name on start.
+type GoogleDrive struct {
+	Auth   string `json:"auth"`   // "clientId:clientSecret:refreshToken" OAuth credentials.
+	Parent string `json:"parent"` // destination folder: its opaque ID, or "name=<folder name>".
+
+	// ChunkSize is the chunk size in bytes for blob uploads to
+	// Drive. It must be a positive multiple of 256KiB, per the Drive
+	// API. Zero uploads each blob in a single request.
+	ChunkSize int64 `json:"chunkSize,omitempty"`
+	// MaxRetries is how many times a failed upload, or upload chunk,
+	// is retried before the error is returned. Zero means no
+	// retries.
+	MaxRetries int `json:"maxRetries,omitempty"`
+	// RetryBackoff is the delay before the first retry of a failed
+	// upload, as a time.Duration string (e.g. "2s"). The delay
+	// doubles on each subsequent retry. Default is "1s".
+	RetryBackoff string `json:"retryBackoff,omitempty"`
+
+	// Legacy is the legacy colon-separated string form, kept verbatim
+	// for serverinit.genLowLevelConfig to parse.
+	Legacy string `json:"-"`
+}
+
+// gdriveNoMethods is GoogleDrive without its UnmarshalJSON and
+// MarshalJSON methods, so the object form can use the default
+// (un)marshaling.
+type gdriveNoMethods GoogleDrive
+
+func (gd *GoogleDrive) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, &gd.Legacy)
+	}
+	return json.Unmarshal(b, (*gdriveNoMethods)(gd))
+}
+
+func (gd *GoogleDrive) MarshalJSON() ([]byte, error) {
+	if gd.Legacy != "" {
+		return json.Marshal(gd.Legacy)
+	}
+	return json.Marshal((*gdriveNoMethods)(gd))
+}
+
+// Swift holds the high-level configuration values for an OpenStack
+// Swift blobserver, declared in Config.Swift.
+type Swift struct {
+	AuthURL   string `json:"authURL"`          // URL of the authentication endpoint (ex: "https://auth.cloud.example.com/v2.0").
+	User      string `json:"user"`             // user name.
+	APIKey    string `json:"apiKey"`           // API key or password.
+	Container string `json:"container"`        // container to store blobs in.
+	Region    string `json:"region,omitempty"` // region to use; the auth server's default if empty.
+	Tenant    string `json:"tenant,omitempty"` // tenant (project) name, for v2 auth.
+}
+
+// SFTP holds the high-level configuration values for storing blobs
+// on an SFTP (SSH) server, declared in Config.SFTP.
+type SFTP struct {
+	Host string `json:"host"` // address of the SSH server, of the form host:port.
+	User string `json:"user"` // user to log in as.
+	Dir  string `json:"dir"`  // remote directory to store blobs in.
+
+	// Exactly one of Password or PrivateKeyFile must be set.
+	Password       string `json:"password,omitempty"`       // password to log in with.
+	PrivateKeyFile string `json:"privateKeyFile,omitempty"` // path to the SSH private key file.
+}
+
+// WebDAV holds the high-level configuration values for storing blobs
+// on a WebDAV (e.g. Nextcloud or ownCloud) server, declared in
+// Config.WebDAV.
+type WebDAV struct {
+	URL      string `json:"url"`                // base URL of the WebDAV collection to store blobs in.
+	Username string `json:"username,omitempty"` // username for HTTP basic auth.
+	Password string `json:"password,omitempty"` // password for HTTP basic auth.
+
+	// Insecure permits a non-https URL.
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+// RADOS holds the high-level configuration values for storing blobs
+// in a Ceph RADOS pool, declared in Config.RADOS.
+type RADOS struct {
+	Monitors []string `json:"monitors"`          // addresses of the Ceph monitors (ex: "ceph1.example.com:6789").
+	Pool     string   `json:"pool"`              // pool to store blobs in.
+	User     string   `json:"user,omitempty"`    // Ceph user to authenticate as. Defaults to "admin".
+	Keyring  string   `json:"keyring,omitempty"` // path to the keyring file.
+}
+
+// ThumbCache holds the high-level configuration values for the UI's
+// thumbnail meta store, declared in Config.ThumbCache.
+type ThumbCache struct {
+	// Type is the kind of key-value store to use: "kv", "sqlite",
+	// "leveldb", or "memory".
+	Type string `json:"type"`
+
+	// File is the path of the store: a file for "kv" and "sqlite",
+	// a directory for "leveldb". It must be empty for "memory".
+	File string `json:"file,omitempty"`
+}
+
+// Encrypt holds the high-level configuration values for at-rest
+// encryption, declared in Config.Encrypt. Each named target storage is
+// wrapped in a "storage-encrypt" handler, and the sync handler
+// mirroring to it is repointed at the encrypting prefix, so blobs are
+// encrypted before they leave the server.
+type Encrypt struct {
+	// KeyFile is the path to the encryption key file: 16 raw bytes
+	// (AES-128). It must exist at config generation time, unless
+	// AutoKeygen is set.
+	KeyFile string `json:"keyFile"`
+
+	// AutoKeygen, if true, creates KeyFile with a fresh random key
+	// when it does not exist yet.
+	AutoKeygen bool `json:"autoKeygen,omitempty"`
+
+	// Targets names the mirrored storages to encrypt ("s3",
+	// "googlecloudstorage", ...). Each must be configured.
+	Targets []string `json:"targets"`
+}
+
+// Sync holds the server configuration values for an additional sync
+// handler, i.e. for a sync prefix declared in Config.Syncs.
+type Sync struct {
+	// From is the blobserver prefix to replicate from (ex: "/index/").
+	// It must be one of the other prefixes of the generated low-level
+	// configuration.
+	From string `json:"from"`
+
+	// To is the blobserver prefix to replicate to (ex: "/sto-s3/").
+	// Exactly one of To or ToStorage must be set.
+	To string `json:"to,omitempty"`
+
+	// ToStorage is a low-level storage configuration (with "type" and
+	// its arguments) used as the destination of the sync handler, for
+	// destinations which do not already exist as a prefix. The
+	// corresponding storage handler is generated at the sync prefix
+	// with "-storage" appended (ex: "/sync-index-backup-storage/").
+	ToStorage map[string]interface{} `json:"toStorage,omitempty"`
+
+	// Queue is the path to the file used as the sync queue. If empty,
+	// it defaults to a file named after the sync prefix in blobPath.
+	Queue string `json:"queue,omitempty"`
+}
+
+// Role holds the server configuration values for one role declared in
+// Config.Roles, describing which prefixes one camlistored process of a
+// split deployment serves and where the other processes can reach it.
+type Role struct {
+	// BaseURL is the URL (ex: "https://storage.example.com") at which
+	// the other roles' processes reach this role's process. It is used
+	// as the base of the remote storage handlers generated for
+	// cross-role references.
+	BaseURL string `json:"baseURL"`
+
+	// Prefixes lists the prefixes of the generated low-level
+	// configuration (ex: "/bs/") that this role serves.
+	Prefixes []string `json:"prefixes"`
+}
+
 // Publish holds the server configuration values specific to a publisher, i.e. to a publish prefix.
 type Publish struct {
 	// Program is the server app program to run as the publisher.
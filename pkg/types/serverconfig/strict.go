@@ -0,0 +1,167 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serverconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// UnknownFields returns a message for each JSON key in b, a high-level
+// config, that doesn't correspond to any known Config field, including
+// keys of nested objects like the publish map entries. Each message
+// carries a did-you-mean suggestion when a known key is close enough.
+// Keys differing from a known one only in case are accepted, as
+// encoding/json matches them anyway.
+func UnknownFields(b []byte) ([]string, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return unknownInObject("", m, reflect.TypeOf(Config{})), nil
+}
+
+// unknownInObject checks the JSON object m against the fields of
+// struct type t. prefix ("" at the top level) is prepended to key
+// names in the returned messages.
+func unknownInObject(prefix string, m map[string]interface{}, t reflect.Type) (msgs []string) {
+	fields := jsonFields(t)
+	for _, k := range sortedObjectKeys(m) {
+		ft, ok := fields[strings.ToLower(k)]
+		if !ok {
+			msg := fmt.Sprintf("unknown key %q", prefix+k)
+			if sug := closestField(k, fields); sug != "" {
+				msg += fmt.Sprintf(" (did you mean %q?)", sug)
+			}
+			msgs = append(msgs, msg)
+			continue
+		}
+		msgs = append(msgs, unknownInValue(prefix+k, m[k], ft.typ)...)
+	}
+	return
+}
+
+// unknownInValue checks the JSON value v, at path, against the Go type
+// t it unmarshals into, recursing into objects and arrays.
+func unknownInValue(path string, v interface{}, t reflect.Type) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		switch t.Kind() {
+		case reflect.Struct:
+			return unknownInObject(path+".", vv, t)
+		case reflect.Map:
+			var msgs []string
+			for _, k := range sortedObjectKeys(vv) {
+				msgs = append(msgs, unknownInValue(path+"."+k, vv[k], t.Elem())...)
+			}
+			return msgs
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice {
+			var msgs []string
+			for i, e := range vv {
+				msgs = append(msgs, unknownInValue(fmt.Sprintf("%s[%d]", path, i), e, t.Elem())...)
+			}
+			return msgs
+		}
+	}
+	return nil
+}
+
+type jsonField struct {
+	name string // the JSON key, as spelled in the struct tag
+	typ  reflect.Type
+}
+
+// jsonFields returns the JSON keys of struct type t, lowercased for
+// case-insensitive lookup, as encoding/json matches keys.
+func jsonFields(t reflect.Type) map[string]jsonField {
+	fields := make(map[string]jsonField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[strings.ToLower(name)] = jsonField{name, f.Type}
+	}
+	return fields
+}
+
+func sortedObjectKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// closestField returns the known JSON key closest to key, or "" if
+// none is close enough to suggest.
+func closestField(key string, fields map[string]jsonField) string {
+	const maxDist = 3
+	best, bestDist := "", maxDist+1
+	lkey := strings.ToLower(key)
+	for lower, f := range fields {
+		d := editDistance(lkey, lower)
+		if d < bestDist || d == bestDist && f.name < best {
+			best, bestDist = f.name, d
+		}
+	}
+	if bestDist > maxDist || bestDist >= len(key) {
+		return ""
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 0; i < len(a); i++ {
+		cur[0] = i + 1
+		for j := 0; j < len(b); j++ {
+			cost := 1
+			if a[i] == b[j] {
+				cost = 0
+			}
+			cur[j+1] = min3(cur[j]+1, prev[j+1]+1, prev[j]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serverconfig
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnknownFields(t *testing.T) {
+	tests := []struct {
+		conf string
+		want []string // nil means no unknown keys
+	}{
+		{conf: `{"listen": ":3179", "httpsCert": "/cert", "allowUnknownFields": false}`},
+		// Case differences are accepted, like encoding/json does.
+		{conf: `{"HTTPSCert": "/cert"}`},
+		{
+			conf: `{"listen": ":3179", "httpCert": "/cert"}`,
+			want: []string{`unknown key "httpCert" (did you mean "httpsCert"?)`},
+		},
+		{
+			conf: `{"pubish": {}}`,
+			want: []string{`unknown key "pubish" (did you mean "publish"?)`},
+		},
+		// Nested: publish map entries.
+		{
+			conf: `{"publish": {"/blog/": {"camliRoot": "blog", "goTemplat": "blog.html"}}}`,
+			want: []string{`unknown key "publish./blog/.goTemplat" (did you mean "goTemplate"?)`},
+		},
+		// Nested: the s3 object form.
+		{
+			conf: `{"s3": {"accessKey": "key", "secretKey": "s", "buckt": "b"}}`,
+			want: []string{`unknown key "s3.buckt" (did you mean "bucket"?)`},
+		},
+		// The legacy s3 string form has no keys to check.
+		{conf: `{"s3": "key:secret:bucket"}`},
+		// The googlecloudstorage object form.
+		{
+			conf: `{"googlecloudstorage": {"bucke": "b", "auth": "gce"}}`,
+			want: []string{`unknown key "googlecloudstorage.bucke" (did you mean "bucket"?)`},
+		},
+		{conf: `{"googlecloudstorage": {"bucket": "b", "fullSyncOnStart": true, "blockingFullSyncOnStart": true}}`},
+		// The googledrive object form.
+		{
+			conf: `{"googledrive": {"auth": "id:secret:tok", "parent": "p", "chunkSiz": 262144}}`,
+			want: []string{`unknown key "googledrive.chunkSiz" (did you mean "chunkSize"?)`},
+		},
+		{conf: `{"googledrive": {"auth": "id:secret:tok", "parent": "p", "maxRetries": 3, "retryBackoff": "2s"}}`},
+		// Nothing close enough: no suggestion.
+		{
+			conf: `{"frobnicator": true}`,
+			want: []string{`unknown key "frobnicator"`},
+		},
+	}
+	for _, tt := range tests {
+		got, err := UnknownFields([]byte(tt.conf))
+		if err != nil {
+			t.Errorf("UnknownFields(%s) = %v", tt.conf, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("UnknownFields(%s) = %q; want %q", tt.conf, got, tt.want)
+		}
+	}
+}
@@ -31,6 +31,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -41,12 +42,16 @@ import (
 )
 
 type Server struct {
-	mux      *http.ServeMux
-	listener net.Listener
-	verbose  bool // log HTTP requests and response codes
+	mux            *http.ServeMux
+	listener       net.Listener
+	extraListeners []net.Listener // from ListenExtra; served alongside listener
+	verbose        bool           // log HTTP requests and response codes
 
 	enableTLS               bool
 	tlsCertFile, tlsKeyFile string
+	tlsGetCert              func(*tls.ClientHelloInfo) (*tls.Certificate, error) // or nil to use the cert files
+	tlsMinVersion           uint16                                               // or 0 for the crypto/tls default
+	tlsCipherSuites         []uint16                                             // or nil for the crypto/tls default
 
 	mu   sync.Mutex
 	reqs int64
@@ -62,10 +67,92 @@ func New() *Server {
 
 func (s *Server) SetTLS(certFile, keyFile string) {
 	s.enableTLS = true
+	s.SetTLSCert(certFile, keyFile)
+}
+
+// SetTLSCert sets the certificate used by TLS listeners without
+// enabling TLS on the main listener, for when only an extra listener
+// (see ListenExtra) serves TLS.
+func (s *Server) SetTLSCert(certFile, keyFile string) {
 	s.tlsCertFile = certFile
 	s.tlsKeyFile = keyFile
 }
 
+// SetTLSFunc is like SetTLS, but the certificate is produced at
+// handshake time by fn (e.g. a Let's Encrypt manager) instead of
+// being loaded from files.
+func (s *Server) SetTLSFunc(fn func(*tls.ClientHelloInfo) (*tls.Certificate, error)) {
+	s.enableTLS = true
+	s.SetTLSCertFunc(fn)
+}
+
+// SetTLSCertFunc sets the certificate callback used by TLS listeners
+// without enabling TLS on the main listener (see SetTLSCert).
+func (s *Server) SetTLSCertFunc(fn func(*tls.ClientHelloInfo) (*tls.Certificate, error)) {
+	s.tlsGetCert = fn
+}
+
+// SetTLSOptions restricts the TLS versions and cipher suites offered
+// when serving TLS. A zero minVersion or an empty cipherSuites leaves
+// the corresponding crypto/tls default in place.
+func (s *Server) SetTLSOptions(minVersion uint16, cipherSuites []uint16) {
+	s.tlsMinVersion = minVersion
+	s.tlsCipherSuites = cipherSuites
+}
+
+// TLSVersions maps the version names accepted in the server config's
+// "tlsMinVersion" value to their crypto/tls constants.
+var TLSVersions = map[string]uint16{
+	"TLS1.0": tls.VersionTLS10,
+	"TLS1.1": tls.VersionTLS11,
+	"TLS1.2": tls.VersionTLS12,
+}
+
+// TLSCipherSuites maps the cipher suite names accepted in the server
+// config's "tlsCiphers" list to their crypto/tls constants.
+var TLSCipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_RC4_128_SHA":                tls.TLS_RSA_WITH_RC4_128_SHA,
+	"TLS_RSA_WITH_3DES_EDE_CBC_SHA":           tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_RC4_128_SHA":        tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_RC4_128_SHA":          tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA,
+	"TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA":     tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+}
+
+// ParseTLSVersion returns the crypto/tls constant for the named TLS
+// version, or an error listing the accepted names.
+func ParseTLSVersion(name string) (uint16, error) {
+	if v, ok := TLSVersions[name]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q; accepted versions are %s", name, sortedTLSNames(TLSVersions))
+}
+
+// ParseTLSCipherSuite returns the crypto/tls constant for the named
+// cipher suite, or an error listing the accepted names.
+func ParseTLSCipherSuite(name string) (uint16, error) {
+	if v, ok := TLSCipherSuites[name]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unknown TLS cipher suite %q; accepted suites are %s", name, sortedTLSNames(TLSCipherSuites))
+}
+
+func sortedTLSNames(m map[string]uint16) string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
 func (s *Server) ListenURL() string {
 	scheme := "http"
 	if s.enableTLS {
@@ -148,15 +235,9 @@ func (s *Server) Listen(addr string) error {
 	}
 
 	if s.enableTLS {
-		config := &tls.Config{
-			Rand:       rand.Reader,
-			Time:       time.Now,
-			NextProtos: []string{"http/1.1"},
-		}
-		config.Certificates = make([]tls.Certificate, 1)
-		config.Certificates[0], err = tls.LoadX509KeyPair(s.tlsCertFile, s.tlsKeyFile)
+		config, err := s.tlsConfig()
 		if err != nil {
-			return fmt.Errorf("Failed to load TLS cert: %v", err)
+			return err
 		}
 		s.listener = tls.NewListener(s.listener, config)
 	}
@@ -168,6 +249,60 @@ func (s *Server) Listen(addr string) error {
 	return nil
 }
 
+// tlsConfig builds the server's tls.Config from the certificate set
+// with SetTLS (or the callback set with SetTLSFunc) and the options
+// set with SetTLSOptions.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		Rand:       rand.Reader,
+		Time:       time.Now,
+		NextProtos: []string{"http/1.1"},
+	}
+	if s.tlsMinVersion != 0 {
+		config.MinVersion = s.tlsMinVersion
+	}
+	if len(s.tlsCipherSuites) > 0 {
+		config.CipherSuites = s.tlsCipherSuites
+	}
+	if s.tlsGetCert != nil {
+		config.GetCertificate = s.tlsGetCert
+		return config, nil
+	}
+	var err error
+	config.Certificates = make([]tls.Certificate, 1)
+	config.Certificates[0], err = tls.LoadX509KeyPair(s.tlsCertFile, s.tlsKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load TLS cert: %v", err)
+	}
+	return config, nil
+}
+
+// ListenExtra starts listening on an additional host:port addr,
+// serving the same handlers as the main address. If https, the
+// listener serves TLS with the certificate set by SetTLS, regardless
+// of whether the main listener does. Extra listeners are served once
+// Serve is called.
+func (s *Server) ListenExtra(addr string, https bool) error {
+	ln, err := listen.Listen(addr)
+	if err != nil {
+		return fmt.Errorf("Failed to listen on %s: %v", addr, err)
+	}
+	scheme := "http"
+	if https {
+		config, err := s.tlsConfig()
+		if err != nil {
+			return err
+		}
+		ln = tls.NewListener(ln, config)
+		scheme = "https"
+	}
+	if os.Getenv("TESTING_PORT_WRITE_FD") == "" { // Don't make noise during unit tests
+		log.Printf("Also listening on %s://%s\n", scheme, ln.Addr())
+	}
+	s.extraListeners = append(s.extraListeners, ln)
+	return nil
+}
+
 func (s *Server) throttleListener() net.Listener {
 	kBps, _ := strconv.Atoi(os.Getenv("DEV_THROTTLE_KBPS"))
 	ms, _ := strconv.Atoi(os.Getenv("DEV_THROTTLE_LATENCY_MS"))
@@ -190,6 +325,15 @@ func (s *Server) Serve() {
 		log.Fatalf("Listen error: %v", err)
 	}
 	go runTestHarnessIntegration(s.listener)
+	for _, ln := range s.extraListeners {
+		go func(ln net.Listener) {
+			err := http.Serve(ln, s)
+			if err != nil {
+				log.Printf("Error in http server on %s: %v\n", ln.Addr(), err)
+				os.Exit(1)
+			}
+		}(ln)
+	}
 	err := http.Serve(s.throttleListener(), s)
 	if err != nil {
 		log.Printf("Error in http server: %v\n", err)
@@ -30,6 +30,8 @@ import (
 	"log"
 	"math/big"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -41,24 +43,37 @@ import (
 
 	"camlistore.org/pkg/buildinfo"
 	"camlistore.org/pkg/legal/legalprint"
+	"camlistore.org/pkg/letsencrypt"
+	"camlistore.org/pkg/maintenance"
+	"camlistore.org/pkg/mdns"
 	"camlistore.org/pkg/misc"
 	"camlistore.org/pkg/osutil"
 	"camlistore.org/pkg/serverinit"
 	"camlistore.org/pkg/webserver"
 
 	// Storage options:
+	_ "camlistore.org/pkg/blobserver/azure"
+	_ "camlistore.org/pkg/blobserver/b2"
+	_ "camlistore.org/pkg/blobserver/blobpacked"
 	_ "camlistore.org/pkg/blobserver/cond"
 	_ "camlistore.org/pkg/blobserver/diskpacked"
 	_ "camlistore.org/pkg/blobserver/encrypt"
 	_ "camlistore.org/pkg/blobserver/google/cloudstorage"
 	_ "camlistore.org/pkg/blobserver/google/drive"
 	_ "camlistore.org/pkg/blobserver/localdisk"
+	_ "camlistore.org/pkg/blobserver/mirrorverify"
 	_ "camlistore.org/pkg/blobserver/mongo"
 	_ "camlistore.org/pkg/blobserver/proxycache"
+	_ "camlistore.org/pkg/blobserver/rados"
 	_ "camlistore.org/pkg/blobserver/remote"
 	_ "camlistore.org/pkg/blobserver/replica"
 	_ "camlistore.org/pkg/blobserver/s3"
+	_ "camlistore.org/pkg/blobserver/sftp"
 	_ "camlistore.org/pkg/blobserver/shard"
+	_ "camlistore.org/pkg/blobserver/swift"
+	_ "camlistore.org/pkg/blobserver/tiered"
+	_ "camlistore.org/pkg/blobserver/union"
+	_ "camlistore.org/pkg/blobserver/webdav"
 	// Indexers: (also present themselves as storage targets)
 	"camlistore.org/pkg/index"
 	// KeyValue implementations:
@@ -83,6 +98,7 @@ var (
 	listenFlag      = flag.String("listen", "", "host:port to listen on, or :0 to auto-select. If blank, the value in the config will be used instead.")
 	flagOpenBrowser = flag.Bool("openbrowser", true, "Launches the UI on startup")
 	flagReindex     = flag.Bool("reindex", false, "Reindex all blobs on startup")
+	flagRole        = flag.String("role", "", "If the config file defines roles, only serve the prefixes of this role. If blank, all prefixes are served.")
 	flagPollParent  bool
 )
 
@@ -150,6 +166,23 @@ func genSelfTLS(listen string) error {
 		IsCA:         true,
 		BasicConstraintsValid: true,
 	}
+	// Put the listen address in the SANs too, so clients
+	// connecting by IP literal (v4 or v6) can verify the cert.
+	if ip := net.ParseIP(hostname); ip != nil {
+		if ip.IsUnspecified() {
+			// We don't know which addresses the server will
+			// be reached on; at least cover the loopbacks.
+			template.IPAddresses = append(template.IPAddresses, net.IPv4(127, 0, 0, 1), net.IPv6loopback)
+			template.DNSNames = append(template.DNSNames, "localhost")
+		} else {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		}
+	} else {
+		template.DNSNames = append(template.DNSNames, hostname)
+		if hostname == "localhost" {
+			template.IPAddresses = append(template.IPAddresses, net.IPv4(127, 0, 0, 1), net.IPv6loopback)
+		}
+	}
 
 	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
 	if err != nil {
@@ -215,12 +248,63 @@ func findConfigFile(file string) (absPath string, isNewConfig bool, err error) {
 	return
 }
 
-func setupTLS(ws *webserver.Server, config *serverinit.Config, listen string) {
+// setupTLS configures the webserver's TLS certificate, if enabled, and
+// returns the certificate's fingerprint ("" when no TLS listener is
+// configured). mainHTTPS says whether the main listen address itself
+// serves TLS, as opposed to only an extra listener doing so.
+func setupTLS(ws *webserver.Server, config *serverinit.Config, listen string, enabled, mainHTTPS bool) (certFingerprint string) {
 	cert, key := config.OptionalString("httpsCert", ""), config.OptionalString("httpsKey", "")
-	if !config.OptionalBool("https", true) {
-		return
+	minVersionName := config.OptionalString("tlsMinVersion", "")
+	cipherNames := config.OptionalList("tlsCiphers")
+	if !enabled {
+		return ""
+	}
+	var minVersion uint16
+	if minVersionName != "" {
+		var err error
+		minVersion, err = webserver.ParseTLSVersion(minVersionName)
+		if err != nil {
+			exitf("Bad tlsMinVersion: %v", err)
+		}
 	}
-	if (cert != "") != (key != "") {
+	var cipherSuites []uint16
+	for _, name := range cipherNames {
+		suite, err := webserver.ParseTLSCipherSuite(name)
+		if err != nil {
+			exitf("Bad tlsCiphers entry: %v", err)
+		}
+		cipherSuites = append(cipherSuites, suite)
+	}
+	ws.SetTLSOptions(minVersion, cipherSuites)
+	if cert == "auto" {
+		// Let's Encrypt mode, from a high-level config with
+		// "httpsCert": "auto": obtain and renew the
+		// certificate for autocertDomain over ACME, caching it
+		// under autocertCacheDir. The tls-sni-01 challenge is
+		// answered on our own HTTPS port, so the certificate
+		// arrives on the first handshake.
+		domain := config.OptionalString("autocertDomain", "")
+		if domain == "" {
+			exitf(`httpsCert "auto" requires an "autocertDomain"`)
+		}
+		dir := config.OptionalString("autocertCacheDir", filepath.Join(osutil.CamliVarDir(), "letsencrypt"))
+		manager, err := letsencrypt.NewManager(domain, dir)
+		if err != nil {
+			exitf("Let's Encrypt setup for %s: %v", domain, err)
+		}
+		if mainHTTPS {
+			ws.SetTLSFunc(manager.GetCertificate)
+		} else {
+			ws.SetTLSCertFunc(manager.GetCertificate)
+		}
+		if leaf := manager.Certificate(); leaf != nil {
+			sig := misc.SHA256Prefix(leaf.Raw)
+			log.Printf("TLS enabled, with Let's Encrypt certificate for %s, SHA-256 fingerprint: %v", domain, sig)
+			return sig
+		}
+		log.Printf("TLS enabled; obtaining a Let's Encrypt certificate for %s on first use", domain)
+		return ""
+	} else if (cert != "") != (key != "") {
 		exitf("httpsCert and httpsKey must both be either present or absent")
 	}
 
@@ -261,7 +345,64 @@ func setupTLS(ws *webserver.Server, config *serverinit.Config, listen string) {
 	}
 	sig := misc.SHA256Prefix(certif.Raw)
 	log.Printf("TLS enabled, with SHA-256 certificate fingerprint: %v", sig)
-	ws.SetTLS(cert, key)
+	if mainHTTPS {
+		ws.SetTLS(cert, key)
+	} else {
+		ws.SetTLSCert(cert, key)
+	}
+	return sig
+}
+
+// serveHTTPRedirect answers plain HTTP on addr with a permanent
+// redirect to the same path and query under target (the server's
+// HTTPS URL), so old http:// bookmarks keep working.
+func serveHTTPRedirect(addr, target string) {
+	target = strings.TrimSuffix(target, "/")
+	err := http.ListenAndServe(addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target+r.URL.RequestURI(), http.StatusMovedPermanently)
+	}))
+	exitf("HTTP redirect listener on %s: %v", addr, err)
+}
+
+// advertiseLANServer advertises the server on the local network with
+// mDNS, so clients can find it with "camtool discover" or
+// --server=auto.
+func advertiseLANServer(baseURL, certFingerprint string) {
+	instance, _ := os.Hostname()
+	if i := strings.Index(instance, "."); i != -1 {
+		instance = instance[:i]
+	}
+	if instance == "" {
+		instance = "camlistored"
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		log.Printf("Not advertising on the local network: bad baseURL %q: %v", baseURL, err)
+		return
+	}
+	port := 80
+	if u.Scheme == "https" {
+		port = 443
+	}
+	if _, portStr, err := net.SplitHostPort(u.Host); err == nil {
+		if p, err := strconv.Atoi(portStr); err == nil {
+			port = p
+		}
+	}
+	svc := &mdns.Service{
+		Instance: instance,
+		Host:     instance + ".local.",
+		Port:     port,
+		TXT:      map[string]string{"url": baseURL},
+	}
+	if certFingerprint != "" {
+		svc.TXT["fp"] = certFingerprint
+	}
+	if _, err := mdns.Advertise(svc); err != nil {
+		log.Printf("mDNS advertisement failed: %v", err)
+		return
+	}
+	log.Printf("Advertising server on the local network as %q", instance)
 }
 
 var osExit = os.Exit // testing hook
@@ -306,6 +447,32 @@ func handleSignals(shutdownc <-chan io.Closer) {
 	}
 }
 
+// A listenerConfig is one parsed entry of the low-level "listeners"
+// list, which genconfig generates from the list form of the
+// high-level "listen" key.
+type listenerConfig struct {
+	addr  string // address (of the form host|ip:port) to listen on.
+	https bool   // serve TLS on this address.
+}
+
+// configListeners parses the low-level "listeners" entries, each of
+// the form "http://host:port" or "https://host:port". It returns nil
+// when the config uses the single "listen" address instead.
+func configListeners(config *serverinit.Config) []listenerConfig {
+	var lns []listenerConfig
+	for _, v := range config.OptionalList("listeners") {
+		switch {
+		case strings.HasPrefix(v, "http://"):
+			lns = append(lns, listenerConfig{addr: strings.TrimPrefix(v, "http://")})
+		case strings.HasPrefix(v, "https://"):
+			lns = append(lns, listenerConfig{addr: strings.TrimPrefix(v, "https://"), https: true})
+		default:
+			exitf(`Invalid "listeners" entry %q; must be of the form "http://host:port" or "https://host:port"`, v)
+		}
+	}
+	return lns
+}
+
 // listenAndBaseURL finds the configured, default, or inferred listen address
 // and base URL from the command-line flags and provided config.
 func listenAndBaseURL(config *serverinit.Config) (listen, baseURL string) {
@@ -354,20 +521,62 @@ func Main(up chan<- struct{}, down <-chan struct{}) {
 		exitf("Error finding config file %q: %v", fileName, err)
 	}
 	log.Printf("Using config file %s", fileName)
-	config, err := serverinit.Load(fileName)
+	config, err := serverinit.LoadWithRole(fileName, *flagRole)
 	if err != nil {
 		exitf("Could not load server config: %v", err)
 	}
 
 	ws := webserver.New()
-	listen, baseURL := listenAndBaseURL(config)
+	listeners := configListeners(config)
+	httpsEnabled := config.OptionalBool("https", true)
+	mainHTTPS := httpsEnabled
+	var listen, baseURL string
+	if len(listeners) > 0 {
+		if *listenFlag != "" {
+			exitf(`The -listen flag can't be used when the config has "listeners"`)
+		}
+		// The first listener is the main one: it's the one the
+		// baseURL is inferred from when not configured.
+		listen = listeners[0].addr
+		mainHTTPS = listeners[0].https
+		httpsEnabled = false
+		for _, l := range listeners {
+			if l.https {
+				httpsEnabled = true
+			}
+		}
+		baseURL = config.OptionalString("baseURL", "")
+	} else {
+		listen, baseURL = listenAndBaseURL(config)
+	}
+	advertiseLAN := config.OptionalBool("advertiseLAN", false)
+	if config.OptionalBool("readonly", false) {
+		maintenance.Set(true, "")
+	}
 
-	setupTLS(ws, config, listen)
+	certFingerprint := setupTLS(ws, config, listen, httpsEnabled, mainHTTPS)
 
 	err = ws.Listen(listen)
 	if err != nil {
 		exitf("Listen: %v", err)
 	}
+	for i, l := range listeners {
+		if i == 0 {
+			continue
+		}
+		if err := ws.ListenExtra(l.addr, l.https); err != nil {
+			exitf("Listen on %s: %v", l.addr, err)
+		}
+	}
+	if redirect := config.OptionalObject("httpRedirect"); len(redirect) > 0 {
+		addr := redirect.RequiredString("listen")
+		target := redirect.RequiredString("target")
+		if err := redirect.Validate(); err != nil {
+			exitf("Bad httpRedirect config: %v", err)
+		}
+		log.Printf("Redirecting plain HTTP on %s to %s", addr, target)
+		go serveHTTPRedirect(addr, target)
+	}
 
 	if baseURL == "" {
 		baseURL = ws.ListenURL()
@@ -395,6 +604,13 @@ func Main(up chan<- struct{}, down <-chan struct{}) {
 	if flagPollParent {
 		osutil.DieOnParentDeath()
 	}
+	if advertiseLAN {
+		if strings.HasPrefix(config.OptionalString("auth", ""), "localhost") {
+			log.Printf("Not advertising on the local network: auth is localhost-only.")
+		} else {
+			advertiseLANServer(baseURL, certFingerprint)
+		}
+	}
 
 	if err := config.StartApps(); err != nil {
 		exitf("StartApps: %v", err)
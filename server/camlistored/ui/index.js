@@ -120,12 +120,24 @@ cam.IndexPage = React.createClass({
 
 	render: function() {
 		return React.DOM.div({onDragEnter:this.handleDragStart_, onDragOver:this.handleDragStart_, onDrop:this.handleDrop_}, [
+			this.getMaintenanceBanner_(),
 			this.getNav_(),
 			this.getBlobItemContainer_(),
 			this.getDetailView_(),
 		]);
 	},
 
+	// The server advertises read-only maintenance mode in the
+	// discovery document; show a banner so users know why writes
+	// are failing.
+	getMaintenanceBanner_: function() {
+		if (!this.props.config.readOnly) {
+			return null;
+		}
+		return React.DOM.div({key:'maintenancebanner', className:'cam-maintenance-banner'},
+			this.props.config.maintenanceMessage || 'Server is in read-only maintenance mode.');
+	},
+
 	handleDragStart_: function(e) {
 		this.clearDragTimer_();
 		e.preventDefault();
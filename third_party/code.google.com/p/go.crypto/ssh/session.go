@@ -186,6 +186,37 @@ func (s *Session) Start(cmd string) error {
 	return s.start()
 }
 
+// RFC 4254 Section 6.5.
+type subsystemRequestMsg struct {
+	PeersId   uint32
+	Request   string
+	WantReply bool
+	Subsystem string
+}
+
+// RequestSubsystem requests the association of a subsystem with the session
+// on the remote host. A subsystem is a predefined command that runs in the
+// background when the ssh session is initiated. A Session only accepts one
+// call to Run, Start, Shell or RequestSubsystem.
+func (s *Session) RequestSubsystem(subsystem string) error {
+	if s.started {
+		return errors.New("ssh: session already started")
+	}
+	req := subsystemRequestMsg{
+		PeersId:   s.peersId,
+		Request:   "subsystem",
+		WantReply: true,
+		Subsystem: subsystem,
+	}
+	if err := s.writePacket(marshal(msgChannelRequest, req)); err != nil {
+		return err
+	}
+	if err := s.waitForResponse(); err != nil {
+		return fmt.Errorf("ssh: could not start subsystem %s: %v", subsystem, err)
+	}
+	return s.start()
+}
+
 // Run runs cmd on the remote host. Typically, the remote
 // server passes cmd to the shell for interpretation.
 // A Session only accepts one call to Run, Start or Shell.